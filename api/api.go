@@ -21,6 +21,26 @@ type PVInfo struct {
 	PVAttr string `json:"pvAttr,omitempty"`
 	PVSize uint64 `json:"pvSize,omitempty"`
 	PVFree uint64 `json:"pvFree,omitempty"`
+	// FailureDomain identifies the enclosure or HBA the pv is attached to, empty for virtual devices
+	FailureDomain string `json:"failureDomain,omitempty"`
+	// PVTags are the lvm tags of the pv, comma separated
+	PVTags string `json:"pvTags,omitempty"`
+}
+
+// DiskProfile is the result of the benchmark carina-node runs on a disk before adding it to a volume group
+type DiskProfile struct {
+	// Disk is the device path of the pv
+	Disk string `json:"disk"`
+	// DeviceGroup is the volume group the disk was added to
+	DeviceGroup string `json:"deviceGroup"`
+	// SeqReadMBps is the sequential read throughput with 1MiB blocks in MiB/s
+	SeqReadMBps int64 `json:"seqReadMBps"`
+	// RandReadIOPS is the random read rate with 4KiB blocks
+	RandReadIOPS int64 `json:"randReadIOPS"`
+	// RandWriteIOPS is the random write rate with 4KiB blocks
+	RandWriteIOPS int64 `json:"randWriteIOPS"`
+	// LatencyMicros is the mean latency of the random reads in microseconds
+	LatencyMicros int64 `json:"latencyMicros"`
 }
 
 // Disk defines disk details
@@ -50,6 +70,18 @@ type Disk struct {
 	// For example: RAID, ATA or PCIE.
 	Attachment AttachmentType `json:"attachment,omitempty"`
 
+	// FailureDomain identifies the enclosure or HBA the disk is attached to, empty for virtual devices
+	FailureDomain string `json:"failureDomain,omitempty"`
+
+	// Serial is the serial number of the disk reported by udev
+	Serial string `json:"serial,omitempty"`
+
+	// WWN is the world wide name of the disk, empty when the disk has none
+	WWN string `json:"wwn,omitempty"`
+
+	// Health is Healthy, or the problems found in sysfs, e.g. disk sdb state is offline
+	Health string `json:"health,omitempty"`
+
 	// Partitions is the set of partitions on this disk.
 	Partitions PartitionSet `json:"partitions,omitempty"`
 
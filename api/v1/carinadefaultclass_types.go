@@ -0,0 +1,64 @@
+/*
+ Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CarinaDefaultClassSpec defines the default carina storageclass of pvcs in the selected namespaces
+type CarinaDefaultClassSpec struct {
+	// NamespaceSelector selects the namespaces the default applies to, an empty selector matches all namespaces
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// StorageClassName is set on new pvcs without a storageClassName, it must be a carina storageclass
+	StorageClassName string `json:"storageClassName"`
+	// DeviceGroup is injected as carina.storage.io/disk-group-name into the defaulted pvcs
+	// +optional
+	DeviceGroup string `json:"deviceGroup,omitempty"`
+	// Priority decides between defaults matching the same namespace, the highest wins
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:resource:shortName=cdc
+// +kubebuilder:printcolumn:name="STORAGECLASS",type="string",JSONPath=".spec.storageClassName"
+// +kubebuilder:printcolumn:name="GROUP",type="string",JSONPath=".spec.deviceGroup"
+// +kubebuilder:printcolumn:name="PRIORITY",type="integer",JSONPath=".spec.priority"
+
+// CarinaDefaultClass is the Schema for the carinadefaultclasses API
+type CarinaDefaultClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec CarinaDefaultClassSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// CarinaDefaultClassList contains a list of CarinaDefaultClass
+type CarinaDefaultClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CarinaDefaultClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CarinaDefaultClass{}, &CarinaDefaultClassList{})
+}
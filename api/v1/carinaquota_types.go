@@ -0,0 +1,94 @@
+/*
+ Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// QuotaLimit caps carina volumes, an unset field means unlimited
+type QuotaLimit struct {
+	// Capacity is the total size of the volumes
+	// +optional
+	Capacity *resource.Quantity `json:"capacity,omitempty"`
+	// Volumes is the number of volumes, the cache volume of a bcache volume is not counted
+	// +optional
+	Volumes *int64 `json:"volumes,omitempty"`
+}
+
+// QuotaUsage is the capacity and number of carina volumes in use
+type QuotaUsage struct {
+	Capacity resource.Quantity `json:"capacity"`
+	Volumes  int64             `json:"volumes"`
+}
+
+// QuotaStatus is the usage and what is left of a limit
+type QuotaStatus struct {
+	Used QuotaUsage `json:"used"`
+	// Remaining unset fields are unlimited
+	// +optional
+	Remaining QuotaLimit `json:"remaining,omitempty"`
+}
+
+// CarinaQuotaSpec defines the limits of carina volumes in the namespace
+type CarinaQuotaSpec struct {
+	// Hard limits all carina volumes of the namespace
+	// +optional
+	Hard QuotaLimit `json:"hard,omitempty"`
+	// DeviceGroups limits the volumes of a disk group, keyed by disk group name e.g. carina-vg-ssd
+	// +optional
+	DeviceGroups map[string]QuotaLimit `json:"deviceGroups,omitempty"`
+}
+
+// CarinaQuotaStatus defines the observed usage of carina volumes in the namespace
+type CarinaQuotaStatus struct {
+	QuotaStatus `json:",inline"`
+	// DeviceGroups usage of the disk groups limited in spec
+	// +optional
+	DeviceGroups map[string]QuotaStatus `json:"deviceGroups,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=cq
+// +kubebuilder:printcolumn:name="USED",type="string",JSONPath=".status.used.capacity"
+// +kubebuilder:printcolumn:name="HARD",type="string",JSONPath=".spec.hard.capacity"
+// +kubebuilder:printcolumn:name="VOLUMES",type="integer",JSONPath=".status.used.volumes"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// CarinaQuota is the Schema for the carinaquotas API
+type CarinaQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CarinaQuotaSpec   `json:"spec,omitempty"`
+	Status CarinaQuotaStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// CarinaQuotaList contains a list of CarinaQuota
+type CarinaQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CarinaQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CarinaQuota{}, &CarinaQuotaList{})
+}
@@ -0,0 +1,22 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v1
+
+// Hub marks v1 as the storage version LogicVolumes of other versions convert through.
+// v1beta1 implements conversion.Convertible against this type, served by the /convert
+// webhook of carina-controller, see docs/manual/api-versioning.md.
+func (*LogicVolume) Hub() {}
@@ -47,18 +47,48 @@ type LogicVolumeStatus struct {
 	Status      string             `json:"status,omitempty"`
 	DeviceMajor uint32             `json:"deviceMajor,omitempty"`
 	DeviceMinor uint32             `json:"deviceMinor,omitempty"`
+	// Devices are the disks holding the data of the volume, e.g. /dev/sdb
+	Devices []string `json:"devices,omitempty"`
+	// FailureDomains are the enclosures or HBAs holding the data of the volume
+	FailureDomains []string `json:"failureDomains,omitempty"`
+	// Conditions of asynchronous operations on the volume, e.g. dataset prefill
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// LastActivity is the last time the node saw I/O on the volume, recorded with an hourly granularity
+	LastActivity *metav1.Time `json:"lastActivity,omitempty"`
+	// Usage is the used percent of the filesystem of the volume, sampled by the node while it is mounted
+	Usage *int32 `json:"usage,omitempty"`
+	// Drbd is the state of the drbd resource of a replicated volume on the node of this LogicVolume
+	Drbd *DrbdStatus `json:"drbd,omitempty"`
+}
+
+// DrbdStatus drbd资源在本节点上的状态
+type DrbdStatus struct {
+	// Role of the resource on this node, Primary while the volume is in use here
+	Role string `json:"role,omitempty"`
+	// Disk state of the local backing volume, e.g. UpToDate, Inconsistent
+	Disk string `json:"disk,omitempty"`
+	// PeerDisk is the disk state of the other replica as seen from this node
+	PeerDisk string `json:"peerDisk,omitempty"`
+	// Connection to the other replica, e.g. Connected, Connecting, StandAlone
+	Connection string `json:"connection,omitempty"`
+	// Replication state, e.g. Established, SyncSource, SyncTarget
+	Replication string `json:"replication,omitempty"`
+	// Quorum is true while this node reaches a majority of the nodes of the resource, only then it can be primary
+	Quorum bool `json:"quorum,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:scope=Cluster
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:shortName=lv
+// +kubebuilder:storageversion
 // +kubebuilder:printcolumn:name="SIZE",type="string",JSONPath=".spec.size"
 // +kubebuilder:printcolumn:name="GROUP",type="string",JSONPath=".spec.deviceGroup"
 // +kubebuilder:printcolumn:name="NODE",type="string",JSONPath=".spec.nodeName"
 // +kubebuilder:printcolumn:name="STATUS",type="string",JSONPath=".status.status"
 // +kubebuilder:printcolumn:name="NAMESPACE",type="string",priority=1,JSONPath=".spec.nameSpace"
 // +kubebuilder:printcolumn:name="PVC",type="string",priority=1,JSONPath=".spec.pvc"
+// +kubebuilder:printcolumn:name="LAST-ACTIVITY",type="date",priority=1,JSONPath=".status.lastActivity"
 
 // LogicVolume is the Schema for the logicvolumes API
 type LogicVolume struct {
@@ -0,0 +1,98 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// rebalance phases
+const (
+	RebalancePending   = "Pending"
+	RebalanceRunning   = "Running"
+	RebalanceSucceeded = "Succeeded"
+	RebalanceFailed    = "Failed"
+)
+
+// RebalanceSpec defines which physical volumes of a volume group extents are moved between
+type RebalanceSpec struct {
+	// NodeName is the node of the volume group
+	NodeName string `json:"nodeName"`
+	// VGName is the volume group to rebalance
+	VGName string `json:"vgName"`
+	// SourcePV is the physical volume above the high watermark
+	SourcePV string `json:"sourcePV"`
+	// TargetPVs are the physical volumes below the low watermark receiving the moved extents
+	TargetPVs []string `json:"targetPVs"`
+	// TargetUsage is the usage percent of the source physical volume the rebalance stops at
+	TargetUsage int32 `json:"targetUsage"`
+}
+
+// RebalanceStatus defines the observed state of Rebalance
+type RebalanceStatus struct {
+	// +optional
+	Phase string `json:"phase,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+	// MovedLVs are the logical volumes whose extents have been moved off the source
+	// +optional
+	MovedLVs []string `json:"movedLVs,omitempty"`
+	// MovedBytes is the size of the extents moved so far
+	// +optional
+	MovedBytes uint64 `json:"movedBytes,omitempty"`
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:resource:shortName=rb
+// +kubebuilder:printcolumn:name="NODE",type="string",JSONPath=".spec.nodeName"
+// +kubebuilder:printcolumn:name="VG",type="string",JSONPath=".spec.vgName"
+// +kubebuilder:printcolumn:name="SOURCE",type="string",JSONPath=".spec.sourcePV"
+// +kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Rebalance is the Schema for the rebalances API
+type Rebalance struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RebalanceSpec   `json:"spec,omitempty"`
+	Status RebalanceStatus `json:"status,omitempty"`
+}
+
+// Finished reports whether the rebalance has succeeded or failed
+func (r *Rebalance) Finished() bool {
+	return r.Status.Phase == RebalanceSucceeded || r.Status.Phase == RebalanceFailed
+}
+
+// +kubebuilder:object:root=true
+
+// RebalanceList contains a list of Rebalance
+type RebalanceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Rebalance `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Rebalance{}, &RebalanceList{})
+}
@@ -0,0 +1,85 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SnapshotPolicySpec defines when the selected pvcs of the namespace are snapshotted and how many snapshots are kept
+type SnapshotPolicySpec struct {
+	// Schedule in cron format, e.g. "0 */6 * * *" or "@daily", in the time zone of carina-controller
+	Schedule string `json:"schedule"`
+	// Retention is the number of snapshots kept per pvc, older ones are deleted
+	// +kubebuilder:validation:Minimum=1
+	Retention int32 `json:"retention"`
+	// Selector selects the carina pvcs of the namespace, an empty selector matches all of them
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// VolumeSnapshotClassName of the snapshots, the default class of the driver if unset
+	// +optional
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+	// Suspend stops creating snapshots, existing snapshots are kept
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// SnapshotPolicyStatus defines the observed state of SnapshotPolicy
+type SnapshotPolicyStatus struct {
+	// LastScheduleTime is the scheduled time of the last snapshot round
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// NextScheduleTime is the scheduled time of the next snapshot round
+	// +optional
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
+	// Snapshots is the number of snapshots created by the policy that currently exist
+	// +optional
+	Snapshots int32 `json:"snapshots,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=snp
+// +kubebuilder:printcolumn:name="SCHEDULE",type="string",JSONPath=".spec.schedule"
+// +kubebuilder:printcolumn:name="RETENTION",type="integer",JSONPath=".spec.retention"
+// +kubebuilder:printcolumn:name="SNAPSHOTS",type="integer",JSONPath=".status.snapshots"
+// +kubebuilder:printcolumn:name="LAST",type="date",JSONPath=".status.lastScheduleTime"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// SnapshotPolicy is the Schema for the snapshotpolicies API
+type SnapshotPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SnapshotPolicySpec   `json:"spec,omitempty"`
+	Status SnapshotPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// SnapshotPolicyList contains a list of SnapshotPolicy
+type SnapshotPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SnapshotPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SnapshotPolicy{}, &SnapshotPolicyList{})
+}
@@ -0,0 +1,65 @@
+/*
+ Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StoragePolicySpec defines the defaults applied to carina pvcs of the selected namespaces
+type StoragePolicySpec struct {
+	// NamespaceSelector selects the namespaces the policy applies to, an empty selector matches all namespaces
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// DeviceGroup is injected as carina.storage.io/disk-group-name into new pvcs
+	// +optional
+	DeviceGroup string `json:"deviceGroup,omitempty"`
+	// FsType is injected as carina.storage.io/fstype into new pvcs
+	// +optional
+	FsType string `json:"fsType,omitempty"`
+	// Priority decides between policies matching the same namespace, the highest wins
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:resource:shortName=sp
+// +kubebuilder:printcolumn:name="GROUP",type="string",JSONPath=".spec.deviceGroup"
+// +kubebuilder:printcolumn:name="FSTYPE",type="string",JSONPath=".spec.fsType"
+// +kubebuilder:printcolumn:name="PRIORITY",type="integer",JSONPath=".spec.priority"
+
+// StoragePolicy is the Schema for the storagepolicies API
+type StoragePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec StoragePolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// StoragePolicyList contains a list of StoragePolicy
+type StoragePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []StoragePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&StoragePolicy{}, &StoragePolicyList{})
+}
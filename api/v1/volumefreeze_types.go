@@ -0,0 +1,107 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VolumeFreeze phases
+const (
+	VolumeFreezeFreezing = "Freezing"
+	VolumeFreezeFrozen   = "Frozen"
+	VolumeFreezeThawing  = "Thawing"
+	VolumeFreezeThawed   = "Thawed"
+	VolumeFreezeFailed   = "Failed"
+)
+
+// VolumeFreezeSpec defines which pvcs of the namespace are frozen and for how long
+type VolumeFreezeSpec struct {
+	// Selector selects the carina pvcs of the namespace, an empty selector matches all of them
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Timeout after which the volumes are thawed even if nobody asked for it, 5m if unset, at most 1h
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Thaw is set once the backup is taken, the volumes are thawed and the freeze finishes
+	// +optional
+	Thaw bool `json:"thaw,omitempty"`
+}
+
+// FrozenVolume is a volume selected by a VolumeFreeze
+type FrozenVolume struct {
+	PVC  string `json:"pvc"`
+	PV   string `json:"pv"`
+	Node string `json:"node"`
+	// Frozen is true while the filesystem of the volume is frozen by carina-node
+	// +optional
+	Frozen bool `json:"frozen,omitempty"`
+	// Message is the error of the last freeze or thaw of the volume
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// VolumeFreezeStatus defines the observed state of VolumeFreeze
+type VolumeFreezeStatus struct {
+	// Phase is one of Freezing, Frozen, Thawing, Thawed, Failed
+	// +optional
+	Phase string `json:"phase,omitempty"`
+	// FrozenAt is the time all selected volumes were frozen
+	// +optional
+	FrozenAt *metav1.Time `json:"frozenAt,omitempty"`
+	// ThawDeadline is the time the nodes thaw the volumes on their own
+	// +optional
+	ThawDeadline *metav1.Time `json:"thawDeadline,omitempty"`
+	// +optional
+	Volumes []FrozenVolume `json:"volumes,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=vfz
+// +kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="FROZEN",type="date",JSONPath=".status.frozenAt"
+// +kubebuilder:printcolumn:name="DEADLINE",type="date",JSONPath=".status.thawDeadline"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// VolumeFreeze is the Schema for the volumefreezes API
+// A VolumeFreeze fsfreezes the selected volumes of its namespace, e.g. for a consistent
+// backup across several pvcs, until spec.thaw is set or it is deleted. Every node thaws
+// its volumes at status.thawDeadline on its own, a freeze can not outlive its timeout
+// even if carina-controller or the api server is unavailable.
+type VolumeFreeze struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VolumeFreezeSpec   `json:"spec,omitempty"`
+	Status VolumeFreezeStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// VolumeFreezeList contains a list of VolumeFreeze
+type VolumeFreezeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VolumeFreeze `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VolumeFreeze{}, &VolumeFreezeList{})
+}
@@ -0,0 +1,111 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VolumeOperationType destructive operation carried out by carina-controller
+type VolumeOperationType string
+
+const (
+	// VolumeOperationForceDelete deletes a LogicVolume and its unbound pv, the finalizer is dropped if the node is gone
+	VolumeOperationForceDelete VolumeOperationType = "ForceDelete"
+	// VolumeOperationWipe erases the data of a LogicVolume on its node, then deletes it and its unbound pv
+	VolumeOperationWipe VolumeOperationType = "Wipe"
+	// VolumeOperationAdopt creates a pv for a LogicVolume that has none, pre-bound to a pvc
+	VolumeOperationAdopt VolumeOperationType = "Adopt"
+)
+
+// VolumeOperation phases
+const (
+	VolumeOperationPending   = "Pending"
+	VolumeOperationRunning   = "Running"
+	VolumeOperationSucceeded = "Succeeded"
+	VolumeOperationFailed    = "Failed"
+)
+
+// VolumeClaimReference names the pvc an adopted volume is bound to
+type VolumeClaimReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// VolumeOperationSpec defines the operation and the LogicVolume it applies to
+type VolumeOperationSpec struct {
+	// Operation is one of ForceDelete, Wipe, Adopt
+	// +kubebuilder:validation:Enum=ForceDelete;Wipe;Adopt
+	Operation VolumeOperationType `json:"operation"`
+	// LogicVolume is the name of the LogicVolume operated on
+	LogicVolume string `json:"logicVolume"`
+	// WipePolicy of a Wipe operation, discard or zero, zero if unset
+	// +optional
+	WipePolicy string `json:"wipePolicy,omitempty"`
+	// ClaimRef is the pvc an adopted volume is pre-bound to, required by Adopt
+	// +optional
+	ClaimRef *VolumeClaimReference `json:"claimRef,omitempty"`
+	// StorageClassName of the pv created by Adopt, it has to match the pvc
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+}
+
+// VolumeOperationStatus defines the observed state of VolumeOperation
+type VolumeOperationStatus struct {
+	// Phase is one of Pending, Running, Succeeded, Failed
+	// +optional
+	Phase string `json:"phase,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=vop
+// +kubebuilder:printcolumn:name="OPERATION",type="string",JSONPath=".spec.operation"
+// +kubebuilder:printcolumn:name="LOGICVOLUME",type="string",JSONPath=".spec.logicVolume"
+// +kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="REQUESTER",type="string",JSONPath=".metadata.annotations.carina\\.storage\\.io/requested-by"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// VolumeOperation is the Schema for the volumeoperations API
+// Destructive day-2 operations are requested as VolumeOperations instead of being run on the
+// nodes, the admission webhook checks that the requester may use the verb of the operation on
+// the LogicVolume and carina-controller carries it out.
+type VolumeOperation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VolumeOperationSpec   `json:"spec,omitempty"`
+	Status VolumeOperationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// VolumeOperationList contains a list of VolumeOperation
+type VolumeOperationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VolumeOperation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VolumeOperation{}, &VolumeOperationList{})
+}
@@ -0,0 +1,131 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VolumeReplication roles
+const (
+	ReplicationPrimary   = "Primary"
+	ReplicationSecondary = "Secondary"
+)
+
+// VolumeReplication phases
+const (
+	VolumeReplicationReplicating = "Replicating"
+	VolumeReplicationStandby     = "Standby"
+	VolumeReplicationPromoting   = "Promoting"
+	VolumeReplicationDemoting    = "Demoting"
+	VolumeReplicationFailed      = "Failed"
+)
+
+// ReplicationPeer is the standby volume the snapshots of a primary are shipped to
+type ReplicationPeer struct {
+	// Address is host:port of the replication endpoint of the carina-node holding the standby volume
+	Address string `json:"address"`
+	// Volume is the name of the standby pv on the peer cluster
+	Volume string `json:"volume"`
+}
+
+// VolumeReplicationSpec defines the pvc replicated and its role
+type VolumeReplicationSpec struct {
+	// PVC is the carina pvc of the namespace replicated to or from the peer cluster
+	PVC string `json:"pvc"`
+	// Role is Primary for the volume in use, whose snapshots are shipped to the peer, and Secondary
+	// for the standby volume receiving them. Changing it promotes or demotes the volume.
+	// +kubebuilder:validation:Enum=Primary;Secondary
+	Role string `json:"role"`
+	// Peer receives the snapshots of a primary, required by Primary
+	// +optional
+	Peer *ReplicationPeer `json:"peer,omitempty"`
+	// Interval between two snapshots shipped to the peer, 5m if unset, at least 1m
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// VolumeReplicationStatus defines the observed state of VolumeReplication
+type VolumeReplicationStatus struct {
+	// Role is the role the volume has, it follows spec.role once a promote or demote is complete
+	// +optional
+	Role string `json:"role,omitempty"`
+	// Phase is one of Replicating, Standby, Promoting, Demoting, Failed
+	// +optional
+	Phase string `json:"phase,omitempty"`
+	// Volume is the pv of the pvc
+	// +optional
+	Volume string `json:"volume,omitempty"`
+	// Node holds the volume and ships or receives its snapshots
+	// +optional
+	Node string `json:"node,omitempty"`
+	// SyncGeneration identifies the last snapshot completely shipped by a primary or received by a secondary
+	// +optional
+	SyncGeneration int64 `json:"syncGeneration,omitempty"`
+	// Dirty is set while a secondary receives a snapshot, an interrupted transfer is rolled back to
+	// SyncGeneration before the volume is promoted
+	// +optional
+	Dirty bool `json:"dirty,omitempty"`
+	// LastSyncTime is the time of the last complete transfer
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// LastSyncAttempt is the time the primary last started a transfer
+	// +optional
+	LastSyncAttempt *metav1.Time `json:"lastSyncAttempt,omitempty"`
+	// LastSyncBytes is the amount of data shipped by the last complete transfer
+	// +optional
+	LastSyncBytes int64 `json:"lastSyncBytes,omitempty"`
+	// LastSyncError is the error of the last transfer, empty if it succeeded
+	// +optional
+	LastSyncError string `json:"lastSyncError,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=vrep
+// +kubebuilder:printcolumn:name="PVC",type="string",JSONPath=".spec.pvc"
+// +kubebuilder:printcolumn:name="ROLE",type="string",JSONPath=".status.role"
+// +kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="LAST-SYNC",type="date",JSONPath=".status.lastSyncTime"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// VolumeReplication is the Schema for the volumereplications API
+// Experimental. The node of a primary volume takes a thin snapshot every interval and ships
+// the chunks changed since the previous one to the carina-node of the standby volume on the
+// peer cluster over mutual TLS. The standby pv can not be used by pods until it is promoted.
+type VolumeReplication struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VolumeReplicationSpec   `json:"spec,omitempty"`
+	Status VolumeReplicationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// VolumeReplicationList contains a list of VolumeReplication
+type VolumeReplicationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VolumeReplication `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VolumeReplication{}, &VolumeReplicationList{})
+}
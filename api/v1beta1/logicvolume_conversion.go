@@ -0,0 +1,101 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"strconv"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// ConvertTo converts this LogicVolume to the hub version v1, the typed settings become v1 annotations.
+func (src *LogicVolume) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*carinav1.LogicVolume)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.Spec = carinav1.LogicVolumeSpec{
+		NodeName:    src.Spec.NodeName,
+		Size:        src.Spec.Size.DeepCopy(),
+		DeviceGroup: src.Spec.DeviceGroup,
+		Pvc:         src.Spec.Pvc,
+		NameSpace:   src.Spec.Namespace,
+	}
+	dst.Status = *src.Status.DeepCopy()
+
+	set := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[key] = value
+	}
+	set(utils.VolumeManagerType, src.Spec.Type)
+	set(utils.SnapshotSource, src.Spec.SnapshotOf)
+	if src.Spec.Stripes != 0 {
+		set(utils.VolumeStripes, strconv.Itoa(int(src.Spec.Stripes)))
+	}
+	set(utils.VolumeStripeSize, src.Spec.StripeSize)
+	if src.Spec.Encrypted {
+		set(utils.VolumeEncrypted, "true")
+	}
+	return nil
+}
+
+// ConvertFrom converts from the hub version v1 to this LogicVolume. Only annotations ConvertTo writes back
+// unchanged become fields, e.g. stripes "04" stays an annotation, so that a round trip loses nothing.
+func (dst *LogicVolume) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*carinav1.LogicVolume)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.Spec = LogicVolumeSpec{
+		NodeName:    src.Spec.NodeName,
+		Size:        src.Spec.Size.DeepCopy(),
+		DeviceGroup: src.Spec.DeviceGroup,
+		Pvc:         src.Spec.Pvc,
+		Namespace:   src.Spec.NameSpace,
+	}
+	dst.Status = *src.Status.DeepCopy()
+
+	moved := false
+	take := func(key string) string {
+		value := dst.Annotations[key]
+		if value != "" {
+			delete(dst.Annotations, key)
+			moved = true
+		}
+		return value
+	}
+	dst.Spec.Type = take(utils.VolumeManagerType)
+	dst.Spec.SnapshotOf = take(utils.SnapshotSource)
+	dst.Spec.StripeSize = take(utils.VolumeStripeSize)
+	if value := dst.Annotations[utils.VolumeStripes]; value != "" {
+		if n, err := strconv.ParseInt(value, 10, 32); err == nil && n > 0 && strconv.FormatInt(n, 10) == value {
+			take(utils.VolumeStripes)
+			dst.Spec.Stripes = int32(n)
+		}
+	}
+	if dst.Annotations[utils.VolumeEncrypted] == "true" {
+		take(utils.VolumeEncrypted)
+		dst.Spec.Encrypted = true
+	}
+	if moved && len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+	return nil
+}
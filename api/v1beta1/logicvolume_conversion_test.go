@@ -0,0 +1,188 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	apix "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
+)
+
+func hubLogicVolume(annotations map[string]string) *carinav1.LogicVolume {
+	size := resource.MustParse("10Gi")
+	return &carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pvc-1",
+			Namespace:   utils.LogicVolumeNamespace,
+			Labels:      map[string]string{"app": "mysql"},
+			Annotations: annotations,
+			Finalizers:  []string{utils.LogicVolumeFinalizer},
+		},
+		Spec: carinav1.LogicVolumeSpec{NodeName: "node1", Size: resource.MustParse("10Gi"), DeviceGroup: "carina-vg-ssd", Pvc: "mysql", NameSpace: "default"},
+		Status: carinav1.LogicVolumeStatus{
+			VolumeID:    "volume-pvc-1",
+			Code:        codes.OK,
+			CurrentSize: &size,
+			Status:      "Success",
+			Conditions:  []metav1.Condition{{Type: utils.ConditionExported, Status: metav1.ConditionTrue, Reason: "Uploaded"}},
+		},
+	}
+}
+
+func TestLogicVolumeConvertFrom(t *testing.T) {
+	hub := hubLogicVolume(map[string]string{
+		utils.VolumeManagerType: utils.LvmVolumeType,
+		utils.SnapshotSource:    "pvc-0",
+		utils.VolumeStripes:     "4",
+		utils.VolumeStripeSize:  "64k",
+		utils.VolumeEncrypted:   "true",
+		"owner":                 "dba",
+	})
+	lv := &LogicVolume{}
+	assert.NoError(t, lv.ConvertFrom(hub))
+
+	assert.Equal(t, LogicVolumeSpec{
+		NodeName:    "node1",
+		Size:        resource.MustParse("10Gi"),
+		DeviceGroup: "carina-vg-ssd",
+		Pvc:         "mysql",
+		Namespace:   "default",
+		Type:        utils.LvmVolumeType,
+		SnapshotOf:  "pvc-0",
+		Stripes:     4,
+		StripeSize:  "64k",
+		Encrypted:   true,
+	}, lv.Spec)
+	// 移到字段上的注解不再保留
+	assert.Equal(t, map[string]string{"owner": "dba"}, lv.Annotations)
+	assert.Equal(t, hub.Status, lv.Status)
+}
+
+func TestLogicVolumeRoundTrip(t *testing.T) {
+	table := []struct {
+		name        string
+		annotations map[string]string
+	}{
+		{name: "no annotations"},
+		{name: "empty annotations", annotations: map[string]string{}},
+		{name: "all settings", annotations: map[string]string{
+			utils.VolumeManagerType: utils.LvmVolumeType,
+			utils.SnapshotSource:    "pvc-0",
+			utils.VolumeStripes:     "2",
+			utils.VolumeStripeSize:  "128k",
+			utils.VolumeEncrypted:   "true",
+		}},
+		// 不能原样还原的值留在注解中
+		{name: "not canonical", annotations: map[string]string{
+			utils.VolumeManagerType: utils.RawVolumeType,
+			utils.VolumeStripes:     "04",
+			utils.VolumeEncrypted:   "false",
+			utils.VolumeStripeSize:  "",
+		}},
+		{name: "invalid stripes", annotations: map[string]string{utils.VolumeStripes: "many"}},
+		{name: "other annotations", annotations: map[string]string{utils.VolumeWipePolicy: utils.WipePolicyZero}},
+	}
+
+	for _, c := range table {
+		t.Run(c.name, func(t *testing.T) {
+			hub := hubLogicVolume(c.annotations)
+			lv := &LogicVolume{}
+			assert.NoError(t, lv.ConvertFrom(hub.DeepCopy()))
+			back := &carinav1.LogicVolume{}
+			assert.NoError(t, lv.ConvertTo(back))
+			assert.Equal(t, hub, back)
+		})
+	}
+}
+
+func TestLogicVolumeRoundTripFromV1beta1(t *testing.T) {
+	table := []*LogicVolume{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Annotations: map[string]string{"owner": "dba"}},
+			Spec: LogicVolumeSpec{NodeName: "node1", Size: resource.MustParse("1Gi"), DeviceGroup: "carina-vg-hdd", Pvc: "data", Namespace: "default",
+				Type: utils.LvmVolumeType, Stripes: 3, StripeSize: "1m", Encrypted: true},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-2-snap"},
+			Spec:       LogicVolumeSpec{NodeName: "node2", Size: resource.MustParse("1Gi"), DeviceGroup: "carina-vg-ssd", Type: utils.LvmVolumeType, SnapshotOf: "pvc-2"},
+			Status:     carinav1.LogicVolumeStatus{VolumeID: "snap-pvc-2-snap", Status: "Success"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-3"},
+			Spec:       LogicVolumeSpec{NodeName: "node1", Size: resource.MustParse("20Gi"), DeviceGroup: "carina-raw-ssd", Type: utils.RawVolumeType},
+		},
+	}
+
+	for _, lv := range table {
+		hub := &carinav1.LogicVolume{}
+		assert.NoError(t, lv.DeepCopy().ConvertTo(hub))
+		back := &LogicVolume{}
+		assert.NoError(t, back.ConvertFrom(hub))
+		assert.Equal(t, lv, back, lv.Name)
+	}
+}
+
+func TestConversionWebhook(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	assert.NoError(t, AddToScheme(scheme))
+	wh := &conversion.Webhook{}
+	assert.NoError(t, wh.InjectScheme(scheme))
+
+	hub := hubLogicVolume(map[string]string{utils.VolumeManagerType: utils.LvmVolumeType, utils.VolumeStripes: "2"})
+	hub.TypeMeta = metav1.TypeMeta{APIVersion: carinav1.GroupVersion.String(), Kind: "LogicVolume"}
+	raw, err := json.Marshal(hub)
+	assert.NoError(t, err)
+	review := apix.ConversionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1", Kind: "ConversionReview"},
+		Request: &apix.ConversionRequest{
+			UID:               "1",
+			DesiredAPIVersion: GroupVersion.String(),
+			Objects:           []runtime.RawExtension{{Raw: raw}},
+		},
+	}
+	body, err := json.Marshal(review)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	wh.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	response := apix.ConversionReview{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, metav1.StatusSuccess, response.Response.Result.Status, response.Response.Result.Message)
+	assert.Len(t, response.Response.ConvertedObjects, 1)
+
+	lv := &LogicVolume{}
+	assert.NoError(t, json.Unmarshal(response.Response.ConvertedObjects[0].Raw, lv))
+	assert.Equal(t, GroupVersion.String(), lv.APIVersion)
+	assert.Equal(t, "default", lv.Spec.Namespace)
+	assert.Equal(t, utils.LvmVolumeType, lv.Spec.Type)
+	assert.Equal(t, int32(2), lv.Spec.Stripes)
+	assert.Equal(t, "volume-pvc-1", lv.Status.VolumeID)
+}
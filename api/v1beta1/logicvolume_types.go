@@ -0,0 +1,83 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LogicVolumeSpec defines the desired state of LogicVolume. Settings v1 keeps in annotations are typed fields here.
+type LogicVolumeSpec struct {
+	NodeName    string            `json:"nodeName"`
+	Size        resource.Quantity `json:"size"`
+	DeviceGroup string            `json:"deviceGroup"`
+	Pvc         string            `json:"pvc"`
+	// Namespace of the pvc, nameSpace in v1
+	Namespace string `json:"namespace"`
+	// Type of the volume, lvm or raw
+	// +optional
+	Type string `json:"type,omitempty"`
+	// SnapshotOf is the LogicVolume a csi snapshot is taken of, empty for volumes
+	// +optional
+	SnapshotOf string `json:"snapshotOf,omitempty"`
+	// Stripes is the number of physical volumes an lvm volume is striped across
+	// +optional
+	Stripes int32 `json:"stripes,omitempty"`
+	// StripeSize is the size of a stripe, e.g. 64k
+	// +optional
+	StripeSize string `json:"stripeSize,omitempty"`
+	// Encrypted is true if the volume is luks encrypted
+	// +optional
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=lv
+// +kubebuilder:printcolumn:name="SIZE",type="string",JSONPath=".spec.size"
+// +kubebuilder:printcolumn:name="GROUP",type="string",JSONPath=".spec.deviceGroup"
+// +kubebuilder:printcolumn:name="NODE",type="string",JSONPath=".spec.nodeName"
+// +kubebuilder:printcolumn:name="STATUS",type="string",JSONPath=".status.status"
+// +kubebuilder:printcolumn:name="NAMESPACE",type="string",priority=1,JSONPath=".spec.namespace"
+// +kubebuilder:printcolumn:name="PVC",type="string",priority=1,JSONPath=".spec.pvc"
+// +kubebuilder:printcolumn:name="LAST-ACTIVITY",type="date",priority=1,JSONPath=".status.lastActivity"
+
+// LogicVolume is the Schema for the logicvolumes API, it is converted through the storage version v1
+type LogicVolume struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec LogicVolumeSpec `json:"spec,omitempty"`
+	// Status is the same in both versions
+	Status carinav1.LogicVolumeStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// LogicVolumeList contains a list of LogicVolume
+type LogicVolumeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LogicVolume `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LogicVolume{}, &LogicVolumeList{})
+}
@@ -30,6 +30,20 @@ type NodeStorageResourceSpec struct {
 
 	// Foo is an example field of NodeStorageResource. Edit nodestorageresource_types.go to remove/update
 	NodeName string `json:"nodeName,omitempty"`
+	// DataMovement limits the jobs copying volume data on the node
+	// +optional
+	DataMovement *DataMovementSpec `json:"dataMovement,omitempty"`
+}
+
+// DataMovementSpec defines when and how fast migration, rebalance, backup and prefill jobs may move data on the node
+type DataMovementSpec struct {
+	// Windows are daily maintenance windows in node local time written as HH:MM-HH:MM, e.g. 01:00-05:00,
+	// jobs are paused outside of them. Data may be moved at any time when no window is given.
+	// +optional
+	Windows []string `json:"windows,omitempty"`
+	// Bandwidth is the ceiling of all data movement on the node in bytes per second, e.g. 100Mi
+	// +optional
+	Bandwidth *resource.Quantity `json:"bandwidth,omitempty"`
 }
 
 // NodeStorageResourceStatus defines the observed state of NodeStorageResource
@@ -51,8 +65,180 @@ type NodeStorageResourceStatus struct {
 	VgGroups []api.VgGroup `json:"vgGroups,omitempty"`
 	// +optional
 	Disks []api.Disk `json:"disks,,omitempty"`
+	// DiskProfiles are the benchmark results of the disks in the volume groups of the node
+	// +optional
+	DiskProfiles []api.DiskProfile `json:"diskProfiles,omitempty"`
 	// +optional
 	RAIDs []api.Raid `json:"raids,omitempty"`
+	// Taints are the volume groups and thin pools whose usage reached the usageThreshold of the node
+	// +optional
+	Taints []StorageTaint `json:"taints,omitempty"`
+	// Fragmentation are the volume groups whose free space is split at or above the fragmentationThreshold of the node
+	// +optional
+	Fragmentation []VGFragmentation `json:"fragmentation,omitempty"`
+	// Orphans are the volumes of the node without their LogicVolume or PersistentVolume and vice versa
+	// +optional
+	Orphans []OrphanVolume `json:"orphans,omitempty"`
+	// CapacityHistory are the last samples of the allocatable and used bytes of each device group,
+	// kept up to capacityHistorySamples
+	// +optional
+	CapacityHistory []DeviceGroupHistory `json:"capacityHistory,omitempty"`
+	// HotSpares are the blank disks kept to replace lost physical volumes of their volume group
+	// +optional
+	HotSpares []HotSpare `json:"hotSpares,omitempty"`
+	// SpareReplacements are the last physical volumes replaced by a hot spare since carina-node started
+	// +optional
+	SpareReplacements []SpareReplacement `json:"spareReplacements,omitempty"`
+	// PendingDisks are the disks matching diskSelector that are only added to their volume group
+	// once approved, reported while diskApproval is enabled
+	// +optional
+	PendingDisks []PendingDisk `json:"pendingDisks,omitempty"`
+	// Config is the revision of the carina-csi-config ConfigMap carina-node runs with
+	// +optional
+	Config *AppliedConfig `json:"config,omitempty"`
+	// Conditions of the storage of the node, e.g. Fragmentation. The Healthy condition sums up the others.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// AppliedConfig is the config revision applied by carina-node, changes of the ConfigMap are applied without restart
+type AppliedConfig struct {
+	// Revision is the sha256 prefix of the applied config.json
+	Revision string `json:"revision"`
+	// AppliedTime is when the revision was applied
+	AppliedTime metav1.Time `json:"appliedTime"`
+	// RejectedRevision is the latest config.json failing validation, the applied revision stays in effect
+	// +optional
+	RejectedRevision string `json:"rejectedRevision,omitempty"`
+	// Error is why the rejected revision failed validation
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// DeviceGroupHistory is the capacity time series of a volume group or raw disk group
+type DeviceGroupHistory struct {
+	// DeviceGroup is the volume group or raw disk group
+	DeviceGroup string `json:"deviceGroup"`
+	// Samples are ordered from the oldest to the latest
+	// +optional
+	Samples []CapacitySample `json:"samples,omitempty"`
+}
+
+// CapacitySample is the capacity of a device group at a time
+type CapacitySample struct {
+	Time metav1.Time `json:"time"`
+	// Allocatable is the bytes available for new volumes
+	Allocatable uint64 `json:"allocatable"`
+	// Used is the bytes taken by volumes or unavailable because of a taint
+	Used uint64 `json:"used"`
+}
+
+// HotSpare is a blank disk reserved for a volume group
+type HotSpare struct {
+	// DeviceGroup is the volume group the disk replaces lost physical volumes of
+	DeviceGroup string `json:"deviceGroup"`
+	// Disk is the device, e.g. /dev/sdz
+	Disk string `json:"disk"`
+	// Size of the disk in bytes
+	Size uint64 `json:"size"`
+}
+
+// PendingDisk is a disk carina-node would add to a volume group, it is left untouched until its ID
+// is listed in the carina.storage.io/approved-disks annotation of the NodeStorageResource
+type PendingDisk struct {
+	// DeviceGroup is the volume group the disk would join
+	DeviceGroup string `json:"deviceGroup"`
+	// Disk is the device, e.g. /dev/sdb
+	Disk string `json:"disk"`
+	// ID identifies the disk in the approval annotation, wwn-<wwn>, serial-<serial> or the device
+	ID string `json:"id"`
+	// Size of the disk in bytes
+	Size uint64 `json:"size"`
+	// Wwn of the disk
+	// +optional
+	Wwn string `json:"wwn,omitempty"`
+	// Serial number of the disk
+	// +optional
+	Serial string `json:"serial,omitempty"`
+	// Model of the disk
+	// +optional
+	Model string `json:"model,omitempty"`
+	// Action carina-node takes once approved, Format for a blank disk pvcreate writes to, Adopt for
+	// an existing physical volume without volume group
+	Action string `json:"action"`
+}
+
+// SpareReplacement records a lost physical volume replaced by a hot spare
+type SpareReplacement struct {
+	// DeviceGroup is the volume group that lost the physical volume
+	DeviceGroup string `json:"deviceGroup"`
+	// Spare is the disk added to the volume group, empty when no spare fits
+	// +optional
+	Spare string `json:"spare,omitempty"`
+	// Repaired are the raid and mirror logical volumes rebuilt onto the spare
+	// +optional
+	Repaired []string `json:"repaired,omitempty"`
+	// Time of the replacement
+	Time metav1.Time `json:"time"`
+	// Message describes the replacement or why it failed
+	Message string `json:"message"`
+}
+
+// OrphanVolume is a volume of the node that lost its counterpart. Volumes without LogicVolume
+// and LogicVolumes without PersistentVolume are garbage collected after the orphanGracePeriod,
+// the others are only reported.
+type OrphanVolume struct {
+	// Kind of the orphan, Volume, LogicVolume or PersistentVolume
+	Kind string `json:"kind"`
+	// Name of the logical volume, partition, LogicVolume or PersistentVolume
+	Name string `json:"name"`
+	// DeviceGroup is the volume group or raw disk group of the volume
+	// +optional
+	DeviceGroup string `json:"deviceGroup,omitempty"`
+	// Reason describes the missing counterpart
+	Reason string `json:"reason"`
+	// Since is when the orphan was found
+	Since metav1.Time `json:"since"`
+	// CollectAfter is when the orphan is garbage collected, unset for orphans that are only reported
+	// +optional
+	CollectAfter *metav1.Time `json:"collectAfter,omitempty"`
+}
+
+// VGFragmentation describes a volume group whose free space is split into small segments.
+// Large striped or raid volumes need contiguous free space on several physical volumes and
+// may fail although the volume group has enough free space in total.
+type VGFragmentation struct {
+	// DeviceGroup is the volume group
+	DeviceGroup string `json:"deviceGroup"`
+	// Free is the free bytes of the volume group
+	Free uint64 `json:"free"`
+	// LargestFree is the largest contiguous free segment of the volume group in bytes
+	LargestFree uint64 `json:"largestFree"`
+	// Percent is the share of the free space outside of the largest free segment
+	Percent int `json:"percent"`
+	// DrainPV is the physical volume the suggested plan empties into one contiguous free segment
+	// +optional
+	DrainPV string `json:"drainPV,omitempty"`
+	// TargetPVs are the physical volumes receiving the extents of DrainPV
+	// +optional
+	TargetPVs []string `json:"targetPVs,omitempty"`
+	// Plan are the pvmove commands that empty DrainPV
+	// +optional
+	Plan []string `json:"plan,omitempty"`
+}
+
+// StorageTaint marks a volume group or a thin pool that is nearly full.
+// A tainted volume group reports no allocatable capacity, new volumes are placed elsewhere.
+type StorageTaint struct {
+	// DeviceGroup is the volume group
+	DeviceGroup string `json:"deviceGroup"`
+	// ThinPool is set when the thin pool of a volume is nearly full rather than the volume group
+	// +optional
+	ThinPool string `json:"thinPool,omitempty"`
+	// Usage is the used percent of the volume group or thin pool
+	Usage int `json:"usage"`
+	// Since is when the usage reached the threshold
+	Since metav1.Time `json:"since"`
 }
 
 // +kubebuilder:object:root=true
@@ -24,15 +24,185 @@ package v1beta1
 import (
 	"github.com/carina-io/carina/api"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppliedConfig) DeepCopyInto(out *AppliedConfig) {
+	*out = *in
+	in.AppliedTime.DeepCopyInto(&out.AppliedTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppliedConfig.
+func (in *AppliedConfig) DeepCopy() *AppliedConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(AppliedConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CapacitySample) DeepCopyInto(out *CapacitySample) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CapacitySample.
+func (in *CapacitySample) DeepCopy() *CapacitySample {
+	if in == nil {
+		return nil
+	}
+	out := new(CapacitySample)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataMovementSpec) DeepCopyInto(out *DataMovementSpec) {
+	*out = *in
+	if in.Windows != nil {
+		in, out := &in.Windows, &out.Windows
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Bandwidth != nil {
+		in, out := &in.Bandwidth, &out.Bandwidth
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataMovementSpec.
+func (in *DataMovementSpec) DeepCopy() *DataMovementSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DataMovementSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeviceGroupHistory) DeepCopyInto(out *DeviceGroupHistory) {
+	*out = *in
+	if in.Samples != nil {
+		in, out := &in.Samples, &out.Samples
+		*out = make([]CapacitySample, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeviceGroupHistory.
+func (in *DeviceGroupHistory) DeepCopy() *DeviceGroupHistory {
+	if in == nil {
+		return nil
+	}
+	out := new(DeviceGroupHistory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HotSpare) DeepCopyInto(out *HotSpare) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HotSpare.
+func (in *HotSpare) DeepCopy() *HotSpare {
+	if in == nil {
+		return nil
+	}
+	out := new(HotSpare)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LogicVolume) DeepCopyInto(out *LogicVolume) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LogicVolume.
+func (in *LogicVolume) DeepCopy() *LogicVolume {
+	if in == nil {
+		return nil
+	}
+	out := new(LogicVolume)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LogicVolume) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LogicVolumeList) DeepCopyInto(out *LogicVolumeList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]LogicVolume, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LogicVolumeList.
+func (in *LogicVolumeList) DeepCopy() *LogicVolumeList {
+	if in == nil {
+		return nil
+	}
+	out := new(LogicVolumeList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LogicVolumeList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LogicVolumeSpec) DeepCopyInto(out *LogicVolumeSpec) {
+	*out = *in
+	out.Size = in.Size.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LogicVolumeSpec.
+func (in *LogicVolumeSpec) DeepCopy() *LogicVolumeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LogicVolumeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeStorageResource) DeepCopyInto(out *NodeStorageResource) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -89,6 +259,11 @@ func (in *NodeStorageResourceList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeStorageResourceSpec) DeepCopyInto(out *NodeStorageResourceSpec) {
 	*out = *in
+	if in.DataMovement != nil {
+		in, out := &in.DataMovement, &out.DataMovement
+		*out = new(DataMovementSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeStorageResourceSpec.
@@ -129,11 +304,73 @@ func (in *NodeStorageResourceStatus) DeepCopyInto(out *NodeStorageResourceStatus
 		*out = make([]api.Disk, len(*in))
 	copy(*out, *in)
 	}
+	if in.DiskProfiles != nil {
+		in, out := &in.DiskProfiles, &out.DiskProfiles
+		*out = make([]api.DiskProfile, len(*in))
+		copy(*out, *in)
+	}
 	if in.RAIDs != nil {
 		in, out := &in.RAIDs, &out.RAIDs
 		*out = make([]api.Raid, len(*in))
 		copy(*out, *in)
 	}
+	if in.Taints != nil {
+		in, out := &in.Taints, &out.Taints
+		*out = make([]StorageTaint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Fragmentation != nil {
+		in, out := &in.Fragmentation, &out.Fragmentation
+		*out = make([]VGFragmentation, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Orphans != nil {
+		in, out := &in.Orphans, &out.Orphans
+		*out = make([]OrphanVolume, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.CapacityHistory != nil {
+		in, out := &in.CapacityHistory, &out.CapacityHistory
+		*out = make([]DeviceGroupHistory, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.HotSpares != nil {
+		in, out := &in.HotSpares, &out.HotSpares
+		*out = make([]HotSpare, len(*in))
+		copy(*out, *in)
+	}
+	if in.SpareReplacements != nil {
+		in, out := &in.SpareReplacements, &out.SpareReplacements
+		*out = make([]SpareReplacement, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PendingDisks != nil {
+		in, out := &in.PendingDisks, &out.PendingDisks
+		*out = make([]PendingDisk, len(*in))
+		copy(*out, *in)
+	}
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = new(AppliedConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeStorageResourceStatus.
@@ -145,3 +382,100 @@ func (in *NodeStorageResourceStatus) DeepCopy() *NodeStorageResourceStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrphanVolume) DeepCopyInto(out *OrphanVolume) {
+	*out = *in
+	in.Since.DeepCopyInto(&out.Since)
+	if in.CollectAfter != nil {
+		in, out := &in.CollectAfter, &out.CollectAfter
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrphanVolume.
+func (in *OrphanVolume) DeepCopy() *OrphanVolume {
+	if in == nil {
+		return nil
+	}
+	out := new(OrphanVolume)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PendingDisk) DeepCopyInto(out *PendingDisk) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PendingDisk.
+func (in *PendingDisk) DeepCopy() *PendingDisk {
+	if in == nil {
+		return nil
+	}
+	out := new(PendingDisk)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpareReplacement) DeepCopyInto(out *SpareReplacement) {
+	*out = *in
+	if in.Repaired != nil {
+		in, out := &in.Repaired, &out.Repaired
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpareReplacement.
+func (in *SpareReplacement) DeepCopy() *SpareReplacement {
+	if in == nil {
+		return nil
+	}
+	out := new(SpareReplacement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageTaint) DeepCopyInto(out *StorageTaint) {
+	*out = *in
+	in.Since.DeepCopyInto(&out.Since)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageTaint.
+func (in *StorageTaint) DeepCopy() *StorageTaint {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageTaint)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VGFragmentation) DeepCopyInto(out *VGFragmentation) {
+	*out = *in
+	if in.TargetPVs != nil {
+		in, out := &in.TargetPVs, &out.TargetPVs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Plan != nil {
+		in, out := &in.Plan, &out.Plan
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VGFragmentation.
+func (in *VGFragmentation) DeepCopy() *VGFragmentation {
+	if in == nil {
+		return nil
+	}
+	out := new(VGFragmentation)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -23,9 +23,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/carina-io/carina/api"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/csidriver/journal"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/pkg/diskimpact"
+	"github.com/carina-io/carina/pkg/simulate"
 	"github.com/carina-io/carina/utils/log"
 	"github.com/labstack/echo/v4"
 	corev1 "k8s.io/api/core/v1"
@@ -40,7 +47,8 @@ type carinaNode struct {
 }
 
 var (
-	kCache cache.Cache
+	kCache     cache.Cache
+	csiJournal *journal.Journal
 )
 
 type eHttpServer struct {
@@ -48,11 +56,16 @@ type eHttpServer struct {
 	stopChan <-chan struct{}
 }
 
-func newHttpServer(c cache.Cache, stopChan <-chan struct{}) *eHttpServer {
+func newHttpServer(c cache.Cache, j *journal.Journal, stopChan <-chan struct{}) *eHttpServer {
 	kCache = c
+	csiJournal = j
 	e := echo.New()
 	e.GET("/devicegroup", vgList)
 	e.GET("/volume", volumeList)
+	e.GET("/journal", journalDump)
+	e.GET("/disk/volumes", diskVolumes)
+	e.GET("/config", appliedConfig)
+	e.GET("/simulate", simulatePlacement)
 
 	return &eHttpServer{
 		e:        e,
@@ -134,6 +147,72 @@ func volumeList(c echo.Context) error {
 	return c.JSON(http.StatusOK, result)
 }
 
+// appliedConfig 返回当前生效的配置版本，以及最近一次校验失败被拒绝的配置
+func appliedConfig(c echo.Context) error {
+	return c.JSON(http.StatusOK, configuration.Applied())
+}
+
+// journalDump 返回csi控制器最近的请求记录，?limit=n只返回最后n条
+// The journal of a node is served by the carina-node of that node.
+func journalDump(c echo.Context) error {
+	if csiJournal == nil {
+		return c.JSON(http.StatusNotFound, "csi journal is disabled")
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	records, err := csiJournal.Dump(limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, records)
+}
+
+// diskVolumes 返回节点上数据落在某块盘上的LogicVolume、PVC和Pod，?node=node1&disk=sdb
+// The disk is a kernel name, a device path, or a /dev/disk/by-id link, serial or wwn of a raw disk.
+func diskVolumes(c echo.Context) error {
+	node, disk := c.QueryParam("node"), c.QueryParam("disk")
+	if node == "" || disk == "" {
+		return c.JSON(http.StatusBadRequest, "node and disk are required")
+	}
+	ctx := c.Request().Context()
+	nsr := &carinav1beta1.NodeStorageResource{}
+	if err := kCache.Get(ctx, client.ObjectKey{Name: node}, nsr); err != nil {
+		nsr = nil
+	}
+	lvs := &carinav1.LogicVolumeList{}
+	if err := kCache.List(ctx, lvs); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	pvs := &corev1.PersistentVolumeList{}
+	if err := kCache.List(ctx, pvs); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	pods := &corev1.PodList{}
+	if err := kCache.List(ctx, pods); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, diskimpact.Find(node, diskimpact.ResolveDisk(disk, nsr), lvs.Items, pvs.Items, pods.Items))
+}
+
+// simulatePlacement 模拟PVC落在哪个节点和磁盘组，以及之后的剩余容量，不创建任何对象
+// ?size=100Gi&group=carina-vg-ssd&count=3&strategy=binpack&onePerNode=true, cordoned nodes are skipped.
+func simulatePlacement(c echo.Context) error {
+	onePerNode, _ := strconv.ParseBool(c.QueryParam("onePerNode"))
+	req, err := simulate.ParseRequest(c.QueryParam("size"), c.QueryParam("group"), c.QueryParam("count"), c.QueryParam("strategy"), onePerNode, configuration.SchedulerStrategy())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	ctx := c.Request().Context()
+	nsrs := &carinav1beta1.NodeStorageResourceList{}
+	if err := kCache.List(ctx, nsrs); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	nodes := &corev1.NodeList{}
+	if err := kCache.List(ctx, nodes); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, simulate.Simulate(req, nsrs.Items, simulate.UnschedulableNodes(nodes.Items)))
+}
+
 func getEndpoints() ([]carinaNode, error) {
 	result := []carinaNode{}
 	endpoints := corev1.Endpoints{}
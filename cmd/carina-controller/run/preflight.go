@@ -0,0 +1,65 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/preflight"
+	"github.com/spf13/cobra"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var preflightOpts preflight.Options
+
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Check that carina can be upgraded to this version",
+	Long: `preflight validates the installed CRD versions, orphaned and stuck objects, deprecated keys of the
+carina config and the versions of the running carina components, and prints a pass/fail report.
+It exits non-zero when a check failed, run it with the image of the new release before upgrading.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		cfg, err := ctrl.GetConfig()
+		if err != nil {
+			return err
+		}
+		c, err := client.New(cfg, client.Options{Scheme: scheme})
+		if err != nil {
+			return err
+		}
+		report := preflight.Run(cmd.Context(), c, preflightOpts)
+		report.Print(os.Stdout)
+		if report.Failed() {
+			return fmt.Errorf("preflight failed")
+		}
+		return nil
+	},
+}
+
+func init() {
+	fs := preflightCmd.Flags()
+	fs.StringVar(&preflightOpts.Namespace, "namespace", configuration.RuntimeNamespace(), "Namespace carina is installed in")
+	fs.StringVar(&preflightOpts.ControllerName, "controller-name", "csi-carina-provisioner", "Name of the carina controller deployment")
+	fs.StringVar(&preflightOpts.NodeName, "node-name", "csi-carina-node", "Name of the carina node daemonset")
+	fs.StringVar(&preflightOpts.ConfigMap, "config-map", "carina-csi-config", "Name of the configmap holding config.json")
+	rootCmd.AddCommand(preflightCmd)
+}
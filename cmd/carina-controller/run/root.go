@@ -20,10 +20,11 @@ import (
 	"flag"
 	"fmt"
 	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
 	"github.com/spf13/cobra"
 	"k8s.io/klog/v2"
 	"os"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"time"
 )
 
 var config struct {
@@ -31,8 +32,26 @@ var config struct {
 	metricsAddr string
 	webhookAddr string
 	httpAddr    string
+	journalPath string
+	journalSize int
 	certDir     string
-	zapOpts     zap.Options
+	logLevel    string
+	logFormat   string
+
+	// webhookService is the service of carina-controller the LogicVolume crd sends conversions to
+	webhookService string
+
+	tracingEndpoint    string
+	tracingInsecure    bool
+	tracingSampleRatio float64
+
+	leaderElect             bool
+	leaseDuration           time.Duration
+	renewDeadline           time.Duration
+	retryPeriod             time.Duration
+	gracefulShutdownTimeout time.Duration
+
+	replicaFailoverGracePeriod time.Duration
 }
 
 var rootCmd = &cobra.Command{
@@ -64,10 +83,24 @@ func init() {
 	fs.StringVar(&config.webhookAddr, "webhook-addr", ":8443", "Listen address for the webhook endpoint")
 	fs.StringVar(&config.httpAddr, "http-addr", ":8089", "Listen address for the http")
 	fs.StringVar(&config.certDir, "cert-dir", "", "certificate directory")
+	fs.StringVar(&config.webhookService, "webhook-service", "carina-controller", "Service of the webhook endpoint in the namespace of carina-controller, the LogicVolume crd converts versions through it")
+	fs.StringVar(&config.journalPath, "journal-path", "/var/log/carina/csi-journal-controller.log", "File the recent CSI requests are journaled to")
+	fs.IntVar(&config.journalSize, "journal-size", 1000, "Number of CSI requests and responses kept in the journal, 0 disables it")
+	fs.BoolVar(&config.leaderElect, "leader-elect", true, "Elect a leader among the carina-controller replicas, only the leader runs the controllers")
+	fs.DurationVar(&config.leaseDuration, "leader-elect-lease-duration", 15*time.Second, "Duration a replica waits before taking over the leader lease that was not renewed")
+	fs.DurationVar(&config.renewDeadline, "leader-elect-renew-deadline", 10*time.Second, "Duration the leader retries to renew the lease before it steps down")
+	fs.DurationVar(&config.retryPeriod, "leader-elect-retry-period", 2*time.Second, "Duration between attempts to acquire or renew the leader lease")
+	fs.DurationVar(&config.gracefulShutdownTimeout, "graceful-shutdown-timeout", 30*time.Second, "Time in-flight CSI requests and reconciles get to finish on shutdown before the leader lease is released")
+	fs.DurationVar(&config.replicaFailoverGracePeriod, "replica-failover-grace-period", 5*time.Minute, "Time a node stays NotReady before the pods using replicated volumes move to the node of the other copy, 0 waits for the node.kubernetes.io/out-of-service taint")
+
+	fs.StringVar(&config.logLevel, "log-level", "info", "Log level, one of debug, info, warn and error")
+	fs.StringVar(&config.logFormat, "log-format", log.FormatConsole, "Log format, console or json")
+	fs.StringVar(&config.tracingEndpoint, "tracing-endpoint", "", "host:port of the OTLP gRPC collector the spans of the CSI calls are exported to, empty disables tracing")
+	fs.BoolVar(&config.tracingInsecure, "tracing-insecure", false, "Export spans without TLS")
+	fs.Float64Var(&config.tracingSampleRatio, "tracing-sample-ratio", 1, "Ratio of the CSI calls traced when the caller did not decide")
 
 	goflags := flag.NewFlagSet("klog", flag.ExitOnError)
 	klog.InitFlags(goflags)
-	config.zapOpts.BindFlags(goflags)
 
 	fs.AddGoFlagSet(goflags)
 }
@@ -20,6 +20,8 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"path/filepath"
+	"time"
 
 	carinav1 "github.com/carina-io/carina/api/v1"
 	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
@@ -28,19 +30,26 @@ import (
 	"github.com/carina-io/carina/pkg/configuration"
 	"github.com/carina-io/carina/pkg/csidriver/driver"
 	"github.com/carina-io/carina/pkg/csidriver/driver/k8s"
+	"github.com/carina-io/carina/pkg/csidriver/journal"
+	"github.com/carina-io/carina/pkg/csidriver/requestlog"
 	"github.com/carina-io/carina/pkg/csidriver/runners"
+	"github.com/carina-io/carina/pkg/tracing"
 	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -53,13 +62,28 @@ func init() {
 	utilruntime.Must(carinav1.AddToScheme(scheme))
 	utilruntime.Must(carinav1beta1.AddToScheme(scheme))
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	// preflight命令读取crd
+	utilruntime.Must(apiextv1.AddToScheme(scheme))
 
 	// +kubebuilder:scaffold:scheme
 }
 
 // Run builds and starts the manager with leader election.
 func subMain() error {
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&config.zapOpts)))
+	// controller-runtime和klog的日志与carina自身的日志使用同一级别和格式
+	if err := log.Setup(config.logLevel, config.logFormat); err != nil {
+		return err
+	}
+	ctrl.SetLogger(log.Logr())
+	klog.SetLogger(log.Logr())
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "carina-controller", "", config.tracingEndpoint, config.tracingInsecure, config.tracingSampleRatio)
+	if err != nil {
+		return fmt.Errorf("unable to set up tracing: %v", err)
+	}
+	defer func() {
+		_ = shutdownTracing(context.Background())
+	}()
 
 	cfg, err := ctrl.GetConfig()
 	if err != nil {
@@ -77,9 +101,15 @@ func subMain() error {
 	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme:                  scheme,
 		MetricsBindAddress:      config.metricsAddr,
-		LeaderElection:          true,
+		LeaderElection:          config.leaderElect,
 		LeaderElectionID:        utils.CSIPluginName + "-carina-controller",
 		LeaderElectionNamespace: configuration.RuntimeNamespace(),
+		LeaseDuration:           &config.leaseDuration,
+		RenewDeadline:           &config.renewDeadline,
+		RetryPeriod:             &config.retryPeriod,
+		// 退出时释放lease，其他副本无需等待lease过期即可接管
+		LeaderElectionReleaseOnCancel: true,
+		GracefulShutdownTimeout:       &config.gracefulShutdownTimeout,
 		WebhookServer: &webhook.Server{
 			Host:     hookHost,
 			Port:     hookPort,
@@ -97,7 +127,16 @@ func subMain() error {
 	dec, _ := admission.NewDecoder(scheme)
 	wh := mgr.GetWebhookServer()
 	wh.Register("/pod/mutate", hook.PodMutator(mgr.GetClient(), dec))
-	//wh.Register("/pvc/mutate", hook.PVCMutator(mgr.GetClient(), dec))
+	wh.Register("/pod/validate", hook.PodValidator(dec))
+	wh.Register("/pvc/validate", hook.PVCValidator(mgr.GetClient(), dec))
+	wh.Register("/storageclass/validate", hook.StorageClassValidator(mgr.GetClient(), dec))
+	wh.Register("/pvc/mutate", hook.PVCMutator(mgr.GetClient(), dec))
+	wh.Register("/volumeoperation/mutate", hook.VolumeOperationAuthorizer(mgr.GetClient(), dec))
+	wh.Register("/pod/evict", hook.EvictionValidator(mgr.GetClient()))
+	wh.Register("/logicvolume/mutate", hook.LogicVolumeDefaulter(dec))
+	wh.Register("/logicvolume/validate", hook.LogicVolumeValidator(dec))
+	// 转换LogicVolume的v1和v1beta1，crd的conversion由ConversionInjector指向该地址
+	wh.Register("/convert", &conversion.Webhook{})
 
 	stopChan := make(chan struct{})
 	defer close(stopChan)
@@ -121,6 +160,183 @@ func subMain() error {
 		return err
 	}
 
+	releasedVolumeController := &controllers.ReleasedVolumeReconciler{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("carina-controller"),
+	}
+	if err := releasedVolumeController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ReleasedVolume")
+		return err
+	}
+
+	sharedVolumeController := &controllers.SharedVolumeReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("carina-controller"),
+	}
+	if err := sharedVolumeController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "SharedVolume")
+		return err
+	}
+
+	orphanVolumeController := &controllers.OrphanVolumeReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Recorder:  mgr.GetEventRecorderFor("carina-controller"),
+	}
+	if err := orphanVolumeController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "OrphanVolume")
+		return err
+	}
+
+	storageConditionController := &controllers.StorageConditionReconciler{
+		Client: mgr.GetClient(),
+	}
+	if err := storageConditionController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "StorageCondition")
+		return err
+	}
+
+	volumeTTLController := &controllers.VolumeTTLReconciler{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("carina-controller"),
+	}
+	if err := volumeTTLController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VolumeTTL")
+		return err
+	}
+
+	volumeAutoresizeController := &controllers.VolumeAutoresizeReconciler{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("carina-controller"),
+	}
+	if err := volumeAutoresizeController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VolumeAutoresize")
+		return err
+	}
+
+	volumeReplicationController := &controllers.VolumeReplicationReconciler{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("carina-controller"),
+	}
+	if err := volumeReplicationController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VolumeReplication")
+		return err
+	}
+
+	failureDomainController := &controllers.FailureDomainReconciler{
+		Client: mgr.GetClient(),
+	}
+	if err := failureDomainController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "FailureDomain")
+		return err
+	}
+
+	cacheDeviceController := &controllers.CacheDeviceReconciler{
+		Client: mgr.GetClient(),
+	}
+	if err := cacheDeviceController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CacheDevice")
+		return err
+	}
+
+	volumeActivityExporter := &controllers.VolumeActivityExporter{
+		Client: mgr.GetClient(),
+	}
+	if err := volumeActivityExporter.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VolumeActivity")
+		return err
+	}
+
+	capacityForecaster := &controllers.CapacityForecaster{
+		Client: mgr.GetClient(),
+	}
+	if err := capacityForecaster.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CapacityForecast")
+		return err
+	}
+
+	volumeOperationController := &controllers.VolumeOperationReconciler{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("carina-controller"),
+	}
+	if err := volumeOperationController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VolumeOperation")
+		return err
+	}
+
+	volumeFreezeCoordinator := &controllers.VolumeFreezeCoordinator{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("carina-controller"),
+	}
+	if err := volumeFreezeCoordinator.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VolumeFreezeCoordinator")
+		return err
+	}
+
+	quotaController := &controllers.CarinaQuotaReconciler{
+		Client: mgr.GetClient(),
+	}
+	if err := quotaController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CarinaQuota")
+		return err
+	}
+
+	rebalancePlanner := &controllers.RebalancePlanner{
+		Client: mgr.GetClient(),
+	}
+	if err := rebalancePlanner.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RebalancePlanner")
+		return err
+	}
+
+	replicaPlacementController := &controllers.ReplicaPlacementReconciler{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("carina-controller"),
+	}
+	if err := replicaPlacementController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ReplicaPlacement")
+		return err
+	}
+
+	replicaFailoverController := &controllers.ReplicaFailoverReconciler{
+		Client:              mgr.GetClient(),
+		Recorder:            mgr.GetEventRecorderFor("carina-controller"),
+		NotReadyGracePeriod: config.replicaFailoverGracePeriod,
+	}
+	if err := replicaFailoverController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ReplicaFailover")
+		return err
+	}
+
+	// KubeVirt是可选的，未安装时不启动热迁移协调
+	if _, err := mgr.GetRESTMapper().RESTMapping(controllers.VMIMigrationGVK.GroupKind(), controllers.VMIMigrationGVK.Version); err == nil {
+		vmMigrationController := &controllers.VMMigrationReconciler{
+			Client:   mgr.GetClient(),
+			Recorder: mgr.GetEventRecorderFor("carina-controller"),
+		}
+		if err := vmMigrationController.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "VMMigration")
+			return err
+		}
+	} else {
+		setupLog.Info("kubevirt is not installed, live migration coordination disabled")
+	}
+
+	// 快照策略依赖external-snapshotter的crd
+	if _, err := mgr.GetRESTMapper().RESTMapping(controllers.VolumeSnapshotGVK.GroupKind(), controllers.VolumeSnapshotGVK.Version); err == nil {
+		snapshotPolicyController := &controllers.SnapshotPolicyReconciler{
+			Client:   mgr.GetClient(),
+			Recorder: mgr.GetEventRecorderFor("carina-controller"),
+		}
+		if err := snapshotPolicyController.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "SnapshotPolicy")
+			return err
+		}
+	} else {
+		setupLog.Info("volume snapshot crds are not installed, snapshot policies disabled")
+	}
+
 	// +kubebuilder:scaffold:builder
 
 	// pre-cache objects
@@ -142,6 +358,17 @@ func subMain() error {
 		return err
 	}
 
+	if err := mgr.Add(&hook.ConversionInjector{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		CAFile:    filepath.Join(config.certDir, "ca"),
+		Namespace: configuration.RuntimeNamespace(),
+		Service:   config.webhookService,
+		Interval:  10 * time.Minute,
+	}); err != nil {
+		return err
+	}
+
 	// Add metrics exporter to manager.
 	// Note that grpc.ClientConn can be shared with multiple stubs/services.
 	// https://github.com/grpc/grpc-go/tree/master/examples/features/multiplex
@@ -156,19 +383,33 @@ func subMain() error {
 	}
 	n := k8s.NewNodeService(mgr)
 
-	grpcServer := grpc.NewServer()
+	var rpcJournal *journal.Journal
+	// request id先于journal分配，journal记录里带上同一个request id
+	// span先于request id创建，日志里带上trace id
+	interceptors := []grpc.UnaryServerInterceptor{otelgrpc.UnaryServerInterceptor(), requestlog.UnaryServerInterceptor()}
+	if config.journalSize > 0 {
+		rpcJournal, err = journal.New(config.journalPath, config.journalSize)
+		if err != nil {
+			return err
+		}
+		defer rpcJournal.Close()
+		interceptors = append(interceptors, rpcJournal.UnaryServerInterceptor())
+	}
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
 	csi.RegisterIdentityServer(grpcServer, driver.NewIdentityService())
 	csi.RegisterControllerServer(grpcServer, driver.NewControllerService(s, n))
 
 	// gRPC service itself should run even when the manager is *not* a leader
-	// because CSI sidecar containers choose a leader.
-	err = mgr.Add(runners.NewGRPCRunner(grpcServer, config.csiSocket, false))
+	// because CSI sidecar containers choose a leader. On shutdown in-flight requests get half
+	// of the graceful shutdown timeout, a CreateVolume cut short is retried by the provisioner
+	// of the next leader and adopts the LogicVolume already created.
+	err = mgr.Add(runners.NewGRPCRunner(grpcServer, config.csiSocket, false, config.gracefulShutdownTimeout/2))
 	if err != nil {
 		return err
 	}
 
 	// Http Server
-	e := newHttpServer(mgr.GetCache(), stopChan)
+	e := newHttpServer(mgr.GetCache(), rpcJournal, stopChan)
 	go e.start()
 
 	setupLog.Info("starting manager")
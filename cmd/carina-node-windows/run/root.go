@@ -0,0 +1,78 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+var config struct {
+	csiSocket   string
+	metricsAddr string
+	journalPath string
+	journalSize int
+	logLevel    string
+	logFormat   string
+}
+
+var rootCmd = &cobra.Command{
+	Use:     "carina-node",
+	Version: utils.Version,
+	Short:   "Carina CSI node for windows",
+	Long: `carina-node for windows provides CSI node service on windows nodes.
+Volumes are virtual disks of the storage pools of the node, they are
+partitioned, formatted and mounted through CSI Proxy.
+
+The node name where this program runs must be given by
+NODE_NAME environment variable.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return subMain()
+	},
+}
+
+// Execute adds all child commands to the root command and sets flags appropriately.
+// This is called by main.main(). It only needs to happen once to the rootCmd.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	fs := rootCmd.Flags()
+	fs.StringVar(&config.csiSocket, "csi-address", `C:\var\lib\kubelet\plugins\carina.storage.io\csi.sock`, "UNIX domain socket filename for CSI")
+	fs.StringVar(&config.metricsAddr, "metrics-addr", ":8080", "Listen address for metrics")
+	fs.StringVar(&config.journalPath, "journal-path", `C:\var\log\carina\csi-journal-node.log`, "File the recent CSI requests are journaled to")
+	fs.IntVar(&config.journalSize, "journal-size", 1000, "Number of CSI requests and responses kept in the journal, 0 disables it")
+	fs.StringVar(&config.logLevel, "log-level", "info", "Log level, one of debug, info, warn and error")
+	fs.StringVar(&config.logFormat, "log-format", log.FormatConsole, "Log format, console or json")
+
+	goflags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(goflags)
+
+	fs.AddGoFlagSet(goflags)
+}
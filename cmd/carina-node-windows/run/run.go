@@ -0,0 +1,126 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/csidriver/journal"
+	"github.com/carina-io/carina/pkg/csidriver/requestlog"
+	"github.com/carina-io/carina/pkg/windows/csiproxy"
+	"github.com/carina-io/carina/pkg/windows/node"
+	"github.com/carina-io/carina/pkg/windows/storagespaces"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var (
+	scheme   = runtime.NewScheme()
+	setupLog = ctrl.Log.WithName("setup")
+)
+
+func init() {
+	utilruntime.Must(carinav1.AddToScheme(scheme))
+	utilruntime.Must(carinav1beta1.AddToScheme(scheme))
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+}
+
+func subMain() error {
+	nodeName := os.Getenv("NODE_NAME")
+	if len(nodeName) == 0 {
+		return errors.New("env NODE_NAME is not given")
+	}
+
+	if err := log.Setup(config.logLevel, config.logFormat); err != nil {
+		return err
+	}
+	ctrl.SetLogger(log.Logr())
+	klog.SetLogger(log.Logr())
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:             scheme,
+		MetricsBindAddress: config.metricsAddr,
+		LeaderElection:     false,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to start manager")
+		return err
+	}
+
+	// 分区、格式化和挂载由节点上安装的csi-proxy完成
+	proxy, err := csiproxy.NewClient()
+	if err != nil {
+		setupLog.Error(err, "unable to connect to csi-proxy")
+		return err
+	}
+	defer proxy.Close()
+	spaces := &storagespaces.StorageSpacesImplement{Shell: storagespaces.PowerShell{}}
+
+	lvController := node.NewLogicVolumeReconciler(
+		mgr.GetClient(),
+		mgr.GetEventRecorderFor("logicvolume-node"),
+		nodeName,
+		spaces,
+	)
+	if err := lvController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LogicalVolume")
+		return err
+	}
+
+	if err := mgr.Add(node.NewResourceReporter(mgr.GetClient(), nodeName, spaces)); err != nil {
+		return err
+	}
+
+	interceptors := []grpc.UnaryServerInterceptor{requestlog.UnaryServerInterceptor()}
+	if config.journalSize > 0 {
+		if err := os.MkdirAll(filepath.Dir(config.journalPath), 0755); err != nil {
+			return err
+		}
+		rpcJournal, err := journal.New(config.journalPath, config.journalSize)
+		if err != nil {
+			return err
+		}
+		defer rpcJournal.Close()
+		interceptors = append(interceptors, rpcJournal.UnaryServerInterceptor())
+	}
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	csi.RegisterIdentityServer(grpcServer, node.NewIdentityService())
+	csi.RegisterNodeServer(grpcServer, node.NewNodeService(nodeName, mgr.GetClient(), spaces, proxy))
+	if err := os.MkdirAll(filepath.Dir(config.csiSocket), 0755); err != nil {
+		return err
+	}
+	if err := mgr.Add(node.NewGRPCRunner(grpcServer, config.csiSocket)); err != nil {
+		return err
+	}
+
+	setupLog.Info("starting manager")
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running manager")
+		return err
+	}
+	return nil
+}
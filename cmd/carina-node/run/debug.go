@@ -0,0 +1,88 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/carina-io/carina/controllers"
+	"github.com/carina-io/carina/pkg/csidriver/filesystem"
+	"github.com/carina-io/carina/pkg/datamover"
+	"github.com/carina-io/carina/pkg/devicemanager/bcache"
+	"github.com/carina-io/carina/pkg/devicemanager/lvmd"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	lvmManager   lvmd.Lvm2
+	moveThrottle *datamover.Throttle
+	debugNode    string
+)
+
+// debugAuth 调试接口要求请求携带token，token文件不存在或为空时关闭接口
+// The token file is read on every request, so a secret created or rotated later takes effect without a restart.
+func debugAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		b, err := ioutil.ReadFile(config.debugTokenFile)
+		token := strings.TrimSpace(string(b))
+		if err != nil || token == "" {
+			return c.JSON(http.StatusNotFound, "debug api is disabled")
+		}
+		got := c.Request().Header.Get(utils.DebugTokenHeader)
+		if got == "" {
+			got = strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return c.JSON(http.StatusUnauthorized, "invalid debug token")
+		}
+		return next(c)
+	}
+}
+
+// debugState 返回节点上lvm、bcache、挂载和限速的实时状态
+func debugState(c echo.Context) error {
+	state := types.NodeDebugState{Node: debugNode, Time: time.Now(), Errors: map[string]string{}}
+	var err error
+	if state.VgGroups, err = volumeManager.GetCurrentVgStruct(); err != nil {
+		state.Errors["vgs"] = err.Error()
+	}
+	if state.PVs, err = lvmManager.PVS(); err != nil {
+		state.Errors["pvs"] = err.Error()
+	}
+	if state.LVs, err = volumeManager.VolumeList("", ""); err != nil {
+		state.Errors["lvs"] = err.Error()
+	}
+	if state.Bcache, err = bcache.Stats(); err != nil {
+		state.Errors["bcache"] = err.Error()
+	}
+	if state.Mounts, err = filesystem.DeviceMounts(); err != nil {
+		state.Errors["mounts"] = err.Error()
+	}
+	state.Throttle.Windows, state.Throttle.Bandwidth = moveThrottle.Settings()
+	state.Throttle.Open = moveThrottle.Open()
+	state.Throttle.Blkio = controllers.BlkioThrottles()
+	if len(state.Errors) == 0 {
+		state.Errors = nil
+	}
+	return c.JSON(http.StatusOK, state)
+}
@@ -17,13 +17,21 @@
 package run
 
 import (
+	"net/http"
+	"strconv"
+
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/csidriver/journal"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/lvmd"
 	"github.com/carina-io/carina/pkg/devicemanager/volume"
 	"github.com/labstack/echo/v4"
-	"net/http"
 )
 
 var (
 	volumeManager volume.LocalVolume
+	csiJournal    *journal.Journal
+	filterStatus  func() []lvmd.FilterStatus
 )
 
 type eHttpServer struct {
@@ -31,11 +39,20 @@ type eHttpServer struct {
 	stopChan <-chan struct{}
 }
 
-func newHttpServer(v volume.LocalVolume, stopChan <-chan struct{}) *eHttpServer {
-	volumeManager = v
+func newHttpServer(dm *deviceManager.DeviceManager, nodeName string, j *journal.Journal, stopChan <-chan struct{}) *eHttpServer {
+	volumeManager = dm.VolumeManager
+	lvmManager = dm.LvmManager
+	moveThrottle = dm.Throttle
+	debugNode = nodeName
+	csiJournal = j
+	filterStatus = dm.LvmFilterStatus
 	e := echo.New()
 	e.GET("/devicegroup", vgList)
 	e.GET("/volume", volumeList)
+	e.GET("/journal", journalDump)
+	e.GET("/lvmfilter", lvmFilter)
+	e.GET("/config", appliedConfig)
+	e.GET("/debug/state", debugState, debugAuth)
 
 	return &eHttpServer{
 		e:        e,
@@ -71,3 +88,26 @@ func volumeList(c echo.Context) error {
 	}
 	return c.JSON(http.StatusOK, lvList)
 }
+
+// appliedConfig 返回当前生效的配置版本，以及最近一次校验失败被拒绝的配置
+func appliedConfig(c echo.Context) error {
+	return c.JSON(http.StatusOK, configuration.Applied())
+}
+
+// journalDump 返回最近的csi请求记录，?limit=n只返回最后n条
+func journalDump(c echo.Context) error {
+	if csiJournal == nil {
+		return c.JSON(http.StatusNotFound, "csi journal is disabled")
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	records, err := csiJournal.Dump(limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, records)
+}
+
+// lvmFilter 返回lvm.conf过滤规则的同步状态及漂移次数
+func lvmFilter(c echo.Context) error {
+	return c.JSON(http.StatusOK, filterStatus())
+}
@@ -20,17 +20,34 @@ import (
 	"flag"
 	"fmt"
 	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
 	"github.com/spf13/cobra"
 	"k8s.io/klog/v2"
 	"os"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
 var config struct {
 	csiSocket   string
 	metricsAddr string
 	httpAddr    string
-	zapOpts     zap.Options
+	journalPath string
+	journalSize int
+	// operationJournalDir 卷创建的操作日志，节点重启后据此收尾中断的创建
+	operationJournalDir string
+	// debugTokenFile 调试接口的token，来自可选挂载的secret
+	debugTokenFile string
+	logLevel       string
+	logFormat      string
+
+	tracingEndpoint    string
+	tracingInsecure    bool
+	tracingSampleRatio float64
+
+	standalone bool
+	stateDir   string
+
+	replicationAddr    string
+	replicationCertDir string
 }
 
 var rootCmd = &cobra.Command{
@@ -63,10 +80,23 @@ func init() {
 	fs.StringVar(&config.csiSocket, "csi-address", utils.DefaultCSISocket, "UNIX domain socket filename for CSI")
 	fs.StringVar(&config.metricsAddr, "metrics-addr", ":8080", "Listen address for metrics")
 	fs.StringVar(&config.httpAddr, "http-addr", ":8089", "Listen address for http")
+	fs.StringVar(&config.journalPath, "journal-path", "/var/log/carina/csi-journal-node.log", "File the recent CSI requests are journaled to")
+	fs.IntVar(&config.journalSize, "journal-size", 1000, "Number of CSI requests and responses kept in the journal, 0 disables it")
+	fs.StringVar(&config.operationJournalDir, "operation-journal-dir", "/var/lib/carina/operations", "Host directory of the journal of volume creations, interrupted creations are resumed or rolled back after a restart")
+	fs.StringVar(&config.debugTokenFile, "debug-token-file", "/var/run/carina/debug/token", "File holding the token of the /debug/state api, the api is disabled while it is missing or empty")
+
+	fs.StringVar(&config.logLevel, "log-level", "info", "Log level, one of debug, info, warn and error")
+	fs.StringVar(&config.logFormat, "log-format", log.FormatConsole, "Log format, console or json")
+	fs.StringVar(&config.tracingEndpoint, "tracing-endpoint", "", "host:port of the OTLP gRPC collector the spans of the CSI calls are exported to, empty disables tracing")
+	fs.BoolVar(&config.tracingInsecure, "tracing-insecure", false, "Export spans without TLS")
+	fs.Float64Var(&config.tracingSampleRatio, "tracing-sample-ratio", 1, "Ratio of the CSI calls traced when the caller did not decide")
+	fs.BoolVar(&config.standalone, "standalone", false, "Serve the CSI controller service too and keep the carina objects in --standalone-state-dir instead of CRDs, for single node clusters without carina-controller")
+	fs.StringVar(&config.stateDir, "standalone-state-dir", "/var/lib/carina/state", "Directory of the carina objects in standalone mode")
+	fs.StringVar(&config.replicationAddr, "replication-addr", "", "Listen address of the endpoint receiving replicated snapshots, empty disables receiving")
+	fs.StringVar(&config.replicationCertDir, "replication-cert-dir", "/var/run/carina/replication", "Directory of tls.crt, tls.key and ca.crt used by the replication endpoint and client")
 
 	goflags := flag.NewFlagSet("klog", flag.ExitOnError)
 	klog.InitFlags(goflags)
-	config.zapOpts.BindFlags(goflags)
 
 	fs.AddGoFlagSet(goflags)
 }
@@ -19,6 +19,7 @@ package run
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 
 	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
@@ -27,16 +28,24 @@ import (
 	"github.com/carina-io/carina/controllers"
 	"github.com/carina-io/carina/pkg/csidriver/driver"
 	"github.com/carina-io/carina/pkg/csidriver/driver/k8s"
+	"github.com/carina-io/carina/pkg/csidriver/journal"
+	"github.com/carina-io/carina/pkg/csidriver/requestlog"
 	"github.com/carina-io/carina/pkg/csidriver/runners"
 	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/tools"
+	"github.com/carina-io/carina/pkg/opjournal"
+	"github.com/carina-io/carina/pkg/standalone"
+	"github.com/carina-io/carina/pkg/tracing"
+	"github.com/carina-io/carina/utils/log"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -59,13 +68,40 @@ func subMain() error {
 		return errors.New("env NODE_NAME is not given")
 	}
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&config.zapOpts)))
+	// controller-runtime和klog的日志与carina自身的日志使用同一级别和格式
+	if err := log.Setup(config.logLevel, config.logFormat); err != nil {
+		return err
+	}
+	ctrl.SetLogger(log.Logr())
+	klog.SetLogger(log.Logr())
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	// 镜像和发行版不同，lvm2等工具的位置及是否安装也不同，缺失的功能在NodeStorageResource中报告
+	tools.Setup()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "carina-node", nodeName, config.tracingEndpoint, config.tracingInsecure, config.tracingSampleRatio)
+	if err != nil {
+		return fmt.Errorf("unable to set up tracing: %v", err)
+	}
+	defer func() {
+		_ = shutdownTracing(context.Background())
+	}()
+
+	options := ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: config.metricsAddr,
 		LeaderElection:     false,
-	})
+	}
+	// 单机模式下LogicVolume等carina对象保存在节点本地，其余对象仍读写api server
+	if config.standalone {
+		store, err := standalone.NewStore(config.stateDir, scheme)
+		if err != nil {
+			return err
+		}
+		options.NewCache = standalone.NewCache(store)
+		options.NewClient = standalone.NewClient(store)
+		setupLog.Info("standalone mode", "stateDir", config.stateDir)
+	}
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		return err
@@ -81,6 +117,11 @@ func subMain() error {
 	stopChan := make(chan struct{})
 	defer close(stopChan)
 	dm := deviceManager.NewDeviceManager(nodeName, mgr.GetCache(), stopChan)
+	// 升级卷的lvm元数据，卷的元数据比当前carina新时拒绝启动
+	if err := dm.MigrateMetadata(); err != nil {
+		setupLog.Error(err, "unable to migrate lvm metadata")
+		return err
+	}
 
 	podController := controllers.PodReconciler{
 		Client:   mgr.GetClient(),
@@ -94,6 +135,12 @@ func subMain() error {
 		return err
 	}
 
+	opJournal, err := opjournal.Open(config.operationJournalDir)
+	if err != nil {
+		setupLog.Error(err, "unable to open operation journal")
+		return err
+	}
+
 	lvController := controllers.NewLogicVolumeReconciler(
 		mgr.GetClient(),
 		mgr.GetScheme(),
@@ -101,6 +148,9 @@ func subMain() error {
 		nodeName,
 		dm.VolumeManager,
 		dm.Partition,
+		dm.Pool,
+		dm.Throttle,
+		opJournal,
 	)
 
 	if err := lvController.SetupWithManager(mgr); err != nil {
@@ -111,6 +161,7 @@ func subMain() error {
 	nodeResourceController := controllers.NewNodeStorageResourceReconciler(
 		mgr.GetClient(),
 		mgr.GetScheme(),
+		mgr.GetEventRecorderFor("nodestorageresource-node"),
 		nodeName,
 		dm.VolumeManager,
 		stopChan,
@@ -123,6 +174,69 @@ func subMain() error {
 		return err
 	}
 
+	rebalanceController := &controllers.RebalanceReconciler{
+		Client:   mgr.GetClient(),
+		NodeName: nodeName,
+		DM:       dm,
+	}
+	if err := rebalanceController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Rebalance")
+		return err
+	}
+
+	volumeFreezeController := &controllers.VolumeFreezeReconciler{
+		Client:   mgr.GetClient(),
+		NodeName: nodeName,
+		DM:       dm,
+	}
+	if err := volumeFreezeController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VolumeFreeze")
+		return err
+	}
+
+	drbdController := &controllers.DrbdReconciler{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("drbd-node"),
+		NodeName: nodeName,
+		DM:       dm,
+	}
+	if err := drbdController.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Drbd")
+		return err
+	}
+
+	orphanCollector := &controllers.OrphanCollector{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("nodestorageresource-node"),
+		NodeName: nodeName,
+		Trouble:  dm.Trouble,
+		Pool:     dm.Pool,
+	}
+	if err := orphanCollector.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "OrphanCollector")
+		return err
+	}
+
+	// 没有carina-controller时由节点删除孤儿LogicVolume、设置pod的condition
+	if config.standalone {
+		orphanVolumeController := &controllers.OrphanVolumeReconciler{
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
+			Recorder:  mgr.GetEventRecorderFor("nodestorageresource-node"),
+		}
+		if err := orphanVolumeController.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "OrphanVolume")
+			return err
+		}
+		storageConditionController := &controllers.StorageConditionReconciler{
+			Client: mgr.GetClient(),
+		}
+		if err := storageConditionController.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "StorageCondition")
+			return err
+		}
+	}
+
 	if _, err := mgr.GetCache().GetInformer(ctx, &corev1.Node{}); err != nil {
 		return err
 	}
@@ -135,6 +249,27 @@ func subMain() error {
 		return err
 	}
 
+	// 记录卷最近一次I/O的时间
+	if err := mgr.Add(&controllers.VolumeActivityRecorder{Client: mgr.GetClient(), DM: dm}); err != nil {
+		return err
+	}
+
+	// 记录已挂载卷的文件系统使用率，供自动扩容
+	if err := mgr.Add(&controllers.VolumeUsageRecorder{Client: mgr.GetClient(), NodeName: nodeName}); err != nil {
+		return err
+	}
+
+	// 异步复制卷快照到对端集群
+	if err := mgr.Add(&controllers.VolumeReplicationAgent{
+		Client:   mgr.GetClient(),
+		NodeName: nodeName,
+		DM:       dm,
+		Addr:     config.replicationAddr,
+		CertDir:  config.replicationCertDir,
+	}); err != nil {
+		return err
+	}
+
 	// Add gRPC server to manager.
 	s, err := k8s.NewLogicVolumeService(mgr)
 	if err != nil {
@@ -143,20 +278,41 @@ func subMain() error {
 	if err := os.MkdirAll(driver.DeviceDirectory, 0755); err != nil {
 		return err
 	}
-	grpcServer := grpc.NewServer()
+	var rpcJournal *journal.Journal
+	// request id先于journal分配，journal记录里带上同一个request id
+	// span先于request id创建，日志里带上trace id
+	interceptors := []grpc.UnaryServerInterceptor{otelgrpc.UnaryServerInterceptor(), requestlog.UnaryServerInterceptor()}
+	if config.journalSize > 0 {
+		rpcJournal, err = journal.New(config.journalPath, config.journalSize)
+		if err != nil {
+			return err
+		}
+		defer rpcJournal.Close()
+		interceptors = append(interceptors, rpcJournal.UnaryServerInterceptor())
+	}
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
 	csi.RegisterIdentityServer(grpcServer, driver.NewIdentityService())
-	csi.RegisterNodeServer(grpcServer, driver.NewNodeService(nodeName, dm.VolumeManager, dm.Partition, s))
-	err = mgr.Add(runners.NewGRPCRunner(grpcServer, config.csiSocket, false))
+	csi.RegisterNodeServer(grpcServer, driver.NewNodeService(nodeName, dm.VolumeManager, dm.Partition, s, dm.Pool, dm.Throttle))
+	if config.standalone {
+		// 没有carina-controller，由节点提供controller服务，api reader同样读本地的LogicVolume
+		s.APIReader = mgr.GetClient()
+		csi.RegisterControllerServer(grpcServer, driver.NewControllerService(s, k8s.NewNodeService(mgr)))
+	}
+	err = mgr.Add(runners.NewGRPCRunner(grpcServer, config.csiSocket, false, 0))
 	if err != nil {
 		return err
 	}
 
 	// 启动磁盘检查
 	go dm.DeviceCheckTask()
-	// 启动volume一致性检查
-	dm.VolumeConsistencyCheck()
+	// 补充预先格式化的备用卷
+	dm.SpareVolumeTask()
+	// 同步lvm.conf过滤规则
+	dm.LvmFilterTask()
+	// 定时对开启fstrim的卷回收空间
+	dm.FstrimTask()
 	// http server
-	e := newHttpServer(dm.VolumeManager, stopChan)
+	e := newHttpServer(dm, nodeName, rpcJournal, stopChan)
 	go e.start()
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -0,0 +1,88 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/utils"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var capacityNode string
+
+var nodeCapacityCmd = &cobra.Command{
+	Use:   "node-capacity",
+	Short: "Show capacity and allocatable storage of every node and device group",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+		return nodeCapacity(cmd.Context(), c, rootCmd.OutOrStdout())
+	},
+}
+
+func init() {
+	nodeCapacityCmd.Flags().StringVar(&capacityNode, "node", "", "Only show the given node")
+	rootCmd.AddCommand(nodeCapacityCmd)
+}
+
+func nodeCapacity(ctx context.Context, c client.Reader, out io.Writer) error {
+	nsrList := new(carinav1beta1.NodeStorageResourceList)
+	if err := c.List(ctx, nsrList); err != nil {
+		return err
+	}
+	sort.Slice(nsrList.Items, func(i, j int) bool {
+		return nsrList.Items[i].Spec.NodeName < nsrList.Items[j].Spec.NodeName
+	})
+
+	w := newTabWriter(out)
+	defer w.Flush()
+	fmt.Fprintln(w, "NODE\tGROUP\tCAPACITY\tALLOCATABLE\tSYNCED")
+	for _, nsr := range nsrList.Items {
+		if capacityNode != "" && nsr.Spec.NodeName != capacityNode {
+			continue
+		}
+		synced := "<unknown>"
+		if !nsr.Status.SyncTime.IsZero() {
+			synced = duration.HumanDuration(time.Since(nsr.Status.SyncTime.Time)) + " ago"
+		}
+		keys := []string{}
+		for k := range nsr.Status.Capacity {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			capacity := nsr.Status.Capacity[k]
+			allocatable := nsr.Status.Allocatable[k]
+			// lvm容量单位为Gi，裸盘为字节
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", nsr.Spec.NodeName, strings.TrimPrefix(k, utils.DeviceCapacityKeyPrefix),
+				capacity.String(), allocatable.String(), synced)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// rows 把表格输出拆分为每行的字段
+func rows(out string) [][]string {
+	result := [][]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		result = append(result, strings.Fields(line))
+	}
+	return result
+}
+
+func TestNodeCapacity(t *testing.T) {
+	newNSR := func(node string, capacity, allocatable map[string]resource.Quantity) *carinav1beta1.NodeStorageResource {
+		return &carinav1beta1.NodeStorageResource{
+			ObjectMeta: metav1.ObjectMeta{Name: node},
+			Spec:       carinav1beta1.NodeStorageResourceSpec{NodeName: node},
+			Status:     carinav1beta1.NodeStorageResourceStatus{Capacity: capacity, Allocatable: allocatable},
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newNSR("node2", map[string]resource.Quantity{
+			"carina.storage.io/carina-vg-ssd": resource.MustParse("100"),
+			"carina.storage.io/carina-raw-hdd": resource.MustParse("2000398934016"),
+		}, map[string]resource.Quantity{
+			"carina.storage.io/carina-vg-ssd": resource.MustParse("60"),
+			"carina.storage.io/carina-raw-hdd": resource.MustParse("0"),
+		}),
+		newNSR("node1", map[string]resource.Quantity{
+			"carina.storage.io/carina-vg-hdd": resource.MustParse("500"),
+		}, map[string]resource.Quantity{
+			"carina.storage.io/carina-vg-hdd": resource.MustParse("480"),
+		}),
+	).Build()
+
+	header := []string{"NODE", "GROUP", "CAPACITY", "ALLOCATABLE", "SYNCED"}
+	table := []struct {
+		node   string
+		expect [][]string
+	}{
+		{node: "", expect: [][]string{
+			header,
+			{"node1", "carina-vg-hdd", "500", "480", "<unknown>"},
+			{"node2", "carina-raw-hdd", "2000398934016", "0", "<unknown>"},
+			{"node2", "carina-vg-ssd", "100", "60", "<unknown>"},
+		}},
+		{node: "node2", expect: [][]string{
+			header,
+			{"node2", "carina-raw-hdd", "2000398934016", "0", "<unknown>"},
+			{"node2", "carina-vg-ssd", "100", "60", "<unknown>"},
+		}},
+		{node: "node3", expect: [][]string{header}},
+	}
+
+	for _, tc := range table {
+		capacityNode = tc.node
+		out := new(bytes.Buffer)
+		assert.NoError(t, nodeCapacity(context.Background(), c, out))
+		assert.Equal(t, tc.expect, rows(out.String()), tc.node)
+	}
+	capacityNode = ""
+}
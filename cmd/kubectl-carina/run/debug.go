@@ -0,0 +1,119 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+var debugCmd = &cobra.Command{
+	Use:   "debug <node>",
+	Short: "Show the live lvm, bcache, mount and throttle state of a node",
+	Long: `debug calls the /debug/state api of the carina-node pod on the node through the apiserver pod proxy.
+The token of the api is read from the carina-debug-token secret in the carina namespace.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return debugNode(cmd.Context(), args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(debugCmd)
+}
+
+func debugNode(ctx context.Context, node string) error {
+	cfg, err := restConfig()
+	if err != nil {
+		return err
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	body, err := nodeDebugState(ctx, cs, config.carinaNamespace, config.nodeName, node)
+	if err != nil {
+		return err
+	}
+	out := bytes.Buffer{}
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return err
+	}
+	fmt.Fprintln(rootCmd.OutOrStdout(), out.String())
+	return nil
+}
+
+// nodeDebugState 通过apiserver的pod代理读取节点的调试状态，返回原始的json
+func nodeDebugState(ctx context.Context, cs kubernetes.Interface, namespace, daemonSet, node string) ([]byte, error) {
+	ds, err := cs.AppsV1().DaemonSets(namespace).Get(ctx, daemonSet, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods, err := cs.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(ds.Spec.Selector.MatchLabels).String(),
+		FieldSelector: "spec.nodeName=" + node,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var pod *corev1.Pod
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning {
+			pod = &pods.Items[i]
+			break
+		}
+	}
+	if pod == nil {
+		return nil, fmt.Errorf("no running pod of daemonset %s/%s on node %s", namespace, daemonSet, node)
+	}
+	port := int32(0)
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == "http" {
+				port = p.ContainerPort
+			}
+		}
+	}
+	if port == 0 {
+		return nil, fmt.Errorf("pod %s/%s has no http port", namespace, pod.Name)
+	}
+
+	secret, err := cs.CoreV1().Secrets(namespace).Get(ctx, utils.DebugTokenSecret, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("read debug token: %v", err)
+	}
+	token := strings.TrimSpace(string(secret.Data["token"]))
+
+	return cs.CoreV1().RESTClient().Get().
+		Namespace(namespace).
+		Resource("pods").
+		Name(fmt.Sprintf("%s:%d", pod.Name, port)).
+		SubResource("proxy").
+		Suffix("debug/state").
+		SetHeader(utils.DebugTokenHeader, token).
+		DoRaw(ctx)
+}
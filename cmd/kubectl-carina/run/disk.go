@@ -0,0 +1,95 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/diskimpact"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var diskCmd = &cobra.Command{
+	Use:   "disk",
+	Short: "Inspect the disks of nodes",
+}
+
+var diskVolumesCmd = &cobra.Command{
+	Use:   "volumes <node> <disk>",
+	Short: "List the LogicVolumes, PVCs and pods with data on a disk",
+	Long: `volumes lists every LogicVolume of the node whose data is on the disk, as a plain, striped or raid
+volume, the cache or backing volume of a bcache volume or a snapshot, with the PVC and pods using it.
+
+The disk is a kernel name like sdb, a device path, or a /dev/disk/by-id link, serial or wwn of a raw disk.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return diskVolumes(cmd.Context(), args[0], args[1])
+	},
+}
+
+func init() {
+	diskCmd.AddCommand(diskVolumesCmd)
+	rootCmd.AddCommand(diskCmd)
+}
+
+func diskVolumes(ctx context.Context, node, disk string) error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	nsr := &carinav1beta1.NodeStorageResource{}
+	if err := c.Get(ctx, client.ObjectKey{Name: node}, nsr); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		nsr = nil
+	}
+	lvs := new(carinav1.LogicVolumeList)
+	if err := c.List(ctx, lvs); err != nil {
+		return err
+	}
+	pvs := new(corev1.PersistentVolumeList)
+	if err := c.List(ctx, pvs); err != nil {
+		return err
+	}
+	pods := new(corev1.PodList)
+	if err := c.List(ctx, pods, client.MatchingFields{"spec.nodeName": node}); err != nil {
+		return err
+	}
+
+	w := newTabWriter(rootCmd.OutOrStdout())
+	defer w.Flush()
+	fmt.Fprintln(w, "LOGICVOLUME\tROLE\tGROUP\tDEVICES\tPVC\tPODS")
+	for _, v := range diskimpact.Find(node, diskimpact.ResolveDisk(disk, nsr), lvs.Items, pvs.Items, pods.Items) {
+		pvc, podNames := "<none>", "<none>"
+		if v.PVC != "" {
+			pvc = v.Namespace + "/" + v.PVC
+		}
+		if len(v.Pods) > 0 {
+			podNames = strings.Join(v.Pods, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", v.LogicVolume, v.Role, v.DeviceGroup, strings.Join(v.Devices, ","), pvc, podNames)
+	}
+	return nil
+}
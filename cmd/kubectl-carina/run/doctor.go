@@ -0,0 +1,256 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils"
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 节点资源超过该时间未同步视为异常
+const staleSyncTime = 10 * time.Minute
+
+type doctor struct {
+	c   client.Client
+	out io.Writer
+	// nodeVolumes 返回各节点上的lv
+	nodeVolumes func(ctx context.Context) (map[string]map[string]bool, error)
+	problems    int
+}
+
+func (d *doctor) ok(format string, a ...interface{}) {
+	fmt.Fprintf(d.out, "[OK]   "+format+"\n", a...)
+}
+
+func (d *doctor) warn(format string, a ...interface{}) {
+	fmt.Fprintf(d.out, "[WARN] "+format+"\n", a...)
+}
+
+func (d *doctor) fail(format string, a ...interface{}) {
+	d.problems++
+	fmt.Fprintf(d.out, "[FAIL] "+format+"\n", a...)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the carina installation and the consistency of its volumes",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+		d := &doctor{c: c, out: rootCmd.OutOrStdout(), nodeVolumes: nodeVolumes}
+		d.run(cmd.Context())
+		if d.problems > 0 {
+			return fmt.Errorf("%d problem(s) found", d.problems)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+func (d *doctor) run(ctx context.Context) {
+	lvs := new(carinav1.LogicVolumeList)
+	if err := d.c.List(ctx, lvs); err != nil {
+		if meta.IsNoMatchError(err) {
+			d.fail("LogicVolume CRD is not installed")
+		} else {
+			d.fail("list LogicVolumes: %v", err)
+		}
+		return
+	}
+	nsrs := new(carinav1beta1.NodeStorageResourceList)
+	if err := d.c.List(ctx, nsrs); err != nil {
+		if meta.IsNoMatchError(err) {
+			d.fail("NodeStorageResource CRD is not installed")
+		} else {
+			d.fail("list NodeStorageResources: %v", err)
+		}
+		return
+	}
+	d.ok("CRDs are installed")
+
+	d.checkComponents(ctx)
+	d.checkNodeResources(ctx, nsrs)
+	d.checkVolumes(ctx, lvs)
+}
+
+func (d *doctor) checkComponents(ctx context.Context) {
+	deploy := new(appsv1.Deployment)
+	if err := d.c.Get(ctx, client.ObjectKey{Namespace: config.carinaNamespace, Name: config.controllerName}, deploy); err != nil {
+		d.fail("get controller deployment %s/%s: %v", config.carinaNamespace, config.controllerName, err)
+	} else if deploy.Status.ReadyReplicas == 0 {
+		d.fail("controller deployment %s/%s has no ready replica", config.carinaNamespace, config.controllerName)
+	} else {
+		d.ok("controller deployment has %d ready replica(s)", deploy.Status.ReadyReplicas)
+	}
+
+	ds := new(appsv1.DaemonSet)
+	if err := d.c.Get(ctx, client.ObjectKey{Namespace: config.carinaNamespace, Name: config.nodeName}, ds); err != nil {
+		d.fail("get node daemonset %s/%s: %v", config.carinaNamespace, config.nodeName, err)
+	} else if ds.Status.NumberReady < ds.Status.DesiredNumberScheduled {
+		d.fail("node daemonset %s/%s has %d/%d pods ready", config.carinaNamespace, config.nodeName, ds.Status.NumberReady, ds.Status.DesiredNumberScheduled)
+	} else {
+		d.ok("node daemonset has %d/%d pods ready", ds.Status.NumberReady, ds.Status.DesiredNumberScheduled)
+	}
+}
+
+func (d *doctor) checkNodeResources(ctx context.Context, nsrs *carinav1beta1.NodeStorageResourceList) {
+	nsrMap := map[string]carinav1beta1.NodeStorageResource{}
+	for _, nsr := range nsrs.Items {
+		nsrMap[nsr.Spec.NodeName] = nsr
+	}
+
+	pods := new(corev1.PodList)
+	if err := d.c.List(ctx, pods, client.InNamespace(config.carinaNamespace), client.MatchingLabels{"app": config.nodeName}); err != nil {
+		d.fail("list carina node pods: %v", err)
+		return
+	}
+	healthy := true
+	for _, p := range pods.Items {
+		nsr, ok := nsrMap[p.Spec.NodeName]
+		if !ok {
+			healthy = false
+			d.fail("node %s runs carina but has no NodeStorageResource", p.Spec.NodeName)
+			continue
+		}
+		if time.Since(nsr.Status.SyncTime.Time) > staleSyncTime {
+			healthy = false
+			d.warn("NodeStorageResource of node %s was last synced at %s", p.Spec.NodeName, nsr.Status.SyncTime.Format(time.RFC3339))
+		}
+		if len(nsr.Status.Capacity) == 0 {
+			healthy = false
+			d.warn("node %s has no device group, check diskSelector", p.Spec.NodeName)
+		}
+	}
+	if healthy {
+		d.ok("%d node(s) report storage resources", len(pods.Items))
+	}
+}
+
+func (d *doctor) checkVolumes(ctx context.Context, lvs *carinav1.LogicVolumeList) {
+	nodes := new(corev1.NodeList)
+	if err := d.c.List(ctx, nodes); err != nil {
+		d.fail("list nodes: %v", err)
+		return
+	}
+	ready := map[string]bool{}
+	for _, n := range nodes.Items {
+		for _, cond := range n.Status.Conditions {
+			if cond.Type == corev1.NodeReady && cond.Status == corev1.ConditionTrue {
+				ready[n.Name] = true
+			}
+		}
+	}
+
+	pvs := new(corev1.PersistentVolumeList)
+	if err := d.c.List(ctx, pvs); err != nil {
+		d.fail("list persistent volumes: %v", err)
+		return
+	}
+	pvMap := map[string]bool{}
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == utils.CSIPluginName {
+			pvMap[pv.Name] = true
+		}
+	}
+
+	actual, err := d.nodeVolumes(ctx)
+	if err != nil {
+		d.warn("can not query volumes from carina nodes, skip lv consistency check: %v", err)
+	}
+
+	healthy := true
+	lvMap := map[string]bool{}
+	for _, lv := range lvs.Items {
+		lvMap[lv.Name] = true
+		if lv.Status.Status != "Success" {
+			healthy = false
+			d.warn("LogicVolume %s is %q: %s", lv.Name, lv.Status.Status, lv.Status.Message)
+		}
+		if !ready[lv.Spec.NodeName] {
+			healthy = false
+			d.warn("LogicVolume %s is on node %s which is not ready", lv.Name, lv.Spec.NodeName)
+		}
+		// bcache的缓存卷没有对应的pv
+		if len(lv.OwnerReferences) == 0 && lv.Status.Status != "" && !pvMap[lv.Name] {
+			healthy = false
+			d.warn("LogicVolume %s has no PersistentVolume, it may be orphaned", lv.Name)
+		}
+		if actual != nil && lv.Annotations[utils.VolumeManagerType] != utils.RawVolumeType && lv.Status.VolumeID != "" {
+			if nodeLvs, ok := actual[lv.Spec.NodeName]; ok && !nodeLvs[lv.Status.VolumeID] {
+				healthy = false
+				d.fail("LogicVolume %s has no lv %s on node %s", lv.Name, lv.Status.VolumeID, lv.Spec.NodeName)
+			}
+		}
+	}
+	for name := range pvMap {
+		if !lvMap[name] {
+			healthy = false
+			d.fail("PersistentVolume %s has no LogicVolume", name)
+		}
+	}
+	if healthy {
+		d.ok("%d LogicVolume(s) are consistent", len(lvs.Items))
+	}
+}
+
+// nodeVolumes 通过apiserver代理访问carina-controller聚合的节点卷信息
+func nodeVolumes(ctx context.Context) (map[string]map[string]bool, error) {
+	cfg, err := restConfig()
+	if err != nil {
+		return nil, err
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	body, err := cs.CoreV1().Services(config.carinaNamespace).ProxyGet("http", "carina-controller", "http", "/volume", nil).DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	volumes := map[string][]types.LvInfo{}
+	if err := json.Unmarshal(body, &volumes); err != nil {
+		return nil, err
+	}
+	result := map[string]map[string]bool{}
+	for node, lvs := range volumes {
+		result[node] = map[string]bool{}
+		for _, lv := range lvs {
+			result[node][lv.LVName] = true
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,189 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDoctor(t *testing.T) {
+	stale := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	capacity := map[string]resource.Quantity{"carina.storage.io/carina-vg-ssd": resource.MustParse("100")}
+
+	deployment := func(ready int32) client.Object {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "csi-carina-provisioner"},
+			Status:     appsv1.DeploymentStatus{ReadyReplicas: ready},
+		}
+	}
+	daemonSet := func(ready, desired int32) client.Object {
+		return &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "csi-carina-node"},
+			Status:     appsv1.DaemonSetStatus{NumberReady: ready, DesiredNumberScheduled: desired},
+		}
+	}
+	nodePod := func(node string) client.Object {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "csi-carina-node-" + node, Labels: map[string]string{"app": "csi-carina-node"}},
+			Spec:       corev1.PodSpec{NodeName: node},
+		}
+	}
+	nsr := func(node string, synced time.Time, capacity map[string]resource.Quantity) client.Object {
+		return &carinav1beta1.NodeStorageResource{
+			ObjectMeta: metav1.ObjectMeta{Name: node},
+			Spec:       carinav1beta1.NodeStorageResourceSpec{NodeName: node},
+			Status:     carinav1beta1.NodeStorageResourceStatus{SyncTime: metav1.NewTime(synced), Capacity: capacity},
+		}
+	}
+	node := func(name string, ready corev1.ConditionStatus) client.Object {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}}},
+		}
+	}
+	lv := func(name, node, status, volumeID, owner string) client.Object {
+		lv := &carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: utils.LogicVolumeNamespace},
+			Spec:       carinav1.LogicVolumeSpec{NodeName: node, DeviceGroup: "carina-vg-ssd", Size: resource.MustParse("1Gi")},
+			Status:     carinav1.LogicVolumeStatus{Status: status, VolumeID: volumeID},
+		}
+		if status == "Failed" {
+			lv.Status.Message = "no enough space"
+		}
+		if owner != "" {
+			lv.OwnerReferences = []metav1.OwnerReference{{APIVersion: carinav1.GroupVersion.String(), Kind: "LogicVolume", Name: owner}}
+		}
+		return lv
+	}
+	pv := func(name, driver string) client.Object {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: "volume-" + name},
+			}},
+		}
+	}
+
+	healthy := []client.Object{
+		deployment(1), daemonSet(2, 2), nodePod("node1"), nodePod("node2"),
+		nsr("node1", time.Now(), capacity), nsr("node2", time.Now(), capacity),
+		node("node1", corev1.ConditionTrue), node("node2", corev1.ConditionTrue),
+		lv("pvc-1", "node1", "Success", "volume-pvc-1", ""), pv("pvc-1", utils.CSIPluginName),
+	}
+	broken := []client.Object{
+		deployment(0), daemonSet(1, 2), nodePod("node1"), nodePod("node2"),
+		nsr("node1", stale, nil),
+		node("node1", corev1.ConditionTrue), node("node3", corev1.ConditionFalse),
+		lv("pvc-1", "node1", "Failed", "volume-pvc-1", ""), pv("pvc-1", utils.CSIPluginName),
+		lv("pvc-2", "node3", "Success", "volume-pvc-2", ""),
+		// bcache的缓存卷没有pv
+		lv("pvc-1-cache", "node1", "Success", "", "pvc-1"),
+		pv("pvc-9", utils.CSIPluginName), pv("pvc-other", "topolvm.io"),
+	}
+	volumes := func(ctx context.Context) (map[string]map[string]bool, error) {
+		return map[string]map[string]bool{"node1": {"volume-other": true}, "node3": {"volume-pvc-2": true}}, nil
+	}
+	table := []struct {
+		name        string
+		objects     []client.Object
+		nodeVolumes func(ctx context.Context) (map[string]map[string]bool, error)
+		problems    int
+		expect      []string
+	}{
+		{
+			name:    "healthy",
+			objects: healthy,
+			nodeVolumes: func(ctx context.Context) (map[string]map[string]bool, error) {
+				return map[string]map[string]bool{"node1": {"volume-pvc-1": true}}, nil
+			},
+			expect: []string{
+				"[OK]   CRDs are installed",
+				"[OK]   controller deployment has 1 ready replica(s)",
+				"[OK]   node daemonset has 2/2 pods ready",
+				"[OK]   2 node(s) report storage resources",
+				"[OK]   1 LogicVolume(s) are consistent",
+			},
+		},
+		{
+			name:    "node volumes unavailable",
+			objects: healthy,
+			nodeVolumes: func(ctx context.Context) (map[string]map[string]bool, error) {
+				return nil, errors.New("service unavailable")
+			},
+			expect: []string{
+				"[OK]   CRDs are installed",
+				"[OK]   controller deployment has 1 ready replica(s)",
+				"[OK]   node daemonset has 2/2 pods ready",
+				"[OK]   2 node(s) report storage resources",
+				"[WARN] can not query volumes from carina nodes, skip lv consistency check: service unavailable",
+				"[OK]   1 LogicVolume(s) are consistent",
+			},
+		},
+		{
+			name:        "broken",
+			objects:     broken,
+			nodeVolumes: volumes,
+			problems:    5,
+			expect: []string{
+				"[OK]   CRDs are installed",
+				"[FAIL] controller deployment kube-system/csi-carina-provisioner has no ready replica",
+				"[FAIL] node daemonset kube-system/csi-carina-node has 1/2 pods ready",
+				"[WARN] NodeStorageResource of node node1 was last synced at " + stale.Local().Format(time.RFC3339),
+				"[WARN] node node1 has no device group, check diskSelector",
+				"[FAIL] node node2 runs carina but has no NodeStorageResource",
+				"[WARN] LogicVolume pvc-1 is \"Failed\": no enough space",
+				"[FAIL] LogicVolume pvc-1 has no lv volume-pvc-1 on node node1",
+				"[WARN] LogicVolume pvc-2 is on node node3 which is not ready",
+				"[WARN] LogicVolume pvc-2 has no PersistentVolume, it may be orphaned",
+				"[FAIL] PersistentVolume pvc-9 has no LogicVolume",
+			},
+		},
+	}
+
+	for _, tc := range table {
+		out := new(bytes.Buffer)
+		d := &doctor{
+			c:           fake.NewClientBuilder().WithScheme(scheme).WithObjects(tc.objects...).Build(),
+			out:         out,
+			nodeVolumes: tc.nodeVolumes,
+		}
+		d.run(context.Background())
+		assert.Equal(t, tc.problems, d.problems, tc.name)
+		// 列表的顺序不固定，按行比较
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		sort.Strings(lines)
+		sort.Strings(tc.expect)
+		assert.Equal(t, tc.expect, lines, tc.name)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/volumeactivity"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+var idleOptions struct {
+	days          int
+	allNamespaces bool
+}
+
+var idleCmd = &cobra.Command{
+	Use:   "idle",
+	Short: "List PVCs whose volumes saw no I/O for a number of days",
+	Long: `List PVCs whose volumes saw no I/O for a number of days, the longest idle first.
+
+carina-node records the last I/O of every volume with an hourly granularity, a volume
+without any recorded I/O is idle since its creation.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return idleVolumes(cmd.Context())
+	},
+}
+
+func init() {
+	fs := idleCmd.Flags()
+	fs.IntVar(&idleOptions.days, "days", 30, "Minimum number of days without I/O")
+	fs.BoolVarP(&idleOptions.allNamespaces, "all-namespaces", "A", false, "List idle PVCs of all namespaces")
+	rootCmd.AddCommand(idleCmd)
+}
+
+func idleVolumes(ctx context.Context) error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	namespace := ""
+	if !idleOptions.allNamespaces {
+		if namespace, err = targetNamespace(); err != nil {
+			return err
+		}
+	}
+	lvs := new(carinav1.LogicVolumeList)
+	if err := c.List(ctx, lvs); err != nil {
+		return err
+	}
+
+	w := newTabWriter(rootCmd.OutOrStdout())
+	defer w.Flush()
+	fmt.Fprintln(w, "NAMESPACE\tPVC\tSIZE\tGROUP\tNODE\tLAST-ACTIVITY\tIDLE")
+	var total int64
+	now := time.Now()
+	for _, v := range volumeactivity.IdleVolumes(lvs.Items, time.Duration(idleOptions.days)*24*time.Hour, now) {
+		if namespace != "" && v.Namespace != namespace {
+			continue
+		}
+		total += v.SizeBytes
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", v.Namespace, v.Pvc,
+			resource.NewQuantity(v.SizeBytes, resource.BinarySI).String(), v.DeviceGroup, v.Node,
+			v.LastActivity.Format(time.RFC3339), duration.HumanDuration(v.Idle))
+	}
+	if total > 0 {
+		fmt.Fprintf(w, "\ntotal\t\t%s\n", resource.NewQuantity(total, resource.BinarySI).String())
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var lvOptions struct {
+	node     string
+	group    string
+	failures bool
+}
+
+var lvCmd = &cobra.Command{
+	Use:     "lv",
+	Aliases: []string{"logicvolume"},
+	Short:   "Inspect LogicVolumes",
+}
+
+var lvListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List LogicVolumes with the PVC and node they belong to",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+		return lvList(cmd.Context(), c, rootCmd.OutOrStdout())
+	},
+}
+
+func init() {
+	fs := lvListCmd.Flags()
+	fs.StringVar(&lvOptions.node, "node", "", "Only list volumes on the given node")
+	fs.StringVar(&lvOptions.group, "group", "", "Only list volumes in the given device group")
+	fs.BoolVar(&lvOptions.failures, "failed", false, "Only list volumes that are not in Success status")
+	lvCmd.AddCommand(lvListCmd)
+	rootCmd.AddCommand(lvCmd)
+}
+
+func lvList(ctx context.Context, c client.Reader, out io.Writer) error {
+	lvs := new(carinav1.LogicVolumeList)
+	if err := c.List(ctx, lvs); err != nil {
+		return err
+	}
+	sort.Slice(lvs.Items, func(i, j int) bool {
+		if lvs.Items[i].Spec.NodeName != lvs.Items[j].Spec.NodeName {
+			return lvs.Items[i].Spec.NodeName < lvs.Items[j].Spec.NodeName
+		}
+		return lvs.Items[i].Name < lvs.Items[j].Name
+	})
+
+	w := newTabWriter(out)
+	defer w.Flush()
+	fmt.Fprintln(w, "NAME\tTYPE\tSIZE\tGROUP\tNODE\tSTATUS\tPVC")
+	for _, lv := range lvs.Items {
+		if lvOptions.node != "" && lv.Spec.NodeName != lvOptions.node {
+			continue
+		}
+		if lvOptions.group != "" && lv.Spec.DeviceGroup != lvOptions.group {
+			continue
+		}
+		if lvOptions.failures && lv.Status.Status == "Success" {
+			continue
+		}
+		volumeType := lv.Annotations[utils.VolumeManagerType]
+		if volumeType == "" {
+			volumeType = utils.LvmVolumeType
+		}
+		pvc := "<none>"
+		if lv.Spec.Pvc != "" {
+			pvc = lv.Spec.NameSpace + "/" + lv.Spec.Pvc
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", lv.Name, volumeType, lv.Spec.Size.String(),
+			lv.Spec.DeviceGroup, lv.Spec.NodeName, lv.Status.Status, pvc)
+	}
+	return nil
+}
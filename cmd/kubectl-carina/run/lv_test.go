@@ -0,0 +1,78 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestLvList(t *testing.T) {
+	newLV := func(name, node, group, status, pvc string, raw bool) client.Object {
+		lv := &carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: utils.LogicVolumeNamespace},
+			Spec:       carinav1.LogicVolumeSpec{NodeName: node, DeviceGroup: group, Size: resource.MustParse("1Gi"), Pvc: pvc},
+			Status:     carinav1.LogicVolumeStatus{Status: status},
+		}
+		if pvc != "" {
+			lv.Spec.NameSpace = "db"
+		}
+		if raw {
+			lv.Annotations = map[string]string{utils.VolumeManagerType: utils.RawVolumeType}
+		}
+		return lv
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newLV("pvc-3", "node2", "carina-vg-ssd", "Success", "data-mysql-1", false),
+		newLV("pvc-1", "node1", "carina-vg-ssd", "Success", "data-mysql-0", false),
+		newLV("pvc-2", "node1", "carina-raw-hdd", "Failed", "", true),
+	).Build()
+
+	header := []string{"NAME", "TYPE", "SIZE", "GROUP", "NODE", "STATUS", "PVC"}
+	pvc1 := []string{"pvc-1", "lvm", "1Gi", "carina-vg-ssd", "node1", "Success", "db/data-mysql-0"}
+	pvc2 := []string{"pvc-2", "raw", "1Gi", "carina-raw-hdd", "node1", "Failed", "<none>"}
+	pvc3 := []string{"pvc-3", "lvm", "1Gi", "carina-vg-ssd", "node2", "Success", "db/data-mysql-1"}
+	table := []struct {
+		node     string
+		group    string
+		failures bool
+		expect   [][]string
+	}{
+		{expect: [][]string{header, pvc1, pvc2, pvc3}},
+		{node: "node1", expect: [][]string{header, pvc1, pvc2}},
+		{group: "carina-vg-ssd", expect: [][]string{header, pvc1, pvc3}},
+		{node: "node2", group: "carina-raw-hdd", expect: [][]string{header}},
+		{failures: true, expect: [][]string{header, pvc2}},
+	}
+
+	for _, tc := range table {
+		lvOptions.node, lvOptions.group, lvOptions.failures = tc.node, tc.group, tc.failures
+		out := new(bytes.Buffer)
+		assert.NoError(t, lvList(context.Background(), c, out))
+		assert.Equal(t, tc.expect, rows(out.String()), "%+v", tc)
+	}
+	lvOptions.node, lvOptions.group, lvOptions.failures = "", "", false
+}
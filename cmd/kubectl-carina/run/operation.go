@@ -0,0 +1,148 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var operationOptions struct {
+	yes              bool
+	wait             bool
+	timeout          time.Duration
+	wipePolicy       string
+	claim            string
+	storageClassName string
+}
+
+var forceDeleteCmd = &cobra.Command{
+	Use:   "force-delete <logicvolume>",
+	Short: "Delete a LogicVolume and its unbound PV, even if its node is gone",
+	Long: `Delete a LogicVolume and its unbound PV through a VolumeOperation.
+
+If the node of the volume is not ready, the finalizer of the LogicVolume is
+removed and the volume is left on the disks of the node.
+Needs the "force-delete" verb on the logicvolume.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runOperation(cmd.Context(), carinav1.VolumeOperationSpec{
+			Operation:   carinav1.VolumeOperationForceDelete,
+			LogicVolume: args[0],
+		})
+	},
+}
+
+var wipeCmd = &cobra.Command{
+	Use:   "wipe <logicvolume>",
+	Short: "Erase the data of a LogicVolume, then delete it and its unbound PV",
+	Long: `Erase the data of a LogicVolume on its node, then delete it and its unbound PV
+through a VolumeOperation. Needs the "wipe" verb on the logicvolume.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runOperation(cmd.Context(), carinav1.VolumeOperationSpec{
+			Operation:   carinav1.VolumeOperationWipe,
+			LogicVolume: args[0],
+			WipePolicy:  operationOptions.wipePolicy,
+		})
+	},
+}
+
+var adoptCmd = &cobra.Command{
+	Use:   "adopt <logicvolume> --claim <namespace>/<pvc>",
+	Short: "Create a PV for a LogicVolume that has none, pre-bound to a PVC",
+	Long: `Create a PV with Retain policy for a LogicVolume whose PV was lost, so that its
+data can be used again by the given PVC. Needs the "adopt" verb on the logicvolume.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		parts := strings.Split(operationOptions.claim, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("--claim must be <namespace>/<pvc>, got %q", operationOptions.claim)
+		}
+		return runOperation(cmd.Context(), carinav1.VolumeOperationSpec{
+			Operation:        carinav1.VolumeOperationAdopt,
+			LogicVolume:      args[0],
+			ClaimRef:         &carinav1.VolumeClaimReference{Namespace: parts[0], Name: parts[1]},
+			StorageClassName: operationOptions.storageClassName,
+		})
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{forceDeleteCmd, wipeCmd, adoptCmd} {
+		fs := cmd.Flags()
+		fs.BoolVar(&operationOptions.wait, "wait", true, "Wait for the operation to finish")
+		fs.DurationVar(&operationOptions.timeout, "timeout", 5*time.Minute, "How long to wait for the operation")
+		rootCmd.AddCommand(cmd)
+	}
+	forceDeleteCmd.Flags().BoolVar(&operationOptions.yes, "yes", false, "Confirm that the volume is deleted")
+	wipeCmd.Flags().BoolVar(&operationOptions.yes, "yes", false, "Confirm that the data of the volume is erased")
+	wipeCmd.Flags().StringVar(&operationOptions.wipePolicy, "policy", "zero", "How the data is erased, discard or zero")
+	adoptCmd.Flags().StringVar(&operationOptions.claim, "claim", "", "PVC the volume is bound to, <namespace>/<pvc>")
+	adoptCmd.Flags().StringVar(&operationOptions.storageClassName, "storage-class", "", "Storage class of the PV, has to match the PVC")
+	_ = adoptCmd.MarkFlagRequired("claim")
+}
+
+// runOperation 创建VolumeOperation并等待carina-controller执行完成
+func runOperation(ctx context.Context, spec carinav1.VolumeOperationSpec) error {
+	if spec.Operation != carinav1.VolumeOperationAdopt && !operationOptions.yes {
+		return errors.New("the operation can not be undone, pass --yes to continue")
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	out := rootCmd.OutOrStdout()
+
+	op := &carinav1.VolumeOperation{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("%s-%s-", spec.LogicVolume, strings.ToLower(string(spec.Operation))),
+		},
+		Spec: spec,
+	}
+	if err := c.Create(ctx, op); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "volumeoperation %s created\n", op.Name)
+	if !operationOptions.wait {
+		return nil
+	}
+
+	err = wait.PollImmediate(2*time.Second, operationOptions.timeout, func() (bool, error) {
+		if err := c.Get(ctx, client.ObjectKey{Name: op.Name}, op); err != nil {
+			return false, err
+		}
+		return op.Status.Phase == carinav1.VolumeOperationSucceeded || op.Status.Phase == carinav1.VolumeOperationFailed, nil
+	})
+	if err != nil {
+		return fmt.Errorf("wait for volumeoperation %s: %v", op.Name, err)
+	}
+	if op.Status.Phase == carinav1.VolumeOperationFailed {
+		return fmt.Errorf("volumeoperation %s failed: %s", op.Name, op.Status.Message)
+	}
+	fmt.Fprintf(out, "volumeoperation %s succeeded: %s\n", op.Name, op.Status.Message)
+	return nil
+}
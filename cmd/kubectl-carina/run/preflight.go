@@ -0,0 +1,57 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"fmt"
+
+	"github.com/carina-io/carina/pkg/preflight"
+	"github.com/spf13/cobra"
+)
+
+var preflightConfigMap string
+
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Check that carina can be upgraded",
+	Long: `preflight validates the installed CRD versions, orphaned and stuck objects, deprecated keys of the
+carina config and the versions of the running carina components, and prints a pass/fail report.
+Run it after applying the CRDs of the new release and before upgrading the controller and node daemons.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+		report := preflight.Run(cmd.Context(), c, preflight.Options{
+			Namespace:      config.carinaNamespace,
+			ControllerName: config.controllerName,
+			NodeName:       config.nodeName,
+			ConfigMap:      preflightConfigMap,
+		})
+		report.Print(rootCmd.OutOrStdout())
+		if report.Failed() {
+			return fmt.Errorf("preflight failed")
+		}
+		return nil
+	},
+}
+
+func init() {
+	preflightCmd.Flags().StringVar(&preflightConfigMap, "config-map", "carina-csi-config", "Name of the configmap holding config.json")
+	rootCmd.AddCommand(preflightCmd)
+}
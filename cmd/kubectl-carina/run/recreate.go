@@ -0,0 +1,184 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/utils"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var recreateOptions struct {
+	to          string
+	deletePods  bool
+	discardData bool
+	timeout     time.Duration
+}
+
+var recreateCmd = &cobra.Command{
+	Use:   "recreate <pvc> --to <node> --discard-data",
+	Short: "Recreate a PVC empty on another node",
+	Long: `Delete a carina PVC and create it again so that a new, empty volume is
+provisioned on another node.
+
+Carina volumes are local, the data of the current volume is NOT copied and is
+lost with the old volume, --discard-data confirms that.
+This is the same rebuild carina does for pods annotated with
+carina.stroage.io/allow-pod-migration-if-node-notready, triggered by hand.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		namespace, err := targetNamespace()
+		if err != nil {
+			return err
+		}
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+		return recreate(cmd.Context(), c, rootCmd.OutOrStdout(), namespace, args[0])
+	},
+}
+
+func init() {
+	fs := recreateCmd.Flags()
+	fs.StringVar(&recreateOptions.to, "to", "", "Node to provision the new volume on")
+	fs.BoolVar(&recreateOptions.deletePods, "delete-pods", false, "Delete pods using the PVC so that it can be removed")
+	fs.BoolVar(&recreateOptions.discardData, "discard-data", false, "Confirm that the data of the current volume is discarded")
+	fs.DurationVar(&recreateOptions.timeout, "timeout", 5*time.Minute, "How long to wait for the old PVC to be removed")
+	_ = recreateCmd.MarkFlagRequired("to")
+	rootCmd.AddCommand(recreateCmd)
+}
+
+func recreate(ctx context.Context, c client.Client, out io.Writer, namespace, name string) error {
+	pvc := new(corev1.PersistentVolumeClaim)
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, pvc); err != nil {
+		return err
+	}
+	if pvc.Spec.StorageClassName == nil {
+		return fmt.Errorf("pvc %s/%s has no storage class", namespace, name)
+	}
+	sc := new(storagev1.StorageClass)
+	if err := c.Get(ctx, client.ObjectKey{Name: *pvc.Spec.StorageClassName}, sc); err != nil {
+		return err
+	}
+	if sc.Provisioner != utils.CSIPluginName {
+		return fmt.Errorf("pvc %s/%s is not provisioned by %s", namespace, name, utils.CSIPluginName)
+	}
+
+	if pvc.Spec.VolumeName != "" {
+		pv := new(corev1.PersistentVolume)
+		if err := c.Get(ctx, client.ObjectKey{Name: pvc.Spec.VolumeName}, pv); err == nil && pv.Spec.CSI != nil {
+			if pv.Spec.CSI.VolumeAttributes[utils.VolumeDeviceNode] == recreateOptions.to {
+				return fmt.Errorf("pvc %s/%s is already on node %s", namespace, name, recreateOptions.to)
+			}
+		}
+	}
+
+	nsr := new(carinav1beta1.NodeStorageResource)
+	if err := c.Get(ctx, client.ObjectKey{Name: recreateOptions.to}, nsr); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("node %s has no carina storage", recreateOptions.to)
+		}
+		return err
+	}
+
+	pods, err := podsUsingPvc(ctx, c, namespace, name)
+	if err != nil {
+		return err
+	}
+	if len(pods) > 0 && !recreateOptions.deletePods {
+		return fmt.Errorf("pvc %s/%s is used by %d pod(s), stop them or pass --delete-pods", namespace, name, len(pods))
+	}
+	if !recreateOptions.discardData {
+		return fmt.Errorf("the data of pvc %s/%s is not copied to node %s, pass --discard-data to recreate it empty", namespace, name, recreateOptions.to)
+	}
+
+	for _, p := range pods {
+		fmt.Fprintf(out, "deleting pod %s/%s\n", p.Namespace, p.Name)
+		if err := c.Delete(ctx, &p); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	fmt.Fprintf(out, "deleting pvc %s/%s\n", namespace, name)
+	if err := c.Delete(ctx, pvc); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	err = wait.PollImmediate(2*time.Second, recreateOptions.timeout, func() (bool, error) {
+		err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, new(corev1.PersistentVolumeClaim))
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return fmt.Errorf("wait for pvc %s/%s to be removed: %v", namespace, name, err)
+	}
+
+	newPvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      pvc.Labels,
+			Annotations: map[string]string{utils.AnnSelectedNode: recreateOptions.to},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      pvc.Spec.AccessModes,
+			Selector:         pvc.Spec.Selector,
+			Resources:        pvc.Spec.Resources,
+			StorageClassName: pvc.Spec.StorageClassName,
+			VolumeMode:       pvc.Spec.VolumeMode,
+			DataSource:       pvc.Spec.DataSource,
+		},
+	}
+	if err := c.Create(ctx, newPvc); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "pvc %s/%s recreated empty on node %s\n", namespace, name, recreateOptions.to)
+	return nil
+}
+
+func podsUsingPvc(ctx context.Context, c client.Client, namespace, name string) ([]corev1.Pod, error) {
+	podList := new(corev1.PodList)
+	if err := c.List(ctx, podList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	pods := []corev1.Pod{}
+	for _, p := range podList.Items {
+		if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, v := range p.Spec.Volumes {
+			if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == name {
+				pods = append(pods, p)
+				break
+			}
+		}
+	}
+	return pods, nil
+}
@@ -0,0 +1,124 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRecreate(t *testing.T) {
+	ctx := context.Background()
+	objects := func(storageClass, node string) []client.Object {
+		return []client.Object{
+			&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "csi-carina-sc"}, Provisioner: utils.CSIPluginName},
+			&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "local-path"}, Provisioner: "rancher.io/local-path"},
+			&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "data-mysql-0", Labels: map[string]string{"app": "mysql"}},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					Resources:        corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}},
+					StorageClassName: &storageClass,
+					VolumeName:       "pvc-1",
+				},
+			},
+			&corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+				Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+					Driver: utils.CSIPluginName, VolumeHandle: "volume-pvc-1", VolumeAttributes: map[string]string{utils.VolumeDeviceNode: node},
+				}}},
+			},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "mysql-0"},
+				Spec: corev1.PodSpec{Volumes: []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-mysql-0"},
+				}}}},
+			},
+			// 已结束的pod不妨碍删除pvc
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "mysql-backup"},
+				Spec: corev1.PodSpec{Volumes: []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-mysql-0"},
+				}}}},
+				Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+			},
+			&carinav1beta1.NodeStorageResource{ObjectMeta: metav1.ObjectMeta{Name: "node2"}, Spec: carinav1beta1.NodeStorageResourceSpec{NodeName: "node2"}},
+		}
+	}
+
+	table := []struct {
+		name         string
+		storageClass string
+		node         string
+		to           string
+		deletePods   bool
+		discardData  bool
+		err          string
+	}{
+		{name: "other provisioner", storageClass: "local-path", node: "node1", to: "node2", deletePods: true, discardData: true,
+			err: "pvc db/data-mysql-0 is not provisioned by carina.storage.io"},
+		{name: "same node", storageClass: "csi-carina-sc", node: "node2", to: "node2", deletePods: true, discardData: true,
+			err: "pvc db/data-mysql-0 is already on node node2"},
+		{name: "node without storage", storageClass: "csi-carina-sc", node: "node1", to: "node3", deletePods: true, discardData: true,
+			err: "node node3 has no carina storage"},
+		{name: "pods running", storageClass: "csi-carina-sc", node: "node1", to: "node2", discardData: true,
+			err: "pvc db/data-mysql-0 is used by 1 pod(s), stop them or pass --delete-pods"},
+		{name: "data not discarded", storageClass: "csi-carina-sc", node: "node1", to: "node2", deletePods: true,
+			err: "the data of pvc db/data-mysql-0 is not copied to node node2, pass --discard-data to recreate it empty"},
+		{name: "recreated", storageClass: "csi-carina-sc", node: "node1", to: "node2", deletePods: true, discardData: true},
+	}
+
+	for _, tc := range table {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects(tc.storageClass, tc.node)...).Build()
+		recreateOptions.to, recreateOptions.deletePods, recreateOptions.discardData = tc.to, tc.deletePods, tc.discardData
+		out := new(bytes.Buffer)
+		err := recreate(ctx, c, out, "db", "data-mysql-0")
+
+		pvc := new(corev1.PersistentVolumeClaim)
+		assert.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "db", Name: "data-mysql-0"}, pvc), tc.name)
+		podErr := c.Get(ctx, client.ObjectKey{Namespace: "db", Name: "mysql-0"}, new(corev1.Pod))
+		if tc.err != "" {
+			// 拒绝时不删除任何对象
+			assert.EqualError(t, err, tc.err, tc.name)
+			assert.Equal(t, "pvc-1", pvc.Spec.VolumeName, tc.name)
+			assert.NoError(t, podErr, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.True(t, apierrors.IsNotFound(podErr), tc.name)
+		assert.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "db", Name: "mysql-backup"}, new(corev1.Pod)), tc.name)
+		assert.Empty(t, pvc.Spec.VolumeName, tc.name)
+		assert.Equal(t, map[string]string{utils.AnnSelectedNode: "node2"}, pvc.Annotations, tc.name)
+		assert.Equal(t, map[string]string{"app": "mysql"}, pvc.Labels, tc.name)
+		assert.Equal(t, "csi-carina-sc", *pvc.Spec.StorageClassName, tc.name)
+		assert.Equal(t, resource.MustParse("10Gi"), pvc.Spec.Resources.Requests[corev1.ResourceStorage], tc.name)
+		assert.Equal(t, "deleting pod db/mysql-0\ndeleting pvc db/data-mysql-0\npvc db/data-mysql-0 recreated empty on node node2\n", out.String(), tc.name)
+	}
+	recreateOptions.to, recreateOptions.deletePods, recreateOptions.discardData = "", false, false
+}
@@ -0,0 +1,121 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/utils"
+	"github.com/spf13/cobra"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(carinav1.AddToScheme(scheme))
+	utilruntime.Must(carinav1beta1.AddToScheme(scheme))
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextv1.AddToScheme(scheme))
+}
+
+var config struct {
+	kubeconfig      string
+	context         string
+	namespace       string
+	carinaNamespace string
+	controllerName  string
+	nodeName        string
+	as              string
+	asGroups        []string
+}
+
+var rootCmd = &cobra.Command{
+	Use:     "kubectl-carina",
+	Version: utils.Version,
+	Short:   "Day-2 operations for carina",
+	Long: `kubectl-carina is a kubectl plugin wrapping the carina CRDs and node APIs.
+
+Install it anywhere in PATH and call it as "kubectl carina <command>".`,
+	SilenceUsage: true,
+}
+
+// Execute adds all child commands to the root command and sets flags appropriately.
+// This is called by main.main(). It only needs to happen once to the rootCmd.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	fs := rootCmd.PersistentFlags()
+	fs.StringVar(&config.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file")
+	fs.StringVar(&config.context, "context", "", "The name of the kubeconfig context to use")
+	fs.StringVarP(&config.namespace, "namespace", "n", "", "Namespace of the PVC, defaults to the kubeconfig namespace")
+	fs.StringVar(&config.carinaNamespace, "carina-namespace", "kube-system", "Namespace carina is installed in")
+	fs.StringVar(&config.controllerName, "controller-name", "csi-carina-provisioner", "Name of the carina controller deployment")
+	fs.StringVar(&config.nodeName, "node-name", "csi-carina-node", "Name of the carina node daemonset")
+	fs.StringVar(&config.as, "as", "", "Username to impersonate for the operation")
+	fs.StringArrayVar(&config.asGroups, "as-group", []string{}, "Group to impersonate for the operation, can be repeated")
+}
+
+func clientConfig() clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = config.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: config.context}
+	overrides.AuthInfo.Impersonate = config.as
+	overrides.AuthInfo.ImpersonateGroups = config.asGroups
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+}
+
+func restConfig() (*rest.Config, error) {
+	return clientConfig().ClientConfig()
+}
+
+func newClient() (client.Client, error) {
+	cfg, err := restConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(cfg, client.Options{Scheme: scheme})
+}
+
+// targetNamespace 返回PVC所在的命名空间
+func targetNamespace() (string, error) {
+	if config.namespace != "" {
+		return config.namespace, nil
+	}
+	ns, _, err := clientConfig().Namespace()
+	return ns, err
+}
+
+func newTabWriter(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+}
@@ -0,0 +1,121 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/simulate"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var simulateOpts struct {
+	group      string
+	count      string
+	strategy   string
+	onePerNode bool
+	configMap  string
+}
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate <size>",
+	Short: "Show where PVCs of a size would land and the capacity left afterwards",
+	Long: `simulate places count PVCs of the size one after another the way carina-scheduler checks and scores the
+capacity of the nodes, and prints the node and device group of each PVC and the capacity remaining afterwards.
+Nothing is created. Without --strategy the schedulerStrategy of the carina config is used.
+
+  kubectl carina simulate 100Gi --group carina-vg-ssd --count 3 --one-per-node`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSimulate(cmd.Context(), args[0])
+	},
+}
+
+func init() {
+	simulateCmd.Flags().StringVar(&simulateOpts.group, "group", "", "Device group of the storage class, any volume group if empty")
+	simulateCmd.Flags().StringVar(&simulateOpts.count, "count", "1", "Number of PVCs, e.g. the replicas added to a StatefulSet")
+	simulateCmd.Flags().StringVar(&simulateOpts.strategy, "strategy", "", "binpack or spreadout")
+	simulateCmd.Flags().BoolVar(&simulateOpts.onePerNode, "one-per-node", false, "Place at most one PVC on a node, like pods with anti-affinity")
+	simulateCmd.Flags().StringVar(&simulateOpts.configMap, "config-map", "carina-csi-config", "Name of the configmap holding config.json")
+	rootCmd.AddCommand(simulateCmd)
+}
+
+func runSimulate(ctx context.Context, size string) error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	req, err := simulate.ParseRequest(size, simulateOpts.group, simulateOpts.count, simulateOpts.strategy, simulateOpts.onePerNode, schedulerStrategy(ctx, c))
+	if err != nil {
+		return err
+	}
+	nsrs := new(carinav1beta1.NodeStorageResourceList)
+	if err := c.List(ctx, nsrs); err != nil {
+		return err
+	}
+	nodes := new(corev1.NodeList)
+	if err := c.List(ctx, nodes); err != nil {
+		return err
+	}
+	result := simulate.Simulate(req, nsrs.Items, simulate.UnschedulableNodes(nodes.Items))
+
+	w := newTabWriter(rootCmd.OutOrStdout())
+	fmt.Fprintln(w, "PVC\tNODE\tGROUP\tDISK")
+	for _, p := range result.Placements {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", p.Index, p.Node, p.DeviceGroup, orNone(p.Disk))
+	}
+	for i := len(result.Placements); i < len(result.Placements)+result.Unplaced; i++ {
+		fmt.Fprintf(w, "%d\t<unschedulable>\t\t\n", i)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "NODE\tGROUP\tDISK\tALLOCATABLE\tREMAINING\tNEW PVCS")
+	for _, g := range result.Capacity {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%dGi\t%dGi\t%d\n", g.Node, g.DeviceGroup, orNone(g.Disk), g.AllocatableGb, g.RemainingGb, g.Volumes)
+	}
+	w.Flush()
+	if result.Unplaced > 0 {
+		return fmt.Errorf("%d of %d PVCs do not fit with strategy %s", result.Unplaced, len(result.Placements)+result.Unplaced, req.Strategy)
+	}
+	return nil
+}
+
+// schedulerStrategy 读取carina配置中的调度策略，读不到时与调度器一样使用spreadout
+func schedulerStrategy(ctx context.Context, c client.Client) string {
+	cm := new(corev1.ConfigMap)
+	if err := c.Get(ctx, client.ObjectKey{Namespace: config.carinaNamespace, Name: simulateOpts.configMap}, cm); err != nil {
+		return simulate.StrategySpreadout
+	}
+	cfg := struct {
+		SchedulerStrategy string `json:"schedulerStrategy"`
+	}{}
+	if err := json.Unmarshal([]byte(cm.Data["config.json"]), &cfg); err != nil || cfg.SchedulerStrategy == "" {
+		return simulate.StrategySpreadout
+	}
+	return cfg.SchedulerStrategy
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
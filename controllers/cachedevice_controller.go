@@ -0,0 +1,156 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// CacheDeviceReconciler 记录bcache卷的缓存盘与后端盘
+// The node records the disks holding every LogicVolume in its status, for a bcache
+// volume they are copied to the pv as cache-devices and backing-devices annotations
+// and exported as carina_volume_device_info so that a failed ssd maps to its pvcs.
+type CacheDeviceReconciler struct {
+	client.Client
+
+	deviceInfo *prometheus.GaugeVec
+	mu         sync.Mutex
+	// exported 每个pv已导出的指标标签，pv删除时清理
+	exported map[string][]prometheus.Labels
+}
+
+// +kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch
+
+func (r *CacheDeviceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	pv := &corev1.PersistentVolume{}
+	if err := r.Get(ctx, req.NamespacedName, pv); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.exportDevices(req.Name, nil)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if !cachedVolume(pv) || pv.DeletionTimestamp != nil {
+		r.exportDevices(pv.Name, nil)
+		return ctrl.Result{}, nil
+	}
+
+	lvs, err := volumeLogicVolumes(ctx, r.Client, pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// LogicVolume还未同步到缓存时稍后再试
+	if len(lvs) == 0 {
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	var cache, backing []string
+	node := ""
+	for _, lv := range lvs {
+		switch lv.Status.VolumeID {
+		case pv.Spec.CSI.VolumeHandle:
+			backing = lv.Status.Devices
+			node = lv.Spec.NodeName
+		case pv.Spec.CSI.VolumeAttributes[utils.VolumeCacheId]:
+			cache = lv.Status.Devices
+		}
+	}
+
+	labels := []prometheus.Labels{}
+	for role, devices := range map[string][]string{"cache": cache, "backing": backing} {
+		for _, device := range devices {
+			l := prometheus.Labels{"node": node, "pv": pv.Name, "namespace": "", "pvc": "", "role": role, "device": device}
+			if pv.Spec.ClaimRef != nil {
+				l["namespace"] = pv.Spec.ClaimRef.Namespace
+				l["pvc"] = pv.Spec.ClaimRef.Name
+			}
+			labels = append(labels, l)
+		}
+	}
+	r.exportDevices(pv.Name, labels)
+
+	annotations, changed := utils.CacheDeviceAnnotations(pv.Annotations, cache, backing)
+	if !changed {
+		return ctrl.Result{}, nil
+	}
+	pv.Annotations = annotations
+	if err := r.Update(ctx, pv); err != nil {
+		return ctrl.Result{}, err
+	}
+	log.Infof("annotate pv %s with cache devices %v and backing devices %v", pv.Name, cache, backing)
+	return ctrl.Result{}, nil
+}
+
+// exportDevices 替换pv的指标，labels为空时删除
+func (r *CacheDeviceReconciler) exportDevices(pvName string, labels []prometheus.Labels) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, l := range r.exported[pvName] {
+		r.deviceInfo.Delete(l)
+	}
+	delete(r.exported, pvName)
+	for _, l := range labels {
+		r.deviceInfo.With(l).Set(1)
+	}
+	if len(labels) > 0 {
+		r.exported[pvName] = labels
+	}
+}
+
+func cachedVolume(pv *corev1.PersistentVolume) bool {
+	return carinaVolume(pv) && pv.Spec.CSI.VolumeAttributes[utils.VolumeCacheDiskType] != ""
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *CacheDeviceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.exported = map[string][]prometheus.Labels{}
+	r.deviceInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "carina",
+		Subsystem: "volume",
+		Name:      "device_info",
+		Help:      "Disks holding the cache and the backing volume of a bcache volume, the value is always 1",
+	}, []string{"node", "pv", "namespace", "pvc", "role", "device"})
+	if err := metrics.Registry.Register(r.deviceInfo); err != nil {
+		return err
+	}
+
+	pred := predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return cachedVolume(e.Object.(*corev1.PersistentVolume)) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return cachedVolume(e.Object.(*corev1.PersistentVolume)) },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return cachedVolume(e.ObjectNew.(*corev1.PersistentVolume)) },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("cachedevice").
+		WithEventFilter(pred).
+		For(&corev1.PersistentVolume{}).
+		Complete(r)
+}
@@ -0,0 +1,229 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/capacityforecast"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// CapacityForecaster 预测各节点磁盘组写满的时间
+// Every SampleInterval it records the used bytes of the volume groups reported in the
+// NodeStorageResources, fits the growth over the last week and exports the days until
+// each group is full. Groups full within capacityForecastDays set the CapacityExhaustion
+// condition of their NodeStorageResource. The history is kept in memory, a new leader
+// needs MinSpan of samples before it forecasts.
+type CapacityForecaster struct {
+	client.Client
+
+	history       *capacityforecast.History
+	daysUntilFull *prometheus.GaugeVec
+	growth        *prometheus.GaugeVec
+	// exported 已导出的node/group，磁盘组消失时清理
+	exported map[string]prometheus.Labels
+}
+
+var _ manager.LeaderElectionRunnable = &CapacityForecaster{}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=nodestorageresources,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=nodestorageresources/status,verbs=get;update;patch
+
+// SetupWithManager registers the metrics and adds the forecaster to the manager
+func (r *CapacityForecaster) SetupWithManager(mgr ctrl.Manager) error {
+	r.history = capacityforecast.NewHistory()
+	r.exported = map[string]prometheus.Labels{}
+	r.daysUntilFull = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "carina",
+		Subsystem: "devicegroup",
+		Name:      "days_until_full",
+		Help:      "Days until the volume group is full at the growth of the last week, absent while it is not growing",
+	}, []string{"node", "devicegroup"})
+	r.growth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "carina",
+		Subsystem: "devicegroup",
+		Name:      "growth_bytes_per_day",
+		Help:      "Growth of the used bytes of the volume group over the last week",
+	}, []string{"node", "devicegroup"})
+	for _, c := range []prometheus.Collector{r.daysUntilFull, r.growth} {
+		if err := metrics.Registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return mgr.Add(r)
+}
+
+// Start implements controller-runtime's manager.Runnable.
+func (r *CapacityForecaster) Start(ctx context.Context) error {
+	ticker := time.NewTicker(capacityforecast.SampleInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.forecast(ctx, time.Now()); err != nil {
+			log.Warnf("capacity forecast failed: %s", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (r *CapacityForecaster) NeedLeaderElection() bool {
+	return true
+}
+
+func (r *CapacityForecaster) forecast(ctx context.Context, now time.Time) error {
+	nsrList := &carinav1beta1.NodeStorageResourceList{}
+	if err := r.List(ctx, nsrList); err != nil {
+		return err
+	}
+	horizon := configuration.CapacityForecastDays()
+	seen := map[string]bool{}
+	for i := range nsrList.Items {
+		nsr := &nsrList.Items[i]
+		full := []string{}
+		forecasted := false
+		for _, vg := range nsr.Status.VgGroups {
+			if vg.VGSize == 0 {
+				continue
+			}
+			key := nsr.Spec.NodeName + "/" + vg.VGName
+			seen[key] = true
+			r.history.Add(key, capacityforecast.Sample{Time: now, Used: int64(vg.VGSize - vg.VGFree), Capacity: int64(vg.VGSize)})
+			f, ok := r.history.Forecast(key)
+			if !ok {
+				continue
+			}
+			forecasted = true
+			days, filling := f.DaysUntilFull(now)
+			r.export(key, prometheus.Labels{"node": nsr.Spec.NodeName, "devicegroup": vg.VGName}, f, days, filling)
+			if filling && horizon > 0 && days <= float64(horizon) {
+				full = append(full, forecastMessage(vg.VGName, f, days))
+			}
+		}
+		// 历史不足时不修改condition，避免controller切换后告警闪断
+		if !forecasted && horizon > 0 {
+			continue
+		}
+		if err := r.updateCondition(ctx, nsr, full, horizon); err != nil {
+			log.Warnf("update capacity condition of nodestorageresource %s failed: %s", nsr.Name, err.Error())
+		}
+	}
+
+	r.history.Retain(seen)
+	for key := range r.exported {
+		if !seen[key] {
+			r.export(key, nil, capacityforecast.Forecast{}, 0, false)
+		}
+	}
+	return nil
+}
+
+// export 替换磁盘组的指标，labels为空时删除
+func (r *CapacityForecaster) export(key string, labels prometheus.Labels, f capacityforecast.Forecast, days float64, filling bool) {
+	if old, ok := r.exported[key]; ok {
+		r.daysUntilFull.Delete(old)
+		r.growth.Delete(old)
+		delete(r.exported, key)
+	}
+	if labels == nil {
+		return
+	}
+	r.growth.With(labels).Set(f.Growth)
+	if filling {
+		r.daysUntilFull.With(labels).Set(days)
+	}
+	r.exported[key] = labels
+}
+
+func (r *CapacityForecaster) updateCondition(ctx context.Context, nsr *carinav1beta1.NodeStorageResource, full []string, horizon int) error {
+	conditions := append([]metav1.Condition{}, nsr.Status.Conditions...)
+	if horizon == 0 {
+		meta.RemoveStatusCondition(&conditions, utils.ConditionCapacityExhaustion)
+	} else {
+		meta.SetStatusCondition(&conditions, capacityCondition(full, horizon))
+	}
+	// carina-node只在自身状态变化时更新Healthy，这里同步汇总
+	status := nsr.Status.DeepCopy()
+	status.Conditions = conditions
+	needUpdateHealthStatus(status)
+	conditions = status.Conditions
+	if equality.Semantic.DeepEqual(conditions, nsr.Status.Conditions) {
+		return nil
+	}
+	if len(full) > 0 {
+		log.Warnf("node %s: %s", nsr.Spec.NodeName, strings.Join(full, "; "))
+	}
+	nsr2 := nsr.DeepCopy()
+	nsr2.Status.Conditions = conditions
+	return r.Status().Patch(ctx, nsr2, client.MergeFromWithOptions(nsr, client.MergeFromWithOptimisticLock{}))
+}
+
+func capacityCondition(full []string, horizon int) metav1.Condition {
+	if len(full) == 0 {
+		return metav1.Condition{
+			Type:    utils.ConditionCapacityExhaustion,
+			Status:  metav1.ConditionFalse,
+			Reason:  "NotFillingUp",
+			Message: fmt.Sprintf("no volume group is forecast to be full within %d days", horizon),
+		}
+	}
+	sort.Strings(full)
+	return metav1.Condition{
+		Type:    utils.ConditionCapacityExhaustion,
+		Status:  metav1.ConditionTrue,
+		Reason:  "FillingUp",
+		Message: strings.Join(full, "; "),
+	}
+}
+
+// forecastMessage e.g. carina-vg-hdd full in ~6 days, growing 10Gi/day with 60Gi free
+// The days are rounded so that the message, and with it the condition, only changes once a day.
+func forecastMessage(group string, f capacityforecast.Forecast, days float64) string {
+	free := f.Capacity - f.Used
+	if free < 0 {
+		free = 0
+	}
+	growth := resource.NewQuantity(int64(f.Growth), resource.BinarySI)
+	return fmt.Sprintf("%s full in ~%.0f days, growing %s/day with %s free", group, days, roundGi(growth).String(),
+		roundGi(resource.NewQuantity(free, resource.BinarySI)).String())
+}
+
+// roundGi 取整到Gi，避免消息随每次采样变化
+func roundGi(q *resource.Quantity) *resource.Quantity {
+	gi := (q.Value() + 1<<29) >> 30
+	return resource.NewQuantity(gi<<30, resource.BinarySI)
+}
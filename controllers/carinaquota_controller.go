@@ -0,0 +1,92 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/quota"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// CarinaQuotaReconciler 统计命名空间内carina卷的用量，更新CarinaQuota状态
+// The quota itself is enforced by the csi controller when volumes are created or expanded.
+type CarinaQuotaReconciler struct {
+	client.Client
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=carinaquotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=carinaquotas/status,verbs=get;update;patch
+
+func (r *CarinaQuotaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	q := &carinav1.CarinaQuota{}
+	if err := r.Get(ctx, req.NamespacedName, q); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	lvList := new(carinav1.LogicVolumeList)
+	if err := r.List(ctx, lvList); err != nil {
+		return ctrl.Result{}, err
+	}
+	total, groups := quota.Usage(lvList.Items, q.Namespace)
+	status := quota.Status(q, total, groups)
+	if equality.Semantic.DeepEqual(q.Status, status) {
+		return ctrl.Result{}, nil
+	}
+	q.Status = status
+	if err := r.Status().Update(ctx, q); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// quotasOfVolume enqueues the quotas of the namespace the volume belongs to
+func (r *CarinaQuotaReconciler) quotasOfVolume(obj client.Object) []reconcile.Request {
+	lv, ok := obj.(*carinav1.LogicVolume)
+	if !ok || lv.Spec.NameSpace == "" {
+		return nil
+	}
+	quotaList := new(carinav1.CarinaQuotaList)
+	if err := r.List(context.Background(), quotaList, client.InNamespace(lv.Spec.NameSpace)); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, q := range quotaList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: q.Namespace, Name: q.Name}})
+	}
+	return requests
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *CarinaQuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("carinaquota").
+		For(&carinav1.CarinaQuota{}).
+		Watches(&source.Kind{Type: &carinav1.LogicVolume{}}, handler.EnqueueRequestsFromMapFunc(r.quotasOfVolume)).
+		Complete(r)
+}
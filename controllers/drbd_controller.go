@@ -0,0 +1,295 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/drbd"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/carina-io/carina/utils/mutx"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// DrbdReconciler 在节点上维护副本卷的drbd资源
+// Both LogicVolumes of a replicated volume carry the drbd minor, each node brings the
+// resource up over its own copy once both copies exist and reports the state of the
+// resource in the status of its LogicVolume. The tiebreaker node brings it up without disk.
+type DrbdReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	NodeName string
+	DM       *deviceManager.DeviceManager
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+
+func (r *DrbdReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	lv := new(carinav1.LogicVolume)
+	if err := r.Get(ctx, req.NamespacedName, lv); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if lv.Spec.NodeName != r.NodeName && lv.Annotations[utils.VolumeDrbdTiebreaker] != r.NodeName {
+		return ctrl.Result{}, nil
+	}
+	minor, err := strconv.Atoi(lv.Annotations[utils.VolumeDrbdMinor])
+	if err != nil {
+		return ctrl.Result{}, nil
+	}
+	if lv.Spec.NodeName != r.NodeName {
+		return r.reconcileTiebreaker(ctx, lv, minor)
+	}
+	primary := lv.Name
+	if p := lv.Annotations[utils.VolumeReplicaOf]; p != "" {
+		primary = p
+	}
+
+	if lv.DeletionTimestamp != nil {
+		if !utils.ContainsString(lv.Finalizers, utils.DrbdFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		// 先停止drbd资源，本节点的lv才能删除
+		err := r.DM.Pool.Run(ctx, mutx.PriorityProvision, lv.Name, func() error {
+			return r.DM.Drbd.Down(primary)
+		})
+		if err != nil {
+			log.Errorf("take down drbd resource %s failed: %s", primary, err.Error())
+			return ctrl.Result{}, err
+		}
+		lv2 := lv.DeepCopy()
+		lv2.Finalizers = utils.SliceRemoveString(lv2.Finalizers, utils.DrbdFinalizer)
+		return ctrl.Result{}, r.Patch(ctx, lv2, client.MergeFrom(lv))
+	}
+	if !utils.ContainsString(lv.Finalizers, utils.DrbdFinalizer) {
+		lv2 := lv.DeepCopy()
+		lv2.Finalizers = append(lv2.Finalizers, utils.DrbdFinalizer)
+		if err := r.Patch(ctx, lv2, client.MergeFrom(lv)); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// 两个副本的lv都创建完成后才能配置drbd资源
+	peer := new(carinav1.LogicVolume)
+	peerName := drbd.ReplicaName(primary)
+	if lv.Name != primary {
+		peerName = primary
+	}
+	if err := r.Get(ctx, client.ObjectKey{Name: peerName, Namespace: lv.Namespace}, peer); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	primaryLV, replicaLV := lv, peer
+	if lv.Name != primary {
+		primaryLV, replicaLV = peer, lv
+	}
+	if primaryLV.Status.VolumeID == "" || replicaLV.Status.VolumeID == "" {
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	res, err := r.drbdResource(ctx, primaryLV, replicaLV, minor)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var st *drbd.Status
+	err = r.DM.Pool.Run(ctx, mutx.PriorityProvision, lv.Name, func() error {
+		if err := r.DM.Drbd.Up(res); err != nil {
+			return err
+		}
+		var err error
+		if st, err = r.DM.Drbd.Status(primary); err != nil || st == nil {
+			return err
+		}
+		// 新建的卷两边都是空的，不需要全量同步
+		if lv.Name == primary && st.Connection == "Connected" && st.Disk == "Inconsistent" && st.PeerDisk == "Inconsistent" {
+			if err := r.DM.Drbd.SkipInitialSync(primary); err != nil {
+				return err
+			}
+			st, err = r.DM.Drbd.Status(primary)
+		}
+		return err
+	})
+	if err != nil {
+		log.Errorf("bring up drbd resource %s failed: %s", primary, err.Error())
+		r.Recorder.Event(lv, corev1.EventTypeWarning, "DrbdUpFailed", fmt.Sprintf("bring up drbd resource on node %s failed: %s", r.NodeName, err.Error()))
+		return ctrl.Result{}, err
+	}
+	if st == nil {
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	current := &carinav1.DrbdStatus{
+		Role:        st.Role,
+		Disk:        st.Disk,
+		PeerDisk:    st.PeerDisk,
+		Connection:  st.Connection,
+		Replication: st.Replication,
+		Quorum:      st.Quorum,
+	}
+	if lv.Status.Drbd == nil || *lv.Status.Drbd != *current {
+		wasDegraded := lv.Status.Drbd != nil && (lv.Status.Drbd.Disk != "UpToDate" || lv.Status.Drbd.PeerDisk != "UpToDate")
+		switch {
+		case st.Degraded() && !wasDegraded:
+			r.Recorder.Event(lv, corev1.EventTypeWarning, "ReplicaDegraded", fmt.Sprintf("drbd resource %s on node %s is degraded: disk %s, peer disk %s, connection %s", primary, r.NodeName, st.Disk, st.PeerDisk, st.Connection))
+		case !st.Degraded() && wasDegraded:
+			r.Recorder.Event(lv, corev1.EventTypeNormal, "ReplicaSynced", fmt.Sprintf("drbd resource %s on node %s is up to date on both nodes", primary, r.NodeName))
+		}
+		lv2 := lv.DeepCopy()
+		lv2.Status.Drbd = current
+		if err := r.Status().Patch(ctx, lv2, client.MergeFrom(lv)); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+}
+
+// reconcileTiebreaker 在tiebreaker节点上不带磁盘加入副本卷的drbd资源
+func (r *DrbdReconciler) reconcileTiebreaker(ctx context.Context, lv *carinav1.LogicVolume, minor int) (ctrl.Result, error) {
+	if lv.DeletionTimestamp != nil {
+		if !utils.ContainsString(lv.Finalizers, utils.DrbdTiebreakerFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		err := r.DM.Pool.Run(ctx, mutx.PriorityProvision, lv.Name, func() error {
+			return r.DM.Drbd.Down(lv.Name)
+		})
+		if err != nil {
+			log.Errorf("take down drbd tiebreaker of resource %s failed: %s", lv.Name, err.Error())
+			return ctrl.Result{}, err
+		}
+		lv2 := lv.DeepCopy()
+		lv2.Finalizers = utils.SliceRemoveString(lv2.Finalizers, utils.DrbdTiebreakerFinalizer)
+		return ctrl.Result{}, r.Patch(ctx, lv2, client.MergeFromWithOptions(lv, client.MergeFromWithOptimisticLock{}))
+	}
+	// 两个副本的节点同时修改finalizer，不能覆盖它们的
+	if !utils.ContainsString(lv.Finalizers, utils.DrbdTiebreakerFinalizer) {
+		lv2 := lv.DeepCopy()
+		lv2.Finalizers = append(lv2.Finalizers, utils.DrbdTiebreakerFinalizer)
+		if err := r.Patch(ctx, lv2, client.MergeFromWithOptions(lv, client.MergeFromWithOptimisticLock{})); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	replicaLV := new(carinav1.LogicVolume)
+	if err := r.Get(ctx, client.ObjectKey{Name: drbd.ReplicaName(lv.Name), Namespace: lv.Namespace}, replicaLV); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if lv.Status.VolumeID == "" || replicaLV.Status.VolumeID == "" {
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+	res, err := r.drbdResource(ctx, lv, replicaLV, minor)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	err = r.DM.Pool.Run(ctx, mutx.PriorityProvision, lv.Name, func() error {
+		return r.DM.Drbd.Up(res)
+	})
+	if err != nil {
+		log.Errorf("bring up drbd tiebreaker of resource %s failed: %s", lv.Name, err.Error())
+		r.Recorder.Event(lv, corev1.EventTypeWarning, "DrbdUpFailed", fmt.Sprintf("bring up drbd tiebreaker on node %s failed: %s", r.NodeName, err.Error()))
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+}
+
+// drbdResource 返回副本卷的drbd资源，第一个副本的LogicVolume记录了tiebreaker节点
+func (r *DrbdReconciler) drbdResource(ctx context.Context, primaryLV, replicaLV *carinav1.LogicVolume, minor int) (*drbd.Resource, error) {
+	res := &drbd.Resource{Name: primaryLV.Name, Minor: minor}
+	for i, v := range []*carinav1.LogicVolume{primaryLV, replicaLV} {
+		host, err := r.drbdHost(ctx, v.Spec.NodeName)
+		if err != nil {
+			return nil, err
+		}
+		host.Disk = fmt.Sprintf("/dev/%s/%s", v.Spec.DeviceGroup, v.Status.VolumeID)
+		res.Hosts[i] = host
+	}
+	if name := primaryLV.Annotations[utils.VolumeDrbdTiebreaker]; name != "" {
+		host, err := r.drbdHost(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		res.Tiebreaker = &host
+	}
+	return res, nil
+}
+
+// drbdHost 返回节点的主机名和复制地址
+func (r *DrbdReconciler) drbdHost(ctx context.Context, nodeName string) (drbd.Host, error) {
+	node := new(corev1.Node)
+	if err := r.Get(ctx, client.ObjectKey{Name: nodeName}, node); err != nil {
+		return drbd.Host{}, err
+	}
+	host := drbd.Host{Hostname: node.Name}
+	if h := node.Labels[corev1.LabelHostname]; h != "" {
+		host.Hostname = h
+	}
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeInternalIP {
+			host.Address = addr.Address
+			break
+		}
+	}
+	if host.Address == "" {
+		return drbd.Host{}, fmt.Errorf("node %s has no internal ip", node.Name)
+	}
+	return host, nil
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *DrbdReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	mine := func(o client.Object) bool {
+		lv, ok := o.(*carinav1.LogicVolume)
+		if !ok {
+			return false
+		}
+		_, replicated := lv.Annotations[utils.VolumeDrbdMinor]
+		return replicated && (lv.Spec.NodeName == r.NodeName || lv.Annotations[utils.VolumeDrbdTiebreaker] == r.NodeName)
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("drbd").
+		For(&carinav1.LogicVolume{}).
+		WithEventFilter(predicate.Funcs{
+			CreateFunc:  func(e event.CreateEvent) bool { return mine(e.Object) },
+			UpdateFunc:  func(e event.UpdateEvent) bool { return mine(e.ObjectNew) },
+			DeleteFunc:  func(e event.DeleteEvent) bool { return false },
+			GenericFunc: func(e event.GenericEvent) bool { return mine(e.Object) },
+		}).
+		Complete(r)
+}
@@ -0,0 +1,105 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// FailureDomainReconciler 给pv打上故障域标签
+// The node records the enclosures or HBAs holding the data of a volume on its
+// LogicVolume, they are copied to the pv as failure-domain.carina.storage.io/<domain>
+// labels so that operators can check that replicas do not share an enclosure or HBA.
+type FailureDomainReconciler struct {
+	client.Client
+}
+
+// +kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch
+
+func (r *FailureDomainReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	pv := &corev1.PersistentVolume{}
+	if err := r.Get(ctx, req.NamespacedName, pv); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if !carinaVolume(pv) || pv.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	lvs, err := volumeLogicVolumes(ctx, r.Client, pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// LogicVolume还未同步到缓存时稍后再试
+	if len(lvs) == 0 {
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	domains := []string{}
+	for _, lv := range lvs {
+		for _, domain := range lv.Status.FailureDomains {
+			if !utils.ContainsString(domains, domain) {
+				domains = append(domains, domain)
+			}
+		}
+	}
+	sort.Strings(domains)
+
+	labels, changed := utils.FailureDomainLabels(pv.Labels, domains)
+	if !changed {
+		return ctrl.Result{}, nil
+	}
+	pv.Labels = labels
+	if err := r.Update(ctx, pv); err != nil {
+		return ctrl.Result{}, err
+	}
+	log.Infof("label pv %s with failure domains %v", pv.Name, domains)
+	return ctrl.Result{}, nil
+}
+
+func carinaVolume(pv *corev1.PersistentVolume) bool {
+	return pv.Spec.CSI != nil && pv.Spec.CSI.Driver == utils.CSIPluginName
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *FailureDomainReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	pred := predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return carinaVolume(e.Object.(*corev1.PersistentVolume)) },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return carinaVolume(e.ObjectNew.(*corev1.PersistentVolume)) },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("failuredomain").
+		WithEventFilter(pred).
+		For(&corev1.PersistentVolume{}).
+		Complete(r)
+}
@@ -20,21 +20,34 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/carina-io/carina/pkg/datamover"
+	"github.com/carina-io/carina/pkg/devicemanager/device"
 	"github.com/carina-io/carina/pkg/devicemanager/partition"
 	"github.com/carina-io/carina/pkg/devicemanager/volume"
+	"github.com/carina-io/carina/pkg/opjournal"
+	"github.com/carina-io/carina/pkg/populator"
+	"github.com/carina-io/carina/pkg/tracing"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
+	"github.com/carina-io/carina/utils/mutx"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc/codes"
 	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	carinav1 "github.com/carina-io/carina/api/v1"
 )
@@ -47,12 +60,29 @@ type LogicVolumeReconciler struct {
 	nodeName  string
 	volume    volume.LocalVolume
 	partition partition.LocalPartition
+	pool      *mutx.PriorityPool
+	throttle  *datamover.Throttle
+	journal   *opjournal.Journal
+	// s3Config 挂载的s3配置目录，deviceDir 卷设备所在目录
+	s3Config  string
+	deviceDir string
+
+	exportMu sync.Mutex
+	// exports 正在上传的快照为零值，上传失败的记录失败时间
+	exports map[string]time.Time
 }
 
 // +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 
-func NewLogicVolumeReconciler(client client.Client, scheme *runtime.Scheme, recorder record.EventRecorder, nodeName string, volume volume.LocalVolume, partition partition.LocalPartition) *LogicVolumeReconciler {
+// maxLVRemoveBatch 一次批量删除的卷数上限，避免单次lvremove耗时过长
+const maxLVRemoveBatch = 64
+
+// exportRetryInterval 快照上传失败后重试的间隔
+const exportRetryInterval = 5 * time.Minute
+
+func NewLogicVolumeReconciler(client client.Client, scheme *runtime.Scheme, recorder record.EventRecorder, nodeName string, volume volume.LocalVolume, partition partition.LocalPartition, pool *mutx.PriorityPool, throttle *datamover.Throttle, journal *opjournal.Journal) *LogicVolumeReconciler {
 	return &LogicVolumeReconciler{
 		Client:    client,
 		Scheme:    scheme,
@@ -60,6 +90,11 @@ func NewLogicVolumeReconciler(client client.Client, scheme *runtime.Scheme, reco
 		nodeName:  nodeName,
 		volume:    volume,
 		partition: partition,
+		pool:      pool,
+		throttle:  throttle,
+		journal:   journal,
+		s3Config:  utils.S3ConfigDir,
+		deviceDir: "/dev",
 	}
 }
 
@@ -72,12 +107,22 @@ func (r *LogicVolumeReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			log.Error(err, "unable to fetch LogicVolume")
 			return ctrl.Result{}, err
 		}
-		return ctrl.Result{}, nil
+		if _, ok := r.journal.Get(req.Name); !ok {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, r.pool.Run(ctx, mutx.PriorityProvision, req.Name, func() error {
+			return r.recoverLV(ctx, req.Name, nil)
+		})
 	}
 
 	if lv.Spec.NodeName != r.nodeName {
 		log.Info("unfiltered logic value nodeName ", lv.Spec.NodeName)
-		return ctrl.Result{}, nil
+		if _, ok := r.journal.Get(lv.Name); !ok {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, r.pool.Run(ctx, mutx.PriorityProvision, lv.Name, func() error {
+			return r.recoverLV(ctx, lv.Name, lv)
+		})
 	}
 
 	if lv.ObjectMeta.DeletionTimestamp == nil {
@@ -92,13 +137,28 @@ func (r *LogicVolumeReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			return ctrl.Result{Requeue: true}, nil
 		}
 
+		// 卷的创建、扩容、删除优先级低于pod的挂载
+		if err := r.pool.Acquire(ctx, mutx.PriorityProvision, lv.Name); err != nil {
+			return ctrl.Result{}, err
+		}
+		defer r.pool.Release(mutx.PriorityProvision)
+
+		if err := r.recoverLV(ctx, lv.Name, lv); err != nil {
+			log.Error(err, " failed to recover LV name ", lv.Name)
+			return ctrl.Result{}, err
+		}
 		if lv.Status.VolumeID == "" {
-			err := r.createLV(ctx, lv)
+			createCtx, span := tracing.StartFromObject(ctx, "lvcreate", lv, attribute.String("deviceGroup", lv.Spec.DeviceGroup))
+			err := r.createLV(createCtx, lv)
+			tracing.End(span, err)
 			if err != nil {
 				log.Error(err, " failed to create LV name ", lv.Name)
 			}
 			return ctrl.Result{}, err
 		}
+		if lv.Annotations[utils.SnapshotExportTarget] != "" {
+			return r.exportSnapshot(ctx, lv)
+		}
 		err := r.expandLV(ctx, lv)
 		if err != nil {
 			log.Error(err, " failed to expand LV name ", lv.Name)
@@ -112,8 +172,23 @@ func (r *LogicVolumeReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, nil
 	}
 
+	if batch := r.namespaceTeardownBatch(ctx, lv); len(batch) > 1 {
+		return ctrl.Result{}, r.removeLVBatch(ctx, batch)
+	}
+
 	log.Info("start finalizing LogicVolume name ", lv.Name)
-	err := r.removeLVIfExists(ctx, lv)
+	err := r.pool.Run(ctx, mutx.PriorityProvision, lv.Name, func() error {
+		if err := r.wipeLV(lv); err != nil {
+			return err
+		}
+		if err := r.removeLVIfExists(ctx, lv); err != nil {
+			return err
+		}
+		if err := r.journal.Complete(lv.Name); err != nil {
+			log.Warnf("complete journal of LV %s failed %s", lv.Name, err.Error())
+		}
+		return nil
+	})
 	if err != nil {
 		return ctrl.Result{}, err
 	}
@@ -130,12 +205,128 @@ func (r *LogicVolumeReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 }
 
 func (r *LogicVolumeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// 上次运行中断的卷创建在启动后逐个收尾，LogicVolume已删除的也会被调谐
+	entries := r.journal.Entries()
+	interrupted := make(chan event.GenericEvent, len(entries))
+	for _, e := range entries {
+		interrupted <- event.GenericEvent{Object: &carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: e.Name, Namespace: utils.LogicVolumeNamespace},
+			Spec:       carinav1.LogicVolumeSpec{NodeName: r.nodeName},
+		}}
+	}
+	if len(entries) > 0 {
+		log.Infof("%d interrupted volume creations are recovered", len(entries))
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&carinav1.LogicVolume{}).
+		Watches(&source.Channel{Source: interrupted}, &handler.EnqueueRequestForObject{}).
 		WithEventFilter(&logicVolumeFilter{r.nodeName}).
 		Complete(r)
 }
 
+// recoverLV 按操作日志收尾中断的卷创建，lv为nil表示LogicVolume已不存在
+func (r *LogicVolumeReconciler) recoverLV(ctx context.Context, name string, lv *carinav1.LogicVolume) error {
+	e, ok := r.journal.Get(name)
+	if !ok {
+		return nil
+	}
+	action := opjournal.Decide(e, lv, r.nodeName)
+	switch action {
+	case opjournal.ActionResume:
+		// 创建时已存在的thin pool、卷或分区会被复用
+		return nil
+	case opjournal.ActionRollback:
+		log.Infof("roll back interrupted creation of LV %s step %s started %s", name, e.Step, e.Started.Format(time.RFC3339))
+		if err := r.rollbackLV(e); err != nil {
+			return err
+		}
+		if lv != nil && lv.UID == e.UID {
+			r.Recorder.Event(lv, corev1.EventTypeNormal, "CreateVolumeRolledBack", fmt.Sprintf("removed the volume of an interrupted creation at step %s node: %s", e.Step, r.nodeName))
+		}
+	}
+	return r.journal.Complete(name)
+}
+
+// rollbackLV 删除中断的创建留下的卷、thin pool或分区
+func (r *LogicVolumeReconciler) rollbackLV(e opjournal.Entry) error {
+	var err error
+	switch e.VolumeType {
+	case utils.LvmVolumeType:
+		err = utils.UntilMaxRetry(func() error {
+			if e.SnapshotSource != "" {
+				return r.volume.DeleteSnapshot(e.Name, e.DeviceGroup)
+			}
+			return r.volume.DeleteVolume(e.Name, e.DeviceGroup)
+		}, 5, 12*time.Second)
+	case utils.RawVolumeType:
+		err = utils.UntilMaxRetry(func() error {
+			return r.partition.DeletePartition(utils.PartitionName(e.Name), e.DeviceGroup)
+		}, 5, 12*time.Second)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("roll back interrupted creation of LV %s failed: %s", e.Name, err.Error())
+	}
+	r.volume.NoticeUpdateCapacity([]string{e.DeviceGroup})
+	return nil
+}
+
+// journalCreation 在创建卷的每一步之前写入操作日志，日志写入失败时不创建卷
+func (r *LogicVolumeReconciler) journalCreation(lv *carinav1.LogicVolume, step opjournal.Step) error {
+	err := r.journal.Record(opjournal.Entry{
+		Name:           lv.Name,
+		UID:            lv.UID,
+		VolumeType:     lv.Annotations[utils.VolumeManagerType],
+		DeviceGroup:    lv.Spec.DeviceGroup,
+		SnapshotSource: lv.Annotations[utils.SnapshotSource],
+		Restore:        restored(lv),
+		Step:           step,
+		Started:        time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("journal creation of LV %s failed: %s", lv.Name, err.Error())
+	}
+	return nil
+}
+
+// wipeLV 回收卷时按注解中的策略擦除数据
+func (r *LogicVolumeReconciler) wipeLV(lv *carinav1.LogicVolume) error {
+	policy := lv.Annotations[utils.VolumeWipePolicy]
+	// 快照与卷共享数据块，不能擦除
+	if policy == "" || policy == utils.WipePolicyNone || lv.Annotations[utils.SnapshotSource] != "" {
+		return nil
+	}
+
+	passes, _ := strconv.Atoi(lv.Annotations[utils.VolumeWipePasses])
+	if passes < 1 {
+		passes = 1
+	}
+	log.Infof("wipe LogicVolume %s with policy %s, %d passes", lv.Name, policy, passes)
+	r.Recorder.Event(lv, corev1.EventTypeNormal, "WipeVolumeStarted", fmt.Sprintf("wipe volume started node: %s, policy: %s, passes: %d", r.nodeName, policy, passes))
+	wipe := device.Wipe{
+		Policy: policy,
+		Passes: passes,
+		Progress: func(pass, passes, percent int) {
+			log.Infof("wipe LogicVolume %s pass %d/%d: %d%%", lv.Name, pass, passes, percent)
+			r.Recorder.Event(lv, corev1.EventTypeNormal, "WipeVolumeProgress", fmt.Sprintf("wipe volume node: %s, pass %d/%d: %d%%", r.nodeName, pass, passes, percent))
+		},
+	}
+	var err error
+	switch lv.Annotations[utils.VolumeManagerType] {
+	case utils.LvmVolumeType:
+		err = r.volume.WipeVolume(lv.Name, lv.Spec.DeviceGroup, wipe)
+	case utils.RawVolumeType:
+		err = r.partition.WipePartition(utils.PartitionName(lv.Name), lv.Spec.DeviceGroup, wipe)
+	}
+	if err != nil {
+		r.Recorder.Event(lv, corev1.EventTypeWarning, "WipeVolumeFailed", fmt.Sprintf("wipe volume failed node: %s, error: %s", r.nodeName, err.Error()))
+		return err
+	}
+	r.Recorder.Event(lv, corev1.EventTypeNormal, "WipeVolumeSuccess", fmt.Sprintf("wipe volume success node: %s, policy: %s, passes: %d", r.nodeName, policy, passes))
+	return nil
+}
+
 // operation lvm
 func (r *LogicVolumeReconciler) removeLVIfExists(ctx context.Context, lv *carinav1.LogicVolume) error {
 	// Finalizer's process ( RemoveLV then removeString ) is not atomic,
@@ -144,6 +335,9 @@ func (r *LogicVolumeReconciler) removeLVIfExists(ctx context.Context, lv *carina
 	switch lv.Annotations[utils.VolumeManagerType] {
 	case utils.LvmVolumeType:
 		err := utils.UntilMaxRetry(func() error {
+			if lv.Annotations[utils.SnapshotSource] != "" {
+				return r.volume.DeleteSnapshot(lv.Name, lv.Spec.DeviceGroup)
+			}
 			return r.volume.DeleteVolume(lv.Name, lv.Spec.DeviceGroup)
 		}, 10, 12*time.Second)
 		if err != nil {
@@ -166,6 +360,84 @@ func (r *LogicVolumeReconciler) removeLVIfExists(ctx context.Context, lv *carina
 	return nil
 }
 
+// namespaceTeardownBatch 命名空间删除时，本节点同一设备组中该命名空间待删除的lvm卷合并为一批回收
+// The first element is lv itself. Nil means lv is finalized on its own: the namespace is not
+// terminating, or lv is a snapshot or raw partition which keep the single volume path.
+func (r *LogicVolumeReconciler) namespaceTeardownBatch(ctx context.Context, lv *carinav1.LogicVolume) []*carinav1.LogicVolume {
+	if !batchRemovable(lv) || lv.Spec.NameSpace == "" {
+		return nil
+	}
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: lv.Spec.NameSpace}, ns); err != nil || ns.DeletionTimestamp == nil {
+		return nil
+	}
+	lvList := &carinav1.LogicVolumeList{}
+	if err := r.List(ctx, lvList); err != nil {
+		log.Warnf("list LogicVolume failed %s, finalize %s alone", err.Error(), lv.Name)
+		return nil
+	}
+
+	batch := []*carinav1.LogicVolume{lv}
+	for i := range lvList.Items {
+		other := &lvList.Items[i]
+		if len(batch) >= maxLVRemoveBatch {
+			break
+		}
+		if other.Name == lv.Name || !batchRemovable(other) {
+			continue
+		}
+		if other.Spec.NodeName == r.nodeName && other.Spec.DeviceGroup == lv.Spec.DeviceGroup && other.Spec.NameSpace == lv.Spec.NameSpace {
+			batch = append(batch, other)
+		}
+	}
+	return batch
+}
+
+// batchRemovable 待回收的lvm卷，快照不参与批量删除
+func batchRemovable(lv *carinav1.LogicVolume) bool {
+	return lv.DeletionTimestamp != nil &&
+		utils.ContainsString(lv.Finalizers, utils.LogicVolumeFinalizer) &&
+		lv.Annotations[utils.VolumeManagerType] == utils.LvmVolumeType &&
+		lv.Annotations[utils.SnapshotSource] == ""
+}
+
+// removeLVBatch 擦除后用一次lvremove删除整批卷，再逐个去掉finalizer
+func (r *LogicVolumeReconciler) removeLVBatch(ctx context.Context, batch []*carinav1.LogicVolume) error {
+	lv := batch[0]
+	log.Infof("start finalizing %d LogicVolumes of terminating namespace %s in one batch", len(batch), lv.Spec.NameSpace)
+	err := r.pool.Run(ctx, mutx.PriorityProvision, lv.Name, func() error {
+		names := []string{}
+		for _, l := range batch {
+			if err := r.wipeLV(l); err != nil {
+				return err
+			}
+			names = append(names, l.Name)
+		}
+		err := utils.UntilMaxRetry(func() error {
+			return r.volume.DeleteVolumes(names, lv.Spec.DeviceGroup)
+		}, 10, 12*time.Second)
+		if err != nil {
+			log.Error(err, " failed to remove LV batch ", names, " uid ", lv.Spec.DeviceGroup)
+		}
+		r.volume.NoticeUpdateCapacity([]string{lv.Spec.DeviceGroup})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, l := range batch {
+		l2 := l.DeepCopy()
+		l2.Finalizers = utils.SliceRemoveString(l2.Finalizers, utils.LogicVolumeFinalizer)
+		if err := r.Patch(ctx, l2, client.MergeFrom(l)); err != nil && !apierrs.IsNotFound(err) {
+			log.Error(err, " failed to remove finalizer name ", l.Name)
+			return err
+		}
+	}
+	log.Infof("removed %d LogicVolumes of namespace %s", len(batch), lv.Spec.NameSpace)
+	return nil
+}
+
 func (r *LogicVolumeReconciler) createLV(ctx context.Context, lv *carinav1.LogicVolume) error {
 	// When lv.Status.Code is not codes.OK (== 0), CreateLV has already failed.
 	// LogicalVolume CRD will be deleted soon by the controller.
@@ -177,9 +449,45 @@ func (r *LogicVolumeReconciler) createLV(ctx context.Context, lv *carinav1.Logic
 
 	switch lv.Annotations[utils.VolumeManagerType] {
 	case utils.LvmVolumeType:
-		err := utils.UntilMaxRetry(func() error {
-			return r.volume.CreateVolume(lv.Name, lv.Spec.DeviceGroup, uint64(reqBytes), 1)
-		}, 5, 12*time.Second)
+		if err := r.journalCreation(lv, opjournal.StepStarted); err != nil {
+			return err
+		}
+		volumeID := "volume-" + lv.Name
+		var err error
+		if source := lv.Annotations[utils.SnapshotSource]; source != "" {
+			volumeID = "snap-" + lv.Name
+			err = utils.UntilMaxRetry(func() error {
+				return r.volume.CreateSnapshot(lv.Name, source, lv.Spec.DeviceGroup)
+			}, 5, 12*time.Second)
+		} else {
+			// 条带参数由csi控制器校验后记录在LogicVolume注解上
+			var stripes uint
+			var stripeSize string
+			stripes, stripeSize, err = utils.StripeParameters(lv.Annotations)
+			adopted := false
+			if err == nil && spareAdoptable(lv, stripes) {
+				// 有大小和文件系统相同的备用卷时直接改名领用，省去lvcreate和mkfs
+				err = utils.UntilMaxRetry(func() error {
+					var adoptErr error
+					adopted, adoptErr = r.volume.AdoptSpare(lv.Name, lv.Spec.DeviceGroup, uint64(reqBytes), lv.Annotations[utils.VolumeFsType])
+					return adoptErr
+				}, 5, 12*time.Second)
+				if adopted && err == nil {
+					r.Recorder.Event(lv, corev1.EventTypeNormal, "SpareVolumeAdopted", fmt.Sprintf("adopted a preformatted %s spare volume node: %s", lv.Annotations[utils.VolumeFsType], r.nodeName))
+				}
+			}
+			if err == nil && !adopted {
+				err = utils.UntilMaxRetry(func() error {
+					return r.volume.CreateVolume(lv.Name, lv.Spec.DeviceGroup, uint64(reqBytes), 1, stripes, stripeSize)
+				}, 5, 12*time.Second)
+			}
+			if err == nil && restored(lv) {
+				err = r.journalCreation(lv, opjournal.StepCreated)
+				if err == nil {
+					err = r.restoreLV(ctx, lv)
+				}
+			}
+		}
 
 		if err != nil {
 			lv.Status.Code = codes.Internal
@@ -192,7 +500,7 @@ func (r *LogicVolumeReconciler) createLV(ctx context.Context, lv *carinav1.Logic
 				log.Error(err2, " failed to update status name ", lv.Name, " uid ", lv.UID)
 			}
 		} else {
-			lv.Status.VolumeID = "volume-" + lv.Name
+			lv.Status.VolumeID = volumeID
 			lv.Status.CurrentSize = resource.NewQuantity(reqBytes, resource.BinarySI)
 			lv.Status.Code = codes.OK
 			lv.Status.Message = ""
@@ -203,10 +511,28 @@ func (r *LogicVolumeReconciler) createLV(ctx context.Context, lv *carinav1.Logic
 				lv.Status.DeviceMajor = lvInfo.LVKernelMajor
 				lv.Status.DeviceMinor = lvInfo.LVKernelMinor
 			}
+			// 快照与源卷共用thin pool，故障域与源卷相同
+			poolName := lv.Name
+			if source := lv.Annotations[utils.SnapshotSource]; source != "" {
+				poolName = source
+			}
+			if domains, err := r.volume.VolumeFailureDomains(poolName, lv.Spec.DeviceGroup); err != nil {
+				log.Warnf("get failure domains of LV %s failed %s", lv.Name, err.Error())
+			} else {
+				lv.Status.FailureDomains = domains
+			}
+			if devices, err := r.volume.VolumeDevices(poolName, lv.Spec.DeviceGroup); err != nil {
+				log.Warnf("get devices of LV %s failed %s", lv.Name, err.Error())
+			} else {
+				lv.Status.Devices = devices
+			}
 			r.Recorder.Event(lv, corev1.EventTypeNormal, "CreateVolumeSuccess", fmt.Sprintf("create volume success node: %s, time: %s", r.nodeName, time.Now().Format("2006-01-02T15:04:05.000Z")))
 		}
 
 	case utils.RawVolumeType:
+		if err := r.journalCreation(lv, opjournal.StepStarted); err != nil {
+			return err
+		}
 		if _, ok := lv.Annotations[utils.ExclusivityDisk]; !ok {
 			log.Info("Create lv using an exclusive disk")
 		}
@@ -243,6 +569,10 @@ func (r *LogicVolumeReconciler) createLV(ctx context.Context, lv *carinav1.Logic
 			minor, _ := strconv.ParseUint(diskInfo.UdevInfo.Properties["MINOR"], 10, 32)
 			lv.Status.DeviceMajor = uint32(major)
 			lv.Status.DeviceMinor = uint32(minor)
+			lv.Status.Devices = []string{diskInfo.Path}
+			if domain := device.FailureDomain(diskInfo.Path); domain != "" {
+				lv.Status.FailureDomains = []string{domain}
+			}
 
 			r.Recorder.Event(lv, corev1.EventTypeNormal, "CreateVolumeSuccess", fmt.Sprintf("create volume success node: %s, time: %s", r.nodeName, time.Now().Format("2006-01-02T15:04:05.000Z")))
 		}
@@ -257,11 +587,150 @@ func (r *LogicVolumeReconciler) createLV(ctx context.Context, lv *carinav1.Logic
 		return err
 	}
 
+	if err := r.journal.Complete(lv.Name); err != nil {
+		log.Warnf("complete journal of LV %s failed %s", lv.Name, err.Error())
+	}
+
 	r.volume.NoticeUpdateCapacity([]string{lv.Spec.DeviceGroup})
 	log.Info("created new LV name ", lv.Name, " uid ", lv.UID, " status.volumeID ", lv.Status.VolumeID)
 	return nil
 }
 
+// spareAdoptable 备用卷只有文件系统，加密、条带化和从快照恢复的卷不能领用
+func spareAdoptable(lv *carinav1.LogicVolume, stripes uint) bool {
+	return lv.Annotations[utils.VolumeFsType] != "" && lv.Annotations[utils.VolumeEncrypted] != "true" &&
+		!restored(lv) && stripes <= 1
+}
+
+// restored 卷从本节点的快照或者s3中导出的快照恢复
+func restored(lv *carinav1.LogicVolume) bool {
+	return lv.Annotations[utils.VolumeDataSource] != "" || lv.Annotations[utils.VolumeRestoreSource] != ""
+}
+
+// s3Source 返回s3地址，endpoint和凭据来自carina-node挂载的s3配置
+func (r *LogicVolumeReconciler) s3Source(url string) (*populator.S3Source, error) {
+	src, err := populator.ParseS3URL(url)
+	if err != nil {
+		return nil, err
+	}
+	if err := src.LoadConfig(r.s3Config); err != nil {
+		return nil, fmt.Errorf("load s3 config from %s failed: %s", r.s3Config, err.Error())
+	}
+	return src, nil
+}
+
+// restoreLV 将快照数据复制到新建的卷，只受带宽限制，等待维护窗口会使卷创建超时
+func (r *LogicVolumeReconciler) restoreLV(ctx context.Context, lv *carinav1.LogicVolume) error {
+	dst := fmt.Sprintf("%s/%s/volume-%s", r.deviceDir, lv.Spec.DeviceGroup, lv.Name)
+	start := time.Now()
+	if url := lv.Annotations[utils.VolumeRestoreSource]; url != "" {
+		src, err := r.s3Source(url)
+		if err == nil {
+			var image *populator.Image
+			image, err = populator.RestoreImage(ctx, r.throttle, dst, src)
+			if err == nil {
+				log.Infof("restored LV %s from %s, %d chunks written in %s", lv.Name, url, len(image.Chunks), time.Since(start).Round(time.Second))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("restore from %s failed: %s", url, err.Error())
+		}
+		return nil
+	}
+
+	snapshotID := lv.Annotations[utils.VolumeDataSource]
+	src := fmt.Sprintf("%s/%s/%s", r.deviceDir, lv.Spec.DeviceGroup, snapshotID)
+	written, err := datamover.CopyDevice(ctx, r.throttle, dst, src)
+	if err != nil {
+		return fmt.Errorf("restore from snapshot %s failed: %s", snapshotID, err.Error())
+	}
+	log.Infof("restored LV %s from snapshot %s, %d bytes written in %s", lv.Name, snapshotID, written, time.Since(start).Round(time.Second))
+	return nil
+}
+
+// exportSnapshot 在后台将快照上传到快照类指定的s3地址，结果记录在Exported条件中
+// The upload may take hours, it runs outside of the reconcile and keeps no worker busy. A failed
+// upload is retried after exportRetryInterval, a restart of carina-node starts it over.
+func (r *LogicVolumeReconciler) exportSnapshot(ctx context.Context, lv *carinav1.LogicVolume) (ctrl.Result, error) {
+	if meta.IsStatusConditionTrue(lv.Status.Conditions, utils.ConditionExported) {
+		return ctrl.Result{}, nil
+	}
+	r.exportMu.Lock()
+	if r.exports == nil {
+		r.exports = map[string]time.Time{}
+	}
+	failed, known := r.exports[lv.Name]
+	if known && failed.IsZero() {
+		r.exportMu.Unlock()
+		return ctrl.Result{}, nil
+	}
+	if wait := exportRetryInterval - time.Since(failed); known && wait > 0 {
+		r.exportMu.Unlock()
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+	r.exports[lv.Name] = time.Time{}
+	r.exportMu.Unlock()
+
+	url := populator.ExportURL(lv.Annotations[utils.SnapshotExportTarget], lv.Status.VolumeID)
+	if err := r.setCondition(ctx, lv.Name, metav1.Condition{
+		Type:    utils.ConditionExported,
+		Status:  metav1.ConditionFalse,
+		Reason:  "Uploading",
+		Message: "uploading to " + url,
+	}); err != nil {
+		r.exportMu.Lock()
+		delete(r.exports, lv.Name)
+		r.exportMu.Unlock()
+		return ctrl.Result{}, err
+	}
+
+	src := fmt.Sprintf("%s/%s/%s", r.deviceDir, lv.Spec.DeviceGroup, lv.Status.VolumeID)
+	go func() {
+		start := time.Now()
+		condition := metav1.Condition{Type: utils.ConditionExported}
+		dst, err := r.s3Source(url)
+		var image *populator.Image
+		if err == nil {
+			image, err = populator.ExportImage(context.Background(), r.throttle, src, dst)
+		}
+		r.exportMu.Lock()
+		if err != nil {
+			r.exports[lv.Name] = time.Now()
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "UploadFailed"
+			condition.Message = fmt.Sprintf("upload to %s failed: %s", url, err.Error())
+			log.Errorf("export snapshot %s failed: %s", lv.Name, condition.Message)
+		} else {
+			delete(r.exports, lv.Name)
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = "Uploaded"
+			condition.Message = fmt.Sprintf("uploaded to %s, %d chunks", url, len(image.Chunks))
+			log.Infof("exported snapshot %s to %s in %s", lv.Name, url, time.Since(start).Round(time.Second))
+		}
+		r.exportMu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := r.setCondition(ctx, lv.Name, condition); err != nil {
+			log.Warnf("update export condition of snapshot %s failed: %s", lv.Name, err.Error())
+		}
+	}()
+	return ctrl.Result{}, nil
+}
+
+// setCondition 设置LogicVolume的状态条件，冲突时重新读取后重试
+func (r *LogicVolumeReconciler) setCondition(ctx context.Context, name string, condition metav1.Condition) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		lv := &carinav1.LogicVolume{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: utils.LogicVolumeNamespace, Name: name}, lv); err != nil {
+			return err
+		}
+		condition.ObservedGeneration = lv.Generation
+		meta.SetStatusCondition(&lv.Status.Conditions, condition)
+		return r.Status().Update(ctx, lv)
+	})
+}
+
 func (r *LogicVolumeReconciler) expandLV(ctx context.Context, lv *carinav1.LogicVolume) error {
 	// The reconciliation loop of LogicVolume may call expandLV before resizing is triggered.
 	// So, lv.Status.CurrentSize could be nil here.
@@ -281,9 +750,12 @@ func (r *LogicVolumeReconciler) expandLV(ctx context.Context, lv *carinav1.Logic
 		if _, ok := lv.Annotations[utils.ExclusivityDisk]; !ok {
 			return fmt.Errorf("Extend lv %s doesn't using an exclusive disk", lv.Name)
 		}
-		err := utils.UntilMaxRetry(func() error {
-			return r.volume.ResizeVolume(lv.Name, lv.Spec.DeviceGroup, uint64(reqBytes), 1)
-		}, 10, 12*time.Second)
+		stripes, _, err := utils.StripeParameters(lv.Annotations)
+		if err == nil {
+			err = utils.UntilMaxRetry(func() error {
+				return r.volume.ResizeVolume(lv.Name, lv.Spec.DeviceGroup, uint64(reqBytes), 1, stripes)
+			}, 10, 12*time.Second)
+		}
 		if err != nil {
 			lv.Status.Code = codes.Internal
 			lv.Status.Message = err.Error()
@@ -0,0 +1,433 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/datamover"
+	"github.com/carina-io/carina/pkg/devicemanager/device"
+	"github.com/carina-io/carina/pkg/devicemanager/volume"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/mutx"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// objectStore 按路径保存对象的s3服务，failing时所有请求失败
+type objectStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	failing bool
+}
+
+func (s *objectStore) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	switch req.Method {
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(req.Body)
+		s.objects[req.URL.Path] = body
+	case http.MethodGet:
+		body, ok := s.objects[req.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	}
+}
+
+func (s *objectStore) fail(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+func (s *objectStore) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// newExportReconciler 返回使用临时设备目录和s3配置的LogicVolumeReconciler
+func newExportReconciler(t *testing.T, objects ...client.Object) (*LogicVolumeReconciler, *objectStore) {
+	store := &objectStore{objects: map[string][]byte{}}
+	server := httptest.NewServer(store)
+	t.Cleanup(server.Close)
+	config := t.TempDir()
+	for file, value := range map[string]string{
+		"endpoint":                   server.URL,
+		utils.PrefillAccessKeySecret: "carina",
+		utils.PrefillSecretKeySecret: "secret",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(config, file), []byte(value), 0600))
+	}
+
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	return &LogicVolumeReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		nodeName:  "node1",
+		throttle:  datamover.NewThrottle(),
+		s3Config:  config,
+		deviceDir: t.TempDir(),
+	}, store
+}
+
+func writeDevice(t *testing.T, path string, size int64, data []byte) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	assert.NoError(t, f.Truncate(size))
+	_, err = f.WriteAt(data, 4096)
+	assert.NoError(t, err)
+}
+
+func exportCondition(t *testing.T, r *LogicVolumeReconciler, name string) *metav1.Condition {
+	lv := &carinav1.LogicVolume{}
+	assert.NoError(t, r.Get(context.Background(), client.ObjectKey{Namespace: utils.LogicVolumeNamespace, Name: name}, lv))
+	return meta.FindStatusCondition(lv.Status.Conditions, utils.ConditionExported)
+}
+
+func TestExportSnapshot(t *testing.T) {
+	snapshot := &carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "snap-1",
+			Namespace: utils.LogicVolumeNamespace,
+			Annotations: map[string]string{
+				utils.VolumeManagerType:    utils.LvmVolumeType,
+				utils.SnapshotSource:       "pvc-1",
+				utils.SnapshotExportTarget: "s3://backup/cluster-a",
+			},
+		},
+		Spec:   carinav1.LogicVolumeSpec{NodeName: "node1", DeviceGroup: "carina-vg-ssd"},
+		Status: carinav1.LogicVolumeStatus{VolumeID: "snap-snap-1"},
+	}
+	r, store := newExportReconciler(t, snapshot)
+	data := bytes.Repeat([]byte("carina"), 1000)
+	writeDevice(t, filepath.Join(r.deviceDir, "carina-vg-ssd", "snap-snap-1"), 16<<20, data)
+
+	result, err := r.exportSnapshot(context.Background(), snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), result.RequeueAfter)
+	assert.Eventually(t, func() bool {
+		c := exportCondition(t, r, "snap-1")
+		return c != nil && c.Status == metav1.ConditionTrue
+	}, 10*time.Second, 10*time.Millisecond)
+	c := exportCondition(t, r, "snap-1")
+	assert.Equal(t, "Uploaded", c.Reason)
+	assert.Equal(t, "uploaded to s3://backup/cluster-a/snap-snap-1, 1 chunks", c.Message)
+	assert.ElementsMatch(t, []string{"/backup/cluster-a/snap-snap-1/chunks/00000000", "/backup/cluster-a/snap-snap-1/manifest.json"}, store.keys())
+
+	// 已上传的快照不再上传
+	lv := &carinav1.LogicVolume{}
+	assert.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(snapshot), lv))
+	store.fail(true)
+	_, err = r.exportSnapshot(context.Background(), lv)
+	assert.NoError(t, err)
+	assert.Equal(t, "Uploaded", exportCondition(t, r, "snap-1").Reason)
+	store.fail(false)
+
+	// 在其他节点上从s3恢复
+	volume := &carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pvc-2",
+			Namespace:   utils.LogicVolumeNamespace,
+			Annotations: map[string]string{utils.VolumeRestoreSource: "s3://backup/cluster-a/snap-snap-1"},
+		},
+		Spec: carinav1.LogicVolumeSpec{NodeName: "node2", DeviceGroup: "carina-vg-hdd"},
+	}
+	dst := filepath.Join(r.deviceDir, "carina-vg-hdd", "volume-pvc-2")
+	writeDevice(t, dst, 32<<20, nil)
+	assert.NoError(t, r.restoreLV(context.Background(), volume))
+	got, err := ioutil.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got[4096:4096+len(data)])
+	assert.Equal(t, make([]byte, 4096), got[:4096])
+
+	volume.Annotations[utils.VolumeRestoreSource] = "s3://backup/cluster-a/snap-missing"
+	assert.Error(t, r.restoreLV(context.Background(), volume))
+}
+
+func TestExportSnapshotRetry(t *testing.T) {
+	snapshot := &carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "snap-1",
+			Namespace:   utils.LogicVolumeNamespace,
+			Annotations: map[string]string{utils.SnapshotSource: "pvc-1", utils.SnapshotExportTarget: "s3://backup/cluster-a"},
+		},
+		Spec:   carinav1.LogicVolumeSpec{NodeName: "node1", DeviceGroup: "carina-vg-ssd"},
+		Status: carinav1.LogicVolumeStatus{VolumeID: "snap-snap-1"},
+	}
+	r, store := newExportReconciler(t, snapshot)
+	writeDevice(t, filepath.Join(r.deviceDir, "carina-vg-ssd", "snap-snap-1"), 8<<20, []byte("carina"))
+	store.fail(true)
+
+	_, err := r.exportSnapshot(context.Background(), snapshot)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		c := exportCondition(t, r, "snap-1")
+		return c != nil && c.Reason == "UploadFailed"
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// 失败后等待重试间隔
+	result, err := r.exportSnapshot(context.Background(), snapshot)
+	assert.NoError(t, err)
+	assert.True(t, result.RequeueAfter > exportRetryInterval-time.Minute, result.RequeueAfter)
+	assert.Equal(t, "UploadFailed", exportCondition(t, r, "snap-1").Reason)
+
+	// 重试间隔过后重新上传
+	store.fail(false)
+	r.exportMu.Lock()
+	r.exports["snap-1"] = time.Now().Add(-exportRetryInterval)
+	r.exportMu.Unlock()
+	result, err = r.exportSnapshot(context.Background(), snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), result.RequeueAfter)
+	assert.Eventually(t, func() bool {
+		c := exportCondition(t, r, "snap-1")
+		return c != nil && c.Reason == "Uploaded"
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+// teardownVolume 记录批量擦除和删除的卷，wipeErr不为空时擦除失败
+type teardownVolume struct {
+	volume.LocalVolume
+	mu      sync.Mutex
+	wiped   []string
+	deleted [][]string
+	wipeErr error
+}
+
+func (v *teardownVolume) WipeVolume(lvName, vgName string, wipe device.Wipe) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.wiped = append(v.wiped, lvName)
+	return v.wipeErr
+}
+
+func (v *teardownVolume) DeleteVolumes(lvNames []string, vgName string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.deleted = append(v.deleted, lvNames)
+	return nil
+}
+
+func (v *teardownVolume) NoticeUpdateCapacity(vgName []string) {}
+
+// failingPatch 修改指定名字的对象失败
+type failingPatch struct {
+	client.Client
+	name string
+}
+
+func (c *failingPatch) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if obj.GetName() == c.name {
+		return errors.New("apiserver unavailable")
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// teardownLV 返回命名空间ns中待回收的lvm卷
+func teardownLV(name, nodeName, deviceGroup, ns string, mutate func(lv *carinav1.LogicVolume)) *carinav1.LogicVolume {
+	now := metav1.Now()
+	lv := &carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         utils.LogicVolumeNamespace,
+			Annotations:       map[string]string{utils.VolumeManagerType: utils.LvmVolumeType},
+			Finalizers:        []string{utils.LogicVolumeFinalizer},
+			DeletionTimestamp: &now,
+		},
+		Spec: carinav1.LogicVolumeSpec{NameSpace: ns, NodeName: nodeName, DeviceGroup: deviceGroup},
+	}
+	if mutate != nil {
+		mutate(lv)
+	}
+	return lv
+}
+
+func newTeardownReconciler(t *testing.T, vm *teardownVolume, objects ...client.Object) *LogicVolumeReconciler {
+	now := metav1.Now()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	objects = append(objects,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "teardown", DeletionTimestamp: &now, Finalizers: []string{"kubernetes"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "active"}},
+	)
+	return &LogicVolumeReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Recorder: record.NewFakeRecorder(100),
+		nodeName: "node1",
+		volume:   vm,
+		pool:     mutx.NewPriorityPool(1),
+	}
+}
+
+func batchNames(batch []*carinav1.LogicVolume) []string {
+	names := []string{}
+	for _, lv := range batch {
+		names = append(names, lv.Name)
+	}
+	return names
+}
+
+func TestNamespaceTeardownBatch(t *testing.T) {
+	lv := teardownLV("pvc-1", "node1", "carina-vg-ssd", "teardown", nil)
+	objects := []client.Object{
+		lv,
+		teardownLV("pvc-2", "node1", "carina-vg-ssd", "teardown", nil),
+		teardownLV("pvc-3", "node1", "carina-vg-ssd", "active", nil),
+		teardownLV("pvc-4", "node2", "carina-vg-ssd", "teardown", nil),
+		teardownLV("pvc-5", "node1", "carina-vg-hdd", "teardown", nil),
+		// 还没有删除的卷
+		teardownLV("pvc-6", "node1", "carina-vg-ssd", "teardown", func(lv *carinav1.LogicVolume) { lv.DeletionTimestamp = nil }),
+		// 已经去掉finalizer，正在被删除的卷
+		teardownLV("pvc-7", "node1", "carina-vg-ssd", "teardown", func(lv *carinav1.LogicVolume) {
+			lv.Finalizers = []string{"carina.storage.io/other"}
+		}),
+		teardownLV("pvc-8", "node1", "carina-vg-ssd", "teardown", func(lv *carinav1.LogicVolume) {
+			lv.Annotations[utils.SnapshotSource] = "pvc-2"
+		}),
+		teardownLV("pvc-9", "node1", "carina-vg-ssd", "teardown", func(lv *carinav1.LogicVolume) {
+			lv.Annotations[utils.VolumeManagerType] = utils.RawVolumeType
+		}),
+	}
+	r := newTeardownReconciler(t, &teardownVolume{}, objects...)
+	ctx := context.Background()
+
+	batch := r.namespaceTeardownBatch(ctx, lv)
+	assert.Equal(t, []string{"pvc-1", "pvc-2"}, batchNames(batch))
+	// 命名空间没有删除时逐个回收
+	assert.Nil(t, r.namespaceTeardownBatch(ctx, teardownLV("pvc-3", "node1", "carina-vg-ssd", "active", nil)))
+	assert.Nil(t, r.namespaceTeardownBatch(ctx, teardownLV("pvc-8", "node1", "carina-vg-ssd", "teardown", func(lv *carinav1.LogicVolume) {
+		lv.Annotations[utils.SnapshotSource] = "pvc-2"
+	})))
+	assert.Nil(t, r.namespaceTeardownBatch(ctx, teardownLV("pvc-10", "node1", "carina-vg-ssd", "", nil)))
+}
+
+func TestNamespaceTeardownBatchSize(t *testing.T) {
+	objects := []client.Object{}
+	for i := 0; i < maxLVRemoveBatch+6; i++ {
+		objects = append(objects, teardownLV(fmt.Sprintf("pvc-%03d", i), "node1", "carina-vg-ssd", "teardown", nil))
+	}
+	lv := objects[len(objects)-1].(*carinav1.LogicVolume)
+	r := newTeardownReconciler(t, &teardownVolume{}, objects...)
+
+	batch := r.namespaceTeardownBatch(context.Background(), lv)
+	assert.Len(t, batch, maxLVRemoveBatch)
+	assert.Equal(t, lv.Name, batch[0].Name)
+}
+
+func TestRemoveLVBatch(t *testing.T) {
+	ctx := context.Background()
+	newObjects := func() []client.Object {
+		return []client.Object{
+			teardownLV("pvc-1", "node1", "carina-vg-ssd", "teardown", nil),
+			teardownLV("pvc-2", "node1", "carina-vg-ssd", "teardown", func(lv *carinav1.LogicVolume) {
+				lv.Annotations[utils.VolumeWipePolicy] = utils.WipePolicyZero
+			}),
+			teardownLV("pvc-3", "node1", "carina-vg-ssd", "teardown", nil),
+		}
+	}
+	exists := func(r *LogicVolumeReconciler) []string {
+		names := []string{}
+		for _, name := range []string{"pvc-1", "pvc-2", "pvc-3"} {
+			lv := &carinav1.LogicVolume{}
+			if err := r.Get(ctx, client.ObjectKey{Namespace: utils.LogicVolumeNamespace, Name: name}, lv); err == nil {
+				assert.Contains(t, lv.Finalizers, utils.LogicVolumeFinalizer)
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	t.Run("removed", func(t *testing.T) {
+		vm := &teardownVolume{}
+		objects := newObjects()
+		r := newTeardownReconciler(t, vm, objects...)
+		batch := r.namespaceTeardownBatch(ctx, objects[0].(*carinav1.LogicVolume))
+
+		assert.NoError(t, r.removeLVBatch(ctx, batch))
+		assert.Equal(t, []string{"pvc-2"}, vm.wiped)
+		assert.Equal(t, [][]string{{"pvc-1", "pvc-2", "pvc-3"}}, vm.deleted)
+		assert.Empty(t, exists(r))
+	})
+
+	t.Run("wipe failed", func(t *testing.T) {
+		// 擦除失败时不删除任何卷，finalizer全部保留
+		vm := &teardownVolume{wipeErr: errors.New("device busy")}
+		objects := newObjects()
+		r := newTeardownReconciler(t, vm, objects...)
+		batch := r.namespaceTeardownBatch(ctx, objects[0].(*carinav1.LogicVolume))
+
+		assert.Error(t, r.removeLVBatch(ctx, batch))
+		assert.Empty(t, vm.deleted)
+		assert.Equal(t, []string{"pvc-1", "pvc-2", "pvc-3"}, exists(r))
+	})
+
+	t.Run("finalizer partially removed", func(t *testing.T) {
+		vm := &teardownVolume{}
+		objects := newObjects()
+		r := newTeardownReconciler(t, vm, objects...)
+		cl := &failingPatch{Client: r.Client, name: "pvc-2"}
+		r.Client = cl
+		batch := r.namespaceTeardownBatch(ctx, objects[0].(*carinav1.LogicVolume))
+
+		assert.Error(t, r.removeLVBatch(ctx, batch))
+		assert.Equal(t, []string{"pvc-2", "pvc-3"}, exists(r))
+
+		// 重试时只回收还留着finalizer的卷
+		cl.name = ""
+		lv := &carinav1.LogicVolume{}
+		assert.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: utils.LogicVolumeNamespace, Name: "pvc-2"}, lv))
+		batch = r.namespaceTeardownBatch(ctx, lv)
+		assert.Equal(t, []string{"pvc-2", "pvc-3"}, batchNames(batch))
+		assert.NoError(t, r.removeLVBatch(ctx, batch))
+		assert.Equal(t, [][]string{{"pvc-1", "pvc-2", "pvc-3"}, {"pvc-2", "pvc-3"}}, vm.deleted)
+		assert.Empty(t, exists(r))
+	})
+}
@@ -188,6 +188,11 @@ func (r *NodeReconciler) getNeedRebuildVolume(ctx context.Context) (map[string]c
 			continue
 		}
 
+		// 副本卷的数据在另一个节点上还有一份，由ReplicaFailoverReconciler迁移pod，不能重建
+		if lv.Annotations[utils.VolumeReplicaNode] != "" {
+			continue
+		}
+
 		// 重建逻辑
 		log.Infof("lv list: %s", lv.Name)
 		if _, ok := r.cacheNoDeleteLv[lv.Name]; ok {
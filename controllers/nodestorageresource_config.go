@@ -0,0 +1,42 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/configuration"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// needUpdateConfigStatus 报告节点当前生效的配置版本及最近一次被拒绝的配置
+func needUpdateConfigStatus(status *carinav1beta1.NodeStorageResourceStatus, applied configuration.AppliedConfig) bool {
+	if applied.Revision == "" {
+		return false
+	}
+	config := &carinav1beta1.AppliedConfig{
+		Revision:         applied.Revision,
+		AppliedTime:      metav1.NewTime(applied.AppliedAt),
+		RejectedRevision: applied.RejectedRevision,
+		Error:            applied.Error,
+	}
+	if status.Config != nil && status.Config.Revision == config.Revision &&
+		status.Config.RejectedRevision == config.RejectedRevision && status.Config.Error == config.Error {
+		return false
+	}
+	status.Config = config
+	return true
+}
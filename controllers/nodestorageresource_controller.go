@@ -24,11 +24,14 @@ import (
 	"strings"
 	"time"
 
+	"github.com/carina-io/carina/pkg/capacityforecast"
 	"github.com/carina-io/carina/pkg/configuration"
 
 	"github.com/carina-io/carina/api"
 	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/device"
 	"github.com/carina-io/carina/pkg/devicemanager/partition"
+	"github.com/carina-io/carina/pkg/devicemanager/tools"
 	"github.com/carina-io/carina/pkg/devicemanager/volume"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
@@ -39,6 +42,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
@@ -57,12 +61,19 @@ type NodeStorageResourceReconciler struct {
 	client.Client
 	Log      logr.Logger
 	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 	nodeName string
 	// stop
 	StopChan  <-chan struct{}
 	volume    volume.LocalVolume
 	partition partition.LocalPartition
 	dm        *deviceManager.DeviceManager
+	// dataMovement the settings last applied to the data mover throttle
+	dataMovement *carinav1beta1.DataMovementSpec
+	// reportedReplacements the hot spare replacements an event was recorded for
+	reportedReplacements map[string]bool
+	// reportedPending the pending disks an event was recorded for
+	reportedPending map[string]bool
 }
 
 //+kubebuilder:rbac:groups=carina.storage.io,resources=nodestorageresources,verbs=get;list;watch;create;update;patch;delete
@@ -70,7 +81,7 @@ type NodeStorageResourceReconciler struct {
 
 func NewNodeStorageResourceReconciler(
 	client client.Client,
-	scheme *runtime.Scheme, nodeName string,
+	scheme *runtime.Scheme, recorder record.EventRecorder, nodeName string,
 	volume volume.LocalVolume,
 	stopChan <-chan struct{},
 	partition partition.LocalPartition,
@@ -80,6 +91,7 @@ func NewNodeStorageResourceReconciler(
 	return &NodeStorageResourceReconciler{
 		Client:    client,
 		Scheme:    scheme,
+		Recorder:  recorder,
 		nodeName:  nodeName,
 		volume:    volume,
 		StopChan:  stopChan,
@@ -114,18 +126,37 @@ func (r *NodeStorageResourceReconciler) Reconcile(ctx context.Context, req ctrl.
 	}
 
 	nsr := nodeStorageResource.DeepCopy()
+	r.applyDataMovement(nsr.Spec.DataMovement)
+	r.approvePendingDisks(nsr)
 
-	lvmNeed := r.needUpdateLvmStatus(&nsr.Status)
+	nodeLabels := r.nodeLabels(ctx)
+	lvmNeed := r.needUpdateLvmStatus(&nsr.Status, nodeLabels)
 	diskNeed := r.needUpdateDiskStatus(&nsr.Status)
 	raidNeed := r.needUpdateRaidStatus(&nsr.Status)
-
-	if lvmNeed || diskNeed || raidNeed {
+	usageNeed := r.needUpdateUsageStatus(ctx, nsr, nodeLabels)
+	fragmentationNeed := r.needUpdateFragmentationStatus(&nsr.Status)
+	toolsNeed := needUpdateToolsStatus(&nsr.Status, tools.Current())
+	historyNeed := needUpdateCapacityHistory(&nsr.Status, time.Now())
+	spareNeed := r.needUpdateSpareStatus(nsr)
+	pendingNeed := r.needUpdatePendingDiskStatus(nsr)
+	healthNeed := needUpdateHealthStatus(&nsr.Status)
+	configNeed := needUpdateConfigStatus(&nsr.Status, configuration.Applied())
+
+	if lvmNeed || diskNeed || raidNeed || usageNeed || fragmentationNeed || toolsNeed || historyNeed || spareNeed || pendingNeed || healthNeed || configNeed {
 		nsr.Status.SyncTime = metav1.Now()
 
 		if err := r.Client.Status().Update(ctx, nsr); err != nil {
 			log.Error(err, " failed to update nodeStorageResource status name ", nsr.Name)
 		}
 	}
+	// thin pool的使用率变化不会触发调谐，开启usageThreshold时定期检查，pv触发的请求不重复排队
+	if req.Name == r.nodeName && configuration.UsageThreshold() > 0 {
+		return ctrl.Result{RequeueAfter: usageCheckInterval}, nil
+	}
+	// 容量历史每小时采样一次
+	if req.Name == r.nodeName && configuration.CapacityHistorySamples() > 0 {
+		return ctrl.Result{RequeueAfter: capacityforecast.HistoryInterval}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
@@ -177,7 +208,16 @@ func (r *NodeStorageResourceReconciler) SetupWithManager(mgr ctrl.Manager) error
 				}
 				return false
 			},
-			UpdateFunc:  func(event.UpdateEvent) bool { return false },
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				// 只关注数据搬迁配置及磁盘批准注解的变更，status由本控制器自己更新
+				o := e.ObjectOld.(*carinav1beta1.NodeStorageResource)
+				n := e.ObjectNew.(*carinav1beta1.NodeStorageResource)
+				if o == nil || n == nil || n.Spec.NodeName != r.nodeName {
+					return false
+				}
+				return !equality.Semantic.DeepEqual(o.Spec.DataMovement, n.Spec.DataMovement) ||
+					o.Annotations[utils.ApprovedDisks] != n.Annotations[utils.ApprovedDisks]
+			},
 			GenericFunc: func(event.GenericEvent) bool { return false },
 		})
 
@@ -253,6 +293,27 @@ func (r *NodeStorageResourceReconciler) ensureNodeStorageResourceExist() error {
 	return nil
 }
 
+// applyDataMovement configures the data mover throttle of the node from the NodeStorageResource spec
+func (r *NodeStorageResourceReconciler) applyDataMovement(spec *carinav1beta1.DataMovementSpec) {
+	if r.dm == nil || r.dm.Throttle == nil || equality.Semantic.DeepEqual(spec, r.dataMovement) {
+		return
+	}
+	windows := []string{}
+	bandwidth := int64(0)
+	if spec != nil {
+		windows = spec.Windows
+		if spec.Bandwidth != nil {
+			bandwidth = spec.Bandwidth.Value()
+		}
+	}
+	if err := r.dm.Throttle.Update(windows, bandwidth); err != nil {
+		log.Errorf("invalid dataMovement of nodestorageresource %s, keep %s: %s", r.nodeName, r.dm.Throttle.String(), err.Error())
+		return
+	}
+	r.dataMovement = spec.DeepCopy()
+	log.Infof("data movement of node %s: %s", r.nodeName, r.dm.Throttle.String())
+}
+
 func (r *NodeStorageResourceReconciler) createNodeStorageResource(ctx context.Context) error {
 	NodeStorageResource := &carinav1beta1.NodeStorageResource{
 		TypeMeta: metav1.TypeMeta{
@@ -291,20 +352,28 @@ func (r *NodeStorageResourceReconciler) deleteNodeStorageResource(ctx context.Co
 	return nil
 }
 
+// nodeLabels 节点的标签，用于匹配按节点标签配置的预留容量
+func (r *NodeStorageResourceReconciler) nodeLabels(ctx context.Context) map[string]string {
+	node := &corev1.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: r.nodeName}, node); err != nil {
+		log.Warnf("get node %s error %s", r.nodeName, err.Error())
+		return nil
+	}
+	return node.Labels
+}
+
 // Determine whether the LVM volume needs to be updated
-func (r *NodeStorageResourceReconciler) needUpdateLvmStatus(status *carinav1beta1.NodeStorageResourceStatus) bool {
+func (r *NodeStorageResourceReconciler) needUpdateLvmStatus(status *carinav1beta1.NodeStorageResourceStatus, nodeLabels map[string]string) bool {
 	vgs, err := r.volume.GetCurrentVgStruct()
 	if err != nil {
 		return false
 	}
 	if !equality.Semantic.DeepEqual(vgs, status.VgGroups) {
 		status.VgGroups = vgs
+		status.DiskProfiles = diskProfiles(vgs)
 		for _, v := range vgs {
 			sizeGb := v.VGSize>>30 + 1
-			freeGb := uint64(0)
-			if v.VGFree > utils.DefaultReservedSpace {
-				freeGb = (v.VGFree - utils.DefaultReservedSpace) >> 30
-			}
+			freeGb := vgAllocatable(v, nodeLabels)
 			if status.Capacity == nil {
 				status.Capacity = make(map[string]resource.Quantity)
 			}
@@ -319,6 +388,26 @@ func (r *NodeStorageResourceReconciler) needUpdateLvmStatus(status *carinav1beta
 	return false
 }
 
+// diskProfiles 从pv标签中读取磁盘加入vg前的测试结果
+func diskProfiles(vgs []api.VgGroup) []api.DiskProfile {
+	profiles := []api.DiskProfile{}
+	for _, vg := range vgs {
+		for _, pv := range vg.PVS {
+			p, ok := device.ParseProfileTag(pv.PVTags)
+			if !ok {
+				continue
+			}
+			p.Disk = pv.PVName
+			p.DeviceGroup = vg.VGName
+			profiles = append(profiles, p)
+		}
+	}
+	if len(profiles) == 0 {
+		return nil
+	}
+	return profiles
+}
+
 // Determine whether the Disk needs to be updated
 func (r *NodeStorageResourceReconciler) needUpdateDiskStatus(status *carinav1beta1.NodeStorageResourceStatus) bool {
 
@@ -377,6 +466,8 @@ func (r *NodeStorageResourceReconciler) needUpdateDiskStatus(status *carinav1bet
 		for _, disk := range diskSet {
 			tmp := api.Disk{}
 			utils.Fill(disk, &tmp)
+			tmp.FailureDomain = device.FailureDomain(disk.Path)
+			fillDiskInventory(&tmp)
 			disks = append(disks, tmp)
 		}
 
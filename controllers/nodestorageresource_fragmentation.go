@@ -0,0 +1,107 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/pkg/rebalance"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// needUpdateFragmentationStatus 报告空闲空间碎片达到fragmentationThreshold的vg及整理建议
+func (r *NodeStorageResourceReconciler) needUpdateFragmentationStatus(status *carinav1beta1.NodeStorageResourceStatus) bool {
+	threshold := configuration.FragmentationThreshold()
+	if threshold == 0 {
+		if status.Fragmentation == nil && meta.FindStatusCondition(status.Conditions, utils.ConditionFragmentation) == nil {
+			return false
+		}
+		status.Fragmentation = nil
+		meta.RemoveStatusCondition(&status.Conditions, utils.ConditionFragmentation)
+		return true
+	}
+
+	fragmented := []carinav1beta1.VGFragmentation{}
+	for _, vg := range status.VgGroups {
+		segments := map[string][]types.PVSegment{}
+		for _, pv := range vg.PVS {
+			if pv == nil {
+				continue
+			}
+			s, err := r.dm.LvmManager.PVSegments(pv.PVName)
+			if err != nil {
+				log.Errorf("list segments of pv %s error %s", pv.PVName, err.Error())
+				return false
+			}
+			segments[pv.PVName] = s
+		}
+		if frag, ok := rebalance.Fragmentation(vg, segments); ok && frag.Percent >= threshold {
+			fragmented = append(fragmented, frag)
+		}
+	}
+	if len(fragmented) == 0 {
+		fragmented = nil
+	}
+
+	conditions := append([]metav1.Condition{}, status.Conditions...)
+	meta.SetStatusCondition(&conditions, fragmentationCondition(fragmented))
+	if equality.Semantic.DeepEqual(fragmented, status.Fragmentation) && equality.Semantic.DeepEqual(conditions, status.Conditions) {
+		return false
+	}
+	for _, frag := range fragmented {
+		log.Infof("vg %s is %d%% fragmented, largest free segment %d of %d bytes, plan %v", frag.DeviceGroup, frag.Percent, frag.LargestFree, frag.Free, frag.Plan)
+	}
+	status.Fragmentation = fragmented
+	status.Conditions = conditions
+	return true
+}
+
+func fragmentationCondition(fragmented []carinav1beta1.VGFragmentation) metav1.Condition {
+	if len(fragmented) == 0 {
+		return metav1.Condition{
+			Type:    utils.ConditionFragmentation,
+			Status:  metav1.ConditionFalse,
+			Reason:  "NotFragmented",
+			Message: "the free space of every volume group is below the fragmentation threshold",
+		}
+	}
+	msgs := []string{}
+	for _, frag := range fragmented {
+		largest := resource.NewQuantity(int64(frag.LargestFree), resource.BinarySI)
+		free := resource.NewQuantity(int64(frag.Free), resource.BinarySI)
+		msg := fmt.Sprintf("%s is %d%% fragmented, largest free segment %s of %s", frag.DeviceGroup, frag.Percent, largest.String(), free.String())
+		if frag.DrainPV != "" {
+			msg += fmt.Sprintf(", draining %s frees it in one piece", frag.DrainPV)
+		}
+		msgs = append(msgs, msg)
+	}
+	return metav1.Condition{
+		Type:    utils.ConditionFragmentation,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Fragmented",
+		Message: strings.Join(msgs, "; "),
+	}
+}
@@ -0,0 +1,112 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/carina-io/carina/api"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/capacityforecast"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/csidriver/filesystem"
+	"github.com/carina-io/carina/utils"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const diskHealthy = "Healthy"
+
+// problemConditions 为True时节点存储不健康的condition
+var problemConditions = []string{utils.ConditionToolsMissing, utils.ConditionFragmentation, utils.ConditionCapacityExhaustion}
+
+// needUpdateCapacityHistory 每小时记录各磁盘组的allocatable和used
+func needUpdateCapacityHistory(status *carinav1beta1.NodeStorageResourceStatus, now time.Time) bool {
+	history := capacityforecast.RecordHistory(status.CapacityHistory,
+		capacityforecast.GroupCapacity(status.Capacity, status.Allocatable), now, configuration.CapacityHistorySamples())
+	if equality.Semantic.DeepEqual(history, status.CapacityHistory) {
+		return false
+	}
+	status.CapacityHistory = history
+	return true
+}
+
+// needUpdateHealthStatus 汇总其他condition、污点和磁盘健康状态，须在其余状态更新之后调用
+func needUpdateHealthStatus(status *carinav1beta1.NodeStorageResourceStatus) bool {
+	conditions := append([]metav1.Condition{}, status.Conditions...)
+	meta.SetStatusCondition(&conditions, healthCondition(status))
+	if equality.Semantic.DeepEqual(conditions, status.Conditions) {
+		return false
+	}
+	status.Conditions = conditions
+	return true
+}
+
+func healthCondition(status *carinav1beta1.NodeStorageResourceStatus) metav1.Condition {
+	problems := []string{}
+	for _, t := range problemConditions {
+		if meta.IsStatusConditionTrue(status.Conditions, t) {
+			problems = append(problems, t)
+		}
+	}
+	for _, t := range status.Taints {
+		group := t.DeviceGroup
+		if t.ThinPool != "" {
+			group = t.DeviceGroup + "/" + t.ThinPool
+		}
+		problems = append(problems, fmt.Sprintf("%s %d%% used", group, t.Usage))
+	}
+	for _, d := range status.Disks {
+		if d.Health != "" && d.Health != diskHealthy {
+			problems = append(problems, d.Health)
+		}
+	}
+	if len(problems) == 0 {
+		return metav1.Condition{
+			Type:    utils.ConditionHealthy,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Healthy",
+			Message: "no storage problem reported on the node",
+		}
+	}
+	return metav1.Condition{
+		Type:    utils.ConditionHealthy,
+		Status:  metav1.ConditionFalse,
+		Reason:  "ProblemsFound",
+		Message: strings.Join(problems, ", "),
+	}
+}
+
+// fillDiskInventory 补充磁盘的序列号、WWN和健康状态
+func fillDiskInventory(disk *api.Disk) {
+	props := disk.UdevInfo.Properties
+	disk.Serial = props["ID_SERIAL_SHORT"]
+	if disk.Serial == "" {
+		disk.Serial = props["ID_SERIAL"]
+	}
+	disk.WWN = props["ID_WWN_WITH_EXTENSION"]
+	if disk.WWN == "" {
+		disk.WWN = props["ID_WWN"]
+	}
+	disk.Health = diskHealthy
+	if problems := filesystem.DiskErrors(disk.Path); problems != "" {
+		disk.Health = problems
+	}
+}
@@ -0,0 +1,79 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// needUpdatePendingDiskStatus 报告等待批准加入vg的磁盘，每块新发现的磁盘产生一个事件
+func (r *NodeStorageResourceReconciler) needUpdatePendingDiskStatus(nsr *carinav1beta1.NodeStorageResource) bool {
+	if r.dm == nil {
+		return false
+	}
+	pending := r.dm.PendingDisks()
+	reported := map[string]bool{}
+	for _, p := range pending {
+		key := p.DeviceGroup + "/" + p.ID
+		reported[key] = true
+		if r.reportedPending[key] {
+			continue
+		}
+		r.Recorder.Event(nsr, corev1.EventTypeNormal, "DiskPendingApproval", pendingDiskMessage(p))
+	}
+	r.reportedPending = reported
+
+	if equality.Semantic.DeepEqual(pending, nsr.Status.PendingDisks) {
+		return false
+	}
+	nsr.Status.PendingDisks = pending
+	return true
+}
+
+// approvePendingDisks 批准注解包含待加入的磁盘时立即扫描磁盘，不等待下一个扫描周期
+func (r *NodeStorageResourceReconciler) approvePendingDisks(nsr *carinav1beta1.NodeStorageResource) {
+	if r.dm == nil {
+		return
+	}
+	approved := map[string]bool{}
+	for _, id := range strings.Split(nsr.Annotations[utils.ApprovedDisks], ",") {
+		approved[strings.TrimSpace(id)] = true
+	}
+	for _, p := range nsr.Status.PendingDisks {
+		if approved[p.ID] {
+			r.dm.RequestDeviceScan()
+			return
+		}
+	}
+}
+
+func pendingDiskMessage(p carinav1beta1.PendingDisk) string {
+	size := resource.NewQuantity(int64(p.Size), resource.BinarySI).String()
+	what := fmt.Sprintf("physical volume %s (%s, %s) would be", p.Disk, p.ID, size)
+	if p.Action == deviceManager.OnboardFormat {
+		what = fmt.Sprintf("blank disk %s (%s, %s) would be formatted and", p.Disk, p.ID, size)
+	}
+	return fmt.Sprintf("%s added to %s, approve it with the annotation %s=%s", what, p.DeviceGroup, utils.ApprovedDisks, p.ID)
+}
@@ -0,0 +1,53 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// needUpdateSpareStatus 报告节点上的热备盘和替换记录，每条新的替换记录产生一个事件
+func (r *NodeStorageResourceReconciler) needUpdateSpareStatus(nsr *carinav1beta1.NodeStorageResource) bool {
+	if r.dm == nil {
+		return false
+	}
+	spares, replacements := r.dm.HotSpareStatus()
+	if r.reportedReplacements == nil {
+		r.reportedReplacements = map[string]bool{}
+	}
+	for _, rp := range replacements {
+		key := rp.DeviceGroup + "/" + rp.Time.UTC().String()
+		if r.reportedReplacements[key] {
+			continue
+		}
+		r.reportedReplacements[key] = true
+		if rp.Spare == "" {
+			r.Recorder.Event(nsr, corev1.EventTypeWarning, "HotSpareUnavailable", rp.Message)
+		} else {
+			r.Recorder.Event(nsr, corev1.EventTypeNormal, "HotSpareReplaced", rp.Message)
+		}
+	}
+
+	if equality.Semantic.DeepEqual(spares, nsr.Status.HotSpares) && equality.Semantic.DeepEqual(replacements, nsr.Status.SpareReplacements) {
+		return false
+	}
+	nsr.Status.HotSpares = spares
+	nsr.Status.SpareReplacements = replacements
+	return true
+}
@@ -0,0 +1,53 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/devicemanager/tools"
+	"github.com/carina-io/carina/utils"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// needUpdateToolsStatus 报告节点上缺失的工具及受影响的功能
+func needUpdateToolsStatus(status *carinav1beta1.NodeStorageResourceStatus, report *tools.Report) bool {
+	if report == nil {
+		return false
+	}
+	condition := metav1.Condition{
+		Type:    utils.ConditionToolsMissing,
+		Status:  metav1.ConditionFalse,
+		Reason:  "ToolsAvailable",
+		Message: fmt.Sprintf("the tools of every feature are available on this %s node", report.Platform),
+	}
+	if len(report.Missing) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "ToolsMissing"
+		condition.Message = fmt.Sprintf("missing on this %s node, %s", report.Platform, report.Summary())
+	}
+	conditions := append([]metav1.Condition{}, status.Conditions...)
+	meta.SetStatusCondition(&conditions, condition)
+	if equality.Semantic.DeepEqual(conditions, status.Conditions) {
+		return false
+	}
+	status.Conditions = conditions
+	return true
+}
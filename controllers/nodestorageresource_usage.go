@@ -0,0 +1,213 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/carina-io/carina/api"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/pkg/devicemanager/volume"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// usageCheckInterval 开启usageThreshold时检查vg和thin pool使用率的间隔
+const usageCheckInterval = time.Minute
+
+// vgUsage vg已使用的百分比
+func vgUsage(vg api.VgGroup) int {
+	if vg.VGSize == 0 {
+		return 0
+	}
+	return int((vg.VGSize - vg.VGFree) * 100 / vg.VGSize)
+}
+
+// vgAllocatable vg可分配的容量(GiB)，扣除reservedCapacity配置的预留，没有配置时预留DefaultReservedSpace
+func vgAllocatable(vg api.VgGroup, nodeLabels map[string]string) int64 {
+	reserved := configuration.ReservedBytes(configuration.ReservedCapacity(), vg.VGName, vg.VGSize, nodeLabels)
+	if vg.VGFree > reserved {
+		return int64((vg.VGFree - reserved) >> 30)
+	}
+	return 0
+}
+
+// storageTaints returns the volume groups and thin pools at or above threshold.
+// Taints that were already present keep their Since, so it records when the usage first crossed.
+func storageTaints(vgs []api.VgGroup, lvs []types.LvInfo, threshold int, current []carinav1beta1.StorageTaint, now metav1.Time) []carinav1beta1.StorageTaint {
+	if threshold <= 0 {
+		return nil
+	}
+	since := map[string]metav1.Time{}
+	for _, t := range current {
+		since[t.DeviceGroup+"/"+t.ThinPool] = t.Since
+	}
+	taints := []carinav1beta1.StorageTaint{}
+	add := func(group, pool string, usage int) {
+		t := carinav1beta1.StorageTaint{DeviceGroup: group, ThinPool: pool, Usage: usage, Since: now}
+		if s, ok := since[group+"/"+pool]; ok {
+			t.Since = s
+		}
+		taints = append(taints, t)
+	}
+	for _, vg := range vgs {
+		if usage := vgUsage(vg); usage >= threshold {
+			add(vg.VGName, "", usage)
+		}
+	}
+	for _, lv := range lvs {
+		// thin pool的lv_attr以t开头，data_percent是池的使用率
+		if !strings.HasPrefix(lv.LVName, volume.THIN) || !strings.HasPrefix(lv.LVAttr, "t") {
+			continue
+		}
+		if usage := int(lv.DataPercent); usage >= threshold {
+			add(lv.VGName, lv.LVName, usage)
+		}
+	}
+	if len(taints) == 0 {
+		return nil
+	}
+	sort.Slice(taints, func(i, j int) bool {
+		if taints[i].DeviceGroup != taints[j].DeviceGroup {
+			return taints[i].DeviceGroup < taints[j].DeviceGroup
+		}
+		return taints[i].ThinPool < taints[j].ThinPool
+	})
+	return taints
+}
+
+// needUpdateUsageStatus 更新vg和thin pool的污点，被标记的vg可分配容量为0
+func (r *NodeStorageResourceReconciler) needUpdateUsageStatus(ctx context.Context, nsr *carinav1beta1.NodeStorageResource, nodeLabels map[string]string) bool {
+	status := &nsr.Status
+	threshold := configuration.UsageThreshold()
+	lvs := []types.LvInfo{}
+	if threshold > 0 {
+		var err error
+		lvs, err = r.volume.VolumeList("", "")
+		if err != nil {
+			log.Errorf("list lv error %s", err.Error())
+			return false
+		}
+	}
+	taints := storageTaints(status.VgGroups, lvs, threshold, status.Taints, metav1.Now())
+
+	needUpdate := false
+	if !equality.Semantic.DeepEqual(taints, status.Taints) {
+		r.recordTaints(ctx, nsr, status.Taints, taints, threshold)
+		status.Taints = taints
+		needUpdate = true
+	}
+
+	tainted := map[string]bool{}
+	for _, t := range taints {
+		if t.ThinPool == "" {
+			tainted[t.DeviceGroup] = true
+		}
+	}
+	for _, vg := range status.VgGroups {
+		free := vgAllocatable(vg, nodeLabels)
+		if tainted[vg.VGName] {
+			free = 0
+		}
+		key := fmt.Sprintf("%s%s", utils.DeviceCapacityKeyPrefix, vg.VGName)
+		if q, ok := status.Allocatable[key]; ok && q.Value() == free {
+			continue
+		}
+		if status.Allocatable == nil {
+			status.Allocatable = make(map[string]resource.Quantity)
+		}
+		status.Allocatable[key] = *resource.NewQuantity(free, resource.BinarySI)
+		needUpdate = true
+	}
+	return needUpdate
+}
+
+// recordTaints 对新增和解除的污点记录事件，thin pool的事件记录在pvc上
+func (r *NodeStorageResourceReconciler) recordTaints(ctx context.Context, nsr *carinav1beta1.NodeStorageResource, old, taints []carinav1beta1.StorageTaint, threshold int) {
+	if r.Recorder == nil {
+		return
+	}
+	before := map[string]bool{}
+	for _, t := range old {
+		before[t.DeviceGroup+"/"+t.ThinPool] = true
+	}
+	after := map[string]bool{}
+	for _, t := range taints {
+		key := t.DeviceGroup + "/" + t.ThinPool
+		after[key] = true
+		if before[key] {
+			continue
+		}
+		if t.ThinPool == "" {
+			log.Warnf("volume group %s usage %d%% reached threshold %d%%, no new volumes are placed in it", t.DeviceGroup, t.Usage, threshold)
+			r.Recorder.Eventf(nsr, corev1.EventTypeWarning, "VolumeGroupNearlyFull", "volume group %s usage %d%% reached threshold %d%%, no new volumes are placed in it", t.DeviceGroup, t.Usage, threshold)
+			continue
+		}
+		log.Warnf("thin pool %s/%s usage %d%% reached threshold %d%%", t.DeviceGroup, t.ThinPool, t.Usage, threshold)
+		r.Recorder.Eventf(nsr, corev1.EventTypeWarning, "ThinPoolNearlyFull", "thin pool %s/%s usage %d%% reached threshold %d%%", t.DeviceGroup, t.ThinPool, t.Usage, threshold)
+		r.markVolume(ctx, t, true, threshold)
+	}
+	for _, t := range old {
+		key := t.DeviceGroup + "/" + t.ThinPool
+		if after[key] {
+			continue
+		}
+		if t.ThinPool == "" {
+			log.Infof("volume group %s usage is below threshold %d%% again", t.DeviceGroup, threshold)
+			r.Recorder.Eventf(nsr, corev1.EventTypeNormal, "VolumeGroupUsageNormal", "volume group %s usage is below threshold %d%% again", t.DeviceGroup, threshold)
+			continue
+		}
+		log.Infof("thin pool %s/%s usage is below threshold %d%% again", t.DeviceGroup, t.ThinPool, threshold)
+		r.markVolume(ctx, t, false, threshold)
+	}
+}
+
+// markVolume 在thin pool所属卷的pvc上记录事件，pod的condition由carina-controller根据污点设置
+func (r *NodeStorageResourceReconciler) markVolume(ctx context.Context, t carinav1beta1.StorageTaint, full bool, threshold int) {
+	// thin-<pv名称>，LogicVolume与pv同名
+	name := strings.TrimPrefix(t.ThinPool, volume.THIN)
+	lv := &carinav1.LogicVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: utils.LogicVolumeNamespace}, lv); err != nil || lv.Spec.Pvc == "" {
+		return
+	}
+	// carina-node没有读取pvc的权限，事件通过pv的claimRef记录到pvc上
+	pv := &corev1.PersistentVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: name}, pv); err == nil && pv.Spec.ClaimRef != nil {
+		pvc := pv.Spec.ClaimRef.DeepCopy()
+		if pvc.Kind == "" {
+			pvc.Kind = "PersistentVolumeClaim"
+			pvc.APIVersion = "v1"
+		}
+		if full {
+			r.Recorder.Eventf(pvc, corev1.EventTypeWarning, "VolumeNearlyFull", "thin pool of the volume is %d%% used, threshold %d%%, expand the pvc or delete snapshots", t.Usage, threshold)
+		} else {
+			r.Recorder.Eventf(pvc, corev1.EventTypeNormal, "VolumeUsageNormal", "thin pool of the volume is below threshold %d%% again", threshold)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carina-io/carina/api"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStorageTaints(t *testing.T) {
+	now := metav1.NewTime(time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC))
+	earlier := metav1.NewTime(now.Add(-time.Hour))
+	vgs := []api.VgGroup{
+		{VGName: "carina-vg-ssd", VGSize: 100 << 30, VGFree: 5 << 30},
+		{VGName: "carina-vg-hdd", VGSize: 100 << 30, VGFree: 50 << 30},
+		{VGName: "carina-vg-empty"},
+	}
+	lvs := []types.LvInfo{
+		{LVName: "thin-pvc-1", VGName: "carina-vg-hdd", LVAttr: "twi-aotz--", DataPercent: 92.5},
+		{LVName: "thin-pvc-2", VGName: "carina-vg-hdd", LVAttr: "twi-aotz--", DataPercent: 10},
+		// 精简卷本身不是thin pool
+		{LVName: "thin-pvc-3", VGName: "carina-vg-hdd", LVAttr: "Vwi-aotz--", DataPercent: 99},
+		{LVName: "volume-pvc-4", VGName: "carina-vg-hdd", LVAttr: "-wi-ao----", DataPercent: 99},
+	}
+
+	table := []struct {
+		threshold int
+		current   []carinav1beta1.StorageTaint
+		expect    []carinav1beta1.StorageTaint
+	}{
+		{threshold: 0, expect: nil},
+		{threshold: 99, expect: nil},
+		{threshold: 90, expect: []carinav1beta1.StorageTaint{
+			{DeviceGroup: "carina-vg-hdd", ThinPool: "thin-pvc-1", Usage: 92, Since: now},
+			{DeviceGroup: "carina-vg-ssd", Usage: 95, Since: now},
+		}},
+		{threshold: 50, expect: []carinav1beta1.StorageTaint{
+			{DeviceGroup: "carina-vg-hdd", Usage: 50, Since: now},
+			{DeviceGroup: "carina-vg-hdd", ThinPool: "thin-pvc-1", Usage: 92, Since: now},
+			{DeviceGroup: "carina-vg-ssd", Usage: 95, Since: now},
+		}},
+		// 已存在的污点保留最初的时间，已恢复的污点被移除
+		{threshold: 90, current: []carinav1beta1.StorageTaint{
+			{DeviceGroup: "carina-vg-hdd", Usage: 60, Since: earlier},
+			{DeviceGroup: "carina-vg-ssd", Usage: 91, Since: earlier},
+		}, expect: []carinav1beta1.StorageTaint{
+			{DeviceGroup: "carina-vg-hdd", ThinPool: "thin-pvc-1", Usage: 92, Since: now},
+			{DeviceGroup: "carina-vg-ssd", Usage: 95, Since: earlier},
+		}},
+	}
+
+	for _, d := range table {
+		assert.Equal(t, d.expect, storageTaints(vgs, lvs, d.threshold, d.current, now), "threshold %d", d.threshold)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/troubleshoot"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/carina-io/carina/utils/mutx"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// OrphanCollector 定期对比节点上的卷与LogicVolume、PV，报告并回收孤儿卷
+// Every Interval it lists the volumes and partitions carina created on the node, the LogicVolumes
+// of the node and the carina PersistentVolumes and reports those missing their counterpart in the
+// orphans of the NodeStorageResource. Volumes without LogicVolume and LogicVolumes without
+// PersistentVolume are deleted once they were orphans for orphanGracePeriod, unless orphanDryRun
+// is set; a LogicVolume is only annotated with carina.storage.io/collect-orphan and deleted by
+// carina-controller. The time an orphan was found is kept in the status, a restart of carina-node
+// does not restart the grace period.
+type OrphanCollector struct {
+	client.Client
+	Recorder record.EventRecorder
+	NodeName string
+	Trouble  *troubleshoot.Trouble
+	Pool     *mutx.PriorityPool
+	Interval time.Duration
+}
+
+var _ manager.LeaderElectionRunnable = &OrphanCollector{}
+
+// SetupWithManager adds the collector to the manager
+func (r *OrphanCollector) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Interval == 0 {
+		r.Interval = 600 * time.Second
+	}
+	return mgr.Add(r)
+}
+
+// Start implements controller-runtime's manager.Runnable.
+func (r *OrphanCollector) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		log.Info("volume consistency check...")
+		err := r.Pool.Run(ctx, mutx.PriorityBackground, "volume consistency check", func() error {
+			return r.collect(ctx, time.Now())
+		})
+		if err != nil {
+			log.Warnf("volume consistency check failed: %s", err.Error())
+		}
+	}
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (r *OrphanCollector) NeedLeaderElection() bool {
+	return false
+}
+
+func (r *OrphanCollector) collect(ctx context.Context, now time.Time) error {
+	nsr := &carinav1beta1.NodeStorageResource{}
+	if err := r.Get(ctx, client.ObjectKey{Name: r.NodeName}, nsr); err != nil {
+		return err
+	}
+	local, err := r.Trouble.LocalVolumes()
+	if err != nil {
+		return err
+	}
+	lvList := &carinav1.LogicVolumeList{}
+	if err := r.List(ctx, lvList, client.MatchingFields{"nodeName": r.NodeName}); err != nil {
+		return err
+	}
+	pvList := &corev1.PersistentVolumeList{}
+	if err := r.List(ctx, pvList); err != nil {
+		return err
+	}
+
+	orphans := troubleshoot.TrackOrphans(nsr.Status.Orphans, troubleshoot.FindOrphans(r.NodeName, local, lvList.Items, pvList.Items), now, configuration.OrphanGracePeriod())
+	dryRun := configuration.OrphanDryRun()
+	var remaining []carinav1beta1.OrphanVolume
+	for _, o := range orphans {
+		if o.Since.Time.Equal(now) {
+			r.Recorder.Event(nsr, corev1.EventTypeWarning, "OrphanFound", orphanMessage(o))
+		}
+		if o.CollectAfter == nil || now.Before(o.CollectAfter.Time) {
+			remaining = append(remaining, o)
+			continue
+		}
+		if dryRun {
+			r.Recorder.Event(nsr, corev1.EventTypeNormal, "OrphanDryRun", "would delete "+orphanMessage(o))
+			remaining = append(remaining, o)
+			continue
+		}
+		if err := r.delete(ctx, o, local); err != nil {
+			log.Errorf("delete orphan %s %s failed: %s", o.Kind, o.Name, err.Error())
+			r.Recorder.Event(nsr, corev1.EventTypeWarning, "OrphanCollectFailed", fmt.Sprintf("delete %s: %s", orphanMessage(o), err.Error()))
+			remaining = append(remaining, o)
+			continue
+		}
+		if o.Kind == troubleshoot.OrphanLogicVolume {
+			log.Warnf("requested deletion of orphan %s", orphanMessage(o))
+			r.Recorder.Event(nsr, corev1.EventTypeNormal, "OrphanCollected", "requested deletion of "+orphanMessage(o))
+			continue
+		}
+		log.Warnf("deleted orphan %s", orphanMessage(o))
+		r.Recorder.Event(nsr, corev1.EventTypeNormal, "OrphanCollected", "deleted "+orphanMessage(o))
+	}
+
+	if equality.Semantic.DeepEqual(remaining, nsr.Status.Orphans) {
+		return nil
+	}
+	nsr2 := nsr.DeepCopy()
+	nsr2.Status.Orphans = remaining
+	return r.Status().Patch(ctx, nsr2, client.MergeFromWithOptions(nsr, client.MergeFromWithOptimisticLock{}))
+}
+
+func (r *OrphanCollector) delete(ctx context.Context, o carinav1beta1.OrphanVolume, local []troubleshoot.LocalVolume) error {
+	switch o.Kind {
+	case troubleshoot.OrphanVolume:
+		for _, v := range local {
+			if v.Name == o.Name {
+				return r.Trouble.DeleteLocalVolume(v)
+			}
+		}
+		return nil
+	case troubleshoot.OrphanLogicVolume:
+		lv := &carinav1.LogicVolume{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: utils.LogicVolumeNamespace, Name: o.Name}, lv); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		// 删除前再次确认pv不存在
+		pv := &corev1.PersistentVolume{}
+		err := r.Get(ctx, client.ObjectKey{Name: o.Name}, pv)
+		if err == nil {
+			return fmt.Errorf("persistentvolume %s exists", o.Name)
+		}
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		// carina-node没有删除LogicVolume的权限，由carina-controller确认后删除
+		if lv.Annotations[utils.CollectOrphan] == r.NodeName {
+			return nil
+		}
+		lv2 := lv.DeepCopy()
+		if lv2.Annotations == nil {
+			lv2.Annotations = map[string]string{}
+		}
+		lv2.Annotations[utils.CollectOrphan] = r.NodeName
+		return client.IgnoreNotFound(r.Patch(ctx, lv2, client.MergeFromWithOptions(lv, client.MergeFromWithOptimisticLock{})))
+	}
+	return nil
+}
+
+func orphanMessage(o carinav1beta1.OrphanVolume) string {
+	if o.DeviceGroup != "" {
+		return fmt.Sprintf("%s %s in %s: %s", o.Kind, o.Name, o.DeviceGroup, o.Reason)
+	}
+	return fmt.Sprintf("%s %s: %s", o.Kind, o.Name, o.Reason)
+}
@@ -0,0 +1,135 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// OrphanVolumeReconciler 删除carina-node报告的孤儿LogicVolume
+// carina-node may not delete LogicVolumes, the OrphanCollector annotates an orphan LogicVolume of
+// its node with carina.storage.io/collect-orphan instead. The LogicVolume is deleted only if the
+// annotation names the node of the LogicVolume, it is neither a snapshot nor owned by another object
+// and the api server has no PersistentVolume of it, otherwise the annotation is removed again.
+type OrphanVolumeReconciler struct {
+	client.Client
+	APIReader client.Reader
+	Recorder  record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch;update;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch
+
+func (r *OrphanVolumeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	lv := &carinav1.LogicVolume{}
+	if err := r.Get(ctx, req.NamespacedName, lv); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	node, ok := lv.Annotations[utils.CollectOrphan]
+	if !ok || lv.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	reason := ""
+	switch {
+	case node != lv.Spec.NodeName:
+		reason = fmt.Sprintf("requested by node %s, the volume is on node %s", node, lv.Spec.NodeName)
+	case lv.Annotations[utils.SnapshotSource] != "":
+		reason = fmt.Sprintf("the volume is a snapshot of %s", lv.Annotations[utils.SnapshotSource])
+	case len(lv.OwnerReferences) > 0:
+		// bcache的缓存卷、副本卷的第二个副本随所属对象删除
+		reason = fmt.Sprintf("the volume is owned by %s %s", lv.OwnerReferences[0].Kind, lv.OwnerReferences[0].Name)
+	default:
+		pv, err := r.persistentVolume(ctx, lv)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if pv != "" {
+			reason = fmt.Sprintf("persistentvolume %s exists", pv)
+		}
+	}
+	if reason != "" {
+		log.Warnf("reject deletion of orphan LogicVolume %s: %s", lv.Name, reason)
+		r.Recorder.Event(lv, corev1.EventTypeWarning, "OrphanCollectRejected", reason)
+		delete(lv.Annotations, utils.CollectOrphan)
+		return ctrl.Result{}, client.IgnoreNotFound(r.Update(ctx, lv))
+	}
+
+	if err := r.Delete(ctx, lv, client.Preconditions{UID: &lv.UID}); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	log.Warnf("deleted orphan LogicVolume %s of node %s", lv.Name, node)
+	return ctrl.Result{}, nil
+}
+
+// persistentVolume returns the PersistentVolume of the LogicVolume, it is named after the LogicVolume
+// or, if created by hand, only its volume handle matches the volume id. It returns "" if there is none.
+func (r *OrphanVolumeReconciler) persistentVolume(ctx context.Context, lv *carinav1.LogicVolume) (string, error) {
+	// 不经过缓存，pv可能刚刚创建
+	pv := &corev1.PersistentVolume{}
+	err := r.APIReader.Get(ctx, client.ObjectKey{Name: lv.Name}, pv)
+	if err == nil {
+		return pv.Name, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	if lv.Status.VolumeID == "" {
+		return "", nil
+	}
+	pvList := &corev1.PersistentVolumeList{}
+	if err := r.APIReader.List(ctx, pvList); err != nil {
+		return "", err
+	}
+	for _, pv := range pvList.Items {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == utils.CSIPluginName && pv.Spec.CSI.VolumeHandle == lv.Status.VolumeID {
+			return pv.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *OrphanVolumeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	requested := func(o client.Object) bool {
+		_, ok := o.GetAnnotations()[utils.CollectOrphan]
+		return ok
+	}
+	pred := predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return requested(e.Object) },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return requested(e.ObjectNew) },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("orphanvolume").
+		For(&carinav1.LogicVolume{}, builder.WithPredicates(pred)).
+		Complete(r)
+}
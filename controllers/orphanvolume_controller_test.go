@@ -0,0 +1,112 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOrphanVolume(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	newLV := func(mutate func(lv *carinav1.LogicVolume)) *carinav1.LogicVolume {
+		lv := &carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pvc-1",
+				Namespace:   utils.LogicVolumeNamespace,
+				Annotations: map[string]string{utils.CollectOrphan: "node1"},
+			},
+			Spec:   carinav1.LogicVolumeSpec{NodeName: "node1", DeviceGroup: "carina-vg-ssd"},
+			Status: carinav1.LogicVolumeStatus{VolumeID: "volume-pvc-1"},
+		}
+		if mutate != nil {
+			mutate(lv)
+		}
+		return lv
+	}
+	newPV := func(name, driver, handle string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: handle},
+			}},
+		}
+	}
+
+	table := []struct {
+		name    string
+		lv      *carinav1.LogicVolume
+		pvs     []client.Object
+		deleted bool
+		event   bool
+	}{
+		{name: "orphan", lv: newLV(nil), deleted: true},
+		{name: "pv of other volumes", lv: newLV(nil), pvs: []client.Object{
+			newPV("pvc-2", utils.CSIPluginName, "volume-pvc-2"),
+			newPV("data", "topolvm.io", "volume-pvc-1"),
+		}, deleted: true},
+		{name: "no annotation", lv: newLV(func(lv *carinav1.LogicVolume) { lv.Annotations = nil })},
+		{name: "other node", lv: newLV(func(lv *carinav1.LogicVolume) { lv.Annotations[utils.CollectOrphan] = "node2" }), event: true},
+		{name: "pv of same name", lv: newLV(nil), pvs: []client.Object{newPV("pvc-1", utils.CSIPluginName, "volume-pvc-1")}, event: true},
+		{name: "pv of volume id", lv: newLV(nil), pvs: []client.Object{newPV("data", utils.CSIPluginName, "volume-pvc-1")}, event: true},
+		{name: "snapshot", lv: newLV(func(lv *carinav1.LogicVolume) { lv.Annotations[utils.SnapshotSource] = "pvc-0" }), event: true},
+		{name: "owned", lv: newLV(func(lv *carinav1.LogicVolume) {
+			lv.OwnerReferences = []metav1.OwnerReference{{APIVersion: carinav1.GroupVersion.String(), Kind: "LogicVolume", Name: "pvc-0"}}
+		}), event: true},
+	}
+
+	for _, c := range table {
+		t.Run(c.name, func(t *testing.T) {
+			cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(c.pvs, c.lv)...).Build()
+			recorder := record.NewFakeRecorder(10)
+			r := &OrphanVolumeReconciler{Client: cl, APIReader: cl, Recorder: recorder}
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(c.lv)})
+			assert.NoError(t, err)
+
+			lv := &carinav1.LogicVolume{}
+			err = cl.Get(context.Background(), client.ObjectKeyFromObject(c.lv), lv)
+			if c.deleted {
+				assert.True(t, apierrors.IsNotFound(err), "%v", err)
+				return
+			}
+			assert.NoError(t, err)
+			_, requested := lv.Annotations[utils.CollectOrphan]
+			assert.False(t, requested)
+			if c.event {
+				if assert.Len(t, recorder.Events, 1) {
+					assert.Contains(t, <-recorder.Events, " OrphanCollectRejected ")
+				}
+			} else {
+				assert.Empty(t, recorder.Events)
+			}
+		})
+	}
+}
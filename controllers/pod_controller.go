@@ -22,9 +22,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/carina-io/carina/pkg/iobudget"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/exec"
 	"github.com/carina-io/carina/utils/log"
@@ -45,8 +47,24 @@ const (
 	BlkIOThrottleWriteBPS  = "blkio.throttle.write_bps_device"
 	BlkIOThrottleWriteIOPS = "blkio.throttle.write_iops_device"
 	BlkIOCGroupPath        = "/sys/fs/cgroup/blkio/"
+
+	// Pod注解，Pod挂载的所有carina卷共享的总限速，由节点分配到各个设备
+	BlkIOThrottleTotalReadBPS   = "blkio.throttle.total_read_bps"
+	BlkIOThrottleTotalReadIOPS  = "blkio.throttle.total_read_iops"
+	BlkIOThrottleTotalWriteBPS  = "blkio.throttle.total_write_bps"
+	BlkIOThrottleTotalWriteIOPS = "blkio.throttle.total_write_iops"
+	// BlkIOThrottleDistribution how the totals are split across the devices, even or capacity
+	BlkIOThrottleDistribution = "blkio.throttle.distribution"
 )
 
+// BlkIOThrottleTotals maps the pod total annotations to the cgroup file they are split into
+var BlkIOThrottleTotals = map[string]string{
+	BlkIOThrottleReadBPS:   BlkIOThrottleTotalReadBPS,
+	BlkIOThrottleReadIOPS:  BlkIOThrottleTotalReadIOPS,
+	BlkIOThrottleWriteBPS:  BlkIOThrottleTotalWriteBPS,
+	BlkIOThrottleWriteIOPS: BlkIOThrottleTotalWriteIOPS,
+}
+
 // PodReconciler reconciles a Node object
 type PodReconciler struct {
 	client.Client
@@ -56,8 +74,8 @@ type PodReconciler struct {
 	StopChan <-chan struct{}
 }
 
-// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;update;patch
-// +kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch
 
 // Reconcile finalize Node
 func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -167,6 +185,51 @@ func (r *PodReconciler) SinglePodCGroupConfig(ctx context.Context, pod *corev1.P
 		})
 	}
 
+	limits := podBlkioLimits(pod, r.podDevices(ctx, pod))
+	// 填充到将要变更的cgroup
+	for _, c := range cb {
+		// 对于单独Pod的更新这里判断很简单，如果存在这个注解则更新，如果不存在这个注解则删除
+		for blkioKey, value := range limits[c.name] {
+			c.newBlkio[blkioKey] = value
+		}
+	}
+	// 变更cgroup file
+	writeCgroupBlkioFile(r.Executor, cb)
+	return nil
+}
+
+func (r *PodReconciler) AllPodCGroupConfig(ctx context.Context) error {
+	log.Info("config all pod cgroup blkio")
+
+	podList := &corev1.PodList{}
+	err := r.Client.List(ctx, podList, client.MatchingFields{"combinedIndex": fmt.Sprintf("%s-%s", utils.CarinaSchedule, r.NodeName)})
+	if err != nil {
+		return err
+	}
+	// 获取当前cgroup 配置
+	cb := readCGroupBlkioFile()
+	// 获取设备限制
+	for i := range podList.Items {
+		p := &podList.Items[i]
+		limits := podBlkioLimits(p, r.podDevices(ctx, p))
+		// 填充到将要变更的cgroup
+		for _, c := range cb {
+			for blkioKey, value := range limits[c.name] {
+				_, oldOk := c.oldBlkio[blkioKey]
+				if value != "0" || oldOk {
+					c.newBlkio[blkioKey] = value
+				}
+			}
+		}
+	}
+	// 判断设备是否需要更新
+	writeCgroupBlkioFile(r.Executor, cb)
+	return nil
+}
+
+// podDevices 返回Pod挂载的carina卷的设备号和容量
+func (r *PodReconciler) podDevices(ctx context.Context, pod *corev1.Pod) []iobudget.Device {
+	devices := []iobudget.Device{}
 	for _, volume := range pod.Spec.Volumes {
 		if volume.VolumeSource.PersistentVolumeClaim == nil {
 			continue
@@ -189,72 +252,40 @@ func (r *PodReconciler) SinglePodCGroupConfig(ctx context.Context, pod *corev1.P
 		}
 		// 设置主从版本号作为Key
 		blkioKey := fmt.Sprintf("%s:%s", pvInfo.Spec.CSI.VolumeAttributes[utils.VolumeDeviceMajor], pvInfo.Spec.CSI.VolumeAttributes[utils.VolumeDeviceMinor])
-		// 填充到将要变更的cgroup
-		for _, c := range cb {
-			newValue, newOk := pod.Annotations[fmt.Sprintf("%s/%s", KubernetesCustomized, c.name)]
-			// 对于单独Pod的更新这里判断很简单，如果存在这个注解则更新，如果不存在这个注解则删除
-			if newOk {
-				c.newBlkio[blkioKey] = newValue
-			} else {
-				c.newBlkio[blkioKey] = "0"
-			}
-		}
+		capacity := pvInfo.Spec.Capacity[corev1.ResourceStorage]
+		devices = append(devices, iobudget.Device{Key: blkioKey, Capacity: capacity.Value()})
 	}
-	// 变更cgroup file
-	writeCgroupBlkioFile(r.Executor, cb)
-	return nil
+	return devices
 }
 
-func (r *PodReconciler) AllPodCGroupConfig(ctx context.Context) error {
-	log.Info("config all pod cgroup blkio")
-
-	podList := &corev1.PodList{}
-	err := r.Client.List(ctx, podList, client.MatchingFields{"combinedIndex": fmt.Sprintf("%s-%s", utils.CarinaSchedule, r.NodeName)})
-	if err != nil {
-		return err
+// podBlkioLimits 根据Pod注解计算每个cgroup文件中各设备的限速值，没有限速的设备为"0"
+func podBlkioLimits(pod *corev1.Pod, devices []iobudget.Device) map[string]map[string]string {
+	annotation := func(name string) *uint64 {
+		value, ok := pod.Annotations[fmt.Sprintf("%s/%s", KubernetesCustomized, name)]
+		if !ok {
+			return nil
+		}
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			log.Errorf("pod %s/%s annotation %s invalid value %s", pod.Namespace, pod.Name, name, value)
+			return nil
+		}
+		return &v
 	}
-	// 获取当前cgroup 配置
-	cb := readCGroupBlkioFile()
-	// 获取设备限制
-	for _, p := range podList.Items {
-		for _, volume := range p.Spec.Volumes {
-			if volume.PersistentVolumeClaim == nil {
-				continue
-			}
-			pvList := &corev1.PersistentVolumeList{}
-			err := r.Client.List(ctx, pvList, client.MatchingFields{"pvIndex": fmt.Sprintf("%s-%s", p.GetNamespace(), volume.VolumeSource.PersistentVolumeClaim.ClaimName)})
-			if err != nil {
-				log.Errorf("get pv %s failed %s", volume.VolumeSource.PersistentVolumeClaim.ClaimName, err.Error())
-				continue
-			}
-			if len(pvList.Items) != 1 {
-				log.Errorf("get pv count not equal one,  %d", len(pvList.Items))
-				continue
-			}
-			pvInfo := pvList.Items[0]
-			if pvInfo.Spec.CSI.Driver != utils.CSIPluginName {
-				continue
-			}
-
-			// 设置主从版本号作为Key
-			blkioKey := fmt.Sprintf("%s:%s", pvInfo.Spec.CSI.VolumeAttributes[utils.VolumeDeviceMajor], pvInfo.Spec.CSI.VolumeAttributes[utils.VolumeDeviceMinor])
-			// 填充到将要变更的cgroup
-			for _, c := range cb {
-				_, oldOk := c.oldBlkio[blkioKey]
-				newValue, newOk := p.Annotations[fmt.Sprintf("%s/%s", KubernetesCustomized, c.name)]
-				if newOk {
-					c.newBlkio[blkioKey] = newValue
-				} else {
-					if oldOk {
-						c.newBlkio[blkioKey] = "0"
-					}
-				}
+	distribution := pod.Annotations[fmt.Sprintf("%s/%s", KubernetesCustomized, BlkIOThrottleDistribution)]
+
+	result := map[string]map[string]string{}
+	for _, name := range []string{BlkIOThrottleReadBPS, BlkIOThrottleReadIOPS, BlkIOThrottleWriteBPS, BlkIOThrottleWriteIOPS} {
+		limits := iobudget.Limits(annotation(name), annotation(BlkIOThrottleTotals[name]), devices, distribution)
+		result[name] = map[string]string{}
+		for _, d := range devices {
+			result[name][d.Key] = "0"
+			if v, ok := limits[d.Key]; ok {
+				result[name][d.Key] = strconv.FormatUint(v, 10)
 			}
 		}
 	}
-	// 判断设备是否需要更新
-	writeCgroupBlkioFile(r.Executor, cb)
-	return nil
+	return result
 }
 
 // filter carina pod
@@ -327,6 +358,15 @@ func readCGroupBlkioFile() []*cgroupblkio {
 	return cb
 }
 
+// BlkioThrottles 返回节点上blkio cgroup的限速配置，以配置文件名和设备号major:minor为键
+func BlkioThrottles() map[string]map[string]string {
+	result := map[string]map[string]string{}
+	for _, c := range readCGroupBlkioFile() {
+		result[c.name] = c.oldBlkio
+	}
+	return result
+}
+
 // echo 1:2 1 > blkio cgroup 比较神奇的地方，会搜索当前系统是否存在该设备
 // echo 1:2 1 > xxx/blkio_throttle_read_bps 当设备不存在时会追加，当存在时会更新
 // echo 1:2 0 > xxx/blkio_throttle_read_bps 会删除符合条件的设备
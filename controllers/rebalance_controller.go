@@ -0,0 +1,153 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/rebalance"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// RebalanceReconciler 在节点上执行Rebalance，用pvmove将lv从源pv迁移到目标pv
+// A rebalance runs within the data movement windows of the node. pvmove keeps
+// the volumes online and can be resumed, so a rebalance interrupted by a
+// restart of carina-node simply runs again and skips what has been moved.
+type RebalanceReconciler struct {
+	client.Client
+	NodeName string
+	DM       *deviceManager.DeviceManager
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=rebalances,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=rebalances/status,verbs=get;update;patch
+
+func (r *RebalanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	rb := &carinav1.Rebalance{}
+	if err := r.Get(ctx, req.NamespacedName, rb); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if rb.Spec.NodeName != r.NodeName || rb.Finished() {
+		return ctrl.Result{}, nil
+	}
+
+	if rb.Status.Phase != carinav1.RebalanceRunning {
+		rb.Status.Phase = carinav1.RebalanceRunning
+		now := metav1.Now()
+		rb.Status.StartTime = &now
+		if err := r.Status().Update(ctx, rb); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// pvmove可能持续很久，期间只阻塞本节点的Rebalance
+	err := r.rebalance(ctx, rb)
+	now := metav1.Now()
+	rb.Status.CompletionTime = &now
+	if err != nil {
+		log.Errorf("rebalance %s of vg %s failed: %s", rb.Name, rb.Spec.VGName, err.Error())
+		rb.Status.Phase = carinav1.RebalanceFailed
+		rb.Status.Message = err.Error()
+	} else {
+		log.Infof("rebalance %s of vg %s finished, moved %d lvs", rb.Name, rb.Spec.VGName, len(rb.Status.MovedLVs))
+		rb.Status.Phase = carinav1.RebalanceSucceeded
+	}
+	return ctrl.Result{}, r.Status().Update(ctx, rb)
+}
+
+func (r *RebalanceReconciler) rebalance(ctx context.Context, rb *carinav1.Rebalance) error {
+	pvs, err := r.DM.LvmManager.PVS()
+	if err != nil {
+		return err
+	}
+	var size, used, targetFree uint64
+	found := false
+	for _, pv := range pvs {
+		if pv.VGName != rb.Spec.VGName {
+			continue
+		}
+		if pv.PVName == rb.Spec.SourcePV {
+			found = true
+			size = pv.PVSize
+			used = pv.PVSize - pv.PVFree
+		} else if utils.ContainsString(rb.Spec.TargetPVs, pv.PVName) {
+			targetFree += pv.PVFree
+		}
+	}
+	if !found {
+		return fmt.Errorf("pv %s is not in vg %s", rb.Spec.SourcePV, rb.Spec.VGName)
+	}
+
+	segments, err := r.DM.LvmManager.PVSegments(rb.Spec.SourcePV)
+	if err != nil {
+		return err
+	}
+	moves := rebalance.SelectLVs(segments, size, used, rb.Spec.TargetUsage, targetFree)
+	if len(moves) == 0 {
+		rb.Status.Message = fmt.Sprintf("pv %s is at or below %d%% or no lv fits into the target pvs", rb.Spec.SourcePV, rb.Spec.TargetUsage)
+		return nil
+	}
+
+	for i, m := range moves {
+		if err := r.DM.Throttle.Wait(ctx); err != nil {
+			return err
+		}
+		log.Infof("rebalance %s: move %s (%d bytes) from %s to %v", rb.Name, m.LVName, m.Size, rb.Spec.SourcePV, rb.Spec.TargetPVs)
+		if err := r.DM.LvmManager.PVMove(m.LVName, rb.Spec.SourcePV, rb.Spec.TargetPVs); err != nil {
+			return fmt.Errorf("move %s off %s failed: %v", m.LVName, rb.Spec.SourcePV, err)
+		}
+		rb.Status.MovedLVs = append(rb.Status.MovedLVs, m.LVName)
+		rb.Status.MovedBytes += m.Size
+		rb.Status.Message = fmt.Sprintf("moved %d/%d lvs", i+1, len(moves))
+		if err := r.Status().Update(ctx, rb); err != nil {
+			log.Warnf("update status of rebalance %s failed: %s", rb.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *RebalanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	mine := func(o client.Object) bool {
+		rb, ok := o.(*carinav1.Rebalance)
+		return ok && rb.Spec.NodeName == r.NodeName && !rb.Finished()
+	}
+	pred := predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return mine(e.Object) },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		UpdateFunc:  func(event.UpdateEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("rebalance").
+		WithEventFilter(pred).
+		For(&carinav1.Rebalance{}).
+		Complete(r)
+}
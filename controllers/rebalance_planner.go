@@ -0,0 +1,128 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/rebalance"
+	"github.com/carina-io/carina/utils/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// rebalanceCooldown 同一个vg两次再平衡的最小间隔，节点上报的容量在此期间会刷新
+const rebalanceCooldown = time.Hour
+
+// RebalancePlanner 根据NodeStorageResource上报的pv使用率及碎片整理建议创建Rebalance
+// It only decides what to move, the move itself is done by carina-node of
+// the node. At most one rebalance is active per volume group.
+type RebalancePlanner struct {
+	client.Client
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=nodestorageresources,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=rebalances,verbs=get;list;watch;create
+
+func (r *RebalancePlanner) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	high := configuration.RebalanceHighWatermark()
+	defragment := configuration.Defragment()
+	if high == 0 && !defragment {
+		return ctrl.Result{}, nil
+	}
+
+	nsr := &carinav1beta1.NodeStorageResource{}
+	if err := r.Get(ctx, req.NamespacedName, nsr); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	rbList := new(carinav1.RebalanceList)
+	if err := r.List(ctx, rbList); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	specs := []carinav1.RebalanceSpec{}
+	if high > 0 {
+		for _, vg := range nsr.Status.VgGroups {
+			if spec, ok := rebalance.Plan(vg, high, configuration.RebalanceLowWatermark()); ok {
+				specs = append(specs, spec)
+			}
+		}
+	}
+	// 碎片整理即把DrainPV上的lv全部迁出
+	if defragment {
+		for _, frag := range nsr.Status.Fragmentation {
+			if frag.DrainPV != "" {
+				specs = append(specs, carinav1.RebalanceSpec{VGName: frag.DeviceGroup, SourcePV: frag.DrainPV, TargetPVs: frag.TargetPVs})
+			}
+		}
+	}
+
+	for _, spec := range specs {
+		if recentlyRebalanced(rbList.Items, nsr.Spec.NodeName, spec.VGName) {
+			continue
+		}
+		spec.NodeName = nsr.Spec.NodeName
+		rb := &carinav1.Rebalance{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: strings.ToLower(strings.ReplaceAll(fmt.Sprintf("%s-%s-", spec.NodeName, spec.VGName), "_", "-")),
+			},
+			Spec: spec,
+		}
+		if err := r.Create(ctx, rb); err != nil {
+			return ctrl.Result{}, err
+		}
+		rbList.Items = append(rbList.Items, *rb)
+		log.Infof("rebalance %s created: move extents of %s on node %s to %v until %d%% used", rb.Name, spec.SourcePV, spec.NodeName, spec.TargetPVs, spec.TargetUsage)
+	}
+	return ctrl.Result{}, nil
+}
+
+// recentlyRebalanced 该vg有正在进行的再平衡，或者刚刚结束一次
+func recentlyRebalanced(items []carinav1.Rebalance, node, vg string) bool {
+	for _, rb := range items {
+		if rb.Spec.NodeName != node || rb.Spec.VGName != vg {
+			continue
+		}
+		if !rb.Finished() {
+			return true
+		}
+		if rb.Status.CompletionTime != nil && time.Since(rb.Status.CompletionTime.Time) < rebalanceCooldown {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *RebalancePlanner) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("rebalanceplanner").
+		For(&carinav1beta1.NodeStorageResource{}).
+		Complete(r)
+}
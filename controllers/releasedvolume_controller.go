@@ -0,0 +1,167 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// ReleasedVolumeReconciler 回收Released状态的pv
+// A carina pv with Retain policy keeps its LogicVolume after the pvc is deleted, the
+// capacity is stranded until somebody cleans up by hand. Once an admin annotates such
+// a pv with carina.storage.io/reclaim-released=true, the LogicVolume is wiped according
+// to the wipe policy and deleted, then the pv itself is deleted.
+type ReleasedVolumeReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch;update;delete
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch;update;delete
+
+func (r *ReleasedVolumeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if !configuration.ReclaimReleasedVolume() {
+		return ctrl.Result{}, nil
+	}
+
+	pv := &corev1.PersistentVolume{}
+	if err := r.Get(ctx, req.NamespacedName, pv); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if !reclaimable(pv) {
+		return ctrl.Result{}, nil
+	}
+
+	// pv注解优先，其次是storageclass参数记录在LogicVolume上的策略，最后是全局配置
+	explicit := pv.Annotations[utils.VolumeWipePolicy] != ""
+	policy := pv.Annotations[utils.VolumeWipePolicy]
+	if policy == "" {
+		policy = configuration.WipePolicy()
+	}
+	if !utils.ContainsString([]string{utils.WipePolicyNone, utils.WipePolicyDiscard, utils.WipePolicyZero}, policy) {
+		r.Recorder.Event(pv, corev1.EventTypeWarning, "ReclaimFailed", fmt.Sprintf("unknown %s %s, should be one of none, discard, zero", utils.VolumeWipePolicy, policy))
+		return ctrl.Result{}, nil
+	}
+
+	lvs, err := volumeLogicVolumes(ctx, r.Client, pv.Spec.CSI.VolumeHandle)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// 先删除LogicVolume，由节点擦除数据并释放容量，完成后再删除pv
+	if len(lvs) > 0 {
+		// 先全部打上擦除策略再删除，避免缓存卷被级联删除时还没有策略
+		for i := range lvs {
+			lv := &lvs[i]
+			if lv.DeletionTimestamp != nil || lv.Annotations[utils.VolumeWipePolicy] == policy || (!explicit && lv.Annotations[utils.VolumeWipePolicy] != "") {
+				continue
+			}
+			if lv.Annotations == nil {
+				lv.Annotations = map[string]string{}
+			}
+			lv.Annotations[utils.VolumeWipePolicy] = policy
+			if err := r.Update(ctx, lv); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		for i := range lvs {
+			lv := &lvs[i]
+			if lv.DeletionTimestamp != nil {
+				continue
+			}
+			if err := r.Delete(ctx, lv); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			log.Infof("reclaim released pv %s, delete LogicVolume %s with wipe policy %s", pv.Name, lv.Name, policy)
+		}
+		r.Recorder.Event(pv, corev1.EventTypeNormal, "Reclaiming", fmt.Sprintf("deleting logic volume on node %s, wipe policy %s", pv.Spec.CSI.VolumeAttributes[utils.VolumeDeviceNode], policy))
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	if err := r.Delete(ctx, pv); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	log.Infof("released pv %s reclaimed", pv.Name)
+	return ctrl.Result{}, nil
+}
+
+// volumeLogicVolumes 返回卷对应的LogicVolume，bcache卷还包括其缓存卷
+func volumeLogicVolumes(ctx context.Context, c client.Reader, volumeID string) ([]carinav1.LogicVolume, error) {
+	lvList := new(carinav1.LogicVolumeList)
+	if err := c.List(ctx, lvList); err != nil {
+		return nil, err
+	}
+
+	result := []carinav1.LogicVolume{}
+	owners := []string{}
+	for _, lv := range lvList.Items {
+		if lv.Status.VolumeID == volumeID {
+			result = append(result, lv)
+			owners = append(owners, lv.Name)
+		}
+	}
+	for _, lv := range lvList.Items {
+		for _, owner := range lv.OwnerReferences {
+			if owner.Kind == "LogicVolume" && utils.ContainsString(owners, owner.Name) {
+				result = append(result, lv)
+			}
+		}
+	}
+	return result, nil
+}
+
+func reclaimable(pv *corev1.PersistentVolume) bool {
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != utils.CSIPluginName {
+		return false
+	}
+	return pv.Status.Phase == corev1.VolumeReleased &&
+		pv.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimRetain &&
+		pv.Annotations[utils.ReclaimReleasedVolume] == "true" &&
+		pv.DeletionTimestamp == nil
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *ReleasedVolumeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	pred := predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return reclaimable(e.Object.(*corev1.PersistentVolume)) },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return reclaimable(e.ObjectNew.(*corev1.PersistentVolume)) },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("releasedvolume").
+		WithEventFilter(pred).
+		For(&corev1.PersistentVolume{}).
+		Complete(r)
+}
@@ -0,0 +1,127 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReclaimable(t *testing.T) {
+	now := metav1.Now()
+	newPV := func(mutate func(pv *corev1.PersistentVolume)) *corev1.PersistentVolume {
+		pv := &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pvc-1",
+				Annotations: map[string]string{utils.ReclaimReleasedVolume: "true"},
+			},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					CSI: &corev1.CSIPersistentVolumeSource{Driver: utils.CSIPluginName, VolumeHandle: "volume-pvc-1"},
+				},
+			},
+			Status: corev1.PersistentVolumeStatus{Phase: corev1.VolumeReleased},
+		}
+		if mutate != nil {
+			mutate(pv)
+		}
+		return pv
+	}
+
+	table := []struct {
+		name   string
+		pv     *corev1.PersistentVolume
+		expect bool
+	}{
+		{name: "released retain annotated", pv: newPV(nil), expect: true},
+		{name: "bound", pv: newPV(func(pv *corev1.PersistentVolume) { pv.Status.Phase = corev1.VolumeBound }), expect: false},
+		{name: "available", pv: newPV(func(pv *corev1.PersistentVolume) { pv.Status.Phase = corev1.VolumeAvailable }), expect: false},
+		{name: "delete policy", pv: newPV(func(pv *corev1.PersistentVolume) {
+			pv.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimDelete
+		}), expect: false},
+		{name: "no annotation", pv: newPV(func(pv *corev1.PersistentVolume) { pv.Annotations = nil }), expect: false},
+		{name: "annotation false", pv: newPV(func(pv *corev1.PersistentVolume) {
+			pv.Annotations[utils.ReclaimReleasedVolume] = "false"
+		}), expect: false},
+		{name: "being deleted", pv: newPV(func(pv *corev1.PersistentVolume) { pv.DeletionTimestamp = &now }), expect: false},
+		{name: "other driver", pv: newPV(func(pv *corev1.PersistentVolume) { pv.Spec.CSI.Driver = "topolvm.io" }), expect: false},
+		{name: "not csi", pv: newPV(func(pv *corev1.PersistentVolume) {
+			pv.Spec.CSI = nil
+			pv.Spec.HostPath = &corev1.HostPathVolumeSource{Path: "/data"}
+		}), expect: false},
+	}
+
+	for _, c := range table {
+		assert.Equal(t, c.expect, reclaimable(c.pv), c.name)
+	}
+}
+
+func TestVolumeLogicVolumes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	newLV := func(name, volumeID, owner string) client.Object {
+		lv := &carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: utils.LogicVolumeNamespace},
+			Status:     carinav1.LogicVolumeStatus{VolumeID: volumeID},
+		}
+		if owner != "" {
+			lv.OwnerReferences = []metav1.OwnerReference{{APIVersion: carinav1.GroupVersion.String(), Kind: "LogicVolume", Name: owner}}
+		}
+		return lv
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newLV("pvc-1", "volume-pvc-1", ""),
+		// bcache卷的缓存卷属于pvc-1
+		newLV("pvc-1-cache", "volume-pvc-1-cache", "pvc-1"),
+		newLV("pvc-2", "volume-pvc-2", ""),
+		newLV("pvc-10", "volume-pvc-10", ""),
+		newLV("pvc-3-cache", "volume-pvc-3-cache", "pvc-3"),
+	).Build()
+
+	table := []struct {
+		volumeID string
+		expect   []string
+	}{
+		{volumeID: "volume-pvc-1", expect: []string{"pvc-1", "pvc-1-cache"}},
+		{volumeID: "volume-pvc-2", expect: []string{"pvc-2"}},
+		{volumeID: "volume-pvc-10", expect: []string{"pvc-10"}},
+		{volumeID: "volume-pvc-3", expect: []string{}},
+		{volumeID: "", expect: []string{}},
+	}
+
+	for _, tc := range table {
+		lvs, err := volumeLogicVolumes(context.Background(), c, tc.volumeID)
+		assert.NoError(t, err)
+		names := []string{}
+		for _, lv := range lvs {
+			names = append(names, lv.Name)
+		}
+		sort.Strings(names)
+		assert.Equal(t, tc.expect, names, tc.volumeID)
+	}
+}
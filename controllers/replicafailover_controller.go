@@ -0,0 +1,211 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/devicemanager/drbd"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// ReplicaFailoverReconciler 节点失效时把使用副本卷的pod迁移到另一个副本所在的节点
+// A NotReady node may still be running its pods, so they are only removed once the node has the
+// out-of-service taint or stayed NotReady for NotReadyGracePeriod. The pods on the failed node are
+// deleted without grace period, the pv allows both nodes of the volume so they are scheduled to
+// the surviving one, where drbd promotes its up to date copy. Nothing is done when both nodes of
+// a volume are down or the surviving copy has no quorum.
+type ReplicaFailoverReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	// NotReadyGracePeriod 节点NotReady超过该时间后迁移pod，0只在节点有out-of-service污点时迁移
+	NotReadyGracePeriod time.Duration
+}
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func (r *ReplicaFailoverReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	node := &corev1.Node{}
+	deleted := false
+	if err := r.Get(ctx, req.NamespacedName, node); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		deleted = true
+	}
+	if !deleted {
+		if nodeReady(node) {
+			return ctrl.Result{}, nil
+		}
+		if !outOfService(node) {
+			// 节点可能只是与apiserver断开，pod仍在写入
+			if r.NotReadyGracePeriod <= 0 {
+				return ctrl.Result{}, nil
+			}
+			if wait := r.NotReadyGracePeriod - notReadyFor(node, time.Now()); wait > 0 {
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
+		}
+	}
+
+	lvList := new(carinav1.LogicVolumeList)
+	if err := r.List(ctx, lvList); err != nil {
+		return ctrl.Result{}, err
+	}
+	for _, lv := range lvList.Items {
+		replicaNode := lv.Annotations[utils.VolumeReplicaNode]
+		if replicaNode == "" || lv.DeletionTimestamp != nil {
+			continue
+		}
+		survivor := ""
+		switch req.Name {
+		case lv.Spec.NodeName:
+			survivor = replicaNode
+		case replicaNode:
+			survivor = lv.Spec.NodeName
+		default:
+			continue
+		}
+		if err := r.failover(ctx, &lv, req.Name, survivor); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if deleted {
+		return ctrl.Result{}, nil
+	}
+	// 节点恢复之前持续检查，期间调度到该节点的pod同样迁移
+	return ctrl.Result{RequeueAfter: time.Minute}, nil
+}
+
+// failover 删除失效节点上使用该卷的pod
+func (r *ReplicaFailoverReconciler) failover(ctx context.Context, lv *carinav1.LogicVolume, failed, survivor string) error {
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: lv.Spec.NameSpace, Name: lv.Spec.Pvc}, pvc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(lv.Spec.NameSpace)); err != nil {
+		return err
+	}
+	pods := []corev1.Pod{}
+	for _, p := range podList.Items {
+		if p.Spec.NodeName == failed && podUsesClaim(&p, lv.Spec.Pvc) {
+			pods = append(pods, p)
+		}
+	}
+	if len(pods) == 0 {
+		return nil
+	}
+
+	n := &corev1.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: survivor}, n); err != nil || !nodeReady(n) {
+		log.Warnf("both nodes %s and %s of replicated volume %s are down", failed, survivor, lv.Name)
+		r.Recorder.Event(pvc, corev1.EventTypeWarning, "ReplicaFailoverFailed", fmt.Sprintf("node %s is down and node %s of the other replica is not ready", failed, survivor))
+		return nil
+	}
+
+	// 另一个副本与tiebreaker失联时不能提升，它也可能不是最新的
+	survivorLV := lv
+	if survivor != lv.Spec.NodeName {
+		survivorLV = &carinav1.LogicVolume{}
+		if err := r.Get(ctx, client.ObjectKey{Name: drbd.ReplicaName(lv.Name), Namespace: lv.Namespace}, survivorLV); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+	}
+	if st := survivorLV.Status.Drbd; st == nil || !st.Quorum || st.Disk != "UpToDate" {
+		log.Warnf("replica of volume %s on node %s has no quorum or is not up to date, do not fail over from node %s", lv.Name, survivor, failed)
+		r.Recorder.Event(pvc, corev1.EventTypeWarning, "ReplicaFailoverFailed", fmt.Sprintf("node %s is down and the replica on node %s has no quorum or is not up to date", failed, survivor))
+		return nil
+	}
+
+	noGracePeriod := int64(0)
+	for i := range pods {
+		err := r.Delete(ctx, &pods[i], &client.DeleteOptions{GracePeriodSeconds: &noGracePeriod})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		log.Infof("replicated volume %s fails over from node %s to %s, delete pod %s/%s", lv.Name, failed, survivor, pods[i].Namespace, pods[i].Name)
+	}
+	r.Recorder.Event(pvc, corev1.EventTypeNormal, "ReplicaFailover", fmt.Sprintf("node %s is down, pods using the volume move to node %s", failed, survivor))
+	return nil
+}
+
+// nodeReady 节点处于Ready状态且未被删除
+func nodeReady(node *corev1.Node) bool {
+	if node.DeletionTimestamp != nil {
+		return false
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// outOfService 节点有out-of-service污点，管理员确认节点已关机
+func outOfService(node *corev1.Node) bool {
+	for _, t := range node.Spec.Taints {
+		if t.Key == utils.OutOfServiceTaint {
+			return true
+		}
+	}
+	return false
+}
+
+// notReadyFor 返回节点处于NotReady状态的时长
+func notReadyFor(node *corev1.Node, now time.Time) time.Duration {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return now.Sub(c.LastTransitionTime.Time)
+		}
+	}
+	return 0
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *ReplicaFailoverReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("replicafailover").
+		For(&corev1.Node{}).
+		WithEventFilter(predicate.Funcs{
+			CreateFunc: func(e event.CreateEvent) bool { return !nodeReady(e.Object.(*corev1.Node)) },
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldNode, newNode := e.ObjectOld.(*corev1.Node), e.ObjectNew.(*corev1.Node)
+				return nodeReady(oldNode) != nodeReady(newNode) || outOfService(oldNode) != outOfService(newNode)
+			},
+			DeleteFunc:  func(e event.DeleteEvent) bool { return true },
+			GenericFunc: func(e event.GenericEvent) bool { return false },
+		}).
+		Complete(r)
+}
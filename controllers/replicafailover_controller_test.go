@@ -0,0 +1,134 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/devicemanager/drbd"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// failoverObjects 副本卷的两个副本在node1和node2上，node1上的pod使用它
+func failoverObjects(notReadySince time.Duration, outOfService bool, survivor *carinav1.DrbdStatus) []client.Object {
+	failed := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{{
+			Type:               corev1.NodeReady,
+			Status:             corev1.ConditionUnknown,
+			LastTransitionTime: metav1.NewTime(time.Now().Add(-notReadySince)),
+		}}},
+	}
+	if outOfService {
+		failed.Spec.Taints = []corev1.Taint{{Key: utils.OutOfServiceTaint, Value: "nodeshutdown", Effect: corev1.TaintEffectNoExecute}}
+	}
+	return []client.Object{
+		failed,
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node2"},
+			Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+		},
+		&carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pvc-1",
+				Namespace:   utils.LogicVolumeNamespace,
+				Annotations: map[string]string{utils.VolumeReplicaNode: "node2", utils.VolumeDrbdMinor: "0", utils.VolumeDrbdTiebreaker: "node3"},
+			},
+			Spec: carinav1.LogicVolumeSpec{NodeName: "node1", NameSpace: "default", Pvc: "data"},
+		},
+		&carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        drbd.ReplicaName("pvc-1"),
+				Namespace:   utils.LogicVolumeNamespace,
+				Annotations: map[string]string{utils.VolumeReplicaOf: "pvc-1", utils.VolumeDrbdMinor: "0"},
+			},
+			Spec:   carinav1.LogicVolumeSpec{NodeName: "node2", NameSpace: "default", Pvc: "data"},
+			Status: carinav1.LogicVolumeStatus{Drbd: survivor},
+		},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "data"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db-0"},
+			Spec: corev1.PodSpec{
+				NodeName: "node1",
+				Volumes: []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+				}}},
+			},
+		},
+	}
+}
+
+func TestReplicaFailover(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	quorum := &carinav1.DrbdStatus{Role: "Secondary", Disk: "UpToDate", Connection: "Connecting", Quorum: true}
+
+	table := []struct {
+		name          string
+		notReadySince time.Duration
+		outOfService  bool
+		gracePeriod   time.Duration
+		survivor      *carinav1.DrbdStatus
+		deleted       bool
+		requeueAfter  time.Duration
+		event         string
+	}{
+		{name: "grace period", notReadySince: time.Minute, gracePeriod: 5 * time.Minute, survivor: quorum, requeueAfter: 4 * time.Minute},
+		{name: "grace period elapsed", notReadySince: 6 * time.Minute, gracePeriod: 5 * time.Minute, survivor: quorum, deleted: true, requeueAfter: time.Minute, event: "ReplicaFailover"},
+		{name: "out of service", notReadySince: time.Minute, outOfService: true, gracePeriod: 5 * time.Minute, survivor: quorum, deleted: true, requeueAfter: time.Minute, event: "ReplicaFailover"},
+		{name: "only out of service", notReadySince: time.Hour, survivor: quorum},
+		{name: "no quorum", outOfService: true, gracePeriod: 5 * time.Minute, requeueAfter: time.Minute, event: "ReplicaFailoverFailed",
+			survivor: &carinav1.DrbdStatus{Role: "Secondary", Disk: "UpToDate", Connection: "Connecting"}},
+		{name: "outdated", outOfService: true, gracePeriod: 5 * time.Minute, requeueAfter: time.Minute, event: "ReplicaFailoverFailed",
+			survivor: &carinav1.DrbdStatus{Role: "Secondary", Disk: "Outdated", Connection: "Connecting", Quorum: true}},
+		{name: "survivor not reported", outOfService: true, gracePeriod: 5 * time.Minute, requeueAfter: time.Minute, event: "ReplicaFailoverFailed"},
+	}
+
+	for _, c := range table {
+		t.Run(c.name, func(t *testing.T) {
+			cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(failoverObjects(c.notReadySince, c.outOfService, c.survivor)...).Build()
+			recorder := record.NewFakeRecorder(10)
+			r := &ReplicaFailoverReconciler{Client: cl, Recorder: recorder, NotReadyGracePeriod: c.gracePeriod}
+			result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}})
+			assert.NoError(t, err)
+			assert.InDelta(t, c.requeueAfter, result.RequeueAfter, float64(time.Second))
+
+			err = cl.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "db-0"}, &corev1.Pod{})
+			assert.Equal(t, c.deleted, apierrors.IsNotFound(err), "%v", err)
+			if c.event == "" {
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			if assert.Len(t, recorder.Events, 1) {
+				assert.Contains(t, <-recorder.Events, " "+c.event+" ")
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/placement"
+	"github.com/carina-io/carina/pkg/version"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// ReplicaPlacementReconciler 为申请了副本放置的StatefulSet计算每个副本的节点
+// The plan is written back as an annotation, the operator owning the
+// StatefulSet pins its pods and volumes with it. Capacity is not reserved,
+// two StatefulSets planned at the same time may pick the same free space.
+type ReplicaPlacementReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=nodestorageresources,verbs=get;list;watch
+
+func (r *ReplicaPlacementReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, req.NamespacedName, sts); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	raw := sts.Annotations[utils.ReplicaPlacement]
+	if raw == "" || sts.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	request, err := placement.ParseRequest(raw)
+	if err != nil {
+		r.Recorder.Event(sts, corev1.EventTypeWarning, "InvalidReplicaPlacement", err.Error())
+		return ctrl.Result{}, nil
+	}
+	if request.DeviceGroup != "" {
+		request.DeviceGroup = version.GetDeviceGroup(request.DeviceGroup)
+	}
+
+	previous := []placement.Replica{}
+	if p := sts.Annotations[utils.ReplicaPlacementPlan]; p != "" {
+		if err := json.Unmarshal([]byte(p), &previous); err != nil {
+			log.Warnf("ignore invalid replica placement plan of statefulset %s: %s", req.NamespacedName, err.Error())
+		}
+	}
+
+	nodes, err := r.nodeCapacities(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	plan, err := placement.Compute(request, nodes, previous)
+	if err != nil {
+		r.Recorder.Event(sts, corev1.EventTypeWarning, "ReplicaPlacementFailed", err.Error())
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if string(data) == sts.Annotations[utils.ReplicaPlacementPlan] {
+		return ctrl.Result{}, nil
+	}
+	sts2 := sts.DeepCopy()
+	sts2.Annotations[utils.ReplicaPlacementPlan] = string(data)
+	if err := r.Patch(ctx, sts2, client.MergeFrom(sts)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	replicas := []string{}
+	for i, p := range plan {
+		replicas = append(replicas, fmt.Sprintf("%d=%s/%s", i, p.Node, p.DeviceGroup))
+	}
+	r.Recorder.Event(sts, corev1.EventTypeNormal, "ReplicaPlacementPlanned", strings.Join(replicas, ","))
+	log.Infof("statefulset %s replicas placed %s", req.NamespacedName, strings.Join(replicas, ","))
+	return ctrl.Result{}, nil
+}
+
+// nodeCapacities 返回可调度的就绪节点上各lvm磁盘组的可分配容量
+func (r *ReplicaPlacementReconciler) nodeCapacities(ctx context.Context) ([]placement.NodeCapacity, error) {
+	nl := new(corev1.NodeList)
+	if err := r.List(ctx, nl); err != nil {
+		return nil, err
+	}
+	schedulable := map[string]bool{}
+	for _, n := range nl.Items {
+		if n.Spec.Unschedulable {
+			continue
+		}
+		for _, c := range n.Status.Conditions {
+			if c.Type == corev1.NodeReady && c.Status == corev1.ConditionTrue {
+				schedulable[n.Name] = true
+			}
+		}
+	}
+
+	nsrList := new(carinav1beta1.NodeStorageResourceList)
+	if err := r.List(ctx, nsrList); err != nil {
+		return nil, err
+	}
+	result := []placement.NodeCapacity{}
+	for _, nsr := range nsrList.Items {
+		if !schedulable[nsr.Spec.NodeName] {
+			continue
+		}
+		free := map[string]int64{}
+		for key, value := range nsr.Status.Allocatable {
+			group := strings.TrimPrefix(key, utils.DeviceCapacityKeyPrefix)
+			// raw磁盘组按磁盘上报，键中带有磁盘名
+			if group == key || strings.Contains(group, "/") || version.CheckRawDeviceGroup(group) {
+				continue
+			}
+			free[group] = value.Value() << 30
+		}
+		result = append(result, placement.NodeCapacity{Node: nsr.Spec.NodeName, Free: free})
+	}
+	return result, nil
+}
+
+func requestsPlacement(o client.Object) bool {
+	return o.GetAnnotations()[utils.ReplicaPlacement] != ""
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *ReplicaPlacementReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	pred := predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return requestsPlacement(e.Object) },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return requestsPlacement(e.ObjectNew) },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("replicaplacement").
+		WithEventFilter(pred).
+		For(&appsv1.StatefulSet{}).
+		Complete(r)
+}
@@ -0,0 +1,124 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/nfsgateway"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// SharedVolumeReconciler 为共享卷维护nfs网关
+// A LogicVolume annotated with carina.storage.io/shared gets a ganesha Deployment on its node and a
+// Service, both owned by the LogicVolume and garbage collected with it. The cluster ip of the Service
+// is written to carina.storage.io/nfs-server, carina-node mounts it when publishing the volume.
+type SharedVolumeReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;delete
+
+func (r *SharedVolumeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	lv := &carinav1.LogicVolume{}
+	if err := r.Get(ctx, req.NamespacedName, lv); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	// 删除中的卷由垃圾回收删除网关
+	if !nfsgateway.Shared(lv) || lv.DeletionTimestamp != nil || lv.Spec.NodeName == "" {
+		return ctrl.Result{}, nil
+	}
+	// 节点创建卷之后网关才能挂载
+	if lv.Status.Status != "Success" {
+		return ctrl.Result{}, nil
+	}
+
+	namespace := configuration.RuntimeNamespace()
+	deploy := &appsv1.Deployment{}
+	deploy.Name, deploy.Namespace = nfsgateway.Name(lv), namespace
+	op, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		nfsgateway.MutateDeployment(deploy, lv, configuration.NfsGatewayImage())
+		return controllerutil.SetControllerReference(lv, deploy, r.Scheme)
+	})
+	if err != nil {
+		r.Recorder.Event(lv, corev1.EventTypeWarning, "NfsGatewayFailed", fmt.Sprintf("create or update nfs gateway %s/%s failed: %s", namespace, deploy.Name, err.Error()))
+		return ctrl.Result{}, err
+	}
+	if op == controllerutil.OperationResultCreated {
+		r.Recorder.Event(lv, corev1.EventTypeNormal, "NfsGatewayCreated", fmt.Sprintf("nfs gateway %s/%s created on node %s", namespace, deploy.Name, lv.Spec.NodeName))
+	}
+
+	svc := &corev1.Service{}
+	svc.Name, svc.Namespace = nfsgateway.Name(lv), namespace
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		nfsgateway.MutateService(svc, lv)
+		return controllerutil.SetControllerReference(lv, svc, r.Scheme)
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone || lv.Annotations[utils.VolumeNfsServer] == svc.Spec.ClusterIP {
+		return ctrl.Result{}, nil
+	}
+	lv.Annotations[utils.VolumeNfsServer] = svc.Spec.ClusterIP
+	if err := r.Update(ctx, lv); err != nil {
+		if apierrors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	log.Infof("shared volume %s exported by nfs gateway %s/%s at %s", lv.Name, namespace, svc.Name, svc.Spec.ClusterIP)
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *SharedVolumeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	shared := func(o client.Object) bool {
+		lv, ok := o.(*carinav1.LogicVolume)
+		return ok && nfsgateway.Shared(lv)
+	}
+	pred := predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return shared(e.Object) },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return shared(e.ObjectNew) },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("sharedvolume").
+		For(&carinav1.LogicVolume{}, builder.WithPredicates(pred)).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Complete(r)
+}
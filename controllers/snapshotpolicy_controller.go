@@ -0,0 +1,231 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/snapshotpolicy"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// VolumeSnapshotGVK external-snapshotter api, SnapshotPolicy needs the snapshot crds installed
+var VolumeSnapshotGVK = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1", Kind: "VolumeSnapshot"}
+
+// SnapshotPolicyReconciler 按计划为命名空间内选中的pvc创建快照，并删除超出保留数量的旧快照
+// Snapshots carry no owner reference, deleting the policy keeps them.
+type SnapshotPolicyReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=snapshotpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=snapshotpolicies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;create;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
+
+func (r *SnapshotPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	policy := &carinav1.SnapshotPolicy{}
+	if err := r.Get(ctx, req.NamespacedName, policy); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if policy.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	schedule, err := snapshotpolicy.ParseSchedule(policy.Spec.Schedule)
+	if err != nil {
+		r.Recorder.Event(policy, corev1.EventTypeWarning, "InvalidSchedule", err.Error())
+		policy.Status.Message = err.Error()
+		policy.Status.NextScheduleTime = nil
+		return ctrl.Result{}, r.Status().Update(ctx, policy)
+	}
+
+	now := time.Now()
+	since := policy.CreationTimestamp.Time
+	if policy.Status.LastScheduleTime != nil {
+		since = policy.Status.LastScheduleTime.Time
+	}
+
+	policy.Status.Message = ""
+	if !policy.Spec.Suspend {
+		// 错过的多次计划只补做最近的一次
+		if scheduled := schedule.Last(since, now); !scheduled.IsZero() {
+			if err := r.takeSnapshots(ctx, policy, scheduled); err != nil {
+				policy.Status.Message = err.Error()
+				r.Recorder.Event(policy, corev1.EventTypeWarning, "SnapshotFailed", err.Error())
+				if err2 := r.Status().Update(ctx, policy); err2 != nil {
+					log.Errorf("update snapshot policy %s status failed: %s", req.NamespacedName, err2.Error())
+				}
+				return ctrl.Result{}, err
+			}
+			policy.Status.LastScheduleTime = &metav1.Time{Time: scheduled}
+		}
+	}
+
+	snapshots, err := r.listSnapshots(ctx, policy)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	existing := len(snapshots)
+	if !policy.Spec.Suspend {
+		for _, s := range snapshotpolicy.Expired(snapshots, int(policy.Spec.Retention)) {
+			vs := &unstructured.Unstructured{}
+			vs.SetGroupVersionKind(VolumeSnapshotGVK)
+			vs.SetNamespace(policy.Namespace)
+			vs.SetName(s.Name)
+			if err := r.Delete(ctx, vs); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			existing--
+			log.Infof("delete expired volume snapshot %s/%s of policy %s", policy.Namespace, s.Name, policy.Name)
+		}
+	}
+	policy.Status.Snapshots = int32(existing)
+
+	result := ctrl.Result{}
+	policy.Status.NextScheduleTime = nil
+	if next := schedule.Next(now); !next.IsZero() && !policy.Spec.Suspend {
+		policy.Status.NextScheduleTime = &metav1.Time{Time: next}
+		result.RequeueAfter = next.Sub(now)
+	}
+	if err := r.Status().Update(ctx, policy); err != nil {
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// takeSnapshots 为选中的每个carina pvc创建一个快照，名称由计划时间确定，重试时不会重复创建
+func (r *SnapshotPolicyReconciler) takeSnapshots(ctx context.Context, policy *carinav1.SnapshotPolicy, scheduled time.Time) error {
+	selector := labels.Everything()
+	if policy.Spec.Selector != nil {
+		s, err := metav1.LabelSelectorAsSelector(policy.Spec.Selector)
+		if err != nil {
+			return err
+		}
+		selector = s
+	}
+
+	pvcList := new(corev1.PersistentVolumeClaimList)
+	if err := r.List(ctx, pvcList, client.InNamespace(policy.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return err
+	}
+
+	count := 0
+	for _, pvc := range pvcList.Items {
+		if pvc.Status.Phase != corev1.ClaimBound || pvc.DeletionTimestamp != nil {
+			continue
+		}
+		if ok, err := r.provisionedByCarina(ctx, &pvc); err != nil || !ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		vs := &unstructured.Unstructured{}
+		vs.SetGroupVersionKind(VolumeSnapshotGVK)
+		vs.SetNamespace(policy.Namespace)
+		vs.SetName(fmt.Sprintf("%s-%s-%s", pvc.Name, policy.Name, scheduled.Format("200601021504")))
+		vs.SetLabels(map[string]string{
+			utils.SnapshotPolicyLabel: policy.Name,
+			utils.SnapshotPVCLabel:    pvc.Name,
+		})
+		spec := map[string]interface{}{
+			"source": map[string]interface{}{"persistentVolumeClaimName": pvc.Name},
+		}
+		if policy.Spec.VolumeSnapshotClassName != "" {
+			spec["volumeSnapshotClassName"] = policy.Spec.VolumeSnapshotClassName
+		}
+		vs.Object["spec"] = spec
+		if err := r.Create(ctx, vs); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				continue
+			}
+			return fmt.Errorf("create snapshot of pvc %s failed: %s", pvc.Name, err.Error())
+		}
+		count++
+	}
+
+	log.Infof("snapshot policy %s/%s took %d snapshots", policy.Namespace, policy.Name, count)
+	r.Recorder.Event(policy, corev1.EventTypeNormal, "SnapshotsCreated", fmt.Sprintf("created %d volume snapshots", count))
+	return nil
+}
+
+func (r *SnapshotPolicyReconciler) provisionedByCarina(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return false, nil
+	}
+	sc := &storagev1.StorageClass{}
+	if err := r.Get(ctx, client.ObjectKey{Name: *pvc.Spec.StorageClassName}, sc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return sc.Provisioner == utils.CSIPluginName, nil
+}
+
+// listSnapshots 返回策略创建且未在删除中的快照
+func (r *SnapshotPolicyReconciler) listSnapshots(ctx context.Context, policy *carinav1.SnapshotPolicy) ([]snapshotpolicy.Snapshot, error) {
+	vsList := &unstructured.UnstructuredList{}
+	vsList.SetGroupVersionKind(VolumeSnapshotGVK.GroupVersion().WithKind(VolumeSnapshotGVK.Kind + "List"))
+	if err := r.List(ctx, vsList, client.InNamespace(policy.Namespace), client.MatchingLabels{utils.SnapshotPolicyLabel: policy.Name}); err != nil {
+		return nil, err
+	}
+
+	result := []snapshotpolicy.Snapshot{}
+	for _, vs := range vsList.Items {
+		if vs.GetDeletionTimestamp() != nil {
+			continue
+		}
+		result = append(result, snapshotpolicy.Snapshot{
+			Name:    vs.GetName(),
+			PVC:     vs.GetLabels()[utils.SnapshotPVCLabel],
+			Created: vs.GetCreationTimestamp().Time,
+		})
+	}
+	return result, nil
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *SnapshotPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("snapshotpolicy").
+		WithEventFilter(predicate.GenerationChangedPredicate{}).
+		For(&carinav1.SnapshotPolicy{}).
+		Complete(r)
+}
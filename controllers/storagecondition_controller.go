@@ -0,0 +1,205 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/volume"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// podNodeNameIndex pod按所在节点的索引
+const podNodeNameIndex = "podNodeName"
+
+// StorageConditionReconciler 按NodeStorageResource中thin pool的污点设置pod的condition
+// carina-node only reports the thin pools above usageThreshold in the taints of its
+// NodeStorageResource, it may not patch pods. For every pod on the node using a carina pvc the
+// condition carina.storage.io/StorageNearlyFull is set while the thin pool of one of its volumes is
+// tainted and usagePodCondition is on, and reset to False afterwards.
+type StorageConditionReconciler struct {
+	client.Client
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=nodestorageresources,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods/status,verbs=patch
+
+func (r *StorageConditionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	nsr := &carinav1beta1.NodeStorageResource{}
+	if err := r.Get(ctx, req.NamespacedName, nsr); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	nodeName := nsr.Spec.NodeName
+
+	// thin-<pv名称>，LogicVolume与pv同名
+	usage := map[string]int{}
+	for _, t := range nsr.Status.Taints {
+		if t.ThinPool != "" {
+			usage[strings.TrimPrefix(t.ThinPool, volume.THIN)] = t.Usage
+		}
+	}
+	lvList := &carinav1.LogicVolumeList{}
+	if err := r.List(ctx, lvList); err != nil {
+		return ctrl.Result{}, err
+	}
+	claims := map[string]string{}
+	for _, lv := range lvList.Items {
+		if lv.Spec.NodeName == nodeName && lv.Spec.Pvc != "" {
+			claims[lv.Spec.NameSpace+"/"+lv.Spec.Pvc] = lv.Name
+		}
+	}
+
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.MatchingFields{podNodeNameIndex: nodeName}); err != nil {
+		return ctrl.Result{}, err
+	}
+	// 关闭后仍然清除此前设置的condition
+	enabled := configuration.UsagePodCondition()
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		condition, ok := storageCondition(pod, claims, usage, enabled)
+		if !ok {
+			continue
+		}
+		newPod := pod.DeepCopy()
+		if !setPodCondition(newPod, condition) {
+			continue
+		}
+		if err := r.Status().Patch(ctx, newPod, client.StrategicMergeFrom(pod)); err != nil {
+			log.Warnf("set condition %s of pod %s/%s failed: %s", condition.Type, pod.Namespace, pod.Name, err.Error())
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// storageCondition pod应有的condition，pod没有使用该节点的carina卷时返回false
+func storageCondition(pod *corev1.Pod, claims map[string]string, usage map[string]int, enabled bool) (corev1.PodCondition, bool) {
+	condition := corev1.PodCondition{
+		Type:               utils.ConditionStorageNearlyFull,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.Now(),
+	}
+	found := false
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim == nil {
+			continue
+		}
+		name, ok := claims[pod.Namespace+"/"+v.PersistentVolumeClaim.ClaimName]
+		if !ok {
+			continue
+		}
+		found = true
+		if u, ok := usage[name]; ok && enabled {
+			condition.Status = corev1.ConditionTrue
+			condition.Reason = "ThinPoolNearlyFull"
+			condition.Message = fmt.Sprintf("thin pool of pvc %s is %d%% used", v.PersistentVolumeClaim.ClaimName, u)
+			break
+		}
+	}
+	return condition, found
+}
+
+func podUsesClaim(pod *corev1.Pod, claim string) bool {
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == claim {
+			return true
+		}
+	}
+	return false
+}
+
+// setPodCondition 设置pod的condition，返回是否有变化，不存在的condition不会被设置为False
+func setPodCondition(pod *corev1.Pod, condition corev1.PodCondition) bool {
+	for i, c := range pod.Status.Conditions {
+		if c.Type != condition.Type {
+			continue
+		}
+		if c.Status == condition.Status && c.Message == condition.Message {
+			return false
+		}
+		pod.Status.Conditions[i] = condition
+		return true
+	}
+	if condition.Status != corev1.ConditionTrue {
+		return false
+	}
+	pod.Status.Conditions = append(pod.Status.Conditions, condition)
+	return true
+}
+
+// nodeOfPod pod调度到节点后检查该节点的污点
+func nodeOfPod(o client.Object) []reconcile.Request {
+	pod, ok := o.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: pod.Spec.NodeName}}}
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *StorageConditionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, podNodeNameIndex, func(object client.Object) []string {
+		return []string{object.(*corev1.Pod).Spec.NodeName}
+	})
+	if err != nil {
+		return err
+	}
+
+	taintsChanged := predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool { return true },
+		DeleteFunc: func(event.DeleteEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			o := e.ObjectOld.(*carinav1beta1.NodeStorageResource)
+			n := e.ObjectNew.(*carinav1beta1.NodeStorageResource)
+			return !equality.Semantic.DeepEqual(o.Status.Taints, n.Status.Taints)
+		},
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	scheduled := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool { return e.Object.(*corev1.Pod).Spec.NodeName != "" },
+		DeleteFunc: func(event.DeleteEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return e.ObjectOld.(*corev1.Pod).Spec.NodeName == "" && e.ObjectNew.(*corev1.Pod).Spec.NodeName != ""
+		},
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("storagecondition").
+		For(&carinav1beta1.NodeStorageResource{}, builder.WithPredicates(taintsChanged)).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(nodeOfPod), builder.WithPredicates(scheduled)).
+		Complete(r)
+}
@@ -0,0 +1,180 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSetPodCondition(t *testing.T) {
+	conditionType := corev1.PodConditionType(utils.ConditionStorageNearlyFull)
+	other := corev1.PodCondition{Type: corev1.PodReady, Status: corev1.ConditionTrue}
+
+	table := []struct {
+		conditions []corev1.PodCondition
+		condition  corev1.PodCondition
+		changed    bool
+		expect     []corev1.PodCondition
+	}{
+		// 不存在的condition不会被设置为False
+		{
+			conditions: []corev1.PodCondition{other},
+			condition:  corev1.PodCondition{Type: conditionType, Status: corev1.ConditionFalse},
+			changed:    false,
+			expect:     []corev1.PodCondition{other},
+		},
+		{
+			conditions: []corev1.PodCondition{other},
+			condition:  corev1.PodCondition{Type: conditionType, Status: corev1.ConditionTrue, Message: "carina-vg-ssd 95%"},
+			changed:    true,
+			expect:     []corev1.PodCondition{other, {Type: conditionType, Status: corev1.ConditionTrue, Message: "carina-vg-ssd 95%"}},
+		},
+		{
+			conditions: []corev1.PodCondition{{Type: conditionType, Status: corev1.ConditionTrue, Message: "carina-vg-ssd 95%"}},
+			condition:  corev1.PodCondition{Type: conditionType, Status: corev1.ConditionTrue, Message: "carina-vg-ssd 95%"},
+			changed:    false,
+			expect:     []corev1.PodCondition{{Type: conditionType, Status: corev1.ConditionTrue, Message: "carina-vg-ssd 95%"}},
+		},
+		{
+			conditions: []corev1.PodCondition{{Type: conditionType, Status: corev1.ConditionTrue, Message: "carina-vg-ssd 95%"}},
+			condition:  corev1.PodCondition{Type: conditionType, Status: corev1.ConditionTrue, Message: "carina-vg-ssd 97%"},
+			changed:    true,
+			expect:     []corev1.PodCondition{{Type: conditionType, Status: corev1.ConditionTrue, Message: "carina-vg-ssd 97%"}},
+		},
+		{
+			conditions: []corev1.PodCondition{other, {Type: conditionType, Status: corev1.ConditionTrue, Message: "carina-vg-ssd 95%"}},
+			condition:  corev1.PodCondition{Type: conditionType, Status: corev1.ConditionFalse},
+			changed:    true,
+			expect:     []corev1.PodCondition{other, {Type: conditionType, Status: corev1.ConditionFalse}},
+		},
+	}
+
+	for i, d := range table {
+		pod := &corev1.Pod{Status: corev1.PodStatus{Conditions: d.conditions}}
+		assert.Equal(t, d.changed, setPodCondition(pod, d.condition), "case %d", i)
+		assert.Equal(t, d.expect, pod.Status.Conditions, "case %d", i)
+	}
+}
+
+func TestStorageCondition(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	assert.NoError(t, carinav1beta1.AddToScheme(scheme))
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	enabled := configuration.UsagePodCondition()
+	defer configuration.GlobalConfig.Set("usagePodCondition", enabled)
+
+	conditionType := corev1.PodConditionType(utils.ConditionStorageNearlyFull)
+	newPod := func(name, claim string, conditions ...corev1.PodCondition) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: corev1.PodSpec{
+				NodeName: "node1",
+				Volumes: []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+				}}},
+			},
+			Status: corev1.PodStatus{Conditions: conditions},
+		}
+	}
+	objects := func(taints []carinav1beta1.StorageTaint, full corev1.ConditionStatus) []client.Object {
+		objects := []client.Object{
+			&carinav1beta1.NodeStorageResource{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Spec:       carinav1beta1.NodeStorageResourceSpec{NodeName: "node1"},
+				Status:     carinav1beta1.NodeStorageResourceStatus{Taints: taints},
+			},
+			&carinav1.LogicVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: utils.LogicVolumeNamespace},
+				Spec:       carinav1.LogicVolumeSpec{NodeName: "node1", NameSpace: "default", Pvc: "data-1"},
+			},
+			&carinav1.LogicVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pvc-2", Namespace: utils.LogicVolumeNamespace},
+				Spec:       carinav1.LogicVolumeSpec{NodeName: "node1", NameSpace: "default", Pvc: "data-2"},
+			},
+			newPod("db-2", "data-2"),
+			newPod("web-0", "other"),
+		}
+		if full == "" {
+			return append(objects, newPod("db-1", "data-1"))
+		}
+		return append(objects, newPod("db-1", "data-1", corev1.PodCondition{Type: conditionType, Status: full, Message: "thin pool of pvc data-1 is 95% used"}))
+	}
+	tainted := []carinav1beta1.StorageTaint{
+		{DeviceGroup: "carina-vg-ssd", ThinPool: "thin-pvc-1", Usage: 95},
+		{DeviceGroup: "carina-vg-hdd", Usage: 90},
+	}
+
+	table := []struct {
+		name    string
+		enabled bool
+		taints  []carinav1beta1.StorageTaint
+		before  corev1.ConditionStatus
+		expect  corev1.ConditionStatus
+	}{
+		{name: "thin pool nearly full", enabled: true, taints: tainted, expect: corev1.ConditionTrue},
+		{name: "already set", enabled: true, taints: tainted, before: corev1.ConditionTrue, expect: corev1.ConditionTrue},
+		{name: "disabled", taints: tainted},
+		{name: "disabled after set", taints: tainted, before: corev1.ConditionTrue, expect: corev1.ConditionFalse},
+		{name: "taint removed", enabled: true, before: corev1.ConditionTrue, expect: corev1.ConditionFalse},
+		{name: "no taint", enabled: true},
+	}
+
+	for _, c := range table {
+		t.Run(c.name, func(t *testing.T) {
+			configuration.GlobalConfig.Set("usagePodCondition", c.enabled)
+			cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects(c.taints, c.before)...).Build()
+			r := &StorageConditionReconciler{Client: cl}
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: "node1"}})
+			assert.NoError(t, err)
+
+			for name, expect := range map[string]corev1.ConditionStatus{"db-1": c.expect, "db-2": "", "web-0": ""} {
+				pod := &corev1.Pod{}
+				assert.NoError(t, cl.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, pod))
+				var condition *corev1.PodCondition
+				for i := range pod.Status.Conditions {
+					if pod.Status.Conditions[i].Type == conditionType {
+						condition = &pod.Status.Conditions[i]
+					}
+				}
+				if expect == "" {
+					assert.Nil(t, condition, name)
+					continue
+				}
+				if assert.NotNil(t, condition, name) {
+					assert.Equal(t, expect, condition.Status, name)
+					if expect == corev1.ConditionTrue {
+						assert.Equal(t, "thin pool of pvc data-1 is 95% used", condition.Message, name)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// VMIMigrationGVK KubeVirt live migration request
+	VMIMigrationGVK = schema.GroupVersionKind{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachineInstanceMigration"}
+	vmiGVK          = schema.GroupVersionKind{Group: "kubevirt.io", Version: "v1", Kind: "VirtualMachineInstance"}
+)
+
+// VMMigrationReconciler 处理使用carina卷的KubeVirt虚拟机热迁移
+// Carina volumes live on a single node and can not be attached on the migration target,
+// copying the logic volume to another node while the vm keeps writing is not supported.
+// Instead of leaving the migration to fail somewhere inside KubeVirt, the migration is
+// cancelled right away, the reason is recorded as LiveMigratable condition on the
+// LogicVolumes and as events on the migration and the vmi, the LiveMigration condition
+// follows the migration from Migrating to Cancelled.
+type VMMigrationReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=kubevirt.io,resources=virtualmachineinstancemigrations,verbs=get;list;watch;update;delete
+// +kubebuilder:rbac:groups=kubevirt.io,resources=virtualmachineinstances,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes/status,verbs=get;update;patch
+
+func (r *VMMigrationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	vmim := &unstructured.Unstructured{}
+	vmim.SetGroupVersionKind(VMIMigrationGVK)
+	if err := r.Get(ctx, req.NamespacedName, vmim); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if vmim.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, nil
+	}
+	phase, _, _ := unstructured.NestedString(vmim.Object, "status", "phase")
+	if phase == "Succeeded" || phase == "Failed" {
+		return ctrl.Result{}, nil
+	}
+
+	vmiName, _, _ := unstructured.NestedString(vmim.Object, "spec", "vmiName")
+	if vmiName == "" {
+		return ctrl.Result{}, nil
+	}
+	vmi := &unstructured.Unstructured{}
+	vmi.SetGroupVersionKind(vmiGVK)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: vmim.GetNamespace(), Name: vmiName}, vmi); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	pvs, err := r.carinaVolumes(ctx, vmi)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(pvs) == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	volumes := []string{}
+	for _, pv := range pvs {
+		volumes = append(volumes, fmt.Sprintf("%s on node %s", pv.Spec.ClaimRef.Name, pv.Spec.CSI.VolumeAttributes[utils.VolumeDeviceNode]))
+	}
+	message := fmt.Sprintf("vmi %s/%s uses carina local volumes (%s), live migration is not supported, stop the vm before moving it to another node",
+		vmi.GetNamespace(), vmi.GetName(), strings.Join(volumes, ", "))
+
+	migration := fmt.Sprintf("live migration %s/%s of vmi %s", vmim.GetNamespace(), vmim.GetName(), vmi.GetName())
+	for _, pv := range pvs {
+		if err := r.setConditions(ctx, pv.Spec.CSI.VolumeHandle, metav1.Condition{
+			Type:    utils.ConditionLiveMigratable,
+			Status:  metav1.ConditionFalse,
+			Reason:  "NodeLocalVolume",
+			Message: message,
+		}, metav1.Condition{
+			Type:    utils.ConditionLiveMigration,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Migrating",
+			Message: migration + " is being cancelled",
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// 先打上标记，删除失败时重试不会重复记录事件
+	annotations := vmim.GetAnnotations()
+	if annotations[utils.LiveMigration] != utils.LiveMigrationRejected {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[utils.LiveMigration] = utils.LiveMigrationRejected
+		vmim.SetAnnotations(annotations)
+		if err := r.Update(ctx, vmim); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Event(vmim, corev1.EventTypeWarning, "LiveMigrationRejected", message)
+		r.Recorder.Event(vmi, corev1.EventTypeWarning, "LiveMigrationRejected", message)
+	}
+
+	// KubeVirt cancels a migration when its VirtualMachineInstanceMigration is deleted
+	if err := r.Delete(ctx, vmim); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	for _, pv := range pvs {
+		if err := r.setConditions(ctx, pv.Spec.CSI.VolumeHandle, metav1.Condition{
+			Type:    utils.ConditionLiveMigration,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Cancelled",
+			Message: migration + " cancelled",
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	log.Infof("live migration %s/%s cancelled: %s", vmim.GetNamespace(), vmim.GetName(), message)
+	return ctrl.Result{}, nil
+}
+
+// carinaVolumes 返回vmi使用的carina pv，DataVolume创建的pvc与其同名
+func (r *VMMigrationReconciler) carinaVolumes(ctx context.Context, vmi *unstructured.Unstructured) ([]corev1.PersistentVolume, error) {
+	volumes, _, _ := unstructured.NestedSlice(vmi.Object, "spec", "volumes")
+
+	result := []corev1.PersistentVolume{}
+	for _, v := range volumes {
+		volume, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		claimName, _, _ := unstructured.NestedString(volume, "persistentVolumeClaim", "claimName")
+		if claimName == "" {
+			claimName, _, _ = unstructured.NestedString(volume, "dataVolume", "name")
+		}
+		if claimName == "" {
+			continue
+		}
+
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: vmi.GetNamespace(), Name: claimName}, pvc); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if pvc.Spec.VolumeName == "" {
+			continue
+		}
+		pv := &corev1.PersistentVolume{}
+		if err := r.Get(ctx, client.ObjectKey{Name: pvc.Spec.VolumeName}, pv); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != utils.CSIPluginName || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		result = append(result, *pv)
+	}
+	return result, nil
+}
+
+// setConditions 设置卷的所有LogicVolume的状态条件
+func (r *VMMigrationReconciler) setConditions(ctx context.Context, volumeID string, conditions ...metav1.Condition) error {
+	lvList := new(carinav1.LogicVolumeList)
+	if err := r.List(ctx, lvList); err != nil {
+		return err
+	}
+	for i := range lvList.Items {
+		lv := &lvList.Items[i]
+		if lv.Status.VolumeID != volumeID {
+			continue
+		}
+		for _, condition := range conditions {
+			condition.ObservedGeneration = lv.Generation
+			meta.SetStatusCondition(&lv.Status.Conditions, condition)
+		}
+		if err := r.Status().Update(ctx, lv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *VMMigrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	vmim := &unstructured.Unstructured{}
+	vmim.SetGroupVersionKind(VMIMigrationGVK)
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("vmmigration").
+		For(vmim).
+		Complete(r)
+}
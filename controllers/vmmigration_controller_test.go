@@ -0,0 +1,219 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// failingDelete 删除对象总是失败
+type failingDelete struct {
+	client.Client
+}
+
+func (c failingDelete) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return errors.New("apiserver unavailable")
+}
+
+func vmMigrationObjects(phase, driver string) []client.Object {
+	vmim := &unstructured.Unstructured{}
+	vmim.SetGroupVersionKind(VMIMigrationGVK)
+	vmim.SetNamespace("default")
+	vmim.SetName("migration-1")
+	_ = unstructured.SetNestedField(vmim.Object, "vm-centos", "spec", "vmiName")
+	if phase != "" {
+		_ = unstructured.SetNestedField(vmim.Object, phase, "status", "phase")
+	}
+
+	vmi := &unstructured.Unstructured{}
+	vmi.SetGroupVersionKind(vmiGVK)
+	vmi.SetNamespace("default")
+	vmi.SetName("vm-centos")
+	_ = unstructured.SetNestedSlice(vmi.Object, []interface{}{
+		map[string]interface{}{"name": "rootdisk", "dataVolume": map[string]interface{}{"name": "centos-rootdisk"}},
+		map[string]interface{}{"name": "datadisk", "persistentVolumeClaim": map[string]interface{}{"claimName": "centos-datadisk"}},
+		map[string]interface{}{"name": "cloudinit", "cloudInitNoCloud": map[string]interface{}{"userData": "#cloud-config"}},
+	}, "spec", "volumes")
+
+	objects := []client.Object{vmim, vmi}
+	for _, name := range []string{"centos-rootdisk", "centos-datadisk"} {
+		objects = append(objects,
+			&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+				Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-" + name},
+			},
+			&corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pv-" + name},
+				Spec: corev1.PersistentVolumeSpec{
+					ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: name},
+					PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+						Driver:           driver,
+						VolumeHandle:     "volume-pv-" + name,
+						VolumeAttributes: map[string]string{utils.VolumeDeviceNode: "node1"},
+					}},
+				},
+			},
+			&carinav1.LogicVolume{
+				ObjectMeta: metav1.ObjectMeta{Namespace: utils.LogicVolumeNamespace, Name: "pv-" + name},
+				Status:     carinav1.LogicVolumeStatus{VolumeID: "volume-pv-" + name},
+			},
+		)
+	}
+	return objects
+}
+
+func TestVMMigrationReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	scheme.AddKnownTypeWithName(VMIMigrationGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(vmiGVK, &unstructured.Unstructured{})
+
+	table := []struct {
+		name      string
+		objects   []client.Object
+		failing   bool
+		expectErr bool
+		// 期望的迁移请求注解，迁移请求被删除时为nil
+		annotations map[string]string
+		// 期望的LogicVolume条件原因，为空时没有该条件
+		migratable string
+		migration  string
+		events     int
+	}{
+		{
+			name:       "cancelled",
+			objects:    vmMigrationObjects("Scheduling", utils.CSIPluginName),
+			migratable: "NodeLocalVolume",
+			migration:  "Cancelled",
+			events:     2,
+		},
+		{
+			name:        "cancel fails",
+			objects:     vmMigrationObjects("Running", utils.CSIPluginName),
+			failing:     true,
+			expectErr:   true,
+			annotations: map[string]string{utils.LiveMigration: utils.LiveMigrationRejected},
+			migratable:  "NodeLocalVolume",
+			migration:   "Migrating",
+			events:      2,
+		},
+		{
+			name:        "other driver",
+			objects:     vmMigrationObjects("Running", "rbd.csi.ceph.com"),
+			annotations: map[string]string{},
+		},
+		{
+			name:        "finished",
+			objects:     vmMigrationObjects("Succeeded", utils.CSIPluginName),
+			annotations: map[string]string{},
+		},
+	}
+
+	for _, c := range table {
+		t.Run(c.name, func(t *testing.T) {
+			var cl client.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(c.objects...).Build()
+			if c.failing {
+				cl = failingDelete{cl}
+			}
+			recorder := record.NewFakeRecorder(10)
+			r := &VMMigrationReconciler{Client: cl, Recorder: recorder}
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "migration-1"}})
+			assert.Equal(t, c.expectErr, err != nil, "%v", err)
+
+			vmim := &unstructured.Unstructured{}
+			vmim.SetGroupVersionKind(VMIMigrationGVK)
+			err = cl.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "migration-1"}, vmim)
+			if c.annotations == nil {
+				assert.True(t, apierrors.IsNotFound(err), "%v", err)
+			} else {
+				assert.NoError(t, err)
+				annotations := vmim.GetAnnotations()
+				if annotations == nil {
+					annotations = map[string]string{}
+				}
+				assert.Equal(t, c.annotations, annotations)
+			}
+
+			for _, name := range []string{"pv-centos-rootdisk", "pv-centos-datadisk"} {
+				lv := &carinav1.LogicVolume{}
+				assert.NoError(t, cl.Get(context.Background(), client.ObjectKey{Namespace: utils.LogicVolumeNamespace, Name: name}, lv))
+				for conditionType, reason := range map[string]string{utils.ConditionLiveMigratable: c.migratable, utils.ConditionLiveMigration: c.migration} {
+					condition := meta.FindStatusCondition(lv.Status.Conditions, conditionType)
+					if reason == "" {
+						assert.Nil(t, condition, "%s %s", name, conditionType)
+						continue
+					}
+					if assert.NotNil(t, condition, "%s %s", name, conditionType) {
+						assert.Equal(t, reason, condition.Reason, "%s %s", name, conditionType)
+					}
+				}
+			}
+			assert.Len(t, recorder.Events, c.events)
+		})
+	}
+}
+
+// 取消失败后重试删除迁移请求，不重复记录事件
+func TestVMMigrationRetryCancel(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	scheme.AddKnownTypeWithName(VMIMigrationGVK, &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(vmiGVK, &unstructured.Unstructured{})
+
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(vmMigrationObjects("Running", utils.CSIPluginName)...).Build()
+	recorder := record.NewFakeRecorder(10)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "migration-1"}}
+
+	_, err := (&VMMigrationReconciler{Client: failingDelete{cl}, Recorder: recorder}).Reconcile(context.Background(), req)
+	assert.Error(t, err)
+	assert.Len(t, recorder.Events, 2)
+
+	_, err = (&VMMigrationReconciler{Client: cl, Recorder: recorder}).Reconcile(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Len(t, recorder.Events, 2)
+
+	vmim := &unstructured.Unstructured{}
+	vmim.SetGroupVersionKind(VMIMigrationGVK)
+	assert.True(t, apierrors.IsNotFound(cl.Get(context.Background(), req.NamespacedName, vmim)))
+	lv := &carinav1.LogicVolume{}
+	assert.NoError(t, cl.Get(context.Background(), client.ObjectKey{Namespace: utils.LogicVolumeNamespace, Name: "pv-centos-datadisk"}, lv))
+	condition := meta.FindStatusCondition(lv.Status.Conditions, utils.ConditionLiveMigration)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Cancelled", condition.Reason)
+		assert.Equal(t, "live migration default/migration-1 of vmi vm-centos cancelled", condition.Message)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/volumeactivity"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// VolumeActivityExporter 导出各卷最近一次I/O的时间
+// carina_volume_last_activity_timestamp_seconds is the status.lastActivity written by the
+// node, or the creation of the LogicVolume if it never saw I/O, so that idle pvcs can be
+// found with time() - carina_volume_last_activity_timestamp_seconds.
+type VolumeActivityExporter struct {
+	client.Client
+
+	lastActivity *prometheus.GaugeVec
+	mu           sync.Mutex
+	// exported 每个LogicVolume已导出的指标标签，删除时清理
+	exported map[string]prometheus.Labels
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch
+
+func (r *VolumeActivityExporter) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	lv := &carinav1.LogicVolume{}
+	if err := r.Get(ctx, req.NamespacedName, lv); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.export(req.Name, nil, 0)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if lv.Spec.Pvc == "" || lv.DeletionTimestamp != nil {
+		r.export(lv.Name, nil, 0)
+		return ctrl.Result{}, nil
+	}
+	labels := prometheus.Labels{"node": lv.Spec.NodeName, "logicvolume": lv.Name, "namespace": lv.Spec.NameSpace, "pvc": lv.Spec.Pvc}
+	r.export(lv.Name, labels, float64(volumeactivity.LastActivity(lv).Unix()))
+	return ctrl.Result{}, nil
+}
+
+// export 替换LogicVolume的指标，labels为空时删除
+func (r *VolumeActivityExporter) export(name string, labels prometheus.Labels, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.exported[name]; ok {
+		r.lastActivity.Delete(old)
+		delete(r.exported, name)
+	}
+	if labels != nil {
+		r.lastActivity.With(labels).Set(value)
+		r.exported[name] = labels
+	}
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *VolumeActivityExporter) SetupWithManager(mgr ctrl.Manager) error {
+	r.exported = map[string]prometheus.Labels{}
+	r.lastActivity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "carina",
+		Subsystem: "volume",
+		Name:      "last_activity_timestamp_seconds",
+		Help:      "Last time the node saw I/O on the volume with an hourly granularity, the creation of the volume if it saw none",
+	}, []string{"node", "logicvolume", "namespace", "pvc"})
+	if err := metrics.Registry.Register(r.lastActivity); err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("volumeactivity").
+		For(&carinav1.LogicVolume{}).
+		Complete(r)
+}
@@ -0,0 +1,97 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/volumeactivity"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// VolumeActivityRecorder 记录本节点卷最近一次有I/O的时间
+// It samples /proc/diskstats every few minutes and writes status.lastActivity of the
+// LogicVolumes that saw I/O, at most once per RecordGranularity. The counters are kept in
+// memory, the first sample after a restart of carina-node is only a baseline.
+type VolumeActivityRecorder struct {
+	client.Client
+	DM *deviceManager.DeviceManager
+
+	tracker *volumeactivity.Tracker
+}
+
+var _ manager.LeaderElectionRunnable = &VolumeActivityRecorder{}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes/status,verbs=get;update;patch
+
+// Start implements controller-runtime's manager.Runnable.
+func (r *VolumeActivityRecorder) Start(ctx context.Context) error {
+	r.tracker = volumeactivity.NewTracker()
+	ticker := time.NewTicker(volumeactivity.SampleInterval)
+	defer ticker.Stop()
+	for {
+		r.sample(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *VolumeActivityRecorder) sample(ctx context.Context) {
+	counters, err := r.DM.VolumeIOCounters()
+	if err != nil {
+		log.Warnf("read volume io counters failed: %s", err.Error())
+		return
+	}
+	now := time.Now()
+	for _, name := range r.tracker.Observe(counters) {
+		if err := r.record(ctx, name, now); err != nil {
+			log.Warnf("record activity of logic volume %s failed: %s", name, err.Error())
+		}
+	}
+}
+
+func (r *VolumeActivityRecorder) record(ctx context.Context, name string, now time.Time) error {
+	lv := &carinav1.LogicVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: utils.LogicVolumeNamespace}, lv); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !volumeactivity.NeedsRecord(lv, now) {
+		return nil
+	}
+	lv2 := lv.DeepCopy()
+	lv2.Status.LastActivity = &metav1.Time{Time: now}
+	return r.Status().Patch(ctx, lv2, client.MergeFrom(lv))
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (r *VolumeActivityRecorder) NeedLeaderElection() bool {
+	return false
+}
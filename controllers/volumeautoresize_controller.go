@@ -0,0 +1,159 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/autoresize"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// VolumeAutoresizeReconciler 文件系统使用率超过阈值时自动扩容pvc
+// carina-node records the filesystem usage of mounted volumes in status.usage of their
+// LogicVolume. Once it reaches carina.storage.io/autoresize-threshold of the pvc, the request
+// of the pvc grows by carina.storage.io/autoresize-step, at most to
+// carina.storage.io/autoresize-max-size, and the external-resizer expands the volume as usual.
+// A pvc is only expanded again after the previous expansion is complete.
+type VolumeAutoresizeReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch
+
+func (r *VolumeAutoresizeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if !configuration.Autoresize() {
+		return ctrl.Result{}, nil
+	}
+	lv := &carinav1.LogicVolume{}
+	if err := r.Get(ctx, req.NamespacedName, lv); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if lv.Status.Usage == nil || lv.Spec.Pvc == "" || lv.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: lv.Spec.NameSpace, Name: lv.Spec.Pvc}, pvc); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if pvc.Spec.VolumeName != lv.Name || pvc.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+	policy, err := autoresize.ParsePolicy(pvc.Annotations)
+	if err != nil {
+		r.Recorder.Event(pvc, corev1.EventTypeWarning, "InvalidAutoresize", err.Error())
+		return ctrl.Result{}, nil
+	}
+	if policy == nil || resizing(pvc) {
+		return ctrl.Result{}, nil
+	}
+
+	usage := *lv.Status.Usage
+	request := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	size, ok := autoresize.NextSize(policy, request, usage)
+	if !ok {
+		if usage >= policy.Threshold {
+			r.Recorder.Eventf(pvc, corev1.EventTypeWarning, "AutoresizeMaxSizeReached", "filesystem usage %d%% reached threshold %d%%, but the request %s is already at max size %s", usage, policy.Threshold, request.String(), policy.MaxSize.String())
+		}
+		return ctrl.Result{}, nil
+	}
+
+	pvc2 := pvc.DeepCopy()
+	pvc2.Spec.Resources.Requests[corev1.ResourceStorage] = size
+	if err := r.Patch(ctx, pvc2, client.MergeFromWithOptions(pvc, client.MergeFromWithOptimisticLock{})); err != nil {
+		if apierrors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		r.Recorder.Eventf(pvc, corev1.EventTypeWarning, "AutoresizeFailed", "expand from %s to %s: %s", request.String(), size.String(), err.Error())
+		// storageclass不允许扩容等情况重试也不会成功
+		if apierrors.IsForbidden(err) || apierrors.IsInvalid(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	message := fmt.Sprintf("filesystem usage %d%% reached threshold %d%%, expanding from %s to %s", usage, policy.Threshold, request.String(), size.String())
+	log.Infof("pvc %s/%s %s", pvc.Namespace, pvc.Name, message)
+	r.Recorder.Event(pvc, corev1.EventTypeNormal, "VolumeAutoresized", message)
+	return ctrl.Result{}, nil
+}
+
+// resizing pvc的上一次扩容是否还没完成
+func resizing(pvc *corev1.PersistentVolumeClaim) bool {
+	if pvc.Status.Phase != corev1.ClaimBound {
+		return true
+	}
+	request := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+	if capacity.Cmp(request) < 0 {
+		return true
+	}
+	for _, c := range pvc.Status.Conditions {
+		if (c.Type == corev1.PersistentVolumeClaimResizing || c.Type == corev1.PersistentVolumeClaimFileSystemResizePending) && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAutoresize(pvc *corev1.PersistentVolumeClaim) bool {
+	return pvc.Annotations[utils.VolumeAutoresizeThreshold] != "" && pvc.Spec.VolumeName != ""
+}
+
+// volumeOfClaim pvc的注解或扩容状态变化时重新检查其卷，LogicVolume与pv同名
+func (r *VolumeAutoresizeReconciler) volumeOfClaim(o client.Object) []reconcile.Request {
+	pvc, ok := o.(*corev1.PersistentVolumeClaim)
+	if !ok || !hasAutoresize(pvc) {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: pvc.Spec.VolumeName, Namespace: utils.LogicVolumeNamespace}}}
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *VolumeAutoresizeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	pred := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool { return e.Object.(*carinav1.LogicVolume).Status.Usage != nil },
+		DeleteFunc: func(event.DeleteEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			old, lv := e.ObjectOld.(*carinav1.LogicVolume), e.ObjectNew.(*carinav1.LogicVolume)
+			return lv.Status.Usage != nil && (old.Status.Usage == nil || *old.Status.Usage != *lv.Status.Usage)
+		},
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("volumeautoresize").
+		For(&carinav1.LogicVolume{}, builder.WithPredicates(pred)).
+		Watches(&source.Kind{Type: &corev1.PersistentVolumeClaim{}}, handler.EnqueueRequestsFromMapFunc(r.volumeOfClaim)).
+		Complete(r)
+}
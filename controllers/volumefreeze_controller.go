@@ -0,0 +1,219 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/volumefreeze"
+	"github.com/carina-io/carina/utils/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// VolumeFreezeReconciler 在节点上冻结和解冻VolumeFreeze选中的本节点卷
+// Every volume frozen here is also thawed by a local timer at the thaw deadline, without
+// the api server or carina-controller. After a restart of carina-node the timers are armed
+// again from the status of the freezes, a reboot of the node thaws everything anyway.
+type VolumeFreezeReconciler struct {
+	client.Client
+	NodeName string
+	DM       *deviceManager.DeviceManager
+
+	mu     sync.Mutex
+	timers map[types.NamespacedName]*thawTimer
+}
+
+// thawTimer 到截止时间时解冻本节点冻结的卷
+type thawTimer struct {
+	timer *time.Timer
+	pvs   map[string]bool
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumefreezes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumefreezes/status,verbs=get;update;patch
+
+func (r *VolumeFreezeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	vf := &carinav1.VolumeFreeze{}
+	if err := r.Get(ctx, req.NamespacedName, vf); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	thaw := volumefreeze.ShouldThaw(vf, now)
+	local := r.localFrozen(req.NamespacedName)
+	changed := map[string]carinav1.FrozenVolume{}
+	frozen := []string{}
+	for _, v := range vf.Status.Volumes {
+		if v.Node != r.NodeName {
+			continue
+		}
+		switch {
+		case thaw && (v.Frozen || local[v.PV]):
+			if err := r.DM.ThawVolume(v.PV); err != nil {
+				log.Errorf("thaw volume %s of freeze %s failed: %s", v.PV, req.NamespacedName, err.Error())
+				v.Message = err.Error()
+				frozen = append(frozen, v.PV)
+			} else {
+				v.Frozen = false
+				v.Message = ""
+			}
+		case !thaw && vf.Status.Phase == carinav1.VolumeFreezeFreezing && !v.Frozen && v.Message == "":
+			if err := r.DM.FreezeVolume(v.PV); err != nil {
+				log.Errorf("freeze volume %s of freeze %s failed: %s", v.PV, req.NamespacedName, err.Error())
+				v.Message = err.Error()
+			} else {
+				v.Frozen = true
+				frozen = append(frozen, v.PV)
+			}
+		default:
+			if v.Frozen {
+				frozen = append(frozen, v.PV)
+			}
+			continue
+		}
+		changed[v.PV] = v
+	}
+
+	if thaw {
+		r.disarm(req.NamespacedName)
+	} else if len(frozen) > 0 && vf.Status.ThawDeadline != nil {
+		r.arm(req.NamespacedName, vf.Status.ThawDeadline.Time, frozen)
+	}
+
+	if len(changed) > 0 {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			latest := &carinav1.VolumeFreeze{}
+			if err := r.Get(ctx, req.NamespacedName, latest); err != nil {
+				return err
+			}
+			for i, v := range latest.Status.Volumes {
+				if c, ok := changed[v.PV]; ok && v.Node == r.NodeName {
+					latest.Status.Volumes[i] = c
+				}
+			}
+			return r.Status().Update(ctx, latest)
+		})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if thaw && len(frozen) > 0 {
+		// 解冻失败时重试，截止时间已过不再依赖定时器
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+	if !thaw && vf.Status.ThawDeadline != nil {
+		return ctrl.Result{RequeueAfter: vf.Status.ThawDeadline.Sub(now)}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *VolumeFreezeReconciler) localFrozen(key types.NamespacedName) map[string]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := map[string]bool{}
+	if t, ok := r.timers[key]; ok {
+		for pv := range t.pvs {
+			result[pv] = true
+		}
+	}
+	return result
+}
+
+// arm 设置或更新freeze的解冻定时器
+func (r *VolumeFreezeReconciler) arm(key types.NamespacedName, deadline time.Time, pvs []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timers == nil {
+		r.timers = map[types.NamespacedName]*thawTimer{}
+	}
+	t, ok := r.timers[key]
+	if !ok {
+		t = &thawTimer{pvs: map[string]bool{}}
+		r.timers[key] = t
+		t.timer = time.AfterFunc(time.Until(deadline), func() { r.expire(key) })
+	}
+	for _, pv := range pvs {
+		t.pvs[pv] = true
+	}
+}
+
+func (r *VolumeFreezeReconciler) disarm(key types.NamespacedName) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.timers[key]; ok {
+		t.timer.Stop()
+		delete(r.timers, key)
+	}
+}
+
+// expire 截止时间到达时解冻卷，状态由之后的Reconcile上报
+func (r *VolumeFreezeReconciler) expire(key types.NamespacedName) {
+	r.mu.Lock()
+	t, ok := r.timers[key]
+	delete(r.timers, key)
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	for pv := range t.pvs {
+		if err := r.DM.ThawVolume(pv); err != nil {
+			log.Errorf("thaw volume %s of freeze %s at the deadline failed: %s", pv, key, err.Error())
+			continue
+		}
+		log.Warnf("volume %s of freeze %s thawed at the deadline", pv, key)
+	}
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *VolumeFreezeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	mine := func(o client.Object) bool {
+		vf, ok := o.(*carinav1.VolumeFreeze)
+		if !ok {
+			return false
+		}
+		for _, v := range vf.Status.Volumes {
+			if v.Node == r.NodeName {
+				return true
+			}
+		}
+		return false
+	}
+	pred := predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return mine(e.Object) },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return mine(e.ObjectNew) },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("volumefreeze").
+		WithEventFilter(pred).
+		For(&carinav1.VolumeFreeze{}).
+		Complete(r)
+}
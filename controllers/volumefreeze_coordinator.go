@@ -0,0 +1,185 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/volumefreeze"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VolumeFreezeCoordinator 选出VolumeFreeze要冻结的卷，并根据节点上报的结果推进其状态
+// The nodes freeze and thaw the volumes themselves and thaw them at the deadline on their
+// own, the coordinator only decides when to thaw. The finalizer keeps a deleted freeze
+// around until its volumes are thawed.
+type VolumeFreezeCoordinator struct {
+	client.Client
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumefreezes,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumefreezes/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumefreezes/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch
+
+func (r *VolumeFreezeCoordinator) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	vf := &carinav1.VolumeFreeze{}
+	if err := r.Get(ctx, req.NamespacedName, vf); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	now := time.Now()
+
+	switch vf.Status.Phase {
+	case "":
+		if vf.DeletionTimestamp != nil {
+			return ctrl.Result{}, r.removeFinalizer(ctx, vf)
+		}
+		return r.start(ctx, vf, now)
+	case carinav1.VolumeFreezeThawed, carinav1.VolumeFreezeFailed:
+		if vf.DeletionTimestamp != nil {
+			return ctrl.Result{}, r.removeFinalizer(ctx, vf)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	phase, message := volumefreeze.Next(vf, now)
+	if phase != vf.Status.Phase {
+		vf.Status.Phase = phase
+		vf.Status.Message = message
+		if phase == carinav1.VolumeFreezeFrozen {
+			vf.Status.FrozenAt = &metav1.Time{Time: now}
+		}
+		if err := r.Status().Update(ctx, vf); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Infof("volume freeze %s/%s %s %s", vf.Namespace, vf.Name, phase, message)
+		if phase == carinav1.VolumeFreezeFailed {
+			r.Recorder.Event(vf, corev1.EventTypeWarning, phase, message)
+		} else {
+			r.Recorder.Event(vf, corev1.EventTypeNormal, phase, fmt.Sprintf("%d volumes %s", len(vf.Status.Volumes), message))
+		}
+	}
+	return ctrl.Result{RequeueAfter: volumefreeze.RequeueAfter(vf, now)}, nil
+}
+
+// start 添加finalizer，记录选中的卷和解冻截止时间，由各节点开始冻结
+func (r *VolumeFreezeCoordinator) start(ctx context.Context, vf *carinav1.VolumeFreeze, now time.Time) (ctrl.Result, error) {
+	if !utils.ContainsString(vf.Finalizers, utils.VolumeFreezeFinalizer) {
+		vf.Finalizers = append(vf.Finalizers, utils.VolumeFreezeFinalizer)
+		if err := r.Update(ctx, vf); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	volumes, err := r.selectVolumes(ctx, vf)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(volumes) == 0 {
+		vf.Status.Phase = carinav1.VolumeFreezeFailed
+		vf.Status.Message = "no bound carina filesystem pvc selected"
+		r.Recorder.Event(vf, corev1.EventTypeWarning, vf.Status.Phase, vf.Status.Message)
+		return ctrl.Result{}, r.Status().Update(ctx, vf)
+	}
+
+	timeout := volumefreeze.Timeout(vf)
+	vf.Status.Phase = carinav1.VolumeFreezeFreezing
+	vf.Status.Volumes = volumes
+	vf.Status.ThawDeadline = &metav1.Time{Time: now.Add(timeout)}
+	if err := r.Status().Update(ctx, vf); err != nil {
+		return ctrl.Result{}, err
+	}
+	log.Infof("volume freeze %s/%s freezing %d volumes, thaw deadline %s", vf.Namespace, vf.Name, len(volumes), vf.Status.ThawDeadline.Format(time.RFC3339))
+	r.Recorder.Event(vf, corev1.EventTypeNormal, vf.Status.Phase, fmt.Sprintf("freezing %d volumes for at most %s", len(volumes), timeout))
+	return ctrl.Result{RequeueAfter: timeout}, nil
+}
+
+// selectVolumes 返回选中的已绑定carina文件系统卷，块设备卷没有文件系统可冻结
+func (r *VolumeFreezeCoordinator) selectVolumes(ctx context.Context, vf *carinav1.VolumeFreeze) ([]carinav1.FrozenVolume, error) {
+	selector := labels.Everything()
+	if vf.Spec.Selector != nil {
+		s, err := metav1.LabelSelectorAsSelector(vf.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		selector = s
+	}
+	pvcList := new(corev1.PersistentVolumeClaimList)
+	if err := r.List(ctx, pvcList, client.InNamespace(vf.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+
+	volumes := []carinav1.FrozenVolume{}
+	for _, pvc := range pvcList.Items {
+		if pvc.Status.Phase != corev1.ClaimBound || pvc.DeletionTimestamp != nil {
+			continue
+		}
+		if pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == corev1.PersistentVolumeBlock {
+			continue
+		}
+		pv := &corev1.PersistentVolume{}
+		if err := r.Get(ctx, client.ObjectKey{Name: pvc.Spec.VolumeName}, pv); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != utils.CSIPluginName {
+			continue
+		}
+		node := pv.Spec.CSI.VolumeAttributes[utils.VolumeDeviceNode]
+		if node == "" {
+			continue
+		}
+		volumes = append(volumes, carinav1.FrozenVolume{PVC: pvc.Name, PV: pv.Name, Node: node})
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].PVC < volumes[j].PVC })
+	return volumes, nil
+}
+
+func (r *VolumeFreezeCoordinator) removeFinalizer(ctx context.Context, vf *carinav1.VolumeFreeze) error {
+	if !utils.ContainsString(vf.Finalizers, utils.VolumeFreezeFinalizer) {
+		return nil
+	}
+	vf.Finalizers = utils.SliceRemoveString(vf.Finalizers, utils.VolumeFreezeFinalizer)
+	return r.Update(ctx, vf)
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *VolumeFreezeCoordinator) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("volumefreeze-coordinator").
+		For(&carinav1.VolumeFreeze{}).
+		Complete(r)
+}
@@ -0,0 +1,378 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// VolumeOperationReconciler 执行经过webhook授权的卷运维操作
+// The node daemons only act on LogicVolumes written by carina-controller, users never
+// need exec access to the node pods. An operation that was not admitted by the webhook
+// carries no requester and is refused.
+type VolumeOperationReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumeoperations,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumeoperations/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+
+func (r *VolumeOperationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	op := &carinav1.VolumeOperation{}
+	if err := r.Get(ctx, req.NamespacedName, op); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if finished(op) || op.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+	if op.Annotations[utils.VolumeOperationRequester] == "" {
+		return ctrl.Result{}, r.finish(ctx, op, carinav1.VolumeOperationFailed, "the operation was not admitted by the carina webhook")
+	}
+
+	lv := &carinav1.LogicVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: op.Spec.LogicVolume, Namespace: utils.LogicVolumeNamespace}, lv); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		if op.Status.Phase == carinav1.VolumeOperationRunning {
+			return ctrl.Result{}, r.finish(ctx, op, carinav1.VolumeOperationSucceeded, fmt.Sprintf("logicvolume %s removed", op.Spec.LogicVolume))
+		}
+		return ctrl.Result{}, r.finish(ctx, op, carinav1.VolumeOperationFailed, fmt.Sprintf("logicvolume %s not found", op.Spec.LogicVolume))
+	}
+
+	switch op.Spec.Operation {
+	case carinav1.VolumeOperationForceDelete, carinav1.VolumeOperationWipe:
+		return r.remove(ctx, op, lv)
+	case carinav1.VolumeOperationAdopt:
+		return ctrl.Result{}, r.adopt(ctx, op, lv)
+	}
+	return ctrl.Result{}, r.finish(ctx, op, carinav1.VolumeOperationFailed, fmt.Sprintf("unknown operation %s", op.Spec.Operation))
+}
+
+// remove 删除LogicVolume及其未绑定的pv，Wipe先由节点按策略擦除数据
+// ForceDelete drops the finalizer when the node is gone, the data stays on its disks.
+func (r *VolumeOperationReconciler) remove(ctx context.Context, op *carinav1.VolumeOperation, lv *carinav1.LogicVolume) (ctrl.Result, error) {
+	ready, err := r.nodeReady(ctx, lv.Spec.NodeName)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if op.Status.Phase == carinav1.VolumeOperationRunning {
+		if op.Spec.Operation == carinav1.VolumeOperationForceDelete && !ready && utils.ContainsString(lv.Finalizers, utils.LogicVolumeFinalizer) {
+			lv2 := lv.DeepCopy()
+			lv2.Finalizers = utils.SliceRemoveString(lv2.Finalizers, utils.LogicVolumeFinalizer)
+			if err := r.Patch(ctx, lv2, client.MergeFrom(lv)); err != nil {
+				return ctrl.Result{}, err
+			}
+			log.Warnf("node %s of logicvolume %s is not ready, finalizer removed, the volume is left on its disks", lv.Spec.NodeName, lv.Name)
+		}
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	if op.Spec.Operation == carinav1.VolumeOperationWipe {
+		if lv.Annotations[utils.SnapshotSource] != "" {
+			return ctrl.Result{}, r.finish(ctx, op, carinav1.VolumeOperationFailed, "a snapshot shares its data with the volume and can not be wiped")
+		}
+		if !ready {
+			return ctrl.Result{}, r.finish(ctx, op, carinav1.VolumeOperationFailed, fmt.Sprintf("node %s is not ready, the volume can not be wiped", lv.Spec.NodeName))
+		}
+	}
+
+	if lv.Spec.Pvc != "" {
+		pods, err := r.podsUsingClaim(ctx, lv.Spec.NameSpace, lv.Spec.Pvc)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if len(pods) > 0 {
+			return ctrl.Result{}, r.finish(ctx, op, carinav1.VolumeOperationFailed, fmt.Sprintf("pvc %s/%s is used by pod %s", lv.Spec.NameSpace, lv.Spec.Pvc, pods[0]))
+		}
+	}
+
+	lvs := []carinav1.LogicVolume{*lv}
+	pvs := []corev1.PersistentVolume{}
+	if lv.Status.VolumeID != "" {
+		if lvs, err = volumeLogicVolumes(ctx, r.Client, lv.Status.VolumeID); err != nil {
+			return ctrl.Result{}, err
+		}
+		if pvs, err = r.volumePVs(ctx, lv.Status.VolumeID); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	for _, pv := range pvs {
+		if pv.Status.Phase == corev1.VolumeBound {
+			return ctrl.Result{}, r.finish(ctx, op, carinav1.VolumeOperationFailed, fmt.Sprintf("pv %s is bound, delete pvc %s/%s first", pv.Name, pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name))
+		}
+	}
+
+	// 与回收Released卷相同，先全部打上擦除策略再删除
+	if op.Spec.Operation == carinav1.VolumeOperationWipe {
+		policy := op.Spec.WipePolicy
+		if policy == "" {
+			policy = utils.WipePolicyZero
+		}
+		for i := range lvs {
+			l := &lvs[i]
+			if l.DeletionTimestamp != nil || l.Annotations[utils.VolumeWipePolicy] == policy {
+				continue
+			}
+			if l.Annotations == nil {
+				l.Annotations = map[string]string{}
+			}
+			l.Annotations[utils.VolumeWipePolicy] = policy
+			if err := r.Update(ctx, l); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+	for i := range pvs {
+		if err := r.Delete(ctx, &pvs[i]); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+	}
+	for i := range lvs {
+		if lvs[i].DeletionTimestamp != nil {
+			continue
+		}
+		if err := r.Delete(ctx, &lvs[i]); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+	}
+
+	requester := op.Annotations[utils.VolumeOperationRequester]
+	log.Infof("volume operation %s: %s logicvolume %s on node %s requested by %s", op.Name, op.Spec.Operation, lv.Name, lv.Spec.NodeName, requester)
+	r.Recorder.Event(lv, corev1.EventTypeWarning, string(op.Spec.Operation), fmt.Sprintf("requested by %s with volume operation %s", requester, op.Name))
+	op.Status.Phase = carinav1.VolumeOperationRunning
+	op.Status.Message = fmt.Sprintf("deleting logicvolume %s", lv.Name)
+	if err := r.Status().Update(ctx, op); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+}
+
+// adopt 为没有pv的LogicVolume创建预绑定到pvc的pv，pv回收策略为Retain
+func (r *VolumeOperationReconciler) adopt(ctx context.Context, op *carinav1.VolumeOperation, lv *carinav1.LogicVolume) error {
+	switch {
+	case lv.DeletionTimestamp != nil:
+		return r.finish(ctx, op, carinav1.VolumeOperationFailed, fmt.Sprintf("logicvolume %s is being deleted", lv.Name))
+	case lv.Status.VolumeID == "" || lv.Status.Status != "Success":
+		return r.finish(ctx, op, carinav1.VolumeOperationFailed, fmt.Sprintf("logicvolume %s has not been created on its node", lv.Name))
+	case lv.Annotations[utils.VolumeManagerType] != utils.LvmVolumeType:
+		return r.finish(ctx, op, carinav1.VolumeOperationFailed, "only lvm volumes can be adopted")
+	case lv.Annotations[utils.SnapshotSource] != "":
+		return r.finish(ctx, op, carinav1.VolumeOperationFailed, "snapshots can not be adopted, restore them into a new pvc")
+	case len(lv.OwnerReferences) > 0:
+		return r.finish(ctx, op, carinav1.VolumeOperationFailed, "the cache volume of a bcache volume can not be adopted")
+	}
+
+	pvs, err := r.volumePVs(ctx, lv.Status.VolumeID)
+	if err != nil {
+		return err
+	}
+	if len(pvs) > 0 {
+		return r.finish(ctx, op, carinav1.VolumeOperationFailed, fmt.Sprintf("logicvolume %s already has pv %s", lv.Name, pvs[0].Name))
+	}
+
+	claim := op.Spec.ClaimRef
+	volumeMode := corev1.PersistentVolumeFilesystem
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: claim.Namespace, Name: claim.Name}, pvc); err == nil {
+		if pvc.Spec.VolumeName != "" {
+			return r.finish(ctx, op, carinav1.VolumeOperationFailed, fmt.Sprintf("pvc %s/%s is already bound to pv %s", claim.Namespace, claim.Name, pvc.Spec.VolumeName))
+		}
+		if pvc.Spec.VolumeMode != nil {
+			volumeMode = *pvc.Spec.VolumeMode
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	attributes := map[string]string{
+		utils.DeviceDiskKey:     lv.Spec.DeviceGroup,
+		utils.VolumeDevicePath:  fmt.Sprintf("/dev/%s/%s", lv.Spec.DeviceGroup, lv.Status.VolumeID),
+		utils.VolumeDeviceNode:  lv.Spec.NodeName,
+		utils.VolumeDeviceMajor: fmt.Sprintf("%d", lv.Status.DeviceMajor),
+		utils.VolumeDeviceMinor: fmt.Sprintf("%d", lv.Status.DeviceMinor),
+	}
+	if lv.Annotations[utils.VolumeEncrypted] == "true" {
+		attributes[utils.VolumeEncrypted] = "true"
+	}
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: lv.Name,
+			Annotations: map[string]string{
+				"pv.kubernetes.io/provisioned-by": utils.CSIPluginName,
+				utils.VolumeOperationRequester:    op.Annotations[utils.VolumeOperationRequester],
+			},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity:                      corev1.ResourceList{corev1.ResourceStorage: lv.Spec.Size},
+			AccessModes:                   []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+			StorageClassName:              op.Spec.StorageClassName,
+			VolumeMode:                    &volumeMode,
+			ClaimRef: &corev1.ObjectReference{
+				Kind:       "PersistentVolumeClaim",
+				APIVersion: "v1",
+				Namespace:  claim.Namespace,
+				Name:       claim.Name,
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:           utils.CSIPluginName,
+					VolumeHandle:     lv.Status.VolumeID,
+					VolumeAttributes: attributes,
+				},
+			},
+			NodeAffinity: &corev1.VolumeNodeAffinity{
+				Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+						MatchExpressions: []corev1.NodeSelectorRequirement{{
+							Key:      utils.TopologyNodeKey,
+							Operator: corev1.NodeSelectorOpIn,
+							Values:   []string{lv.Spec.NodeName},
+						}},
+					}},
+				},
+			},
+		},
+	}
+	if err := r.Create(ctx, pv); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return r.finish(ctx, op, carinav1.VolumeOperationFailed, fmt.Sprintf("pv %s already exists", pv.Name))
+		}
+		return err
+	}
+
+	if lv.Spec.NameSpace != claim.Namespace || lv.Spec.Pvc != claim.Name {
+		lv2 := lv.DeepCopy()
+		lv2.Spec.NameSpace = claim.Namespace
+		lv2.Spec.Pvc = claim.Name
+		if err := r.Patch(ctx, lv2, client.MergeFrom(lv)); err != nil {
+			return err
+		}
+	}
+
+	requester := op.Annotations[utils.VolumeOperationRequester]
+	log.Infof("volume operation %s: logicvolume %s adopted by pvc %s/%s, requested by %s", op.Name, lv.Name, claim.Namespace, claim.Name, requester)
+	r.Recorder.Event(lv, corev1.EventTypeNormal, string(op.Spec.Operation), fmt.Sprintf("pv created for pvc %s/%s, requested by %s", claim.Namespace, claim.Name, requester))
+	return r.finish(ctx, op, carinav1.VolumeOperationSucceeded, fmt.Sprintf("pv %s created for pvc %s/%s", pv.Name, claim.Namespace, claim.Name))
+}
+
+func (r *VolumeOperationReconciler) finish(ctx context.Context, op *carinav1.VolumeOperation, phase, message string) error {
+	op.Status.Phase = phase
+	op.Status.Message = message
+	op.Status.CompletionTime = &metav1.Time{Time: time.Now()}
+	if phase == carinav1.VolumeOperationFailed {
+		r.Recorder.Event(op, corev1.EventTypeWarning, "OperationFailed", message)
+	} else {
+		r.Recorder.Event(op, corev1.EventTypeNormal, "OperationSucceeded", message)
+	}
+	return r.Status().Update(ctx, op)
+}
+
+func (r *VolumeOperationReconciler) nodeReady(ctx context.Context, name string) (bool, error) {
+	node := &corev1.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: name}, node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue, nil
+		}
+	}
+	return false, nil
+}
+
+// podsUsingClaim 返回使用pvc且未结束的pod
+func (r *VolumeOperationReconciler) podsUsingClaim(ctx context.Context, namespace, name string) ([]string, error) {
+	podList := new(corev1.PodList)
+	if err := r.List(ctx, podList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	pods := []string{}
+	for _, p := range podList.Items {
+		if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, v := range p.Spec.Volumes {
+			if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == name {
+				pods = append(pods, p.Name)
+				break
+			}
+		}
+	}
+	return pods, nil
+}
+
+func (r *VolumeOperationReconciler) volumePVs(ctx context.Context, volumeID string) ([]corev1.PersistentVolume, error) {
+	pvList := new(corev1.PersistentVolumeList)
+	if err := r.List(ctx, pvList); err != nil {
+		return nil, err
+	}
+	result := []corev1.PersistentVolume{}
+	for _, pv := range pvList.Items {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == utils.CSIPluginName && pv.Spec.CSI.VolumeHandle == volumeID {
+			result = append(result, pv)
+		}
+	}
+	return result, nil
+}
+
+func finished(op *carinav1.VolumeOperation) bool {
+	return op.Status.Phase == carinav1.VolumeOperationSucceeded || op.Status.Phase == carinav1.VolumeOperationFailed
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *VolumeOperationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	pred := predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return !finished(e.Object.(*carinav1.VolumeOperation)) },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return !finished(e.ObjectNew.(*carinav1.VolumeOperation)) },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("volumeoperation").
+		WithEventFilter(pred).
+		For(&carinav1.VolumeOperation{}).
+		Complete(r)
+}
@@ -0,0 +1,456 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/autoresize"
+	deviceManager "github.com/carina-io/carina/pkg/devicemanager"
+	"github.com/carina-io/carina/pkg/devicemanager/volume"
+	"github.com/carina-io/carina/pkg/replication"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/carina-io/carina/utils/mutx"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// errReplicationConflict 接收端没有发送端的基准快照，需要全量同步
+var errReplicationConflict = errors.New("the standby does not hold the base snapshot")
+
+// VolumeReplicationAgent 在卷所在节点上发送或接收VolumeReplication的快照
+// For a primary it takes a thin snapshot every interval and ships the chunks changed since the
+// snapshot of the last complete transfer to the peer, or all chunks if the peer does not hold
+// that snapshot. For a secondary it serves the mutual TLS endpoint at Addr, writes the chunks to
+// the standby volume and snapshots it after every complete transfer, an interrupted transfer is
+// rolled back to that snapshot before the next delta or a promote.
+type VolumeReplicationAgent struct {
+	client.Client
+	NodeName string
+	DM       *deviceManager.DeviceManager
+	// Addr is the listen address of the replication endpoint, empty disables receiving
+	Addr string
+	// CertDir holds tls.crt, tls.key and ca.crt of the endpoint and of the client shipping snapshots
+	CertDir string
+
+	locks  *mutx.GlobalLocks
+	client *http.Client
+}
+
+var _ manager.LeaderElectionRunnable = &VolumeReplicationAgent{}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumereplications,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumereplications/status,verbs=get;update;patch
+
+// Start implements controller-runtime's manager.Runnable.
+func (r *VolumeReplicationAgent) Start(ctx context.Context) error {
+	r.locks = mutx.NewGlobalLocks()
+	if r.Addr != "" {
+		config, err := replication.TLSConfig(r.CertDir, true)
+		if err != nil {
+			return fmt.Errorf("replication endpoint: %v", err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc(replication.Path, r.receive)
+		server := &http.Server{Addr: r.Addr, Handler: mux, TLSConfig: config}
+		go func() {
+			<-ctx.Done()
+			_ = server.Close()
+		}()
+		go func() {
+			log.Infof("replication endpoint listening on %s", r.Addr)
+			if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				log.Errorf("replication endpoint failed: %s", err.Error())
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		vrList := &carinav1.VolumeReplicationList{}
+		if err := r.List(ctx, vrList); err != nil {
+			log.Warnf("list volume replications failed: %s", err.Error())
+			continue
+		}
+		now := time.Now()
+		for i := range vrList.Items {
+			vr := &vrList.Items[i]
+			if vr.Status.Node != r.NodeName || vr.DeletionTimestamp != nil {
+				continue
+			}
+			switch {
+			case vr.Spec.Role == carinav1.ReplicationPrimary && vr.Status.Role == carinav1.ReplicationSecondary:
+				if err := r.promote(ctx, vr); err != nil {
+					log.Warnf("promote volume %s failed: %s", vr.Status.Volume, err.Error())
+				}
+			case vr.Spec.Role == carinav1.ReplicationPrimary && vr.Status.Role == carinav1.ReplicationPrimary && vr.Spec.Peer != nil && replication.SyncDue(vr, now):
+				go r.ship(ctx, vr.DeepCopy())
+			}
+		}
+	}
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (r *VolumeReplicationAgent) NeedLeaderElection() bool {
+	return false
+}
+
+func (r *VolumeReplicationAgent) patchStatus(ctx context.Context, vr *carinav1.VolumeReplication, update func(*carinav1.VolumeReplicationStatus)) error {
+	vr2 := vr.DeepCopy()
+	update(&vr2.Status)
+	if err := r.Status().Patch(ctx, vr2, client.MergeFrom(vr)); err != nil {
+		return err
+	}
+	vr.Status = vr2.Status
+	return nil
+}
+
+func (r *VolumeReplicationAgent) logicVolume(ctx context.Context, vr *carinav1.VolumeReplication) (*carinav1.LogicVolume, error) {
+	lv := &carinav1.LogicVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: vr.Status.Volume, Namespace: utils.LogicVolumeNamespace}, lv); err != nil {
+		return nil, err
+	}
+	return lv, nil
+}
+
+// ship 创建快照并发送给对端
+func (r *VolumeReplicationAgent) ship(ctx context.Context, vr *carinav1.VolumeReplication) {
+	pv := vr.Status.Volume
+	if !r.locks.TryAcquire(pv) {
+		return
+	}
+	defer r.locks.Release(pv)
+
+	now := metav1.Now()
+	if err := r.patchStatus(ctx, vr, func(s *carinav1.VolumeReplicationStatus) { s.LastSyncAttempt = &now }); err != nil {
+		log.Warnf("update volume replication %s/%s failed: %s", vr.Namespace, vr.Name, err.Error())
+		return
+	}
+	var written int64
+	generation := now.UnixNano()
+	err := r.DM.Pool.Run(ctx, mutx.PriorityBackground, "replicate "+pv, func() error {
+		var err error
+		written, err = r.shipSnapshot(ctx, vr, generation)
+		return err
+	})
+	if err != nil {
+		log.Warnf("replicate volume %s to %s failed: %s", pv, vr.Spec.Peer.Address, err.Error())
+		_ = r.patchStatus(ctx, vr, func(s *carinav1.VolumeReplicationStatus) { s.LastSyncError = err.Error() })
+		return
+	}
+	done := metav1.Now()
+	log.Infof("replicated volume %s to %s, %d bytes in %s", pv, vr.Spec.Peer.Address, written, done.Sub(now.Time))
+	_ = r.patchStatus(ctx, vr, func(s *carinav1.VolumeReplicationStatus) {
+		s.SyncGeneration = generation
+		s.LastSyncTime = &done
+		s.LastSyncBytes = written
+		s.LastSyncError = ""
+	})
+}
+
+func (r *VolumeReplicationAgent) shipSnapshot(ctx context.Context, vr *carinav1.VolumeReplication, generation int64) (int64, error) {
+	if r.client == nil {
+		config, err := replication.TLSConfig(r.CertDir, false)
+		if err != nil {
+			return 0, err
+		}
+		r.client = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	}
+	lv, err := r.logicVolume(ctx, vr)
+	if err != nil {
+		return 0, err
+	}
+	pv, vg := lv.Name, lv.Spec.DeviceGroup
+	name := replication.SnapshotName(pv, generation)
+	if err := r.DM.VolumeManager.CreateSnapshot(name, pv, vg); err != nil {
+		return 0, err
+	}
+	current, err := r.DM.VolumeManager.VolumeInfo(volume.SNAP+name, vg)
+	if err != nil {
+		_ = r.DM.VolumeManager.DeleteSnapshot(name, vg)
+		return 0, err
+	}
+
+	baseGeneration, basePath := vr.Status.SyncGeneration, ""
+	if baseGeneration > 0 {
+		base, err := r.DM.VolumeManager.VolumeInfo(volume.SNAP+replication.SnapshotName(pv, baseGeneration), vg)
+		if err == nil {
+			basePath = base.LVPath
+		} else {
+			baseGeneration = 0
+		}
+	}
+	written, err := r.send(ctx, vr, current.LVPath, basePath, baseGeneration, generation, int64(current.LVSize))
+	if err == errReplicationConflict && baseGeneration != 0 {
+		log.Infof("peer of volume %s does not hold snapshot %d, sending all data", pv, baseGeneration)
+		written, err = r.send(ctx, vr, current.LVPath, "", 0, generation, int64(current.LVSize))
+	}
+	if err != nil {
+		_ = r.DM.VolumeManager.DeleteSnapshot(name, vg)
+		return 0, err
+	}
+	r.deleteSnapshots(pv, vg, generation)
+	return written, nil
+}
+
+func (r *VolumeReplicationAgent) send(ctx context.Context, vr *carinav1.VolumeReplication, current, base string, baseGeneration, generation, size int64) (int64, error) {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	w := replication.NewWriter(pw)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := replication.Delta(ctx, w, base, current, size)
+		if err == nil {
+			err = w.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	url := fmt.Sprintf("https://%s%s%s?base=%d&generation=%d&size=%d", vr.Spec.Peer.Address, replication.Path, vr.Spec.Peer.Volume, baseGeneration, generation, size)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, r.DM.Throttle.Reader(ctx, pr))
+	if err != nil {
+		return 0, err
+	}
+	resp, err := r.client.Do(req)
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	<-done
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return w.Bytes(), nil
+	case http.StatusConflict:
+		return 0, errReplicationConflict
+	}
+	return 0, fmt.Errorf("peer %s: %s %s", vr.Spec.Peer.Address, resp.Status, strings.TrimSpace(string(message)))
+}
+
+// deleteSnapshots 删除卷除keep以外的复制快照
+func (r *VolumeReplicationAgent) deleteSnapshots(pv, vg string, keep int64) {
+	snapshots, err := r.DM.VolumeManager.SnapshotList(pv, vg)
+	if err != nil {
+		log.Warnf("list snapshots of volume %s failed: %s", pv, err.Error())
+		return
+	}
+	for _, s := range snapshots {
+		if generation, ok := replication.SnapshotGeneration(s.LVName, pv); ok && generation != keep {
+			if err := r.DM.VolumeManager.DeleteSnapshot(strings.TrimPrefix(s.LVName, volume.SNAP), vg); err != nil {
+				log.Warnf("delete replication snapshot %s failed: %s", s.LVName, err.Error())
+			}
+		}
+	}
+}
+
+// rollback 把备用卷恢复到最近一次完整接收的快照，合并后快照消失，再重新创建
+func (r *VolumeReplicationAgent) rollback(vr *carinav1.VolumeReplication, lv *carinav1.LogicVolume) error {
+	if vr.Status.SyncGeneration == 0 {
+		return fmt.Errorf("volume %s holds an incomplete transfer and no complete one to roll back to", lv.Name)
+	}
+	name := replication.SnapshotName(lv.Name, vr.Status.SyncGeneration)
+	if err := r.DM.VolumeManager.RestoreSnapshot(volume.SNAP+name, lv.Spec.DeviceGroup); err != nil {
+		return err
+	}
+	log.Infof("rolled back volume %s to replication snapshot %d", lv.Name, vr.Status.SyncGeneration)
+	if err := r.DM.VolumeManager.CreateSnapshot(name, lv.Name, lv.Spec.DeviceGroup); err != nil {
+		log.Warnf("recreate replication snapshot of volume %s failed: %s", lv.Name, err.Error())
+	}
+	return nil
+}
+
+// promote 回滚未完成的传输，删除复制快照，卷成为主卷
+func (r *VolumeReplicationAgent) promote(ctx context.Context, vr *carinav1.VolumeReplication) error {
+	pv := vr.Status.Volume
+	if !r.locks.TryAcquire(pv) {
+		return fmt.Errorf("volume %s is busy receiving a snapshot", pv)
+	}
+	defer r.locks.Release(pv)
+	lv, err := r.logicVolume(ctx, vr)
+	if err != nil {
+		return err
+	}
+	if vr.Status.Dirty {
+		if err := r.rollback(vr, lv); err != nil {
+			_ = r.patchStatus(ctx, vr, func(s *carinav1.VolumeReplicationStatus) { s.LastSyncError = err.Error() })
+			return err
+		}
+	}
+	r.deleteSnapshots(pv, lv.Spec.DeviceGroup, 0)
+	return r.patchStatus(ctx, vr, func(s *carinav1.VolumeReplicationStatus) {
+		s.Role = carinav1.ReplicationPrimary
+		s.SyncGeneration = 0
+		s.Dirty = false
+		s.LastSyncAttempt = nil
+		s.LastSyncError = ""
+	})
+}
+
+// standby 返回本节点上接收该卷快照的VolumeReplication
+func (r *VolumeReplicationAgent) standby(ctx context.Context, pv string) (*carinav1.VolumeReplication, error) {
+	vrList := &carinav1.VolumeReplicationList{}
+	if err := r.List(ctx, vrList); err != nil {
+		return nil, err
+	}
+	for i := range vrList.Items {
+		vr := &vrList.Items[i]
+		if vr.Status.Volume == pv && vr.Status.Node == r.NodeName && vr.Status.Role == carinav1.ReplicationSecondary &&
+			vr.Spec.Role == carinav1.ReplicationSecondary && vr.DeletionTimestamp == nil {
+			return vr, nil
+		}
+	}
+	return nil, nil
+}
+
+// receive 接收对端发送的快照并写入备用卷
+func (r *VolumeReplicationAgent) receive(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := req.Context()
+	pv := strings.TrimPrefix(req.URL.Path, replication.Path)
+	query := req.URL.Query()
+	base, err1 := strconv.ParseInt(query.Get("base"), 10, 64)
+	generation, err2 := strconv.ParseInt(query.Get("generation"), 10, 64)
+	size, err3 := strconv.ParseInt(query.Get("size"), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || generation <= 0 || size <= 0 {
+		http.Error(w, "base, generation and size are required", http.StatusBadRequest)
+		return
+	}
+
+	vr, err := r.standby(ctx, pv)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if vr == nil {
+		http.Error(w, fmt.Sprintf("volume %s is not a standby on this node", pv), http.StatusNotFound)
+		return
+	}
+	if !r.locks.TryAcquire(pv) {
+		http.Error(w, fmt.Sprintf("volume %s is busy", pv), http.StatusServiceUnavailable)
+		return
+	}
+	defer r.locks.Release(pv)
+
+	lv, err := r.logicVolume(ctx, vr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	vg := lv.Spec.DeviceGroup
+	info, err := r.DM.VolumeManager.VolumeInfo(volume.LVVolume+pv, vg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if int64(info.LVSize) < size {
+		http.Error(w, fmt.Sprintf("standby volume %s has %d bytes, the primary %d, expand its pvc", pv, info.LVSize, size), http.StatusPreconditionFailed)
+		return
+	}
+	if mounted(info.LVKernelMajor, info.LVKernelMinor) {
+		http.Error(w, fmt.Sprintf("standby volume %s is mounted", pv), http.StatusLocked)
+		return
+	}
+	if base != 0 {
+		if base != vr.Status.SyncGeneration {
+			http.Error(w, fmt.Sprintf("standby holds snapshot %d", vr.Status.SyncGeneration), http.StatusConflict)
+			return
+		}
+		if vr.Status.Dirty {
+			if err := r.rollback(vr, lv); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	if err := r.patchStatus(ctx, vr, func(s *carinav1.VolumeReplicationStatus) { s.Dirty = true }); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	start := time.Now()
+	written, err := r.apply(req.Body, info.LVPath, size)
+	if err == nil {
+		err = r.DM.VolumeManager.CreateSnapshot(replication.SnapshotName(pv, generation), pv, vg)
+	}
+	if err != nil {
+		log.Warnf("receive snapshot %d of volume %s failed: %s", generation, pv, err.Error())
+		_ = r.patchStatus(context.Background(), vr, func(s *carinav1.VolumeReplicationStatus) { s.LastSyncError = err.Error() })
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	r.deleteSnapshots(pv, vg, generation)
+	done := metav1.Now()
+	log.Infof("received snapshot %d of volume %s, %d bytes in %s", generation, pv, written, done.Sub(start))
+	err = r.patchStatus(ctx, vr, func(s *carinav1.VolumeReplicationStatus) {
+		s.SyncGeneration = generation
+		s.Dirty = false
+		s.LastSyncTime = &done
+		s.LastSyncBytes = written
+		s.LastSyncError = ""
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "%d\n", written)
+}
+
+func (r *VolumeReplicationAgent) apply(body io.Reader, device string, size int64) (int64, error) {
+	f, err := os.OpenFile(device, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	written, err := replication.Apply(body, f, size)
+	if err != nil {
+		return written, err
+	}
+	return written, f.Sync()
+}
+
+// mounted 设备是否在本节点上被挂载
+func mounted(major, minor uint32) bool {
+	content, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	_, ok := autoresize.ParseMountInfo(string(content))[fmt.Sprintf("%d:%d", major, minor)]
+	return ok
+}
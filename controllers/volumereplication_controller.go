@@ -0,0 +1,219 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/replication"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VolumeReplicationReconciler 解析VolumeReplication的卷，负责主备切换
+// It resolves the pvc of a VolumeReplication to its LogicVolume and node, which ships or
+// receives the snapshots. A standby volume is marked with the replication-role annotation,
+// carina-node refuses to publish it. A demote waits until no pod uses the pvc, a promote is
+// completed by the node, which first rolls back an interrupted transfer.
+type VolumeReplicationReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumereplications,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumereplications/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=volumereplications/finalizers,verbs=update
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes,verbs=get;list;watch;update;patch
+
+func (r *VolumeReplicationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	vr := &carinav1.VolumeReplication{}
+	if err := r.Get(ctx, req.NamespacedName, vr); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if vr.DeletionTimestamp != nil {
+		return ctrl.Result{}, r.finalize(ctx, vr)
+	}
+	if !utils.ContainsString(vr.Finalizers, utils.VolumeReplicationFinalizer) {
+		vr.Finalizers = append(vr.Finalizers, utils.VolumeReplicationFinalizer)
+		if err := r.Update(ctx, vr); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	before := vr.Status.DeepCopy()
+	result, err := r.reconcile(ctx, vr)
+	if err != nil {
+		vr.Status.Phase = carinav1.VolumeReplicationFailed
+		vr.Status.Message = err.Error()
+	}
+	if !equality.Semantic.DeepEqual(before, &vr.Status) {
+		if perr := r.Status().Update(ctx, vr); perr != nil {
+			return ctrl.Result{}, perr
+		}
+	}
+	if err != nil {
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	return result, nil
+}
+
+func (r *VolumeReplicationReconciler) reconcile(ctx context.Context, vr *carinav1.VolumeReplication) (ctrl.Result, error) {
+	if err := replication.Validate(vr); err != nil {
+		return ctrl.Result{}, err
+	}
+	lv, err := r.volume(ctx, vr)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	vr.Status.Volume = lv.Name
+	vr.Status.Node = lv.Spec.NodeName
+	vr.Status.Message = ""
+
+	switch {
+	case vr.Spec.Role == carinav1.ReplicationPrimary && vr.Status.Role == "":
+		vr.Status.Role = carinav1.ReplicationPrimary
+	case vr.Spec.Role == carinav1.ReplicationSecondary && vr.Status.Role != carinav1.ReplicationSecondary:
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: vr.Namespace, Name: vr.Spec.PVC}, pvc); err != nil {
+			return ctrl.Result{}, err
+		}
+		inUse, err := claimInUse(ctx, r.Client, pvc)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if inUse {
+			vr.Status.Phase = replication.Phase(vr)
+			vr.Status.Message = "waiting for the pods using the pvc to stop"
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		// 先禁止使用卷，再开始接收快照
+		if err := r.setStandby(ctx, lv, true); err != nil {
+			return ctrl.Result{}, err
+		}
+		if vr.Status.Role == carinav1.ReplicationPrimary {
+			r.Recorder.Event(vr, corev1.EventTypeNormal, "Demoted", fmt.Sprintf("volume %s is a standby now, it receives the snapshots of the peer", lv.Name))
+		}
+		vr.Status.Role = carinav1.ReplicationSecondary
+		vr.Status.SyncGeneration = 0
+		vr.Status.Dirty = false
+		vr.Status.LastSyncError = ""
+	}
+
+	// 提升由节点完成，节点回滚未完成的传输后把status.role改为Primary
+	if vr.Status.Role == carinav1.ReplicationPrimary && vr.Spec.Role == carinav1.ReplicationPrimary && lv.Annotations[utils.VolumeReplicationRole] != "" {
+		if err := r.setStandby(ctx, lv, false); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Event(vr, corev1.EventTypeNormal, "Promoted", fmt.Sprintf("volume %s is the primary now and can be used", lv.Name))
+		log.Infof("volume replication %s/%s promoted volume %s", vr.Namespace, vr.Name, lv.Name)
+	}
+	vr.Status.Phase = replication.Phase(vr)
+	return ctrl.Result{}, nil
+}
+
+// volume 返回pvc的LogicVolume，只支持未加密的lvm卷
+func (r *VolumeReplicationReconciler) volume(ctx context.Context, vr *carinav1.VolumeReplication) (*carinav1.LogicVolume, error) {
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: vr.Namespace, Name: vr.Spec.PVC}, pvc); err != nil {
+		return nil, err
+	}
+	if pvc.Spec.VolumeName == "" {
+		return nil, fmt.Errorf("pvc %s is not bound", pvc.Name)
+	}
+	pv := &corev1.PersistentVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pvc.Spec.VolumeName}, pv); err != nil {
+		return nil, err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != utils.CSIPluginName {
+		return nil, fmt.Errorf("pvc %s is not a carina volume", pvc.Name)
+	}
+	if pv.Spec.CSI.VolumeAttributes[utils.VolumeCacheId] != "" {
+		return nil, fmt.Errorf("bcache volumes can not be replicated")
+	}
+	lv := &carinav1.LogicVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pv.Name, Namespace: utils.LogicVolumeNamespace}, lv); err != nil {
+		return nil, err
+	}
+	if lv.Annotations[utils.VolumeManagerType] != utils.LvmVolumeType {
+		return nil, fmt.Errorf("only lvm volumes can be replicated")
+	}
+	if lv.Annotations[utils.VolumeEncrypted] == "true" {
+		return nil, fmt.Errorf("encrypted volumes can not be replicated")
+	}
+	return lv, nil
+}
+
+func (r *VolumeReplicationReconciler) setStandby(ctx context.Context, lv *carinav1.LogicVolume, standby bool) error {
+	if (lv.Annotations[utils.VolumeReplicationRole] != "") == standby {
+		return nil
+	}
+	lv2 := lv.DeepCopy()
+	if standby {
+		if lv2.Annotations == nil {
+			lv2.Annotations = map[string]string{}
+		}
+		lv2.Annotations[utils.VolumeReplicationRole] = carinav1.ReplicationSecondary
+	} else {
+		delete(lv2.Annotations, utils.VolumeReplicationRole)
+	}
+	if err := r.Patch(ctx, lv2, client.MergeFrom(lv)); err != nil {
+		return err
+	}
+	lv.Annotations = lv2.Annotations
+	return nil
+}
+
+// finalize 删除VolumeReplication后备用卷恢复可用，已收到的数据保留
+func (r *VolumeReplicationReconciler) finalize(ctx context.Context, vr *carinav1.VolumeReplication) error {
+	if !utils.ContainsString(vr.Finalizers, utils.VolumeReplicationFinalizer) {
+		return nil
+	}
+	if vr.Status.Volume != "" {
+		lv := &carinav1.LogicVolume{}
+		err := r.Get(ctx, client.ObjectKey{Name: vr.Status.Volume, Namespace: utils.LogicVolumeNamespace}, lv)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			if err := r.setStandby(ctx, lv, false); err != nil {
+				return err
+			}
+		}
+	}
+	vr.Finalizers = utils.SliceRemoveString(vr.Finalizers, utils.VolumeReplicationFinalizer)
+	return r.Update(ctx, vr)
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *VolumeReplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("volumereplication").
+		For(&carinav1.VolumeReplication{}).
+		Complete(r)
+}
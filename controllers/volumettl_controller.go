@@ -0,0 +1,165 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// VolumeTTLReconciler 删除超过租期未被使用的pvc
+// A bound carina pvc annotated with carina.storage.io/ttl is deleted once no pod has used it
+// for the ttl. The time the last pod went away is kept in carina.storage.io/unused-since, a
+// pod using the pvc again removes it, so every use renews the lease. The volume itself is
+// reclaimed according to the reclaim policy of the pv.
+type VolumeTTLReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;update;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+
+func (r *VolumeTTLReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, req.NamespacedName, pvc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if !hasTTL(pvc) || pvc.Status.Phase != corev1.ClaimBound {
+		return ctrl.Result{}, nil
+	}
+	ttl, err := utils.ParseVolumeTTL(pvc.Annotations[utils.VolumeTTL])
+	if err != nil {
+		r.Recorder.Event(pvc, corev1.EventTypeWarning, "InvalidTTL", err.Error())
+		return ctrl.Result{}, nil
+	}
+	pv := &corev1.PersistentVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pvc.Spec.VolumeName}, pv); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != utils.CSIPluginName {
+		return ctrl.Result{}, nil
+	}
+
+	inUse, err := claimInUse(ctx, r.Client, pvc)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if inUse {
+		if _, ok := pvc.Annotations[utils.VolumeUnusedSince]; ok {
+			delete(pvc.Annotations, utils.VolumeUnusedSince)
+			return ctrl.Result{}, r.Update(ctx, pvc)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	now := time.Now()
+	since, err := time.Parse(time.RFC3339, pvc.Annotations[utils.VolumeUnusedSince])
+	if err != nil {
+		// 首次发现未被使用，或注解被改坏，从现在开始计时
+		pvc.Annotations[utils.VolumeUnusedSince] = now.UTC().Format(time.RFC3339)
+		if err := r.Update(ctx, pvc); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: ttl}, nil
+	}
+	if expires := since.Add(ttl); now.Before(expires) {
+		return ctrl.Result{RequeueAfter: expires.Sub(now)}, nil
+	}
+
+	// pvc-protection保证删除时若恰好有pod开始使用，pvc会等pod结束后才被删除
+	r.Recorder.Event(pvc, corev1.EventTypeNormal, "TTLExpired", fmt.Sprintf("not used by any pod since %s, ttl %s, deleting pvc", since.Format(time.RFC3339), ttl))
+	if err := r.Delete(ctx, pvc, client.Preconditions{UID: &pvc.UID, ResourceVersion: &pvc.ResourceVersion}); err != nil {
+		if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+			return ctrl.Result{Requeue: apierrors.IsConflict(err)}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	log.Infof("pvc %s/%s unused since %s, ttl %s expired, deleted", pvc.Namespace, pvc.Name, since.Format(time.RFC3339), ttl)
+	return ctrl.Result{}, nil
+}
+
+// claimInUse pvc是否被未结束的pod使用
+func claimInUse(ctx context.Context, c client.Reader, pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	podList := &corev1.PodList{}
+	if err := c.List(ctx, podList, client.InNamespace(pvc.Namespace)); err != nil {
+		return false, err
+	}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if podUsesClaim(pod, pvc.Name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func hasTTL(pvc *corev1.PersistentVolumeClaim) bool {
+	return pvc.Annotations[utils.VolumeTTL] != "" && pvc.DeletionTimestamp == nil
+}
+
+// claimsOfPod pod变化时重新检查其使用的pvc
+func (r *VolumeTTLReconciler) claimsOfPod(o client.Object) []reconcile.Request {
+	pod, ok := o.(*corev1.Pod)
+	if !ok {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: v.PersistentVolumeClaim.ClaimName}})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up Reconciler with Manager.
+func (r *VolumeTTLReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	pred := predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return hasTTL(e.Object.(*corev1.PersistentVolumeClaim)) },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return hasTTL(e.ObjectNew.(*corev1.PersistentVolumeClaim)) },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("volumettl").
+		For(&corev1.PersistentVolumeClaim{}, builder.WithPredicates(pred)).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(r.claimsOfPod)).
+		Complete(r)
+}
@@ -0,0 +1,108 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/autoresize"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/csidriver/filesystem"
+	"github.com/carina-io/carina/pkg/volumeactivity"
+	"github.com/carina-io/carina/utils/log"
+	"golang.org/x/sys/unix"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// VolumeUsageRecorder 记录本节点已挂载卷的文件系统使用率
+// With autoresize enabled it looks up the mount points of the LogicVolumes of the node in
+// /proc/self/mountinfo every SampleInterval and writes the used percent of their filesystems
+// to status.usage when it changed. Unmounted volumes keep their last usage.
+type VolumeUsageRecorder struct {
+	client.Client
+	NodeName string
+}
+
+var _ manager.LeaderElectionRunnable = &VolumeUsageRecorder{}
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=logicvolumes/status,verbs=get;update;patch
+
+// Start implements controller-runtime's manager.Runnable.
+func (r *VolumeUsageRecorder) Start(ctx context.Context) error {
+	ticker := time.NewTicker(autoresize.SampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if !configuration.Autoresize() {
+			continue
+		}
+		if err := r.sample(ctx); err != nil {
+			log.Warnf("sample volume usage failed: %s", err.Error())
+		}
+	}
+}
+
+func (r *VolumeUsageRecorder) sample(ctx context.Context) error {
+	content, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	mounts := autoresize.ParseMountInfo(string(content))
+	lvList := &carinav1.LogicVolumeList{}
+	if err := r.List(ctx, lvList, client.MatchingFields{"nodeName": r.NodeName}); err != nil {
+		return err
+	}
+	for i := range lvList.Items {
+		lv := &lvList.Items[i]
+		if lv.DeletionTimestamp != nil {
+			continue
+		}
+		// 块设备、加密卷和bcache卷挂载的不是逻辑卷本身，找不到挂载点
+		target, ok := mounts[volumeactivity.DeviceKey(lv)]
+		if !ok {
+			continue
+		}
+		var sfs unix.Statfs_t
+		if err := filesystem.Statfs(target, &sfs); err != nil {
+			log.Warnf("statfs %s of logic volume %s failed: %s", target, lv.Name, err.Error())
+			continue
+		}
+		usage := autoresize.UsagePercent(sfs.Blocks, sfs.Bfree, sfs.Bavail)
+		if lv.Status.Usage != nil && *lv.Status.Usage == usage {
+			continue
+		}
+		lv2 := lv.DeepCopy()
+		lv2.Status.Usage = &usage
+		if err := r.Status().Patch(ctx, lv2, client.MergeFrom(lv)); err != nil {
+			log.Warnf("record usage of logic volume %s failed: %s", lv.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (r *VolumeUsageRecorder) NeedLeaderElection() bool {
+	return false
+}
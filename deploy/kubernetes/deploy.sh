@@ -6,12 +6,21 @@ function install() {
 
   kubectl apply -f crd-logicvolume.yaml
   kubectl apply -f crd-nodestoreresource.yaml
+  kubectl apply -f crd-storagepolicy.yaml
+  kubectl apply -f crd-carinadefaultclass.yaml
+  kubectl apply -f crd-carinaquota.yaml
+  kubectl apply -f crd-rebalance.yaml
+  kubectl apply -f crd-snapshotpolicy.yaml
+  kubectl apply -f crd-volumeoperation.yaml
+  kubectl apply -f crd-volumefreeze.yaml
+  kubectl apply -f crd-volumereplication.yaml
   kubectl apply -f csi-config-map.yaml
   kubectl apply -f csi-controller-psp.yaml
   kubectl apply -f csi-controller-rbac.yaml
   kubectl apply -f csi-carina-controller.yaml
   kubectl apply -f csi-node-psp.yaml
   kubectl apply -f csi-node-rbac.yaml
+  kubectl apply -f csi-driver.yaml
   kubectl apply -f csi-carina-node.yaml
   kubectl apply -f carina-scheduler.yaml
   sleep 3s
@@ -30,6 +39,7 @@ function uninstall() {
   kubectl delete -f csi-carina-controller.yaml
   kubectl delete -f csi-node-psp.yaml
   kubectl delete -f csi-node-rbac.yaml
+  kubectl delete -f csi-driver.yaml
   kubectl delete -f csi-carina-node.yaml
   kubectl delete -f carina-scheduler.yaml
  
@@ -45,6 +55,14 @@ function uninstall() {
     kubectl delete -f crd-logicvolume.yaml
   fi
   kubectl delete -f crd-nodestoreresource.yaml
+  kubectl delete -f crd-storagepolicy.yaml
+  kubectl delete -f crd-carinadefaultclass.yaml
+  kubectl delete -f crd-carinaquota.yaml
+  kubectl delete -f crd-rebalance.yaml
+  kubectl delete -f crd-snapshotpolicy.yaml
+  kubectl delete -f crd-volumeoperation.yaml
+  kubectl delete -f crd-volumefreeze.yaml
+  kubectl delete -f crd-volumereplication.yaml
 
 }
 
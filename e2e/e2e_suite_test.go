@@ -68,12 +68,14 @@ var _ = Describe("Carina", func() {
 	Context("raw block pod", rawBlockPod)
 	Context("create statefulSet pod", statefulSetCreate)
 	Context("create topostatefulSet pod", topoStatefulSetCreate)
+	Context("mount and mkfs options", mountOptions)
 
 	By("cleanup all resources")
 	Context("delete all deployment", deleteAllDeployment)
 	Context("delete block pod", deleteBlockPod)
 	Context("delete statefulSet pod", deleteStatefulSet)
 	Context("delete topostatefulSet pod", deletetopoStatefulSet)
+	Context("delete mount options pod", deleteMountOptions)
 	Context("all pvc delete", testDeletePvc)
 	Context("delete normal pod", deleteNormalDeployment)
 })
@@ -0,0 +1,198 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/carina-io/carina/utils/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var scMountOptions = `
+apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: csi-carina-sc-options
+provisioner: carina.storage.io
+parameters:
+  csi.storage.k8s.io/fstype: ext4
+  carina.storage.io/mkfs-options: "-O ^has_journal"
+reclaimPolicy: Delete
+allowVolumeExpansion: true
+volumeBindingMode: Immediate
+mountOptions:
+  - noatime
+`
+
+var scInvalidMkfsOptions = `
+apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: csi-carina-sc-invalid-options
+provisioner: carina.storage.io
+parameters:
+  csi.storage.k8s.io/fstype: xfs
+  carina.storage.io/mkfs-options: "-N"
+reclaimPolicy: Delete
+volumeBindingMode: Immediate
+`
+
+var pvcMountOptions = `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: csi-carina-pvc-options
+  namespace: carina
+spec:
+  accessModes:
+    - ReadWriteOnce
+  resources:
+    requests:
+      storage: 3Gi
+  storageClassName: csi-carina-sc-options
+  volumeMode: Filesystem
+`
+
+var pvcInvalidMkfsOptions = `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: csi-carina-pvc-invalid-options
+  namespace: carina
+spec:
+  accessModes:
+    - ReadWriteOnce
+  resources:
+    requests:
+      storage: 3Gi
+  storageClassName: csi-carina-sc-invalid-options
+  volumeMode: Filesystem
+`
+
+var deploymentMountOptions = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: carina-deployment-options
+  namespace: carina
+  labels:
+    app: web-server-options
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: web-server-options
+  template:
+    metadata:
+      labels:
+        app: web-server-options
+    spec:
+      containers:
+        - name: web-server-options
+          image: docker.io/library/nginx:latest
+          volumeMounts:
+            - name: mypvc
+              mountPath: /var/lib/www/html
+      volumes:
+        - name: mypvc
+          persistentVolumeClaim:
+            claimName: csi-carina-pvc-options
+            readOnly: false
+`
+
+func mountOptions() {
+	label := "app=web-server-options"
+	It("mount with storageclass mount and mkfs options", func() {
+		for _, manifest := range []string{scMountOptions, pvcMountOptions, deploymentMountOptions} {
+			stdout, stderr, err := kubectlWithInput([]byte(manifest), "apply", "-f", "-")
+			Expect(err).ShouldNot(HaveOccurred(), "stdout=%s, stderr=%s", stdout, stderr)
+		}
+		Eventually(func() error {
+			stdout, stderr, err := kubectl("get", "pods", "-l", label, "-o", "json", "-n", NameSpace)
+			if err != nil {
+				return fmt.Errorf("get pod label %s failed. stdout: %s, stderr: %s, err: %v", label, stdout, stderr, err)
+			}
+			var pods corev1.PodList
+			if err := json.Unmarshal(stdout, &pods); err != nil {
+				return fmt.Errorf("unmarshal error: stdout=%s", stdout)
+			}
+			if len(pods.Items) == 0 {
+				return fmt.Errorf("not found pod label %s", label)
+			}
+			pod := pods.Items[0]
+			if pod.Status.Phase != corev1.PodRunning {
+				return fmt.Errorf("pod %s not running", pod.Name)
+			}
+
+			By("check mount options")
+			stdout, stderr, err = kubectl("exec", "-n", NameSpace, pod.Name, "--", "grep", "/var/lib/www/html", "/proc/mounts")
+			if err != nil {
+				return fmt.Errorf("failed to read mounts. stdout: %s, stderr: %s, err: %v", stdout, stderr, err)
+			}
+			log.Info(string(stdout))
+			Expect(string(stdout)).To(ContainSubstring("ext4"))
+			Expect(string(stdout)).To(ContainSubstring("noatime"))
+			return nil
+		}, 5*time.Minute, 10*time.Second).Should(Succeed())
+	})
+
+	It("reject invalid mkfs options", func() {
+		for _, manifest := range []string{scInvalidMkfsOptions, pvcInvalidMkfsOptions} {
+			stdout, stderr, err := kubectlWithInput([]byte(manifest), "apply", "-f", "-")
+			Expect(err).ShouldNot(HaveOccurred(), "stdout=%s, stderr=%s", stdout, stderr)
+		}
+		Eventually(func() error {
+			stdout, stderr, err := kubectl("get", "events", "-n", NameSpace, "--field-selector", "involvedObject.name=csi-carina-pvc-invalid-options", "-o", "json")
+			if err != nil {
+				return fmt.Errorf("get events failed. stdout: %s, stderr: %s, err: %v", stdout, stderr, err)
+			}
+			var events corev1.EventList
+			if err := json.Unmarshal(stdout, &events); err != nil {
+				return fmt.Errorf("unmarshal error: stdout=%s", stdout)
+			}
+			for _, e := range events.Items {
+				if e.Reason == "ProvisioningFailed" && strings.Contains(e.Message, "mkfs-options") {
+					return nil
+				}
+			}
+			return fmt.Errorf("pvc csi-carina-pvc-invalid-options has no ProvisioningFailed event")
+		}).Should(Succeed())
+
+		stdout, stderr, err := kubectl("get", "pvc", "csi-carina-pvc-invalid-options", "-n", NameSpace, "-o", "jsonpath={.status.phase}")
+		Expect(err).ShouldNot(HaveOccurred(), "stdout=%s, stderr=%s", stdout, stderr)
+		Expect(string(stdout)).Should(Equal(string(corev1.ClaimPending)))
+	})
+}
+
+func deleteMountOptions() {
+	It("delete mount options pod", func() {
+		for _, manifest := range []string{deploymentMountOptions, pvcMountOptions, pvcInvalidMkfsOptions, scMountOptions, scInvalidMkfsOptions} {
+			stdout, stderr, err := kubectlWithInput([]byte(manifest), "delete", "-f", "-")
+			Expect(err).ShouldNot(HaveOccurred(), "stdout=%s, stderr=%s", stdout, stderr)
+		}
+		Eventually(func() error {
+			_, _, err := kubectl("get", "pvc", "csi-carina-pvc-options", "-n", NameSpace)
+			return err
+		}).Should(HaveOccurred())
+	})
+}
@@ -0,0 +1,103 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/carina-io/carina/utils/log"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LogicVolumeCRD is the crd the conversion webhook is injected into
+const LogicVolumeCRD = "logicvolumes.carina.storage.io"
+
+// ConversionInjector points the conversion of the LogicVolume crd at the /convert webhook of carina-controller,
+// with the ca of the webhook certificate. The crd is shipped without it because its caBundle is only known at
+// install time, and kubectl apply or helm upgrade of the crd reset it, so it is checked again every Interval.
+type ConversionInjector struct {
+	Client client.Client
+	// APIReader reads the crd without a cache and the rbac to watch crds
+	APIReader client.Reader
+	// CAFile holds the ca of the webhook certificate, ca of the secret created by kube-webhook-certgen
+	CAFile    string
+	Namespace string
+	Service   string
+	Interval  time.Duration
+}
+
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;patch
+
+// Start implements controller-runtime's manager.Runnable.
+func (c *ConversionInjector) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Inject(ctx); err != nil {
+			log.Warnf("inject conversion webhook into crd %s failed %s", LogicVolumeCRD, err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Inject sets the conversion of the crd unless it is already up to date
+func (c *ConversionInjector) Inject(ctx context.Context) error {
+	ca, err := ioutil.ReadFile(c.CAFile)
+	if os.IsNotExist(err) {
+		log.Debugf("%s not found, v1beta1 LogicVolumes are not converted", c.CAFile)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	crd := &apiextv1.CustomResourceDefinition{}
+	if err := c.APIReader.Get(ctx, client.ObjectKey{Name: LogicVolumeCRD}, crd); err != nil {
+		return err
+	}
+	path := "/convert"
+	port := int32(443)
+	conversion := &apiextv1.CustomResourceConversion{
+		Strategy: apiextv1.WebhookConverter,
+		Webhook: &apiextv1.WebhookConversion{
+			ClientConfig: &apiextv1.WebhookClientConfig{
+				Service:  &apiextv1.ServiceReference{Namespace: c.Namespace, Name: c.Service, Path: &path, Port: &port},
+				CABundle: ca,
+			},
+			ConversionReviewVersions: []string{"v1"},
+		},
+	}
+	if equality.Semantic.DeepEqual(crd.Spec.Conversion, conversion) {
+		return nil
+	}
+	patch := client.MergeFrom(crd.DeepCopy())
+	crd.Spec.Conversion = conversion
+	if err := c.Client.Patch(ctx, crd, patch); err != nil {
+		return err
+	}
+	log.Infof("crd %s converts LogicVolumes with the webhook of service %s/%s", LogicVolumeCRD, c.Namespace, c.Service)
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestConversionInjector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, apiextv1.AddToScheme(scheme))
+	crd := &apiextv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: LogicVolumeCRD},
+		Spec:       apiextv1.CustomResourceDefinitionSpec{Conversion: &apiextv1.CustomResourceConversion{Strategy: apiextv1.NoneConverter}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(crd).Build()
+	caFile := filepath.Join(t.TempDir(), "ca")
+	injector := &ConversionInjector{Client: c, APIReader: c, CAFile: caFile, Namespace: "kube-system", Service: "carina-controller"}
+	ctx := context.Background()
+	get := func() *apiextv1.CustomResourceDefinition {
+		crd := &apiextv1.CustomResourceDefinition{}
+		assert.NoError(t, c.Get(ctx, client.ObjectKey{Name: LogicVolumeCRD}, crd))
+		return crd
+	}
+
+	// 没有webhook证书时不修改crd
+	assert.NoError(t, injector.Inject(ctx))
+	assert.Equal(t, apiextv1.NoneConverter, get().Spec.Conversion.Strategy)
+
+	assert.NoError(t, ioutil.WriteFile(caFile, []byte("ca-1"), 0600))
+	assert.NoError(t, injector.Inject(ctx))
+	injected := get()
+	conversion := injected.Spec.Conversion
+	assert.Equal(t, apiextv1.WebhookConverter, conversion.Strategy)
+	assert.Equal(t, []byte("ca-1"), conversion.Webhook.ClientConfig.CABundle)
+	assert.Equal(t, "kube-system", conversion.Webhook.ClientConfig.Service.Namespace)
+	assert.Equal(t, "carina-controller", conversion.Webhook.ClientConfig.Service.Name)
+	assert.Equal(t, "/convert", *conversion.Webhook.ClientConfig.Service.Path)
+	assert.Equal(t, []string{"v1"}, conversion.Webhook.ConversionReviewVersions)
+
+	// 已经是最新时不再修改
+	assert.NoError(t, injector.Inject(ctx))
+	assert.Equal(t, injected.ResourceVersion, get().ResourceVersion)
+
+	// 证书轮换或crd被重新apply后重新注入
+	assert.NoError(t, ioutil.WriteFile(caFile, []byte("ca-2"), 0600))
+	assert.NoError(t, injector.Inject(ctx))
+	assert.Equal(t, []byte("ca-2"), get().Spec.Conversion.Webhook.ClientConfig.CABundle)
+}
@@ -0,0 +1,132 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:webhookVersions=v1,path=/logicvolume/mutate,mutating=true,failurePolicy=ignore,matchPolicy=equivalent,groups=carina.storage.io,resources=logicvolumes,verbs=create;update,versions=v1,sideEffects=none,name=logicvolume-mutate-hook.carina.storage.io
+// +kubebuilder:webhook:webhookVersions=v1,path=/logicvolume/validate,mutating=false,failurePolicy=ignore,matchPolicy=equivalent,groups=carina.storage.io,resources=logicvolumes,verbs=create;update,versions=v1,sideEffects=none,name=logicvolume-hook.carina.storage.io
+
+// lvmExtent lvm按extent分配空间，默认4Mi
+const lvmExtent = 4 << 20
+
+type logicVolumeDefaulter struct {
+	decoder *admission.Decoder
+}
+
+// LogicVolumeDefaulter creates a mutating webhook filling the defaults of LogicVolumes.
+func LogicVolumeDefaulter(dec *admission.Decoder) http.Handler {
+	return &webhook.Admission{Handler: logicVolumeDefaulter{dec}}
+}
+
+// Handle implements admission.Handler interface.
+func (d logicVolumeDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	lv := &carinav1.LogicVolume{}
+	if err := d.decoder.Decode(req, lv); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if !defaultLogicVolume(lv) {
+		return admission.Allowed("")
+	}
+	marshaled, err := json.Marshal(lv)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// defaultLogicVolume 将容量向上取整到lvm extent，与节点实际创建的卷一致
+func defaultLogicVolume(lv *carinav1.LogicVolume) bool {
+	size := lv.Spec.Size.Value()
+	if size <= 0 || size%lvmExtent == 0 {
+		return false
+	}
+	lv.Spec.Size = *resource.NewQuantity((size/lvmExtent+1)*lvmExtent, resource.BinarySI)
+	return true
+}
+
+type logicVolumeValidator struct {
+	decoder *admission.Decoder
+}
+
+// LogicVolumeValidator creates a validating webhook for LogicVolumes.
+func LogicVolumeValidator(dec *admission.Decoder) http.Handler {
+	return &webhook.Admission{Handler: logicVolumeValidator{dec}}
+}
+
+// Handle implements admission.Handler interface.
+func (v logicVolumeValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	lv := &carinav1.LogicVolume{}
+	if err := v.decoder.Decode(req, lv); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var old *carinav1.LogicVolume
+	if req.Operation == admissionv1.Update {
+		old = &carinav1.LogicVolume{}
+		if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		// 删除中的卷只会移除finalizer
+		if old.DeletionTimestamp != nil {
+			return admission.Allowed("")
+		}
+	}
+	if problems := validateLogicVolume(old, lv); len(problems) > 0 {
+		return admission.Denied(fmt.Sprintf("logicvolume %s is invalid: %s", lv.Name, strings.Join(problems, "; ")))
+	}
+	return admission.Allowed("")
+}
+
+// validateLogicVolume 检查新建或更新的LogicVolume，old为空时为新建
+// The node and device group of a volume never change, its size only grows.
+func validateLogicVolume(old, lv *carinav1.LogicVolume) []string {
+	problems := []string{}
+	if lv.Spec.NodeName == "" {
+		problems = append(problems, "nodeName is required")
+	}
+	if lv.Spec.DeviceGroup == "" {
+		problems = append(problems, "deviceGroup is required")
+	}
+	if lv.Spec.Size.Sign() <= 0 {
+		problems = append(problems, fmt.Sprintf("size must be positive, got %s", lv.Spec.Size.String()))
+	}
+	if old == nil {
+		return problems
+	}
+	if old.Spec.NodeName != lv.Spec.NodeName {
+		problems = append(problems, fmt.Sprintf("nodeName is immutable, %s was changed to %s", old.Spec.NodeName, lv.Spec.NodeName))
+	}
+	if old.Spec.DeviceGroup != lv.Spec.DeviceGroup {
+		problems = append(problems, fmt.Sprintf("deviceGroup is immutable, %s was changed to %s", old.Spec.DeviceGroup, lv.Spec.DeviceGroup))
+	}
+	if lv.Spec.Size.Cmp(old.Spec.Size) < 0 {
+		problems = append(problems, fmt.Sprintf("size cannot shrink from %s to %s", old.Spec.Size.String(), lv.Spec.Size.String()))
+	}
+	return problems
+}
@@ -0,0 +1,254 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:webhookVersions=v1,path=/pvc/mutate,mutating=true,failurePolicy=ignore,matchPolicy=equivalent,groups="",resources=persistentvolumeclaims,verbs=create,versions=v1,sideEffects=none,name=pvc-mutate-hook.carina.storage.io
+// +kubebuilder:rbac:groups=carina.storage.io,resources=storagepolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=carina.storage.io,resources=carinadefaultclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// pvcMutator sets the default carina storageclass from CarinaDefaultClass and injects disk group and fstype
+// defaults from StoragePolicy into carina PVCs.
+type pvcMutator struct {
+	client  client.Client
+	decoder *admission.Decoder
+}
+
+// PVCMutator creates a mutating webhook for PVCs.
+func PVCMutator(c client.Client, dec *admission.Decoder) http.Handler {
+	return &webhook.Admission{Handler: pvcMutator{c, dec}}
+}
+
+// Handle implements admission.Handler interface.
+func (m pvcMutator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := m.decoder.Decode(req, pvc); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if pvc.Namespace == "" {
+		pvc.Namespace = req.Namespace
+	}
+
+	// 默认值注入是尽力而为的，任何错误都不阻塞pvc创建
+	defaulted := m.setDefaultClass(ctx, pvc)
+	injected := m.injectStoragePolicy(ctx, pvc)
+	if !defaulted && !injected {
+		return admission.Allowed("nothing to inject")
+	}
+
+	marshaledPVC, err := json.Marshal(pvc)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPVC)
+}
+
+// setDefaultClass sets storageClassName and disk group of a pvc without storageClassName from the
+// CarinaDefaultClass matching its namespace, returns whether the pvc changed.
+// An explicit empty storageClassName requests a pv without class and is kept.
+func (m pvcMutator) setDefaultClass(ctx context.Context, pvc *corev1.PersistentVolumeClaim) bool {
+	if pvc.Spec.StorageClassName != nil {
+		return false
+	}
+	if _, ok := pvc.Annotations[corev1.BetaStorageClassAnnotation]; ok {
+		return false
+	}
+	var dcList carinav1.CarinaDefaultClassList
+	if err := m.client.List(ctx, &dcList); err != nil {
+		log.Warnf("list CarinaDefaultClass failed: %s", err.Error())
+		return false
+	}
+	if len(dcList.Items) == 0 {
+		return false
+	}
+	nsLabels, err := m.namespaceLabels(ctx, pvc.Namespace)
+	if err != nil {
+		log.Warnf("get namespace %s failed: %s", pvc.Namespace, err.Error())
+		return false
+	}
+	dc := selectDefaultClass(dcList.Items, nsLabels)
+	if dc == nil {
+		return false
+	}
+
+	var sc storagev1.StorageClass
+	if err := m.client.Get(ctx, types.NamespacedName{Name: dc.Spec.StorageClassName}, &sc); err != nil {
+		log.Warnf("get storageclass %s of default class %s failed: %s", dc.Spec.StorageClassName, dc.Name, err.Error())
+		return false
+	}
+	if sc.Provisioner != utils.CSIPluginName {
+		log.Warnf("storageclass %s of default class %s is not a carina storageclass", sc.Name, dc.Name)
+		return false
+	}
+	applyDefaultClass(pvc, &sc, dc)
+	log.Infof("pvc %s/%s defaulted to storageclass %s by default class %s", pvc.Namespace, pvc.Name, sc.Name, dc.Name)
+	return true
+}
+
+// injectStoragePolicy injects the defaults of the StoragePolicy matching the namespace into a pvc of a carina
+// storageclass, returns whether the pvc changed.
+func (m pvcMutator) injectStoragePolicy(ctx context.Context, pvc *corev1.PersistentVolumeClaim) bool {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return false
+	}
+
+	var sc storagev1.StorageClass
+	if err := m.client.Get(ctx, types.NamespacedName{Name: *pvc.Spec.StorageClassName}, &sc); err != nil {
+		log.Warnf("get storageclass %s failed: %s", *pvc.Spec.StorageClassName, err.Error())
+		return false
+	}
+	if sc.Provisioner != utils.CSIPluginName {
+		return false
+	}
+
+	var spList carinav1.StoragePolicyList
+	if err := m.client.List(ctx, &spList); err != nil {
+		log.Warnf("list StoragePolicy failed: %s", err.Error())
+		return false
+	}
+	if len(spList.Items) == 0 {
+		return false
+	}
+
+	nsLabels, err := m.namespaceLabels(ctx, pvc.Namespace)
+	if err != nil {
+		log.Warnf("get namespace %s failed: %s", pvc.Namespace, err.Error())
+		return false
+	}
+
+	policy := selectStoragePolicy(spList.Items, nsLabels)
+	if policy == nil {
+		return false
+	}
+	if !applyStoragePolicy(pvc, &sc, policy) {
+		return false
+	}
+	log.Infof("pvc %s/%s defaults injected from storage policy %s", pvc.Namespace, pvc.Name, policy.Name)
+	return true
+}
+
+func (m pvcMutator) namespaceLabels(ctx context.Context, name string) (map[string]string, error) {
+	var ns corev1.Namespace
+	if err := m.client.Get(ctx, types.NamespacedName{Name: name}, &ns); err != nil {
+		return nil, err
+	}
+	return ns.Labels, nil
+}
+
+// selectDefaultClass 返回匹配命名空间标签且优先级最高的默认存储类，优先级相同时按名称排序取第一个
+func selectDefaultClass(classes []carinav1.CarinaDefaultClass, nsLabels map[string]string) *carinav1.CarinaDefaultClass {
+	var selected *carinav1.CarinaDefaultClass
+	for i := range classes {
+		c := &classes[i]
+		if c.Spec.StorageClassName == "" {
+			continue
+		}
+		if c.Spec.NamespaceSelector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(c.Spec.NamespaceSelector)
+			if err != nil {
+				log.Warnf("invalid namespace selector of default class %s: %s", c.Name, err.Error())
+				continue
+			}
+			if !selector.Matches(labels.Set(nsLabels)) {
+				continue
+			}
+		}
+		if selected == nil || c.Spec.Priority > selected.Spec.Priority ||
+			(c.Spec.Priority == selected.Spec.Priority && c.Name < selected.Name) {
+			selected = c
+		}
+	}
+	return selected
+}
+
+// applyDefaultClass sets the storageclass and, unless the pvc or a bcache storageclass chooses it, the disk group.
+func applyDefaultClass(pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass, dc *carinav1.CarinaDefaultClass) {
+	name := sc.Name
+	pvc.Spec.StorageClassName = &name
+	if dc.Spec.DeviceGroup == "" || sc.Parameters[utils.VolumeBackendDiskType] != "" {
+		return
+	}
+	if pvc.Annotations == nil {
+		pvc.Annotations = map[string]string{}
+	}
+	if _, ok := pvc.Annotations[utils.DeviceDiskKey]; !ok {
+		pvc.Annotations[utils.DeviceDiskKey] = dc.Spec.DeviceGroup
+	}
+}
+
+// selectStoragePolicy 返回匹配命名空间标签且优先级最高的策略，优先级相同时按名称排序取第一个
+func selectStoragePolicy(policies []carinav1.StoragePolicy, nsLabels map[string]string) *carinav1.StoragePolicy {
+	var selected *carinav1.StoragePolicy
+	for i := range policies {
+		p := &policies[i]
+		if p.Spec.NamespaceSelector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(p.Spec.NamespaceSelector)
+			if err != nil {
+				log.Warnf("invalid namespace selector of storage policy %s: %s", p.Name, err.Error())
+				continue
+			}
+			if !selector.Matches(labels.Set(nsLabels)) {
+				continue
+			}
+		}
+		if selected == nil || p.Spec.Priority > selected.Spec.Priority ||
+			(p.Spec.Priority == selected.Spec.Priority && p.Name < selected.Name) {
+			selected = p
+		}
+	}
+	return selected
+}
+
+// applyStoragePolicy sets the annotations the pvc does not carry yet, returns whether the pvc changed.
+// bcache storageclasses select their disk groups themselves, so only the fstype is injected there.
+func applyStoragePolicy(pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass, policy *carinav1.StoragePolicy) bool {
+	if pvc.Annotations == nil {
+		pvc.Annotations = map[string]string{}
+	}
+	changed := false
+	if policy.Spec.DeviceGroup != "" && sc.Parameters[utils.VolumeBackendDiskType] == "" {
+		if _, ok := pvc.Annotations[utils.DeviceDiskKey]; !ok {
+			pvc.Annotations[utils.DeviceDiskKey] = policy.Spec.DeviceGroup
+			changed = true
+		}
+	}
+	if policy.Spec.FsType != "" && (pvc.Spec.VolumeMode == nil || *pvc.Spec.VolumeMode != corev1.PersistentVolumeBlock) {
+		if _, ok := pvc.Annotations[utils.VolumeFsType]; !ok {
+			pvc.Annotations[utils.VolumeFsType] = policy.Spec.FsType
+			changed = true
+		}
+	}
+	return changed
+}
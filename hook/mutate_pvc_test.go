@@ -0,0 +1,153 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"testing"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSelectStoragePolicy(t *testing.T) {
+	policies := []carinav1.StoragePolicy{
+		{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: carinav1.StoragePolicySpec{DeviceGroup: "carina-vg-hdd"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "prod"}, Spec: carinav1.StoragePolicySpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			DeviceGroup:       "carina-vg-ssd",
+			Priority:          10,
+		}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "batch"}, Spec: carinav1.StoragePolicySpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "workload", Operator: metav1.LabelSelectorOpIn, Values: []string{"batch"}},
+			}},
+			DeviceGroup: "carina-vg-hdd",
+			FsType:      "xfs",
+			Priority:    10,
+		}},
+	}
+
+	table := []struct {
+		labels map[string]string
+		result string
+	}{
+		{labels: map[string]string{"env": "prod"}, result: "prod"},
+		{labels: map[string]string{"workload": "batch"}, result: "batch"},
+		// same priority, the name decides
+		{labels: map[string]string{"env": "prod", "workload": "batch"}, result: "batch"},
+		{labels: map[string]string{"env": "dev"}, result: "default"},
+		{labels: nil, result: "default"},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		p := selectStoragePolicy(policies, e.labels)
+		if a.NotNil(p, e.labels) {
+			a.Equal(e.result, p.Name, e.labels)
+		}
+	}
+	a.Nil(selectStoragePolicy(policies[1:], map[string]string{"env": "dev"}))
+}
+
+func TestApplyStoragePolicy(t *testing.T) {
+	policy := &carinav1.StoragePolicy{Spec: carinav1.StoragePolicySpec{DeviceGroup: "carina-vg-ssd", FsType: "xfs"}}
+	lvmSC := &storagev1.StorageClass{Parameters: map[string]string{"csi.storage.k8s.io/fstype": "ext4"}}
+	bcacheSC := &storagev1.StorageClass{Parameters: map[string]string{"carina.storage.io/backend-disk-group-name": "hdd"}}
+	block := corev1.PersistentVolumeBlock
+
+	a := assert.New(t)
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	a.True(applyStoragePolicy(pvc, lvmSC, policy))
+	a.Equal("carina-vg-ssd", pvc.Annotations["carina.storage.io/disk-group-name"])
+	a.Equal("xfs", pvc.Annotations["carina.storage.io/fstype"])
+
+	// explicit annotations are kept
+	pvc = &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		"carina.storage.io/disk-group-name": "carina-vg-hdd",
+		"carina.storage.io/fstype":          "ext4",
+	}}}
+	a.False(applyStoragePolicy(pvc, lvmSC, policy))
+	a.Equal("carina-vg-hdd", pvc.Annotations["carina.storage.io/disk-group-name"])
+
+	pvc = &corev1.PersistentVolumeClaim{}
+	a.True(applyStoragePolicy(pvc, bcacheSC, policy))
+	a.NotContains(pvc.Annotations, "carina.storage.io/disk-group-name")
+
+	pvc = &corev1.PersistentVolumeClaim{Spec: corev1.PersistentVolumeClaimSpec{VolumeMode: &block}}
+	a.True(applyStoragePolicy(pvc, lvmSC, policy))
+	a.NotContains(pvc.Annotations, "carina.storage.io/fstype")
+}
+
+func TestSelectDefaultClass(t *testing.T) {
+	classes := []carinav1.CarinaDefaultClass{
+		{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Spec: carinav1.CarinaDefaultClassSpec{StorageClassName: "csi-carina-hdd"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "gold"}, Spec: carinav1.CarinaDefaultClassSpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "gold"}},
+			StorageClassName:  "csi-carina-ssd",
+			Priority:          10,
+		}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "broken"}, Spec: carinav1.CarinaDefaultClassSpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "gold"}},
+			Priority:          20,
+		}},
+	}
+
+	a := assert.New(t)
+	dc := selectDefaultClass(classes, map[string]string{"tier": "gold"})
+	if a.NotNil(dc) {
+		a.Equal("gold", dc.Name)
+	}
+	dc = selectDefaultClass(classes, map[string]string{"tier": "silver"})
+	if a.NotNil(dc) {
+		a.Equal("default", dc.Name)
+	}
+	a.Nil(selectDefaultClass(classes[1:], nil))
+}
+
+func TestApplyDefaultClass(t *testing.T) {
+	dc := &carinav1.CarinaDefaultClass{Spec: carinav1.CarinaDefaultClassSpec{StorageClassName: "csi-carina-ssd", DeviceGroup: "carina-vg-ssd"}}
+	lvmSC := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "csi-carina-ssd"}}
+	bcacheSC := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "csi-carina-bcache"},
+		Parameters: map[string]string{"carina.storage.io/backend-disk-group-name": "hdd"}}
+
+	a := assert.New(t)
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	applyDefaultClass(pvc, lvmSC, dc)
+	if a.NotNil(pvc.Spec.StorageClassName) {
+		a.Equal("csi-carina-ssd", *pvc.Spec.StorageClassName)
+	}
+	a.Equal("carina-vg-ssd", pvc.Annotations["carina.storage.io/disk-group-name"])
+
+	// explicit disk group is kept
+	pvc = &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		"carina.storage.io/disk-group-name": "carina-vg-hdd",
+	}}}
+	applyDefaultClass(pvc, lvmSC, dc)
+	a.Equal("carina-vg-hdd", pvc.Annotations["carina.storage.io/disk-group-name"])
+
+	pvc = &corev1.PersistentVolumeClaim{}
+	applyDefaultClass(pvc, bcacheSC, dc)
+	if a.NotNil(pvc.Spec.StorageClassName) {
+		a.Equal("csi-carina-bcache", *pvc.Spec.StorageClassName)
+	}
+	a.NotContains(pvc.Annotations, "carina.storage.io/disk-group-name")
+}
@@ -0,0 +1,130 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:webhookVersions=v1,path=/pod/evict,mutating=false,failurePolicy=ignore,matchPolicy=equivalent,groups="",resources=pods/eviction,verbs=create,versions=v1,sideEffects=none,name=eviction-hook.carina.storage.io
+
+// evictionValidator refuses evictions from cordoned nodes of pods whose carina volumes are on that node.
+type evictionValidator struct {
+	client client.Client
+}
+
+// EvictionValidator creates a validating webhook for pod evictions.
+// A drain evicts the pods of a cordoned node, a pod of a StatefulSet evicted while its local volume stays on
+// the node can not start anywhere else. The eviction is answered with 429 Too Many Requests like an eviction
+// blocked by a PodDisruptionBudget, so kubectl drain keeps retrying until the volume was migrated, the pod was
+// deleted or the drain was allowed by the annotation carina.storage.io/allow-drain on the pod or the node.
+func EvictionValidator(c client.Client) http.Handler {
+	return &webhook.Admission{Handler: evictionValidator{c}}
+}
+
+// Handle implements admission.Handler interface.
+func (v evictionValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.SubResource != "eviction" {
+		return admission.Allowed("")
+	}
+	pod := &corev1.Pod{}
+	if err := v.client.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: req.Name}, pod); err != nil {
+		if !apierrs.IsNotFound(err) {
+			log.Error(err.Error(), " get pod ", req.Namespace, "/", req.Name, " failed")
+		}
+		return admission.Allowed("skip validation")
+	}
+	if pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return admission.Allowed("pod is not running")
+	}
+	if pod.Annotations[utils.AllowDrain] == "true" {
+		return admission.Allowed("drain allowed by pod annotation")
+	}
+
+	node := &corev1.Node{}
+	if err := v.client.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node); err != nil {
+		return admission.Allowed("skip validation")
+	}
+	// 只拦截驱逐节点，其它驱逐(如descheduler)不受影响
+	if !node.Spec.Unschedulable {
+		return admission.Allowed("node is not cordoned")
+	}
+	if node.Annotations[utils.AllowDrain] == "true" {
+		return admission.Allowed("drain allowed by node annotation")
+	}
+
+	pvs := []*corev1.PersistentVolume{}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := v.client.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: volume.PersistentVolumeClaim.ClaimName}, pvc); err != nil || pvc.Spec.VolumeName == "" {
+			continue
+		}
+		pv := &corev1.PersistentVolume{}
+		if err := v.client.Get(ctx, types.NamespacedName{Name: pvc.Spec.VolumeName}, pv); err != nil {
+			continue
+		}
+		pvs = append(pvs, pv)
+	}
+	blockers := drainBlockers(node.Name, pvs)
+	if len(blockers) == 0 {
+		return admission.Allowed("")
+	}
+
+	msg := fmt.Sprintf("node %s is drained but pod %s/%s uses carina volumes on it: %s; move them with kubectl carina recreate or annotate the pod or node %s=true",
+		node.Name, pod.Namespace, pod.Name, strings.Join(blockers, ", "), utils.AllowDrain)
+	log.Infof("refuse eviction: %s", msg)
+	resp := admission.Denied("")
+	// 与PodDisruptionBudget拒绝驱逐一致，kubectl drain遇到429会重试
+	resp.Result.Code = http.StatusTooManyRequests
+	resp.Result.Reason = metav1.StatusReasonTooManyRequests
+	resp.Result.Message = msg
+	return resp
+}
+
+// drainBlockers returns the claims of the carina volumes on the node, they become unavailable when the pod is
+// evicted. Volumes that were migrated to another node no longer block the drain.
+func drainBlockers(node string, pvs []*corev1.PersistentVolume) []string {
+	blockers := []string{}
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != utils.CSIPluginName || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		if pv.Spec.CSI.VolumeAttributes[utils.VolumeDeviceNode] != node {
+			continue
+		}
+		blockers = append(blockers, pv.Spec.ClaimRef.Name)
+	}
+	sort.Strings(blockers)
+	return blockers
+}
@@ -0,0 +1,104 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/carina-io/carina/controllers"
+	"github.com/carina-io/carina/pkg/iobudget"
+	"github.com/carina-io/carina/utils"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:webhookVersions=v1,path=/pod/validate,mutating=false,failurePolicy=ignore,matchPolicy=equivalent,groups="",resources=pods,verbs=create;update,versions=v1,sideEffects=none,name=pod-validate-hook.carina.storage.io
+
+var blkioAnnotations = []string{
+	controllers.BlkIOThrottleReadBPS,
+	controllers.BlkIOThrottleReadIOPS,
+	controllers.BlkIOThrottleWriteBPS,
+	controllers.BlkIOThrottleWriteIOPS,
+	controllers.BlkIOThrottleTotalReadBPS,
+	controllers.BlkIOThrottleTotalReadIOPS,
+	controllers.BlkIOThrottleTotalWriteBPS,
+	controllers.BlkIOThrottleTotalWriteIOPS,
+	controllers.BlkIOThrottleDistribution,
+}
+
+// podValidator validates the blkio throttle annotations of pods.
+type podValidator struct {
+	decoder *admission.Decoder
+}
+
+// PodValidator creates a validating webhook for Pods.
+func PodValidator(dec *admission.Decoder) http.Handler {
+	return &webhook.Admission{Handler: podValidator{dec}}
+}
+
+// Handle implements admission.Handler interface.
+func (v podValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pod := &corev1.Pod{}
+	if err := v.decoder.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if problems := validateBlkioAnnotations(pod.Annotations); len(problems) > 0 {
+		return admission.Denied(fmt.Sprintf("pod %s has invalid annotations: %s", pod.Name, strings.Join(problems, "; ")))
+	}
+	return admission.Allowed("")
+}
+
+// validateBlkioAnnotations 限速注解的值会直接写入cgroup文件，必须是非负整数
+func validateBlkioAnnotations(annotations map[string]string) []string {
+	problems := []string{}
+	prefix := controllers.KubernetesCustomized + "/blkio."
+	keys := []string{}
+	for k := range annotations {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		name := strings.TrimPrefix(k, controllers.KubernetesCustomized+"/")
+		if !utils.ContainsString(blkioAnnotations, name) {
+			supported := []string{}
+			for _, b := range blkioAnnotations {
+				supported = append(supported, controllers.KubernetesCustomized+"/"+b)
+			}
+			problems = append(problems, fmt.Sprintf("unknown annotation %s, supported annotations are %s", k, strings.Join(supported, ", ")))
+			continue
+		}
+		if name == controllers.BlkIOThrottleDistribution {
+			if !utils.ContainsString([]string{iobudget.DistributionEven, iobudget.DistributionCapacity}, annotations[k]) {
+				problems = append(problems, fmt.Sprintf("%s must be %s or %s, got %q", k, iobudget.DistributionEven, iobudget.DistributionCapacity, annotations[k]))
+			}
+			continue
+		}
+		if _, err := strconv.ParseUint(annotations[k], 10, 64); err != nil {
+			problems = append(problems, fmt.Sprintf("%s must be a non-negative integer (bytes or io per second), got %q", k, annotations[k]))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,211 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/version"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:webhookVersions=v1,path=/pvc/validate,mutating=false,failurePolicy=ignore,matchPolicy=equivalent,groups="",resources=persistentvolumeclaims,verbs=create,versions=v1,sideEffects=none,name=pvc-hook.carina.storage.io
+// +kubebuilder:rbac:groups=carina.storage.io,resources=nodestorageresources,verbs=get;list;watch
+
+// pvcValidator rejects PVCs requesting a disk group that no node provides.
+type pvcValidator struct {
+	client  client.Client
+	decoder *admission.Decoder
+}
+
+// PVCValidator creates a validating webhook for PVCs.
+func PVCValidator(c client.Client, dec *admission.Decoder) http.Handler {
+	return &webhook.Admission{Handler: pvcValidator{c, dec}}
+}
+
+// Handle implements admission.Handler interface.
+func (v pvcValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := v.decoder.Decode(req, pvc); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return admission.Allowed("no storageclass")
+	}
+
+	var sc storagev1.StorageClass
+	if err := v.client.Get(ctx, types.NamespacedName{Name: *pvc.Spec.StorageClassName}, &sc); err != nil {
+		if apierrs.IsNotFound(err) {
+			// the storageclass may be created later
+			return admission.Allowed("storageclass not found")
+		}
+		log.Error(err.Error(), " get storageclass ", *pvc.Spec.StorageClassName, " failed")
+		return admission.Allowed("skip validation")
+	}
+	if sc.Provisioner != utils.CSIPluginName {
+		return admission.Allowed("not a carina storageclass")
+	}
+
+	// pvc注解切换到必须加密的磁盘组时，存储类需要提供luks密码
+	if group := pvc.Annotations[utils.DeviceDiskKey]; group != "" && sc.Parameters[utils.VolumeBackendDiskType] == "" &&
+		sc.Parameters[utils.NodePublishSecretName] == "" && configuration.IsEncryptedDeviceGroup(version.GetDeviceGroup(group)) {
+		return admission.Denied(fmt.Sprintf("disk group %s requested by pvc annotation %s must be encrypted by cluster policy, but storageclass %s has no %s",
+			group, utils.DeviceDiskKey, sc.Name, utils.NodePublishSecretName))
+	}
+
+	if ttl, ok := pvc.Annotations[utils.VolumeTTL]; ok {
+		if _, err := utils.ParseVolumeTTL(ttl); err != nil {
+			return admission.Denied(err.Error())
+		}
+	}
+
+	if source := pvc.Annotations[utils.VolumeImportSource]; source != "" {
+		if err := validateImportSource(pvc, source); err != nil {
+			return admission.Denied(err.Error())
+		}
+	}
+
+	if problems := bcacheAnnotationProblems(sc.Parameters, pvc.Annotations); len(problems) > 0 {
+		return admission.Denied(strings.Join(problems, "; "))
+	}
+
+	var nsrList carinav1beta1.NodeStorageResourceList
+	if err := v.client.List(ctx, &nsrList); err != nil {
+		// 校验失败不应阻塞pvc创建，由csi控制器在供应时报错
+		log.Error(err.Error(), " list NodeStorageResource failed")
+		return admission.Allowed("skip validation")
+	}
+	// 集群刚部署时节点尚未上报磁盘，此时无法判断
+	if len(nsrList.Items) == 0 {
+		return admission.Allowed("no node storage reported yet")
+	}
+
+	groups := availableDeviceGroups(nsrList.Items)
+	if group := pvc.Annotations[utils.DeviceDiskKey]; group != "" {
+		group = version.GetDeviceGroup(group)
+		if !utils.ContainsString(groups, group) {
+			return admission.Denied(fmt.Sprintf("disk group %s requested by pvc annotation %s does not exist on any node, available disk groups are: %s",
+				group, utils.DeviceDiskKey, strings.Join(groups, ", ")))
+		}
+	}
+	params := utils.BcacheParameters(sc.Parameters, pvc.Annotations)
+	for _, key := range []string{utils.DeviceDiskKey, utils.VolumeBackendDiskType, utils.VolumeCacheDiskType} {
+		group := params[key]
+		if key == utils.DeviceDiskKey {
+			group = utils.DeviceGroupParameter(sc.Parameters)
+		}
+		// the pvc annotation overrides the storageclass disk group
+		if key == utils.DeviceDiskKey && pvc.Annotations[utils.DeviceDiskKey] != "" {
+			continue
+		}
+		if group == "" {
+			continue
+		}
+		group = version.GetDeviceGroup(group)
+		if !utils.ContainsString(groups, group) {
+			return admission.Denied(fmt.Sprintf("disk group %s requested by storageclass %s or pvc annotation (%s) does not exist on any node, available disk groups are: %s",
+				group, sc.Name, key, strings.Join(groups, ", ")))
+		}
+	}
+	return admission.Allowed("")
+}
+
+// validateImportSource 导入目录必须在importHostPaths允许的范围内
+func validateImportSource(pvc *corev1.PersistentVolumeClaim, source string) error {
+	_, path, err := utils.ParseImportSource(source)
+	if err != nil {
+		return fmt.Errorf("invalid pvc annotation %s: %v", utils.VolumeImportSource, err)
+	}
+	if pvc.Spec.DataSource != nil {
+		return fmt.Errorf("pvc annotation %s can not be combined with a data source", utils.VolumeImportSource)
+	}
+	if pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == corev1.PersistentVolumeBlock {
+		return fmt.Errorf("block volumes can not be imported")
+	}
+	if !utils.PathWithin(path, configuration.ImportHostPaths()) {
+		return fmt.Errorf("import from %s is not allowed, the directory must be below one of importHostPaths %v", path, configuration.ImportHostPaths())
+	}
+	return nil
+}
+
+// bcacheAnnotationProblems 校验pvc注解选择的bcache参数，与storageclass参数合并后检查
+func bcacheAnnotationProblems(params, annotations map[string]string) []string {
+	set := false
+	for _, key := range []string{utils.VolumeBackendDiskType, utils.VolumeCacheDiskType, utils.VolumeCacheDiskRatio, utils.VolumeCachePolicy} {
+		if annotations[key] != "" {
+			set = true
+		}
+	}
+	if !set {
+		return nil
+	}
+	merged := utils.BcacheParameters(params, annotations)
+	problems := []string{}
+	if merged[utils.VolumeCacheDiskType] == "" || merged[utils.VolumeCacheDiskRatio] == "" {
+		problems = append(problems, fmt.Sprintf("bcache volumes need %s and %s from the pvc annotations or the storageclass", utils.VolumeCacheDiskType, utils.VolumeCacheDiskRatio))
+	}
+	if merged[utils.VolumeCacheDiskType] != "" && merged[utils.VolumeBackendDiskType] == "" {
+		problems = append(problems, fmt.Sprintf("bcache volumes need a backing disk group, set %s or %s", utils.VolumeBackendDiskType, utils.DeviceDiskKey))
+	}
+	if v := merged[utils.VolumeCacheDiskRatio]; v != "" {
+		ratio, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || ratio < 1 || ratio >= 100 {
+			problems = append(problems, fmt.Sprintf("%s must be an integer between 1 and 99, got %q", utils.VolumeCacheDiskRatio, v))
+		}
+	}
+	if v := merged[utils.VolumeCachePolicy]; v != "" && !utils.ContainsString([]string{"writethrough", "writeback", "writearound"}, v) {
+		problems = append(problems, fmt.Sprintf("%s must be one of writethrough, writeback, writearound, got %q", utils.VolumeCachePolicy, v))
+	}
+	if stripes, _, _ := utils.StripeParameters(params); stripes > 1 {
+		problems = append(problems, "striping is not supported for bcache volumes")
+	}
+	return problems
+}
+
+// availableDeviceGroups 从节点可分配容量中汇总所有磁盘组
+// lvm groups are reported as carina.storage.io/<group>, raw disks as carina.storage.io/<group>/<disk>.
+func availableDeviceGroups(nsrs []carinav1beta1.NodeStorageResource) []string {
+	groups := []string{}
+	for _, nsr := range nsrs {
+		for key := range nsr.Status.Allocatable {
+			if !strings.HasPrefix(key, utils.DeviceCapacityKeyPrefix) {
+				continue
+			}
+			group := strings.Split(strings.TrimPrefix(key, utils.DeviceCapacityKeyPrefix), "/")[0]
+			if group != "" && !utils.ContainsString(groups, group) {
+				groups = append(groups, group)
+			}
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
@@ -0,0 +1,192 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/populator"
+	"github.com/carina-io/carina/pkg/version"
+	"github.com/carina-io/carina/utils"
+	storagev1 "k8s.io/api/storage/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:webhookVersions=v1,path=/storageclass/validate,mutating=false,failurePolicy=ignore,matchPolicy=equivalent,groups=storage.k8s.io,resources=storageclasses,verbs=create;update,versions=v1,sideEffects=none,name=storageclass-hook.carina.storage.io
+
+// knownParameters 所有carina支持的storageclass参数
+var knownParameters = utils.StorageClassParameters
+
+// storageClassValidator validates parameters of Carina StorageClasses.
+type storageClassValidator struct {
+	client  client.Client
+	decoder *admission.Decoder
+}
+
+// StorageClassValidator creates a validating webhook for StorageClasses.
+func StorageClassValidator(c client.Client, dec *admission.Decoder) http.Handler {
+	return &webhook.Admission{Handler: storageClassValidator{c, dec}}
+}
+
+// Handle implements admission.Handler interface.
+func (v storageClassValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	sc := &storagev1.StorageClass{}
+	if err := v.decoder.Decode(req, sc); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if sc.Provisioner != utils.CSIPluginName {
+		return admission.Allowed("not a carina storageclass")
+	}
+
+	problems := validateStorageClassParameters(sc.Parameters)
+	problems = append(problems, encryptionProblems(sc.Parameters, func(group string) bool {
+		return configuration.IsEncryptedDeviceGroup(version.GetDeviceGroup(group))
+	})...)
+	if len(problems) > 0 {
+		return admission.Denied(fmt.Sprintf("storageclass %s is invalid: %s", sc.Name, strings.Join(problems, "; ")))
+	}
+	return admission.Allowed("")
+}
+
+// validateStorageClassParameters 检查carina参数名称及取值，返回所有问题
+func validateStorageClassParameters(params map[string]string) []string {
+	problems := []string{}
+	keys := []string{}
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if strings.HasPrefix(k, utils.DeviceCapacityKeyPrefix) && !utils.ContainsString(knownParameters, k) {
+			problems = append(problems, fmt.Sprintf("unknown parameter %s, supported parameters are %s", k, strings.Join(knownParameters, ", ")))
+		}
+	}
+
+	for _, key := range []string{utils.ExclusivityDisk, utils.VolumeEncrypted, utils.VolumeFstrim, utils.VolumeShared} {
+		if v, ok := params[key]; ok && v != "true" && v != "false" {
+			problems = append(problems, fmt.Sprintf("%s must be \"true\" or \"false\", got %q", key, v))
+		}
+	}
+
+	_, hasBackend := params[utils.VolumeBackendDiskType]
+	_, hasCache := params[utils.VolumeCacheDiskType]
+	_, hasRatio := params[utils.VolumeCacheDiskRatio]
+	if hasBackend || hasCache || hasRatio {
+		if !hasBackend || !hasCache || !hasRatio {
+			problems = append(problems, fmt.Sprintf("bcache volumes need all of %s, %s and %s", utils.VolumeBackendDiskType, utils.VolumeCacheDiskType, utils.VolumeCacheDiskRatio))
+		}
+		if utils.DeviceGroupParameter(params) != "" {
+			problems = append(problems, fmt.Sprintf("%s can not be used together with %s", utils.DeviceDiskKey, utils.VolumeBackendDiskType))
+		}
+	}
+	if params[utils.DeviceDiskKey] != "" && params[utils.DeviceGroupKey] != "" && params[utils.DeviceDiskKey] != params[utils.DeviceGroupKey] {
+		problems = append(problems, fmt.Sprintf("%s and %s request different disk groups", utils.DeviceDiskKey, utils.DeviceGroupKey))
+	}
+	if v, ok := params[utils.VolumeCacheDiskRatio]; ok {
+		ratio, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || ratio < 1 || ratio >= 100 {
+			problems = append(problems, fmt.Sprintf("%s must be an integer between 1 and 99, got %q", utils.VolumeCacheDiskRatio, v))
+		}
+	}
+	if v, ok := params[utils.VolumeCachePolicy]; ok && !utils.ContainsString([]string{"writethrough", "writeback", "writearound"}, v) {
+		problems = append(problems, fmt.Sprintf("%s must be one of writethrough, writeback, writearound, got %q", utils.VolumeCachePolicy, v))
+	}
+
+	if stripes, _, err := utils.StripeParameters(params); err != nil {
+		problems = append(problems, err.Error())
+	} else if stripes > 1 && (hasBackend || hasCache) {
+		problems = append(problems, fmt.Sprintf("%s can not be used for bcache volumes", utils.VolumeStripes))
+	}
+
+	if _, _, err := utils.WipeParameters(params); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if v, ok := params[utils.VolumeMkfsOptions]; ok {
+		// 与CreateVolume一致，未指定文件系统时为ext4
+		fsType := params["csi.storage.k8s.io/fstype"]
+		if fsType == "" {
+			fsType = "ext4"
+		}
+		if _, err := utils.MkfsOptions(fsType, v); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if v, ok := params[utils.VolumeFsckPolicy]; ok && !utils.ContainsString([]string{utils.FsckPolicyNever, utils.FsckPolicyAuto, utils.FsckPolicyForce}, v) {
+		problems = append(problems, fmt.Sprintf("%s must be one of never, auto, force, got %q", utils.VolumeFsckPolicy, v))
+	}
+	if v, ok := params[utils.VolumeReplicas]; ok {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 2 {
+			problems = append(problems, fmt.Sprintf("%s must be 1 or 2, got %q", utils.VolumeReplicas, v))
+		}
+	}
+	if _, _, _, err := utils.ParseDeviceOwner(params[utils.VolumeDeviceOwner]); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := utils.ParseDeviceMode(params[utils.VolumeDeviceMode]); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if v, ok := params[utils.VolumePrefillSource]; ok {
+		if _, err := populator.ParseS3URL(v); err != nil {
+			problems = append(problems, fmt.Sprintf("%s is invalid: %v", utils.VolumePrefillSource, err))
+		}
+	}
+	if v, ok := params[utils.VolumePrefillParallelism]; ok {
+		if n, err := strconv.Atoi(v); err != nil || n <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be a positive integer, got %q", utils.VolumePrefillParallelism, v))
+		}
+	}
+	return problems
+}
+
+// encryptionProblems 集群策略要求加密的磁盘组，存储类不能关闭加密且必须提供luks密码
+// encryptedGroup reports whether the cluster policy requires encryption of a disk group.
+func encryptionProblems(params map[string]string, encryptedGroup func(group string) bool) []string {
+	problems := []string{}
+	required := params[utils.VolumeEncrypted] == "true"
+	for _, group := range []string{utils.DeviceGroupParameter(params), params[utils.VolumeBackendDiskType], params[utils.VolumeCacheDiskType]} {
+		if group == "" || !encryptedGroup(group) {
+			continue
+		}
+		if params[utils.VolumeEncrypted] == "false" {
+			problems = append(problems, fmt.Sprintf("disk group %s must be encrypted by cluster policy, %s can not be \"false\"", group, utils.VolumeEncrypted))
+		}
+		required = true
+	}
+	if !required {
+		return problems
+	}
+
+	if params[utils.VolumeBackendDiskType] != "" || params[utils.VolumeCacheDiskType] != "" {
+		problems = append(problems, "encryption is not supported for bcache volumes")
+	}
+	if params[utils.NodePublishSecretName] == "" {
+		problems = append(problems, fmt.Sprintf("encrypted volumes need %s naming a secret with the key %s", utils.NodePublishSecretName, utils.EncryptionPassphraseSecret))
+	}
+	return problems
+}
@@ -0,0 +1,160 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// parameterSources 读取storageclass参数的表达式，volume context由CreateVolume从参数复制而来
+var parameterSources = map[string]bool{
+	"req.GetParameters()":    true,
+	"req.Parameters":         true,
+	"params":                 true,
+	"parameters":             true,
+	"volumeContext":          true,
+	"req.GetVolumeContext()": true,
+}
+
+// utilsConstants 解析utils/constants.go中的字符串常量
+func utilsConstants(t *testing.T) map[string]string {
+	f, err := parser.ParseFile(token.NewFileSet(), "../utils/constants.go", nil, 0)
+	assert.NoError(t, err)
+	constants := map[string]string{}
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, name := range vs.Names {
+				if i >= len(vs.Values) {
+					continue
+				}
+				if lit, ok := vs.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					constants[name.Name], _ = strconv.Unquote(lit.Value)
+				}
+			}
+		}
+	}
+	return constants
+}
+
+// parameterKeys 收集文件中读取和写入storageclass参数所用的utils常量名
+func parameterKeys(t *testing.T, path string, reads, writes map[string]bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, nil, 0)
+	assert.NoError(t, err)
+	inUtils := f.Name.Name == "utils"
+
+	key := func(e *ast.IndexExpr) string {
+		if !parameterSources[types.ExprString(e.X)] {
+			return ""
+		}
+		switch index := e.Index.(type) {
+		case *ast.SelectorExpr:
+			if pkg, ok := index.X.(*ast.Ident); ok && pkg.Name == "utils" {
+				return index.Sel.Name
+			}
+		case *ast.Ident:
+			if inUtils {
+				return index.Name
+			}
+		}
+		return ""
+	}
+
+	assigned := map[ast.Expr]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if as, ok := n.(*ast.AssignStmt); ok {
+			for _, lhs := range as.Lhs {
+				assigned[lhs] = true
+			}
+		}
+		return true
+	})
+	ast.Inspect(f, func(n ast.Node) bool {
+		e, ok := n.(*ast.IndexExpr)
+		if !ok {
+			return true
+		}
+		if k := key(e); k != "" {
+			if assigned[e] {
+				writes[k] = true
+			} else {
+				reads[k] = true
+			}
+		}
+		return true
+	})
+}
+
+func TestStorageClassParametersTable(t *testing.T) {
+	constants := utilsConstants(t)
+	reads, writes := map[string]bool{}, map[string]bool{}
+	parameterKeys(t, "../utils/utils.go", reads, writes)
+	err := filepath.Walk("../pkg/csidriver", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		parameterKeys(t, path, reads, writes)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	table := map[string]bool{}
+	for _, p := range knownParameters {
+		table[p] = true
+	}
+	// CreateSnapshot读取的是VolumeSnapshotClass的参数
+	for _, p := range utils.SnapshotClassParameters {
+		table[p] = true
+	}
+	read := map[string]bool{}
+	missing := []string{}
+	for name := range reads {
+		value, ok := constants[name]
+		assert.True(t, ok, "utils.%s is not a string constant", name)
+		read[value] = true
+		// 控制器写入volume context的属性和pvc注解不是storageclass参数
+		if writes[name] || !strings.HasPrefix(value, utils.CSIPluginName+"/") {
+			continue
+		}
+		if !table[value] {
+			missing = append(missing, value)
+		}
+	}
+	sort.Strings(missing)
+	assert.Empty(t, missing, "storageclass parameters read in pkg/csidriver but missing from utils.StorageClassParameters")
+
+	for _, p := range knownParameters {
+		assert.True(t, read[p], "%s is allowed but never read", p)
+	}
+}
@@ -0,0 +1,247 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"testing"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestValidateStorageClassParameters(t *testing.T) {
+	table := []struct {
+		params   map[string]string
+		problems int
+	}{
+		{params: map[string]string{"carina.storage.io/disk-group-name": "carina-vg-ssd", "csi.storage.k8s.io/fstype": "xfs"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/disk-group": "nvme"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/disk-group-name": "nvme", "carina.storage.io/disk-group": "hdd"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/disk-type": "carina-vg-ssd"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/exclusively-raw-disk": "yes"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/encrypted": "yes"}, problems: 1},
+		{params: map[string]string{
+			"carina.storage.io/backend-disk-group-name": "hdd",
+			"carina.storage.io/cache-disk-group-name":   "ssd",
+			"carina.storage.io/cache-disk-ratio":        "50",
+			"carina.storage.io/cache-policy":            "writeback",
+		}, problems: 0},
+		{params: map[string]string{
+			"carina.storage.io/backend-disk-group-name": "hdd",
+			"carina.storage.io/cache-disk-ratio":        "100",
+		}, problems: 2},
+		{params: map[string]string{"carina.storage.io/cache-policy": "writeall"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/prefill-source": "http://bucket", "carina.storage.io/prefill-parallelism": "0"}, problems: 2},
+		{params: map[string]string{"carina.storage.io/stripes": "4", "carina.storage.io/stripe-size": "64k"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/stripe-size": "64k"}, problems: 1},
+		{params: map[string]string{"csi.storage.k8s.io/fstype": "ext4", "carina.storage.io/mkfs-options": "-O ^has_journal -E lazy_itable_init=0"}, problems: 0},
+		{params: map[string]string{"csi.storage.k8s.io/fstype": "xfs", "carina.storage.io/mkfs-options": "-m crc=1,reflink=1 -i size=512"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/mkfs-options": "-n"}, problems: 1},
+		{params: map[string]string{"csi.storage.k8s.io/fstype": "btrfs", "carina.storage.io/mkfs-options": "-L data"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/device-owner": "999:999", "carina.storage.io/device-mode": "0660"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/device-owner": "pod"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/device-owner": "postgres", "carina.storage.io/device-mode": "0644"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/device-mode": "0460"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/device-mode": "rw"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/fstrim": "true"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/fstrim": "weekly"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/fsck-policy": "auto"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/fsck-policy": "always"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/replicas": "2"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/replicas": "3"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/replicas": "two"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/wipe-policy": "zero", "carina.storage.io/wipe-passes": "3"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/wipe-policy": "shred"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/wipe-policy": "zero", "carina.storage.io/wipe-passes": "8"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/wipe-passes": "2"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/shared": "true"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/shared": "nfs"}, problems: 1},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		a.Len(validateStorageClassParameters(e.params), e.problems, e.params)
+	}
+}
+
+func TestBcacheAnnotationProblems(t *testing.T) {
+	plain := map[string]string{"carina.storage.io/disk-group-name": "carina-vg-hdd"}
+	bcache := map[string]string{
+		"carina.storage.io/backend-disk-group-name": "carina-vg-hdd",
+		"carina.storage.io/cache-disk-group-name":   "carina-vg-ssd",
+		"carina.storage.io/cache-disk-ratio":        "20",
+	}
+	table := []struct {
+		params      map[string]string
+		annotations map[string]string
+		problems    int
+	}{
+		{params: plain, annotations: nil, problems: 0},
+		{params: plain, annotations: map[string]string{"carina.storage.io/cache-disk-group-name": "carina-vg-ssd", "carina.storage.io/cache-disk-ratio": "30"}, problems: 0},
+		{params: plain, annotations: map[string]string{"carina.storage.io/cache-disk-group-name": "carina-vg-ssd"}, problems: 1},
+		{params: map[string]string{}, annotations: map[string]string{"carina.storage.io/cache-disk-group-name": "carina-vg-ssd", "carina.storage.io/cache-disk-ratio": "30"}, problems: 1},
+		{params: bcache, annotations: map[string]string{"carina.storage.io/cache-disk-group-name": "carina-vg-nvme", "carina.storage.io/cache-policy": "writeback"}, problems: 0},
+		{params: bcache, annotations: map[string]string{"carina.storage.io/cache-disk-ratio": "100"}, problems: 1},
+		{params: bcache, annotations: map[string]string{"carina.storage.io/cache-policy": "writeall"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/disk-group-name": "carina-vg-hdd", "carina.storage.io/stripes": "2"},
+			annotations: map[string]string{"carina.storage.io/cache-disk-group-name": "carina-vg-ssd", "carina.storage.io/cache-disk-ratio": "30"}, problems: 1},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		a.Len(bcacheAnnotationProblems(e.params, e.annotations), e.problems, e.annotations)
+	}
+}
+
+func TestEncryptionProblems(t *testing.T) {
+	encryptedGroup := func(group string) bool { return group == "carina-vg-secure" }
+	secret := "csi.storage.k8s.io/node-publish-secret-name"
+	table := []struct {
+		params   map[string]string
+		problems int
+	}{
+		{params: map[string]string{"carina.storage.io/disk-group-name": "carina-vg-ssd"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/disk-group-name": "carina-vg-secure"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/disk-group": "carina-vg-secure", secret: "luks"}, problems: 0},
+		{params: map[string]string{"carina.storage.io/disk-group": "carina-vg-secure", secret: "luks", "carina.storage.io/encrypted": "false"}, problems: 1},
+		{params: map[string]string{"carina.storage.io/disk-group-name": "carina-vg-ssd", "carina.storage.io/encrypted": "true"}, problems: 1},
+		{params: map[string]string{
+			"carina.storage.io/backend-disk-group-name": "carina-vg-secure",
+			"carina.storage.io/cache-disk-group-name":   "carina-vg-ssd",
+			secret: "luks",
+		}, problems: 1},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		a.Len(encryptionProblems(e.params, encryptedGroup), e.problems, e.params)
+	}
+}
+
+func TestValidateBlkioAnnotations(t *testing.T) {
+	table := []struct {
+		annotations map[string]string
+		problems    int
+	}{
+		{annotations: map[string]string{"carina.storage.io/blkio.throttle.read_bps_device": "10485760"}, problems: 0},
+		{annotations: map[string]string{"carina.storage.io/blkio.throttle.read_bps_device": "10M"}, problems: 1},
+		{annotations: map[string]string{"carina.storage.io/blkio.throttle.write_iops_device": "-1"}, problems: 1},
+		{annotations: map[string]string{"carina.storage.io/blkio.throttle.read_bps": "100"}, problems: 1},
+		{annotations: map[string]string{
+			"carina.storage.io/blkio.throttle.total_read_bps": "104857600",
+			"carina.storage.io/blkio.throttle.distribution":   "capacity",
+		}, problems: 0},
+		{annotations: map[string]string{"carina.storage.io/blkio.throttle.total_write_iops": "1k"}, problems: 1},
+		{annotations: map[string]string{"carina.storage.io/blkio.throttle.distribution": "weighted"}, problems: 1},
+		{annotations: map[string]string{"app": "demo"}, problems: 0},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		a.Len(validateBlkioAnnotations(e.annotations), e.problems, e.annotations)
+	}
+}
+
+func TestAvailableDeviceGroups(t *testing.T) {
+	nsrs := []carinav1beta1.NodeStorageResource{
+		{Status: carinav1beta1.NodeStorageResourceStatus{Allocatable: map[string]resource.Quantity{
+			"carina.storage.io/carina-vg-ssd":      resource.MustParse("100"),
+			"carina.storage.io/carina-raw-hdd/sdb": resource.MustParse("200"),
+		}}},
+		{Status: carinav1beta1.NodeStorageResourceStatus{Allocatable: map[string]resource.Quantity{
+			"carina.storage.io/carina-vg-ssd": resource.MustParse("100"),
+			"carina.storage.io/carina-vg-hdd": resource.MustParse("100"),
+		}}},
+	}
+	assert.New(t).Equal([]string{"carina-raw-hdd", "carina-vg-hdd", "carina-vg-ssd"}, availableDeviceGroups(nsrs))
+}
+
+func TestValidateVolumeOperation(t *testing.T) {
+	claim := &carinav1.VolumeClaimReference{Namespace: "mysql", Name: "data-mysql-0"}
+	table := []struct {
+		spec     carinav1.VolumeOperationSpec
+		problems int
+	}{
+		{spec: carinav1.VolumeOperationSpec{Operation: carinav1.VolumeOperationForceDelete, LogicVolume: "pvc-1"}, problems: 0},
+		{spec: carinav1.VolumeOperationSpec{Operation: carinav1.VolumeOperationWipe, LogicVolume: "pvc-1", WipePolicy: "discard"}, problems: 0},
+		{spec: carinav1.VolumeOperationSpec{Operation: carinav1.VolumeOperationWipe, LogicVolume: "pvc-1", WipePolicy: "none"}, problems: 1},
+		{spec: carinav1.VolumeOperationSpec{Operation: carinav1.VolumeOperationForceDelete, LogicVolume: "pvc-1", WipePolicy: "zero"}, problems: 1},
+		{spec: carinav1.VolumeOperationSpec{Operation: carinav1.VolumeOperationAdopt, LogicVolume: "pvc-1", ClaimRef: claim}, problems: 0},
+		{spec: carinav1.VolumeOperationSpec{Operation: carinav1.VolumeOperationAdopt, LogicVolume: "pvc-1"}, problems: 1},
+		{spec: carinav1.VolumeOperationSpec{Operation: carinav1.VolumeOperationWipe, LogicVolume: "pvc-1", ClaimRef: claim}, problems: 1},
+		{spec: carinav1.VolumeOperationSpec{Operation: "Format"}, problems: 2},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		a.Len(validateVolumeOperation(&carinav1.VolumeOperation{Spec: e.spec}), e.problems, e.spec)
+	}
+}
+
+func TestValidateLogicVolume(t *testing.T) {
+	lv := func(node, group, size string) *carinav1.LogicVolume {
+		return &carinav1.LogicVolume{Spec: carinav1.LogicVolumeSpec{NodeName: node, DeviceGroup: group, Size: resource.MustParse(size)}}
+	}
+	old := lv("node1", "carina-vg-ssd", "10Gi")
+	table := []struct {
+		old, lv  *carinav1.LogicVolume
+		problems int
+	}{
+		{lv: lv("node1", "carina-vg-ssd", "10Gi"), problems: 0},
+		{lv: lv("", "", "0"), problems: 3},
+		{old: old, lv: lv("node1", "carina-vg-ssd", "20Gi"), problems: 0},
+		{old: old, lv: lv("node2", "carina-vg-hdd", "10Gi"), problems: 2},
+		{old: old, lv: lv("node1", "carina-vg-ssd", "5Gi"), problems: 1},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		a.Len(validateLogicVolume(e.old, e.lv), e.problems, e.lv.Spec)
+	}
+
+	l := lv("node1", "carina-vg-ssd", "10Gi")
+	a.False(defaultLogicVolume(l))
+	l.Spec.Size = resource.MustParse("1500M")
+	a.True(defaultLogicVolume(l))
+	a.Equal(int64(1432)<<20, l.Spec.Size.Value())
+}
+
+func TestDrainBlockers(t *testing.T) {
+	pv := func(name, driver, node string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Namespace: "mysql", Name: name},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+				Driver:           driver,
+				VolumeAttributes: map[string]string{"carina.storage.io/node": node},
+			}},
+		}}
+	}
+	pvs := []*corev1.PersistentVolume{
+		pv("log-mysql-0", "carina.storage.io", "node1"),
+		pv("data-mysql-0", "carina.storage.io", "node1"),
+		// 已迁移到其它节点
+		pv("backup-mysql-0", "carina.storage.io", "node2"),
+		pv("nfs-mysql-0", "nfs.csi.k8s.io", "node1"),
+		{},
+	}
+	a := assert.New(t)
+	a.Equal([]string{"data-mysql-0", "log-mysql-0"}, drainBlockers("node1", pvs))
+	a.Empty(drainBlockers("node3", pvs))
+}
@@ -0,0 +1,151 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:webhookVersions=v1,path=/volumeoperation/mutate,mutating=true,failurePolicy=fail,matchPolicy=equivalent,groups=carina.storage.io,resources=volumeoperations,verbs=create;update,versions=v1,sideEffects=none,name=volumeoperation-hook.carina.storage.io
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// operationVerbs rbac verb on logicvolumes a user needs for each operation, on top of creating the VolumeOperation
+var operationVerbs = map[carinav1.VolumeOperationType]string{
+	carinav1.VolumeOperationForceDelete: "force-delete",
+	carinav1.VolumeOperationWipe:        "wipe",
+	carinav1.VolumeOperationAdopt:       "adopt",
+}
+
+// volumeOperationAuthorizer admits VolumeOperations whose requester holds the verb of the
+// operation on the LogicVolume and records the requester in an annotation.
+type volumeOperationAuthorizer struct {
+	client  client.Client
+	decoder *admission.Decoder
+}
+
+// VolumeOperationAuthorizer creates a mutating webhook for VolumeOperations.
+func VolumeOperationAuthorizer(c client.Client, dec *admission.Decoder) http.Handler {
+	return &webhook.Admission{Handler: volumeOperationAuthorizer{c, dec}}
+}
+
+// Handle implements admission.Handler interface.
+func (a volumeOperationAuthorizer) Handle(ctx context.Context, req admission.Request) admission.Response {
+	op := &carinav1.VolumeOperation{}
+	if err := a.decoder.Decode(req, op); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// 操作创建后不可修改，避免先以有权限的操作通过校验再改成其他操作
+	if req.Operation == admissionv1.Update {
+		old := &carinav1.VolumeOperation{}
+		if err := a.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if !reflect.DeepEqual(old.Spec, op.Spec) || old.Annotations[utils.VolumeOperationRequester] != op.Annotations[utils.VolumeOperationRequester] {
+			return admission.Denied("the spec and requester of a VolumeOperation are immutable")
+		}
+		return admission.Allowed("")
+	}
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed("")
+	}
+
+	if problems := validateVolumeOperation(op); len(problems) > 0 {
+		return admission.Denied(fmt.Sprintf("volume operation %s is invalid: %s", op.Name, strings.Join(problems, "; ")))
+	}
+
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range req.UserInfo.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Group:    carinav1.GroupVersion.Group,
+				Resource: "logicvolumes",
+				Name:     op.Spec.LogicVolume,
+				Verb:     operationVerbs[op.Spec.Operation],
+			},
+			User:   req.UserInfo.Username,
+			Groups: req.UserInfo.Groups,
+			UID:    req.UserInfo.UID,
+			Extra:  extra,
+		},
+	}
+	if err := a.client.Create(ctx, sar); err != nil {
+		log.Errorf("subject access review of volume operation %s failed: %s", op.Name, err.Error())
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if !sar.Status.Allowed {
+		return admission.Denied(fmt.Sprintf("user %s is not allowed to %s logicvolume %s: %s",
+			req.UserInfo.Username, operationVerbs[op.Spec.Operation], op.Spec.LogicVolume, sar.Status.Reason))
+	}
+
+	if op.Annotations == nil {
+		op.Annotations = map[string]string{}
+	}
+	op.Annotations[utils.VolumeOperationRequester] = req.UserInfo.Username
+	log.Infof("volume operation %s %s of logicvolume %s requested by %s", op.Name, op.Spec.Operation, op.Spec.LogicVolume, req.UserInfo.Username)
+
+	marshaled, err := json.Marshal(op)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// validateVolumeOperation 检查操作参数，返回所有问题
+func validateVolumeOperation(op *carinav1.VolumeOperation) []string {
+	problems := []string{}
+	if _, ok := operationVerbs[op.Spec.Operation]; !ok {
+		problems = append(problems, fmt.Sprintf("unknown operation %q, should be one of ForceDelete, Wipe, Adopt", op.Spec.Operation))
+	}
+	if op.Spec.LogicVolume == "" {
+		problems = append(problems, "logicVolume is required")
+	}
+
+	if op.Spec.WipePolicy != "" {
+		if op.Spec.Operation != carinav1.VolumeOperationWipe {
+			problems = append(problems, "wipePolicy is only used by Wipe")
+		} else if op.Spec.WipePolicy != utils.WipePolicyDiscard && op.Spec.WipePolicy != utils.WipePolicyZero {
+			problems = append(problems, fmt.Sprintf("wipePolicy must be discard or zero, got %q", op.Spec.WipePolicy))
+		}
+	}
+
+	if op.Spec.Operation == carinav1.VolumeOperationAdopt {
+		if op.Spec.ClaimRef == nil || op.Spec.ClaimRef.Namespace == "" || op.Spec.ClaimRef.Name == "" {
+			problems = append(problems, "Adopt needs claimRef with namespace and name")
+		}
+	} else if op.Spec.ClaimRef != nil || op.Spec.StorageClassName != "" {
+		problems = append(problems, "claimRef and storageClassName are only used by Adopt")
+	}
+	return problems
+}
@@ -0,0 +1,155 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package autoresize
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carina-io/carina/utils"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// SampleInterval 节点采集文件系统使用率的间隔
+	SampleInterval = time.Minute
+	// DefaultStep 未设置autoresize-step时每次扩容请求大小的10%
+	DefaultStep = "10%"
+
+	gib = int64(1) << 30
+)
+
+// Policy is the automatic expansion of a pvc set by its annotations
+type Policy struct {
+	// Threshold is the filesystem usage percent triggering an expansion
+	Threshold int32
+	// Step is the absolute size an expansion adds, unless StepPercent is set
+	Step resource.Quantity
+	// StepPercent is the percent of the current request an expansion adds
+	StepPercent int64
+	// MaxSize bounds the request of the pvc
+	MaxSize resource.Quantity
+}
+
+// ParsePolicy returns the autoresize policy of a pvc, nil if the pvc has no threshold annotation
+func ParsePolicy(annotations map[string]string) (*Policy, error) {
+	value, ok := annotations[utils.VolumeAutoresizeThreshold]
+	if !ok {
+		return nil, nil
+	}
+	threshold, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || threshold < 1 || threshold > 99 {
+		return nil, fmt.Errorf("%s must be a percent between 1 and 99, got %q", utils.VolumeAutoresizeThreshold, value)
+	}
+	policy := &Policy{Threshold: int32(threshold)}
+
+	value, ok = annotations[utils.VolumeAutoresizeMaxSize]
+	if !ok {
+		return nil, fmt.Errorf("%s is required with %s", utils.VolumeAutoresizeMaxSize, utils.VolumeAutoresizeThreshold)
+	}
+	if policy.MaxSize, err = resource.ParseQuantity(strings.TrimSpace(value)); err != nil || policy.MaxSize.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid %s %q", utils.VolumeAutoresizeMaxSize, value)
+	}
+
+	step := strings.TrimSpace(annotations[utils.VolumeAutoresizeStep])
+	if step == "" {
+		step = DefaultStep
+	}
+	if strings.HasSuffix(step, "%") {
+		percent, err := strconv.ParseInt(strings.TrimSuffix(step, "%"), 10, 64)
+		if err != nil || percent < 1 || percent > 1000 {
+			return nil, fmt.Errorf("%s must be a size or a percent between 1%% and 1000%%, got %q", utils.VolumeAutoresizeStep, step)
+		}
+		policy.StepPercent = percent
+		return policy, nil
+	}
+	if policy.Step, err = resource.ParseQuantity(step); err != nil || policy.Step.Sign() <= 0 {
+		return nil, fmt.Errorf("%s must be a size or a percent between 1%% and 1000%%, got %q", utils.VolumeAutoresizeStep, step)
+	}
+	return policy, nil
+}
+
+// NextSize returns the request a pvc is expanded to at the given filesystem usage, false if it
+// stays. The new request is rounded up to GiB and capped at the max size.
+func NextSize(policy *Policy, request resource.Quantity, usage int32) (resource.Quantity, bool) {
+	if usage < policy.Threshold || request.Cmp(policy.MaxSize) >= 0 {
+		return request, false
+	}
+	current := request.Value()
+	add := policy.Step.Value()
+	if policy.StepPercent > 0 {
+		add = current / 100 * policy.StepPercent
+		if add == 0 {
+			add = 1
+		}
+	}
+	size := (current + add + gib - 1) / gib * gib
+	if size > policy.MaxSize.Value() {
+		size = policy.MaxSize.Value()
+	}
+	if size <= current {
+		return request, false
+	}
+	return *resource.NewQuantity(size, resource.BinarySI), true
+}
+
+// UsagePercent returns the used percent of a filesystem from statfs, rounded up like df
+func UsagePercent(blocks, free, available uint64) int32 {
+	used := blocks - free
+	if used+available == 0 {
+		return 0
+	}
+	return int32((used*100 + used + available - 1) / (used + available))
+}
+
+// ParseMountInfo returns the first mount point of every device in /proc/self/mountinfo keyed by "major:minor"
+func ParseMountInfo(content string) map[string]string {
+	result := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		// id parent major:minor root mountpoint options ... - fstype source superoptions
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		if _, ok := result[fields[2]]; ok {
+			continue
+		}
+		result[fields[2]] = unescapeMountPath(fields[4])
+	}
+	return result
+}
+
+// unescapeMountPath mountinfo中空格等字符被转义为\040形式的八进制
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,103 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package autoresize
+
+import (
+	"testing"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestParsePolicy(t *testing.T) {
+	a := assert.New(t)
+	policy, err := ParsePolicy(map[string]string{})
+	a.NoError(err)
+	a.Nil(policy)
+
+	policy, err = ParsePolicy(map[string]string{utils.VolumeAutoresizeThreshold: "80", utils.VolumeAutoresizeMaxSize: "100Gi"})
+	a.NoError(err)
+	a.Equal(int32(80), policy.Threshold)
+	a.Equal(int64(10), policy.StepPercent)
+	a.Equal("100Gi", policy.MaxSize.String())
+
+	policy, err = ParsePolicy(map[string]string{utils.VolumeAutoresizeThreshold: "90", utils.VolumeAutoresizeMaxSize: "1Ti", utils.VolumeAutoresizeStep: "5Gi"})
+	a.NoError(err)
+	a.Equal(int64(0), policy.StepPercent)
+	a.Equal("5Gi", policy.Step.String())
+
+	for _, annotations := range []map[string]string{
+		{utils.VolumeAutoresizeThreshold: "80"},
+		{utils.VolumeAutoresizeThreshold: "100", utils.VolumeAutoresizeMaxSize: "100Gi"},
+		{utils.VolumeAutoresizeThreshold: "eighty", utils.VolumeAutoresizeMaxSize: "100Gi"},
+		{utils.VolumeAutoresizeThreshold: "80", utils.VolumeAutoresizeMaxSize: "lots"},
+		{utils.VolumeAutoresizeThreshold: "80", utils.VolumeAutoresizeMaxSize: "100Gi", utils.VolumeAutoresizeStep: "0%"},
+		{utils.VolumeAutoresizeThreshold: "80", utils.VolumeAutoresizeMaxSize: "100Gi", utils.VolumeAutoresizeStep: "-1Gi"},
+	} {
+		_, err := ParsePolicy(annotations)
+		a.Error(err, annotations)
+	}
+}
+
+func TestNextSize(t *testing.T) {
+	percent := &Policy{Threshold: 80, StepPercent: 20, MaxSize: resource.MustParse("25Gi")}
+	absolute := &Policy{Threshold: 80, Step: resource.MustParse("500Mi"), MaxSize: resource.MustParse("1Ti")}
+	table := []struct {
+		policy  *Policy
+		request string
+		usage   int32
+		size    string
+		expand  bool
+	}{
+		{policy: percent, request: "10Gi", usage: 79, size: "10Gi"},
+		{policy: percent, request: "10Gi", usage: 80, size: "12Gi", expand: true},
+		// 向上取整到GiB
+		{policy: percent, request: "11Gi", usage: 95, size: "14Gi", expand: true},
+		{policy: percent, request: "24Gi", usage: 95, size: "25Gi", expand: true},
+		{policy: percent, request: "25Gi", usage: 99, size: "25Gi"},
+		{policy: absolute, request: "10Gi", usage: 85, size: "11Gi", expand: true},
+	}
+	for _, e := range table {
+		size, expand := NextSize(e.policy, resource.MustParse(e.request), e.usage)
+		assert.Equal(t, e.expand, expand, e.request)
+		want := resource.MustParse(e.size)
+		assert.Equal(t, want.Value(), size.Value(), e.request)
+	}
+}
+
+func TestUsagePercent(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(int32(0), UsagePercent(0, 0, 0))
+	a.Equal(int32(50), UsagePercent(1000, 500, 500))
+	// reserved blocks of ext4 count as neither used nor available, like in df
+	a.Equal(int32(53), UsagePercent(1000, 500, 450))
+	a.Equal(int32(100), UsagePercent(1000, 50, 0))
+}
+
+func TestParseMountInfo(t *testing.T) {
+	content := `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+1203 22 253:4 / /var/lib/kubelet/pods/7c1d/volumes/kubernetes.io~csi/pvc-1/mount rw,relatime shared:501 - xfs /dev/mapper/carina--vg--ssd-volume--pvc--1 rw
+1250 22 253:4 / /var/lib/kubelet/pods/8e2f/volumes/kubernetes.io~csi/pvc-1/mount rw,relatime shared:502 - xfs /dev/mapper/carina--vg--ssd-volume--pvc--1 rw
+1301 22 253:7 / /mnt/with\040space rw - ext4 /dev/mapper/x rw
+`
+	assert.Equal(t, map[string]string{
+		"8:1":   "/",
+		"253:4": "/var/lib/kubelet/pods/7c1d/volumes/kubernetes.io~csi/pvc-1/mount",
+		"253:7": "/mnt/with space",
+	}, ParseMountInfo(content))
+}
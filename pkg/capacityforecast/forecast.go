@@ -0,0 +1,150 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package capacityforecast
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// SampleInterval carina-controller记录各磁盘组用量的间隔
+	SampleInterval = 10 * time.Minute
+	// Window 预测使用的历史长度，更早的样本丢弃
+	Window = 7 * 24 * time.Hour
+	// MinSpan 样本跨度不足时不做预测，避免刚启动时的抖动
+	MinSpan    = time.Hour
+	minSamples = 3
+	// maxHorizon 十年以上写满视为不增长
+	maxHorizon = 10 * 365 * 24 * time.Hour
+)
+
+// Sample is the usage of a disk group at a time
+type Sample struct {
+	Time     time.Time
+	Used     int64
+	Capacity int64
+}
+
+// Forecast of a disk group, Full is zero when the group is not filling up
+type Forecast struct {
+	// Growth bytes per day, negative when the group is shrinking
+	Growth float64
+	Used   int64
+	// Capacity of the last sample
+	Capacity int64
+	// Full is when the group runs out of space at the current growth
+	Full time.Time
+}
+
+// DaysUntilFull returns the days left from now, ok is false when the group is not filling up
+func (f Forecast) DaysUntilFull(now time.Time) (float64, bool) {
+	if f.Full.IsZero() {
+		return 0, false
+	}
+	days := f.Full.Sub(now).Hours() / 24
+	if days < 0 {
+		days = 0
+	}
+	return days, true
+}
+
+// History keeps the samples of the disk groups within Window, keyed by node/group
+type History struct {
+	mu      sync.Mutex
+	samples map[string][]Sample
+}
+
+// NewHistory returns an empty History
+func NewHistory() *History {
+	return &History{samples: map[string][]Sample{}}
+}
+
+// Add records a sample and drops the samples that left the window. A change of the capacity,
+// e.g. a disk added to the group, restarts the history as the old trend no longer applies.
+func (h *History) Add(key string, s Sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := h.samples[key]
+	if n := len(samples); n > 0 && samples[n-1].Capacity != s.Capacity {
+		samples = nil
+	}
+	samples = append(samples, s)
+	i := sort.Search(len(samples), func(i int) bool { return s.Time.Sub(samples[i].Time) <= Window })
+	h.samples[key] = append([]Sample{}, samples[i:]...)
+}
+
+// Retain forgets the groups not in keys, e.g. of deleted nodes
+func (h *History) Retain(keys map[string]bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key := range h.samples {
+		if !keys[key] {
+			delete(h.samples, key)
+		}
+	}
+}
+
+// Forecast returns the forecast of the group, ok is false while the history is too short
+func (h *History) Forecast(key string) (Forecast, bool) {
+	h.mu.Lock()
+	samples := append([]Sample{}, h.samples[key]...)
+	h.mu.Unlock()
+	return Predict(samples)
+}
+
+// Predict 对用量做最小二乘线性拟合，按增长速度推算磁盘组写满的时间
+// The samples must be ordered by time. The history has to span MinSpan with at least three samples.
+func Predict(samples []Sample) (Forecast, bool) {
+	n := len(samples)
+	if n < minSamples || samples[n-1].Time.Sub(samples[0].Time) < MinSpan {
+		return Forecast{}, false
+	}
+	last := samples[n-1]
+	// x以小时为单位，相对第一个样本，避免大数相乘丢失精度
+	var sumX, sumY, sumXY, sumXX float64
+	for _, s := range samples {
+		x := s.Time.Sub(samples[0].Time).Hours()
+		y := float64(s.Used)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	fn := float64(n)
+	denominator := fn*sumXX - sumX*sumX
+	if denominator == 0 {
+		return Forecast{}, false
+	}
+	slope := (fn*sumXY - sumX*sumY) / denominator
+
+	f := Forecast{Growth: slope * 24, Used: last.Used, Capacity: last.Capacity}
+	if slope <= 0 {
+		return f, true
+	}
+	free := float64(last.Capacity - last.Used)
+	if free < 0 {
+		free = 0
+	}
+	hours := free / slope
+	if hours*float64(time.Hour) > float64(maxHorizon) {
+		return f, true
+	}
+	f.Full = last.Time.Add(time.Duration(hours * float64(time.Hour)))
+	return f, true
+}
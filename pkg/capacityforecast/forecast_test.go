@@ -0,0 +1,77 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package capacityforecast
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const gi = int64(1 << 30)
+
+func TestPredict(t *testing.T) {
+	a := assert.New(t)
+	start := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	// 每天增长10Gi，剩余60Gi
+	samples := []Sample{}
+	for h := 0; h <= 48; h += 6 {
+		samples = append(samples, Sample{Time: start.Add(time.Duration(h) * time.Hour), Used: 20*gi + int64(h)*10*gi/24, Capacity: 100 * gi})
+	}
+	f, ok := Predict(samples)
+	a.True(ok)
+	a.InDelta(float64(10*gi), f.Growth, 1)
+	days, ok := f.DaysUntilFull(start.Add(48 * time.Hour))
+	a.True(ok)
+	a.InDelta(6, days, 0.01)
+
+	// 用量不变或下降
+	flat := []Sample{{start, 50 * gi, 100 * gi}, {start.Add(time.Hour), 50 * gi, 100 * gi}, {start.Add(2 * time.Hour), 40 * gi, 100 * gi}}
+	f, ok = Predict(flat)
+	a.True(ok)
+	_, ok = f.DaysUntilFull(start)
+	a.False(ok)
+
+	// 样本太少或跨度太短
+	_, ok = Predict(samples[:2])
+	a.False(ok)
+	_, ok = Predict([]Sample{{start, 1, 10}, {start.Add(time.Minute), 2, 10}, {start.Add(2 * time.Minute), 3, 10}})
+	a.False(ok)
+}
+
+func TestHistory(t *testing.T) {
+	a := assert.New(t)
+	h := NewHistory()
+	start := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	for d := 0; d < 10; d++ {
+		h.Add("n1/carina-vg-hdd", Sample{Time: start.Add(time.Duration(d) * 24 * time.Hour), Used: int64(d) * gi, Capacity: 100 * gi})
+	}
+	a.Len(h.samples["n1/carina-vg-hdd"], 8)
+	f, ok := h.Forecast("n1/carina-vg-hdd")
+	a.True(ok)
+	a.InDelta(float64(gi), f.Growth, 1)
+
+	// 扩容后重新开始统计
+	h.Add("n1/carina-vg-hdd", Sample{Time: start.Add(10 * 24 * time.Hour), Used: 10 * gi, Capacity: 200 * gi})
+	_, ok = h.Forecast("n1/carina-vg-hdd")
+	a.False(ok)
+
+	h.Add("n2/carina-vg-ssd", Sample{Time: start, Used: gi, Capacity: 10 * gi})
+	h.Retain(map[string]bool{"n2/carina-vg-ssd": true})
+	a.Len(h.samples, 1)
+}
@@ -0,0 +1,90 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package capacityforecast
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/utils"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HistoryInterval NodeStorageResource中容量历史的采样间隔
+const HistoryInterval = time.Hour
+
+// GroupCapacity 按磁盘组汇总NodeStorageResource的capacity和allocatable，单位为字节
+// The quantities of the status are in Gi. The raw disk groups are keyed by group/disk and summed up.
+func GroupCapacity(capacity, allocatable map[string]resource.Quantity) map[string]carinav1beta1.CapacitySample {
+	groups := map[string]carinav1beta1.CapacitySample{}
+	for key, c := range capacity {
+		if !strings.HasPrefix(key, utils.DeviceCapacityKeyPrefix) {
+			continue
+		}
+		group := strings.SplitN(strings.TrimPrefix(key, utils.DeviceCapacityKeyPrefix), "/", 2)[0]
+		total := uint64(c.Value()) << 30
+		free := uint64(0)
+		if a, ok := allocatable[key]; ok && a.Value() > 0 {
+			free = uint64(a.Value()) << 30
+		}
+		if free > total {
+			free = total
+		}
+		s := groups[group]
+		s.Allocatable += free
+		s.Used += total - free
+		groups[group] = s
+	}
+	return groups
+}
+
+// RecordHistory 每个HistoryInterval为各磁盘组追加一个样本，每组最多保留max个
+// Groups missing from current are dropped from the history, max 0 drops the whole history.
+// It returns the history unchanged while the last sample is younger than HistoryInterval.
+func RecordHistory(history []carinav1beta1.DeviceGroupHistory, current map[string]carinav1beta1.CapacitySample, now time.Time, max int) []carinav1beta1.DeviceGroupHistory {
+	if max <= 0 || len(current) == 0 {
+		return nil
+	}
+	previous := map[string][]carinav1beta1.CapacitySample{}
+	for _, h := range history {
+		previous[h.DeviceGroup] = h.Samples
+	}
+
+	groups := make([]string, 0, len(current))
+	for group := range current {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	result := []carinav1beta1.DeviceGroupHistory{}
+	for _, group := range groups {
+		samples := previous[group]
+		if n := len(samples); n == 0 || now.Sub(samples[n-1].Time.Time) >= HistoryInterval {
+			s := current[group]
+			s.Time = metav1.NewTime(now)
+			samples = append(append([]carinav1beta1.CapacitySample{}, samples...), s)
+		}
+		if len(samples) > max {
+			samples = samples[len(samples)-max:]
+		}
+		result = append(result, carinav1beta1.DeviceGroupHistory{DeviceGroup: group, Samples: samples})
+	}
+	return result
+}
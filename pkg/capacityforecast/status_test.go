@@ -0,0 +1,84 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package capacityforecast
+
+import (
+	"testing"
+	"time"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestGroupCapacity(t *testing.T) {
+	q := func(gb int64) resource.Quantity { return *resource.NewQuantity(gb, resource.BinarySI) }
+	groups := GroupCapacity(map[string]resource.Quantity{
+		"carina.storage.io/carina-vg-ssd":       q(100),
+		"carina.storage.io/carina-raw-hdd/sdb":  q(50),
+		"carina.storage.io/carina-raw-hdd/sdc":  q(50),
+		"carina.storage.io/carina-vg-tainted":   q(10),
+		"kubernetes.io/unrelated-resource-name": q(1),
+	}, map[string]resource.Quantity{
+		"carina.storage.io/carina-vg-ssd":      q(40),
+		"carina.storage.io/carina-raw-hdd/sdb": q(50),
+		"carina.storage.io/carina-raw-hdd/sdc": q(20),
+		"carina.storage.io/carina-vg-tainted":  q(0),
+	})
+	assert.Equal(t, map[string]carinav1beta1.CapacitySample{
+		"carina-vg-ssd":     {Allocatable: uint64(40 * gi), Used: uint64(60 * gi)},
+		"carina-raw-hdd":    {Allocatable: uint64(70 * gi), Used: uint64(30 * gi)},
+		"carina-vg-tainted": {Used: uint64(10 * gi)},
+	}, groups)
+}
+
+func TestRecordHistory(t *testing.T) {
+	a := assert.New(t)
+	start := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	current := func(used uint64) map[string]carinav1beta1.CapacitySample {
+		return map[string]carinav1beta1.CapacitySample{
+			"carina-vg-ssd": {Allocatable: 100 - used, Used: used},
+			"carina-vg-hdd": {Allocatable: 100},
+		}
+	}
+
+	history := RecordHistory(nil, current(10), start, 3)
+	a.Len(history, 2)
+	a.Equal("carina-vg-hdd", history[0].DeviceGroup)
+	a.Equal("carina-vg-ssd", history[1].DeviceGroup)
+	a.Len(history[1].Samples, 1)
+
+	// 采样间隔内不追加
+	history = RecordHistory(history, current(20), start.Add(30*time.Minute), 3)
+	a.Len(history[1].Samples, 1)
+	a.Equal(uint64(10), history[1].Samples[0].Used)
+
+	for h := 1; h <= 4; h++ {
+		history = RecordHistory(history, current(uint64(10+h)), start.Add(time.Duration(h)*time.Hour), 3)
+	}
+	a.Len(history[1].Samples, 3)
+	a.Equal(uint64(12), history[1].Samples[0].Used)
+	a.Equal(uint64(14), history[1].Samples[2].Used)
+	a.Equal(start.Add(4*time.Hour), history[1].Samples[2].Time.Time)
+
+	// 删除的磁盘组不再保留历史
+	history = RecordHistory(history, map[string]carinav1beta1.CapacitySample{"carina-vg-ssd": {}}, start.Add(5*time.Hour), 3)
+	a.Len(history, 1)
+	a.Equal("carina-vg-ssd", history[0].DeviceGroup)
+
+	a.Nil(RecordHistory(history, current(0), start.Add(6*time.Hour), 0))
+}
@@ -17,19 +17,26 @@
 package configuration
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
 	"github.com/fsnotify/fsnotify"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // 配置文件路径
@@ -43,20 +50,40 @@ var TestAssistDiskSelector []string
 var configModifyNotice []chan<- struct{}
 var GlobalConfig *viper.Viper
 var DiskConfig Disk
-var opt = viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
-	mapstructure.StringToTimeDurationHookFunc(),
-	mapstructure.StringToSliceHookFunc(","),
-	// Custom Decode Hook Function
-	func(rf reflect.Kind, rt reflect.Kind, data interface{}) (interface{}, error) {
-		if rf != reflect.Map || rt != reflect.Struct {
+
+// applied 当前生效的配置版本，校验失败的配置不会生效
+var (
+	appliedMu sync.RWMutex
+	applied   AppliedConfig
+)
+
+// decodeOption 解析diskSelector到disk
+func decodeOption(disk *Disk) viper.DecoderConfigOption {
+	return viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		// Custom Decode Hook Function
+		func(rf reflect.Kind, rt reflect.Kind, data interface{}) (interface{}, error) {
+			if rf != reflect.Map || rt != reflect.Struct {
+				return data, nil
+			}
+			mapstructure.Decode(data.(map[string]interface{}), disk)
+			disk.DiskSelectors = []DiskSelectorItem{}
+			mapstructure.Decode(data.(map[string]interface{})["diskselector"], &disk.DiskSelectors)
 			return data, nil
-		}
-		mapstructure.Decode(data.(map[string]interface{}), &DiskConfig)
-		DiskConfig.DiskSelectors = []DiskSelectorItem{}
-		mapstructure.Decode(data.(map[string]interface{})["diskselector"], &DiskConfig.DiskSelectors)
-		return data, nil
-	},
-))
+		},
+	))
+}
+
+// AppliedConfig is the revision of the config file a component runs with
+type AppliedConfig struct {
+	// Revision is the sha256 prefix of the applied config file
+	Revision  string    `json:"revision"`
+	AppliedAt time.Time `json:"appliedAt"`
+	// RejectedRevision and Error are set while the latest config file fails validation, the previous revision stays applied
+	RejectedRevision string `json:"rejectedRevision,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
 
 // ConfigProvider 提供给其他应用获取服务数据
 // 这个configMap理论上应该由Node Server更新，为了实现简单改为有Control Server更新，遍历所有Node信息更新configmap
@@ -71,6 +98,57 @@ type DiskSelectorItem struct {
 	Re        []string `json:"re"`
 	Policy    string   `json:"policy"`
 	NodeLabel string   `json:"nodeLabel"`
+	// Spare 热备盘的正则，匹配的空磁盘不加入磁盘组，磁盘组丢失pv时用来替换
+	Spare []string `json:"spare"`
+	// Wwn、Serial 按wwn和序列号精确匹配磁盘，/dev/sdX重启后可能变化，wwn和序列号不会
+	Wwn    []string `json:"wwn"`
+	Serial []string `json:"serial"`
+	// Model 磁盘型号的正则
+	Model []string `json:"model"`
+	// MinSize、MaxSize 磁盘容量范围，如100Gi，为空时不限制
+	MinSize string `json:"minSize"`
+	MaxSize string `json:"maxSize"`
+	// Rotational true只匹配机械盘，false只匹配固态盘，为空时不限制
+	Rotational *bool `json:"rotational"`
+	// Deny 不加入磁盘组的设备名、wwn或序列号，优先于其他条件
+	Deny []string `json:"deny"`
+}
+
+// Selects reports whether the disk group selects any disk, a group without re, wwn, serial
+// and model only takes its loop devices
+func (d DiskSelectorItem) Selects() bool {
+	return len(d.Re) > 0 || len(d.Wwn) > 0 || len(d.Serial) > 0 || len(d.Model) > 0
+}
+
+// Attributes reports whether the disk group matches on anything besides the device name
+func (d DiskSelectorItem) Attributes() bool {
+	return len(d.Wwn) > 0 || len(d.Serial) > 0 || len(d.Model) > 0 || d.MinSize != "" || d.MaxSize != "" || d.Rotational != nil
+}
+
+// SpareVolumeItem 磁盘组中预先创建并格式化的备用卷
+type SpareVolumeItem struct {
+	DeviceGroup string `json:"deviceGroup"`
+	Size        string `json:"size"`
+	Count       int    `json:"count"`
+	FsType      string `json:"fsType"`
+}
+
+// ReservedCapacityItem 磁盘组中预留的容量，用于快照、thin pool元数据和系统余量，不计入可分配容量
+type ReservedCapacityItem struct {
+	// DeviceGroup 为空时对所有磁盘组生效
+	DeviceGroup string `json:"deviceGroup"`
+	// NodeLabel 与diskSelector相同，只对带有该标签的节点生效，为空时对所有节点生效
+	NodeLabel string `json:"nodeLabel"`
+	Size      string `json:"size"`
+	Percent   int    `json:"percent"`
+}
+
+// LoopDeviceItem 磁盘组中由稀疏文件挂载的loop设备，用于没有空闲磁盘的开发和测试集群
+type LoopDeviceItem struct {
+	// DeviceGroup 为diskSelector中lvm磁盘组的名称，只在该磁盘组生效的节点上创建
+	DeviceGroup string `json:"deviceGroup"`
+	Size        string `json:"size"`
+	Count       int    `json:"count"`
 }
 
 type Disk struct {
@@ -82,51 +160,156 @@ type Disk struct {
 func init() {
 	log.Info("Loading global configuration ...")
 	GlobalConfig = initConfig()
-	Validate(DiskConfig)
 	go dynamicConfig()
 
 }
 
+func configFile() string {
+	return filepath.Join(configPath, "config.json")
+}
+
 func initConfig() *viper.Viper {
-	GlobalConfig := viper.New()
-	GlobalConfig.AddConfigPath(configPath)
-	GlobalConfig.SetConfigName("config")
-	GlobalConfig.SetConfigType("json")
-	err := GlobalConfig.ReadInConfig()
+	data, err := os.ReadFile(configFile())
 	if err != nil {
 		log.Error("Failed to get the configuration", err)
 		os.Exit(-1)
 	}
-	err = GlobalConfig.Unmarshal(&DiskConfig, opt)
+	v, disk, err := decodeConfig(data)
 	if err != nil {
-		log.Error("Failed to unmarshal the configuration")
+		log.Error("Failed to unmarshal the configuration ", err)
 		os.Exit(-1)
 	}
+	DiskConfig = disk
+	applied = AppliedConfig{Revision: configRevision(data), AppliedAt: time.Now()}
+	if err := ValidateConfig(v, disk); err != nil {
+		log.Errorf("Failed to validate the configuration: %s", err)
+		applied.Error = err.Error()
+	}
+	return v
+}
 
-	return GlobalConfig
+// configRevision 配置文件内容的摘要，所有组件读取同一个ConfigMap，版本相同即配置相同
+func configRevision(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// decodeConfig 从配置文件内容解析配置，不修改当前生效的配置
+func decodeConfig(data []byte) (*viper.Viper, Disk, error) {
+	v := viper.New()
+	v.SetConfigType("json")
+	disk := Disk{}
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, disk, err
+	}
+	if err := v.Unmarshal(&disk, decodeOption(&disk)); err != nil {
+		return nil, disk, err
+	}
+	return v, disk, nil
+}
+
+// ValidateConfig 校验磁盘选择器、调度策略和日志级别，失败的配置不会生效
+func ValidateConfig(v *viper.Viper, disk Disk) error {
+	if err := Validate(disk); err != nil {
+		return err
+	}
+	if disk.DiskScanInterval < 0 {
+		return fmt.Errorf("diskScanInterval should not be negative: %d", disk.DiskScanInterval)
+	}
+	if level := v.GetString("logLevel"); level != "" && !utils.ContainsString([]string{"debug", "info", "warn", "error"}, strings.ToLower(level)) {
+		return fmt.Errorf("logLevel should be debug, info, warn or error: %s", level)
+	}
+	return nil
 }
 
 func dynamicConfig() {
-	GlobalConfig.WatchConfig()
-	GlobalConfig.OnConfigChange(func(event fsnotify.Event) {
+	// 单独的viper监听文件，文件变化时不会直接替换当前生效的配置
+	watcher := viper.New()
+	watcher.SetConfigFile(configFile())
+	watcher.SetConfigType("json")
+	if err := watcher.ReadInConfig(); err != nil {
+		log.Errorf("Failed to watch the configuration: %s", err)
+		return
+	}
+	watcher.OnConfigChange(func(event fsnotify.Event) {
 		log.Infof("Detect config change: %s", event.String())
-		for _, c := range configModifyNotice {
-			log.Info("generates the configuration change event")
-			err := GlobalConfig.Unmarshal(&DiskConfig, opt)
-			if err != nil {
-				log.Errorf("Failed to unmarshal the configuration: %s", err)
-				os.Exit(-1)
-			}
-			err = Validate(DiskConfig)
-			if err != nil {
-				log.Errorf("Failed to validate the configuration: %s", err)
-				os.Exit(-1)
-			}
-			c <- struct{}{}
+		data, err := os.ReadFile(configFile())
+		if err != nil {
+			log.Errorf("Failed to read the configuration: %s", err)
+			return
 		}
+		Reload(data)
 	})
+	watcher.WatchConfig()
+}
+
+// Reload 校验并应用新的配置文件内容，校验失败时保留原配置并记录错误
+// ConfigMap的更新由kubelet同步到挂载的文件，组件无需重启
+func Reload(data []byte) {
+	revision := configRevision(data)
+	appliedMu.Lock()
+	switch revision {
+	case applied.RejectedRevision:
+		appliedMu.Unlock()
+		return
+	case applied.Revision:
+		// 改回了当前生效的配置
+		if applied.RejectedRevision != "" {
+			applied.RejectedRevision = ""
+			applied.Error = ""
+		}
+		appliedMu.Unlock()
+		return
+	}
+	appliedMu.Unlock()
+
+	v, disk, err := decodeConfig(data)
+	if err == nil {
+		err = ValidateConfig(v, disk)
+	}
+	if err != nil {
+		log.Errorf("Reject the configuration revision %s: %s", revision, err)
+		appliedMu.Lock()
+		applied.RejectedRevision = revision
+		applied.Error = err.Error()
+		appliedMu.Unlock()
+		notifyListeners()
+		return
+	}
+
+	oldLevel := GlobalConfig.GetString("logLevel")
+	GlobalConfig = v
+	DiskConfig = disk
+	if level := v.GetString("logLevel"); level != "" && !strings.EqualFold(level, oldLevel) {
+		if err := log.SetLevel(level); err != nil {
+			log.Warnf("Failed to set log level %s: %s", level, err)
+		}
+	}
+	appliedMu.Lock()
+	applied = AppliedConfig{Revision: revision, AppliedAt: time.Now()}
+	appliedMu.Unlock()
+	log.Infof("Applied the configuration revision %s", revision)
+	notifyListeners()
+}
+
+func notifyListeners() {
+	for _, c := range configModifyNotice {
+		// 已有未处理的通知时不必重复通知
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Applied returns the revision of the config the component runs with and the latest rejected one
+func Applied() AppliedConfig {
+	appliedMu.RLock()
+	defer appliedMu.RUnlock()
+	return applied
 }
 
+// RegisterListenerChan c is notified when a config revision is applied or rejected
 func RegisterListenerChan(c chan<- struct{}) {
 	configModifyNotice = append(configModifyNotice, c)
 }
@@ -170,6 +353,364 @@ func SchedulerStrategy() string {
 	return schedulerStrategy
 }
 
+// OperationWorkers 节点上同时执行的存储操作数量，默认4
+func OperationWorkers() int {
+	workers := GlobalConfig.GetInt("operationWorkers")
+	if workers <= 0 {
+		workers = 4
+	}
+	return workers
+}
+
+// ReclaimReleasedVolume 是否回收管理员标记过的Released状态pv，默认关闭
+func ReclaimReleasedVolume() bool {
+	return GlobalConfig.GetBool("reclaimReleasedVolume")
+}
+
+// LvmFilter 是否管理carina-node及主机lvm.conf中的global_filter，默认关闭
+func LvmFilter() bool {
+	return GlobalConfig.GetBool("lvmFilter")
+}
+
+// DiskBenchmark 磁盘加入vg前是否测试其性能，结果用于调度打分，默认关闭
+func DiskBenchmark() bool {
+	return GlobalConfig.GetBool("diskBenchmark")
+}
+
+// DiskApproval 磁盘加入vg前是否需要在NodeStorageResource上批准，开启时只报告待加入的磁盘，默认关闭
+func DiskApproval() bool {
+	return GlobalConfig.GetBool("diskApproval")
+}
+
+// FstrimInterval 对开启fstrim的卷执行fstrim的间隔(秒)，0表示关闭，默认一周，最小3600s
+func FstrimInterval() int64 {
+	if !GlobalConfig.IsSet("fstrimInterval") {
+		return 7 * 24 * 3600
+	}
+	interval := GlobalConfig.GetInt64("fstrimInterval")
+	if interval <= 0 {
+		return 0
+	}
+	if interval < 3600 {
+		interval = 3600
+	}
+	return interval
+}
+
+// UsageThreshold vg或thin pool使用率达到该百分比时标记污点，vg不再调度新卷，0表示关闭，默认关闭
+func UsageThreshold() int {
+	threshold := GlobalConfig.GetInt("usageThreshold")
+	if threshold < 0 || threshold > 100 {
+		threshold = 0
+	}
+	return threshold
+}
+
+// UsagePodCondition 卷的thin pool超过usageThreshold时是否给使用它的pod添加condition，默认关闭
+func UsagePodCondition() bool {
+	return GlobalConfig.GetBool("usagePodCondition")
+}
+
+// Autoresize 节点是否采集已挂载卷的文件系统使用率，控制器是否按pvc注解自动扩容，默认关闭
+func Autoresize() bool {
+	return GlobalConfig.GetBool("autoresize")
+}
+
+// WipePolicy 回收卷时数据擦除方式none/discard/zero，默认none
+func WipePolicy() string {
+	wipePolicy := strings.ToLower(GlobalConfig.GetString("wipePolicy"))
+	if !utils.ContainsString([]string{utils.WipePolicyNone, utils.WipePolicyDiscard, utils.WipePolicyZero}, wipePolicy) {
+		wipePolicy = utils.WipePolicyNone
+	}
+	return wipePolicy
+}
+
+// RebalanceHighWatermark pv使用率达到该百分比时触发vg内再平衡，0表示关闭，默认关闭
+func RebalanceHighWatermark() int {
+	watermark := GlobalConfig.GetInt("rebalanceHighWatermark")
+	if watermark < 0 || watermark > 100 {
+		watermark = 0
+	}
+	return watermark
+}
+
+// RebalanceLowWatermark 使用率不超过该百分比的pv才会接收迁移的数据，默认30
+func RebalanceLowWatermark() int {
+	watermark := GlobalConfig.GetInt("rebalanceLowWatermark")
+	if watermark <= 0 || watermark >= 100 {
+		watermark = 30
+	}
+	return watermark
+}
+
+// FragmentationThreshold vg空闲空间碎片达到该百分比时在NodeStorageResource中报告，0表示关闭，默认关闭
+func FragmentationThreshold() int {
+	threshold := GlobalConfig.GetInt("fragmentationThreshold")
+	if threshold < 0 || threshold > 100 {
+		threshold = 0
+	}
+	return threshold
+}
+
+// CapacityHistorySamples NodeStorageResource中每个磁盘组保留的每小时容量样本数，0表示不记录，默认48
+func CapacityHistorySamples() int {
+	if !GlobalConfig.IsSet("capacityHistorySamples") {
+		return 48
+	}
+	samples := GlobalConfig.GetInt("capacityHistorySamples")
+	if samples < 0 {
+		samples = 0
+	}
+	return samples
+}
+
+// CapacityForecastDays 磁盘组按当前增长速度在该天数内写满时在NodeStorageResource中告警，0表示只导出指标，默认7天
+func CapacityForecastDays() int {
+	if !GlobalConfig.IsSet("capacityForecastDays") {
+		return 7
+	}
+	days := GlobalConfig.GetInt("capacityForecastDays")
+	if days < 0 {
+		days = 0
+	}
+	return days
+}
+
+// OrphanGracePeriod 孤儿卷及没有PV的LogicVolume保留多久后回收，默认1小时，0表示发现即回收
+func OrphanGracePeriod() time.Duration {
+	if !GlobalConfig.IsSet("orphanGracePeriod") {
+		return time.Hour
+	}
+	seconds := GlobalConfig.GetInt64("orphanGracePeriod")
+	if seconds < 0 {
+		seconds = 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// OrphanDryRun 只报告孤儿卷，不回收，默认关闭
+func OrphanDryRun() bool {
+	return GlobalConfig.GetBool("orphanDryRun")
+}
+
+// Defragment 是否在数据迁移窗口内执行碎片整理建议，默认关闭
+func Defragment() bool {
+	return GlobalConfig.GetBool("defragment")
+}
+
+// EncryptedDeviceGroups 集群策略要求加密的磁盘组，这些磁盘组上的卷总是luks加密，与storageclass参数无关
+func EncryptedDeviceGroups() []string {
+	return GlobalConfig.GetStringSlice("encryptedDeviceGroups")
+}
+
+// IsEncryptedDeviceGroup raw磁盘组带有/disk后缀，按磁盘组名称比较
+func IsEncryptedDeviceGroup(group string) bool {
+	group = strings.ToLower(strings.Split(group, "/")[0])
+	for _, g := range EncryptedDeviceGroups() {
+		if strings.ToLower(g) == group {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportHostPaths 允许导入到新卷的主机目录，默认为空即禁止导入
+func ImportHostPaths() []string {
+	return GlobalConfig.GetStringSlice("importHostPaths")
+}
+
+// SpareVolumes 每个磁盘组保留的备用卷，大小和文件系统相同的pvc直接领用，默认不创建
+func SpareVolumes() []SpareVolumeItem {
+	items := []SpareVolumeItem{}
+	if err := GlobalConfig.UnmarshalKey("spareVolumes", &items); err != nil {
+		log.Warnf("invalid spareVolumes %s", err.Error())
+		return nil
+	}
+	spares := []SpareVolumeItem{}
+	for _, item := range items {
+		if item.FsType == "" {
+			item.FsType = "ext4"
+		}
+		if err := ValidateSpareVolume(item); err != nil {
+			log.Warnf("skip spare volumes %v: %s", item, err.Error())
+			continue
+		}
+		spares = append(spares, item)
+	}
+	return spares
+}
+
+// ValidateSpareVolume 备用卷按整GiB创建，与csi控制器分配卷的粒度一致，否则永远不会被领用
+func ValidateSpareVolume(item SpareVolumeItem) error {
+	if item.DeviceGroup == "" {
+		return errors.New("deviceGroup should not be empty")
+	}
+	if item.Count < 0 {
+		return fmt.Errorf("count should not be negative: %d", item.Count)
+	}
+	if !utils.ContainsString([]string{"ext2", "ext3", "ext4", "xfs"}, item.FsType) {
+		return fmt.Errorf("fsType should be ext2, ext3, ext4 or xfs: %s", item.FsType)
+	}
+	size, err := item.SizeBytes()
+	if err != nil {
+		return err
+	}
+	if size <= 0 || size%(1<<30) != 0 {
+		return fmt.Errorf("size should be a whole number of GiB: %s", item.Size)
+	}
+	return nil
+}
+
+// SizeBytes 备用卷大小，单位字节
+func (s SpareVolumeItem) SizeBytes() (int64, error) {
+	q, err := resource.ParseQuantity(s.Size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %s: %v", s.Size, err)
+	}
+	return q.Value(), nil
+}
+
+// ReservedCapacity 磁盘组预留的容量，没有匹配的配置时预留DefaultReservedSpace
+func ReservedCapacity() []ReservedCapacityItem {
+	items := []ReservedCapacityItem{}
+	if err := GlobalConfig.UnmarshalKey("reservedCapacity", &items); err != nil {
+		log.Warnf("invalid reservedCapacity %s", err.Error())
+		return nil
+	}
+	reserved := []ReservedCapacityItem{}
+	for _, item := range items {
+		if err := ValidateReservedCapacity(item); err != nil {
+			log.Warnf("skip reserved capacity %v: %s", item, err.Error())
+			continue
+		}
+		reserved = append(reserved, item)
+	}
+	return reserved
+}
+
+// ValidateReservedCapacity size和percent至少设置一个，size为0时不预留
+func ValidateReservedCapacity(item ReservedCapacityItem) error {
+	if item.Size == "" && item.Percent == 0 {
+		return errors.New("size or percent should be set")
+	}
+	if item.Percent < 0 || item.Percent >= 100 {
+		return fmt.Errorf("percent should be between 0 and 99: %d", item.Percent)
+	}
+	if item.Size == "" {
+		return nil
+	}
+	q, err := resource.ParseQuantity(item.Size)
+	if err != nil {
+		return fmt.Errorf("invalid size %s: %v", item.Size, err)
+	}
+	if q.Sign() < 0 {
+		return fmt.Errorf("size should not be negative: %s", item.Size)
+	}
+	return nil
+}
+
+// Bytes 大小为vgSize的磁盘组预留的字节数，同时设置size和percent时取较大者
+func (r ReservedCapacityItem) Bytes(vgSize uint64) uint64 {
+	reserved := vgSize / 100 * uint64(r.Percent)
+	if q, err := resource.ParseQuantity(r.Size); err == nil && uint64(q.Value()) > reserved {
+		reserved = uint64(q.Value())
+	}
+	return reserved
+}
+
+// ReservedBytes 节点上磁盘组预留的字节数
+// The most specific matching item applies: one naming both the device group and a node label
+// over one naming the device group, over one naming a node label, over one naming neither.
+// Among items equally specific the largest reservation applies.
+func ReservedBytes(items []ReservedCapacityItem, deviceGroup string, vgSize uint64, nodeLabels map[string]string) uint64 {
+	reserved := uint64(utils.DefaultReservedSpace)
+	best := -1
+	for _, item := range items {
+		if item.DeviceGroup != "" && item.DeviceGroup != deviceGroup {
+			continue
+		}
+		score := 0
+		if item.DeviceGroup != "" {
+			score += 2
+		}
+		if item.NodeLabel != "" {
+			if _, ok := nodeLabels[item.NodeLabel]; !ok {
+				continue
+			}
+			score++
+		}
+		bytes := item.Bytes(vgSize)
+		if score > best || (score == best && bytes > reserved) {
+			best = score
+			reserved = bytes
+		}
+	}
+	return reserved
+}
+
+// LoopDevices 磁盘组中由稀疏文件挂载的loop设备，默认不创建
+func LoopDevices() []LoopDeviceItem {
+	items := []LoopDeviceItem{}
+	if err := GlobalConfig.UnmarshalKey("loopDevices", &items); err != nil {
+		log.Warnf("invalid loopDevices %s", err.Error())
+		return nil
+	}
+	loops := []LoopDeviceItem{}
+	for _, item := range items {
+		if err := ValidateLoopDevice(item); err != nil {
+			log.Warnf("skip loop devices %v: %s", item, err.Error())
+			continue
+		}
+		loops = append(loops, item)
+	}
+	return loops
+}
+
+// ValidateLoopDevice loop设备与磁盘一样不小于10GiB，更小的磁盘不会加入磁盘组
+func ValidateLoopDevice(item LoopDeviceItem) error {
+	if item.DeviceGroup == "" {
+		return errors.New("deviceGroup should not be empty")
+	}
+	if item.Count < 0 {
+		return fmt.Errorf("count should not be negative: %d", item.Count)
+	}
+	size, err := item.SizeBytes()
+	if err != nil {
+		return err
+	}
+	if size < 10<<30 {
+		return fmt.Errorf("size should be at least 10Gi: %s", item.Size)
+	}
+	return nil
+}
+
+// SizeBytes loop设备大小，单位字节
+func (l LoopDeviceItem) SizeBytes() (int64, error) {
+	q, err := resource.ParseQuantity(l.Size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %s: %v", l.Size, err)
+	}
+	return q.Value(), nil
+}
+
+// LoopDeviceDir loop设备稀疏文件所在目录，需挂载主机目录，否则重启carina-node后数据丢失
+func LoopDeviceDir() string {
+	dir := GlobalConfig.GetString("loopDeviceDir")
+	if dir == "" {
+		return "/var/lib/carina/loop"
+	}
+	return dir
+}
+
+// NfsGatewayImage 共享卷nfs网关的镜像，需包含ganesha.nfsd、mkfs和mount
+func NfsGatewayImage() string {
+	image := GlobalConfig.GetString("nfsGatewayImage")
+	if image == "" {
+		return "registry.k8s.io/sig-storage/nfs-provisioner:v3.0.1"
+	}
+	return image
+}
+
 func RuntimeNamespace() string {
 	namespace := os.Getenv("NAMESPACE")
 	if namespace == "" {
@@ -197,8 +738,21 @@ func Validate(disk Disk) error {
 		if !diskNameRegexp.MatchString(dc.Name) {
 			return fmt.Errorf("disk name should consist of alphanumeric characters, '-', '_' or '.', and should start and end with an alphanumeric character: %s", dc.Name)
 		}
-		if len(dc.Re) == 0 {
-			log.Warnf("disk regexp should not be empty: %s", dc.Re)
+		if !dc.Selects() {
+			log.Warnf("disk group %s has no regexp, wwn, serial or model, only its loop devices join it", dc.Name)
+		}
+		if err := validateDiskMatch(dc); err != nil {
+			return err
+		}
+		// 磁盘组名称不限于ssd/hdd，每个磁盘组独立选择lvm或raw方式
+		if !utils.ContainsString([]string{"", "lvm", "raw"}, strings.ToLower(dc.Policy)) {
+			return fmt.Errorf("disk group %s policy should be LVM or RAW: %s", dc.Name, dc.Policy)
+		}
+		if len(dc.Spare) > 0 && strings.ToLower(dc.Policy) == "raw" {
+			return fmt.Errorf("disk group %s policy is RAW, hot spares are only supported for LVM", dc.Name)
+		}
+		if _, err := regexp.Compile(strings.Join(dc.Spare, "|")); err != nil {
+			return fmt.Errorf("disk group %s spare regexp is invalid: %v", dc.Name, err)
 		}
 		if vgGroup[dc.Name] {
 			return fmt.Errorf("duplicate vg group: %s", dc.Name)
@@ -208,6 +762,40 @@ func Validate(disk Disk) error {
 	return nil
 }
 
+// validateDiskMatch 检查磁盘组的匹配条件，raw磁盘组只支持按设备名匹配
+func validateDiskMatch(dc DiskSelectorItem) error {
+	if strings.ToLower(dc.Policy) == "raw" && (dc.Attributes() || len(dc.Deny) > 0) {
+		return fmt.Errorf("disk group %s policy is RAW, only re is supported to select disks", dc.Name)
+	}
+	if _, err := regexp.Compile(strings.Join(dc.Re, "|")); err != nil {
+		return fmt.Errorf("disk group %s regexp is invalid: %v", dc.Name, err)
+	}
+	if _, err := regexp.Compile(strings.Join(dc.Model, "|")); err != nil {
+		return fmt.Errorf("disk group %s model regexp is invalid: %v", dc.Name, err)
+	}
+	for _, v := range append(append(append([]string{}, dc.Wwn...), dc.Serial...), dc.Deny...) {
+		if strings.TrimSpace(v) == "" {
+			return fmt.Errorf("disk group %s has an empty wwn, serial or deny entry", dc.Name)
+		}
+	}
+	sizes := []int64{}
+	for _, s := range []string{dc.MinSize, dc.MaxSize} {
+		if s == "" {
+			sizes = append(sizes, 0)
+			continue
+		}
+		q, err := resource.ParseQuantity(s)
+		if err != nil || q.Sign() <= 0 {
+			return fmt.Errorf("disk group %s size %s should be a positive quantity such as 100Gi", dc.Name, s)
+		}
+		sizes = append(sizes, q.Value())
+	}
+	if sizes[0] > 0 && sizes[1] > 0 && sizes[0] > sizes[1] {
+		return fmt.Errorf("disk group %s minSize %s is larger than maxSize %s", dc.Name, dc.MinSize, dc.MaxSize)
+	}
+	return nil
+}
+
 func GetRawDeviceGroupRe(diskType string) []string {
 	deviceGroup := strings.ToLower(diskType)
 	currentDiskSelector := DiskConfig.DiskSelectors
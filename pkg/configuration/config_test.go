@@ -9,6 +9,7 @@ import (
 	"github.com/carina-io/carina/utils/log"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestUnmarshalWithDecoderOptions(t *testing.T) {
@@ -41,3 +42,203 @@ func TestUnmarshalWithDecoderOptions(t *testing.T) {
 	log.Info(DiskConfig)
 
 }
+
+func TestValidate(t *testing.T) {
+	table := []struct {
+		selectors []DiskSelectorItem
+		err       bool
+	}{
+		{selectors: []DiskSelectorItem{
+			{Name: "carina-vg-nvme", Re: []string{"nvme+"}, Policy: "LVM"},
+			{Name: "carina-vg-sata-ssd", Re: []string{"sd[a-c]"}, Policy: "LVM"},
+			{Name: "carina-vg-hdd", Re: []string{"sd[d-z]"}, Policy: "lvm"},
+			{Name: "carina-raw-hdd", Re: []string{"vdb"}, Policy: "RAW"},
+		}, err: false},
+		{selectors: []DiskSelectorItem{{Name: "carina-vg-nvme", Policy: "zfs"}}, err: true},
+		{selectors: []DiskSelectorItem{{Name: "nvme", Policy: "LVM"}, {Name: "nvme", Policy: "RAW"}}, err: true},
+		{selectors: []DiskSelectorItem{{Name: "-nvme", Policy: "LVM"}}, err: true},
+		{selectors: []DiskSelectorItem{{Name: "carina-vg-hdd", Re: []string{"sd[d-y]"}, Spare: []string{"sdz"}, Policy: "LVM"}}, err: false},
+		{selectors: []DiskSelectorItem{{Name: "carina-raw-hdd", Re: []string{"sd[d-y]"}, Spare: []string{"sdz"}, Policy: "RAW"}}, err: true},
+		{selectors: []DiskSelectorItem{{Name: "carina-vg-hdd", Re: []string{"sd[d-y]"}, Spare: []string{"sd[z"}, Policy: "LVM"}}, err: true},
+		{selectors: []DiskSelectorItem{{Name: "carina-vg-hdd", Re: []string{"sd[d-y"}, Policy: "LVM"}}, err: true},
+		{selectors: []DiskSelectorItem{{Name: "carina-vg-hdd", Wwn: []string{"0x5000c500a1b2c3d4"}, Serial: []string{"ZC1234AB"}, Deny: []string{"/dev/sda"}, Policy: "LVM"}}, err: false},
+		{selectors: []DiskSelectorItem{{Name: "carina-vg-ssd", Model: []string{"(?i)ssd"}, MinSize: "100Gi", MaxSize: "4Ti", Policy: "LVM"}}, err: false},
+		{selectors: []DiskSelectorItem{{Name: "carina-vg-ssd", Model: []string{"(?i)ssd"}, MinSize: "4Ti", MaxSize: "100Gi", Policy: "LVM"}}, err: true},
+		{selectors: []DiskSelectorItem{{Name: "carina-vg-ssd", Model: []string{"ssd("}, Policy: "LVM"}}, err: true},
+		{selectors: []DiskSelectorItem{{Name: "carina-vg-ssd", Re: []string{"sd"}, MinSize: "ten", Policy: "LVM"}}, err: true},
+		{selectors: []DiskSelectorItem{{Name: "carina-vg-ssd", Wwn: []string{" "}, Policy: "LVM"}}, err: true},
+		{selectors: []DiskSelectorItem{{Name: "carina-raw-ssd", Re: []string{"sd"}, Serial: []string{"ZC1234AB"}, Policy: "RAW"}}, err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		err := Validate(Disk{DiskSelectors: e.selectors})
+		if e.err {
+			a.Error(err, e.selectors)
+		} else {
+			a.NoError(err, e.selectors)
+		}
+	}
+}
+
+func TestValidateSpareVolume(t *testing.T) {
+	table := []struct {
+		item SpareVolumeItem
+		err  bool
+	}{
+		{item: SpareVolumeItem{DeviceGroup: "carina-vg-ssd", Size: "10Gi", Count: 2, FsType: "ext4"}, err: false},
+		{item: SpareVolumeItem{DeviceGroup: "carina-vg-ssd", Size: "1Ti", Count: 1, FsType: "xfs"}, err: false},
+		{item: SpareVolumeItem{DeviceGroup: "carina-vg-ssd", Size: "10G", Count: 2, FsType: "ext4"}, err: true},
+		{item: SpareVolumeItem{DeviceGroup: "carina-vg-ssd", Size: "0", Count: 2, FsType: "ext4"}, err: true},
+		{item: SpareVolumeItem{DeviceGroup: "carina-vg-ssd", Size: "10Gi", Count: 2, FsType: "btrfs"}, err: true},
+		{item: SpareVolumeItem{DeviceGroup: "carina-vg-ssd", Size: "ten", Count: 2, FsType: "ext4"}, err: true},
+		{item: SpareVolumeItem{Size: "10Gi", Count: 2, FsType: "ext4"}, err: true},
+		{item: SpareVolumeItem{DeviceGroup: "carina-vg-ssd", Size: "10Gi", Count: -1, FsType: "ext4"}, err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		err := ValidateSpareVolume(e.item)
+		if e.err {
+			a.Error(err, e.item)
+		} else {
+			a.NoError(err, e.item)
+		}
+	}
+}
+
+func TestValidateReservedCapacity(t *testing.T) {
+	table := []struct {
+		item ReservedCapacityItem
+		err  bool
+	}{
+		{item: ReservedCapacityItem{Size: "20Gi"}, err: false},
+		{item: ReservedCapacityItem{DeviceGroup: "carina-vg-ssd", Percent: 10}, err: false},
+		{item: ReservedCapacityItem{NodeLabel: "edge", Size: "0"}, err: false},
+		{item: ReservedCapacityItem{Size: "20Gi", Percent: 5}, err: false},
+		{item: ReservedCapacityItem{DeviceGroup: "carina-vg-ssd"}, err: true},
+		{item: ReservedCapacityItem{Percent: 100}, err: true},
+		{item: ReservedCapacityItem{Percent: -1}, err: true},
+		{item: ReservedCapacityItem{Size: "-1Gi"}, err: true},
+		{item: ReservedCapacityItem{Size: "twenty"}, err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		err := ValidateReservedCapacity(e.item)
+		if e.err {
+			a.Error(err, e.item)
+		} else {
+			a.NoError(err, e.item)
+		}
+	}
+}
+
+func TestReservedBytes(t *testing.T) {
+	const gi = uint64(1 << 30)
+	items := []ReservedCapacityItem{
+		{Size: "20Gi"},
+		{Percent: 30},
+		{DeviceGroup: "carina-vg-ssd", Size: "50Gi", Percent: 10},
+		{NodeLabel: "edge", Size: "1Gi"},
+		{DeviceGroup: "carina-vg-ssd", NodeLabel: "edge", Size: "0"},
+	}
+
+	a := assert.New(t)
+	a.Equal(10*gi, ReservedBytes(nil, "carina-vg-ssd", 1000*gi, nil))
+	// 同等匹配程度取较大的预留
+	a.Equal(300*gi, ReservedBytes(items, "carina-vg-hdd", 1000*gi, nil))
+	a.Equal(20*gi, ReservedBytes(items, "carina-vg-hdd", 50*gi, nil))
+	a.Equal(100*gi, ReservedBytes(items, "carina-vg-ssd", 1000*gi, nil))
+	a.Equal(50*gi, ReservedBytes(items, "carina-vg-ssd", 100*gi, nil))
+	// 指定磁盘组和节点标签的配置优先
+	labels := map[string]string{"edge": ""}
+	a.Equal(1*gi, ReservedBytes(items, "carina-vg-hdd", 1000*gi, labels))
+	a.Equal(uint64(0), ReservedBytes(items, "carina-vg-ssd", 1000*gi, labels))
+}
+
+func TestValidateLoopDevice(t *testing.T) {
+	table := []struct {
+		item LoopDeviceItem
+		err  bool
+	}{
+		{item: LoopDeviceItem{DeviceGroup: "carina-vg-loop", Size: "20Gi", Count: 2}, err: false},
+		{item: LoopDeviceItem{DeviceGroup: "carina-vg-loop", Size: "10Gi", Count: 0}, err: false},
+		{item: LoopDeviceItem{DeviceGroup: "carina-vg-loop", Size: "10G", Count: 1}, err: true},
+		{item: LoopDeviceItem{DeviceGroup: "carina-vg-loop", Size: "twenty", Count: 1}, err: true},
+		{item: LoopDeviceItem{Size: "20Gi", Count: 1}, err: true},
+		{item: LoopDeviceItem{DeviceGroup: "carina-vg-loop", Size: "20Gi", Count: -1}, err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		err := ValidateLoopDevice(e.item)
+		if e.err {
+			a.Error(err, e.item)
+		} else {
+			a.NoError(err, e.item)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	table := []struct {
+		data string
+		err  bool
+	}{
+		{data: `{"diskSelector":[{"name":"carina-vg-ssd","re":["loop+"],"policy":"LVM"}],"diskScanInterval":"300","schedulerStrategy":"spreadout","logLevel":"debug"}`, err: false},
+		{data: `{"diskSelector":[{"name":"carina-vg-ssd","re":["loop+"],"policy":"zfs"}]}`, err: true},
+		{data: `{"schedulerStrategy":"random"}`, err: true},
+		{data: `{"logLevel":"verbose"}`, err: true},
+		{data: `{"diskScanInterval":"-1"}`, err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		v, disk, err := decodeConfig([]byte(e.data))
+		a.NoError(err, e.data)
+		err = ValidateConfig(v, disk)
+		if e.err {
+			a.Error(err, e.data)
+		} else {
+			a.NoError(err, e.data)
+		}
+	}
+
+	_, _, err := decodeConfig([]byte(`{"diskSelector":`))
+	a.Error(err)
+}
+
+func TestConfigRevision(t *testing.T) {
+	a := assert.New(t)
+	a.Len(configRevision([]byte(`{}`)), 12)
+	a.Equal(configRevision([]byte(`{"logLevel":"info"}`)), configRevision([]byte(`{"logLevel":"info"}`)))
+	a.NotEqual(configRevision([]byte(`{"logLevel":"info"}`)), configRevision([]byte(`{"logLevel":"debug"}`)))
+}
+
+func TestReload(t *testing.T) {
+	a := assert.New(t)
+	notice := make(chan struct{}, 1)
+	RegisterListenerChan(notice)
+	defer func() { configModifyNotice = configModifyNotice[:len(configModifyNotice)-1] }()
+
+	good := []byte(`{"diskSelector":[{"name":"carina-vg-ssd","re":["loop+"],"policy":"LVM"}],"schedulerStrategy":"binpack"}`)
+	Reload(good)
+	a.Equal(configRevision(good), Applied().Revision)
+	a.Equal(SchedulerBinpack, SchedulerStrategy())
+	a.Len(DiskSelector(), 1)
+	<-notice
+
+	bad := []byte(`{"diskSelector":[{"name":"carina-vg-ssd","re":["loop+"],"policy":"zfs"}],"schedulerStrategy":"spreadout"}`)
+	Reload(bad)
+	a.Equal(configRevision(good), Applied().Revision)
+	a.Equal(configRevision(bad), Applied().RejectedRevision)
+	a.NotEmpty(Applied().Error)
+	a.Equal(SchedulerBinpack, SchedulerStrategy())
+	<-notice
+
+	// 改回生效的配置时清除拒绝记录
+	Reload(good)
+	a.Empty(Applied().RejectedRevision)
+	a.Empty(Applied().Error)
+}
@@ -0,0 +1,186 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/carina-io/carina/pkg/csidriver/filesystem"
+	"github.com/carina-io/carina/utils"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// supportedAccessMode 本地卷只能在一个节点上读写
+// With the SINGLE_NODE_MULTI_WRITER capability kubernetes asks for SINGLE_NODE_MULTI_WRITER
+// on ReadWriteOnce volumes and SINGLE_NODE_SINGLE_WRITER on ReadWriteOncePod volumes.
+// ReadOnlyMany volumes ask for MULTI_NODE_READER_ONLY, the node affinity of the pv still
+// keeps their pods on the node of the volume.
+func supportedAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return true
+	}
+	return false
+}
+
+// sharedAccessMode 共享卷经nfs网关导出，还支持多节点读写
+func sharedAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return mode == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER || mode == csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER
+}
+
+// sharedVolume 解析storageclass参数carina.storage.io/shared
+func sharedVolume(params map[string]string) (bool, error) {
+	v := params[utils.VolumeShared]
+	if v == "" {
+		return false, nil
+	}
+	shared, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got %q", utils.VolumeShared, v)
+	}
+	return shared, nil
+}
+
+// checkSharedVolume 共享卷由网关直接挂载lvm卷，不支持需要节点参与挂载的卷类型
+func checkSharedVolume(params map[string]string, volumeType string, replicas int) error {
+	if volumeType != utils.LvmVolumeType {
+		return errors.New("shared volumes must be lvm volumes")
+	}
+	if replicas > 1 {
+		return errors.New("shared volumes can not be replicated")
+	}
+	if ratio := params[utils.VolumeCacheDiskRatio]; ratio != "" && ratio != "0" {
+		return errors.New("shared volumes can not be bcache volumes")
+	}
+	if params[utils.VolumeMkfsOptions] != "" {
+		return fmt.Errorf("%s is not supported for shared volumes", utils.VolumeMkfsOptions)
+	}
+	return nil
+}
+
+// readOnlyAccess 只读访问模式的卷，所有使用它的pod都不能写入
+func readOnlyAccess(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY || mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+}
+
+// readOnlyVolume reports whether every requested capability of a new volume is read only,
+// a volume that can only be read has to get its data from a snapshot
+func readOnlyVolume(capabilities []*csi.VolumeCapability) bool {
+	if len(capabilities) == 0 {
+		return false
+	}
+	for _, c := range capabilities {
+		if !readOnlyAccess(c.GetAccessMode().GetMode()) {
+			return false
+		}
+	}
+	return true
+}
+
+// publishReadOnly pod声明readOnly或卷为只读访问模式时只读发布
+func publishReadOnly(req *csi.NodePublishVolumeRequest) bool {
+	return req.GetReadonly() || readOnlyAccess(req.GetVolumeCapability().GetAccessMode().GetMode())
+}
+
+// publishMountOptions 文件系统卷发布时的挂载参数
+// A clone restored from a snapshot has the xfs uuid of its origin, read-only mounts pass
+// nouuid so that clones can be mounted next to their origin on the same node.
+func publishMountOptions(req *csi.NodePublishVolumeRequest, fsType string) ([]string, error) {
+	readOnly := publishReadOnly(req)
+	var mountOptions []string
+	if readOnly {
+		mountOptions = append(mountOptions, "ro")
+		if fsType == "xfs" {
+			mountOptions = append(mountOptions, "nouuid")
+		}
+	}
+
+	for _, m := range req.GetVolumeCapability().GetMount().GetMountFlags() {
+		if m == "rw" && readOnly {
+			return nil, status.Error(codes.InvalidArgument, "mount option \"rw\" is specified even though read only mode is specified")
+		}
+		if utils.ContainsString(mountOptions, m) {
+			continue
+		}
+		mountOptions = append(mountOptions, m)
+	}
+	return mountOptions, nil
+}
+
+func checkAccessMode(capability *csi.VolumeCapability) error {
+	mode := capability.GetAccessMode().GetMode()
+	if !supportedAccessMode(mode) {
+		return status.Errorf(codes.FailedPrecondition, "unsupported access mode: %s", csi.VolumeCapability_AccessMode_Mode_name[int32(mode)])
+	}
+	return nil
+}
+
+// singleWriter ReadWriteOncePod的卷只能发布给一个pod
+func singleWriter(req *csi.NodePublishVolumeRequest) bool {
+	return req.GetVolumeCapability().GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER
+}
+
+// checkSingleWriter 拒绝把ReadWriteOncePod的卷发布到第二个目标路径
+// kubelet publishes a volume at one target path per pod, so another target path means
+// another pod. Publishes are remembered in memory, filesystem volumes published before
+// carina-node restarted are found in the mount table by their device.
+func (s *nodeService) checkSingleWriter(req *csi.NodePublishVolumeRequest, device string) error {
+	if !singleWriter(req) {
+		return nil
+	}
+	target := req.GetTargetPath()
+	others := []string{}
+	if published, ok := s.published[req.GetVolumeId()]; ok && published != target {
+		others = append(others, published)
+	}
+	if device != "" && req.GetVolumeCapability().GetMount() != nil {
+		paths, err := filesystem.MountPoints(device)
+		if err != nil {
+			return status.Errorf(codes.Internal, "mount check failed: device=%s, error=%v", device, err)
+		}
+		for _, p := range paths {
+			if p != target && !utils.ContainsString(others, p) {
+				others = append(others, p)
+			}
+		}
+	}
+	if len(others) > 0 {
+		return status.Errorf(codes.FailedPrecondition, "volume %s has access mode ReadWriteOncePod and is already published at %s", req.GetVolumeId(), strings.Join(others, ", "))
+	}
+	return nil
+}
+
+func (s *nodeService) rememberPublish(req *csi.NodePublishVolumeRequest) {
+	if singleWriter(req) {
+		s.published[req.GetVolumeId()] = req.GetTargetPath()
+	}
+}
+
+func (s *nodeService) forgetPublish(volumeID, target string) {
+	if s.published[volumeID] == target {
+		delete(s.published, volumeID)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckSingleWriter(t *testing.T) {
+	publish := func(mode csi.VolumeCapability_AccessMode_Mode, target string) *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId:   "volume-pvc-1",
+			TargetPath: target,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+			},
+		}
+	}
+	rwop := csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER
+	rwo := csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER
+
+	a := assert.New(t)
+	s := &nodeService{published: map[string]string{}}
+	a.NoError(s.checkSingleWriter(publish(rwop, "/pod-a"), ""))
+	s.rememberPublish(publish(rwop, "/pod-a"))
+	a.NoError(s.checkSingleWriter(publish(rwop, "/pod-a"), ""))
+
+	err := s.checkSingleWriter(publish(rwop, "/pod-b"), "")
+	a.Equal(codes.FailedPrecondition, status.Code(err))
+	a.Contains(err.Error(), "/pod-a")
+
+	s.forgetPublish("volume-pvc-1", "/pod-b")
+	a.Error(s.checkSingleWriter(publish(rwop, "/pod-b"), ""))
+	s.forgetPublish("volume-pvc-1", "/pod-a")
+	a.NoError(s.checkSingleWriter(publish(rwop, "/pod-b"), ""))
+
+	// ReadWriteOnce volumes can be used by several pods on the node
+	s.rememberPublish(publish(rwo, "/pod-a"))
+	a.NoError(s.checkSingleWriter(publish(rwo, "/pod-b"), ""))
+
+	a.True(supportedAccessMode(rwop))
+	a.True(supportedAccessMode(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER))
+	a.False(supportedAccessMode(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER))
+}
+
+func TestPublishMountOptions(t *testing.T) {
+	publish := func(mode csi.VolumeCapability_AccessMode_Mode, readOnly bool, flags ...string) *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId: "volume-pvc-1",
+			Readonly: readOnly,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: flags}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+			},
+		}
+	}
+	rwo := csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER
+	rox := csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+
+	a := assert.New(t)
+	options, err := publishMountOptions(publish(rwo, false, "noatime"), "ext4")
+	a.NoError(err)
+	a.Equal([]string{"noatime"}, options)
+
+	options, err = publishMountOptions(publish(rwo, true), "ext4")
+	a.NoError(err)
+	a.Equal([]string{"ro"}, options)
+
+	// ReadOnlyMany卷即使pod没有声明readOnly也只读挂载
+	options, err = publishMountOptions(publish(rox, false, "ro", "noatime"), "xfs")
+	a.NoError(err)
+	a.Equal([]string{"ro", "nouuid", "noatime"}, options)
+
+	_, err = publishMountOptions(publish(rox, false, "rw"), "ext4")
+	a.Equal(codes.InvalidArgument, status.Code(err))
+
+	a.True(supportedAccessMode(rox))
+	a.True(readOnlyVolume([]*csi.VolumeCapability{{AccessMode: &csi.VolumeCapability_AccessMode{Mode: rox}}}))
+	a.False(readOnlyVolume([]*csi.VolumeCapability{
+		{AccessMode: &csi.VolumeCapability_AccessMode{Mode: rox}},
+		{AccessMode: &csi.VolumeCapability_AccessMode{Mode: rwo}},
+	}))
+	a.False(readOnlyVolume(nil))
+}
+
+func TestSharedVolume(t *testing.T) {
+	a := assert.New(t)
+	shared, err := sharedVolume(map[string]string{utils.VolumeShared: "true"})
+	a.NoError(err)
+	a.True(shared)
+	shared, err = sharedVolume(map[string]string{})
+	a.NoError(err)
+	a.False(shared)
+	_, err = sharedVolume(map[string]string{utils.VolumeShared: "yes"})
+	a.Error(err)
+
+	rwx := csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+	a.False(supportedAccessMode(rwx))
+	a.True(sharedAccessMode(rwx))
+
+	a.NoError(checkSharedVolume(map[string]string{}, utils.LvmVolumeType, 1))
+	a.Error(checkSharedVolume(map[string]string{}, utils.RawVolumeType, 1))
+	a.Error(checkSharedVolume(map[string]string{}, utils.LvmVolumeType, 2))
+	a.Error(checkSharedVolume(map[string]string{utils.VolumeCacheDiskRatio: "20"}, utils.LvmVolumeType, 1))
+	a.Error(checkSharedVolume(map[string]string{utils.VolumeMkfsOptions: "-m 1"}, utils.LvmVolumeType, 1))
+}
@@ -0,0 +1,130 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"github.com/carina-io/carina/utils"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"sigs.k8s.io/yaml"
+)
+
+// controllerCapabilities ControllerGetCapabilities返回的能力，同时用于生成e2e测试的驱动描述
+var controllerCapabilities = []csi.ControllerServiceCapability_RPC_Type{
+	csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+	csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+	csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+	csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+	csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+}
+
+// nodeCapabilities NodeGetCapabilities返回的能力
+var nodeCapabilities = []csi.NodeServiceCapability_RPC_Type{
+	csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+	csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+	csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+	csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+}
+
+// SupportedFsTypes 节点能够格式化并扩容的文件系统
+var SupportedFsTypes = []string{"ext4", "xfs"}
+
+// TestDriver is the driver definition of the kubernetes external storage e2e suite,
+// passed to e2e.test with -storage.testdriver
+type TestDriver struct {
+	StorageClass  TestDriverClass  `json:"StorageClass"`
+	SnapshotClass *TestDriverClass `json:"SnapshotClass,omitempty"`
+	DriverInfo    TestDriverInfo   `json:"DriverInfo"`
+}
+
+// TestDriverClass names the StorageClass or VolumeSnapshotClass the suite uses
+type TestDriverClass struct {
+	FromName              bool   `json:"FromName,omitempty"`
+	FromFile              string `json:"FromFile,omitempty"`
+	FromExistingClassName string `json:"FromExistingClassName,omitempty"`
+}
+
+// TestDriverInfo describes what the suite may expect from the driver
+type TestDriverInfo struct {
+	Name               string              `json:"Name"`
+	SupportedSizeRange TestDriverSizeRange `json:"SupportedSizeRange"`
+	SupportedFsType    map[string]struct{} `json:"SupportedFsType"`
+	TopologyKeys       []string            `json:"TopologyKeys"`
+	Capabilities       map[string]bool     `json:"Capabilities"`
+}
+
+// TestDriverSizeRange the sizes of the volumes the suite creates
+type TestDriverSizeRange struct {
+	Min string `json:"Min"`
+	Max string `json:"Max,omitempty"`
+}
+
+// NewTestDriver 根据驱动声明的能力生成e2e测试的驱动描述
+// The capabilities follow the RPCs carina advertises, so that a capability added to or removed
+// from the driver changes the tests the conformance suite runs. storageClassFile is the
+// StorageClass manifest used by the suite, snapshotClass an existing VolumeSnapshotClass or empty.
+func NewTestDriver(storageClassFile, snapshotClass string) TestDriver {
+	controller := map[csi.ControllerServiceCapability_RPC_Type]bool{}
+	for _, c := range controllerCapabilities {
+		controller[c] = true
+	}
+	node := map[csi.NodeServiceCapability_RPC_Type]bool{}
+	for _, c := range nodeCapabilities {
+		node[c] = true
+	}
+
+	fsTypes := map[string]struct{}{}
+	for _, fs := range SupportedFsTypes {
+		fsTypes[fs] = struct{}{}
+	}
+	d := TestDriver{
+		StorageClass: TestDriverClass{FromFile: storageClassFile},
+		DriverInfo: TestDriverInfo{
+			Name:               utils.CSIPluginName,
+			SupportedSizeRange: TestDriverSizeRange{Min: "1Gi", Max: "16Ti"},
+			SupportedFsType:    fsTypes,
+			TopologyKeys:       []string{utils.TopologyNodeKey},
+			Capabilities: map[string]bool{
+				"persistence":      true,
+				"block":            true,
+				"fsGroup":          true,
+				"exec":             true,
+				"topology":         true,
+				"singleNodeVolume": true,
+				// 本地卷只能由同一节点上的多个pod使用
+				"multipods":           controller[csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER],
+				"RWX":                 false,
+				"controllerExpansion": controller[csi.ControllerServiceCapability_RPC_EXPAND_VOLUME],
+				"nodeExpansion":       node[csi.NodeServiceCapability_RPC_EXPAND_VOLUME],
+				"onlineExpansion":     controller[csi.ControllerServiceCapability_RPC_EXPAND_VOLUME] && node[csi.NodeServiceCapability_RPC_EXPAND_VOLUME],
+				"snapshotDataSource":  controller[csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT] && snapshotClass != "",
+				// CreateVolume只接受快照作为数据源
+				"pvcDataSource": controller[csi.ControllerServiceCapability_RPC_CLONE_VOLUME],
+				// MaxVolumesPerNode是固定值，不代表节点的实际上限
+				"volumeLimits": false,
+			},
+		},
+	}
+	if snapshotClass != "" {
+		d.SnapshotClass = &TestDriverClass{FromExistingClassName: snapshotClass}
+	}
+	return d
+}
+
+// Manifest returns the yaml passed to e2e.test
+func (d TestDriver) Manifest() ([]byte, error) {
+	return yaml.Marshal(d)
+}
@@ -0,0 +1,51 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTestDriver(t *testing.T) {
+	a := assert.New(t)
+
+	d := NewTestDriver("/tmp/storageclass.yaml", "")
+	a.Equal("carina.storage.io", d.DriverInfo.Name)
+	a.Nil(d.SnapshotClass)
+	caps := d.DriverInfo.Capabilities
+	a.True(caps["controllerExpansion"])
+	a.True(caps["nodeExpansion"])
+	a.True(caps["onlineExpansion"])
+	a.True(caps["multipods"])
+	// 没有VolumeSnapshotClass时不测试快照
+	a.False(caps["snapshotDataSource"])
+	a.False(caps["pvcDataSource"])
+	a.False(caps["RWX"])
+
+	d = NewTestDriver("/tmp/storageclass.yaml", "csi-carina-snapclass")
+	a.True(d.DriverInfo.Capabilities["snapshotDataSource"])
+
+	manifest, err := d.Manifest()
+	a.NoError(err)
+	for _, s := range []string{"FromFile: /tmp/storageclass.yaml", "FromExistingClassName: csi-carina-snapclass",
+		"- topology.carina.storage.io/node", "xfs: {}", "Min: 1Gi"} {
+		a.True(strings.Contains(string(manifest), s), s)
+	}
+}
@@ -22,38 +22,50 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/configuration"
 	"github.com/carina-io/carina/pkg/csidriver/driver/k8s"
+	"github.com/carina-io/carina/pkg/populator"
+	"github.com/carina-io/carina/pkg/tracing"
 	"github.com/carina-io/carina/pkg/version"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
 	"github.com/carina-io/carina/utils/mutx"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"go.opentelemetry.io/otel/attribute"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // NewControllerService returns a new ControllerServer.
 func NewControllerService(lvService *k8s.LogicVolumeService, nodeService *k8s.NodeService) csi.ControllerServer {
-	return &controllerService{lvService: lvService, nodeService: nodeService, mutex: mutx.NewGlobalLocks()}
+	return &controllerService{lvService: lvService, nodeService: nodeService, mutex: mutx.NewGlobalLocks(), drbdMutex: &sync.Mutex{}}
 }
 
 type controllerService struct {
 	csi.UnimplementedControllerServer
 	mutex *mutx.GlobalLocks
+	// drbdMutex 串行分配副本卷的drbd minor
+	drbdMutex *sync.Mutex
 
 	lvService   *k8s.LogicVolumeService
 	nodeService *k8s.NodeService
 }
 
 func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 
 	capabilities := req.GetVolumeCapabilities()
 	source := req.GetVolumeContentSource()
-	deviceGroup := req.GetParameters()[utils.DeviceDiskKey]
+	deviceGroup := utils.DeviceGroupParameter(req.GetParameters())
 	var exclusivityDisk bool = false
 	if req.GetParameters()[utils.ExclusivityDisk] == "true" {
 		exclusivityDisk = true
@@ -66,7 +78,7 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 
 	// 处理磁盘类型参数，支持carina.storage.io/disk-group-name:ssd书写方式
 	deviceGroup = version.GetDeviceGroup(deviceGroup)
-	log.Info("CreateVolume called ",
+	logger.Info("CreateVolume called ",
 		" name ", req.GetName(),
 		" device_group ", deviceGroup,
 		" required ", req.GetCapacityRange().GetRequiredBytes(),
@@ -77,37 +89,52 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		" content_source ", source,
 		" accessibility_requirements ", req.GetAccessibilityRequirements().String())
 
-	if source != nil {
-		return nil, status.Error(codes.InvalidArgument, "volume_content_source not supported")
+	if source != nil && source.GetSnapshot() == nil {
+		return nil, status.Error(codes.InvalidArgument, "only snapshot volume_content_source is supported")
 	}
 	if capabilities == nil {
 		return nil, status.Error(codes.InvalidArgument, "no volume capabilities are provided")
 	}
+	// 只读的卷没有写入数据的机会，只能从快照恢复
+	if readOnlyVolume(capabilities) && source == nil {
+		return nil, status.Error(codes.InvalidArgument, "a ReadOnlyMany volume must be restored from a snapshot")
+	}
 
 	if acquired := s.mutex.TryAcquire(name); !acquired {
-		log.Warnf("an operation with the given Volume ID %s already exists", name)
+		logger.Warnf("an operation with the given Volume ID %s already exists", name)
 		return nil, status.Errorf(codes.Aborted, "an operation with the given Volume ID %s already exists", name)
 	}
 	defer s.mutex.Release(name)
 
+	// 共享卷通过nfs网关导出，可以被多个节点读写
+	shared, err := sharedVolume(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	// check required volume capabilities
 	for _, capability := range capabilities {
 		if block := capability.GetBlock(); block != nil {
-			log.Info("CreateVolume specifies volume capability ", "access_type ", "block")
+			logger.Info("CreateVolume specifies volume capability ", "access_type ", "block")
+			if shared {
+				return nil, status.Error(codes.InvalidArgument, "shared volumes must be filesystem volumes")
+			}
 		} else if mount := capability.GetMount(); mount != nil {
-			log.Info("CreateVolume specifies volume capability ",
+			logger.Info("CreateVolume specifies volume capability ",
 				"access_type ", "mount",
 				"fs_type ", mount.GetFsType(),
 				"flags ", mount.GetMountFlags())
+			if err := utils.CheckMountFlags(mount.GetMountFlags()); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
 		} else {
 			return nil, status.Error(codes.InvalidArgument, "unknown or empty access_type")
 		}
 
 		if mode := capability.GetAccessMode(); mode != nil {
 			modeName := csi.VolumeCapability_AccessMode_Mode_name[int32(mode.GetMode())]
-			log.Info("CreateVolume specifies volume capability ", "access_mode ", modeName)
-			// we only support SINGLE_NODE_WRITER
-			if mode.GetMode() != csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER {
+			logger.Info("CreateVolume specifies volume capability ", "access_mode ", modeName)
+			if !supportedAccessMode(mode.GetMode()) && !(shared && sharedAccessMode(mode.GetMode())) {
 				return nil, status.Errorf(codes.InvalidArgument, "unsupported access mode: %s", modeName)
 			}
 		}
@@ -135,6 +162,118 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		return nil, status.Errorf(codes.Internal, "can not find pvc %s %s", namespace, name)
 	}
 
+	// StoragePolicy注入的pvc注解优先于storageclass参数
+	pvcAnnotations, err := s.nodeService.GetPvcAnnotations(ctx, namespace, pvcName)
+	if err != nil {
+		logger.Warnf("get annotations of pvc %s/%s failed: %s", namespace, pvcName, err.Error())
+	}
+	// pvc注解选择bcache的后端盘组、缓存盘组和缓存比例
+	req.Parameters = utils.BcacheParameters(req.GetParameters(), pvcAnnotations)
+	if group := pvcAnnotations[utils.DeviceDiskKey]; group != "" && req.GetParameters()[utils.VolumeBackendDiskType] == "" {
+		logger.Infof("pvc %s/%s overrides device group %s with %s", namespace, pvcName, deviceGroup, group)
+		deviceGroup = version.GetDeviceGroup(group)
+	}
+	if fsType := pvcAnnotations[utils.VolumeFsType]; fsType != "" {
+		req.Parameters[utils.VolumeFsType] = fsType
+	}
+	if policy := pvcAnnotations[utils.VolumeFsckPolicy]; policy != "" {
+		req.Parameters[utils.VolumeFsckPolicy] = policy
+	}
+
+	// mkfs参数在节点格式化时使用，这里先校验，避免pod一直卡在ContainerCreating
+	fsType := volumeFsType(req)
+	mkfsOptions, err := utils.MkfsOptions(fsType, req.GetParameters()[utils.VolumeMkfsOptions])
+	if fsType != "" && err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// 文件系统修复策略在节点挂载时使用
+	switch policy := req.GetParameters()[utils.VolumeFsckPolicy]; policy {
+	case "", utils.FsckPolicyNever, utils.FsckPolicyAuto, utils.FsckPolicyForce:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "%s must be never, auto or force, got %q", utils.VolumeFsckPolicy, policy)
+	}
+
+	// 开启fstrim的卷记录在LogicVolume上，由节点定时执行
+	fstrim := false
+	if v := req.GetParameters()[utils.VolumeFstrim]; v != "" {
+		if fstrim, err = strconv.ParseBool(v); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s must be true or false, got %q", utils.VolumeFstrim, v)
+		}
+	}
+
+	// 删除卷时的擦除方式记录在LogicVolume上，由节点在删除卷之前执行
+	if _, _, err := utils.WipeParameters(req.GetParameters()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// 块设备节点的属主和权限在节点发布时设置
+	if _, _, _, err := utils.ParseDeviceOwner(req.GetParameters()[utils.VolumeDeviceOwner]); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := utils.ParseDeviceMode(req.GetParameters()[utils.VolumeDeviceMode]); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// 快照与源卷在同一个thin pool中，从快照恢复的卷创建在快照所在的节点和磁盘组，
+	// 已导出到s3的快照在其他节点上从s3恢复，pvc注解可以指定其他集群导出的快照
+	var snapshot *carinav1.LogicVolume
+	restoreSource := pvcAnnotations[utils.VolumeRestoreSource]
+	if source != nil {
+		if ratio := req.GetParameters()[utils.VolumeCacheDiskRatio]; ratio != "" && ratio != "0" {
+			return nil, status.Error(codes.InvalidArgument, "bcache volumes can not be restored from snapshots")
+		}
+		if restoreSource != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "a volume can not be restored from a snapshot and from %s at the same time", utils.VolumeRestoreSource)
+		}
+		snapshot, err = s.snapshotSource(ctx, source.GetSnapshot().GetSnapshotId(), node, requestGb)
+		if err != nil {
+			return nil, err
+		}
+		if node == "" || node == snapshot.Spec.NodeName {
+			node = snapshot.Spec.NodeName
+			deviceGroup = snapshot.Spec.DeviceGroup
+		} else {
+			restoreSource = populator.ExportURL(snapshot.Annotations[utils.SnapshotExportTarget], snapshot.Status.VolumeID)
+			logger.Infof("pvc %s/%s is restored on node %s from %s, snapshot %s is on node %s", namespace, pvcName, node, restoreSource, snapshot.Status.VolumeID, snapshot.Spec.NodeName)
+		}
+	}
+	if restoreSource != "" {
+		if _, err := populator.ParseS3URL(restoreSource); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %v", utils.VolumeRestoreSource, err)
+		}
+		if ratio := req.GetParameters()[utils.VolumeCacheDiskRatio]; ratio != "" && ratio != "0" {
+			return nil, status.Error(codes.InvalidArgument, "bcache volumes can not be restored from snapshots")
+		}
+	}
+
+	// 导入节点上已有的目录，卷只能创建在数据所在的节点
+	importSource := pvcAnnotations[utils.VolumeImportSource]
+	if importSource != "" {
+		importNode, importPath, err := utils.ParseImportSource(importSource)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %v", utils.VolumeImportSource, err)
+		}
+		if snapshot != nil || restoreSource != "" {
+			return nil, status.Error(codes.InvalidArgument, "a volume can not be imported and restored from a snapshot at the same time")
+		}
+		if ratio := req.GetParameters()[utils.VolumeCacheDiskRatio]; ratio != "" && ratio != "0" {
+			return nil, status.Error(codes.InvalidArgument, "bcache volumes can not be imported")
+		}
+		for _, capability := range capabilities {
+			if capability.GetBlock() != nil {
+				return nil, status.Error(codes.InvalidArgument, "block volumes can not be imported")
+			}
+		}
+		if !utils.PathWithin(importPath, configuration.ImportHostPaths()) {
+			return nil, status.Errorf(codes.InvalidArgument, "import from %s is not allowed by importHostPaths", importPath)
+		}
+		if node != "" && node != importNode {
+			return nil, status.Errorf(codes.FailedPrecondition, "pvc %s/%s imports from node %s, but node %s was selected", namespace, pvcName, importNode, node)
+		}
+		node = importNode
+	}
+
 	if version.CheckRawDeviceGroup(deviceGroup) {
 		volumeType = utils.RawVolumeType
 		if node != "" {
@@ -147,6 +286,26 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		volumeType = utils.LvmVolumeType
 	}
 
+	// 副本卷由drbd在两个节点的lvm卷之间同步
+	replicas, err := volumeReplicas(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if shared {
+		if err := checkSharedVolume(req.GetParameters(), volumeType, replicas); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if replicas > 1 {
+		if importSource != "" {
+			return nil, status.Error(codes.InvalidArgument, "replicated volumes can not be imported")
+		}
+		if restoreSource != "" {
+			return nil, status.Error(codes.InvalidArgument, "replicated volumes can not be restored from s3")
+		}
+		return s.createReplicatedVolume(ctx, req, name, node, deviceGroup, requestGb)
+	}
+
 	// if bcache type, need create two lvm volume
 	cacheDiskRatio := req.GetParameters()[utils.VolumeCacheDiskRatio]
 	if cacheDiskRatio != "" && cacheDiskRatio != "0" {
@@ -166,12 +325,20 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 	}
 
 	//check  Parameters done
-	log.Infof("CreateVolume: Starting to Create %s volume %s with: pvcName(%s), pvcNameSpace(%s), node(%s),nodeSelected(%s), storageSelected(%s)", volumeType, req.GetName(), pvcName, namespace, node, nodeName, deviceGroup)
+	logger.Infof("CreateVolume: Starting to Create %s volume %s with: pvcName(%s), pvcNameSpace(%s), node(%s),nodeSelected(%s), storageSelected(%s)", volumeType, req.GetName(), pvcName, namespace, node, nodeName, deviceGroup)
 	// pv csi VolumeAttributes
 	annotation := map[string]string{}
 	annotation[utils.VolumeManagerType] = volumeType
 
 	annotation[utils.ExclusivityDisk] = fmt.Sprint(exclusivityDisk)
+	if restoreSource != "" {
+		if volumeType != utils.LvmVolumeType {
+			return nil, status.Error(codes.InvalidArgument, "only lvm volumes can be restored from snapshots")
+		}
+		annotation[utils.VolumeRestoreSource] = restoreSource
+	} else if snapshot != nil {
+		annotation[utils.VolumeDataSource] = snapshot.Status.VolumeID
+	}
 
 	volumeContext := req.GetParameters()
 	// 不是调度器完成pv调度，则采用controller调度
@@ -182,9 +349,12 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			// So we must create volume, and must not return error response in this case.
 			// - https://github.com/container-storage-interface/spec/blob/release-1.1/spec.md#createvolume
 			// - https://github.com/kubernetes-csi/csi-test/blob/6738ab2206eac88874f0a3ede59b40f680f59f43/pkg/sanity/controller.go#L404-L428
-			log.Info("decide node because accessibility_requirements not found")
-			node, deviceGroup, segments, err = s.nodeService.SelectVolumeNode(ctx, requestGb, deviceGroup, requirements)
-			log.Info("node:", node, " deviceGroup:", deviceGroup)
+			logger.Info("decide node because accessibility_requirements not found")
+			scheduleCtx, span := tracing.Start(ctx, "schedule", attribute.String("deviceGroup", deviceGroup))
+			node, deviceGroup, segments, err = s.nodeService.SelectVolumeNode(scheduleCtx, requestGb, deviceGroup, requirements)
+			span.SetAttributes(attribute.String("node", node))
+			tracing.End(span, err)
+			logger.Info("node:", node, " deviceGroup:", deviceGroup)
 			if err != nil {
 				return nil, status.Errorf(codes.Internal, "failed to get max capacity node %v", err)
 			}
@@ -196,10 +366,13 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			}
 
 		case utils.RawVolumeType:
-			log.Info("decide node because accessibility_requirements not found")
+			logger.Info("decide node because accessibility_requirements not found")
 
-			node, deviceGroup, segments, err = s.nodeService.SelectDeviceNode(ctx, requestGb, deviceGroup, requirements, exclusivityDisk)
-			log.Info("node:", node, " deviceGroup:", deviceGroup)
+			scheduleCtx, span := tracing.Start(ctx, "schedule", attribute.String("deviceGroup", deviceGroup))
+			node, deviceGroup, segments, err = s.nodeService.SelectDeviceNode(scheduleCtx, requestGb, deviceGroup, requirements, exclusivityDisk)
+			span.SetAttributes(attribute.String("node", node))
+			tracing.End(span, err)
+			logger.Info("node:", node, " deviceGroup:", deviceGroup)
 			if err != nil {
 				return nil, status.Errorf(codes.Internal, "failed to get max capacity node %v", err)
 			}
@@ -211,12 +384,64 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			}
 
 		default:
-			log.Errorf("CreateVolume: Create with no support volume type %s", volumeType)
+			logger.Errorf("CreateVolume: Create with no support volume type %s", volumeType)
 			return nil, status.Error(codes.InvalidArgument, "Create with no support type "+volumeType)
 		}
 	}
 
-	log.Infof("CreateVolume: Successful create pvcName %s node %s deviceGroup %s name %s size %d", pvcName, node, deviceGroup, name, requestGb)
+	// 磁盘组在此之后不再变化，按集群策略决定是否加密
+	encrypted := encryptionRequired(req.GetParameters(), deviceGroup)
+	// 快照数据原样复制到新卷，加密与否必须和源卷一致
+	if snapshot != nil && encrypted != (snapshot.Annotations[utils.VolumeEncrypted] == "true") {
+		return nil, status.Errorf(codes.InvalidArgument, "encryption of the volume must match snapshot %s", snapshot.Status.VolumeID)
+	}
+	if encrypted && shared {
+		return nil, status.Errorf(codes.InvalidArgument, "disk group %s requires encryption, which is not supported for shared volumes", deviceGroup)
+	}
+	if encrypted {
+		if volumeType != utils.LvmVolumeType {
+			return nil, status.Errorf(codes.InvalidArgument, "disk group %s requires encryption, which is only supported for lvm volumes", deviceGroup)
+		}
+		annotation[utils.VolumeEncrypted] = "true"
+	}
+
+	// 条带化只作用于lvm卷，由节点创建thin pool时使用
+	stripes, stripeSize, err := utils.StripeParameters(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if stripes > 1 {
+		if volumeType != utils.LvmVolumeType {
+			return nil, status.Error(codes.InvalidArgument, "striping is only supported for lvm volumes")
+		}
+		annotation[utils.VolumeStripes] = fmt.Sprint(stripes)
+		if stripeSize != "" {
+			annotation[utils.VolumeStripeSize] = stripeSize
+		}
+	}
+
+	// 文件系统卷记录文件系统类型，节点可以直接领用预先格式化的备用卷，备用卷按默认参数格式化
+	if fsType != "" && volumeType == utils.LvmVolumeType && snapshot == nil && restoreSource == "" && len(mkfsOptions) == 0 {
+		annotation[utils.VolumeFsType] = fsType
+	}
+
+	if fstrim && fsType != "" {
+		annotation[utils.VolumeFstrim] = "true"
+	}
+	addWipeAnnotations(annotation, req.GetParameters())
+	// 网关首次启动时按此文件系统格式化卷
+	if shared {
+		annotation[utils.VolumeShared] = "true"
+		annotation[utils.VolumeFsType] = fsType
+	}
+
+	release, err := s.lvService.ReserveQuota(ctx, namespace, name, deviceGroup, map[string]int64{deviceGroup: requestGb << 30})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	logger.Infof("CreateVolume: Successful create pvcName %s node %s deviceGroup %s name %s size %d", pvcName, node, deviceGroup, name, requestGb)
 	// create logicVolume
 	volumeID, deviceMajor, deviceMinor, err := s.lvService.CreateVolume(ctx, namespace, pvcName, node, deviceGroup, name, requestGb, metav1.OwnerReference{}, annotation)
 	if err != nil {
@@ -232,32 +457,53 @@ func (s controllerService) CreateVolume(ctx context.Context, req *csi.CreateVolu
 	volumeContext[utils.VolumeDeviceNode] = node
 	volumeContext[utils.VolumeDeviceMajor] = fmt.Sprintf("%d", deviceMajor)
 	volumeContext[utils.VolumeDeviceMinor] = fmt.Sprintf("%d", deviceMinor)
+	if encrypted {
+		volumeContext[utils.VolumeEncrypted] = "true"
+	}
+	if importSource != "" {
+		volumeContext[utils.VolumeImportSource] = importSource
+	}
 	// pv nodeAffinity
 	segments[utils.TopologyNodeKey] = node
+	topology := []*csi.Topology{
+		{
+			Segments: segments,
+		},
+	}
+	// 共享卷经nfs网关访问，pod可以调度到任意节点
+	if shared {
+		topology = nil
+	}
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			CapacityBytes: requestGb << 30,
-			VolumeId:      volumeID,
-			VolumeContext: volumeContext,
-			ContentSource: source,
-			AccessibleTopology: []*csi.Topology{
-				{
-					Segments: segments,
-				},
-			},
+			CapacityBytes:      requestGb << 30,
+			VolumeId:           volumeID,
+			VolumeContext:      volumeContext,
+			ContentSource:      source,
+			AccessibleTopology: topology,
 		},
 	}, nil
 }
 
 func (s controllerService) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	log.Info("DeleteVolume called volume_id ", req.GetVolumeId(), " num_secrets ", len(req.GetSecrets()))
+	logger := log.FromContext(ctx)
+	logger.Info("DeleteVolume called volume_id ", req.GetVolumeId(), " num_secrets ", len(req.GetSecrets()))
 	if len(req.GetVolumeId()) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "volume_id is not provided")
 	}
 
-	err := s.lvService.DeleteVolume(ctx, req.GetVolumeId())
+	// 快照依赖源卷的thin pool，删除源卷会同时删除快照
+	snapshots, err := s.lvService.ListSnapshots(ctx, req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if len(snapshots) > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s has %d snapshots, delete them first", req.GetVolumeId(), len(snapshots))
+	}
+
+	err = s.lvService.DeleteVolume(ctx, req.GetVolumeId())
 	if err != nil {
-		log.Error(err, " DeleteVolume failed volume_id ", req.GetVolumeId())
+		logger.Error(err, " DeleteVolume failed volume_id ", req.GetVolumeId())
 		_, ok := status.FromError(err)
 		if !ok {
 			return nil, status.Error(codes.Internal, err.Error())
@@ -269,7 +515,8 @@ func (s controllerService) DeleteVolume(ctx context.Context, req *csi.DeleteVolu
 }
 
 func (s controllerService) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	log.Info("ValidateVolumeCapabilities called ",
+	logger := log.FromContext(ctx)
+	logger.Info("ValidateVolumeCapabilities called ",
 		"volume_id ", req.GetVolumeId(),
 		"volume_context ", req.GetVolumeContext(),
 		"volume_capabilities ", req.GetVolumeCapabilities(),
@@ -291,8 +538,19 @@ func (s controllerService) ValidateVolumeCapabilities(ctx context.Context, req *
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	for _, capability := range req.GetVolumeCapabilities() {
+		if capability.GetBlock() == nil && capability.GetMount() == nil {
+			return &csi.ValidateVolumeCapabilitiesResponse{Message: "unknown or empty access_type"}, nil
+		}
+		if mode := capability.GetAccessMode().GetMode(); !supportedAccessMode(mode) && !(req.GetVolumeContext()[utils.VolumeShared] == "true" && sharedAccessMode(mode)) {
+			return &csi.ValidateVolumeCapabilitiesResponse{
+				Message: fmt.Sprintf("unsupported access mode: %s", csi.VolumeCapability_AccessMode_Mode_name[int32(mode)]),
+			}, nil
+		}
+	}
+
 	// Since Carina does not provide means to pre-provision volumes,
-	// any existing volume is valid.
+	// any existing volume with a single node access mode is valid.
 	return &csi.ValidateVolumeCapabilitiesResponse{
 		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
 			VolumeContext:      req.GetVolumeContext(),
@@ -303,43 +561,37 @@ func (s controllerService) ValidateVolumeCapabilities(ctx context.Context, req *
 }
 
 func (s controllerService) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	logger := log.FromContext(ctx)
 	topology := req.GetAccessibleTopology()
 	capabilities := req.GetVolumeCapabilities()
-	log.Info("GetCapacity called volume_capabilities ", capabilities,
+	logger.Info("GetCapacity called volume_capabilities ", capabilities,
 		" parameters ", req.GetParameters(),
 		" accessible_topology ", topology)
 	if capabilities != nil {
-		log.Info("capability argument is not nil, but Carina ignores it")
+		logger.Info("capability argument is not nil, but Carina ignores it")
 	}
 
-	deviceGroup := req.GetParameters()[utils.DeviceDiskKey]
+	deviceGroup := utils.DeviceGroupParameter(req.GetParameters())
 
-	// 处理磁盘类型参数，支持carina.storage.io/disk-group-name:ssd书写方式
-	deviceGroup = strings.ToLower(deviceGroup)
+	// 处理磁盘类型参数，支持carina.storage.io/disk-group-name:ssd书写方式，其余磁盘组名称按配置原样使用
 	if deviceGroup != "" {
-		if !strings.HasPrefix(deviceGroup, "carina-vg-") {
-			deviceGroup = fmt.Sprintf("carina-vg-%s", deviceGroup)
-		}
+		deviceGroup = version.GetDeviceGroup(deviceGroup)
 	}
 
-	capacity, err := s.nodeService.GetTotalCapacity(ctx, deviceGroup, topology)
+	capacity, maximum, err := s.nodeService.GetTotalCapacity(ctx, deviceGroup, topology)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	// kube-scheduler按CSIStorageCapacity的maximumVolumeSize判断卷能否放下，裸盘卷不能跨盘
 	return &csi.GetCapacityResponse{
 		AvailableCapacity: capacity,
+		MaximumVolumeSize: &wrappers.Int64Value{Value: maximum},
 	}, nil
 }
 
 func (s controllerService) ControllerGetCapabilities(context.Context, *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-	capabilities := []csi.ControllerServiceCapability_RPC_Type{
-		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
-		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-	}
-
-	csiCaps := make([]*csi.ControllerServiceCapability, len(capabilities))
-	for i, capability := range capabilities {
+	csiCaps := make([]*csi.ControllerServiceCapability, len(controllerCapabilities))
+	for i, capability := range controllerCapabilities {
 		csiCaps[i] = &csi.ControllerServiceCapability{
 			Type: &csi.ControllerServiceCapability_Rpc{
 				Rpc: &csi.ControllerServiceCapability_RPC{
@@ -355,8 +607,9 @@ func (s controllerService) ControllerGetCapabilities(context.Context, *csi.Contr
 }
 
 func (s controllerService) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	volumeID := req.GetVolumeId()
-	log.Infof("ControllerExpandVolume called volumeID %s required %d limit %d num_secrets %d", volumeID, req.GetCapacityRange().GetRequiredBytes(),
+	logger.Infof("ControllerExpandVolume called volumeID %s required %d limit %d num_secrets %d", volumeID, req.GetCapacityRange().GetRequiredBytes(),
 		req.GetCapacityRange().GetLimitBytes(), len(req.GetSecrets()))
 
 	if len(volumeID) == 0 {
@@ -364,7 +617,7 @@ func (s controllerService) ControllerExpandVolume(ctx context.Context, req *csi.
 	}
 
 	if acquired := s.mutex.TryAcquire(volumeID); !acquired {
-		log.Warnf("an operation with the given Volume ID %s already exists", volumeID)
+		logger.Warnf("an operation with the given Volume ID %s already exists", volumeID)
 		return nil, status.Errorf(codes.Aborted, "an operation with the given Volume ID %s already exists", volumeID)
 	}
 	defer s.mutex.Release(volumeID)
@@ -380,6 +633,10 @@ func (s controllerService) ControllerExpandVolume(ctx context.Context, req *csi.
 	if lv.Annotations[utils.VolumeManagerType] == "raw" && lv.Annotations[utils.ExclusivityDisk] == "false" {
 		return nil, status.Error(codes.Internal, "can not exclusivityDisk pods")
 	}
+	// 共享卷的文件系统由nfs网关挂载，节点无法在线扩容
+	if lv.Annotations[utils.VolumeShared] == "true" {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is shared through an nfs gateway and can not be expanded", volumeID)
+	}
 
 	requestGb, err := convertRequestCapacity(req.GetCapacityRange().GetRequiredBytes(), req.GetCapacityRange().GetLimitBytes())
 	if err != nil {
@@ -413,8 +670,35 @@ func (s controllerService) ControllerExpandVolume(ctx context.Context, req *csi.
 	if capacity < (requestGb - currentGb) {
 		return nil, status.Error(codes.Internal, "not enough space")
 	}
+	// 副本卷的第二个副本同步扩容
+	replicaNode := lv.Annotations[utils.VolumeReplicaNode]
+	if replicaNode != "" {
+		capacity, err := s.nodeService.GetCapacityByNodeName(ctx, replicaNode, lv.Spec.DeviceGroup)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if capacity < (requestGb - currentGb) {
+			return nil, status.Errorf(codes.Internal, "not enough space on replica node %s", replicaNode)
+		}
+	}
+
+	quotaRequests := map[string]int64{lv.Spec.DeviceGroup: (requestGb - currentGb) << 30}
+	if replicaNode != "" {
+		quotaRequests[lv.Spec.DeviceGroup] *= 2
+	}
+	if ratio, err := strconv.ParseInt(lv.Annotations[utils.VolumeCacheDiskRatio], 10, 64); err == nil && lv.Annotations[utils.VolumeCacheDiskType] != "" {
+		quotaRequests[lv.Annotations[utils.VolumeCacheDiskType]] += (requestGb - currentGb) * ratio / 100 << 30
+	}
+	release, err := s.lvService.ReserveQuota(ctx, lv.Spec.NameSpace, lv.Name, "", quotaRequests)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	err = s.lvService.ExpandVolume(ctx, volumeID, requestGb)
+	if err == nil {
+		err = s.expandReplica(ctx, lv, requestGb)
+	}
 	if err != nil {
 		_, ok := status.FromError(err)
 		if !ok {
@@ -432,10 +716,10 @@ func (s controllerService) ControllerExpandVolume(ctx context.Context, req *csi.
 
 			ratio, err := strconv.ParseInt(cacheDiskRatio, 10, 64)
 			if err != nil {
-				log.Errorf("carina.storage.io/cache-disk-ratio %s, Should be in 1-100", cacheDiskRatio)
+				logger.Errorf("carina.storage.io/cache-disk-ratio %s, Should be in 1-100", cacheDiskRatio)
 			}
 			if ratio < 1 || ratio >= 100 {
-				log.Errorf("carina.storage.io/cache-disk-ratio %s, Should be in 1-100", cacheDiskRatio)
+				logger.Errorf("carina.storage.io/cache-disk-ratio %s, Should be in 1-100", cacheDiskRatio)
 			}
 			cacheVolumeName := "volume-cache-" + lv.Name[6:]
 			cacheRequestGb := requestGb * ratio / 100
@@ -444,7 +728,7 @@ func (s controllerService) ControllerExpandVolume(ctx context.Context, req *csi.
 			if err != nil {
 				_, ok := status.FromError(err)
 				if !ok {
-					log.Errorf("cache expand failed %s", err.Error())
+					logger.Errorf("cache expand failed %s", err.Error())
 				}
 			}
 		}()
@@ -456,6 +740,27 @@ func (s controllerService) ControllerExpandVolume(ctx context.Context, req *csi.
 	}, nil
 }
 
+// volumeFsType 与节点挂载卷时选择的文件系统一致，块设备卷返回空
+func volumeFsType(req *csi.CreateVolumeRequest) string {
+	fsType := ""
+	for _, capability := range req.GetVolumeCapabilities() {
+		mount := capability.GetMount()
+		if mount == nil {
+			return ""
+		}
+		if mount.GetFsType() != "" {
+			fsType = mount.GetFsType()
+		}
+	}
+	if f := req.GetParameters()[utils.VolumeFsType]; f != "" {
+		fsType = f
+	}
+	if fsType == "" {
+		fsType = "ext4"
+	}
+	return fsType
+}
+
 func convertRequestCapacity(requestBytes, limitBytes int64) (int64, error) {
 	if requestBytes < 0 {
 		return 0, errors.New("required capacity must not be negative")
@@ -477,6 +782,7 @@ func convertRequestCapacity(requestBytes, limitBytes int64) (int64, error) {
 }
 
 func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.CreateVolumeRequest, node string, requestGb int64) (*csi.CreateVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	source := req.GetVolumeContentSource()
 	name := req.GetName()
 	if name == "" {
@@ -501,6 +807,14 @@ func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.Crea
 	backendDiskType = strings.ToLower(backendDiskType)
 	cacheDiskType = strings.ToLower(cacheDiskType)
 
+	if encryptionRequired(req.GetParameters(), backendDiskType) || encryptionRequired(req.GetParameters(), cacheDiskType) {
+		return nil, status.Error(codes.InvalidArgument, "encryption is not supported for bcache volumes")
+	}
+
+	if stripes, _, _ := utils.StripeParameters(req.GetParameters()); stripes > 1 {
+		return nil, status.Error(codes.InvalidArgument, "striping is not supported for bcache volumes")
+	}
+
 	if !utils.ContainsString([]string{"writethrough", "writeback", "writearound"}, cachepolicy) {
 		cachepolicy = "writethrough"
 	}
@@ -527,7 +841,7 @@ func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.Crea
 	segments := map[string]string{}
 
 	if node == "" {
-		log.Info("decide node because accessibility_requirements not found")
+		logger.Info("decide node because accessibility_requirements not found")
 		nodeName, segmentsTmp, err := s.nodeService.SelectMultiVolumeNode(ctx, backendDiskType, cacheDiskType, backendRequestGb, cacheRequestGb, requirements)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to get max capacity node %v", err)
@@ -539,9 +853,21 @@ func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.Crea
 		segments = segmentsTmp
 	}
 
+	quotaRequests := map[string]int64{backendDiskType: backendRequestGb << 30}
+	quotaRequests[cacheDiskType] += cacheRequestGb << 30
+	release, err := s.lvService.ReserveQuota(ctx, namespace, backendVolumeName, backendDiskType, quotaRequests)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	annotation := map[string]string{
 		utils.VolumeCacheDiskRatio: cacheDiskRatio,
+		// 调度器据此统计尚未创建的缓存卷占用
+		utils.VolumeCacheDiskType: cacheDiskType,
 	}
+	// 缓存卷同样保存数据，与后端卷一起擦除
+	addWipeAnnotations(annotation, req.GetParameters())
 
 	backendDiskVolumeID, backendDiskDeviceMajor, backendDiskDeviceMinor, err := s.lvService.CreateVolume(ctx, namespace, pvcName, node, backendDiskType, backendVolumeName, backendRequestGb, metav1.OwnerReference{}, annotation)
 	if err != nil {
@@ -562,16 +888,7 @@ func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.Crea
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	controller := true
-	blockOwnerDeletion := true
-	owner := metav1.OwnerReference{
-		APIVersion:         lv.APIVersion,
-		Kind:               lv.Kind,
-		Name:               lv.Name,
-		UID:                lv.UID,
-		Controller:         &controller,
-		BlockOwnerDeletion: &blockOwnerDeletion,
-	}
+	owner := logicVolumeOwner(lv)
 
 	cacheDiskVolumeID, cacheDiskDeviceMajor, cacheDiskDeviceMinor, err := s.lvService.CreateVolume(ctx, namespace, pvcName, node, cacheDiskType, cacheVolumeName, cacheRequestGb, owner, annotation)
 	if err != nil {
@@ -614,3 +931,163 @@ func (s controllerService) CreateBcacheVolume(ctx context.Context, req *csi.Crea
 		},
 	}, nil
 }
+
+// CreateSnapshot 在源卷的thin pool中创建快照，快照用LogicVolume记录，由源卷所在节点创建
+func (s controllerService) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("CreateSnapshot called name ", req.GetName(), " source_volume_id ", req.GetSourceVolumeId(), " parameters ", req.GetParameters())
+	name := strings.ToLower(req.GetName())
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid name")
+	}
+	sourceVolumeID := req.GetSourceVolumeId()
+	if sourceVolumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "source_volume_id is not provided")
+	}
+	exportTarget := req.GetParameters()[utils.SnapshotExportTarget]
+	if exportTarget != "" {
+		if _, err := populator.ParseS3URL(exportTarget); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %v", utils.SnapshotExportTarget, err)
+		}
+	}
+
+	if acquired := s.mutex.TryAcquire(name); !acquired {
+		logger.Warnf("an operation with the given Snapshot name %s already exists", name)
+		return nil, status.Errorf(codes.Aborted, "an operation with the given Snapshot name %s already exists", name)
+	}
+	defer s.mutex.Release(name)
+
+	source, err := s.lvService.GetLogicVolume(ctx, sourceVolumeID)
+	if err != nil {
+		if err == k8s.ErrVolumeNotFound {
+			return nil, status.Errorf(codes.NotFound, "LogicalVolume for volume id %s is not found", sourceVolumeID)
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if source.Annotations[utils.VolumeManagerType] != utils.LvmVolumeType || source.Annotations[utils.SnapshotSource] != "" || source.Annotations[utils.VolumeCacheDiskType] != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s can not be snapshotted, only lvm volumes are supported", sourceVolumeID)
+	}
+
+	existing, err := s.lvService.GetLogicVolume(ctx, "snap-"+name)
+	if err != nil && err != k8s.ErrVolumeNotFound {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if existing != nil && existing.Annotations[utils.SnapshotSource] != source.Name {
+		return nil, status.Errorf(codes.AlreadyExists, "snapshot %s already exists for another volume", name)
+	}
+
+	annotation := map[string]string{
+		utils.VolumeManagerType: utils.LvmVolumeType,
+		utils.SnapshotSource:    source.Name,
+	}
+	if source.Annotations[utils.VolumeEncrypted] == "true" {
+		annotation[utils.VolumeEncrypted] = "true"
+	}
+	// 节点创建快照后将其上传到s3，其他节点和集群可以从s3恢复
+	if exportTarget != "" {
+		annotation[utils.SnapshotExportTarget] = exportTarget
+	}
+	sizeGb := source.Spec.Size.Value() >> 30
+	snapshotID, _, _, err := s.lvService.CreateVolume(ctx, "", "", source.Spec.NodeName, source.Spec.DeviceGroup, name, sizeGb, metav1.OwnerReference{}, annotation)
+	if err != nil {
+		_, ok := status.FromError(err)
+		if !ok {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, err
+	}
+
+	snapshot, err := s.lvService.GetLogicVolume(ctx, snapshotID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	logger.Infof("CreateSnapshot: Successful create snapshot %s of volume %s node %s deviceGroup %s", snapshotID, sourceVolumeID, source.Spec.NodeName, source.Spec.DeviceGroup)
+	return &csi.CreateSnapshotResponse{
+		Snapshot: &csi.Snapshot{
+			SizeBytes:      sizeGb << 30,
+			SnapshotId:     snapshotID,
+			SourceVolumeId: sourceVolumeID,
+			CreationTime: &timestamp.Timestamp{
+				Seconds: snapshot.CreationTimestamp.Unix(),
+				Nanos:   int32(snapshot.CreationTimestamp.Nanosecond()),
+			},
+			ReadyToUse: true,
+		},
+	}, nil
+}
+
+func (s controllerService) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("DeleteSnapshot called snapshot_id ", req.GetSnapshotId(), " num_secrets ", len(req.GetSecrets()))
+	if len(req.GetSnapshotId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "snapshot_id is not provided")
+	}
+
+	err := s.lvService.DeleteVolume(ctx, req.GetSnapshotId())
+	if err != nil {
+		logger.Error(err, " DeleteSnapshot failed snapshot_id ", req.GetSnapshotId())
+		_, ok := status.FromError(err)
+		if !ok {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, err
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// logicVolumeOwner 返回缓存卷、副本卷指向其主卷的ownerReference
+// The TypeMeta of a LogicVolume read by a typed client is empty, the group version
+// and kind are set explicitly so that the scheduler and quota recognize the owned volume.
+func logicVolumeOwner(lv *carinav1.LogicVolume) metav1.OwnerReference {
+	controller := true
+	blockOwnerDeletion := true
+	return metav1.OwnerReference{
+		APIVersion:         carinav1.GroupVersion.String(),
+		Kind:               "LogicVolume",
+		Name:               lv.Name,
+		UID:                lv.UID,
+		Controller:         &controller,
+		BlockOwnerDeletion: &blockOwnerDeletion,
+	}
+}
+
+// snapshotSource 返回新卷要恢复的快照，已选定其他节点时快照必须已导出到s3
+func (s controllerService) snapshotSource(ctx context.Context, snapshotID, node string, requestGb int64) (*carinav1.LogicVolume, error) {
+	snapshot, err := s.lvService.GetLogicVolume(ctx, snapshotID)
+	if err != nil {
+		if err == k8s.ErrVolumeNotFound {
+			return nil, status.Errorf(codes.NotFound, "snapshot %s is not found", snapshotID)
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if snapshot.Annotations[utils.SnapshotSource] == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not a snapshot", snapshotID)
+	}
+	if node != "" && node != snapshot.Spec.NodeName && !meta.IsStatusConditionTrue(snapshot.Status.Conditions, utils.ConditionExported) {
+		return nil, status.Errorf(codes.InvalidArgument, "snapshot %s is on node %s and not exported to s3, the volume can not be restored on node %s", snapshotID, snapshot.Spec.NodeName, node)
+	}
+	if requestGb < snapshot.Spec.Size.Value()>>30 {
+		return nil, status.Errorf(codes.OutOfRange, "requested %dGi is smaller than snapshot %s", requestGb, snapshotID)
+	}
+	return snapshot, nil
+}
+
+// encryptionRequired 存储类要求加密，或者磁盘组被集群策略列为必须加密
+// The policy wins over the storage class so that an encrypted group can not be
+// used unencrypted by a storage class that forgot or refused the parameter.
+func encryptionRequired(params map[string]string, deviceGroup string) bool {
+	return params[utils.VolumeEncrypted] == "true" || configuration.IsEncryptedDeviceGroup(deviceGroup)
+}
+
+// addWipeAnnotations 记录storageclass参数中删除卷前的擦除方式，参数已在CreateVolume中校验
+func addWipeAnnotations(annotation, params map[string]string) {
+	policy, passes, err := utils.WipeParameters(params)
+	if err != nil || policy == "" || policy == utils.WipePolicyNone {
+		return
+	}
+	annotation[utils.VolumeWipePolicy] = policy
+	if passes > 1 {
+		annotation[utils.VolumeWipePasses] = strconv.Itoa(passes)
+	}
+}
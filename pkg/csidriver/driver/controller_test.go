@@ -16,8 +16,22 @@
 package driver
 
 import (
+	"context"
 	"errors"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/csidriver/driver/k8s"
+	"github.com/carina-io/carina/pkg/quota"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/mutx"
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"testing"
 )
 
@@ -46,3 +60,136 @@ func TestConvertRequestCapacity(t *testing.T) {
 	}
 
 }
+
+func TestVolumeFsType(t *testing.T) {
+	mount := func(fsType string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType}}}
+	}
+	block := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}
+
+	table := []struct {
+		req    *csi.CreateVolumeRequest
+		fsType string
+	}{
+		{req: &csi.CreateVolumeRequest{VolumeCapabilities: []*csi.VolumeCapability{mount("")}}, fsType: "ext4"},
+		{req: &csi.CreateVolumeRequest{VolumeCapabilities: []*csi.VolumeCapability{mount("xfs")}}, fsType: "xfs"},
+		{req: &csi.CreateVolumeRequest{VolumeCapabilities: []*csi.VolumeCapability{mount("xfs")}, Parameters: map[string]string{utils.VolumeFsType: "ext4"}}, fsType: "ext4"},
+		{req: &csi.CreateVolumeRequest{VolumeCapabilities: []*csi.VolumeCapability{block}}, fsType: ""},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		a.Equal(e.fsType, volumeFsType(e.req))
+	}
+}
+
+func TestLogicVolumeOwner(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	a.NoError(carinav1.AddToScheme(scheme))
+	backend := &carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: utils.LogicVolumeNamespace, UID: "uid-pvc-1"},
+		Spec:       carinav1.LogicVolumeSpec{NameSpace: "db", Pvc: "data", DeviceGroup: "carina-vg-hdd", Size: resource.MustParse("10Gi")},
+		Status:     carinav1.LogicVolumeStatus{VolumeID: "volume-pvc-1"},
+	}
+	lvService := &k8s.LogicVolumeService{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(backend).Build()}
+
+	// 通过typed client读取的对象没有TypeMeta
+	lv, err := lvService.GetLogicVolume(ctx, "volume-pvc-1")
+	a.NoError(err)
+	a.Empty(lv.Kind)
+
+	owner := logicVolumeOwner(lv)
+	a.Equal("carina.storage.io/v1", owner.APIVersion)
+	a.Equal("LogicVolume", owner.Kind)
+	a.Equal("pvc-1", owner.Name)
+	a.EqualValues("uid-pvc-1", owner.UID)
+	a.True(*owner.Controller)
+
+	cache := carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "cache-pvc-1", Namespace: utils.LogicVolumeNamespace, OwnerReferences: []metav1.OwnerReference{owner}},
+		Spec:       carinav1.LogicVolumeSpec{NameSpace: "db", Pvc: "data", DeviceGroup: "carina-vg-ssd", Size: resource.MustParse("1Gi")},
+	}
+	// 缓存卷计入容量，不计为单独的卷
+	total, _ := quota.Usage([]carinav1.LogicVolume{*lv, cache}, "db")
+	a.EqualValues(1, total.Volumes)
+	a.EqualValues(11<<30, total.Capacity.Value())
+}
+
+func TestSnapshotSource(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	newSnapshot := func(name string, exported bool) client.Object {
+		lv := &carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   utils.LogicVolumeNamespace,
+				Annotations: map[string]string{utils.SnapshotSource: "pvc-1", utils.SnapshotExportTarget: "s3://backup/cluster-a"},
+			},
+			Spec:   carinav1.LogicVolumeSpec{NodeName: "node1", DeviceGroup: "carina-vg-ssd", Size: resource.MustParse("10Gi")},
+			Status: carinav1.LogicVolumeStatus{VolumeID: "snap-" + name},
+		}
+		if exported {
+			lv.Status.Conditions = []metav1.Condition{{Type: utils.ConditionExported, Status: metav1.ConditionTrue, Reason: "Uploaded"}}
+		}
+		return lv
+	}
+	volume := &carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: utils.LogicVolumeNamespace},
+		Status:     carinav1.LogicVolumeStatus{VolumeID: "volume-pvc-1"},
+	}
+
+	// fake client不支持按volumeID的字段选择，每个用例只有一个LogicVolume
+	table := []struct {
+		name       string
+		lv         client.Object
+		snapshotID string
+		node       string
+		requestGb  int64
+		code       codes.Code
+	}{
+		{name: "node not selected", lv: newSnapshot("local", false), snapshotID: "snap-local", requestGb: 10, code: codes.OK},
+		{name: "node of snapshot", lv: newSnapshot("local", false), snapshotID: "snap-local", node: "node1", requestGb: 20, code: codes.OK},
+		{name: "other node", lv: newSnapshot("local", false), snapshotID: "snap-local", node: "node2", requestGb: 10, code: codes.InvalidArgument},
+		{name: "other node exported", lv: newSnapshot("exported", true), snapshotID: "snap-exported", node: "node2", requestGb: 10, code: codes.OK},
+		{name: "too small", lv: newSnapshot("exported", true), snapshotID: "snap-exported", node: "node2", requestGb: 5, code: codes.OutOfRange},
+		{name: "not a snapshot", lv: volume, snapshotID: "volume-pvc-1", requestGb: 10, code: codes.InvalidArgument},
+		{name: "missing", snapshotID: "snap-missing", requestGb: 10, code: codes.NotFound},
+	}
+	for _, c := range table {
+		builder := fake.NewClientBuilder().WithScheme(scheme)
+		if c.lv != nil {
+			builder = builder.WithObjects(c.lv)
+		}
+		s := controllerService{lvService: &k8s.LogicVolumeService{Client: builder.Build()}}
+		snapshot, err := s.snapshotSource(context.Background(), c.snapshotID, c.node, c.requestGb)
+		assert.Equal(t, c.code, status.Code(err), "%s: %v", c.name, err)
+		if c.code == codes.OK {
+			assert.Equal(t, c.snapshotID, snapshot.Status.VolumeID, c.name)
+		}
+	}
+}
+
+func TestCreateSnapshotExportTarget(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	volume := &carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: utils.LogicVolumeNamespace, Annotations: map[string]string{utils.VolumeManagerType: utils.LvmVolumeType}},
+		Spec:       carinav1.LogicVolumeSpec{NodeName: "node1", DeviceGroup: "carina-vg-ssd", Size: resource.MustParse("10Gi")},
+		Status:     carinav1.LogicVolumeStatus{VolumeID: "volume-pvc-1"},
+	}
+	s := controllerService{
+		lvService: &k8s.LogicVolumeService{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(volume).Build()},
+		mutex:     mutx.NewGlobalLocks(),
+	}
+
+	for _, target := range []string{"https://backup/cluster-a", "s3:///cluster-a"} {
+		_, err := s.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+			Name:           "snapshot-1",
+			SourceVolumeId: "volume-pvc-1",
+			Parameters:     map[string]string{utils.SnapshotExportTarget: target},
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), target)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"os"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultDeviceMode 只设置属主时组内用户也可以读写
+const defaultDeviceMode = 0660
+
+// setDeviceOwnership 按storageclass参数设置块设备节点的属主和权限
+// Non-root containers can then open the device without an initContainer running chown.
+// With device-owner "pod" the uid is the runAsUser and the gid the fsGroup, or runAsGroup
+// without fsGroup, of the pod securityContext; ids the pod does not set stay root.
+func (s *nodeService) setDeviceOwnership(ctx context.Context, req *csi.NodePublishVolumeRequest) error {
+	volumeContext := req.GetVolumeContext()
+	uid, gid, fromPod, err := utils.ParseDeviceOwner(volumeContext[utils.VolumeDeviceOwner])
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	mode, err := utils.ParseDeviceMode(volumeContext[utils.VolumeDeviceMode])
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if fromPod {
+		uid, gid, err = s.podOwner(ctx, volumeContext[utils.PodNamespaceKey], volumeContext[utils.PodNameKey])
+		if err != nil {
+			return err
+		}
+	}
+	if mode == 0 && (uid >= 0 || gid >= 0) {
+		mode = defaultDeviceMode
+	}
+	if mode == 0 {
+		return nil
+	}
+
+	target := req.GetTargetPath()
+	if err := os.Chown(target, uid, gid); err != nil {
+		return status.Errorf(codes.Internal, "chown failed: target=%s, uid=%d, gid=%d, error=%v", target, uid, gid, err)
+	}
+	if err := os.Chmod(target, os.FileMode(mode)); err != nil {
+		return status.Errorf(codes.Internal, "chmod %o failed: target=%s, error=%v", mode, target, err)
+	}
+	log.Infof("set owner %d:%d mode %o of block device %s", uid, gid, mode, target)
+	return nil
+}
+
+// podOwner 从pod的securityContext中取uid和gid，未设置的返回-1
+func (s *nodeService) podOwner(ctx context.Context, namespace, name string) (int, int, error) {
+	if name == "" {
+		return -1, -1, status.Errorf(codes.FailedPrecondition, "%s is %s, but no pod info is passed, set podInfoOnMount of the CSIDriver", utils.VolumeDeviceOwner, utils.DeviceOwnerPod)
+	}
+	pod := &corev1.Pod{}
+	if err := s.k8sLVService.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, pod); err != nil {
+		return -1, -1, status.Errorf(codes.Internal, "get pod %s/%s failed: %v", namespace, name, err)
+	}
+	uid, gid := -1, -1
+	sc := pod.Spec.SecurityContext
+	if sc == nil {
+		return uid, gid, nil
+	}
+	if sc.RunAsUser != nil {
+		uid = int(*sc.RunAsUser)
+	}
+	if sc.FSGroup != nil {
+		gid = int(*sc.FSGroup)
+	} else if sc.RunAsGroup != nil {
+		gid = int(*sc.RunAsGroup)
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,51 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"strconv"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/devicemanager/drbd"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// replicatedVolume 卷的两个副本由drbd同步
+func replicatedVolume(lvr *carinav1.LogicVolume) bool {
+	return lvr.Annotations[utils.VolumeDrbdMinor] != ""
+}
+
+// drbdDevice returns the drbd device of a replicated volume in place of its lvm volume. The
+// device has the same minor on both nodes of the volume and exists once the node brought the
+// resource up, opening it for writing makes this node the primary.
+func (s *nodeService) drbdDevice(lvr *carinav1.LogicVolume) (*types.LvInfo, error) {
+	minor, err := strconv.Atoi(lvr.Annotations[utils.VolumeDrbdMinor])
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid %s of volume %s: %v", utils.VolumeDrbdMinor, lvr.Name, err)
+	}
+	if s.nodeName != lvr.Spec.NodeName && s.nodeName != lvr.Annotations[utils.VolumeReplicaNode] {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s has no replica on node %s", lvr.Name, s.nodeName)
+	}
+	if _, err := os.Stat(drbd.DevicePath(minor)); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "drbd device of volume %s is not up on node %s yet: %v", lvr.Name, s.nodeName, err)
+	}
+	return &types.LvInfo{LVName: lvr.Status.VolumeID, LVKernelMajor: drbd.Major, LVKernelMinor: uint32(minor)}, nil
+}
@@ -0,0 +1,131 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	corev1 "k8s.io/api/core/v1"
+	utilexec "k8s.io/utils/exec"
+)
+
+// fsckPolicy 卷的文件系统修复策略，默认auto
+func fsckPolicy(volumeContext map[string]string) string {
+	switch policy := volumeContext[utils.VolumeFsckPolicy]; policy {
+	case utils.FsckPolicyNever, utils.FsckPolicyForce:
+		return policy
+	}
+	return utils.FsckPolicyAuto
+}
+
+// repairCommand 修复文件系统的命令，force时e2fsck修复所有问题，xfs_repair丢弃无法回放的日志
+func repairCommand(fsType, device string, force bool) (string, []string, bool) {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		if force {
+			return "e2fsck", []string{"-f", "-y", device}, true
+		}
+		return "e2fsck", []string{"-p", device}, true
+	case "xfs":
+		if force {
+			return "xfs_repair", []string{"-L", device}, true
+		}
+		return "xfs_repair", []string{device}, true
+	}
+	return "", nil, false
+}
+
+// repairSucceeded e2fsck的退出码0-3表示没有错误或错误已修复，xfs_repair只有0表示成功
+func repairSucceeded(fsType string, code int) bool {
+	if fsType == "xfs" {
+		return code == 0
+	}
+	return code >= 0 && code < 4
+}
+
+// mountVolume 挂载卷，新卷先格式化，已有文件系统的卷按fsck策略修复
+// With never the filesystem is mounted as is. With auto the volume is mounted as before, the
+// kubelet mounter checks ext filesystems with fsck -a, and a failed mount is repaired and tried
+// again. With force the filesystem is repaired before every mount.
+func (s *nodeService) mountVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, device, fsType, current string, options []string) error {
+	target := req.GetTargetPath()
+	if current == "" {
+		return s.mounter.FormatAndMount(device, target, fsType, options)
+	}
+	switch fsckPolicy(req.GetVolumeContext()) {
+	case utils.FsckPolicyNever:
+		return s.mounter.Mount(device, target, fsType, options)
+	case utils.FsckPolicyForce:
+		if err := s.repairFilesystem(ctx, req, device, current, true); err != nil {
+			return err
+		}
+		return s.mounter.Mount(device, target, fsType, options)
+	}
+	mountErr := s.mounter.FormatAndMount(device, target, fsType, options)
+	if mountErr == nil {
+		return nil
+	}
+	log.FromContext(ctx).Warnf("mount %s failed, repair filesystem %s: %s", device, current, mountErr.Error())
+	if err := s.repairFilesystem(ctx, req, device, current, false); err != nil {
+		return fmt.Errorf("%v, %v", mountErr, err)
+	}
+	return s.mounter.Mount(device, target, fsType, options)
+}
+
+// repairFilesystem 修复卷的文件系统，修复结果作为LogicVolume的事件记录
+func (s *nodeService) repairFilesystem(ctx context.Context, req *csi.NodePublishVolumeRequest, device, fsType string, force bool) error {
+	logger := log.FromContext(ctx)
+	command, args, ok := repairCommand(fsType, device, force)
+	if !ok {
+		return fmt.Errorf("repairing %s filesystems is not supported", fsType)
+	}
+	out, err := s.mounter.Exec.Command(command, args...).CombinedOutput()
+	code := 0
+	if err != nil {
+		code = -1
+		if exitErr, ok := err.(utilexec.ExitError); ok {
+			code = exitErr.ExitStatus()
+		}
+	}
+	output := strings.TrimSpace(string(out))
+	if len(output) > 512 {
+		output = "..." + output[len(output)-512:]
+	}
+	cmdline := command + " " + strings.Join(args, " ")
+
+	eventType, reason := corev1.EventTypeNormal, "FilesystemRepaired"
+	message := fmt.Sprintf("%s exited with %d: %s", cmdline, code, output)
+	succeeded := repairSucceeded(fsType, code)
+	if succeeded {
+		logger.Infof("repaired filesystem of %s: %s", req.GetVolumeId(), message)
+	} else {
+		eventType, reason = corev1.EventTypeWarning, "FilesystemRepairFailed"
+		logger.Errorf("repair filesystem of %s failed: %s", req.GetVolumeId(), message)
+	}
+	if lvr, err := s.k8sLVService.GetLogicVolume(ctx, req.GetVolumeId()); err == nil {
+		s.k8sLVService.Recorder.Event(lvr, eventType, reason, message)
+	}
+	if !succeeded {
+		return fmt.Errorf("filesystem repair failed: %s", message)
+	}
+	return nil
+}
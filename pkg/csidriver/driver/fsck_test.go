@@ -0,0 +1,67 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFsckPolicy(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(utils.FsckPolicyAuto, fsckPolicy(nil))
+	a.Equal(utils.FsckPolicyAuto, fsckPolicy(map[string]string{utils.VolumeFsckPolicy: "sometimes"}))
+	a.Equal(utils.FsckPolicyNever, fsckPolicy(map[string]string{utils.VolumeFsckPolicy: "never"}))
+	a.Equal(utils.FsckPolicyForce, fsckPolicy(map[string]string{utils.VolumeFsckPolicy: "force"}))
+}
+
+func TestRepairCommand(t *testing.T) {
+	table := []struct {
+		fsType  string
+		force   bool
+		command string
+		args    []string
+		ok      bool
+	}{
+		{fsType: "ext4", command: "e2fsck", args: []string{"-p", "/dev/sdb"}, ok: true},
+		{fsType: "ext3", force: true, command: "e2fsck", args: []string{"-f", "-y", "/dev/sdb"}, ok: true},
+		{fsType: "xfs", command: "xfs_repair", args: []string{"/dev/sdb"}, ok: true},
+		{fsType: "xfs", force: true, command: "xfs_repair", args: []string{"-L", "/dev/sdb"}, ok: true},
+		{fsType: "btrfs"},
+	}
+	for _, e := range table {
+		command, args, ok := repairCommand(e.fsType, "/dev/sdb", e.force)
+		assert.Equal(t, e.command, command, e.fsType)
+		assert.Equal(t, e.args, args, e.fsType)
+		assert.Equal(t, e.ok, ok, e.fsType)
+	}
+}
+
+func TestRepairSucceeded(t *testing.T) {
+	a := assert.New(t)
+	// e2fsck: 1 errors corrected, 2 reboot needed, 4 errors left
+	a.True(repairSucceeded("ext4", 0))
+	a.True(repairSucceeded("ext4", 1))
+	a.False(repairSucceeded("ext4", 4))
+	a.False(repairSucceeded("ext4", -1))
+	// xfs_repair: 2 dirty log, 1 corruption left
+	a.True(repairSucceeded("xfs", 0))
+	a.False(repairSucceeded("xfs", 1))
+	a.False(repairSucceeded("xfs", 2))
+}
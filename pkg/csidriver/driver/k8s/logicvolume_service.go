@@ -24,15 +24,19 @@ import (
 	"time"
 
 	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/tracing"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
+	"go.opentelemetry.io/otel/attribute"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -50,7 +54,15 @@ var ErrVolumeNotFound = errors.New("VolumeID is not found")
 // LogicVolumeService represents service for LogicVolume.
 type LogicVolumeService struct {
 	client.Client
-	mu sync.Mutex
+	// APIReader reads the LogicVolume of a CreateVolume bypassing the cache. After a leader
+	// change the cache of the new carina-controller may not have the LogicVolume created by
+	// the previous one yet.
+	APIReader client.Reader
+	mu        sync.Mutex
+	// quotaMu serializes quota checks with the creation of the checked volumes
+	quotaMu sync.Mutex
+	// Recorder records events of the node service on the LogicVolumes
+	Recorder record.EventRecorder
 }
 
 const (
@@ -71,12 +83,13 @@ func NewLogicVolumeService(mgr manager.Manager) (*LogicVolumeService, error) {
 		return nil, err
 	}
 
-	return &LogicVolumeService{Client: mgr.GetClient()}, nil
+	return &LogicVolumeService{Client: mgr.GetClient(), APIReader: mgr.GetAPIReader(), Recorder: mgr.GetEventRecorderFor("logicvolume-node")}, nil
 }
 
 // CreateVolume creates volume
 func (s *LogicVolumeService) CreateVolume(ctx context.Context, namespace, pvc, node, deviceGroup, name string, requestGb int64, owner metav1.OwnerReference, annotation map[string]string) (string, uint32, uint32, error) {
-	log.Info("k8s.CreateVolume called name ", name, " node ", node, " deviceGroup ", deviceGroup, " size_gb ", requestGb)
+	logger := log.FromContext(ctx)
+	logger.Info("k8s.CreateVolume called name ", name, " node ", node, " deviceGroup ", deviceGroup, " size_gb ", requestGb)
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -102,9 +115,16 @@ func (s *LogicVolumeService) CreateVolume(ctx context.Context, namespace, pvc, n
 	if owner.Name != "" {
 		lv.OwnerReferences = []metav1.OwnerReference{owner}
 	}
+	// 节点创建lv时接续CreateVolume的trace
+	if lv.Annotations == nil {
+		lv.Annotations = map[string]string{}
+	}
+	tracing.Inject(ctx, lv.Annotations)
 
+	// a CreateVolume retried after a leader change finds the LogicVolume of the interrupted
+	// one and waits for it instead of creating the volume a second time
 	existingLV := new(carinav1.LogicVolume)
-	err := s.Get(ctx, client.ObjectKey{Name: name, Namespace: utils.LogicVolumeNamespace}, existingLV)
+	err := s.APIReader.Get(ctx, client.ObjectKey{Name: name, Namespace: utils.LogicVolumeNamespace}, existingLV)
 	if err != nil {
 		if !apierrors.IsNotFound(err) {
 			return "", 0, 0, err
@@ -114,8 +134,9 @@ func (s *LogicVolumeService) CreateVolume(ctx context.Context, namespace, pvc, n
 		if err != nil {
 			return "", 0, 0, err
 		}
-		log.Info("created LogicVolume CRD name ", name)
+		logger.Info("created LogicVolume CRD name ", name)
 	} else {
+		logger.Info("LogicVolume CRD already exists name ", name, " node ", existingLV.Spec.NodeName)
 		// LV with same name was found; check compatibility
 		// skip check of capabilities because (1) we allow both of two access types, and (2) we allow only one access mode
 		// for ease of comparison, sizes are compared strictly, not by compatibility of ranges
@@ -125,8 +146,11 @@ func (s *LogicVolumeService) CreateVolume(ctx context.Context, namespace, pvc, n
 		// compatible LV was found
 	}
 
+	// 等待节点创建lv的时间单独记录，节点上的lvcreate是它的兄弟span
+	ctx, span := tracing.Start(ctx, "wait-logicvolume", attribute.String("node", node))
+	defer span.End()
 	for {
-		log.Info("waiting for setting 'status.volumeID' name ", name)
+		logger.Info("waiting for setting 'status.volumeID' name ", name)
 		select {
 		case <-ctx.Done():
 			return "", 0, 0, ctx.Err()
@@ -135,19 +159,23 @@ func (s *LogicVolumeService) CreateVolume(ctx context.Context, namespace, pvc, n
 
 		var newLV carinav1.LogicVolume
 		err := s.Get(ctx, client.ObjectKey{Name: name, Namespace: utils.LogicVolumeNamespace}, &newLV)
+		if apierrors.IsNotFound(err) {
+			// 缓存尚未同步刚创建或接管的LogicVolume
+			continue
+		}
 		if err != nil {
-			log.Error(err, " failed to get LogicVolume name ", name)
+			logger.Error(err, " failed to get LogicVolume name ", name)
 			return "", 0, 0, err
 		}
 		if newLV.Status.VolumeID != "" {
-			log.Info("create complete k8s.LogicVolume volume_id ", newLV.Status.VolumeID)
+			logger.Info("create complete k8s.LogicVolume volume_id ", newLV.Status.VolumeID)
 			return newLV.Status.VolumeID, newLV.Status.DeviceMajor, newLV.Status.DeviceMinor, nil
 		}
 		if newLV.Status.Code != codes.OK {
 			err := s.Delete(ctx, &newLV)
 			if err != nil {
 				// log this error but do not return this error, because newLV.Status.Message is more important
-				log.Error(err, " failed to delete LogicVolume")
+				logger.Error(err, " failed to delete LogicVolume")
 			}
 
 			return "", 0, 0, status.Error(newLV.Status.Code, newLV.Status.Message)
@@ -157,12 +185,13 @@ func (s *LogicVolumeService) CreateVolume(ctx context.Context, namespace, pvc, n
 
 // DeleteVolume deletes volume
 func (s *LogicVolumeService) DeleteVolume(ctx context.Context, volumeID string) error {
-	log.Info("k8s.DeleteVolume called volumeID ", volumeID)
+	logger := log.FromContext(ctx)
+	logger.Info("k8s.DeleteVolume called volumeID ", volumeID)
 
 	lv, err := s.GetLogicVolume(ctx, volumeID)
 	if err != nil {
 		if err == ErrVolumeNotFound {
-			log.Info("volume is not found volume_id ", volumeID)
+			logger.Info("volume is not found volume_id ", volumeID)
 			return nil
 		}
 		return err
@@ -178,7 +207,7 @@ func (s *LogicVolumeService) DeleteVolume(ctx context.Context, volumeID string)
 
 	// wait until delete the target volume
 	for {
-		log.Info("waiting for delete LogicalVolume name ", lv.Name)
+		logger.Info("waiting for delete LogicalVolume name ", lv.Name)
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -190,7 +219,7 @@ func (s *LogicVolumeService) DeleteVolume(ctx context.Context, volumeID string)
 			if apierrors.IsNotFound(err) {
 				return nil
 			}
-			log.Error(err, " failed to get LogicalVolume name ", lv.Name)
+			logger.Error(err, " failed to get LogicalVolume name ", lv.Name)
 			return err
 		}
 	}
@@ -198,7 +227,8 @@ func (s *LogicVolumeService) DeleteVolume(ctx context.Context, volumeID string)
 
 // ExpandVolume expands volume
 func (s *LogicVolumeService) ExpandVolume(ctx context.Context, volumeID string, requestGb int64) error {
-	log.Info("k8s.ExpandVolume called volumeID ", volumeID, " requestGb ", requestGb)
+	logger := log.FromContext(ctx)
+	logger.Info("k8s.ExpandVolume called volumeID ", volumeID, " requestGb ", requestGb)
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -214,7 +244,7 @@ func (s *LogicVolumeService) ExpandVolume(ctx context.Context, volumeID string,
 
 	// wait until carina-node expands the target volume
 	for {
-		log.Info("waiting for update of 'status.currentSize' name ", lv.Name)
+		logger.Info("waiting for update of 'status.currentSize' name ", lv.Name)
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -224,19 +254,19 @@ func (s *LogicVolumeService) ExpandVolume(ctx context.Context, volumeID string,
 		var changedLV carinav1.LogicVolume
 		err := s.Get(ctx, client.ObjectKey{Name: lv.Name, Namespace: utils.LogicVolumeNamespace}, &changedLV)
 		if err != nil {
-			log.Error(err, " failed to get LogicVolume name ", lv.Name)
+			logger.Error(err, " failed to get LogicVolume name ", lv.Name)
 			return err
 		}
 		if changedLV.Status.CurrentSize == nil {
 			return errors.New("status.currentSize should not be nil")
 		}
 		if changedLV.Status.CurrentSize.Value() != changedLV.Spec.Size.Value() {
-			log.Info("failed to match current size and requested size current ", changedLV.Status.CurrentSize.Value(), " requested ", changedLV.Spec.Size.Value())
+			logger.Info("failed to match current size and requested size current ", changedLV.Status.CurrentSize.Value(), " requested ", changedLV.Spec.Size.Value())
 			continue
 		}
 
 		if changedLV.Status.Code != codes.OK {
-			log.Infof("volume expand success %s", volumeID)
+			logger.Infof("volume expand success %s", volumeID)
 			return status.Error(changedLV.Status.Code, changedLV.Status.Message)
 		}
 
@@ -260,8 +290,32 @@ func (s *LogicVolumeService) GetLogicVolume(ctx context.Context, volumeID string
 	return &lvList.Items[0], nil
 }
 
+// ListSnapshots returns the snapshot LogicVolumes taken of the volume.
+func (s *LogicVolumeService) ListSnapshots(ctx context.Context, volumeID string) ([]carinav1.LogicVolume, error) {
+	lv, err := s.GetLogicVolume(ctx, volumeID)
+	if err != nil {
+		if err == ErrVolumeNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	lvList := new(carinav1.LogicVolumeList)
+	if err := s.List(ctx, lvList); err != nil {
+		return nil, err
+	}
+	snapshots := []carinav1.LogicVolume{}
+	for _, item := range lvList.Items {
+		if item.Annotations[utils.SnapshotSource] == lv.Name {
+			snapshots = append(snapshots, item)
+		}
+	}
+	return snapshots, nil
+}
+
 // UpdateLogicVolumeCurrentSize UpdateCurrentSize updates .Status.CurrentSize of LogicVolume.
 func (s *LogicVolumeService) UpdateLogicVolumeCurrentSize(ctx context.Context, volumeID string, size *resource.Quantity) error {
+	logger := log.FromContext(ctx)
 	for {
 		select {
 		case <-ctx.Done():
@@ -278,10 +332,10 @@ func (s *LogicVolumeService) UpdateLogicVolumeCurrentSize(ctx context.Context, v
 
 		if err := s.Status().Update(ctx, lv); err != nil {
 			if apierrors.IsConflict(err) {
-				log.Info("detect conflict when LogicVolume status update", "name", lv.Name)
+				logger.Info("detect conflict when LogicVolume status update", "name", lv.Name)
 				continue
 			}
-			log.Error(err, "failed to update LogicVolume status", "name", lv.Name)
+			logger.Error(err, "failed to update LogicVolume status", "name", lv.Name)
 			return err
 		}
 
@@ -291,6 +345,7 @@ func (s *LogicVolumeService) UpdateLogicVolumeCurrentSize(ctx context.Context, v
 
 // UpdateLogicVolumeSpecSize UpdateSpecSize updates .Spec.Size of LogicVolume.
 func (s *LogicVolumeService) UpdateLogicVolumeSpecSize(ctx context.Context, volumeID string, size *resource.Quantity) error {
+	logger := log.FromContext(ctx)
 	for {
 		select {
 		case <-ctx.Done():
@@ -311,10 +366,40 @@ func (s *LogicVolumeService) UpdateLogicVolumeSpecSize(ctx context.Context, volu
 
 		if err := s.Update(ctx, lv); err != nil {
 			if apierrors.IsConflict(err) {
-				log.Info("detect conflict when LogicVolume spec update", "name", lv.Name)
+				logger.Info("detect conflict when LogicVolume spec update", "name", lv.Name)
+				continue
+			}
+			logger.Error(err, "failed to update LogicVolume spec", "name", lv.Name)
+			return err
+		}
+
+		return nil
+	}
+}
+
+// SetLogicVolumeCondition sets or replaces a condition in .Status.Conditions of LogicVolume.
+func (s *LogicVolumeService) SetLogicVolumeCondition(ctx context.Context, volumeID string, condition metav1.Condition) error {
+	logger := log.FromContext(ctx)
+	for {
+		lv, err := s.GetLogicVolume(ctx, volumeID)
+		if err != nil {
+			return err
+		}
+
+		condition.ObservedGeneration = lv.Generation
+		meta.SetStatusCondition(&lv.Status.Conditions, condition)
+
+		if err := s.Status().Update(ctx, lv); err != nil {
+			if apierrors.IsConflict(err) {
+				logger.Info("detect conflict when LogicVolume condition update", "name", lv.Name)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(1 * time.Second):
+				}
 				continue
 			}
-			log.Error(err, "failed to update LogicVolume spec", "name", lv.Name)
+			logger.Error(err, "failed to update LogicVolume condition", "name", lv.Name)
 			return err
 		}
 
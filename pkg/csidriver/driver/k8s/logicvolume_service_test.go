@@ -0,0 +1,95 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// laggingCache 缓存还没有同步LogicVolume时前misses次Get返回NotFound，
+// 创建的LogicVolume由节点立即设置volumeID
+type laggingCache struct {
+	client.Client
+	misses  int
+	created int
+}
+
+func (c *laggingCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if c.misses > 0 {
+		c.misses--
+		return apierrors.NewNotFound(carinav1.GroupVersion.WithResource("logicvolumes").GroupResource(), key.Name)
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *laggingCache) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.created++
+	lv := obj.(*carinav1.LogicVolume)
+	lv.Status.VolumeID = "volume-" + lv.Name
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestCreateVolumeAPIReader(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	existing := func(size string) client.Object {
+		return &carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: utils.LogicVolumeNamespace},
+			Spec:       carinav1.LogicVolumeSpec{NodeName: "node1", DeviceGroup: "carina-vg-ssd", Size: resource.MustParse(size)},
+			Status:     carinav1.LogicVolumeStatus{VolumeID: "volume-pvc-1-previous-leader"},
+		}
+	}
+
+	table := []struct {
+		name     string
+		objects  []client.Object
+		volumeID string
+		created  int
+		code     codes.Code
+	}{
+		// 上一个leader创建的LogicVolume还不在缓存中，不能重复创建
+		{name: "created by previous leader", objects: []client.Object{existing("1Gi")}, volumeID: "volume-pvc-1-previous-leader"},
+		{name: "incompatible", objects: []client.Object{existing("2Gi")}, code: codes.AlreadyExists},
+		{name: "new volume", volumeID: "volume-pvc-1", created: 1},
+	}
+
+	for _, c := range table {
+		t.Run(c.name, func(t *testing.T) {
+			apiserver := fake.NewClientBuilder().WithScheme(scheme).WithObjects(c.objects...).Build()
+			cache := &laggingCache{Client: apiserver, misses: 1}
+			s := &LogicVolumeService{Client: cache, APIReader: apiserver}
+
+			volumeID, _, _, err := s.CreateVolume(context.Background(), "default", "data", "node1", "carina-vg-ssd", "pvc-1", 1, metav1.OwnerReference{}, nil)
+			assert.Equal(t, c.code, status.Code(err), err)
+			assert.Equal(t, c.volumeID, volumeID)
+			assert.Equal(t, c.created, cache.created)
+		})
+	}
+}
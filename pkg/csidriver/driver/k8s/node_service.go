@@ -33,6 +33,7 @@ import (
 	"github.com/carina-io/carina/pkg/version"
 	"github.com/carina-io/carina/utils"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/rand"
 
@@ -51,7 +52,7 @@ type nodeService interface {
 	// SelectVolumeNode 支持 volume size 及 topology match
 	SelectVolumeNode(ctx context.Context, request int64, deviceGroup string, requirement *csi.TopologyRequirement) (string, string, map[string]string, error)
 	GetCapacityByNodeName(ctx context.Context, nodeName, deviceGroup string) (int64, error)
-	GetTotalCapacity(ctx context.Context, deviceGroup string, topology *csi.Topology) (int64, error)
+	GetTotalCapacity(ctx context.Context, deviceGroup string, topology *csi.Topology) (int64, int64, error)
 	SelectDeviceGroup(ctx context.Context, request int64, nodeName string) (string, error)
 	// HaveSelectedNode sc WaitForConsumer
 	HaveSelectedNode(ctx context.Context, namespace, name string) (string, error)
@@ -272,26 +273,23 @@ func (s NodeService) GetCapacityByNodeName(ctx context.Context, name, deviceGrou
 	return 0, errors.New("device group not found")
 }
 
-// GetTotalCapacity returns total VG capacity of all nodes.
-func (s NodeService) GetTotalCapacity(ctx context.Context, deviceGroup string, topology *csi.Topology) (int64, error) {
+// GetTotalCapacity returns the allocatable bytes of the device group on the nodes of the topology,
+// and the largest volume that fits, e.g. the largest free disk of a raw group.
+// An empty device group stands for all lvm volume groups.
+func (s NodeService) GetTotalCapacity(ctx context.Context, deviceGroup string, topology *csi.Topology) (int64, int64, error) {
 
 	nl, err := s.getNodes(ctx)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
 	nsr, err := s.getNodeStorageResources(ctx)
 	if err != nil {
-		return 0, err
-	}
-	var volumeType string
-	if version.CheckRawDeviceGroup(deviceGroup) {
-		volumeType = utils.RawVolumeType
-	} else {
-		volumeType = utils.LvmVolumeType
+		return 0, 0, err
 	}
+	raw := deviceGroup != "" && version.CheckRawDeviceGroup(deviceGroup)
 
-	capacity := int64(0)
+	capacity, maximum := int64(0), int64(0)
 	for _, node := range nl.Items {
 		// topology selector
 		if topology != nil {
@@ -306,44 +304,47 @@ func (s NodeService) GetTotalCapacity(ctx context.Context, deviceGroup string, t
 		if !exists {
 			continue
 		}
+		total, largest := allocatableBytes(status.Allocatable, deviceGroup, raw)
+		capacity += total
+		if largest > maximum {
+			maximum = largest
+		}
+	}
+	return capacity, maximum, nil
+}
 
-		for key, v := range status.Capacity {
-
-			if deviceGroup == "" && strings.HasPrefix(key, utils.DeviceCapacityKeyPrefix) {
-				strArr := strings.Split(key, "/")
-				if volumeType == utils.RawVolumeType {
-					if version.CheckRawDeviceGroup(strArr[1]) {
-						capacity += v.Value()
-					}
-
-				}
-				if volumeType == utils.LvmVolumeType {
-					if !version.CheckRawDeviceGroup(strArr[1]) {
-						capacity += v.Value()
-					}
-				}
-
-			} else if key == deviceGroup || key == utils.DeviceCapacityKeyPrefix+deviceGroup {
-				strArr := strings.Split(key, "/")
-
-				if volumeType == utils.RawVolumeType {
-					if version.CheckRawDeviceGroup(strArr[1]) {
-						capacity += v.Value()
-					}
-
-				}
-				if volumeType == utils.LvmVolumeType {
-					if !version.CheckRawDeviceGroup(strArr[1]) {
-						capacity += v.Value()
-					}
-				}
-			}
+// allocatableBytes 汇总节点上磁盘组的可分配容量，status中的容量单位为Gi
+// The keys are carina.storage.io/<volume group> for lvm and carina.storage.io/<raw group>/<disk> for raw
+// disks, a raw volume is a partition of a single disk. largest is the largest volume group or disk.
+func allocatableBytes(allocatable map[string]resource.Quantity, deviceGroup string, raw bool) (total int64, largest int64) {
+	for key, v := range allocatable {
+		if !strings.HasPrefix(key, utils.DeviceCapacityKeyPrefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(key, utils.DeviceCapacityKeyPrefix), "/", 2)
+		if raw != (len(parts) == 2) {
+			continue
+		}
+		if deviceGroup != "" && parts[0] != deviceGroup {
+			continue
+		}
+		if !raw && deviceGroup == "" && version.CheckRawDeviceGroup(parts[0]) {
+			continue
+		}
+		bytes := v.Value() << 30
+		if bytes <= 0 {
+			continue
+		}
+		total += bytes
+		if bytes > largest {
+			largest = bytes
 		}
 	}
-	return capacity, nil
+	return total, largest
 }
 
 func (s NodeService) SelectDeviceGroup(ctx context.Context, request int64, nodeName string, volumeType string, exclusivityDisk bool) (string, error) {
+	logger := log.FromContext(ctx)
 	var selectDeviceGroup string
 
 	nl, err := s.getNodes(ctx)
@@ -383,7 +384,7 @@ func (s NodeService) SelectDeviceGroup(ctx context.Context, request int64, nodeN
 				if volumeType == utils.RawVolumeType {
 					if version.CheckRawDeviceGroup(strArr[1]) {
 						//skip exclusivityDisk
-						log.Infof("skip:%s ; disk: key %s; value:%v", lvs, strArr[1]+"/"+strArr[2], value.Value())
+						logger.Infof("skip:%s ; disk: key %s; value:%v", lvs, strArr[1]+"/"+strArr[2], value.Value())
 						if utils.ContainsString(lvs, strArr[1]+"/"+strArr[2]) && !exclusivityDisk {
 							continue
 						}
@@ -392,12 +393,12 @@ func (s NodeService) SelectDeviceGroup(ctx context.Context, request int64, nodeN
 							if strings.Contains(key, disk.Name) {
 								device := disko.Disk{}
 								utils.Fill(disk, &device)
-								log.Info("disk-src:", disk)
-								log.Info(nodeName, ": select disk", disk.Path, " exclusivityDisk: ", exclusivityDisk, " partitions: ", len(disk.Partitions))
+								logger.Info("disk-src:", disk)
+								logger.Info(nodeName, ": select disk", disk.Path, " exclusivityDisk: ", exclusivityDisk, " partitions: ", len(disk.Partitions))
 								//check freespace
-								log.Info("disk-dst:", device)
+								logger.Info("disk-dst:", device)
 
-								log.Info("FreeSpaces: ", device.FreeSpacesWithMin(uint64(request)<<30), " size:", uint64(request)<<30)
+								logger.Info("FreeSpaces: ", device.FreeSpacesWithMin(uint64(request)<<30), " size:", uint64(request)<<30)
 								if len(device.FreeSpacesWithMin(uint64(request)<<30)) < 1 {
 									continue
 								}
@@ -429,7 +430,7 @@ func (s NodeService) SelectDeviceGroup(ctx context.Context, request int64, nodeN
 		}
 	}
 
-	log.Info("select device grouplist ", preselectNode)
+	logger.Info("select device grouplist ", preselectNode)
 	if len(preselectNode) < 1 {
 		return "", ErrNodeNotFound
 	}
@@ -454,6 +455,7 @@ func (s NodeService) SelectDeviceGroup(ctx context.Context, request int64, nodeN
 }
 
 func (s NodeService) SelectDeviceGroupDisk(ctx context.Context, request int64, nodeName string, volumeType string, exclusivityDisk bool, deviceGroup string) (string, error) {
+	logger := log.FromContext(ctx)
 	var selectDeviceGroup string
 
 	nl, err := s.getNodes(ctx)
@@ -485,12 +487,12 @@ func (s NodeService) SelectDeviceGroupDisk(ctx context.Context, request int64, n
 		if !exists {
 			continue
 		}
-		log.Infof("status:%v", status)
+		logger.Infof("status:%v", status)
 		// capacity selector
 		// 经过上层过滤，这里只会有一个节点
 		for key, value := range status.Allocatable {
 			if strings.HasPrefix(key, utils.DeviceCapacityKeyPrefix) {
-				log.Infof("skip:%s ; disk:key %s; deviceGroup:%s", lvs, key, deviceGroup)
+				logger.Infof("skip:%s ; disk:key %s; deviceGroup:%s", lvs, key, deviceGroup)
 				strArr := strings.Split(key, "/")
 				if deviceGroup != "" && !strings.Contains(key, deviceGroup) {
 					continue
@@ -504,12 +506,12 @@ func (s NodeService) SelectDeviceGroupDisk(ctx context.Context, request int64, n
 						if strings.Contains(key, disk.Name) {
 							device := disko.Disk{}
 							utils.Fill(disk, &device)
-							log.Info("disk-src:", disk)
-							log.Info(nodeName, ": select disk", disk.Path, " exclusivityDisk: ", exclusivityDisk, " partitions: ", len(disk.Partitions))
+							logger.Info("disk-src:", disk)
+							logger.Info(nodeName, ": select disk", disk.Path, " exclusivityDisk: ", exclusivityDisk, " partitions: ", len(disk.Partitions))
 							//check freespace
-							log.Info("disk-dst:", device)
+							logger.Info("disk-dst:", device)
 
-							log.Info("FreeSpaces: ", device.FreeSpacesWithMin(uint64(request)<<30), " size:", uint64(request)<<30)
+							logger.Info("FreeSpaces: ", device.FreeSpacesWithMin(uint64(request)<<30), " size:", uint64(request)<<30)
 							if len(device.FreeSpacesWithMin(uint64(request)<<30)) < 1 {
 								continue
 							}
@@ -530,7 +532,7 @@ func (s NodeService) SelectDeviceGroupDisk(ctx context.Context, request int64, n
 		}
 	}
 
-	log.Info("select device grouplist ", preselectNode)
+	logger.Info("select device grouplist ", preselectNode)
 	if len(preselectNode) < 1 {
 		return "", ErrNodeNotFound
 	}
@@ -563,6 +565,16 @@ func (s NodeService) HaveSelectedNode(ctx context.Context, namespace, name strin
 	return node, nil
 }
 
+// GetPvcAnnotations returns the annotations of the pvc, e.g. the defaults injected from StoragePolicy
+func (s NodeService) GetPvcAnnotations(ctx context.Context, namespace, name string) (map[string]string, error) {
+	pvc := new(corev1.PersistentVolumeClaim)
+	err := s.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, pvc)
+	if err != nil {
+		return nil, err
+	}
+	return pvc.Annotations, nil
+}
+
 func (s NodeService) SelectMultiVolumeNode(ctx context.Context, backendDeviceGroup, cacheDeviceGroup string, backendRequestGb, cacheRequestGb int64, requirement *csi.TopologyRequirement) (string, map[string]string, error) {
 	// 在并发场景下，兼顾调度效率与调度公平，将pv分配到不同时间段
 	time.Sleep(time.Duration(rand.Int63nRange(1, 30)) * time.Second)
@@ -669,6 +681,7 @@ func (s NodeService) SelectMultiVolumeNode(ctx context.Context, backendDeviceGro
 
 //In the case of bare disk, it is preferential to match the partitioned disk, and if there is no one, then match the raw disk without partition
 func (s NodeService) SelectDeviceNode(ctx context.Context, request int64, deviceGroup string, requirement *csi.TopologyRequirement, exclusivityDisk bool) (string, string, map[string]string, error) {
+	logger := log.FromContext(ctx)
 	// Locate the disk that matches the current group
 	//re := configuration.GetRawDeviceGroupRe(deviceGroup)
 	time.Sleep(time.Duration(rand.Int63nRange(1, 30)) * time.Second)
@@ -722,7 +735,7 @@ func (s NodeService) SelectDeviceNode(ctx context.Context, request int64, device
 		// capacity selector
 		for key, value := range status.Allocatable {
 			if strings.HasPrefix(key, utils.DeviceCapacityKeyPrefix) {
-				log.Infof("skip:%s ; disk:key %s; deviceGroup:%s", lvs, key, deviceGroup)
+				logger.Infof("skip:%s ; disk:key %s; deviceGroup:%s", lvs, key, deviceGroup)
 				strArr := strings.Split(key, "/")
 				if deviceGroup != "" && !strings.Contains(key, deviceGroup) {
 					continue
@@ -736,12 +749,12 @@ func (s NodeService) SelectDeviceNode(ctx context.Context, request int64, device
 						if strings.Contains(key, disk.Name) {
 							device := disko.Disk{}
 							utils.Fill(disk, &device)
-							log.Info("disk-src:", disk)
-							log.Info(nodeName, ": select disk", disk.Path, " exclusivityDisk: ", exclusivityDisk, " partitions: ", len(disk.Partitions))
+							logger.Info("disk-src:", disk)
+							logger.Info(nodeName, ": select disk", disk.Path, " exclusivityDisk: ", exclusivityDisk, " partitions: ", len(disk.Partitions))
 							//check freespace
-							log.Info("disk-dst:", device)
+							logger.Info("disk-dst:", device)
 
-							log.Info("FreeSpaces: ", device.FreeSpacesWithMin(uint64(request)<<30), " size:", uint64(request)<<30)
+							logger.Info("FreeSpaces: ", device.FreeSpacesWithMin(uint64(request)<<30), " size:", uint64(request)<<30)
 							if len(device.FreeSpacesWithMin(uint64(request)<<30)) < 1 {
 								continue
 							}
@@ -762,7 +775,7 @@ func (s NodeService) SelectDeviceNode(ctx context.Context, request int64, device
 			}
 		}
 	}
-	log.Infof("preselectNode:%v", preselectNode)
+	logger.Infof("preselectNode:%v", preselectNode)
 	if len(preselectNode) < 1 {
 		return "", "", segments, ErrNodeNotFound
 	}
@@ -785,7 +798,7 @@ func (s NodeService) SelectDeviceNode(ctx context.Context, request int64, device
 	node := new(corev1.Node)
 	err = s.Get(ctx, client.ObjectKey{Name: nodeName}, node)
 	if err != nil {
-		log.Error(err, "unable get node ")
+		logger.Error(err, "unable get node ")
 		return "", "", segments, err
 	}
 
@@ -0,0 +1,48 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestAllocatableBytes(t *testing.T) {
+	a := assert.New(t)
+	allocatable := map[string]resource.Quantity{
+		"carina.storage.io/carina-vg-ssd":       *resource.NewQuantity(100, resource.BinarySI),
+		"carina.storage.io/carina-vg-hdd":       *resource.NewQuantity(0, resource.BinarySI),
+		"carina.storage.io/carina-raw-ssd/sdb":  *resource.NewQuantity(50, resource.BinarySI),
+		"carina.storage.io/carina-raw-ssd/sdc":  *resource.NewQuantity(30, resource.BinarySI),
+		"kubernetes.io/unrelated-resource-name": *resource.NewQuantity(1, resource.BinarySI),
+	}
+
+	total, largest := allocatableBytes(allocatable, "carina-vg-ssd", false)
+	a.Equal(int64(100)<<30, total)
+	a.Equal(int64(100)<<30, largest)
+
+	// 裸盘卷只能放在一块盘上
+	total, largest = allocatableBytes(allocatable, "carina-raw-ssd", true)
+	a.Equal(int64(80)<<30, total)
+	a.Equal(int64(50)<<30, largest)
+
+	total, largest = allocatableBytes(allocatable, "carina-vg-hdd", false)
+	a.Zero(total)
+	a.Zero(largest)
+}
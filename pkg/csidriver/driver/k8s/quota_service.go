@@ -0,0 +1,82 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/quota"
+	"github.com/carina-io/carina/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups=carina.storage.io,resources=carinaquotas,verbs=get;list;watch
+
+// ReserveQuota 检查命名空间的CarinaQuota是否允许创建该卷
+// requests holds the bytes requested per disk group, volumeGroup is the disk group the
+// volume is counted in, empty when an existing volume is expanded. On success the caller
+// must call release once the logic volumes are created or expanded, until then other
+// volumes are checked after them. Retries of a new volume whose LogicVolume already
+// exists are not checked again.
+func (s *LogicVolumeService) ReserveQuota(ctx context.Context, namespace, name, volumeGroup string, requests map[string]int64) (func(), error) {
+	noop := func() {}
+	if namespace == "" {
+		return noop, nil
+	}
+	quotaList := new(carinav1.CarinaQuotaList)
+	if err := s.List(ctx, quotaList, client.InNamespace(namespace)); err != nil {
+		return noop, status.Errorf(codes.Internal, "list CarinaQuota of namespace %s failed: %v", namespace, err)
+	}
+	if len(quotaList.Items) == 0 {
+		return noop, nil
+	}
+
+	s.quotaMu.Lock()
+	err := func() error {
+		if volumeGroup != "" {
+			existingLV := new(carinav1.LogicVolume)
+			err := s.Get(ctx, client.ObjectKey{Name: name, Namespace: utils.LogicVolumeNamespace}, existingLV)
+			if err == nil {
+				return nil
+			}
+			if !apierrors.IsNotFound(err) {
+				return status.Error(codes.Internal, err.Error())
+			}
+		}
+
+		lvList := new(carinav1.LogicVolumeList)
+		if err := s.List(ctx, lvList); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		total, groups := quota.Usage(lvList.Items, namespace)
+		for i := range quotaList.Items {
+			if err := quota.Check(&quotaList.Items[i], total, groups, volumeGroup, requests); err != nil {
+				return status.Error(codes.ResourceExhausted, err.Error())
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		s.quotaMu.Unlock()
+		return noop, err
+	}
+	return s.quotaMu.Unlock, nil
+}
@@ -0,0 +1,221 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/drbd"
+	"github.com/carina-io/carina/utils"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// replicaCandidate 可以放置第二个副本的节点
+type replicaCandidate struct {
+	Node string
+	// Free 磁盘组的可分配容量，单位GiB
+	Free int64
+	// OtherZone 与主副本不在同一个可用区
+	OtherZone bool
+}
+
+// pickReplicaNode prefers candidates in another zone than the primary, then follows the
+// scheduler strategy: the least free capacity for binpack, the most for spreadout.
+func pickReplicaNode(candidates []replicaCandidate, binpack bool) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].OtherZone != candidates[j].OtherZone {
+			return candidates[i].OtherZone
+		}
+		if candidates[i].Free != candidates[j].Free {
+			if binpack {
+				return candidates[i].Free < candidates[j].Free
+			}
+			return candidates[i].Free > candidates[j].Free
+		}
+		return candidates[i].Node < candidates[j].Node
+	})
+	return candidates[0].Node
+}
+
+// SelectReplicaNode selects the node of the second copy of a replicated volume. It must be a
+// ready linux node other than the primary, match the topology requirement of the storageclass and
+// have requestGb allocatable in the device group of the primary.
+func (s NodeService) SelectReplicaNode(ctx context.Context, requestGb int64, deviceGroup, primary string, requirement *csi.TopologyRequirement) (string, error) {
+	nl, err := s.getNodes(ctx)
+	if err != nil {
+		return "", err
+	}
+	nsr, err := s.getNodeStorageResources(ctx)
+	if err != nil {
+		return "", err
+	}
+	primaryZone := ""
+	for _, node := range nl.Items {
+		if node.Name == primary {
+			primaryZone = node.Labels[corev1.LabelTopologyZone]
+		}
+	}
+
+	candidates := []replicaCandidate{}
+	for _, node := range nl.Items {
+		if node.Name == primary || node.Spec.Unschedulable {
+			continue
+		}
+		// windows节点没有drbd
+		if node.Labels[corev1.LabelOSStable] == "windows" {
+			continue
+		}
+		if requirement != nil && len(requirement.GetRequisite()) > 0 {
+			matched := false
+			for _, topo := range requirement.GetRequisite() {
+				// 节点拓扑键只约束主副本
+				segments := map[string]string{}
+				for k, v := range topo.GetSegments() {
+					if k != utils.TopologyNodeKey {
+						segments[k] = v
+					}
+				}
+				if labels.SelectorFromSet(segments).Matches(labels.Set(node.Labels)) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		status, ok := nsr[node.Name]
+		if !ok {
+			continue
+		}
+		for key, value := range status.Allocatable {
+			if key != utils.DeviceCapacityKeyPrefix+deviceGroup || value.Value() < requestGb {
+				continue
+			}
+			zone := node.Labels[corev1.LabelTopologyZone]
+			candidates = append(candidates, replicaCandidate{
+				Node:      node.Name,
+				Free:      value.Value(),
+				OtherZone: zone != "" && zone != primaryZone,
+			})
+		}
+	}
+	node := pickReplicaNode(candidates, configuration.SchedulerStrategy() == configuration.SchedulerBinpack)
+	if node == "" {
+		return "", ErrNodeNotFound
+	}
+	return node, nil
+}
+
+// pickTiebreakerNode prefers candidates in a zone of neither copy, a zone failure then takes
+// at most one of the three nodes.
+func pickTiebreakerNode(candidates []replicaCandidate) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].OtherZone != candidates[j].OtherZone {
+			return candidates[i].OtherZone
+		}
+		return candidates[i].Node < candidates[j].Node
+	})
+	return candidates[0].Node
+}
+
+// SelectTiebreakerNode selects the third node of a replicated volume, it joins the drbd resource
+// without disk so the surviving copy keeps quorum when the node of the other one fails. It must
+// be a ready linux node running carina-node other than the nodes of the two copies.
+func (s NodeService) SelectTiebreakerNode(ctx context.Context, primary, replica string) (string, error) {
+	nl, err := s.getNodes(ctx)
+	if err != nil {
+		return "", err
+	}
+	nsr, err := s.getNodeStorageResources(ctx)
+	if err != nil {
+		return "", err
+	}
+	zones := map[string]bool{}
+	for _, node := range nl.Items {
+		if node.Name == primary || node.Name == replica {
+			zones[node.Labels[corev1.LabelTopologyZone]] = true
+		}
+	}
+
+	candidates := []replicaCandidate{}
+	for _, node := range nl.Items {
+		if node.Name == primary || node.Name == replica || node.Labels[corev1.LabelOSStable] == "windows" {
+			continue
+		}
+		if _, ok := nsr[node.Name]; !ok {
+			continue
+		}
+		zone := node.Labels[corev1.LabelTopologyZone]
+		candidates = append(candidates, replicaCandidate{Node: node.Name, OtherZone: zone != "" && !zones[zone]})
+	}
+	node := pickTiebreakerNode(candidates)
+	if node == "" {
+		return "", ErrNodeNotFound
+	}
+	return node, nil
+}
+
+// NextDrbdMinor returns a drbd minor not used by any replicated volume. The caller serializes
+// the allocation with the creation of the LogicVolume recording it.
+func (s *LogicVolumeService) NextDrbdMinor(ctx context.Context) (int, error) {
+	lvList := new(v1.LogicVolumeList)
+	if err := s.APIReader.List(ctx, lvList); err != nil {
+		return 0, err
+	}
+	used := map[int]bool{}
+	for _, lv := range lvList.Items {
+		if minor, err := strconv.Atoi(strings.TrimSpace(lv.Annotations[utils.VolumeDrbdMinor])); err == nil {
+			used[minor] = true
+		}
+	}
+	return drbd.NextMinor(used)
+}
+
+// GetReplicatedVolume returns the LogicVolume of a replicated volume whose creation was
+// interrupted, a retried CreateVolume keeps its placement. It returns nil if there is none.
+func (s *LogicVolumeService) GetReplicatedVolume(ctx context.Context, name string) (*v1.LogicVolume, error) {
+	lv := new(v1.LogicVolume)
+	err := s.APIReader.Get(ctx, client.ObjectKey{Name: name, Namespace: utils.LogicVolumeNamespace}, lv)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := lv.Annotations[utils.VolumeDrbdMinor]; !ok {
+		return nil, status.Errorf(codes.AlreadyExists, "volume %s exists and is not replicated", name)
+	}
+	return lv, nil
+}
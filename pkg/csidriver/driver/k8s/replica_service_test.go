@@ -0,0 +1,51 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPickReplicaNode(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("", pickReplicaNode(nil, true))
+
+	candidates := func() []replicaCandidate {
+		return []replicaCandidate{
+			{Node: "node1", Free: 100},
+			{Node: "node2", Free: 50},
+			{Node: "node3", Free: 80, OtherZone: true},
+			{Node: "node4", Free: 200, OtherZone: true},
+		}
+	}
+	// 优先放在另一个可用区
+	a.Equal("node3", pickReplicaNode(candidates(), true))
+	a.Equal("node4", pickReplicaNode(candidates(), false))
+
+	a.Equal("node2", pickReplicaNode(candidates()[:2], true))
+	a.Equal("node1", pickReplicaNode(candidates()[:2], false))
+}
+
+func TestPickTiebreakerNode(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("", pickTiebreakerNode(nil))
+	a.Equal("node3", pickTiebreakerNode([]replicaCandidate{{Node: "node4"}, {Node: "node3"}}))
+	// 优先放在两个副本之外的可用区
+	a.Equal("node4", pickTiebreakerNode([]replicaCandidate{{Node: "node3"}, {Node: "node5", OtherZone: true}, {Node: "node4", OtherZone: true}}))
+}
@@ -0,0 +1,45 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"github.com/carina-io/carina/pkg/csidriver/filesystem"
+	"github.com/carina-io/carina/utils"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// formatVolume 按storageclass的mkfs参数格式化新卷
+// Without mkfs options, or if the device already has a filesystem, nothing is done and
+// FormatAndMount formats the volume with the kubelet defaults as before.
+func formatVolume(req *csi.NodePublishVolumeRequest, device, fsType, current string) error {
+	if current != "" {
+		return nil
+	}
+	options, err := utils.MkfsOptions(fsType, req.GetVolumeContext()[utils.VolumeMkfsOptions])
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	if err := filesystem.Mkfs(device, fsType, options); err != nil {
+		return status.Errorf(codes.Internal, "format failed: volume=%s, error=%v", req.GetVolumeId(), err)
+	}
+	return nil
+}
@@ -26,22 +26,36 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/anuvu/disko"
 	"github.com/anuvu/disko/linux"
+	carinav1 "github.com/carina-io/carina/api/v1"
 	"github.com/carina-io/carina/pkg/configuration"
 	"github.com/carina-io/carina/pkg/csidriver/driver/k8s"
 	"github.com/carina-io/carina/pkg/csidriver/filesystem"
+	"github.com/carina-io/carina/pkg/datamover"
+	"github.com/carina-io/carina/pkg/devicemanager/bcache"
+	blockdevice "github.com/carina-io/carina/pkg/devicemanager/device"
+	"github.com/carina-io/carina/pkg/devicemanager/drbd"
 	"github.com/carina-io/carina/pkg/devicemanager/partition"
+	"github.com/carina-io/carina/pkg/devicemanager/tools"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 	"github.com/carina-io/carina/pkg/devicemanager/volume"
+	"github.com/carina-io/carina/pkg/nfsgateway"
+	"github.com/carina-io/carina/pkg/populator"
+	"github.com/carina-io/carina/pkg/tracing"
 	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/exec"
 	"github.com/carina-io/carina/utils/log"
+	"github.com/carina-io/carina/utils/mutx"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/otel/attribute"
 
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
 	mountutil "k8s.io/mount-utils"
 	utilexec "k8s.io/utils/exec"
 )
@@ -55,20 +69,27 @@ const (
 	// umountCmd        = "/bin/umount"
 	findmntCmd       = "/usr/bin/findmnt"
 	devicePermission = 0600 | unix.S_IFBLK
+	// bcacheFlushTimeout 卸载时等待缓存盘脏数据写回的时间，超时后由kubelet重试
+	bcacheFlushTimeout = 30 * time.Second
 )
 
 // NewNodeService returns a new NodeServer.
-func NewNodeService(nodeName string, volumeManager volume.LocalVolume, partition partition.LocalPartition, service *k8s.LogicVolumeService) csi.NodeServer {
-	return &nodeService{
+func NewNodeService(nodeName string, volumeManager volume.LocalVolume, partition partition.LocalPartition, service *k8s.LogicVolumeService, pool *mutx.PriorityPool, throttle *datamover.Throttle) csi.NodeServer {
+	s := &nodeService{
 		nodeName:      nodeName,
 		volumeManager: volumeManager,
 		partition:     partition,
 		k8sLVService:  service,
+		pool:          pool,
+		published:     map[string]string{},
+		drbd:          &drbd.DrbdImplement{Executor: &exec.CommandExecutor{}},
 		mounter: mountutil.SafeFormatAndMount{
 			Interface: mountutil.New(""),
 			Exec:      utilexec.New(),
 		},
 	}
+	s.populator = populator.NewPopulator(&s.mounter, throttle, s.reportPrefillProgress)
+	return s
 }
 
 type nodeService struct {
@@ -80,13 +101,20 @@ type nodeService struct {
 	k8sLVService  *k8s.LogicVolumeService
 	mu            sync.Mutex
 	mounter       mountutil.SafeFormatAndMount
+	populator     *populator.Populator
+	pool          *mutx.PriorityPool
+	// published ReadWriteOncePod卷当前发布的目标路径
+	published map[string]string
+	// drbd 多副本卷的drbd资源
+	drbd drbd.Drbd
 }
 
 func (s *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	volumeContext := req.GetVolumeContext()
 	volumeID := req.GetVolumeId()
 
-	log.Info("NodePublishVolume called",
+	logger.Info("NodePublishVolume called",
 		" volume_id ", volumeID,
 		" publish_context ", req.GetPublishContext(),
 		" target_path ", req.GetTargetPath(),
@@ -110,12 +138,29 @@ func (s *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		return nil, status.Errorf(codes.InvalidArgument, "no supported volume capability: %v", req.GetVolumeCapability())
 	}
 
+	if err := s.pool.Acquire(ctx, mutx.PriorityPublish, "publish "+volumeID); err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer s.pool.Release(mutx.PriorityPublish)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.checkSingleWriter(req, ""); err != nil {
+		return nil, err
+	}
+
 	cacheVolumeId := volumeContext[utils.VolumeCacheId]
 	if cacheVolumeId != "" {
-		return s.nodePublishBcacheVolume(ctx, req)
+		resp, err := s.nodePublishBcacheVolume(ctx, req)
+		if err == nil && isBlockVol {
+			err = s.setDeviceOwnership(ctx, req)
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.rememberPublish(req)
+		return resp, nil
 	}
 
 	var lv *types.LvInfo
@@ -124,9 +169,29 @@ func (s *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	if err != nil {
 		return nil, err
 	}
+	// 复制的备用卷在接收快照，提升为主卷之前不能使用
+	if lvr.Annotations[utils.VolumeReplicationRole] == carinav1.ReplicationSecondary {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is the standby of a VolumeReplication, promote it first", volumeID)
+	}
+	// 新建卷的首次挂载归入创建卷的trace
+	ctx, span := tracing.StartFromObject(ctx, "publish", lvr, attribute.String("targetPath", req.GetTargetPath()))
+	defer span.End()
+	// 共享卷在任何节点上都挂载nfs网关的导出
+	if nfsgateway.Shared(lvr) {
+		resp, err := s.nodePublishSharedVolume(ctx, req, lvr)
+		if err != nil {
+			return nil, err
+		}
+		s.rememberPublish(req)
+		return resp, nil
+	}
 	switch lvr.Annotations[utils.VolumeManagerType] {
 	case utils.LvmVolumeType:
-		lv, err = s.getLvFromContext(lvr.Spec.DeviceGroup, volumeID)
+		if replicatedVolume(lvr) {
+			lv, err = s.drbdDevice(lvr)
+		} else {
+			lv, err = s.getLvFromContext(lvr.Spec.DeviceGroup, volumeID)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -134,10 +199,12 @@ func (s *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		if lv == nil {
 			return nil, status.Errorf(codes.NotFound, "failed to find LV: %s", volumeID)
 		}
+		// 加密由csi控制器在创建时按集群策略决定并记录在LogicVolume上
+		encrypted := lvr.Annotations[utils.VolumeEncrypted] == "true"
 		if isBlockVol {
-			_, err = s.nodePublishLvmBlockVolume(req, lv)
+			_, err = s.nodePublishLvmBlockVolume(ctx, req, lv, encrypted)
 		} else if isFsVol {
-			_, err = s.nodePublishLvmFilesystemVolume(req, lv)
+			_, err = s.nodePublishLvmFilesystemVolume(ctx, req, lv, encrypted)
 		}
 
 		if err != nil {
@@ -152,14 +219,14 @@ func (s *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		if err != nil {
 			return nil, err
 		}
-		log.Infof(" IsBlockVol: %v ,Touch partittion name:%s,num:%d,start:%d,size:%d", isBlockVol, partition.Name, partition.Number, partition.Start, partition.Size())
+		logger.Infof(" IsBlockVol: %v ,Touch partittion name:%s,num:%d,start:%d,size:%d", isBlockVol, partition.Name, partition.Number, partition.Start, partition.Size())
 		if partition.Name == "" {
 			return nil, status.Errorf(codes.NotFound, "failed to find partition: %s", utils.PartitionName(volumeID))
 		}
 		if isBlockVol {
-			_, err = s.nodePublishRawBlockVolume(req, disk, &partition)
+			_, err = s.nodePublishRawBlockVolume(ctx, req, disk, &partition)
 		} else if isFsVol {
-			_, err = s.nodePublishRawFilesystemVolume(req, disk, &partition)
+			_, err = s.nodePublishRawFilesystemVolume(ctx, req, disk, &partition)
 		}
 
 		if err != nil {
@@ -167,21 +234,52 @@ func (s *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		}
 
 	default:
-		log.Errorf("Create LogicVolume: Create with no support volume type undefined")
+		logger.Errorf("Create LogicVolume: Create with no support volume type undefined")
 		return nil, status.Errorf(codes.InvalidArgument, "Create with no support type ")
 	}
 
+	if isBlockVol {
+		if err := s.setDeviceOwnership(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+	s.rememberPublish(req)
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
-func (s *nodeService) nodePublishLvmBlockVolume(req *csi.NodePublishVolumeRequest, lv *types.LvInfo) (*csi.NodePublishVolumeResponse, error) {
+func (s *nodeService) nodePublishLvmBlockVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, lv *types.LvInfo, encrypted bool) (*csi.NodePublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
+	major, minor := lv.LVKernelMajor, lv.LVKernelMinor
+	opened := lv.LVPath
+	if encrypted {
+		device := filepath.Join(DeviceDirectory, req.GetVolumeId())
+		if err := s.createDeviceIfNeeded(device, major, minor); err != nil {
+			return nil, err
+		}
+		mapper, err := s.openEncryptedDevice(ctx, req, device)
+		if err != nil {
+			return nil, err
+		}
+		major, minor, err = deviceNumber(mapper)
+		if err != nil {
+			return nil, err
+		}
+		opened = mapper
+	}
+	// 只读访问模式的卷在内核中标记为只读，容器以读写方式打开设备也无法写入
+	if readOnlyAccess(req.GetVolumeCapability().GetAccessMode().GetMode()) {
+		if out, err := s.mounter.Exec.Command("blockdev", "--setro", opened).CombinedOutput(); err != nil {
+			return nil, status.Errorf(codes.Internal, "blockdev --setro %s failed: %v %s", opened, err, string(out))
+		}
+	}
+
 	// Find lv and create a block device with it
 	var stat unix.Stat_t
 	target := req.GetTargetPath()
 	err := filesystem.Stat(target, &stat)
 	switch err {
 	case nil:
-		if stat.Rdev == unix.Mkdev(lv.LVKernelMajor, lv.LVKernelMinor) && stat.Mode&devicePermission == devicePermission {
+		if stat.Rdev == unix.Mkdev(major, minor) && stat.Mode&devicePermission == devicePermission {
 			return &csi.NodePublishVolumeResponse{}, nil
 		}
 		if err := os.Remove(target); err != nil {
@@ -197,19 +295,20 @@ func (s *nodeService) nodePublishLvmBlockVolume(req *csi.NodePublishVolumeReques
 		return nil, status.Errorf(codes.Internal, "mkdir failed: target=%s, error=%v", path.Dir(target), err)
 	}
 
-	devno := unix.Mkdev(lv.LVKernelMajor, lv.LVKernelMinor)
+	devno := unix.Mkdev(major, minor)
 	if err := filesystem.Mknod(target, devicePermission, int(devno)); err != nil {
 		return nil, status.Errorf(codes.Internal, "mknod failed for %s: error=%v", target, err)
 	}
 
-	log.Info("NodePublishVolume(block) succeeded",
+	logger.Info("NodePublishVolume(block) succeeded",
 		" volume_id ", req.GetVolumeId(),
 		" target_path ", target)
 	return &csi.NodePublishVolumeResponse{}, nil
 }
-func (s *nodeService) nodePublishRawBlockVolume(req *csi.NodePublishVolumeRequest, disk disko.Disk, part *disko.Partition) (*csi.NodePublishVolumeResponse, error) {
+func (s *nodeService) nodePublishRawBlockVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, disk disko.Disk, part *disko.Partition) (*csi.NodePublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	// Find parttion
-	name := linux.GetPartitionKname(disk.Path, part.Number)
+	name := blockdevice.PartitionPath(disk.Path, part.Number)
 	partinfo, err := linux.GetUdevInfo(name)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to get partinfo %s", err)
@@ -230,11 +329,11 @@ func (s *nodeService) nodePublishRawBlockVolume(req *csi.NodePublishVolumeReques
 	err = filesystem.Stat(target, &stat)
 	isBlock := (stat.Mode & unix.S_IFMT) == unix.S_IFBLK
 
-	log.Info("targetpath is block:", isBlock, MAJOR, MINOR, stat, "err:", err)
+	logger.Info("targetpath is block:", isBlock, MAJOR, MINOR, stat, "err:", err)
 	switch err {
 	case nil:
 		if stat.Rdev == unix.Mkdev(uint32(MAJOR), uint32(MINOR)) && stat.Mode&devicePermission == devicePermission {
-			log.Info("stat.Rdev%s", stat.Rdev, unix.Mkdev(uint32(MAJOR), uint32(MINOR)), "stat.Mode", stat.Mode)
+			logger.Info("stat.Rdev%s", stat.Rdev, unix.Mkdev(uint32(MAJOR), uint32(MINOR)), "stat.Mode", stat.Mode)
 			return &csi.NodePublishVolumeResponse{}, nil
 		}
 		if err := os.Remove(target); err != nil {
@@ -255,22 +354,25 @@ func (s *nodeService) nodePublishRawBlockVolume(req *csi.NodePublishVolumeReques
 		return nil, status.Errorf(codes.Internal, "mknod failed for %s: error=%v", target, err)
 	}
 
-	log.Info("NodePublishVolume(block) succeeded",
+	logger.Info("NodePublishVolume(block) succeeded",
 		" volume_id ", req.GetVolumeId(),
 		" target_path ", target)
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
-func (s *nodeService) nodePublishLvmFilesystemVolume(req *csi.NodePublishVolumeRequest, lv *types.LvInfo) (*csi.NodePublishVolumeResponse, error) {
+func (s *nodeService) nodePublishLvmFilesystemVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, lv *types.LvInfo, encrypted bool) (*csi.NodePublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	// Check request
 	mountOption := req.GetVolumeCapability().GetMount()
+	// pvc注解carina.storage.io/fstype优先于storageclass的fstype
+	if fsType := req.GetVolumeContext()[utils.VolumeFsType]; fsType != "" {
+		mountOption.FsType = fsType
+	}
 	if mountOption.FsType == "" {
 		mountOption.FsType = "ext4"
 	}
-	accessMode := req.GetVolumeCapability().GetAccessMode().GetMode()
-	if accessMode != csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER {
-		modeName := csi.VolumeCapability_AccessMode_Mode_name[int32(accessMode)]
-		return nil, status.Errorf(codes.FailedPrecondition, "unsupported access mode: %s", modeName)
+	if err := checkAccessMode(req.GetVolumeCapability()); err != nil {
+		return nil, err
 	}
 
 	// Find lv and create a block device with it
@@ -279,17 +381,16 @@ func (s *nodeService) nodePublishLvmFilesystemVolume(req *csi.NodePublishVolumeR
 	if err != nil {
 		return nil, err
 	}
-
-	var mountOptions []string
-	if req.GetReadonly() {
-		mountOptions = append(mountOptions, "ro")
+	if encrypted {
+		device, err = s.openEncryptedDevice(ctx, req, device)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	for _, m := range mountOption.MountFlags {
-		if m == "rw" && req.GetReadonly() {
-			return nil, status.Error(codes.InvalidArgument, "mount option \"rw\" is specified even though read only mode is specified")
-		}
-		mountOptions = append(mountOptions, m)
+	mountOptions, err := publishMountOptions(req, mountOption.FsType)
+	if err != nil {
+		return nil, err
 	}
 
 	err = os.MkdirAll(req.GetTargetPath(), 0755)
@@ -306,14 +407,24 @@ func (s *nodeService) nodePublishLvmFilesystemVolume(req *csi.NodePublishVolumeR
 		return nil, status.Errorf(codes.Internal, "target device is already formatted with different filesystem: volume=%s, current=%s, new:%s", req.GetVolumeId(), fsType, mountOption.FsType)
 	}
 
+	if err := s.checkSingleWriter(req, device); err != nil {
+		return nil, err
+	}
+
 	mounted, err := filesystem.IsMounted(device, req.GetTargetPath())
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "mount check failed: target=%s, error=%v", req.GetTargetPath(), err)
 	}
 
 	if !mounted {
-		log.Infof("mount %s %s %s %s", device, req.GetTargetPath(), mountOption.FsType, strings.Join(mountOptions, ","))
-		if err := s.mounter.FormatAndMount(device, req.GetTargetPath(), mountOption.FsType, mountOptions); err != nil {
+		if err := formatVolume(req, device, mountOption.FsType, fsType); err != nil {
+			return nil, err
+		}
+		if err := s.prefillVolume(req, device, mountOption.FsType); err != nil {
+			return nil, err
+		}
+		logger.Infof("mount %s %s %s %s", device, req.GetTargetPath(), mountOption.FsType, strings.Join(mountOptions, ","))
+		if err := s.mountVolume(ctx, req, device, mountOption.FsType, fsType, mountOptions); err != nil {
 			return nil, status.Errorf(codes.Internal, "mount failed: volume=%s, error=%v", req.GetVolumeId(), err)
 		}
 		if err := os.Chmod(req.GetTargetPath(), 0777|os.ModeSetgid); err != nil {
@@ -321,27 +432,30 @@ func (s *nodeService) nodePublishLvmFilesystemVolume(req *csi.NodePublishVolumeR
 		}
 	}
 
-	log.Info("NodePublishVolume(fs) succeeded",
+	logger.Info("NodePublishVolume(fs) succeeded",
 		" volume_id ", req.GetVolumeId(),
 		" target_path ", req.GetTargetPath(),
 		" fstype ", mountOption.FsType)
 
 	return &csi.NodePublishVolumeResponse{}, nil
 }
-func (s *nodeService) nodePublishRawFilesystemVolume(req *csi.NodePublishVolumeRequest, disk disko.Disk, part *disko.Partition) (*csi.NodePublishVolumeResponse, error) {
+func (s *nodeService) nodePublishRawFilesystemVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, disk disko.Disk, part *disko.Partition) (*csi.NodePublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	// Check request
-	log.Info("NodePublishVolume device: Filesystem")
+	logger.Info("NodePublishVolume device: Filesystem")
 	mountOption := req.GetVolumeCapability().GetMount()
+	// pvc注解carina.storage.io/fstype优先于storageclass的fstype
+	if fsType := req.GetVolumeContext()[utils.VolumeFsType]; fsType != "" {
+		mountOption.FsType = fsType
+	}
 	if mountOption.FsType == "" {
 		mountOption.FsType = "ext4"
 	}
-	accessMode := req.GetVolumeCapability().GetAccessMode().GetMode()
-	if accessMode != csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER {
-		modeName := csi.VolumeCapability_AccessMode_Mode_name[int32(accessMode)]
-		return nil, status.Errorf(codes.FailedPrecondition, "unsupported access mode: %s", modeName)
+	if err := checkAccessMode(req.GetVolumeCapability()); err != nil {
+		return nil, err
 	}
-	device := linux.GetPartitionKname(disk.Path, part.Number)
-	log.Info("NodePublishVolume device: ", device)
+	device := blockdevice.PartitionPath(disk.Path, part.Number)
+	logger.Info("NodePublishVolume device: ", device)
 
 	partinfo, err := linux.GetUdevInfo(device)
 	if err != nil {
@@ -364,16 +478,9 @@ func (s *nodeService) nodePublishRawFilesystemVolume(req *csi.NodePublishVolumeR
 		return nil, err
 	}
 
-	var mountOptions []string
-	if req.GetReadonly() {
-		mountOptions = append(mountOptions, "ro")
-	}
-
-	for _, m := range mountOption.MountFlags {
-		if m == "rw" && req.GetReadonly() {
-			return nil, status.Error(codes.InvalidArgument, "mount option \"rw\" is specified even though read only mode is specified")
-		}
-		mountOptions = append(mountOptions, m)
+	mountOptions, err := publishMountOptions(req, mountOption.FsType)
+	if err != nil {
+		return nil, err
 	}
 
 	err = os.MkdirAll(req.GetTargetPath(), 0755)
@@ -390,14 +497,24 @@ func (s *nodeService) nodePublishRawFilesystemVolume(req *csi.NodePublishVolumeR
 		return nil, status.Errorf(codes.Internal, "target device is already formatted with different filesystem: volume=%s, current=%s, new:%s", req.GetVolumeId(), fsType, mountOption.FsType)
 	}
 
+	if err := s.checkSingleWriter(req, device); err != nil {
+		return nil, err
+	}
+
 	mounted, err := filesystem.IsMounted(device, req.GetTargetPath())
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "mount check failed: target=%s, error=%v", req.GetTargetPath(), err)
 	}
 
 	if !mounted {
-		log.Infof("mount %s %s %s %s", device, req.GetTargetPath(), mountOption.FsType, strings.Join(mountOptions, ","))
-		if err := s.mounter.FormatAndMount(device, req.GetTargetPath(), mountOption.FsType, mountOptions); err != nil {
+		if err := formatVolume(req, device, mountOption.FsType, fsType); err != nil {
+			return nil, err
+		}
+		if err := s.prefillVolume(req, device, mountOption.FsType); err != nil {
+			return nil, err
+		}
+		logger.Infof("mount %s %s %s %s", device, req.GetTargetPath(), mountOption.FsType, strings.Join(mountOptions, ","))
+		if err := s.mountVolume(ctx, req, device, mountOption.FsType, fsType, mountOptions); err != nil {
 			return nil, status.Errorf(codes.Internal, "mount failed: volume=%s, error=%v", req.GetVolumeId(), err)
 		}
 		if err := os.Chmod(req.GetTargetPath(), 0777|os.ModeSetgid); err != nil {
@@ -405,7 +522,7 @@ func (s *nodeService) nodePublishRawFilesystemVolume(req *csi.NodePublishVolumeR
 		}
 	}
 
-	log.Info("NodePublishVolume(fs) succeeded",
+	logger.Info("NodePublishVolume(fs) succeeded",
 		" volume_id ", req.GetVolumeId(),
 		" target_path ", req.GetTargetPath(),
 		" fstype ", mountOption.FsType)
@@ -440,9 +557,10 @@ func (s *nodeService) createDeviceIfNeeded(device string, major, minor uint32) e
 }
 
 func (s *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	volID := req.GetVolumeId()
 	target := req.GetTargetPath()
-	log.Info("NodeUnpublishVolume called",
+	logger.Info("NodeUnpublishVolume called",
 		" volume_id ", volID,
 		" target_path ", target)
 
@@ -453,18 +571,36 @@ func (s *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Error(codes.InvalidArgument, "no target_path is provided")
 	}
 
+	if err := s.pool.Acquire(ctx, mutx.PriorityPublish, "unpublish "+volID); err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer s.pool.Release(mutx.PriorityPublish)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	// 目标路径删除后才算取消发布，之后ReadWriteOncePod的卷可以发布给其它pod
+	// 已完成的预填充任务也不再保留，再次发布时由卷中的完成标记判断
+	defer func() {
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			s.forgetPublish(volID, target)
+			s.populator.Forget(volID)
+		}
+	}()
 
 	lvr, err := s.k8sLVService.GetLogicVolume(ctx, volID)
 	if err != nil {
 		return nil, err
 	}
+	if nfsgateway.Shared(lvr) {
+		return s.nodeUnpublishSharedVolume(ctx, req)
+	}
 	var device string
 	var backendDevice string = ""
+	encrypted := false
 	switch lvr.Annotations[utils.VolumeManagerType] {
 	case utils.LvmVolumeType:
 		device = filepath.Join(DeviceDirectory, volID)
+		encrypted = lvr.Annotations[utils.VolumeEncrypted] == "true"
 	case utils.RawVolumeType:
 		partition, err := s.getPartitionFromContext(lvr.Spec.DeviceGroup, volID)
 		if err != nil {
@@ -474,25 +610,34 @@ func (s *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		if err != nil {
 			return nil, err
 		}
-		device = linux.GetPartitionKname(disk.Path, partition.Number)
+		device = blockdevice.PartitionPath(disk.Path, partition.Number)
 
 	default:
-		log.Errorf("Create LogicVolume: Create with no support volume type undefined")
+		logger.Errorf("Create LogicVolume: Create with no support volume type undefined")
 		return nil, status.Errorf(codes.InvalidArgument, "Create with no support type ")
 	}
 
+	// 卷本身的设备，残留的loop、bcache、dm设备都叠加在它上面
+	baseDevice := device
 	bcacheDevice, err := s.getBcacheDevice(volID)
 	if err == nil && bcacheDevice != nil {
 		device = bcacheDevice.BcachePath
 		backendDevice = bcacheDevice.DevicePath
-		log.Infof("bcache volume cache device %s backend device %s", device, backendDevice)
+		logger.Infof("bcache volume cache device %s backend device %s", device, backendDevice)
 	}
 
 	info, err := os.Stat(target)
 	if os.IsNotExist(err) {
+		// 上一次卸载在刷写缓存时超时，target已删除，继续等待写回
 		if backendDevice != "" {
-			_ = s.volumeManager.DeleteBcache(backendDevice, "")
+			if err := s.detachBcache(ctx, lvr, backendDevice); err != nil {
+				return nil, err
+			}
+		}
+		if encrypted {
+			_ = s.closeEncryptedDevice(volID)
 		}
+		s.teardownVolumeStack(volID, baseDevice)
 		// target_path does not exist, but device for mount-type PV may still exist.
 		_ = os.Remove(device)
 		return &csi.NodeUnpublishVolumeResponse{}, nil
@@ -500,15 +645,19 @@ func (s *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Errorf(codes.Internal, "stat failed for %s: %v", target, err)
 	}
 
+	if encrypted {
+		return s.nodeUnpublishEncryptedVolume(ctx, req, device, info.IsDir())
+	}
+
 	// remove device file if target_path is device, unmount target_path otherwise
 	if info.IsDir() {
 		if backendDevice != "" {
-			unpublishResp, err := s.nodeUnpublishBFileSystemCacheVolume(req, device, backendDevice)
+			unpublishResp, err := s.nodeUnpublishBFileSystemCacheVolume(ctx, req, lvr, device, backendDevice)
 			if err != nil {
 				return unpublishResp, err
 			}
 		} else {
-			unpublishResp, err := s.nodeUnpublishFilesystemVolume(req, device)
+			unpublishResp, err := s.nodeUnpublishFilesystemVolume(ctx, req, device)
 			if err != nil {
 				return unpublishResp, err
 			}
@@ -516,12 +665,13 @@ func (s *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return &csi.NodeUnpublishVolumeResponse{}, nil
 	}
 	if backendDevice != "" {
-		return s.nodeUnpublishBlockCacheVolume(req, device, backendDevice)
+		return s.nodeUnpublishBlockCacheVolume(ctx, req, lvr, device, backendDevice)
 	}
-	return s.nodeUnpublishBlockVolume(req, device)
+	return s.nodeUnpublishBlockVolume(ctx, req, device)
 }
 
-func (s *nodeService) nodeUnpublishFilesystemVolume(req *csi.NodeUnpublishVolumeRequest, device string) (*csi.NodeUnpublishVolumeResponse, error) {
+func (s *nodeService) nodeUnpublishFilesystemVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest, device string) (*csi.NodeUnpublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	target := req.GetTargetPath()
 	mounted, err := filesystem.IsMounted(device, target)
 	if err != nil {
@@ -539,24 +689,26 @@ func (s *nodeService) nodeUnpublishFilesystemVolume(req *csi.NodeUnpublishVolume
 	if err != nil && !os.IsNotExist(err) {
 		return nil, status.Errorf(codes.Internal, "remove device failed for %s: error=%v", device, err)
 	}
-	log.Info("NodeUnpublishVolume(fs) is succeeded",
+	logger.Info("NodeUnpublishVolume(fs) is succeeded",
 		" volume_id ", req.GetVolumeId(),
 		" target_path ", target)
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
-func (s *nodeService) nodeUnpublishBlockVolume(req *csi.NodeUnpublishVolumeRequest, device string) (*csi.NodeUnpublishVolumeResponse, error) {
+func (s *nodeService) nodeUnpublishBlockVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest, device string) (*csi.NodeUnpublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	if err := os.Remove(req.GetTargetPath()); err != nil {
 		return nil, status.Errorf(codes.Internal, "remove failed for %s: error=%v", req.GetTargetPath(), err)
 	}
 
-	log.Info("NodeUnpublishVolume(block) is succeeded",
+	logger.Info("NodeUnpublishVolume(block) is succeeded",
 		" volume_id ", req.GetVolumeId(),
 		" target_path ", req.GetTargetPath())
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
-func (s *nodeService) nodeUnpublishBFileSystemCacheVolume(req *csi.NodeUnpublishVolumeRequest, device, backendDevice string) (*csi.NodeUnpublishVolumeResponse, error) {
+func (s *nodeService) nodeUnpublishBFileSystemCacheVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest, lvr *carinav1.LogicVolume, device, backendDevice string) (*csi.NodeUnpublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	target := req.GetTargetPath()
 	mounted, err := filesystem.IsMounted(device, target)
 	if err != nil {
@@ -571,35 +723,75 @@ func (s *nodeService) nodeUnpublishBFileSystemCacheVolume(req *csi.NodeUnpublish
 		return nil, status.Errorf(codes.Internal, "remove dir failed for %s: error=%v", target, err)
 	}
 	// delete bcache device
-	err = s.volumeManager.DeleteBcache(backendDevice, "")
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "remove device failed for %s: error=%v", device, err)
+	if err := s.detachBcache(ctx, lvr, backendDevice); err != nil {
+		return nil, err
 	}
-	log.Info("NodeUnpublishVolume(fs) is succeeded",
+	logger.Info("NodeUnpublishVolume(fs) is succeeded",
 		" volume_id ", req.GetVolumeId(),
 		" target_path ", target)
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
-func (s *nodeService) nodeUnpublishBlockCacheVolume(req *csi.NodeUnpublishVolumeRequest, device, backendDevice string) (*csi.NodeUnpublishVolumeResponse, error) {
+// teardownVolumeStack 删除中途失败残留在卷上的设备，仍有设备被挂载时不做处理
+func (s *nodeService) teardownVolumeStack(volID, baseDevice string) {
+	layers, err := s.volumeManager.DeviceStack(baseDevice)
+	if err != nil || len(layers) == 0 {
+		return
+	}
+	for _, l := range layers {
+		if paths, err := filesystem.MountPoints("/dev/" + l.Name); err != nil || len(paths) > 0 {
+			log.Warnf("device %s stacked on volume %s is still in use, skip teardown", l.Name, volID)
+			return
+		}
+	}
+	if err := s.volumeManager.TeardownStack(baseDevice); err != nil {
+		log.Errorf("teardown devices stacked on volume %s failed %s", volID, err.Error())
+	}
+}
+
+func (s *nodeService) nodeUnpublishBlockCacheVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest, lvr *carinav1.LogicVolume, device, backendDevice string) (*csi.NodeUnpublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	if err := os.Remove(req.GetTargetPath()); err != nil {
 		return nil, status.Errorf(codes.Internal, "remove failed for %s: error=%v", req.GetTargetPath(), err)
 	}
 	// delete bcache device
-	err := s.volumeManager.DeleteBcache(backendDevice, "")
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "remove device failed for %s: error=%v", device, err)
+	if err := s.detachBcache(ctx, lvr, backendDevice); err != nil {
+		return nil, err
 	}
-	log.Info("NodeUnpublishVolume(block) is succeeded",
+	logger.Info("NodeUnpublishVolume(block) is succeeded",
 		" volume_id ", req.GetVolumeId(),
 		" target_path ", req.GetTargetPath())
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+// detachBcache 写回缓存盘上的脏数据后分离并停止卷的bcache设备
+// A timeout returns Unavailable so that kubelet retries the unpublish while the kernel keeps writing
+// back. With the force-detach annotation on the LogicVolume the dirty data left after the timeout is
+// dropped instead.
+func (s *nodeService) detachBcache(ctx context.Context, lvr *carinav1.LogicVolume, backendDevice string) error {
+	force := lvr.Annotations[utils.VolumeForceDetach] == "true"
+	err := s.volumeManager.DetachBcache(backendDevice, bcacheFlushTimeout, force)
+	var dirty *bcache.DirtyError
+	if errors.As(err, &dirty) {
+		if dirty.Dropped {
+			log.FromContext(ctx).Warn(err.Error())
+			s.k8sLVService.Recorder.Event(lvr, corev1.EventTypeWarning, "BcacheForceDetached", err.Error())
+			return nil
+		}
+		s.k8sLVService.Recorder.Eventf(lvr, corev1.EventTypeWarning, "BcacheFlushTimeout", "%s, retry later or annotate the LogicVolume with %s=true to drop it", err.Error(), utils.VolumeForceDetach)
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "remove bcache device failed for %s: error=%v", backendDevice, err)
+	}
+	return nil
+}
+
 func (s *nodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	logger := log.FromContext(ctx)
 	volID := req.GetVolumeId()
 	p := req.GetVolumePath()
-	log.Info("NodeGetVolumeStats is called volume_id ", volID, " volume_path ", p)
+	logger.Info("NodeGetVolumeStats is called volume_id ", volID, " volume_path ", p)
 	if len(volID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "no volume_id is provided")
 	}
@@ -627,7 +819,8 @@ func (s *nodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 			return nil, status.Errorf(codes.Internal, "seek on %s was failed: %v", p, err)
 		}
 		return &csi.NodeGetVolumeStatsResponse{
-			Usage: []*csi.VolumeUsage{{Total: pos, Unit: csi.VolumeUsage_BYTES}},
+			Usage:           []*csi.VolumeUsage{{Total: pos, Unit: csi.VolumeUsage_BYTES}},
+			VolumeCondition: s.volumeCondition(ctx, volID, p, true),
 		}, nil
 	}
 
@@ -637,6 +830,12 @@ func (s *nodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 
 	var sfs unix.Statfs_t
 	if err := filesystem.Statfs(p, &sfs); err != nil {
+		// 文件系统已损坏时statfs返回EIO，此时上报异常状态而不是直接失败
+		if err == unix.EIO {
+			return &csi.NodeGetVolumeStatsResponse{
+				VolumeCondition: &csi.VolumeCondition{Abnormal: true, Message: "statvfs on " + p + " returned I/O error"},
+			}, nil
+		}
 		return nil, status.Errorf(codes.Internal, "statvfs on %s was failed: %v", p, err)
 	}
 
@@ -657,14 +856,18 @@ func (s *nodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 			Available: int64(sfs.Ffree),
 		})
 	}
-	return &csi.NodeGetVolumeStatsResponse{Usage: usage}, nil
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage:           usage,
+		VolumeCondition: s.volumeCondition(ctx, volID, p, false),
+	}, nil
 }
 
 func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	vid := req.GetVolumeId()
 	vpath := req.GetVolumePath()
 
-	log.Info("NodeExpandVolume is called",
+	logger.Info("NodeExpandVolume is called",
 		" volume_id ", vid,
 		" volume_path ", vpath,
 		" required ", req.GetCapacityRange().GetRequiredBytes(),
@@ -697,7 +900,7 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 
 	isBlock := !info.IsDir()
 	if isBlock {
-		log.Info("NodeExpandVolume(block) is skipped",
+		logger.Info("NodeExpandVolume(block) is skipped",
 			" volume_id ", vid,
 			" target_path ", vpath,
 		)
@@ -705,13 +908,19 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 	}
 
 	var device string
+	encrypted := false
 	lvr, err := s.k8sLVService.GetLogicVolume(ctx, vid)
 	if err != nil {
 		return nil, err
 	}
 	switch lvr.Annotations[utils.VolumeManagerType] {
 	case utils.LvmVolumeType:
-		lv, err := s.getLvFromContext(lvr.Spec.DeviceGroup, vid)
+		var lv *types.LvInfo
+		if replicatedVolume(lvr) {
+			lv, err = s.drbdDevice(lvr)
+		} else {
+			lv, err = s.getLvFromContext(lvr.Spec.DeviceGroup, vid)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -723,6 +932,11 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		if err != nil {
 			return nil, err
 		}
+		// 文件系统位于luks映射设备上，需先扩展映射设备
+		if lvr.Annotations[utils.VolumeEncrypted] == "true" {
+			encrypted = true
+			device = filesystem.CryptMapperPath(vid)
+		}
 	case utils.RawVolumeType:
 		partition, err := s.getPartitionFromContext(lvr.Spec.DeviceGroup, vid)
 		if err != nil {
@@ -735,13 +949,13 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		if err != nil {
 			return nil, err
 		}
-		device = linux.GetPartitionKname(disk.Path, partition.Number)
+		device = blockdevice.PartitionPath(disk.Path, partition.Number)
 		// mounted, err := filesystem.IsMounted(device, vpath)
 		// if err != nil {
 		// 	return nil, status.Errorf(codes.Internal, "mount check failed: target=%s, error=%v", vpath, err)
 		// }
 		// if mounted {
-		// 	log.Infof("umount %s %s", device, vpath)
+		// 	logger.Infof("umount %s %s", device, vpath)
 		// 	if err := s.mounter.Unmount(vpath); err != nil {
 		// 		return nil, status.Errorf(codes.Internal, "mount failed: volume=%s, error=%v", req.GetVolumeId(), err)
 		// 	}
@@ -764,7 +978,7 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		// if err := s.mounter.FormatAndMount(device, vpath, fsType, mountOptions); err != nil {
 		// 	return nil, status.Errorf(codes.Internal, "mount failed: volume=%s, error=%v", req.GetVolumeId(), err)
 		// }
-		// log.Info("NodeExpandVolume(fs) is succeeded",
+		// logger.Info("NodeExpandVolume(fs) is succeeded",
 		// 	" volume_id ", vid,
 		// 	" target_path ", vpath,
 		// )
@@ -772,12 +986,12 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		//return &csi.NodeExpandVolumeResponse{}, nil
 
 	default:
-		log.Errorf("Create LogicVolume: Create with no support volume type undefined")
+		logger.Errorf("Create LogicVolume: Create with no support volume type undefined")
 		return nil, status.Errorf(codes.InvalidArgument, "Create with no support type ")
 	}
 
 	args := []string{"-o", "source", "--noheadings", "--target", req.GetVolumePath()}
-	output, err := s.mounter.Exec.Command(findmntCmd, args...).Output()
+	output, err := s.mounter.Exec.Command(tools.Path("findmnt", findmntCmd), args...).Output()
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "findmnt error occured: %v", err)
 	}
@@ -787,14 +1001,30 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		return nil, status.Errorf(codes.Internal, "filesystem %s is not mounted at %s", vid, vpath)
 	}
 
+	if err := s.pool.Acquire(ctx, mutx.PriorityProvision, "expand "+vid); err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer s.pool.Release(mutx.PriorityProvision)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	// 两个副本的后端卷都已扩容，先扩展drbd设备
+	if replicatedVolume(lvr) {
+		if err := s.drbd.Resize(lvr.Name); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to resize drbd device of %s: %v", vid, err)
+		}
+	}
+	if encrypted {
+		if err := filesystem.LuksResize(filesystem.CryptMapperName(vid), req.GetSecrets()[utils.EncryptionPassphraseSecret]); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to resize luks device of %s: %v", vid, err)
+		}
+	}
 	r := filesystem.NewResizeFs(&s.mounter)
 	if _, err := r.Resize(device, vpath); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to resize filesystem %s (mounted at: %s): %v", vid, vpath, err)
 	}
 
-	log.Info("NodeExpandVolume(fs) is succeeded",
+	logger.Info("NodeExpandVolume(fs) is succeeded",
 		" volume_id ", vid,
 		" target_path ", vpath,
 	)
@@ -803,13 +1033,8 @@ func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 }
 
 func (s *nodeService) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	capabilities := []csi.NodeServiceCapability_RPC_Type{
-		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
-		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
-	}
-
-	csiCaps := make([]*csi.NodeServiceCapability, len(capabilities))
-	for i, capability := range capabilities {
+	csiCaps := make([]*csi.NodeServiceCapability, len(nodeCapabilities))
+	for i, capability := range nodeCapabilities {
 		csiCaps[i] = &csi.NodeServiceCapability{
 			Type: &csi.NodeServiceCapability_Rpc{
 				Rpc: &csi.NodeServiceCapability_RPC{
@@ -899,9 +1124,9 @@ func (s *nodeService) nodePublishBcacheVolume(ctx context.Context, req *csi.Node
 	isBlockVol := req.GetVolumeCapability().GetBlock() != nil
 	isFsVol := req.GetVolumeCapability().GetMount() != nil
 	if isBlockVol {
-		_, err = s.nodePublishBcacheBlockVolume(req, cacheDeviceInfo)
+		_, err = s.nodePublishBcacheBlockVolume(ctx, req, cacheDeviceInfo)
 	} else if isFsVol {
-		_, err = s.nodePublishBcacheFilesystemVolume(req, cacheDeviceInfo)
+		_, err = s.nodePublishBcacheFilesystemVolume(ctx, req, cacheDeviceInfo)
 	}
 	if err != nil {
 		return nil, err
@@ -910,7 +1135,8 @@ func (s *nodeService) nodePublishBcacheVolume(ctx context.Context, req *csi.Node
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
-func (s *nodeService) nodePublishBcacheBlockVolume(req *csi.NodePublishVolumeRequest, cacheDeviceInfo *types.BcacheDeviceInfo) (*csi.NodePublishVolumeResponse, error) {
+func (s *nodeService) nodePublishBcacheBlockVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, cacheDeviceInfo *types.BcacheDeviceInfo) (*csi.NodePublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	// Find lv and create a block device with it
 	var stat unix.Stat_t
 	target := req.GetTargetPath()
@@ -938,37 +1164,33 @@ func (s *nodeService) nodePublishBcacheBlockVolume(req *csi.NodePublishVolumeReq
 		return nil, status.Errorf(codes.Internal, "mknod failed for %s: error=%v", target, err)
 	}
 
-	log.Info("NodePublishVolume(block) succeeded",
+	logger.Info("NodePublishVolume(block) succeeded",
 		" volume_id ", req.GetVolumeId(),
 		" target_path ", target)
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
-func (s *nodeService) nodePublishBcacheFilesystemVolume(req *csi.NodePublishVolumeRequest, cacheDeviceInfo *types.BcacheDeviceInfo) (*csi.NodePublishVolumeResponse, error) {
+func (s *nodeService) nodePublishBcacheFilesystemVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, cacheDeviceInfo *types.BcacheDeviceInfo) (*csi.NodePublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
 	// Check request
 	mountOption := req.GetVolumeCapability().GetMount()
+	// pvc注解carina.storage.io/fstype优先于storageclass的fstype
+	if fsType := req.GetVolumeContext()[utils.VolumeFsType]; fsType != "" {
+		mountOption.FsType = fsType
+	}
 	if mountOption.FsType == "" {
 		mountOption.FsType = "ext4"
 	}
-	accessMode := req.GetVolumeCapability().GetAccessMode().GetMode()
-	if accessMode != csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER {
-		modeName := csi.VolumeCapability_AccessMode_Mode_name[int32(accessMode)]
-		return nil, status.Errorf(codes.FailedPrecondition, "unsupported access mode: %s", modeName)
-	}
-
-	var mountOptions []string
-	if req.GetReadonly() {
-		mountOptions = append(mountOptions, "ro")
+	if err := checkAccessMode(req.GetVolumeCapability()); err != nil {
+		return nil, err
 	}
 
-	for _, m := range mountOption.MountFlags {
-		if m == "rw" && req.GetReadonly() {
-			return nil, status.Error(codes.InvalidArgument, "mount option \"rw\" is specified even though read only mode is specified")
-		}
-		mountOptions = append(mountOptions, m)
+	mountOptions, err := publishMountOptions(req, mountOption.FsType)
+	if err != nil {
+		return nil, err
 	}
 
-	err := os.MkdirAll(req.GetTargetPath(), 0755)
+	err = os.MkdirAll(req.GetTargetPath(), 0755)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "mkdir failed: target=%s, error=%v", req.GetTargetPath(), err)
 	}
@@ -982,14 +1204,21 @@ func (s *nodeService) nodePublishBcacheFilesystemVolume(req *csi.NodePublishVolu
 		return nil, status.Errorf(codes.Internal, "target device is already formatted with different filesystem: volume=%s, current=%s, new:%s", req.GetVolumeId(), fsType, mountOption.FsType)
 	}
 
+	if err := s.checkSingleWriter(req, cacheDeviceInfo.BcachePath); err != nil {
+		return nil, err
+	}
+
 	mounted, err := filesystem.IsMounted(cacheDeviceInfo.BcachePath, req.GetTargetPath())
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "mount check failed: target=%s, error=%v", req.GetTargetPath(), err)
 	}
 
 	if !mounted {
-		log.Infof("mount %s %s %s %s", cacheDeviceInfo.BcachePath, req.GetTargetPath(), mountOption.FsType, strings.Join(mountOptions, ","))
-		if err := s.mounter.FormatAndMount(cacheDeviceInfo.BcachePath, req.GetTargetPath(), mountOption.FsType, mountOptions); err != nil {
+		if err := formatVolume(req, cacheDeviceInfo.BcachePath, mountOption.FsType, fsType); err != nil {
+			return nil, err
+		}
+		logger.Infof("mount %s %s %s %s", cacheDeviceInfo.BcachePath, req.GetTargetPath(), mountOption.FsType, strings.Join(mountOptions, ","))
+		if err := s.mountVolume(ctx, req, cacheDeviceInfo.BcachePath, mountOption.FsType, fsType, mountOptions); err != nil {
 			return nil, status.Errorf(codes.Internal, "mount failed: volume=%s, error=%v", req.GetVolumeId(), err)
 		}
 		if err := os.Chmod(req.GetTargetPath(), 0777|os.ModeSetgid); err != nil {
@@ -1002,7 +1231,7 @@ func (s *nodeService) nodePublishBcacheFilesystemVolume(req *csi.NodePublishVolu
 		}
 	}
 
-	log.Info("NodePublishVolume(fs) succeeded",
+	logger.Info("NodePublishVolume(fs) succeeded",
 		" volume_id ", req.GetVolumeId(),
 		" target_path ", req.GetTargetPath(),
 		" fstype ", mountOption.FsType)
@@ -0,0 +1,131 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"os"
+
+	"github.com/carina-io/carina/pkg/csidriver/filesystem"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// openEncryptedDevice 打开加密卷，返回pod实际使用的映射设备
+// Only volumes created encrypted reach here, a device without a luks header is
+// formatted on first use unless it already holds a filesystem, so that data is
+// never destroyed by mistake.
+func (s *nodeService) openEncryptedDevice(ctx context.Context, req *csi.NodePublishVolumeRequest, device string) (string, error) {
+	logger := log.FromContext(ctx)
+	volumeID := req.GetVolumeId()
+	mapper := filesystem.CryptMapperPath(volumeID)
+	if _, err := os.Stat(mapper); err == nil {
+		return mapper, nil
+	}
+
+	passphrase := req.GetSecrets()[utils.EncryptionPassphraseSecret]
+	if passphrase == "" {
+		return "", status.Errorf(codes.FailedPrecondition, "volume %s is encrypted but the node publish secret has no %s, set %s in the storageclass",
+			volumeID, utils.EncryptionPassphraseSecret, utils.NodePublishSecretName)
+	}
+
+	isLuks, err := filesystem.IsLuks(device)
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	if !isLuks {
+		fsType, err := filesystem.DetectFilesystem(device)
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "filesystem check failed: volume=%s, error=%v", volumeID, err)
+		}
+		if fsType != "" {
+			return "", status.Errorf(codes.FailedPrecondition, "volume %s must be encrypted but already holds an unencrypted %s filesystem", volumeID, fsType)
+		}
+		logger.Infof("format volume %s with luks", volumeID)
+		if err := filesystem.LuksFormat(device, passphrase); err != nil {
+			return "", status.Errorf(codes.Internal, "luks format failed: volume=%s, error=%v", volumeID, err)
+		}
+	}
+
+	// fstrim和discard挂载参数需要luks设备放行discard请求
+	allowDiscards := req.GetVolumeContext()[utils.VolumeFstrim] == "true" ||
+		utils.ContainsString(req.GetVolumeCapability().GetMount().GetMountFlags(), "discard")
+	if err := filesystem.LuksOpen(device, filesystem.CryptMapperName(volumeID), passphrase, allowDiscards); err != nil {
+		return "", status.Errorf(codes.Internal, "luks open failed: volume=%s, error=%v", volumeID, err)
+	}
+	return mapper, nil
+}
+
+// closeEncryptedDevice 关闭加密卷的映射设备，未打开时直接返回
+func (s *nodeService) closeEncryptedDevice(volumeID string) error {
+	if _, err := os.Stat(filesystem.CryptMapperPath(volumeID)); os.IsNotExist(err) {
+		return nil
+	}
+	if err := filesystem.LuksClose(filesystem.CryptMapperName(volumeID)); err != nil {
+		return status.Errorf(codes.Internal, "luks close failed: volume=%s, error=%v", volumeID, err)
+	}
+	return nil
+}
+
+// deviceNumber returns major and minor number of a block device
+func deviceNumber(device string) (uint32, uint32, error) {
+	var stat unix.Stat_t
+	if err := filesystem.Stat(device, &stat); err != nil {
+		return 0, 0, status.Errorf(codes.Internal, "failed to stat %s: error=%v", device, err)
+	}
+	return unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev)), nil
+}
+
+func (s *nodeService) nodeUnpublishEncryptedVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest, device string, isFsVol bool) (*csi.NodeUnpublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
+	target := req.GetTargetPath()
+	if isFsVol {
+		mounted := false
+		mapper := filesystem.CryptMapperPath(req.GetVolumeId())
+		if _, err := os.Stat(mapper); err == nil {
+			mounted, err = filesystem.IsMounted(mapper, target)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "mount check failed: target=%s, error=%v", target, err)
+			}
+		}
+		if mounted {
+			if err := s.mounter.Unmount(target); err != nil {
+				return nil, status.Errorf(codes.Internal, "unmount failed for %s: error=%v", target, err)
+			}
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return nil, status.Errorf(codes.Internal, "remove dir failed for %s: error=%v", target, err)
+		}
+	} else if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal, "remove failed for %s: error=%v", target, err)
+	}
+
+	if err := s.closeEncryptedDevice(req.GetVolumeId()); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(device); err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal, "remove device failed for %s: error=%v", device, err)
+	}
+	logger.Info("NodeUnpublishVolume(encrypted) is succeeded",
+		" volume_id ", req.GetVolumeId(),
+		" target_path ", target)
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
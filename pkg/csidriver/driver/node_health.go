@@ -0,0 +1,114 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/carina-io/carina/pkg/csidriver/filesystem"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// volumeCondition 检查卷的健康状况，供kubelet通过NodeGetVolumeStats上报
+// Problems found are joined into a single message, checks that can not be
+// performed are logged and skipped.
+func (s *nodeService) volumeCondition(ctx context.Context, volumeID, volumePath string, isBlock bool) *csi.VolumeCondition {
+	logger := log.FromContext(ctx)
+	problems := []string{}
+
+	if !isBlock {
+		ro, err := filesystem.IsRemountedReadOnly(volumePath)
+		if err != nil {
+			logger.Warnf("check read-only state of %s failed: %s", volumePath, err.Error())
+		} else if ro {
+			problems = append(problems, "filesystem has been remounted read-only")
+		}
+	}
+
+	problems = append(problems, s.deviceProblems(ctx, volumeID)...)
+
+	if len(problems) == 0 {
+		return &csi.VolumeCondition{Abnormal: false, Message: "volume is healthy"}
+	}
+	return &csi.VolumeCondition{Abnormal: true, Message: strings.Join(problems, "; ")}
+}
+
+// deviceProblems checks the lv state and the disks under the volume
+func (s *nodeService) deviceProblems(ctx context.Context, volumeID string) []string {
+	logger := log.FromContext(ctx)
+	problems := []string{}
+	lvr, err := s.k8sLVService.GetLogicVolume(ctx, volumeID)
+	if err != nil {
+		logger.Warnf("get LogicVolume %s for health check failed: %s", volumeID, err.Error())
+		return problems
+	}
+
+	disks := []string{}
+	switch lvr.Annotations[utils.VolumeManagerType] {
+	case utils.LvmVolumeType:
+		lv, err := s.getLvFromContext(lvr.Spec.DeviceGroup, volumeID)
+		if err != nil || lv == nil {
+			return append(problems, "logic volume is not found on node")
+		}
+		// lv_attr: the 5th char is the state, the 9th the health
+		if len(lv.LVAttr) > 4 {
+			switch lv.LVAttr[4] {
+			case 's', 'S':
+				problems = append(problems, "logic volume is suspended")
+			case '-':
+				problems = append(problems, "logic volume is not active")
+			}
+		}
+		if len(lv.LVAttr) > 8 {
+			switch lv.LVAttr[8] {
+			case 'p':
+				problems = append(problems, "logic volume is partial, physical volumes are missing")
+			case 'r':
+				problems = append(problems, "logic volume needs refresh")
+			case 'm':
+				problems = append(problems, "logic volume has mismatches")
+			}
+		}
+		pvs, err := s.volumeManager.GetCurrentPvStruct()
+		if err != nil {
+			logger.Warnf("get pv info for health check failed: %s", err.Error())
+			break
+		}
+		for _, pv := range pvs {
+			if pv.VGName == lv.VGName {
+				disks = append(disks, pv.PVName)
+			}
+		}
+	case utils.RawVolumeType:
+		disk, err := s.partition.ScanDisk(lvr.Spec.DeviceGroup)
+		if err != nil {
+			logger.Warnf("scan disk %s for health check failed: %s", lvr.Spec.DeviceGroup, err.Error())
+			break
+		}
+		disks = append(disks, disk.Path)
+	}
+
+	for _, d := range disks {
+		if p := filesystem.DiskErrors(d); p != "" {
+			problems = append(problems, p)
+		}
+	}
+	return problems
+}
@@ -0,0 +1,108 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/carina-io/carina/api"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/csidriver/driver/k8s"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/pkg/devicemanager/volume"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeVolume 返回固定的lv和pv信息
+type fakeVolume struct {
+	volume.LocalVolume
+	lvs []types.LvInfo
+	pvs []api.PVInfo
+}
+
+func (v *fakeVolume) VolumeList(lvName, vgName string) ([]types.LvInfo, error) {
+	return v.lvs, nil
+}
+
+func (v *fakeVolume) GetCurrentPvStruct() ([]api.PVInfo, error) {
+	return v.pvs, nil
+}
+
+func TestVolumeCondition(t *testing.T) {
+	const volumeID = "volume-pvc-1"
+	const vgName = "carina-vg-ssd"
+
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	lv := &carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pvc-1",
+			Namespace:   utils.LogicVolumeNamespace,
+			Annotations: map[string]string{utils.VolumeManagerType: utils.LvmVolumeType},
+		},
+		Spec:   carinav1.LogicVolumeSpec{NodeName: "node1", DeviceGroup: vgName},
+		Status: carinav1.LogicVolumeStatus{VolumeID: volumeID},
+	}
+	// 不存在的磁盘，DiskErrors不会报告问题
+	pvs := []api.PVInfo{{PVName: "/dev/carina-test-disk", VGName: vgName}}
+
+	table := []struct {
+		name     string
+		objects  []client.Object
+		lvAttr   string
+		lvFound  bool
+		isBlock  bool
+		abnormal bool
+		message  string
+	}{
+		{name: "healthy", objects: []client.Object{lv}, lvAttr: "-wi-ao----", lvFound: true, message: "volume is healthy"},
+		{name: "healthy block", objects: []client.Object{lv}, lvAttr: "-wi-ao----", lvFound: true, isBlock: true, message: "volume is healthy"},
+		{name: "suspended", objects: []client.Object{lv}, lvAttr: "-wi-so----", lvFound: true, abnormal: true, message: "logic volume is suspended"},
+		{name: "inactive", objects: []client.Object{lv}, lvAttr: "-wi-------", lvFound: true, abnormal: true, message: "logic volume is not active"},
+		{name: "partial", objects: []client.Object{lv}, lvAttr: "-wi-ao--p-", lvFound: true, abnormal: true, message: "logic volume is partial, physical volumes are missing"},
+		{name: "needs refresh", objects: []client.Object{lv}, lvAttr: "-wi-ao--r-", lvFound: true, abnormal: true, message: "logic volume needs refresh"},
+		{name: "mismatches", objects: []client.Object{lv}, lvAttr: "-wi-ao--m-", lvFound: true, abnormal: true, message: "logic volume has mismatches"},
+		{name: "several problems", objects: []client.Object{lv}, lvAttr: "-wi-s---p-", lvFound: true, abnormal: true, message: "logic volume is suspended; logic volume is partial, physical volumes are missing"},
+		{name: "lv missing on node", objects: []client.Object{lv}, abnormal: true, message: "logic volume is not found on node"},
+		{name: "no LogicVolume", lvAttr: "-wi-so----", lvFound: true, message: "volume is healthy"},
+	}
+
+	for _, c := range table {
+		t.Run(c.name, func(t *testing.T) {
+			vm := &fakeVolume{pvs: pvs}
+			if c.lvFound {
+				vm.lvs = []types.LvInfo{{LVName: volumeID, VGName: vgName, LVAttr: c.lvAttr}}
+			}
+			s := &nodeService{
+				volumeManager: vm,
+				k8sLVService: &k8s.LogicVolumeService{
+					Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(c.objects...).Build(),
+				},
+			}
+			// 临时目录不是挂载点，不会被判断为只读重挂载
+			condition := s.volumeCondition(context.Background(), volumeID, t.TempDir(), c.isBlock)
+			assert.Equal(t, c.abnormal, condition.Abnormal)
+			assert.Equal(t, c.message, condition.Message)
+		})
+	}
+}
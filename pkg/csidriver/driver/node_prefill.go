@@ -0,0 +1,134 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/populator"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// prefillVolume 在卷首次挂载前从s3加载数据集
+// The copy runs in the background on a private mount, until it completes the
+// publish call fails with Unavailable so that kubelet keeps retrying and the pod
+// never sees a half filled volume.
+func (s *nodeService) prefillVolume(req *csi.NodePublishVolumeRequest, device, fsType string) error {
+	volumeContext := req.GetVolumeContext()
+	if importSource := volumeContext[utils.VolumeImportSource]; importSource != "" {
+		return s.importVolume(req, device, fsType, importSource)
+	}
+	source := volumeContext[utils.VolumePrefillSource]
+	if source == "" {
+		return nil
+	}
+
+	src, err := populator.ParseS3URL(source)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid %s: %v", utils.VolumePrefillSource, err)
+	}
+	if endpoint := volumeContext[utils.VolumePrefillEndpoint]; endpoint != "" {
+		src.Endpoint = endpoint
+	}
+	if region := volumeContext[utils.VolumePrefillRegion]; region != "" {
+		src.Region = region
+	}
+	src.AccessKey = req.GetSecrets()[utils.PrefillAccessKeySecret]
+	src.SecretKey = req.GetSecrets()[utils.PrefillSecretKeySecret]
+
+	parallelism := 0
+	if p := volumeContext[utils.VolumePrefillParallelism]; p != "" {
+		parallelism, err = strconv.Atoi(p)
+		if err != nil || parallelism <= 0 {
+			return status.Errorf(codes.InvalidArgument, "invalid %s: %s", utils.VolumePrefillParallelism, p)
+		}
+	}
+
+	progress := s.populator.Populate(populator.Request{
+		VolumeID:    req.GetVolumeId(),
+		Device:      device,
+		FsType:      fsType,
+		Source:      src,
+		Parallelism: parallelism,
+	})
+	return prefillStatus(req.GetVolumeId(), progress)
+}
+
+// importVolume 将本节点上已有的目录拷贝到新卷中，与s3预填充共用同一套流程
+func (s *nodeService) importVolume(req *csi.NodePublishVolumeRequest, device, fsType, source string) error {
+	node, path, err := utils.ParseImportSource(source)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid %s: %v", utils.VolumeImportSource, err)
+	}
+	if node != s.nodeName {
+		return status.Errorf(codes.FailedPrecondition, "volume %s imports from node %s, not %s", req.GetVolumeId(), node, s.nodeName)
+	}
+	// the allow list is checked again, the configmap may have changed since provisioning
+	if !utils.PathWithin(path, configuration.ImportHostPaths()) {
+		return status.Errorf(codes.PermissionDenied, "import from %s is not allowed by importHostPaths", path)
+	}
+
+	progress := s.populator.Populate(populator.Request{
+		VolumeID: req.GetVolumeId(),
+		Device:   device,
+		FsType:   fsType,
+		HostPath: path,
+	})
+	return prefillStatus(req.GetVolumeId(), progress)
+}
+
+func prefillStatus(volumeID string, progress populator.Progress) error {
+	switch progress.State {
+	case populator.StateSucceeded:
+		return nil
+	case populator.StateFailed:
+		return status.Errorf(codes.Internal, "prefill volume %s failed: %s", volumeID, progress.Message)
+	default:
+		return status.Errorf(codes.Unavailable, "volume %s is being prefilled, %s", volumeID, progress.String())
+	}
+}
+
+// reportPrefillProgress mirrors populator progress into the LogicVolume conditions
+func (s *nodeService) reportPrefillProgress(volumeID string, progress populator.Progress) {
+	condition := metav1.Condition{
+		Type:    utils.ConditionPrefilled,
+		Status:  metav1.ConditionFalse,
+		Reason:  "Populating",
+		Message: progress.String(),
+	}
+	switch progress.State {
+	case populator.StateSucceeded:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Populated"
+	case populator.StateFailed:
+		condition.Reason = "PopulateFailed"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.k8sLVService.SetLogicVolumeCondition(ctx, volumeID, condition); err != nil {
+		log.Warnf("update prefill condition of volume %s failed: %s", volumeID, err.Error())
+	}
+}
@@ -0,0 +1,201 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/devicemanager/drbd"
+	"github.com/carina-io/carina/pkg/version"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// volumeReplicas 解析storageclass的副本数，只支持1和2
+func volumeReplicas(parameters map[string]string) (int, error) {
+	v := parameters[utils.VolumeReplicas]
+	if v == "" {
+		return 1, nil
+	}
+	replicas, err := strconv.Atoi(v)
+	if err != nil || replicas < 1 || replicas > 2 {
+		return 0, fmt.Errorf("%s must be 1 or 2, got %q", utils.VolumeReplicas, v)
+	}
+	return replicas, nil
+}
+
+// createReplicatedVolume creates a volume with a copy on a second node. Both copies are lvm
+// volumes of the same device group, a drbd resource on top of them keeps them in sync and the
+// pv may be used on either node. The second LogicVolume is owned by the first one, like the
+// cache volume of a bcache volume.
+func (s controllerService) createReplicatedVolume(ctx context.Context, req *csi.CreateVolumeRequest, name, node, deviceGroup string, requestGb int64) (*csi.CreateVolumeResponse, error) {
+	logger := log.FromContext(ctx)
+	pvcName := req.Parameters["csi.storage.k8s.io/pvc/name"]
+	namespace := req.Parameters["csi.storage.k8s.io/pvc/namespace"]
+	requirements := req.GetAccessibilityRequirements()
+
+	if req.GetVolumeContentSource() != nil {
+		return nil, status.Error(codes.InvalidArgument, "replicated volumes can not be restored from snapshots")
+	}
+	if ratio := req.GetParameters()[utils.VolumeCacheDiskRatio]; ratio != "" && ratio != "0" {
+		return nil, status.Error(codes.InvalidArgument, "bcache volumes can not be replicated")
+	}
+	if version.CheckRawDeviceGroup(deviceGroup) {
+		return nil, status.Error(codes.InvalidArgument, "only lvm volumes can be replicated")
+	}
+
+	// 重试的CreateVolume沿用已创建的LogicVolume的节点、磁盘组和minor
+	existing, err := s.lvService.GetReplicatedVolume(ctx, name)
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, err
+	}
+	if existing != nil {
+		node = existing.Spec.NodeName
+		deviceGroup = existing.Spec.DeviceGroup
+	}
+
+	segments := map[string]string{}
+	if node == "" {
+		node, deviceGroup, segments, err = s.nodeService.SelectVolumeNode(ctx, requestGb, deviceGroup, requirements)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get max capacity node %v", err)
+		}
+	} else if deviceGroup == "" {
+		deviceGroup, err = s.nodeService.SelectDeviceGroup(ctx, requestGb, node, utils.LvmVolumeType, false)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get device group %v", err)
+		}
+	}
+	if deviceGroup == "" {
+		return nil, status.Error(codes.Internal, "can not find any device group")
+	}
+	if encryptionRequired(req.GetParameters(), deviceGroup) {
+		return nil, status.Errorf(codes.InvalidArgument, "disk group %s requires encryption, which is not supported for replicated volumes", deviceGroup)
+	}
+
+	var replicaNode string
+	if existing != nil {
+		replicaNode = existing.Annotations[utils.VolumeReplicaNode]
+	} else if replicaNode, err = s.nodeService.SelectReplicaNode(ctx, requestGb, deviceGroup, node, requirements); err != nil {
+		return nil, status.Errorf(codes.ResourceExhausted, "no node other than %s has %dGi free in %s for the second replica: %v", node, requestGb, deviceGroup, err)
+	}
+	// 没有第三个节点时，一个副本失效后另一个也失去仲裁
+	var tiebreaker string
+	if existing != nil {
+		tiebreaker = existing.Annotations[utils.VolumeDrbdTiebreaker]
+	} else if tiebreaker, err = s.nodeService.SelectTiebreakerNode(ctx, node, replicaNode); err != nil {
+		return nil, status.Errorf(codes.ResourceExhausted, "no third node running carina-node for the drbd tiebreaker of the replicas on %s and %s: %v", node, replicaNode, err)
+	}
+
+	// 两个副本都计入配额
+	release, err := s.lvService.ReserveQuota(ctx, namespace, name, deviceGroup, map[string]int64{deviceGroup: 2 * requestGb << 30})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// minor在两个节点上相同，分配与创建LogicVolume之间不能插入其它副本卷
+	s.drbdMutex.Lock()
+	var minor int
+	if existing != nil {
+		minor, err = strconv.Atoi(existing.Annotations[utils.VolumeDrbdMinor])
+	} else {
+		minor, err = s.lvService.NextDrbdMinor(ctx)
+	}
+	if err != nil {
+		s.drbdMutex.Unlock()
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	annotation := map[string]string{
+		utils.VolumeManagerType:    utils.LvmVolumeType,
+		utils.ExclusivityDisk:      "false",
+		utils.VolumeReplicaNode:    replicaNode,
+		utils.VolumeDrbdMinor:      strconv.Itoa(minor),
+		utils.VolumeDrbdTiebreaker: tiebreaker,
+	}
+	addWipeAnnotations(annotation, req.GetParameters())
+	logger.Infof("CreateVolume: replicated volume %s on node %s and %s, tiebreaker %s, device group %s, drbd minor %d", name, node, replicaNode, tiebreaker, deviceGroup, minor)
+	volumeID, _, _, err := s.lvService.CreateVolume(ctx, namespace, pvcName, node, deviceGroup, name, requestGb, metav1.OwnerReference{}, annotation)
+	s.drbdMutex.Unlock()
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, err
+	}
+
+	lv, err := s.lvService.GetLogicVolume(ctx, volumeID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	owner := logicVolumeOwner(lv)
+	replicaAnnotation := map[string]string{
+		utils.VolumeManagerType: utils.LvmVolumeType,
+		utils.VolumeReplicaOf:   name,
+		utils.VolumeDrbdMinor:   strconv.Itoa(minor),
+	}
+	addWipeAnnotations(replicaAnnotation, req.GetParameters())
+	_, _, _, err = s.lvService.CreateVolume(ctx, namespace, pvcName, replicaNode, deviceGroup, drbd.ReplicaName(name), requestGb, owner, replicaAnnotation)
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, err
+	}
+
+	volumeContext := req.GetParameters()
+	volumeContext[utils.DeviceDiskKey] = deviceGroup
+	volumeContext[utils.VolumeDevicePath] = drbd.DevicePath(minor)
+	volumeContext[utils.VolumeDeviceNode] = node
+	volumeContext[utils.VolumeDeviceMajor] = strconv.Itoa(drbd.Major)
+	volumeContext[utils.VolumeDeviceMinor] = strconv.Itoa(minor)
+	volumeContext[utils.VolumeReplicaNode] = replicaNode
+	volumeContext[utils.VolumeDrbdMinor] = strconv.Itoa(minor)
+
+	// pv可以在任一副本所在的节点上使用
+	segments[utils.TopologyNodeKey] = node
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			CapacityBytes: requestGb << 30,
+			VolumeId:      volumeID,
+			VolumeContext: volumeContext,
+			AccessibleTopology: []*csi.Topology{
+				{Segments: segments},
+				{Segments: map[string]string{utils.TopologyNodeKey: replicaNode}},
+			},
+		},
+	}, nil
+}
+
+// expandReplica expands the second copy of a replicated volume to the size of the first one,
+// the node publishing the volume grows the drbd device once both are expanded.
+func (s controllerService) expandReplica(ctx context.Context, lv *carinav1.LogicVolume, requestGb int64) error {
+	if lv.Annotations[utils.VolumeReplicaNode] == "" {
+		return nil
+	}
+	return s.lvService.ExpandVolume(ctx, "volume-"+drbd.ReplicaName(lv.Name), requestGb)
+}
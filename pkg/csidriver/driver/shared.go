@@ -0,0 +1,97 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/nfsgateway"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// nodePublishSharedVolume 挂载共享卷的nfs网关导出
+// The gateway runs on the node of the volume, pods on that node mount the export as well, so that
+// the volume is only ever mounted once and the gateway serves every writer.
+func (s *nodeService) nodePublishSharedVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, lvr *carinav1.LogicVolume) (*csi.NodePublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
+	if req.GetVolumeCapability().GetMount() == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "shared volume %s can only be published as a filesystem", req.GetVolumeId())
+	}
+	server := lvr.Annotations[utils.VolumeNfsServer]
+	if server == "" {
+		return nil, status.Errorf(codes.Unavailable, "nfs gateway of volume %s is not ready yet", req.GetVolumeId())
+	}
+	target := req.GetTargetPath()
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, status.Errorf(codes.Internal, "mkdir failed: target=%s, error=%v", target, err)
+	}
+	notMounted, err := s.mounter.IsLikelyNotMountPoint(target)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "mount check failed: target=%s, error=%v", target, err)
+	}
+	if !notMounted {
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	mountOptions, err := publishMountOptions(req, "nfs")
+	if err != nil {
+		return nil, err
+	}
+	mountOptions = nfsgateway.MountOptions(mountOptions)
+	source := nfsgateway.Source(server)
+	logger.Infof("mount %s %s nfs %s", source, target, strings.Join(mountOptions, ","))
+	if err := s.mounter.Mount(source, target, "nfs", mountOptions); err != nil {
+		return nil, status.Errorf(codes.Internal, "mount nfs failed: volume=%s, source=%s, error=%v", req.GetVolumeId(), source, err)
+	}
+	logger.Info("NodePublishVolume(nfs) succeeded",
+		" volume_id ", req.GetVolumeId(),
+		" target_path ", target,
+		" source ", source)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// nodeUnpublishSharedVolume 卸载nfs导出，卷本身由网关卸载
+func (s *nodeService) nodeUnpublishSharedVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
+	target := req.GetTargetPath()
+	notMounted, err := s.mounter.IsLikelyNotMountPoint(target)
+	if os.IsNotExist(err) {
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "mount check failed: target=%s, error=%v", target, err)
+	}
+	if !notMounted {
+		if err := s.mounter.Unmount(target); err != nil {
+			return nil, status.Errorf(codes.Internal, "unmount failed for %s: error=%v", target, err)
+		}
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return nil, status.Errorf(codes.Internal, "remove dir failed for %s: error=%v", target, err)
+	}
+	logger.Info("NodeUnpublishVolume(nfs) is succeeded",
+		" volume_id ", req.GetVolumeId(),
+		" target_path ", target)
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
@@ -0,0 +1,107 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/carina-io/carina/pkg/devicemanager/tools"
+	"github.com/carina-io/carina/utils/log"
+)
+
+const (
+	cryptsetupCmd = "/sbin/cryptsetup"
+	// mapperDirectory luks设备打开后所在目录
+	mapperDirectory = "/dev/mapper"
+)
+
+// CryptMapperName returns the device mapper name the luks device of a volume is opened as
+func CryptMapperName(volumeID string) string {
+	return "luks-" + volumeID
+}
+
+// CryptMapperPath returns the path of the opened luks device of a volume
+func CryptMapperPath(volumeID string) string {
+	return filepath.Join(mapperDirectory, CryptMapperName(volumeID))
+}
+
+// IsLuks returns true if device carries a luks header.
+func IsLuks(device string) (bool, error) {
+	if err := tools.Check(tools.FeatureEncryption); err != nil {
+		return false, err
+	}
+	err := exec.Command(tools.Path("cryptsetup", cryptsetupCmd), "isLuks", device).Run()
+	if err == nil {
+		return true, nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return false, fmt.Errorf("cryptsetup isLuks failed: device=%s, error=%v", device, err)
+}
+
+// LuksFormat writes a luks2 header protected by passphrase to device, all data on it is lost
+func LuksFormat(device, passphrase string) error {
+	return cryptsetup(passphrase, "luksFormat", "--batch-mode", "--type", "luks2", "--key-file", "-", device)
+}
+
+// LuksOpen maps the luks device to /dev/mapper/name. Discards of the filesystem only
+// reach the underlying device if allowDiscards is set.
+func LuksOpen(device, name, passphrase string, allowDiscards bool) error {
+	args := []string{"luksOpen", "--key-file", "-"}
+	if allowDiscards {
+		args = append(args, "--allow-discards")
+	}
+	return cryptsetup(passphrase, append(args, device, name)...)
+}
+
+// LuksClose removes the mapping created by LuksOpen
+func LuksClose(name string) error {
+	return cryptsetup("", "luksClose", name)
+}
+
+// LuksResize grows the opened luks device to the size of the underlying device.
+// luks2 keeps the volume key in the kernel keyring, the passphrase is only
+// needed if that is not available.
+func LuksResize(name, passphrase string) error {
+	args := []string{"resize"}
+	if passphrase != "" {
+		args = append(args, "--key-file", "-")
+	}
+	return cryptsetup(passphrase, append(args, name)...)
+}
+
+// cryptsetup runs cryptsetup, the passphrase is passed on stdin and never logged
+func cryptsetup(passphrase string, args ...string) error {
+	if err := tools.Check(tools.FeatureEncryption); err != nil {
+		return err
+	}
+	command := tools.Path("cryptsetup", cryptsetupCmd)
+	log.Infof("%s %s", command, strings.Join(args, " "))
+	cmd := exec.Command(command, args...)
+	if passphrase != "" {
+		cmd.Stdin = strings.NewReader(passphrase)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cryptsetup %s failed: output=%s, error=%v", args[0], strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
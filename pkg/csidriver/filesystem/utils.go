@@ -18,12 +18,17 @@ package filesystem
 
 import (
 	"fmt"
+	"github.com/carina-io/carina/pkg/devicemanager/tools"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
 	"github.com/carina-io/carina/utils/log"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -87,6 +92,44 @@ func IsMounted(device, target string) (bool, error) {
 	return false, nil
 }
 
+// MountPoints returns the paths device is mounted on.
+func MountPoints(device string) ([]string, error) {
+	data, err := ioutil.ReadFile("/proc/mounts")
+	if err != nil {
+		return nil, fmt.Errorf("could not read /proc/mounts: %v", err)
+	}
+
+	paths := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		if same, err := isSameDevice(device, fields[0]); err == nil && same {
+			paths = append(paths, fields[1])
+		}
+	}
+	return paths, nil
+}
+
+// DeviceMounts returns the mounts of block devices in /proc/mounts.
+func DeviceMounts() ([]types.MountEntry, error) {
+	data, err := ioutil.ReadFile("/proc/mounts")
+	if err != nil {
+		return nil, fmt.Errorf("could not read /proc/mounts: %v", err)
+	}
+
+	mounts := []types.MountEntry{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		mounts = append(mounts, types.MountEntry{Device: fields[0], Path: fields[1], FsType: fields[2], Options: fields[3]})
+	}
+	return mounts, nil
+}
+
 // DetectFilesystem returns filesystem type if device has a filesystem.
 // This returns an empty string if no filesystem exists.
 func DetectFilesystem(device string) (string, error) {
@@ -98,8 +141,9 @@ func DetectFilesystem(device string) (string, error) {
 	f.Sync()
 	f.Close()
 
-	log.Infof("%s -c /dev/null -o export %s", blkidCmd, device)
-	out, err := exec.Command(blkidCmd, "-c", "/dev/null", "-o", "export", device).CombinedOutput()
+	command := tools.Path("blkid", blkidCmd)
+	log.Infof("%s -c /dev/null -o export %s", command, device)
+	out, err := exec.Command(command, "-c", "/dev/null", "-o", "export", device).CombinedOutput()
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			// blkid exists with status 2 when anything can be found
@@ -120,6 +164,24 @@ func DetectFilesystem(device string) (string, error) {
 	return "", nil
 }
 
+// Mkfs formats device with the same defaults as kubelet, options are appended so they can override them
+func Mkfs(device, fsType string, options []string) error {
+	args := []string{}
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		args = append(args, "-F", "-m0")
+	case "xfs":
+		args = append(args, "-f")
+	}
+	args = append(append(args, options...), device)
+	log.Infof("mkfs.%s %s", fsType, strings.Join(args, " "))
+	out, err := exec.Command("mkfs."+fsType, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mkfs.%s failed: output=%s, device=%s, error=%v", fsType, strings.TrimSpace(string(out)), device, err)
+	}
+	return nil
+}
+
 // Stat wrapped a golang.org/x/sys/unix.Stat function to handle EINTR signal for Go 1.14+
 func Stat(path string, stat *unix.Stat_t) error {
 	for {
@@ -161,3 +223,139 @@ func Statfs(path string, buf *unix.Statfs_t) (err error) {
 		return err
 	}
 }
+
+// IsRemountedReadOnly returns true if the filesystem mounted on target has been
+// switched to read-only underneath a read-write mount, which is what ext4
+// errors=remount-ro and similar error handling do.
+// A volume published read-only is read-only at both levels and is not reported.
+func IsRemountedReadOnly(target string) (bool, error) {
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		return false, err
+	}
+	target, err = filepath.EvalSymlinks(abs)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return false, fmt.Errorf("could not read /proc/self/mountinfo: %v", err)
+	}
+	return remountedReadOnly(string(data), target), nil
+}
+
+// remountedReadOnly 在mountinfo中查找挂载到target的文件系统，比较挂载选项和超级块选项
+func remountedReadOnly(mountinfo, target string) bool {
+	for _, line := range strings.Split(mountinfo, "\n") {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(line)
+		if len(fields) < 10 || fields[4] != target {
+			continue
+		}
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || sep+3 >= len(fields) {
+			continue
+		}
+		mountRO := hasOption(fields[5], "ro")
+		superRO := hasOption(fields[sep+3], "ro")
+		return superRO && !mountRO
+	}
+	return false
+}
+
+func hasOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// ioErrorQuietPeriod io错误计数不再增加多久之后不再报告
+const ioErrorQuietPeriod = 10 * time.Minute
+
+// ioErrors tracks ioerr_cnt of disks. The counter in sysfs is a running total until
+// the next reboot, only an increase since the last sample is a new problem.
+type ioErrors struct {
+	mu   sync.Mutex
+	now  func() time.Time
+	seen map[string]*ioErrorCount
+}
+
+type ioErrorCount struct {
+	count int64
+	// 最近一次增加的数量和时间
+	added  int64
+	raised time.Time
+}
+
+var diskIOErrors = &ioErrors{now: time.Now, seen: map[string]*ioErrorCount{}}
+
+// observe records the counter of the disk and returns the errors added within the quiet period.
+// The first sample of a disk is the baseline, errors from before carina-node started are not reported.
+func (e *ioErrors) observe(disk string, count int64) int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	last, ok := e.seen[disk]
+	if !ok {
+		e.seen[disk] = &ioErrorCount{count: count}
+		return 0
+	}
+	now := e.now()
+	if count > last.count {
+		last.added = count - last.count
+		last.raised = now
+	}
+	// 计数减少说明设备被重新探测，以新的计数为基准
+	last.count = count
+	if last.added > 0 && now.Sub(last.raised) < ioErrorQuietPeriod {
+		return last.added
+	}
+	last.added = 0
+	return 0
+}
+
+// DiskErrors inspects sysfs of the disk backing device and returns a
+// description of any problem found, or an empty string if the disk looks healthy.
+// Partitions are resolved to their parent disk.
+func DiskErrors(device string) string {
+	return diskErrors("/sys/class/block", device, diskIOErrors)
+}
+
+func diskErrors(sysBlock, device string, tracker *ioErrors) string {
+	name := filepath.Base(device)
+	sysPath, err := filepath.EvalSymlinks(filepath.Join(sysBlock, name))
+	if err != nil {
+		return ""
+	}
+	if _, err := os.Stat(filepath.Join(sysPath, "partition")); err == nil {
+		sysPath = filepath.Dir(sysPath)
+		name = filepath.Base(sysPath)
+	}
+
+	problems := []string{}
+	if state, err := ioutil.ReadFile(filepath.Join(sysPath, "device", "state")); err == nil {
+		s := strings.TrimSpace(string(state))
+		// scsi reports running, nvme reports live
+		if s != "" && s != "running" && s != "live" {
+			problems = append(problems, fmt.Sprintf("disk %s state is %s", name, s))
+		}
+	}
+	if cnt, err := ioutil.ReadFile(filepath.Join(sysPath, "device", "ioerr_cnt")); err == nil {
+		n, err := strconv.ParseInt(strings.TrimSpace(string(cnt)), 0, 64)
+		if err == nil {
+			if added := tracker.observe(name, n); added > 0 {
+				problems = append(problems, fmt.Sprintf("disk %s has %d new io errors", name, added))
+			}
+		}
+	}
+	return strings.Join(problems, ", ")
+}
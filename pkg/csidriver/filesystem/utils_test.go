@@ -0,0 +1,127 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemountedReadOnly(t *testing.T) {
+	mountinfo := `22 1 253:0 / / rw,relatime shared:1 - xfs /dev/mapper/centos-root rw,attr2,inode64,noquota
+1021 22 253:3 / /var/lib/kubelet/pods/uid-1/volumes/kubernetes.io~csi/pvc-1/mount rw,relatime shared:501 - ext4 /dev/mapper/carina--vg--ssd-volume--pvc--1 ro,relatime,errors=remount-ro
+1022 22 253:4 / /var/lib/kubelet/pods/uid-2/volumes/kubernetes.io~csi/pvc-2/mount rw,relatime shared:502 - ext4 /dev/mapper/carina--vg--ssd-volume--pvc--2 rw,relatime,errors=remount-ro
+1023 22 253:5 / /var/lib/kubelet/pods/uid-3/volumes/kubernetes.io~csi/pvc-3/mount ro,relatime shared:503 - xfs /dev/mapper/carina--vg--ssd-volume--pvc--3 ro,attr2,inode64
+1024 22 253:6 / /var/lib/kubelet/pods/uid-4/volumes/kubernetes.io~csi/pvc-4/mount rw,relatime shared:504 master:1 - ext4 /dev/mapper/carina--vg--hdd-volume--pvc--4 ro,relatime
+`
+	table := []struct {
+		target   string
+		readOnly bool
+	}{
+		{target: "/var/lib/kubelet/pods/uid-1/volumes/kubernetes.io~csi/pvc-1/mount", readOnly: true},
+		{target: "/var/lib/kubelet/pods/uid-2/volumes/kubernetes.io~csi/pvc-2/mount", readOnly: false},
+		// 以只读方式发布的卷不算被重新挂载为只读
+		{target: "/var/lib/kubelet/pods/uid-3/volumes/kubernetes.io~csi/pvc-3/mount", readOnly: false},
+		// 多个可选字段
+		{target: "/var/lib/kubelet/pods/uid-4/volumes/kubernetes.io~csi/pvc-4/mount", readOnly: true},
+		{target: "/var/lib/kubelet/pods/uid-5/volumes/kubernetes.io~csi/pvc-5/mount", readOnly: false},
+		{target: "/", readOnly: false},
+	}
+	for _, d := range table {
+		assert.Equal(t, d.readOnly, remountedReadOnly(mountinfo, d.target), d.target)
+	}
+}
+
+func TestDiskErrors(t *testing.T) {
+	a := assert.New(t)
+	root, err := ioutil.TempDir("", "sysfs")
+	a.NoError(err)
+	defer os.RemoveAll(root)
+
+	// 模拟/sys/class/block下指向/sys/devices的链接，分区位于磁盘目录下
+	files := map[string]string{
+		"devices/sda/device/state":        "running\n",
+		"devices/sda/device/ioerr_cnt":    "0x0\n",
+		"devices/sda/sda1/partition":      "1\n",
+		"devices/sdb/device/state":        "offline\n",
+		"devices/sdb/device/ioerr_cnt":    "0x1a\n",
+		"devices/sdc/device/state":        "running\n",
+		"devices/sdc/device/ioerr_cnt":    "0x3\n",
+		"devices/sdc/sdc2/partition":      "2\n",
+		"devices/nvme0n1/device/state":    "live\n",
+		"devices/nvme1n1/device/state":    "dead\n",
+		"devices/loop2/loop/backing_file": "/var/lib/carina/disk.img\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		a.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		a.NoError(ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	links := map[string]string{
+		"sda": "devices/sda", "sda1": "devices/sda/sda1", "sdb": "devices/sdb", "sdc": "devices/sdc",
+		"sdc2": "devices/sdc/sdc2", "nvme0n1": "devices/nvme0n1", "nvme1n1": "devices/nvme1n1", "loop2": "devices/loop2",
+	}
+	sysBlock := filepath.Join(root, "class")
+	a.NoError(os.MkdirAll(sysBlock, 0755))
+	for name, target := range links {
+		a.NoError(os.Symlink(filepath.Join(root, target), filepath.Join(sysBlock, name)))
+	}
+
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker := &ioErrors{now: func() time.Time { return now }, seen: map[string]*ioErrorCount{}}
+
+	// 第一次采样作为基准，此前的io错误不报告
+	table := []struct {
+		device   string
+		problems string
+	}{
+		{device: "/dev/sda", problems: ""},
+		{device: "/dev/sda1", problems: ""},
+		{device: "/dev/sdb", problems: "disk sdb state is offline"},
+		{device: "/dev/sdc2", problems: ""},
+		{device: "/dev/nvme0n1", problems: ""},
+		{device: "/dev/nvme1n1", problems: "disk nvme1n1 state is dead"},
+		{device: "/dev/loop2", problems: ""},
+		{device: "/dev/sdz", problems: ""},
+	}
+	for _, d := range table {
+		a.Equal(d.problems, diskErrors(sysBlock, d.device, tracker), d.device)
+	}
+
+	// 计数增加后报告新增的错误，安静期内保持，之后清除
+	a.NoError(ioutil.WriteFile(filepath.Join(root, "devices/sdc/device/ioerr_cnt"), []byte("0x5\n"), 0644))
+	samples := []struct {
+		after    time.Duration
+		problems string
+	}{
+		{after: time.Minute, problems: "disk sdc has 2 new io errors"},
+		{after: 5 * time.Minute, problems: "disk sdc has 2 new io errors"},
+		{after: 11 * time.Minute, problems: ""},
+		{after: 12 * time.Minute, problems: ""},
+	}
+	start := now
+	for _, sample := range samples {
+		now = start.Add(sample.after)
+		a.Equal(sample.problems, diskErrors(sysBlock, "/dev/sdc2", tracker), sample.after.String())
+	}
+	a.Equal("disk sdb state is offline", diskErrors(sysBlock, "/dev/sdb", tracker))
+}
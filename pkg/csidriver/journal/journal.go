@@ -0,0 +1,283 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/carina-io/carina/utils/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	PhaseRequest  = "request"
+	PhaseResponse = "response"
+
+	strippedSecret = "***stripped***"
+	maxRecordSize  = 1 << 20
+)
+
+// quietMethods 周期性轮询的rpc不记录，避免冲掉有用的记录
+var quietMethods = map[string]bool{
+	"Probe":                     true,
+	"GetPluginInfo":             true,
+	"GetPluginCapabilities":     true,
+	"ControllerGetCapabilities": true,
+	"NodeGetCapabilities":       true,
+	"NodeGetInfo":               true,
+	"NodeGetVolumeStats":        true,
+	"GetCapacity":               true,
+}
+
+// Quiet returns whether the rpc is polled periodically and neither journaled nor logged per call
+func Quiet(fullMethod string) bool {
+	return quietMethods[path.Base(fullMethod)]
+}
+
+// Record is one line of the journal, a request and its response share the ID, RequestID is the one in the logs
+type Record struct {
+	ID        uint64          `json:"id"`
+	RequestID string          `json:"requestID,omitempty"`
+	Time      time.Time       `json:"time"`
+	Method    string          `json:"method"`
+	Phase     string          `json:"phase"`
+	Duration  string          `json:"duration,omitempty"`
+	Code      string          `json:"code,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// Journal 持久化最近的csi请求和应答，进程崩溃后仍可查看kubelet请求了什么
+// Records are appended to path as json lines as soon as a request arrives, so
+// the request a daemon crashed in is kept as well. Once the file holds size
+// records it is rotated to path.1, Dump returns the last size records of both.
+type Journal struct {
+	// seq is accessed atomically, keep it first for 64-bit alignment on 32-bit platforms
+	seq   uint64
+	mu    sync.Mutex
+	path  string
+	size  int
+	count int
+	file  *os.File
+}
+
+// New opens the journal at path, records of a previous run are kept
+func New(path string, size int) (*Journal, error) {
+	if size < 1 {
+		size = 1
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	j := &Journal{path: path, size: size}
+
+	records, err := readRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	j.count = len(records)
+	if len(records) == 0 {
+		records, err = readRecords(path + ".1")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(records) > 0 {
+		j.seq = records[len(records)-1].ID
+	}
+
+	j.file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Append writes a record to the journal
+func (j *Journal) Append(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.count >= j.size {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	j.count++
+	return nil
+}
+
+// rotate must hold mu
+func (j *Journal) rotate() error {
+	_ = j.file.Close()
+	if err := os.Rename(j.path, j.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	j.file = f
+	j.count = 0
+	return nil
+}
+
+// Dump returns the last n records, oldest first. n <= 0 returns all retained records.
+func (j *Journal) Dump(n int) ([]Record, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	records, err := readRecords(j.path + ".1")
+	if err != nil {
+		return nil, err
+	}
+	current, err := readRecords(j.path)
+	if err != nil {
+		return nil, err
+	}
+	records = append(records, current...)
+	if n <= 0 || n > j.size {
+		n = j.size
+	}
+	if len(records) > n {
+		records = records[len(records)-n:]
+	}
+	return records, nil
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// UnaryServerInterceptor journals every csi request and its response with secrets stripped.
+// A failing journal is logged and never fails the request.
+func (j *Journal) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if Quiet(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		id := atomic.AddUint64(&j.seq, 1)
+		requestID := log.RequestID(ctx)
+		start := time.Now()
+		j.record(&Record{ID: id, RequestID: requestID, Time: start, Method: info.FullMethod, Phase: PhaseRequest, Payload: sanitize(req)})
+
+		resp, err := handler(ctx, req)
+
+		r := &Record{
+			ID:        id,
+			RequestID: requestID,
+			Time:      time.Now(),
+			Method:    info.FullMethod,
+			Phase:     PhaseResponse,
+			Duration:  time.Since(start).String(),
+			Code:      status.Code(err).String(),
+		}
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Payload = sanitize(resp)
+		}
+		j.record(r)
+		return resp, err
+	}
+}
+
+func (j *Journal) record(r *Record) {
+	if err := j.Append(r); err != nil {
+		log.Warnf("journal %s %s of %s failed: %s", r.Phase, r.Method, j.path, err.Error())
+	}
+}
+
+// readRecords reads a journal file, a torn last line left by a crash is skipped
+func readRecords(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	records := []Record{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for scanner.Scan() {
+		r := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// sanitize 将csi消息转为json，去掉所有secrets字段
+func sanitize(msg interface{}) json.RawMessage {
+	if msg == nil {
+		return nil
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil
+	}
+	stripSecrets(v)
+	data, err = json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func stripSecrets(v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if k == "secrets" && e != nil {
+				t[k] = strippedSecret
+				continue
+			}
+			stripSecrets(e)
+		}
+	case []interface{}:
+		for _, e := range t {
+			stripSecrets(e)
+		}
+	}
+}
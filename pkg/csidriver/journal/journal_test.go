@@ -0,0 +1,71 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package journal
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalRotate(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "csi-journal.log")
+
+	j, err := New(path, 3)
+	a.NoError(err)
+	for i := 1; i <= 5; i++ {
+		a.NoError(j.Append(&Record{ID: uint64(i), Method: "/csi.v1.Node/NodePublishVolume", Phase: PhaseRequest}))
+	}
+	records, err := j.Dump(0)
+	a.NoError(err)
+	a.Len(records, 3)
+	a.Equal(uint64(3), records[0].ID)
+	a.Equal(uint64(5), records[2].ID)
+
+	records, err = j.Dump(1)
+	a.NoError(err)
+	a.Len(records, 1)
+	a.Equal(uint64(5), records[0].ID)
+	a.NoError(j.Close())
+
+	// a restarted daemon keeps the records and continues the ids
+	j, err = New(path, 3)
+	a.NoError(err)
+	a.Equal(uint64(5), j.seq)
+	records, err = j.Dump(0)
+	a.NoError(err)
+	a.Len(records, 3)
+	a.NoError(j.Close())
+}
+
+func TestSanitize(t *testing.T) {
+	a := assert.New(t)
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "volume-pvc-1",
+		TargetPath: "/var/lib/kubelet/pods/1/volumes/kubernetes.io~csi/pvc-1/mount",
+		Secrets:    map[string]string{"passphrase": "top-secret"},
+	}
+	data := string(sanitize(req))
+	a.Contains(data, "volume-pvc-1")
+	a.Contains(data, strippedSecret)
+	a.False(strings.Contains(data, "top-secret"))
+	a.Nil(sanitize(nil))
+}
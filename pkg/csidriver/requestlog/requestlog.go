@@ -0,0 +1,106 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package requestlog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"path"
+	"time"
+
+	"github.com/carina-io/carina/pkg/csidriver/journal"
+	"github.com/carina-io/carina/utils/log"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MetadataKey grpc metadata carrying the request id, a caller may set it and the response header returns it
+const MetadataKey = "x-request-id"
+
+type volumeRequest interface {
+	GetVolumeId() string
+}
+
+type nameRequest interface {
+	GetName() string
+}
+
+type snapshotRequest interface {
+	GetSnapshotId() string
+}
+
+// NewRequestID returns a random request id
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Fields returns the key value pairs identifying the volume or snapshot of a csi request
+func Fields(req interface{}) []interface{} {
+	fields := []interface{}{}
+	if r, ok := req.(volumeRequest); ok && r.GetVolumeId() != "" {
+		fields = append(fields, "volume_id", r.GetVolumeId())
+	}
+	if r, ok := req.(nameRequest); ok && r.GetName() != "" {
+		fields = append(fields, "name", r.GetName())
+	}
+	if r, ok := req.(snapshotRequest); ok && r.GetSnapshotId() != "" {
+		fields = append(fields, "snapshot_id", r.GetSnapshotId())
+	}
+	return fields
+}
+
+// UnaryServerInterceptor 为每个csi请求分配request id，请求内的日志通过log.FromContext带上request id和卷id
+// The id of the x-request-id metadata is reused if the caller sent one, the trace id is added when the
+// call is traced. Every call but the periodic polls is logged with its duration and code once it returns.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		requestID := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(MetadataKey)) > 0 {
+			requestID = md.Get(MetadataKey)[0]
+		}
+		if requestID == "" {
+			requestID = NewRequestID()
+		}
+		method := path.Base(info.FullMethod)
+		fields := append([]interface{}{"method", method}, Fields(req)...)
+		// 开启tracing时日志带上trace id，便于从日志跳转到trace
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			fields = append(fields, "trace_id", sc.TraceID().String())
+		}
+		ctx = log.NewContext(ctx, requestID, fields...)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(MetadataKey, requestID))
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		logger := log.FromContext(ctx).With("duration", time.Since(start).String(), "code", status.Code(err).String())
+		switch {
+		case err != nil:
+			logger.Errorf("%s failed: %s", method, err.Error())
+		case journal.Quiet(info.FullMethod):
+			logger.Debugf("%s done", method)
+		default:
+			logger.Infof("%s done", method)
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,60 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package requestlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/carina-io/carina/utils/log"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestFields(t *testing.T) {
+	a := assert.New(t)
+	a.Equal([]interface{}{"volume_id", "pvc-1"}, Fields(&csi.NodePublishVolumeRequest{VolumeId: "pvc-1"}))
+	a.Equal([]interface{}{"name", "pvc-2"}, Fields(&csi.CreateVolumeRequest{Name: "pvc-2"}))
+	a.Equal([]interface{}{"snapshot_id", "snap-1"}, Fields(&csi.DeleteSnapshotRequest{SnapshotId: "snap-1"}))
+	a.Empty(Fields(&csi.NodeGetInfoRequest{}))
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	a := assert.New(t)
+	interceptor := UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodePublishVolume"}
+
+	var seen []string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		seen = append(seen, log.RequestID(ctx))
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+	_, err := interceptor(context.Background(), &csi.NodePublishVolumeRequest{VolumeId: "pvc-1"}, info, handler)
+	a.NoError(err)
+	_, err = interceptor(context.Background(), &csi.NodePublishVolumeRequest{VolumeId: "pvc-1"}, info, handler)
+	a.NoError(err)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "from-caller"))
+	_, err = interceptor(ctx, &csi.NodePublishVolumeRequest{VolumeId: "pvc-1"}, info, handler)
+	a.NoError(err)
+
+	a.Len(seen, 3)
+	a.Len(seen[0], 16)
+	a.NotEqual(seen[0], seen[1])
+	a.Equal("from-caller", seen[2])
+}
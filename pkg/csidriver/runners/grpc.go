@@ -21,6 +21,9 @@ import (
 	"github.com/carina-io/carina/utils"
 	"net"
 	"os"
+	"time"
+
+	"github.com/carina-io/carina/utils/log"
 
 	"google.golang.org/grpc"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -30,6 +33,7 @@ type gRPCServerRunner struct {
 	srv            *grpc.Server
 	sockFile       string
 	leaderElection bool
+	drainTimeout   time.Duration
 }
 
 var _ manager.LeaderElectionRunnable = gRPCServerRunner{}
@@ -37,8 +41,9 @@ var _ manager.LeaderElectionRunnable = gRPCServerRunner{}
 // NewGRPCRunner creates controller-runtime's manager.Runnable for a gRPC server.
 // The server will listen on UNIX domain socket at sockFile.
 // If leaderElection is true, the server will run only when it is elected as leader.
-func NewGRPCRunner(srv *grpc.Server, sockFile string, leaderElection bool) manager.Runnable {
-	return gRPCServerRunner{srv, sockFile, leaderElection}
+// On shutdown in-flight requests are waited for at most drainTimeout, 0 waits until they are done.
+func NewGRPCRunner(srv *grpc.Server, sockFile string, leaderElection bool, drainTimeout time.Duration) manager.Runnable {
+	return gRPCServerRunner{srv, sockFile, leaderElection, drainTimeout}
 }
 
 // Start implements controller-runtime's manager.Runnable.
@@ -54,7 +59,23 @@ func (r gRPCServerRunner) Start(ctx context.Context) error {
 
 	go r.srv.Serve(lis)
 	<-ctx.Done()
-	r.srv.GracefulStop()
+	if r.drainTimeout <= 0 {
+		r.srv.GracefulStop()
+		return nil
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		r.srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(r.drainTimeout):
+		// 未完成的请求由CSI sidecar重试
+		log.Warnf("gRPC requests still running after %s, stopping the server", r.drainTimeout)
+		r.srv.Stop()
+	}
 	return nil
 }
 
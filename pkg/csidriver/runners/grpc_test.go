@@ -0,0 +1,94 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package runners
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// slowIdentity Probe请求到达后通知started，处理delay后返回
+type slowIdentity struct {
+	csi.UnimplementedIdentityServer
+	started chan struct{}
+	delay   time.Duration
+}
+
+func (s *slowIdentity) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	close(s.started)
+	time.Sleep(s.delay)
+	return &csi.ProbeResponse{}, nil
+}
+
+func TestGRPCRunnerDrain(t *testing.T) {
+	table := []struct {
+		name         string
+		delay        time.Duration
+		drainTimeout time.Duration
+		code         codes.Code
+	}{
+		// 请求在等待时间内完成
+		{name: "drained", delay: 300 * time.Millisecond, drainTimeout: 5 * time.Second, code: codes.OK},
+		// 超过等待时间的请求被中断，由CSI sidecar重试
+		{name: "cut off", delay: 5 * time.Second, drainTimeout: 300 * time.Millisecond, code: codes.Unavailable},
+	}
+
+	for _, c := range table {
+		t.Run(c.name, func(t *testing.T) {
+			sockFile := filepath.Join(t.TempDir(), "csi.sock")
+			identity := &slowIdentity{started: make(chan struct{}), delay: c.delay}
+			srv := grpc.NewServer()
+			csi.RegisterIdentityServer(srv, identity)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stopped := make(chan error)
+			go func() {
+				stopped <- NewGRPCRunner(srv, sockFile, false, c.drainTimeout).Start(ctx)
+			}()
+
+			conn, err := grpc.Dial("unix://"+sockFile, grpc.WithInsecure())
+			assert.NoError(t, err)
+			defer conn.Close()
+			probed := make(chan error)
+			go func() {
+				_, err := csi.NewIdentityClient(conn).Probe(context.Background(), &csi.ProbeRequest{}, grpc.WaitForReady(true))
+				probed <- err
+			}()
+
+			select {
+			case <-identity.started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("probe is not received")
+			}
+			start := time.Now()
+			cancel()
+
+			assert.Equal(t, c.code, status.Code(<-probed))
+			assert.NoError(t, <-stopped)
+			assert.Less(t, time.Since(start), 3*time.Second)
+		})
+	}
+}
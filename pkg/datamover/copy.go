@@ -0,0 +1,74 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package datamover
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// copyChunk is the unit CopyDevice reads, writes and checks for zeros
+const copyChunk = 4 << 20
+
+// CopyDevice copies the content of block device src to the start of dst within
+// the bandwidth ceiling of t and returns the number of bytes written. Chunks
+// holding only zeros are skipped, dst must read as zeros where it is not
+// written, as a new thin volume does, and stays thin.
+func CopyDevice(ctx context.Context, t *Throttle, dst, src string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	r := t.Reader(ctx, in)
+	buf := make([]byte, copyChunk)
+	var offset, written int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 && !isZero(buf[:n]) {
+			if _, werr := out.WriteAt(buf[:n], offset); werr != nil {
+				return written, werr
+			}
+			written += int64(n)
+		}
+		offset += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, out.Sync()
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
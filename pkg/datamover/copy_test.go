@@ -0,0 +1,58 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package datamover
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyDevice(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "carina-copy")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// 第二个块全为零，最后一个块不满
+	data := make([]byte, 2*copyChunk+100)
+	for i := 0; i < copyChunk; i++ {
+		data[i] = byte(i%251 + 1)
+	}
+	copy(data[2*copyChunk:], bytes.Repeat([]byte{7}, 100))
+
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	a.NoError(ioutil.WriteFile(src, data, 0600))
+	a.NoError(ioutil.WriteFile(dst, make([]byte, len(data)), 0600))
+
+	written, err := CopyDevice(context.Background(), NewThrottle(), dst, src)
+	a.NoError(err)
+	a.Equal(int64(copyChunk+100), written)
+
+	result, err := ioutil.ReadFile(dst)
+	a.NoError(err)
+	a.True(bytes.Equal(data, result))
+
+	_, err = CopyDevice(context.Background(), NewThrottle(), dst, filepath.Join(dir, "missing"))
+	a.Error(err)
+}
@@ -0,0 +1,262 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package datamover
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Window is a daily maintenance window in node local time, a window whose end
+// is before its start runs past midnight, e.g. 22:00-02:00
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseWindow parses a window written as HH:MM-HH:MM
+func ParseWindow(s string) (Window, error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 2 {
+		return Window{}, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", s)
+	}
+	var w Window
+	for i, p := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(p))
+		if err != nil {
+			return Window{}, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", s)
+		}
+		d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		if i == 0 {
+			w.Start = d
+		} else {
+			w.End = d
+		}
+	}
+	if w.Start == w.End {
+		return Window{}, fmt.Errorf("invalid window %q, start and end are the same", s)
+	}
+	return w, nil
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", int(w.Start.Hours()), int(w.Start.Minutes())%60, int(w.End.Hours()), int(w.End.Minutes())%60)
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return t.Sub(midnight)
+}
+
+// Contains reports whether t is inside the window
+func (w Window) Contains(t time.Time) bool {
+	tod := timeOfDay(t)
+	if w.Start < w.End {
+		return tod >= w.Start && tod < w.End
+	}
+	return tod >= w.Start || tod < w.End
+}
+
+// untilStart returns how long it is from t to the next start of the window
+func (w Window) untilStart(t time.Time) time.Duration {
+	d := w.Start - timeOfDay(t)
+	if d <= 0 {
+		d += 24 * time.Hour
+	}
+	return d
+}
+
+// Throttle 限制节点上数据搬迁任务（迁移、再平衡、备份、预填充）的时间窗口和带宽
+// All jobs of the node share one Throttle, so the bandwidth ceiling applies to
+// their sum. Jobs call Wait before every unit of work (an object part, a
+// logical volume), so a job running when a window closes finishes the current
+// unit and resumes when the window opens again.
+type Throttle struct {
+	mu      sync.Mutex
+	windows []Window
+	// bandwidth bytes per second, 0 means unlimited
+	bandwidth int64
+	// next is when the bandwidth already handed out has been used up
+	next time.Time
+	// changed is closed whenever the settings change so that waiters re-evaluate
+	changed chan struct{}
+	now     func() time.Time
+}
+
+// NewThrottle returns a Throttle without any limits
+func NewThrottle() *Throttle {
+	return &Throttle{changed: make(chan struct{}), now: time.Now}
+}
+
+// Update replaces the maintenance windows and the bandwidth ceiling, an empty
+// windows list allows data movement at any time. On error the previous
+// settings are kept.
+func (t *Throttle) Update(windows []string, bandwidth int64) error {
+	ws := []Window{}
+	for _, s := range windows {
+		w, err := ParseWindow(s)
+		if err != nil {
+			return err
+		}
+		ws = append(ws, w)
+	}
+	if bandwidth < 0 {
+		return fmt.Errorf("invalid bandwidth %d", bandwidth)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.windows = ws
+	t.bandwidth = bandwidth
+	t.next = time.Time{}
+	close(t.changed)
+	t.changed = make(chan struct{})
+	return nil
+}
+
+// untilOpen returns how long data movement has to wait for a window, must hold mu
+func (t *Throttle) untilOpen(now time.Time) time.Duration {
+	if len(t.windows) == 0 {
+		return 0
+	}
+	var min time.Duration
+	for i, w := range t.windows {
+		if w.Contains(now) {
+			return 0
+		}
+		if d := w.untilStart(now); i == 0 || d < min {
+			min = d
+		}
+	}
+	return min
+}
+
+// Open reports whether data movement is allowed right now
+func (t *Throttle) Open() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.untilOpen(t.now()) == 0
+}
+
+// Settings returns the maintenance windows and the bandwidth ceiling in effect
+func (t *Throttle) Settings() ([]string, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	windows := []string{}
+	for _, w := range t.windows {
+		windows = append(windows, w.String())
+	}
+	return windows, t.bandwidth
+}
+
+// Wait blocks until data movement is allowed by the maintenance windows
+func (t *Throttle) Wait(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		d := t.untilOpen(t.now())
+		changed := t.changed
+		t.mu.Unlock()
+		if d == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve hands out n bytes of bandwidth and returns how long to wait before using them
+func (t *Throttle) reserve(n int) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.bandwidth == 0 {
+		return 0
+	}
+	now := t.now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	wait := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(float64(n) / float64(t.bandwidth) * float64(time.Second)))
+	return wait
+}
+
+// WaitN blocks until n bytes may be moved within the bandwidth ceiling
+func (t *Throttle) WaitN(ctx context.Context, n int) error {
+	d := t.reserve(n)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Reader returns a reader that moves the data read from r within the bandwidth ceiling
+func (t *Throttle) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, throttle: t, r: r}
+}
+
+type reader struct {
+	ctx      context.Context
+	throttle *Throttle
+	r        io.Reader
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.throttle.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (t *Throttle) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	windows := "always"
+	if len(t.windows) > 0 {
+		s := []string{}
+		for _, w := range t.windows {
+			s = append(s, w.String())
+		}
+		windows = strings.Join(s, ",")
+	}
+	bandwidth := "unlimited"
+	if t.bandwidth > 0 {
+		bandwidth = fmt.Sprintf("%d bytes/s", t.bandwidth)
+	}
+	return fmt.Sprintf("windows %s, bandwidth %s", windows, bandwidth)
+}
@@ -0,0 +1,103 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package datamover
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWindow(t *testing.T) {
+	table := []struct {
+		raw    string
+		result string
+		err    bool
+	}{
+		{raw: "01:00-05:00", result: "01:00-05:00"},
+		{raw: " 22:30 - 02:00 ", result: "22:30-02:00"},
+		{raw: "01:00", err: true},
+		{raw: "25:00-05:00", err: true},
+		{raw: "03:00-03:00", err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		w, err := ParseWindow(e.raw)
+		if e.err {
+			a.Error(err, e.raw)
+			continue
+		}
+		a.NoError(err, e.raw)
+		a.Equal(e.result, w.String())
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2022, 3, 1, hour, min, 0, 0, time.Local)
+	}
+	a := assert.New(t)
+
+	w, _ := ParseWindow("01:00-05:00")
+	a.True(w.Contains(at(1, 0)))
+	a.True(w.Contains(at(4, 59)))
+	a.False(w.Contains(at(5, 0)))
+	a.Equal(20*time.Hour, w.untilStart(at(5, 0)))
+
+	w, _ = ParseWindow("22:00-02:00")
+	a.True(w.Contains(at(23, 0)))
+	a.True(w.Contains(at(1, 0)))
+	a.False(w.Contains(at(12, 0)))
+	a.Equal(10*time.Hour, w.untilStart(at(12, 0)))
+}
+
+func TestThrottleWindows(t *testing.T) {
+	a := assert.New(t)
+	th := NewThrottle()
+	th.now = func() time.Time { return time.Date(2022, 3, 1, 12, 0, 0, 0, time.Local) }
+	a.True(th.Open())
+
+	a.NoError(th.Update([]string{"01:00-05:00", "11:00-11:30"}, 0))
+	a.False(th.Open())
+	a.Equal(13*time.Hour, th.untilOpen(th.now()))
+
+	a.Error(th.Update([]string{"bad"}, 0))
+	a.False(th.Open())
+
+	// a settings change wakes up waiters
+	done := make(chan error)
+	go func() { done <- th.Wait(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	a.NoError(th.Update(nil, 0))
+	a.NoError(<-done)
+}
+
+func TestThrottleBandwidth(t *testing.T) {
+	a := assert.New(t)
+	th := NewThrottle()
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.Local)
+	th.now = func() time.Time { return now }
+	a.Equal(time.Duration(0), th.reserve(1<<20))
+
+	a.NoError(th.Update(nil, 100<<20))
+	a.Equal(time.Duration(0), th.reserve(50<<20))
+	a.Equal(500*time.Millisecond, th.reserve(50<<20))
+	a.Equal(time.Second, th.reserve(1))
+}
@@ -0,0 +1,49 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"context"
+	"os"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/volumeactivity"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VolumeIOCounters returns the number of completed reads and writes of the LogicVolumes of
+// this node keyed by LogicVolume name. Volumes whose device is not known yet are left out.
+func (dm *DeviceManager) VolumeIOCounters() (map[string]uint64, error) {
+	lvList := &carinav1.LogicVolumeList{}
+	if err := dm.Cache.List(context.Background(), lvList, client.MatchingFields{"nodeName": dm.nodeName}); err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile("/proc/diskstats")
+	if err != nil {
+		return nil, err
+	}
+	stats := volumeactivity.ParseDiskStats(string(content))
+
+	result := map[string]uint64{}
+	for i := range lvList.Items {
+		key := volumeactivity.DeviceKey(&lvList.Items[i])
+		if c, ok := stats[key]; ok {
+			result[lvList.Items[i].Name] = c
+		}
+	}
+	return result, nil
+}
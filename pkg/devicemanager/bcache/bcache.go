@@ -18,8 +18,12 @@ package bcache
 
 import (
 	"fmt"
+	"time"
+
+	"github.com/carina-io/carina/pkg/devicemanager/tools"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 	"github.com/carina-io/carina/utils/exec"
+	"github.com/carina-io/carina/utils/log"
 )
 
 type BcacheImplement struct {
@@ -27,6 +31,9 @@ type BcacheImplement struct {
 }
 
 func (bi *BcacheImplement) CreateBcache(dev, cacheDev string, block, bucket string) error {
+	if err := tools.Check(tools.FeatureBcache); err != nil {
+		return err
+	}
 	_ = bi.Executor.ExecuteCommand("wipefs", "-af", dev)
 	_ = bi.Executor.ExecuteCommand("wipefs", "-af", cacheDev)
 
@@ -37,16 +44,42 @@ func (bi *BcacheImplement) CreateBcache(dev, cacheDev string, block, bucket stri
 	return bi.Executor.ExecuteCommand("make-bcache", "-B", dev, "-C", cacheDev, "--wipe-bcache")
 }
 
-func (bi *BcacheImplement) RemoveBcache(bcacheInfo *types.BcacheDeviceInfo) error {
+// RemoveBcache 分离缓存盘并停止bcache设备
+// The cache mode is switched to writethrough first so that no new dirty data is cached, then the
+// kernel writes the dirty data back to the backing device while detaching the cache set. If it
+// does not finish within timeout a *DirtyError is returned and the device is left attached, unless
+// force is set, in which case the cache set is stopped and a *DirtyError with Dropped is returned
+// once the device is stopped.
+func (bi *BcacheImplement) RemoveBcache(bcacheInfo *types.BcacheDeviceInfo, timeout time.Duration, force bool) error {
 
 	var err error
 	var cmd string
+	var dropped *DirtyError
 
-	// remove cache device
-	cmd = fmt.Sprintf("echo %s > /sys/block/%s/bcache/detach", bcacheInfo.CsetUuid, bcacheInfo.Name)
-	err = bi.Executor.ExecuteCommand("/bin/sh", "-c", cmd)
-	if err != nil {
-		return err
+	if !detached(sysBlockRoot, bcacheInfo.Name) {
+		_ = bi.Executor.ExecuteCommand("blockdev", "--flushbufs", fmt.Sprintf("/dev/%s", bcacheInfo.Name))
+		cmd = fmt.Sprintf("echo writethrough > /sys/block/%s/bcache/cache_mode", bcacheInfo.Name)
+		_ = bi.Executor.ExecuteCommand("/bin/sh", "-c", cmd)
+
+		// remove cache device
+		cmd = fmt.Sprintf("echo %s > /sys/block/%s/bcache/detach", bcacheInfo.CsetUuid, bcacheInfo.Name)
+		err = bi.Executor.ExecuteCommand("/bin/sh", "-c", cmd)
+		if err != nil {
+			return err
+		}
+
+		if err = waitDetached(sysBlockRoot, bcacheInfo.Name, timeout, detachPollInterval); err != nil {
+			if !force {
+				return err
+			}
+			log.Warnf("force detach %s, drop its dirty data: %s", bcacheInfo.Name, err.Error())
+			cmd = fmt.Sprintf("echo 1 > /sys/fs/bcache/%s/stop", bcacheInfo.CsetUuid)
+			_ = bi.Executor.ExecuteCommand("/bin/sh", "-c", cmd)
+			if dirty, ok := err.(*DirtyError); ok {
+				dirty.Dropped = true
+				dropped = dirty
+			}
+		}
 	}
 
 	// unregister cache device
@@ -62,6 +95,9 @@ func (bi *BcacheImplement) RemoveBcache(bcacheInfo *types.BcacheDeviceInfo) erro
 	if err != nil {
 		return err
 	}
+	if dropped != nil {
+		return dropped
+	}
 
 	return nil
 }
@@ -0,0 +1,66 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bcache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// detachPollInterval 等待缓存盘分离时轮询state的间隔
+const detachPollInterval = 500 * time.Millisecond
+
+// DirtyError 等待超时后缓存盘上仍有未写回后端盘的数据
+type DirtyError struct {
+	Name      string
+	State     string
+	DirtyData string
+	Timeout   time.Duration
+	// Dropped the device was force detached and the dirty data is lost
+	Dropped bool
+}
+
+func (e *DirtyError) Error() string {
+	if e.Dropped {
+		return fmt.Sprintf("bcache device %s was force detached, %s dirty data not written back within %s was dropped", e.Name, e.DirtyData, e.Timeout)
+	}
+	return fmt.Sprintf("bcache device %s is still %s with %s dirty data after %s", e.Name, e.State, e.DirtyData, e.Timeout)
+}
+
+// detached reports whether the cache set was detached from the bcache device, or the device is gone
+func detached(root, name string) bool {
+	dir := filepath.Join(root, name)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return true
+	}
+	return readAttr(dir, "bcache/state") == "no cache"
+}
+
+// waitDetached 分离缓存盘时内核先把脏数据写回后端盘，state变为no cache后才算完成
+func waitDetached(root, name string, timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !detached(root, name) {
+		if !time.Now().Before(deadline) {
+			dir := filepath.Join(root, name)
+			return &DirtyError{Name: name, State: readAttr(dir, "bcache/state"), DirtyData: readAttr(dir, "bcache/dirty_data"), Timeout: timeout}
+		}
+		time.Sleep(interval)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bcache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitDetached(t *testing.T) {
+	a := assert.New(t)
+	root, err := ioutil.TempDir("", "sysblock")
+	a.NoError(err)
+	defer os.RemoveAll(root)
+
+	write := func(p, content string) {
+		a.NoError(os.MkdirAll(filepath.Join(root, filepath.Dir(p)), 0755))
+		a.NoError(ioutil.WriteFile(filepath.Join(root, p), []byte(content), 0644))
+	}
+	write("bcache0/bcache/state", "dirty\n")
+	write("bcache0/bcache/dirty_data", "512.0k\n")
+	write("bcache1/bcache/state", "no cache\n")
+
+	a.False(detached(root, "bcache0"))
+	a.True(detached(root, "bcache1"))
+	a.True(detached(root, "bcache2"))
+
+	err = waitDetached(root, "bcache0", 20*time.Millisecond, 5*time.Millisecond)
+	dirty, ok := err.(*DirtyError)
+	a.True(ok)
+	a.Equal(&DirtyError{Name: "bcache0", State: "dirty", DirtyData: "512.0k", Timeout: 20 * time.Millisecond}, dirty)
+	a.Equal("bcache device bcache0 is still dirty with 512.0k dirty data after 20ms", err.Error())
+
+	// 写回完成后分离
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		write("bcache0/bcache/state", "no cache\n")
+	}()
+	a.NoError(waitDetached(root, "bcache0", time.Second, 5*time.Millisecond))
+}
@@ -17,13 +17,16 @@
 package bcache
 
 import (
+	"time"
+
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 )
 
 type Bcache interface {
 	// CreateBcache create bcache
 	CreateBcache(dev, cacheDev string, block, bucket string) error
-	RemoveBcache(bcacheInfo *types.BcacheDeviceInfo) error
+	// RemoveBcache detaches the cache set, writing its dirty data back within timeout unless force is set
+	RemoveBcache(bcacheInfo *types.BcacheDeviceInfo, timeout time.Duration, force bool) error
 
 	// GetDeviceBcache
 	GetDeviceBcache(dev string) (*types.BcacheDeviceInfo, error)
@@ -0,0 +1,80 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bcache
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+)
+
+// sysBlockRoot is replaced in tests
+var sysBlockRoot = "/sys/block"
+
+// Stats returns the state and the hit counters of every bcache device of the node
+func Stats() ([]types.BcacheStats, error) {
+	return stats(sysBlockRoot)
+}
+
+func stats(root string) ([]types.BcacheStats, error) {
+	dirs, err := filepath.Glob(filepath.Join(root, "bcache*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dirs)
+	result := []types.BcacheStats{}
+	for _, dir := range dirs {
+		s := types.BcacheStats{
+			Name:      filepath.Base(dir),
+			CacheMode: selected(readAttr(dir, "bcache/cache_mode")),
+			State:     readAttr(dir, "bcache/state"),
+			DirtyData: readAttr(dir, "bcache/dirty_data"),
+		}
+		if slaves, _ := ioutil.ReadDir(filepath.Join(dir, "slaves")); len(slaves) > 0 {
+			s.BackingDevice = slaves[0].Name()
+		}
+		s.CacheHits, _ = strconv.ParseInt(readAttr(dir, "bcache/stats_total/cache_hits"), 10, 64)
+		s.CacheMisses, _ = strconv.ParseInt(readAttr(dir, "bcache/stats_total/cache_misses"), 10, 64)
+		s.CacheHitRatio, _ = strconv.ParseInt(readAttr(dir, "bcache/stats_total/cache_hit_ratio"), 10, 64)
+		s.BypassHits, _ = strconv.ParseInt(readAttr(dir, "bcache/stats_total/cache_bypass_hits"), 10, 64)
+		s.BypassMisses, _ = strconv.ParseInt(readAttr(dir, "bcache/stats_total/cache_bypass_misses"), 10, 64)
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+func readAttr(dir, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// selected 返回sysfs选项中当前生效的一项，e.g. writethrough [writeback] writearound none
+func selected(options string) string {
+	for _, o := range strings.Fields(options) {
+		if strings.HasPrefix(o, "[") && strings.HasSuffix(o, "]") {
+			return strings.Trim(o, "[]")
+		}
+	}
+	return options
+}
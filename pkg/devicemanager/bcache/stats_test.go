@@ -0,0 +1,58 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bcache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	a := assert.New(t)
+	root, err := ioutil.TempDir("", "sysblock")
+	a.NoError(err)
+	defer os.RemoveAll(root)
+
+	write := func(p, content string) {
+		a.NoError(os.MkdirAll(filepath.Join(root, filepath.Dir(p)), 0755))
+		a.NoError(ioutil.WriteFile(filepath.Join(root, p), []byte(content), 0644))
+	}
+	write("bcache0/bcache/cache_mode", "writethrough [writeback] writearound none\n")
+	write("bcache0/bcache/state", "dirty\n")
+	write("bcache0/bcache/dirty_data", "1.2M\n")
+	write("bcache0/bcache/stats_total/cache_hits", "980\n")
+	write("bcache0/bcache/stats_total/cache_misses", "20\n")
+	write("bcache0/bcache/stats_total/cache_hit_ratio", "98\n")
+	write("bcache0/bcache/stats_total/cache_bypass_hits", "3\n")
+	write("bcache0/bcache/stats_total/cache_bypass_misses", "1\n")
+	a.NoError(os.MkdirAll(filepath.Join(root, "bcache0/slaves/dm-3"), 0755))
+	write("bcache1/bcache/state", "no cache\n")
+	write("sda/size", "1024\n")
+
+	s, err := stats(root)
+	a.NoError(err)
+	a.Equal([]types.BcacheStats{
+		{Name: "bcache0", BackingDevice: "dm-3", CacheMode: "writeback", State: "dirty", DirtyData: "1.2M",
+			CacheHits: 980, CacheMisses: 20, CacheHitRatio: 98, BypassHits: 3, BypassMisses: 1},
+		{Name: "bcache1", State: "no cache"},
+	}, s)
+}
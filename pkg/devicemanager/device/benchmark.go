@@ -0,0 +1,183 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/carina-io/carina/api"
+)
+
+const (
+	// ProfileTagPrefix pv标签前缀，保存磁盘加入vg前的测试结果
+	// e.g. carina.storage.io/profile=seqread-520:randread-85000:randwrite-60000:latency-180
+	ProfileTagPrefix = "carina.storage.io/profile="
+
+	benchmarkSeqBlock  = 1 << 20
+	benchmarkRandBlock = 4 << 10
+	// benchmarkDepth 随机读写的并发数
+	benchmarkDepth = 16
+)
+
+// Benchmark measures a disk with direct io: sequential 1MiB reads, then random 4KiB reads
+// and random 4KiB writes with benchmarkDepth requests in flight, each for duration.
+// The random writes destroy data, only run it on a disk that is about to become a pv.
+func Benchmark(path string, duration time.Duration) (api.DiskProfile, error) {
+	profile := api.DiskProfile{Disk: path}
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_DIRECT, 0)
+	if err != nil {
+		return profile, err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return profile, err
+	}
+	if size < 16*benchmarkSeqBlock {
+		return profile, fmt.Errorf("disk %s is too small to benchmark: %d bytes", path, size)
+	}
+
+	buf := alignedBuffer(benchmarkSeqBlock)
+	var read int64
+	start := time.Now()
+	for offset := int64(0); time.Since(start) < duration; offset += benchmarkSeqBlock {
+		if offset+benchmarkSeqBlock > size {
+			offset = 0
+		}
+		n, err := f.ReadAt(buf, offset)
+		if err != nil {
+			return profile, fmt.Errorf("sequential read of %s failed: %v", path, err)
+		}
+		read += int64(n)
+	}
+	profile.SeqReadMBps = int64(float64(read)/time.Since(start).Seconds()) >> 20
+
+	ops, busy, err := randomIO(f, size, duration, false)
+	if err != nil {
+		return profile, fmt.Errorf("random read of %s failed: %v", path, err)
+	}
+	profile.RandReadIOPS = ops * int64(time.Second) / int64(duration)
+	if ops > 0 {
+		profile.LatencyMicros = int64(busy/time.Microsecond) / ops
+	}
+
+	ops, _, err = randomIO(f, size, duration, true)
+	if err != nil {
+		return profile, fmt.Errorf("random write of %s failed: %v", path, err)
+	}
+	profile.RandWriteIOPS = ops * int64(time.Second) / int64(duration)
+	return profile, nil
+}
+
+// randomIO 并发随机读写4KiB块，返回完成的请求数和所有请求耗时之和
+func randomIO(f *os.File, size int64, duration time.Duration, write bool) (int64, time.Duration, error) {
+	blocks := size / benchmarkRandBlock
+	deadline := time.Now().Add(duration)
+
+	var mu sync.Mutex
+	var total int64
+	var busy time.Duration
+	var firstErr error
+	wg := sync.WaitGroup{}
+	for i := 0; i < benchmarkDepth; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			buf := alignedBuffer(benchmarkRandBlock)
+			var ops int64
+			var elapsed time.Duration
+			var err error
+			for time.Now().Before(deadline) {
+				offset := r.Int63n(blocks) * benchmarkRandBlock
+				start := time.Now()
+				if write {
+					_, err = f.WriteAt(buf, offset)
+				} else {
+					_, err = f.ReadAt(buf, offset)
+				}
+				if err != nil {
+					break
+				}
+				elapsed += time.Since(start)
+				ops++
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			total += ops
+			busy += elapsed
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(time.Now().UnixNano() + int64(i))
+	}
+	wg.Wait()
+	return total, busy, firstErr
+}
+
+// alignedBuffer direct io要求内存按块对齐
+func alignedBuffer(size int) []byte {
+	const align = 4096
+	buf := make([]byte, size+align)
+	if offset := int(uintptr(unsafe.Pointer(&buf[0])) & (align - 1)); offset != 0 {
+		buf = buf[align-offset:]
+	}
+	return buf[:size]
+}
+
+// ProfileTag 将测试结果编码为pv标签，lvm标签只能包含[A-Za-z0-9_+.-/=!:&#]
+func ProfileTag(p api.DiskProfile) string {
+	return fmt.Sprintf("%sseqread-%d:randread-%d:randwrite-%d:latency-%d", ProfileTagPrefix, p.SeqReadMBps, p.RandReadIOPS, p.RandWriteIOPS, p.LatencyMicros)
+}
+
+// ParseProfileTag 从pv的标签中解析测试结果，没有测试结果时返回false
+func ParseProfileTag(tags string) (api.DiskProfile, bool) {
+	p := api.DiskProfile{}
+	for _, tag := range strings.Split(tags, ",") {
+		if !strings.HasPrefix(tag, ProfileTagPrefix) {
+			continue
+		}
+		values := map[string]int64{}
+		for _, kv := range strings.Split(strings.TrimPrefix(tag, ProfileTagPrefix), ":") {
+			i := strings.LastIndex(kv, "-")
+			if i < 0 {
+				return p, false
+			}
+			v, err := strconv.ParseInt(kv[i+1:], 10, 64)
+			if err != nil {
+				return p, false
+			}
+			values[kv[:i]] = v
+		}
+		p.SeqReadMBps = values["seqread"]
+		p.RandReadIOPS = values["randread"]
+		p.RandWriteIOPS = values["randwrite"]
+		p.LatencyMicros = values["latency"]
+		return p, true
+	}
+	return p, false
+}
@@ -0,0 +1,60 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package device
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/carina-io/carina/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileTag(t *testing.T) {
+	a := assert.New(t)
+	profile := api.DiskProfile{SeqReadMBps: 520, RandReadIOPS: 85000, RandWriteIOPS: 60000, LatencyMicros: 180}
+	tag := ProfileTag(profile)
+	a.Equal("carina.storage.io/profile=seqread-520:randread-85000:randwrite-60000:latency-180", tag)
+
+	table := []struct {
+		tags    string
+		profile api.DiskProfile
+		ok      bool
+	}{
+		{tags: tag, profile: profile, ok: true},
+		{tags: "backup,carina-vg-ssd," + tag, profile: profile, ok: true},
+		{tags: "", ok: false},
+		{tags: "backup", ok: false},
+		{tags: ProfileTagPrefix + "seqread-abc", ok: false},
+	}
+	for _, e := range table {
+		p, ok := ParseProfileTag(e.tags)
+		a.Equal(e.ok, ok, e.tags)
+		if e.ok {
+			a.Equal(e.profile, p, e.tags)
+		}
+	}
+}
+
+func TestAlignedBuffer(t *testing.T) {
+	a := assert.New(t)
+	for _, size := range []int{4096, 1 << 20} {
+		buf := alignedBuffer(size)
+		a.Equal(size, len(buf))
+		a.Equal(uintptr(0), uintptr(unsafe.Pointer(&buf[0]))&4095)
+	}
+}
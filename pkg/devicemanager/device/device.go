@@ -19,6 +19,7 @@ package device
 import (
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
@@ -51,7 +52,7 @@ func (ld *LocalDeviceImplement) ListDevices() ([]string, error) {
 
 // ListDevicesDetail
 /*
-# lsblk --pairs --paths --bytes --all --output NAME,FSTYPE,MOUNTPOINT,SIZE,STATE,TYPE,ROTA,RO,PKNAME
+# lsblk --pairs --paths --bytes --all --output NAME,FSTYPE,MOUNTPOINT,SIZE,STATE,TYPE,ROTA,RO,PKNAME,WWN,SERIAL,MODEL
 NAME="/dev/sda" FSTYPE="" MOUNTPOINT="" SIZE="85899345920" STATE="running" TYPE="disk" ROTA="1" RO="0"
 NAME="/dev/sda1" FSTYPE="ext4" MOUNTPOINT="/" SIZE="81604378624" STATE="" TYPE="part" ROTA="1" RO="0"
 NAME="/dev/sda2" FSTYPE="" MOUNTPOINT="" SIZE="1024" STATE="" TYPE="part" ROTA="1" RO="0"
@@ -68,7 +69,7 @@ NAME="/dev/loop6" FSTYPE="" MOUNTPOINT="" SIZE="" STATE="" TYPE="loop" ROTA="1"
 NAME="/dev/loop7" FSTYPE="" MOUNTPOINT="" SIZE="" STATE="" TYPE="loop" ROTA="1" RO="0"
 */
 func (ld *LocalDeviceImplement) ListDevicesDetail(device string) ([]*types.LocalDisk, error) {
-	args := []string{"--pairs", "--paths", "--bytes", "--all", "--output", "NAME,FSTYPE,MOUNTPOINT,SIZE,STATE,TYPE,ROTA,RO,PKNAME"}
+	args := []string{"--pairs", "--paths", "--bytes", "--all", "--output", "NAME,FSTYPE,MOUNTPOINT,SIZE,STATE,TYPE,ROTA,RO,PKNAME,WWN,SERIAL,MODEL"}
 	if device != "" {
 		args = append(args, device)
 	}
@@ -78,7 +79,7 @@ func (ld *LocalDeviceImplement) ListDevicesDetail(device string) ([]*types.Local
 		return nil, err
 	}
 
-	return parseDiskString(devices), nil
+	return ApplyQuirks(parseDiskString(devices)), nil
 }
 
 // GetDiskUsed
@@ -97,6 +98,9 @@ func (ld *LocalDeviceImplement) GetDiskUsed(device string) (uint64, error) {
 	return stat.Blocks - stat.Bavail, nil
 }
 
+// lsblk --pairs输出的键值对，型号和序列号中可能有空格
+var diskPairRegexp = regexp.MustCompile(`([A-Z:-]+)="([^"]*)"`)
+
 func parseDiskString(diskString string) []*types.LocalDisk {
 	resp := []*types.LocalDisk{}
 
@@ -104,41 +108,45 @@ func parseDiskString(diskString string) []*types.LocalDisk {
 		return resp
 	}
 
-	diskString = strings.ReplaceAll(diskString, "\"", "")
-	//diskString = strings.ReplaceAll(diskString, " ", "")
-
 	vgsList := strings.Split(diskString, "\n")
 	for _, vgs := range vgsList {
+		pairs := diskPairRegexp.FindAllStringSubmatch(vgs, -1)
+		if len(pairs) == 0 {
+			continue
+		}
 		tmp := types.LocalDisk{}
-		vg := strings.Split(vgs, " ")
-		for _, v := range vg {
-			k := strings.Split(v, "=")
-
-			switch k[0] {
+		for _, k := range pairs {
+			switch k[1] {
 			case "NAME":
-				tmp.Name = k[1]
+				tmp.Name = k[2]
 			case "MOUNTPOINT":
-				tmp.MountPoint = k[1]
+				tmp.MountPoint = k[2]
 			case "SIZE":
-				tmp.Size, _ = strconv.ParseUint(k[1], 10, 64)
+				tmp.Size, _ = strconv.ParseUint(k[2], 10, 64)
 			case "STATE":
-				tmp.State = k[1]
+				tmp.State = k[2]
 			case "TYPE":
-				tmp.Type = k[1]
+				tmp.Type = k[2]
 			case "ROTA":
-				tmp.Rotational = k[1]
+				tmp.Rotational = k[2]
 			case "RO":
-				if k[1] == "1" {
+				if k[2] == "1" {
 					tmp.Readonly = true
 				} else {
 					tmp.Readonly = false
 				}
 			case "FSTYPE":
-				tmp.Filesystem = k[1]
+				tmp.Filesystem = k[2]
 			case "PKNAME":
-				tmp.ParentName = k[1]
+				tmp.ParentName = k[2]
+			case "WWN":
+				tmp.Wwn = strings.TrimSpace(k[2])
+			case "SERIAL":
+				tmp.Serial = strings.TrimSpace(k[2])
+			case "MODEL":
+				tmp.Model = strings.TrimSpace(k[2])
 			default:
-				log.Warnf("undefined filed %s-%s", k[0], k[1])
+				log.Warnf("undefined filed %s-%s", k[1], k[2])
 			}
 		}
 		resp = append(resp, &tmp)
@@ -0,0 +1,44 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package device
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDiskString(t *testing.T) {
+	a := assert.New(t)
+	out := `NAME="/dev/sda" FSTYPE="" MOUNTPOINT="" SIZE="85899345920" STATE="running" TYPE="disk" ROTA="1" RO="0" PKNAME="" WWN="0x5000c500a1b2c3d4" SERIAL="ZC1234AB" MODEL="ST4000NM0035    "
+NAME="/dev/sda1" FSTYPE="ext4" MOUNTPOINT="/mnt/data disk" SIZE="81604378624" STATE="" TYPE="part" ROTA="1" RO="0" PKNAME="/dev/sda" WWN="0x5000c500a1b2c3d4" SERIAL="" MODEL=""
+NAME="/dev/nvme0n1" FSTYPE="" MOUNTPOINT="" SIZE="2000398934016" STATE="live" TYPE="disk" ROTA="0" RO="1" PKNAME="" WWN="eui.0025388b91b0a2f1" SERIAL="S4EWNX0N" MODEL="Samsung SSD 970 EVO Plus 2TB"
+`
+	disks := parseDiskString(out)
+	a.Len(disks, 3)
+	a.Equal("/dev/sda", disks[0].Name)
+	a.Equal(uint64(85899345920), disks[0].Size)
+	a.Equal("0x5000c500a1b2c3d4", disks[0].Wwn)
+	a.Equal("ZC1234AB", disks[0].Serial)
+	a.Equal("ST4000NM0035", disks[0].Model)
+	a.Equal("/mnt/data disk", disks[1].MountPoint)
+	a.Equal("/dev/sda", disks[1].ParentName)
+	a.Equal("Samsung SSD 970 EVO Plus 2TB", disks[2].Model)
+	a.Equal("0", disks[2].Rotational)
+	a.True(disks[2].Readonly)
+	a.Empty(parseDiskString(""))
+}
@@ -0,0 +1,83 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package device
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var sysfsRoot = "/sys"
+
+var pciAddress = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-9a-f]$`)
+
+// FailureDomain 返回磁盘所在的物理故障域
+// Disks in a SES enclosure share the enclosure, e.g. enclosure-500304801f0d2a3f,
+// other disks share the HBA or NVMe controller they are attached to, e.g.
+// hba-0000-3b-00.0. Partitions resolve to their disk. An empty string is returned
+// for virtual devices such as loop devices.
+func FailureDomain(dev string) string {
+	return failureDomain(sysfsRoot, dev)
+}
+
+func failureDomain(root, dev string) string {
+	name := filepath.Base(dev)
+	classPath := filepath.Join(root, "class", "block", name)
+	if _, err := os.Stat(filepath.Join(classPath, "partition")); err == nil {
+		if real, err := filepath.EvalSymlinks(classPath); err == nil {
+			name = filepath.Base(filepath.Dir(real))
+		}
+	}
+
+	devicePath := filepath.Join(root, "block", name, "device")
+	slots, _ := filepath.Glob(filepath.Join(devicePath, "enclosure_device:*"))
+	for _, slot := range slots {
+		real, err := filepath.EvalSymlinks(slot)
+		if err != nil {
+			continue
+		}
+		enclosure := filepath.Dir(real)
+		id := filepath.Base(enclosure)
+		if b, err := ioutil.ReadFile(filepath.Join(enclosure, "id")); err == nil && strings.TrimSpace(string(b)) != "" {
+			id = strings.TrimPrefix(strings.TrimSpace(string(b)), "0x")
+		}
+		return "enclosure-" + labelSafe(id)
+	}
+
+	real, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return ""
+	}
+	hba := ""
+	for _, part := range strings.Split(real, string(filepath.Separator)) {
+		if pciAddress.MatchString(part) {
+			hba = part
+		}
+	}
+	if hba == "" {
+		return ""
+	}
+	return "hba-" + labelSafe(hba)
+}
+
+// labelSafe 故障域用作label的值，不能包含冒号
+func labelSafe(s string) string {
+	return strings.NewReplacer(":", "-", " ", "-").Replace(s)
+}
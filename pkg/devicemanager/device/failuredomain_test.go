@@ -0,0 +1,81 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package device
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureDomain(t *testing.T) {
+	a := assert.New(t)
+	root, err := ioutil.TempDir("", "sysfs")
+	a.NoError(err)
+	defer os.RemoveAll(root)
+
+	mkdir := func(p string) {
+		a.NoError(os.MkdirAll(filepath.Join(root, p), 0755))
+	}
+	link := func(target, name string) {
+		a.NoError(os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755))
+		a.NoError(os.Symlink(filepath.Join(root, target), filepath.Join(root, name)))
+	}
+
+	// sata disk behind an ahci controller, with a partition
+	ata := "devices/pci0000:00/0000:00:1f.2/ata1/host0/target0:0:0/0:0:0:0"
+	mkdir(ata)
+	mkdir(ata + "/block/sda/sda1")
+	a.NoError(ioutil.WriteFile(filepath.Join(root, ata, "block/sda/sda1/partition"), []byte("1"), 0644))
+	link(ata, "block/sda/device")
+	link(ata+"/block/sda/sda1", "class/block/sda1")
+
+	// sas disk in a ses enclosure
+	sas := "devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0/host1/port-1:0/end_device-1:0/target1:0:0/1:0:0:0"
+	encl := "devices/pci0000:3a/0000:3a:00.0/0000:3b:00.0/host1/port-1:1/end_device-1:1/target1:0:1/1:0:1:0/enclosure/1:0:1:0"
+	mkdir(sas)
+	mkdir(encl + "/Slot 01")
+	a.NoError(ioutil.WriteFile(filepath.Join(root, encl, "id"), []byte("0x500304801f0d2a3f\n"), 0644))
+	link(encl+"/Slot 01", sas+"/enclosure_device:Slot 01")
+	link(sas, "block/sdb/device")
+
+	// nvme namespace
+	nvme := "devices/pci0000:d7/0000:d7:00.0/0000:d8:00.0/nvme/nvme0"
+	mkdir(nvme)
+	link(nvme, "block/nvme0n1/device")
+
+	// loop device has no device link
+	mkdir("block/loop2")
+
+	table := []struct {
+		dev    string
+		domain string
+	}{
+		{dev: "/dev/sda", domain: "hba-0000-00-1f.2"},
+		{dev: "/dev/sda1", domain: "hba-0000-00-1f.2"},
+		{dev: "/dev/sdb", domain: "enclosure-500304801f0d2a3f"},
+		{dev: "nvme0n1", domain: "hba-0000-d8-00.0"},
+		{dev: "/dev/loop2", domain: ""},
+		{dev: "/dev/sdz", domain: ""},
+	}
+	for _, e := range table {
+		a.Equal(e.domain, failureDomain(root, e.dev), e.dev)
+	}
+}
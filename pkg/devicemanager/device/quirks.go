@@ -0,0 +1,94 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+)
+
+// 边缘设备上不能用作存储的块设备：eMMC的硬件boot分区和RPMB、zram交换设备、裸NAND
+var unusableDevice = regexp.MustCompile(`^(mmcblk[0-9]+(boot[0-9]+|rpmb)|zram[0-9]+|mtdblock[0-9]+)$`)
+
+// 固态盘常见的型号关键字，usb桥接后只能从型号判断
+var solidStateModel = regexp.MustCompile(`(?i)ssd|nvme|flash|solid`)
+
+// ApplyQuirks 修正arm边缘设备上lsblk的输出
+// The eMMC boot areas and RPMB, zram and mtd devices are dropped, they show up as disks but
+// can not hold a volume group. eMMC and SD cards and solid state disks behind a usb bridge,
+// e.g. an nvme drive in a usb enclosure, are reported as rotational and are fixed up to
+// non rotational so that they land in the ssd device groups.
+func ApplyQuirks(disks []*types.LocalDisk) []*types.LocalDisk {
+	return applyQuirks(sysfsRoot, disks)
+}
+
+func applyQuirks(root string, disks []*types.LocalDisk) []*types.LocalDisk {
+	resp := []*types.LocalDisk{}
+	for _, d := range disks {
+		name := filepath.Base(d.Name)
+		if unusableDevice.MatchString(name) {
+			continue
+		}
+		if d.Rotational == "1" && nonRotational(root, name) {
+			d.Rotational = "0"
+		}
+		resp = append(resp, d)
+	}
+	return resp
+}
+
+// NonRotational reports whether a disk the kernel reports as rotational is flash storage
+func NonRotational(dev string) bool {
+	return nonRotational(sysfsRoot, filepath.Base(dev))
+}
+
+func nonRotational(root, name string) bool {
+	if strings.HasPrefix(name, "mmcblk") {
+		return true
+	}
+	devicePath := filepath.Join(root, "block", name, "device")
+	real, err := filepath.EvalSymlinks(devicePath)
+	if err != nil || !strings.Contains(real, "/usb") {
+		return false
+	}
+	// usb-storage和uas总是报告rotational，型号或discard支持说明是固态盘
+	if model, err := ioutil.ReadFile(filepath.Join(devicePath, "model")); err == nil && solidStateModel.Match(model) {
+		return true
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(root, "block", name, "queue", "discard_max_bytes")); err == nil {
+		if n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err == nil && n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// PartitionPath 返回磁盘分区的设备路径
+// Like the kernel, a "p" separates the partition number from disk names ending in a
+// digit: /dev/sda1, /dev/nvme0n1p1, /dev/mmcblk0p1, /dev/loop2p1.
+func PartitionPath(disk string, number uint) string {
+	if disk != "" && disk[len(disk)-1] >= '0' && disk[len(disk)-1] <= '9' {
+		return fmt.Sprintf("%sp%d", disk, number)
+	}
+	return fmt.Sprintf("%s%d", disk, number)
+}
@@ -0,0 +1,105 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package device
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyQuirks(t *testing.T) {
+	a := assert.New(t)
+	root, err := ioutil.TempDir("", "sysfs")
+	a.NoError(err)
+	defer os.RemoveAll(root)
+
+	write := func(p, content string) {
+		a.NoError(os.MkdirAll(filepath.Join(root, filepath.Dir(p)), 0755))
+		a.NoError(ioutil.WriteFile(filepath.Join(root, p), []byte(content), 0644))
+	}
+	link := func(target, name string) {
+		a.NoError(os.MkdirAll(filepath.Join(root, target), 0755))
+		a.NoError(os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755))
+		a.NoError(os.Symlink(filepath.Join(root, target), filepath.Join(root, name)))
+	}
+
+	// nvme盘装在usb硬盘盒里，型号来自桥接芯片
+	nvmeUsb := "devices/platform/scb/fd500000.pcie/pci0000:00/0000:00:00.0/0000:01:00.0/usb2/2-1/2-1:1.0/host0/target0:0:0/0:0:0:0"
+	link(nvmeUsb, "block/sda/device")
+	write(nvmeUsb+"/model", "RTL9210B-CG     \n")
+	write("block/sda/queue/discard_max_bytes", "4294966784\n")
+	// usb机械硬盘
+	hddUsb := "devices/platform/scb/fd500000.pcie/pci0000:00/0000:00:00.0/0000:01:00.0/usb2/2-2/2-2:1.0/host1/target1:0:0/1:0:0:0"
+	link(hddUsb, "block/sdb/device")
+	write(hddUsb+"/model", "Expansion HDD   \n")
+	write("block/sdb/queue/discard_max_bytes", "0\n")
+	// sata盘
+	sata := "devices/pci0000:00/0000:00:1f.2/ata1/host2/target2:0:0/2:0:0:0"
+	link(sata, "block/sdc/device")
+
+	disks := []*types.LocalDisk{
+		{Name: "/dev/mmcblk0", Type: "disk", Rotational: "0"},
+		{Name: "/dev/mmcblk0boot0", Type: "disk", Rotational: "0", Readonly: true},
+		{Name: "/dev/mmcblk0boot1", Type: "disk", Rotational: "0", Readonly: true},
+		{Name: "/dev/mmcblk0rpmb", Type: "disk", Rotational: "0"},
+		{Name: "/dev/mmcblk1", Type: "disk", Rotational: "1"},
+		{Name: "/dev/zram0", Type: "disk", Rotational: "0", MountPoint: "[SWAP]"},
+		{Name: "/dev/sda", Type: "disk", Rotational: "1"},
+		{Name: "/dev/sdb", Type: "disk", Rotational: "1"},
+		{Name: "/dev/sdc", Type: "disk", Rotational: "1"},
+		{Name: "/dev/nvme0n1", Type: "disk", Rotational: "0"},
+	}
+	got := map[string]string{}
+	for _, d := range applyQuirks(root, disks) {
+		got[d.Name] = d.Rotational
+	}
+	a.Equal(map[string]string{
+		"/dev/mmcblk0": "0",
+		"/dev/mmcblk1": "0",
+		"/dev/sda":     "0",
+		"/dev/sdb":     "1",
+		"/dev/sdc":     "1",
+		"/dev/nvme0n1": "0",
+	}, got)
+
+	// 型号中带ssd的桥接盘即使不支持discard也是固态盘
+	write(hddUsb+"/model", "Portable SSD T5 \n")
+	a.True(nonRotational(root, "sdb"))
+	a.False(nonRotational(root, "sdz"))
+}
+
+func TestPartitionPath(t *testing.T) {
+	table := []struct {
+		disk   string
+		number uint
+		path   string
+	}{
+		{disk: "/dev/sda", number: 1, path: "/dev/sda1"},
+		{disk: "/dev/vdb", number: 12, path: "/dev/vdb12"},
+		{disk: "/dev/nvme0n1", number: 2, path: "/dev/nvme0n1p2"},
+		{disk: "/dev/mmcblk0", number: 3, path: "/dev/mmcblk0p3"},
+		{disk: "/dev/loop2", number: 1, path: "/dev/loop2p1"},
+	}
+	for _, e := range table {
+		assert.Equal(t, e.path, PartitionPath(e.disk, e.number), e.disk)
+	}
+}
@@ -0,0 +1,229 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/carina-io/carina/utils/exec"
+	"github.com/carina-io/carina/utils/log"
+	"golang.org/x/sys/unix"
+)
+
+// 叠加在卷上的设备类型
+const (
+	LayerCrypt  = "crypt"
+	LayerDM     = "dm"
+	LayerBcache = "bcache"
+	LayerLoop   = "loop"
+)
+
+const (
+	// teardownAttempts 每一层设备最多尝试删除的次数，udev或刚关闭的文件可能短暂占用设备
+	teardownAttempts = 5
+	teardownBackoff  = time.Second
+)
+
+// StackLayer 叠加在卷上的一个设备
+type StackLayer struct {
+	// Name is the kernel name, e.g. dm-3, bcache0, loop1
+	Name string
+	// Kind is one of crypt, dm, bcache and loop, empty for devices carina does not know how to remove
+	Kind string
+	// DMName is the device mapper name of crypt and dm layers
+	DMName string
+	// Depth is the distance to the volume, devices right on top of it have depth 1
+	Depth int
+}
+
+// DeviceStack returns the devices stacked on dev, found through the holders in sysfs and the
+// backing files of loop devices. Layers are ordered outermost first, the order they are removed in.
+func DeviceStack(dev string) ([]StackLayer, error) {
+	return deviceStack(sysfsRoot, dev)
+}
+
+func deviceStack(root, dev string) ([]StackLayer, error) {
+	name, err := kernelName(root, dev)
+	if err != nil {
+		return nil, err
+	}
+
+	// 一个设备可能经多条路径叠加在卷上，取最长的路径作为深度
+	depth := map[string]int{}
+	var walk func(name string, d int)
+	walk = func(name string, d int) {
+		for _, h := range holders(root, name) {
+			if depth[h] >= d {
+				continue
+			}
+			depth[h] = d
+			walk(h, d+1)
+		}
+	}
+	walk(name, 1)
+
+	layers := []StackLayer{}
+	for n, d := range depth {
+		layers = append(layers, stackLayer(root, n, d))
+	}
+	sort.Slice(layers, func(i, j int) bool {
+		if layers[i].Depth != layers[j].Depth {
+			return layers[i].Depth > layers[j].Depth
+		}
+		return layers[i].Name < layers[j].Name
+	})
+	return layers, nil
+}
+
+// TeardownStack removes the devices stacked on dev outermost first, each layer is retried
+// with a growing backoff. Nothing is removed below a layer that cannot be removed.
+func TeardownStack(executor exec.Executor, dev string) error {
+	layers, err := DeviceStack(dev)
+	if err != nil {
+		return err
+	}
+	for _, l := range layers {
+		if err := removeLayer(executor, l); err != nil {
+			return fmt.Errorf("remove %s device %s stacked on %s failed: %v", l.Kind, l.Name, dev, err)
+		}
+		log.Infof("removed %s device %s stacked on %s", l.Kind, l.Name, dev)
+	}
+	return nil
+}
+
+func removeLayer(executor exec.Executor, l StackLayer) error {
+	command, args := removeCommand(l)
+	if command == "" {
+		return fmt.Errorf("unknown device type")
+	}
+	var err error
+	for i := 0; i < teardownAttempts; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * teardownBackoff)
+		}
+		if !layerExists(sysfsRoot, l.Name) {
+			return nil
+		}
+		if err = executor.ExecuteCommand(command, args...); err != nil {
+			log.Warnf("remove %s device %s, attempt %d: %s", l.Kind, l.Name, i+1, err.Error())
+			continue
+		}
+		// bcache等设备是异步删除的
+		for j := 0; j < 10 && layerExists(sysfsRoot, l.Name); j++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if !layerExists(sysfsRoot, l.Name) {
+			return nil
+		}
+		err = fmt.Errorf("%s still exists", l.Name)
+	}
+	return err
+}
+
+// removeCommand 删除一层设备的命令
+func removeCommand(l StackLayer) (string, []string) {
+	switch l.Kind {
+	case LayerCrypt:
+		return "cryptsetup", []string{"close", l.DMName}
+	case LayerDM:
+		return "dmsetup", []string{"remove", l.DMName}
+	case LayerBcache:
+		return "/bin/sh", []string{"-c", fmt.Sprintf("echo 1 > /sys/block/%s/bcache/stop", l.Name)}
+	case LayerLoop:
+		return "losetup", []string{"-d", "/dev/" + l.Name}
+	}
+	return "", nil
+}
+
+// kernelName 返回设备的内核名称，设备路径可以是符号链接或mknod创建的设备文件
+func kernelName(root, dev string) (string, error) {
+	if !strings.Contains(dev, "/") {
+		return dev, nil
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(dev, &st); err != nil {
+		return "", fmt.Errorf("stat failed for %s: %v", dev, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFBLK {
+		return "", fmt.Errorf("%s is not a block device", dev)
+	}
+	link, err := os.Readlink(filepath.Join(root, "dev", "block", fmt.Sprintf("%d:%d", unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev)))))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(link), nil
+}
+
+// holders 直接叠加在设备上的设备，loop设备不是holder，按其后端文件查找
+func holders(root, name string) []string {
+	result := []string{}
+	entries, _ := ioutil.ReadDir(filepath.Join(root, "class", "block", name, "holders"))
+	for _, e := range entries {
+		result = append(result, e.Name())
+	}
+	loops, _ := filepath.Glob(filepath.Join(root, "class", "block", "loop*", "loop", "backing_file"))
+	for _, l := range loops {
+		b, err := ioutil.ReadFile(l)
+		if err != nil {
+			continue
+		}
+		backing := strings.TrimSpace(string(b))
+		backingName, err := kernelName(root, backing)
+		if err != nil {
+			backingName = filepath.Base(backing)
+		}
+		if backingName == name {
+			result = append(result, filepath.Base(filepath.Dir(filepath.Dir(l))))
+		}
+	}
+	return result
+}
+
+func stackLayer(root, name string, depth int) StackLayer {
+	l := StackLayer{Name: name, Depth: depth}
+	base := filepath.Join(root, "class", "block", name)
+	switch {
+	case strings.HasPrefix(name, "loop"):
+		l.Kind = LayerLoop
+	case strings.HasPrefix(name, "bcache"):
+		l.Kind = LayerBcache
+	case strings.HasPrefix(name, "dm-"):
+		l.Kind = LayerDM
+		if b, err := ioutil.ReadFile(filepath.Join(base, "dm", "name")); err == nil {
+			l.DMName = strings.TrimSpace(string(b))
+		}
+		// cryptsetup创建的映射uuid以CRYPT-开头
+		if b, err := ioutil.ReadFile(filepath.Join(base, "dm", "uuid")); err == nil && strings.HasPrefix(string(b), "CRYPT-") {
+			l.Kind = LayerCrypt
+		}
+		if l.DMName == "" {
+			l.Kind = ""
+		}
+	}
+	return l
+}
+
+func layerExists(root, name string) bool {
+	_, err := os.Stat(filepath.Join(root, "class", "block", name))
+	return err == nil
+}
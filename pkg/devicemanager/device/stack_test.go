@@ -0,0 +1,90 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package device
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceStack(t *testing.T) {
+	a := assert.New(t)
+	root, err := ioutil.TempDir("", "sysfs")
+	a.NoError(err)
+	defer os.RemoveAll(root)
+
+	write := func(p, content string) {
+		a.NoError(os.MkdirAll(filepath.Join(root, filepath.Dir(p)), 0755))
+		a.NoError(ioutil.WriteFile(filepath.Join(root, p), []byte(content), 0644))
+	}
+	mkdir := func(p string) {
+		a.NoError(os.MkdirAll(filepath.Join(root, p), 0755))
+	}
+
+	// lv dm-3 <- crypt dm-4 <- loop0, and bcache0 straight on the lv, dm-4 is also held by dm-5
+	write("class/block/dm-3/dm/name", "lvmvg-volume--pvc--1\n")
+	mkdir("class/block/dm-3/holders/dm-4")
+	mkdir("class/block/dm-3/holders/bcache0")
+	write("class/block/dm-4/dm/name", "pvc-1-crypt\n")
+	write("class/block/dm-4/dm/uuid", "CRYPT-LUKS2-0f1e2d3c-pvc-1-crypt\n")
+	mkdir("class/block/dm-4/holders/dm-5")
+	write("class/block/dm-5/dm/name", "pvc-1-linear\n")
+	write("class/block/dm-5/dm/uuid", "\n")
+	mkdir("class/block/bcache0/holders")
+	write("class/block/loop0/loop/backing_file", "/dev/dm-4\n")
+	write("class/block/loop1/loop/backing_file", "/var/lib/images/disk.img\n")
+
+	layers, err := deviceStack(root, "dm-3")
+	a.NoError(err)
+	a.Equal([]StackLayer{
+		{Name: "dm-5", Kind: LayerDM, DMName: "pvc-1-linear", Depth: 2},
+		{Name: "loop0", Kind: LayerLoop, Depth: 2},
+		{Name: "bcache0", Kind: LayerBcache, Depth: 1},
+		{Name: "dm-4", Kind: LayerCrypt, DMName: "pvc-1-crypt", Depth: 1},
+	}, layers)
+
+	layers, err = deviceStack(root, "bcache0")
+	a.NoError(err)
+	a.Empty(layers)
+
+	_, err = deviceStack(root, filepath.Join(root, "class/block/dm-3/dm/name"))
+	a.Error(err)
+}
+
+func TestRemoveCommand(t *testing.T) {
+	a := assert.New(t)
+	table := []struct {
+		layer   StackLayer
+		command string
+		args    []string
+	}{
+		{StackLayer{Name: "dm-4", Kind: LayerCrypt, DMName: "pvc-1-crypt"}, "cryptsetup", []string{"close", "pvc-1-crypt"}},
+		{StackLayer{Name: "dm-5", Kind: LayerDM, DMName: "pvc-1-linear"}, "dmsetup", []string{"remove", "pvc-1-linear"}},
+		{StackLayer{Name: "bcache0", Kind: LayerBcache}, "/bin/sh", []string{"-c", "echo 1 > /sys/block/bcache0/bcache/stop"}},
+		{StackLayer{Name: "loop0", Kind: LayerLoop}, "losetup", []string{"-d", "/dev/loop0"}},
+		{StackLayer{Name: "md0"}, "", nil},
+	}
+	for _, tt := range table {
+		command, args := removeCommand(tt.layer)
+		a.Equal(tt.command, command, tt.layer.Name)
+		a.Equal(tt.args, args, tt.layer.Name)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package device
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/exec"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// wipeChunks 每遍覆写分段执行，每段完成后报告一次进度
+const wipeChunks = 4
+
+// Wipe 擦除卷的方式
+type Wipe struct {
+	// Policy none, discard or zero
+	Policy string
+	// Passes zero覆写的遍数，discard不支持时回退到zero也按此遍数，默认1遍
+	Passes int
+	// Progress 每完成一段调用一次，percent为当前遍的进度
+	Progress func(pass, passes, percent int)
+}
+
+// WipeDevice 按策略擦除块设备上的数据
+// discard falls back to zeroing when the device does not support it, so that the
+// data is gone either way. Zeroing runs in chunks to report the progress.
+func WipeDevice(executor exec.Executor, path string, wipe Wipe) error {
+	switch wipe.Policy {
+	case "", utils.WipePolicyNone:
+		return nil
+	case utils.WipePolicyDiscard:
+		err := executor.ExecuteCommand("blkdiscard", path)
+		if err == nil {
+			wipe.report(1, 1, 100)
+			return nil
+		}
+		log.Warnf("discard %s failed, zero it instead: %s", path, err.Error())
+		return zeroDevice(executor, path, wipe)
+	case utils.WipePolicyZero:
+		return zeroDevice(executor, path, wipe)
+	}
+	return fmt.Errorf("unknown wipe policy %s", wipe.Policy)
+}
+
+func (w Wipe) report(pass, passes, percent int) {
+	if w.Progress != nil {
+		w.Progress(pass, passes, percent)
+	}
+}
+
+// zeroDevice 按遍数用零覆写设备，取不到设备大小时每遍整体覆写
+func zeroDevice(executor exec.Executor, path string, wipe Wipe) error {
+	passes := wipe.Passes
+	if passes < 1 {
+		passes = 1
+	}
+	size := uint64(0)
+	if out, err := executor.ExecuteCommandWithOutput("blockdev", "--getsize64", path); err == nil {
+		size, _ = strconv.ParseUint(strings.TrimSpace(out), 10, 64)
+	} else {
+		log.Warnf("get size of %s failed, zero it at once: %s", path, err.Error())
+	}
+	for pass := 1; pass <= passes; pass++ {
+		chunks := wipeRanges(size)
+		if len(chunks) == 0 {
+			if err := executor.ExecuteCommand("blkdiscard", "-z", path); err != nil {
+				return err
+			}
+			wipe.report(pass, passes, 100)
+			continue
+		}
+		for i, c := range chunks {
+			if err := executor.ExecuteCommand("blkdiscard", "-z", "-o", strconv.FormatUint(c[0], 10), "-l", strconv.FormatUint(c[1], 10), path); err != nil {
+				return err
+			}
+			wipe.report(pass, passes, (i+1)*100/len(chunks))
+		}
+	}
+	return nil
+}
+
+// wipeRanges 将设备分成wipeChunks段，段的起点按1MiB对齐，小于wipeChunks MiB的设备不分段
+func wipeRanges(size uint64) [][2]uint64 {
+	chunk := size / wipeChunks &^ (1<<20 - 1)
+	if chunk == 0 {
+		return nil
+	}
+	ranges := [][2]uint64{}
+	for off := uint64(0); off < size; off += chunk {
+		length := chunk
+		if len(ranges) == wipeChunks-1 {
+			length = size - off
+		}
+		ranges = append(ranges, [2]uint64{off, length})
+		if len(ranges) == wipeChunks {
+			break
+		}
+	}
+	return ranges
+}
@@ -0,0 +1,40 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package device
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWipeRanges(t *testing.T) {
+	a := assert.New(t)
+	a.Nil(wipeRanges(0))
+	a.Nil(wipeRanges(3 << 20))
+
+	size := uint64(10<<20 + 512)
+	ranges := wipeRanges(size)
+	a.Len(ranges, wipeChunks)
+	covered := uint64(0)
+	for _, r := range ranges {
+		a.Equal(covered, r[0])
+		a.Equal(uint64(0), r[0]%(1<<20))
+		covered += r[1]
+	}
+	a.Equal(size, covered)
+}
@@ -0,0 +1,116 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package drbd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"strings"
+
+	"github.com/carina-io/carina/utils/exec"
+	"github.com/carina-io/carina/utils/log"
+)
+
+type DrbdImplement struct {
+	Executor exec.Executor
+}
+
+// checkTools drbd只在使用副本卷的节点上需要，不计入节点的工具检查
+func checkTools() error {
+	for _, name := range []string{"drbdadm", "drbdsetup"} {
+		if _, err := osexec.LookPath(name); err != nil {
+			return fmt.Errorf("replicated volumes require drbd-utils and the drbd 9 kernel module on the node, %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+var _ Drbd = &DrbdImplement{}
+
+func (d *DrbdImplement) Up(res *Resource) error {
+	if err := checkTools(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ConfigDir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(ConfigPath(res.Name), []byte(res.Config()), 0600); err != nil {
+		return err
+	}
+	out, err := d.Executor.ExecuteCommandWithCombinedOutput("drbdadm", "-c", ConfigPath(res.Name), "adjust", res.Name)
+	if err == nil {
+		return nil
+	}
+	// 新建的后端卷上还没有drbd元数据
+	if !strings.Contains(out, "No valid meta data found") && !strings.Contains(out, "no valid meta-data") {
+		return fmt.Errorf("drbdadm adjust %s: %v %s", res.Name, err, strings.TrimSpace(out))
+	}
+	log.Infof("create drbd meta data of resource %s", res.Name)
+	if out, err := d.Executor.ExecuteCommandWithCombinedOutput("drbdadm", "-c", ConfigPath(res.Name), "create-md", "--force", "--max-peers=1", res.Name); err != nil {
+		return fmt.Errorf("drbdadm create-md %s: %v %s", res.Name, err, strings.TrimSpace(out))
+	}
+	if out, err := d.Executor.ExecuteCommandWithCombinedOutput("drbdadm", "-c", ConfigPath(res.Name), "adjust", res.Name); err != nil {
+		return fmt.Errorf("drbdadm adjust %s: %v %s", res.Name, err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+func (d *DrbdImplement) Down(name string) error {
+	status, err := d.Status(name)
+	if err != nil {
+		return err
+	}
+	if status != nil {
+		if out, err := d.Executor.ExecuteCommandWithCombinedOutput("drbdsetup", "down", name); err != nil {
+			return fmt.Errorf("drbdsetup down %s: %v %s", name, err, strings.TrimSpace(out))
+		}
+	}
+	if err := os.Remove(ConfigPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (d *DrbdImplement) Status(name string) (*Status, error) {
+	if err := checkTools(); err != nil {
+		return nil, err
+	}
+	out, err := d.Executor.ExecuteCommandWithCombinedOutput("drbdsetup", "status", name)
+	if err != nil {
+		if strings.Contains(out, "No such resource") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("drbdsetup status %s: %v %s", name, err, strings.TrimSpace(out))
+	}
+	return ParseStatus(out), nil
+}
+
+func (d *DrbdImplement) SkipInitialSync(name string) error {
+	if out, err := d.Executor.ExecuteCommandWithCombinedOutput("drbdadm", "-c", ConfigPath(name), "new-current-uuid", "--clear-bitmap", name); err != nil {
+		return fmt.Errorf("drbdadm new-current-uuid %s: %v %s", name, err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+func (d *DrbdImplement) Resize(name string) error {
+	if out, err := d.Executor.ExecuteCommandWithCombinedOutput("drbdadm", "-c", ConfigPath(name), "resize", name); err != nil {
+		return fmt.Errorf("drbdadm resize %s: %v %s", name, err, strings.TrimSpace(out))
+	}
+	return nil
+}
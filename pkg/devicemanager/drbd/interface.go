@@ -0,0 +1,33 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package drbd
+
+// Drbd 管理副本卷的drbd资源
+type Drbd interface {
+	// Up writes the configuration of the resource and brings it to that state, creating the
+	// meta data on the backing volume the first time
+	Up(res *Resource) error
+	// Down takes the resource down and removes its configuration, a resource that is not up is ignored
+	Down(name string) error
+	// Status returns the state of the resource on this node, nil if it is not up
+	Status(name string) (*Status, error)
+	// SkipInitialSync marks both empty replicas of a new resource as identical instead of copying
+	// every block of one to the other
+	SkipInitialSync(name string) error
+	// Resize grows the device of the resource after both backing volumes grew
+	Resize(name string) error
+}
@@ -0,0 +1,202 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package drbd
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Major drbd块设备的主设备号
+	Major = 147
+	// BasePort 资源的复制端口为BasePort+minor
+	BasePort = 7900
+	// MaxMinor 可分配的最大minor，端口不超过BasePort+MaxMinor
+	MaxMinor = 999
+	// ConfigDir 资源配置文件所在目录，每次启动资源时重新生成
+	ConfigDir = "/var/run/carina/drbd"
+
+	// replicaPrefix 第二个副本的LogicVolume名前缀
+	replicaPrefix = "replica-"
+)
+
+// Host is one of the two nodes of a resource
+type Host struct {
+	// Hostname 节点的主机名，drbdadm据此找到本节点的配置
+	Hostname string
+	// Address 节点的IP，两个节点通过它复制数据
+	Address string
+	// Disk 节点上的后端lvm卷
+	Disk string
+}
+
+// Resource is the drbd resource of a replicated volume
+type Resource struct {
+	Name  string
+	Minor int
+	Hosts [2]Host
+	// Tiebreaker 第三个节点，不带磁盘，只参与仲裁
+	Tiebreaker *Host
+}
+
+// Status is the state of a resource on the local node
+type Status struct {
+	Role        string
+	Disk        string
+	PeerRole    string
+	PeerDisk    string
+	Connection  string
+	Replication string
+	// Quorum 本节点能否与多数节点通信，失去仲裁的节点不能提升为primary
+	Quorum bool
+}
+
+// Degraded reports whether the resource does not hold two up to date copies
+func (s *Status) Degraded() bool {
+	return s.Disk != "UpToDate" || s.PeerDisk != "UpToDate"
+}
+
+// ReplicaName returns the name of the LogicVolume of the second copy of a volume
+func ReplicaName(volume string) string {
+	return replicaPrefix + volume
+}
+
+// DevicePath returns the block device of a resource
+func DevicePath(minor int) string {
+	return fmt.Sprintf("/dev/drbd%d", minor)
+}
+
+// Port returns the port both nodes of a resource listen on
+func Port(minor int) int {
+	return BasePort + minor
+}
+
+// ConfigPath returns the configuration file of a resource
+func ConfigPath(name string) string {
+	return filepath.Join(ConfigDir, name+".res")
+}
+
+// NextMinor returns the lowest minor not used by any resource. A minor is used on both
+// nodes of a resource, allocating it cluster-wide keeps it free on every node.
+func NextMinor(used map[int]bool) (int, error) {
+	for minor := 0; minor <= MaxMinor; minor++ {
+		if !used[minor] {
+			return minor, nil
+		}
+	}
+	return 0, fmt.Errorf("all %d drbd minors are in use", MaxMinor+1)
+}
+
+// Config renders the drbd configuration of the resource. The primary role follows the
+// node that opens the device. A node only writes while it reaches a majority of the nodes,
+// the diskless tiebreaker lets the surviving copy keep it when the other one fails, so the
+// two copies never both take writes and a split brain is not resolved by discarding data.
+func (r *Resource) Config() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "resource %s {\n", r.Name)
+	fmt.Fprintf(&b, "  device minor %d;\n", r.Minor)
+	b.WriteString("  meta-disk internal;\n")
+	b.WriteString("  options {\n    auto-promote yes;\n    quorum majority;\n    on-no-quorum io-error;\n  }\n")
+	b.WriteString("  net {\n    protocol C;\n")
+	b.WriteString("    after-sb-0pri discard-zero-changes;\n    after-sb-1pri disconnect;\n    after-sb-2pri disconnect;\n  }\n")
+	hostnames := []string{}
+	for i, h := range r.Hosts {
+		fmt.Fprintf(&b, "  on %s {\n", h.Hostname)
+		fmt.Fprintf(&b, "    node-id %d;\n", i)
+		fmt.Fprintf(&b, "    disk %s;\n", h.Disk)
+		fmt.Fprintf(&b, "    address %s:%d;\n", h.Address, Port(r.Minor))
+		b.WriteString("  }\n")
+		hostnames = append(hostnames, h.Hostname)
+	}
+	if r.Tiebreaker != nil {
+		fmt.Fprintf(&b, "  on %s {\n", r.Tiebreaker.Hostname)
+		b.WriteString("    node-id 2;\n")
+		b.WriteString("    disk none;\n")
+		fmt.Fprintf(&b, "    address %s:%d;\n", r.Tiebreaker.Address, Port(r.Minor))
+		b.WriteString("  }\n")
+		hostnames = append(hostnames, r.Tiebreaker.Hostname)
+		fmt.Fprintf(&b, "  connection-mesh {\n    hosts %s;\n  }\n", strings.Join(hostnames, " "))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// ParseStatus parses the output of drbdsetup status of a single resource, e.g.
+//
+//	pvc-1 role:Primary
+//	  disk:UpToDate
+//	  node2 role:Secondary
+//	    replication:SyncSource peer-disk:Inconsistent done:12.50
+//
+// The connection and replication states are only printed while they are not Connected and Established,
+// quorum:no only while the node lost quorum. Only the first peer, the other copy, is reported.
+func ParseStatus(out string) *Status {
+	s := &Status{Quorum: true}
+	peers := 0
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for line := 0; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		// 对端节点的行以节点名开头，按node-id排列，tiebreaker在另一个副本之后
+		if line > 0 && !strings.Contains(fields[0], ":") {
+			peers++
+			if peers == 1 {
+				s.Connection = "Connected"
+			}
+		}
+		if peers > 1 {
+			continue
+		}
+		peer := peers == 1
+		for _, f := range fields {
+			kv := strings.SplitN(f, ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "role":
+				if peer {
+					s.PeerRole = kv[1]
+				} else {
+					s.Role = kv[1]
+				}
+			case "disk":
+				s.Disk = kv[1]
+			case "peer-disk":
+				s.PeerDisk = kv[1]
+				if s.Replication == "" {
+					s.Replication = "Established"
+				}
+			case "connection":
+				s.Connection = kv[1]
+			case "replication":
+				s.Replication = kv[1]
+			case "quorum":
+				s.Quorum = kv[1] != "no"
+			}
+		}
+	}
+	if s.Connection != "" && s.Connection != "Connected" {
+		s.Replication = ""
+	}
+	return s
+}
@@ -0,0 +1,151 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package drbd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig(t *testing.T) {
+	res := &Resource{
+		Name:  "pvc-1",
+		Minor: 3,
+		Hosts: [2]Host{
+			{Hostname: "node1", Address: "10.0.0.1", Disk: "/dev/carina-vg-ssd/volume-pvc-1"},
+			{Hostname: "node2", Address: "10.0.0.2", Disk: "/dev/carina-vg-ssd/volume-replica-pvc-1"},
+		},
+	}
+	assert.Equal(t, `resource pvc-1 {
+  device minor 3;
+  meta-disk internal;
+  options {
+    auto-promote yes;
+    quorum majority;
+    on-no-quorum io-error;
+  }
+  net {
+    protocol C;
+    after-sb-0pri discard-zero-changes;
+    after-sb-1pri disconnect;
+    after-sb-2pri disconnect;
+  }
+  on node1 {
+    node-id 0;
+    disk /dev/carina-vg-ssd/volume-pvc-1;
+    address 10.0.0.1:7903;
+  }
+  on node2 {
+    node-id 1;
+    disk /dev/carina-vg-ssd/volume-replica-pvc-1;
+    address 10.0.0.2:7903;
+  }
+}
+`, res.Config())
+
+	res.Tiebreaker = &Host{Hostname: "node3", Address: "10.0.0.3"}
+	assert.Contains(t, res.Config(), `  on node3 {
+    node-id 2;
+    disk none;
+    address 10.0.0.3:7903;
+  }
+  connection-mesh {
+    hosts node1 node2 node3;
+  }
+}
+`)
+}
+
+func TestParseStatus(t *testing.T) {
+	table := []struct {
+		out      string
+		status   Status
+		degraded bool
+	}{
+		{
+			out: `pvc-1 role:Primary
+  disk:UpToDate
+  node2 role:Secondary
+    peer-disk:UpToDate
+`,
+			status: Status{Role: "Primary", Disk: "UpToDate", PeerRole: "Secondary", PeerDisk: "UpToDate", Connection: "Connected", Replication: "Established", Quorum: true},
+		},
+		{
+			out: `pvc-1 role:Primary
+  disk:UpToDate
+  node2 role:Secondary
+    replication:SyncSource peer-disk:Inconsistent done:12.50
+`,
+			status:   Status{Role: "Primary", Disk: "UpToDate", PeerRole: "Secondary", PeerDisk: "Inconsistent", Connection: "Connected", Replication: "SyncSource", Quorum: true},
+			degraded: true,
+		},
+		{
+			out: `pvc-1 role:Secondary
+  disk:Inconsistent
+  node1 connection:Connecting
+`,
+			status:   Status{Role: "Secondary", Disk: "Inconsistent", Connection: "Connecting", Quorum: true},
+			degraded: true,
+		},
+		{
+			// 第三个节点是tiebreaker
+			out: `pvc-1 role:Secondary
+  disk:UpToDate
+  node1 role:Primary
+    peer-disk:UpToDate
+  node3 role:Secondary
+    peer-disk:Diskless
+`,
+			status: Status{Role: "Secondary", Disk: "UpToDate", PeerRole: "Primary", PeerDisk: "UpToDate", Connection: "Connected", Replication: "Established", Quorum: true},
+		},
+		{
+			out: `pvc-1 role:Secondary
+  disk:UpToDate quorum:no
+  node1 connection:Connecting
+  node3 connection:Connecting
+`,
+			status:   Status{Role: "Secondary", Disk: "UpToDate", Connection: "Connecting"},
+			degraded: true,
+		},
+	}
+	for _, e := range table {
+		status := ParseStatus(e.out)
+		assert.Equal(t, e.status, *status, e.out)
+		assert.Equal(t, e.degraded, status.Degraded(), e.out)
+	}
+}
+
+func TestNextMinor(t *testing.T) {
+	a := assert.New(t)
+	minor, err := NextMinor(map[int]bool{})
+	a.NoError(err)
+	a.Equal(0, minor)
+	minor, err = NextMinor(map[int]bool{0: true, 1: true, 3: true})
+	a.NoError(err)
+	a.Equal(2, minor)
+
+	used := map[int]bool{}
+	for i := 0; i <= MaxMinor; i++ {
+		used[i] = true
+	}
+	_, err = NextMinor(used)
+	a.Error(err)
+	a.Equal(7903, Port(3))
+	a.Equal("/dev/drbd3", DevicePath(3))
+	a.Equal("replica-pvc-1", ReplicaName("pvc-1"))
+}
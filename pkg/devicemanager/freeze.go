@@ -0,0 +1,68 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/carina-io/carina/utils/log"
+)
+
+// volumeMountPoint 返回卷在本节点的一个挂载点，未挂载时返回空
+func volumeMountPoint(pvName string) (string, error) {
+	mounts, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return "", err
+	}
+	return csiMounts(string(mounts))[pvName], nil
+}
+
+// FreezeVolume freezes the filesystem of a mounted volume with fsfreeze. A volume that is
+// not mounted on this node has no writers and there is nothing to freeze.
+func (dm *DeviceManager) FreezeVolume(pvName string) error {
+	target, err := volumeMountPoint(pvName)
+	if err != nil || target == "" {
+		return err
+	}
+	out, err := dm.Executor.ExecuteCommandWithCombinedOutput("fsfreeze", "--freeze", target)
+	if err != nil {
+		return fmt.Errorf("fsfreeze %s failed: %s %s", target, strings.TrimSpace(out), err.Error())
+	}
+	log.Infof("volume %s frozen at %s", pvName, target)
+	return nil
+}
+
+// ThawVolume thaws the filesystem of a volume frozen by FreezeVolume, a volume that is
+// not mounted or not frozen, e.g. after a reboot of the node, is thawed already.
+func (dm *DeviceManager) ThawVolume(pvName string) error {
+	target, err := volumeMountPoint(pvName)
+	if err != nil || target == "" {
+		return err
+	}
+	out, err := dm.Executor.ExecuteCommandWithCombinedOutput("fsfreeze", "--unfreeze", target)
+	if err != nil {
+		// 文件系统未冻结时返回EINVAL
+		if strings.Contains(out, "Invalid argument") {
+			return nil
+		}
+		return fmt.Errorf("fsfreeze %s failed: %s %s", target, strings.TrimSpace(out), err.Error())
+	}
+	log.Infof("volume %s thawed at %s", pvName, target)
+	return nil
+}
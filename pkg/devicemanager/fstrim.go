@@ -0,0 +1,134 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/carina-io/carina/utils/mutx"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fstrimCheckInterval 检查是否有卷到期需要fstrim的间隔
+const fstrimCheckInterval = 10 * time.Minute
+
+// csiMountPoint kubelet挂载csi卷的目录，<kubelet>/pods/<pod uid>/volumes/kubernetes.io~csi/<pv>/mount
+var csiMountPoint = regexp.MustCompile(`/volumes/kubernetes\.io~csi/([^/]+)/mount$`)
+
+// FstrimTask 定时对开启fstrim的已挂载卷执行fstrim，只在维护窗口内执行
+func (dm *DeviceManager) FstrimTask() {
+	ticker := time.NewTicker(fstrimCheckInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if configuration.FstrimInterval() == 0 || !dm.Throttle.Open() {
+					continue
+				}
+				_ = dm.Pool.Run(context.Background(), mutx.PriorityBackground, "fstrim", func() error {
+					dm.TrimVolumes()
+					return nil
+				})
+			case <-dm.stopChan:
+				log.Info("stop fstrim task...")
+				return
+			}
+		}
+	}()
+}
+
+// TrimVolumes runs fstrim on the mounted volumes of this node whose storageclass
+// enabled it and that were not trimmed within fstrimInterval. A volume mounted into
+// several pods is trimmed once. The time of the last trim is kept in memory, after a
+// restart of carina-node every volume is trimmed again in the next maintenance window.
+func (dm *DeviceManager) TrimVolumes() {
+	lvList := &carinav1.LogicVolumeList{}
+	if err := dm.Cache.List(context.Background(), lvList, client.MatchingFields{"nodeName": dm.nodeName}); err != nil {
+		log.Errorf("list logic volume error %s", err.Error())
+		return
+	}
+	enabled := map[string]bool{}
+	for _, lv := range lvList.Items {
+		if lv.Annotations[utils.VolumeFstrim] == "true" {
+			enabled[lv.Name] = true
+		}
+	}
+	if len(enabled) == 0 {
+		return
+	}
+
+	mounts, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		log.Errorf("read /proc/mounts failed: %s", err.Error())
+		return
+	}
+	interval := time.Duration(configuration.FstrimInterval()) * time.Second
+	for name, target := range csiMounts(string(mounts)) {
+		if !enabled[name] {
+			continue
+		}
+		if last, ok := dm.lastFstrim[name]; ok && time.Since(last) < interval {
+			continue
+		}
+		// 维护窗口可能在执行期间结束
+		if !dm.Throttle.Open() {
+			return
+		}
+		start := time.Now()
+		out, err := dm.Executor.ExecuteCommandWithCombinedOutput("fstrim", "-v", target)
+		if err != nil {
+			log.Warnf("fstrim volume %s at %s failed: %s %s", name, target, strings.TrimSpace(out), err.Error())
+			continue
+		}
+		dm.lastFstrim[name] = time.Now()
+		log.Infof("fstrim volume %s: %s, took %s", name, strings.TrimSpace(out), time.Since(start).Round(time.Millisecond))
+	}
+	for name := range dm.lastFstrim {
+		if !enabled[name] {
+			delete(dm.lastFstrim, name)
+		}
+	}
+}
+
+// csiMounts 返回/proc/mounts中csi卷的pv名称和一个挂载点
+func csiMounts(mounts string) map[string]string {
+	result := map[string]string{}
+	for _, line := range strings.Split(mounts, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		// /proc/mounts中的空格等字符被转义为\040，这里的目录不包含这些字符
+		m := csiMountPoint.FindStringSubmatch(fields[1])
+		if m == nil {
+			continue
+		}
+		if _, ok := result[m[1]]; !ok {
+			result[m[1]] = fields[1]
+		}
+	}
+	return result
+}
@@ -0,0 +1,38 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCsiMounts(t *testing.T) {
+	mounts := `/dev/sda1 / ext4 rw,relatime 0 0
+/dev/carina/volume-pvc-1 /var/lib/kubelet/pods/6f1c/volumes/kubernetes.io~csi/pvc-1/mount ext4 rw,relatime 0 0
+/dev/carina/volume-pvc-1 /var/lib/kubelet/pods/9a2d/volumes/kubernetes.io~csi/pvc-1/mount ext4 rw,relatime 0 0
+/dev/mapper/luks-volume-pvc-2 /data/kubelet/pods/1b3e/volumes/kubernetes.io~csi/pvc-2/mount xfs rw,noatime 0 0
+/dev/carina/volume-pvc-3 /var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-3/globalmount ext4 rw 0 0
+tmpfs /var/lib/kubelet/pods/6f1c/volumes/kubernetes.io~projected/kube-api-access tmpfs rw 0 0
+`
+	a := assert.New(t)
+	a.Equal(map[string]string{
+		"pvc-1": "/var/lib/kubelet/pods/6f1c/volumes/kubernetes.io~csi/pvc-1/mount",
+		"pvc-2": "/data/kubelet/pods/1b3e/volumes/kubernetes.io~csi/pvc-2/mount",
+	}, csiMounts(mounts))
+}
@@ -0,0 +1,255 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/carina-io/carina/api"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxSpareReplacements NodeStorageResource中保留的替换记录数量
+const maxSpareReplacements = 20
+
+// spareSelector 磁盘组热备盘的正则，没有配置热备盘时为nil
+func spareSelector(ds configuration.DiskSelectorItem) *regexp.Regexp {
+	if len(ds.Spare) == 0 || strings.ToLower(ds.Policy) == "raw" {
+		return nil
+	}
+	re, err := regexp.Compile(strings.Join(ds.Spare, "|"))
+	if err != nil {
+		log.Warnf("spare regex %s error %v ", strings.Join(ds.Spare, "|"), err)
+		return nil
+	}
+	return re
+}
+
+// spareDisk reports whether the disk is a hot spare of any disk group
+func spareDisk(diskClass map[string]configuration.DiskSelectorItem, disk string) bool {
+	for _, ds := range diskClass {
+		if re := spareSelector(ds); re != nil && re.MatchString(disk) {
+			return true
+		}
+	}
+	return false
+}
+
+// DiscoverSpares 查找各磁盘组热备盘正则匹配的空磁盘，一块磁盘只属于名称最小的磁盘组
+func (dm *DeviceManager) DiscoverSpares(diskClass map[string]configuration.DiskSelectorItem) (map[string][]types.LocalDisk, error) {
+	spares := map[string][]types.LocalDisk{}
+	groups := []string{}
+	for name, ds := range diskClass {
+		if spareSelector(ds) != nil {
+			groups = append(groups, name)
+		}
+	}
+	if len(groups) == 0 {
+		return spares, nil
+	}
+	sort.Strings(groups)
+
+	localDisk, err := dm.DiskManager.ListDevicesDetail("")
+	if err != nil {
+		return spares, err
+	}
+	parentDisk := map[string]int8{}
+	for _, d := range localDisk {
+		parentDisk[d.ParentName] = 1
+	}
+	taken := map[string]bool{}
+	for _, name := range groups {
+		re := spareSelector(diskClass[name])
+		deny := &diskMatcher{deny: diskClass[name].Deny}
+		for _, d := range localDisk {
+			if taken[d.Name] || !candidateDisk(d, parentDisk) || !re.MatchString(d.Name) || deny.Denied(d) {
+				continue
+			}
+			used, err := dm.DiskManager.GetDiskUsed(d.Name)
+			if err != nil || used > 0 {
+				log.Warnf("hot spare %s of %s is not empty", d.Name, name)
+				continue
+			}
+			taken[d.Name] = true
+			spares[name] = append(spares[name], *d)
+		}
+	}
+	return spares, nil
+}
+
+// pickSpare 选择能容纳丢失pv的最小热备盘
+func pickSpare(spares []types.LocalDisk, size uint64) (types.LocalDisk, bool) {
+	best := -1
+	for i, d := range spares {
+		if d.Size < size {
+			continue
+		}
+		if best < 0 || d.Size < spares[best].Size || (d.Size == spares[best].Size && d.Name < spares[best].Name) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return types.LocalDisk{}, false
+	}
+	return spares[best], true
+}
+
+// degradedLVs vg中镜像丢失的raid和mirror卷，lv_attr第1位为卷类型，第9位p表示部分pv丢失
+func degradedLVs(lvs []types.LvInfo, vg string) []string {
+	result := []string{}
+	for _, lv := range lvs {
+		if lv.VGName != vg || len(lv.LVAttr) < 9 {
+			continue
+		}
+		if strings.ContainsRune("rRmM", rune(lv.LVAttr[0])) && lv.LVAttr[8] == 'p' {
+			result = append(result, lv.LVName)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// replaceMissingPvs 为vg中尚未替换的丢失pv各加入一块热备盘，并将降级的raid卷重建到热备盘，返回剩余的热备盘
+func (dm *DeviceManager) replaceMissingPvs(vg *api.VgGroup, ds configuration.DiskSelectorItem, spares []types.LocalDisk) []types.LocalDisk {
+	if spareSelector(ds) == nil {
+		return spares
+	}
+	missing := []*api.PVInfo{}
+	for _, pv := range vg.PVS {
+		if strings.Contains(pv.PVName, "unknown") {
+			missing = append(missing, pv)
+		}
+	}
+
+	dm.spareMutex.Lock()
+	handled := dm.spareHandled[vg.VGName]
+	if handled > len(missing) {
+		handled = len(missing)
+	}
+	dm.spareHandled[vg.VGName] = handled
+	dm.spareMutex.Unlock()
+
+	for _, pv := range missing[handled:] {
+		record := carinav1beta1.SpareReplacement{DeviceGroup: vg.VGName, Time: metav1.Now()}
+		spare, ok := pickSpare(spares, pv.PVSize)
+		if !ok {
+			record.Message = fmt.Sprintf("volume group %s lost a physical volume of %d bytes, no hot spare of that size is available", vg.VGName, pv.PVSize)
+			log.Warn(record.Message)
+			dm.recordReplacement(record, false)
+			continue
+		}
+		if err := dm.VolumeManager.AddNewDiskToVg(spare.Name, vg.VGName); err != nil {
+			log.Errorf("add hot spare %s to vg %s failed: %v", spare.Name, vg.VGName, err)
+			continue
+		}
+		remaining := []types.LocalDisk{}
+		for _, d := range spares {
+			if d.Name != spare.Name {
+				remaining = append(remaining, d)
+			}
+		}
+		spares = remaining
+		record.Spare = spare.Name
+
+		problems := []string{}
+		lvs, err := dm.LvmManager.LVS("")
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("list logical volumes: %v", err))
+		}
+		for _, lv := range degradedLVs(lvs, vg.VGName) {
+			if err := dm.LvmManager.LVRepair(lv, vg.VGName, []string{spare.Name}); err != nil {
+				problems = append(problems, fmt.Sprintf("repair %s: %v", lv, err))
+				continue
+			}
+			record.Repaired = append(record.Repaired, lv)
+		}
+		record.Message = fmt.Sprintf("volume group %s lost a physical volume, added hot spare %s", vg.VGName, spare.Name)
+		if len(record.Repaired) > 0 {
+			record.Message += fmt.Sprintf(", rebuilding %s", strings.Join(record.Repaired, ", "))
+		}
+		if len(problems) > 0 {
+			record.Message += ", " + strings.Join(problems, "; ")
+		}
+		log.Info(record.Message)
+		dm.recordReplacement(record, true)
+	}
+	return spares
+}
+
+// recordReplacement 记录替换结果，连续的替换失败只记录一次
+func (dm *DeviceManager) recordReplacement(record carinav1beta1.SpareReplacement, replaced bool) {
+	dm.spareMutex.Lock()
+	defer dm.spareMutex.Unlock()
+	if replaced {
+		dm.spareHandled[record.DeviceGroup]++
+	} else {
+		for i := len(dm.spareReplacements) - 1; i >= 0; i-- {
+			last := dm.spareReplacements[i]
+			if last.DeviceGroup != record.DeviceGroup {
+				continue
+			}
+			if last.Spare == "" {
+				return
+			}
+			break
+		}
+	}
+	dm.spareReplacements = append(dm.spareReplacements, record)
+	if len(dm.spareReplacements) > maxSpareReplacements {
+		dm.spareReplacements = dm.spareReplacements[len(dm.spareReplacements)-maxSpareReplacements:]
+	}
+	dm.VolumeManager.NoticeUpdateCapacity([]string{})
+}
+
+func (dm *DeviceManager) setHotSpares(spares map[string][]types.LocalDisk) {
+	result := []carinav1beta1.HotSpare{}
+	for group, disks := range spares {
+		for _, d := range disks {
+			result = append(result, carinav1beta1.HotSpare{DeviceGroup: group, Disk: d.Name, Size: d.Size})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].DeviceGroup != result[j].DeviceGroup {
+			return result[i].DeviceGroup < result[j].DeviceGroup
+		}
+		return result[i].Disk < result[j].Disk
+	})
+	dm.spareMutex.Lock()
+	defer dm.spareMutex.Unlock()
+	dm.hotSpares = result
+}
+
+// HotSpareStatus 返回节点上的热备盘和最近的替换记录
+func (dm *DeviceManager) HotSpareStatus() ([]carinav1beta1.HotSpare, []carinav1beta1.SpareReplacement) {
+	dm.spareMutex.Lock()
+	defer dm.spareMutex.Unlock()
+	// 为空时返回nil，与api server返回的status一致
+	var spares []carinav1beta1.HotSpare
+	spares = append(spares, dm.hotSpares...)
+	var replacements []carinav1beta1.SpareReplacement
+	for _, r := range dm.spareReplacements {
+		replacements = append(replacements, *r.DeepCopy())
+	}
+	return spares, replacements
+}
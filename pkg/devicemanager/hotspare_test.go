@@ -0,0 +1,69 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"testing"
+
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPickSpare(t *testing.T) {
+	a := assert.New(t)
+	spares := []types.LocalDisk{
+		{Name: "/dev/sdz", Size: 4 << 40},
+		{Name: "/dev/sdy", Size: 2 << 40},
+		{Name: "/dev/sdx", Size: 2 << 40},
+	}
+	d, ok := pickSpare(spares, 1<<40)
+	a.True(ok)
+	a.Equal("/dev/sdx", d.Name)
+	d, ok = pickSpare(spares, 3<<40)
+	a.True(ok)
+	a.Equal("/dev/sdz", d.Name)
+	_, ok = pickSpare(spares, 5<<40)
+	a.False(ok)
+	_, ok = pickSpare(nil, 0)
+	a.False(ok)
+}
+
+func TestDegradedLVs(t *testing.T) {
+	lvs := []types.LvInfo{
+		{LVName: "raid-pvc-1", VGName: "carina-vg-hdd", LVAttr: "rwi-aor-p-"},
+		{LVName: "mirror-pvc-2", VGName: "carina-vg-hdd", LVAttr: "mwi-a-m-p-"},
+		{LVName: "raid-pvc-3", VGName: "carina-vg-hdd", LVAttr: "rwi-aor---"},
+		{LVName: "thin-pvc-4", VGName: "carina-vg-hdd", LVAttr: "twi-aotzp-"},
+		{LVName: "raid-pvc-5", VGName: "carina-vg-ssd", LVAttr: "rwi-aor-p-"},
+		{LVName: "short", VGName: "carina-vg-hdd", LVAttr: "rwi"},
+	}
+	assert.Equal(t, []string{"mirror-pvc-2", "raid-pvc-1"}, degradedLVs(lvs, "carina-vg-hdd"))
+}
+
+func TestSpareDisk(t *testing.T) {
+	a := assert.New(t)
+	diskClass := map[string]configuration.DiskSelectorItem{
+		"carina-vg-hdd":  {Name: "carina-vg-hdd", Re: []string{"sd[a-y]"}, Spare: []string{"sdz"}, Policy: "LVM"},
+		"carina-raw-ssd": {Name: "carina-raw-ssd", Re: []string{"vd[a-y]"}, Spare: []string{"vdz"}, Policy: "RAW"},
+	}
+	a.True(spareDisk(diskClass, "/dev/sdz"))
+	a.False(spareDisk(diskClass, "/dev/sdb"))
+	// raw磁盘组不支持热备盘
+	a.False(spareDisk(diskClass, "/dev/vdz"))
+	a.Nil(spareSelector(diskClass["carina-raw-ssd"]))
+}
@@ -0,0 +1,134 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/tools"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// loopFile 磁盘组第index个loop设备的稀疏文件
+func loopFile(dir, group string, index int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s-%d.img", group, index))
+}
+
+// associatedLoop 解析losetup -j的输出，返回挂载了该文件的loop设备
+// e.g. /dev/loop3: [66306]:1835011 (/var/lib/carina/loop/carina-vg-loop-0.img)
+func associatedLoop(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if i := strings.Index(line, ":"); i > 0 && strings.HasPrefix(line, "/dev/loop") {
+			return line[:i]
+		}
+	}
+	return ""
+}
+
+// AttachLoopDevices creates the sparse files of the loop devices configured for the lvm
+// device groups of the node and attaches the ones not attached yet, e.g. after a reboot.
+// A file smaller than the configured size is grown, never shrunk. It returns the loop
+// devices of every device group and whether a loop device was attached.
+func (dm *DeviceManager) AttachLoopDevices(diskClass map[string]configuration.DiskSelectorItem) (map[string][]string, bool) {
+	loops := map[string][]string{}
+	attached := false
+	dir := configuration.LoopDeviceDir()
+	items := configuration.LoopDevices()
+	if len(items) > 0 {
+		if err := tools.Check(tools.FeatureLoop); err != nil {
+			log.Errorf("skip loop devices: %s", err.Error())
+			items = nil
+		}
+	}
+	for _, item := range items {
+		ds, ok := diskClass[item.DeviceGroup]
+		if !ok || strings.ToLower(ds.Policy) == "raw" {
+			continue
+		}
+		size, _ := item.SizeBytes()
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Errorf("create loop device directory %s failed: %s", dir, err.Error())
+			return loops, attached
+		}
+		for i := 0; i < item.Count; i++ {
+			file := loopFile(dir, item.DeviceGroup, i)
+			grown, err := growSparseFile(file, size)
+			if err != nil {
+				log.Errorf("prepare loop device file %s failed: %s", file, err.Error())
+				break
+			}
+			out, err := dm.Executor.ExecuteCommandWithOutput("losetup", "-j", file)
+			if err != nil {
+				log.Errorf("find loop device of %s failed: %s", file, err.Error())
+				break
+			}
+			dev := associatedLoop(out)
+			if dev == "" {
+				out, err = dm.Executor.ExecuteCommandWithOutput("losetup", "-f", "--show", file)
+				if err != nil {
+					log.Errorf("attach loop device of %s failed: %s", file, err.Error())
+					break
+				}
+				dev = strings.TrimSpace(out)
+				attached = true
+				log.Infof("attach %s to %s for device group %s", file, dev, item.DeviceGroup)
+			} else if grown {
+				// 通知内核文件大小变化，pv随后在DiscoverPv中扩容
+				if err := dm.Executor.ExecuteCommand("losetup", "-c", dev); err != nil {
+					log.Errorf("update size of loop device %s failed: %s", dev, err.Error())
+				}
+			}
+			loops[item.DeviceGroup] = append(loops[item.DeviceGroup], dev)
+		}
+	}
+
+	dm.loopMutex.Lock()
+	dm.loopDevices = loops
+	dm.loopMutex.Unlock()
+	return loops, attached
+}
+
+// LoopDevices returns the loop devices attached by the last AttachLoopDevices
+func (dm *DeviceManager) LoopDevices() map[string][]string {
+	dm.loopMutex.Lock()
+	defer dm.loopMutex.Unlock()
+	return dm.loopDevices
+}
+
+// growSparseFile 创建或扩大稀疏文件，返回已有文件是否被扩大
+func growSparseFile(file string, size int64) (bool, error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() >= size {
+		return false, nil
+	}
+	if err := f.Truncate(size); err != nil {
+		return false, err
+	}
+	return info.Size() > 0, nil
+}
@@ -0,0 +1,63 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssociatedLoop(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("/dev/loop3", associatedLoop("/dev/loop3: [66306]:1835011 (/var/lib/carina/loop/carina-vg-loop-0.img)\n"))
+	a.Equal("", associatedLoop(""))
+	a.Equal("/var/lib/carina/loop/carina-vg-loop-1.img", loopFile("/var/lib/carina/loop", "carina-vg-loop", 1))
+}
+
+func TestGrowSparseFile(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "loop")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	file := loopFile(dir, "carina-vg-loop", 0)
+
+	grown, err := growSparseFile(file, 10<<30)
+	a.NoError(err)
+	a.False(grown)
+	info, err := os.Stat(file)
+	a.NoError(err)
+	a.Equal(int64(10<<30), info.Size())
+
+	grown, err = growSparseFile(file, 10<<30)
+	a.NoError(err)
+	a.False(grown)
+
+	grown, err = growSparseFile(file, 20<<30)
+	a.NoError(err)
+	a.True(grown)
+
+	// 不缩小已有文件
+	grown, err = growSparseFile(file, 10<<30)
+	a.NoError(err)
+	a.False(grown)
+	info, err = os.Stat(file)
+	a.NoError(err)
+	a.Equal(int64(20<<30), info.Size())
+}
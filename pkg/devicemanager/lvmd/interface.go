@@ -18,6 +18,7 @@ package lvmd
 
 import (
 	"github.com/carina-io/carina/api"
+	"github.com/carina-io/carina/pkg/devicemanager/device"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 )
 
@@ -32,6 +33,8 @@ type Lvm2 interface {
 	// PVScan 扫描pv加入cache,在服务启动时执行
 	PVScan(dev string) error
 	PVDisplay(dev string) (*api.PVInfo, error)
+	// PVAddTag 给pv添加标签，pv移出vg并pvremove后标签随之删除
+	PVAddTag(dev, tag string) error
 
 	VGCheck(vg string) error
 	VGCreate(vg string, tags, pvs []string) error
@@ -43,18 +46,35 @@ type Lvm2 interface {
 	VGExtend(vg, pv string) error
 	// VGReduce vg卷组安全移除pv
 	VGReduce(vg, pv string) error
+	// PVSegments 列出pv上各lv占用的空间
+	PVSegments(pv string) ([]types.PVSegment, error)
+	// PVMove 将lv在source上的数据迁移到targets
+	PVMove(lv, source string, targets []string) error
+	// LVRepair 将raid或mirror卷在丢失pv上的镜像重建到targets，重建在后台同步
+	LVRepair(lv, vg string, targets []string) error
 
 	// CreateThinPool 每一个Volume对应的是一个thin pool下一个lvm卷
 	// 若是要扩容卷，则必须先扩容池子
 	// 快照占用的是池子剩余的容量
-	CreateThinPool(lv, vg string, size uint64) error
+	// stripes大于1时池子数据跨多块pv条带化，扩容时lvm沿用相同的条带
+	CreateThinPool(lv, vg string, size uint64, stripes uint, stripeSize string) error
 	ResizeThinPool(lv, vg string, size uint64) error
 	DeleteThinPool(lv, vg string) error
 	LVCreateFromPool(lv, thin, vg string, size uint64) error
 	// LVCreateFromVG 这个方法不用
 	LVCreateFromVG(lv, vg string, size uint64, tags []string, stripe uint, stripeSize string) error
+	// LVChangeTags 给lv添加和删除标签
+	LVChangeTags(lv, vg string, add, del []string) error
 	LVRemove(lv, vg string) error
+	// LVRemoveBatch 一次lvremove删除多个卷，lvm只扫描和提交一次vg元数据
+	LVRemoveBatch(lvs []string, vg string) error
+	// LVWipe 按策略擦除卷上的数据
+	LVWipe(lv, vg string, wipe device.Wipe) error
 	LVResize(lv, vg string, size uint64) error
+	// LVRename 重命名卷，卷打开时也可以执行
+	LVRename(lv, newName, vg string) error
+	// LVFormat 在卷上创建文件系统，用于预先格式化的备用卷
+	LVFormat(lv, vg, fsType string) error
 	LVDisplay(lv, vg string) (*types.LvInfo, error)
 	// LVS 这个方法会频繁调用
 	LVS(lvName string) ([]types.LvInfo, error)
@@ -21,8 +21,11 @@ import (
 	"fmt"
 	"github.com/carina-io/carina/api"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/carina-io/carina/pkg/devicemanager/device"
+	"github.com/carina-io/carina/pkg/devicemanager/tools"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/exec"
@@ -31,36 +34,115 @@ import (
 
 type Lvm2Implement struct {
 	Executor exec.Executor
+	// textReport 为1时lvm2不支持--reportformat json
+	textReport int32
+}
+
+var (
+	// jsonReportArgs lvm2 2.02.158起支持json报告，字段值不受分隔符和版本差异影响
+	jsonReportArgs = []string{"--reportformat", "json", "--units=b", "--nosuffix"}
+	textReportArgs = []string{"--noheadings", "--separator=,", "--units=b", "--nosuffix", "--unbuffered", "--nameprefixes"}
+)
+
+// report runs an lvm report command and returns the rows of section, along with the messages
+// of lvm for errors. The json report is used and lvm2 versions without it fall back to
+// --nameprefixes for the life of the process. Only stdout is parsed, warnings of lvm go to stderr.
+func (lv2 *Lvm2Implement) report(command, section, fields string, args ...string) ([]lvmRow, string, error) {
+	if atomic.LoadInt32(&lv2.textReport) == 0 {
+		out, stderr, err := lv2.lvm().ExecuteCommandWithStderr(command, append(append([]string{"-o", fields}, jsonReportArgs...), args...)...)
+		if err == nil {
+			if stderr != "" {
+				log.Debugf("%s: %s", command, stderr)
+			}
+			rows, err := parseJSONReport(out, section)
+			if err != nil {
+				return nil, err.Error(), err
+			}
+			return rows, stderr, nil
+		}
+		if !strings.Contains(stderr, "reportformat") {
+			return nil, lvmMessage(stderr, err), err
+		}
+		log.Warnf("%s does not support json reports, use --nameprefixes: %s", command, stderr)
+		atomic.StoreInt32(&lv2.textReport, 1)
+	}
+	out, stderr, err := lv2.lvm().ExecuteCommandWithStderr(command, append(append([]string{"-o", fields}, textReportArgs...), args...)...)
+	if err != nil {
+		return nil, lvmMessage(stderr, err), err
+	}
+	return parseNamePrefixes(out), stderr, nil
+}
+
+// lvmMessage lvm命令失败的原因，没有输出时使用退出码
+func lvmMessage(stderr string, err error) string {
+	if stderr != "" {
+		return stderr
+	}
+	return err.Error()
+}
+
+// lvm 镜像自带静态lvm时lvm2的命令通过它执行，否则使用PATH中的lvm2命令
+func (lv2 *Lvm2Implement) lvm() exec.Executor {
+	if binary := tools.Lvm(); binary != "" {
+		return &staticLvm{Executor: lv2.Executor, binary: binary}
+	}
+	return lv2.Executor
+}
+
+// staticLvm runs lvm2 commands as subcommands of a single lvm binary, e.g. lvm lvcreate ...
+type staticLvm struct {
+	exec.Executor
+	binary string
+}
+
+func (s *staticLvm) ExecuteCommand(command string, arg ...string) error {
+	return s.Executor.ExecuteCommand(s.binary, append([]string{command}, arg...)...)
+}
+
+func (s *staticLvm) ExecuteCommandWithOutput(command string, arg ...string) (string, error) {
+	return s.Executor.ExecuteCommandWithOutput(s.binary, append([]string{command}, arg...)...)
+}
+
+func (s *staticLvm) ExecuteCommandWithCombinedOutput(command string, arg ...string) (string, error) {
+	return s.Executor.ExecuteCommandWithCombinedOutput(s.binary, append([]string{command}, arg...)...)
+}
+
+func (s *staticLvm) ExecuteCommandWithStderr(command string, arg ...string) (string, string, error) {
+	return s.Executor.ExecuteCommandWithStderr(s.binary, append([]string{command}, arg...)...)
 }
 
 func (lv2 *Lvm2Implement) PVCheck(dev string) (string, error) {
-	return lv2.Executor.ExecuteCommandWithCombinedOutput("pvck", dev)
+	return lv2.lvm().ExecuteCommandWithCombinedOutput("pvck", dev)
 }
 
 func (lv2 *Lvm2Implement) PVCreate(dev string) error {
-	return lv2.Executor.ExecuteCommand("pvcreate", dev)
+	return lv2.lvm().ExecuteCommand("pvcreate", dev)
 }
 
 func (lv2 *Lvm2Implement) PVRemove(dev string) error {
-	return lv2.Executor.ExecuteCommand("pvremove", dev)
+	return lv2.lvm().ExecuteCommand("pvremove", dev)
 }
 
 func (lv2 *Lvm2Implement) PVResize(dev string) error {
-	return lv2.Executor.ExecuteCommand("pvresize", dev)
+	return lv2.lvm().ExecuteCommand("pvresize", dev)
 }
 
 // PVS 示例输出
-// pvs --noheadings --separator=, --units=b --nosuffix --unbuffered --nameprefixes
-// LVM2_PV_NAME='/dev/loop2',LVM2_VG_NAME='lvmvg',LVM2_PV_FMT='lvm2',LVM2_PV_ATTR='a--',LVM2_PV_SIZE='16101933056',LVM2_PV_FREE='16101933056'
+// pvs -o pv_name,vg_name,pv_fmt,pv_attr,pv_size,pv_free,pv_tags --reportformat json --units=b --nosuffix
+// {"report": [{"pv": [{"pv_name":"/dev/loop2", "vg_name":"lvmvg", "pv_fmt":"lvm2", "pv_attr":"a--", "pv_size":"16101933056", "pv_free":"16101933056", "pv_tags":"tag1,tag2"}]}]}
+// 不支持json的旧版本
+// pvs -o pv_name,vg_name,pv_fmt,pv_attr,pv_size,pv_free,pv_tags --noheadings --separator=, --units=b --nosuffix --unbuffered --nameprefixes
+// LVM2_PV_NAME='/dev/loop2',LVM2_VG_NAME='lvmvg',LVM2_PV_FMT='lvm2',LVM2_PV_ATTR='a--',LVM2_PV_SIZE='16101933056',LVM2_PV_FREE='16101933056',LVM2_PV_TAGS='tag1,tag2'
 func (lv2 *Lvm2Implement) PVS() ([]api.PVInfo, error) {
-
-	args := []string{"--noheadings", "--separator=,", "--units=b", "--nosuffix", "--unbuffered", "--nameprefixes"}
-
-	pvsInfo, err := lv2.Executor.ExecuteCommandWithOutput("pvs", args...)
+	rows, _, err := lv2.report("pvs", "pv", "pv_name,vg_name,pv_fmt,pv_attr,pv_size,pv_free,pv_tags")
 	if err != nil {
 		return nil, err
 	}
-	return parsePvs(pvsInfo), nil
+	pvs := pvsFromRows(rows)
+	for i := range pvs {
+		pvs[i].FailureDomain = device.FailureDomain(pvs[i].PVName)
+	}
+	return pvs, nil
 }
 
 // PVDisplay
@@ -93,16 +175,21 @@ func (lv2 *Lvm2Implement) PVDisplay(dev string) (*api.PVInfo, error) {
 // PVScan runs the `pvscan --cache <dev>` command. It scans for the
 // device at `dev` and adds it to the LVM metadata cache if `lvmetad`
 // is running. If `dev` is an empty string, it scans all devices.
+// PVAddTag pvchange --addtag tag dev
+func (lv2 *Lvm2Implement) PVAddTag(dev, tag string) error {
+	return lv2.lvm().ExecuteCommand("pvchange", "--addtag", tag, dev)
+}
+
 func (lv2 *Lvm2Implement) PVScan(dev string) error {
 	args := []string{"--cache"}
 	if dev != "" {
 		args = append(args, dev)
 	}
-	return lv2.Executor.ExecuteCommand("pvscan", args...)
+	return lv2.lvm().ExecuteCommand("pvscan", args...)
 }
 
 func (lv2 *Lvm2Implement) VGCheck(vg string) error {
-	return lv2.Executor.ExecuteCommand("vgck", vg)
+	return lv2.lvm().ExecuteCommand("vgck", vg)
 }
 
 // VGCreate vgcreate --add-tag=v1 v1 /dev/loop4
@@ -117,7 +204,7 @@ func (lv2 *Lvm2Implement) VGCreate(vg string, tags, pvs []string) error {
 	for _, pv := range pvs {
 		args = append(args, pv)
 	}
-	err := lv2.Executor.ExecuteCommand("vgcreate", args...)
+	err := lv2.lvm().ExecuteCommand("vgcreate", args...)
 	if err != nil {
 		return err
 	}
@@ -126,7 +213,7 @@ func (lv2 *Lvm2Implement) VGCreate(vg string, tags, pvs []string) error {
 }
 
 func (lv2 *Lvm2Implement) VGRemove(vg string) error {
-	return lv2.Executor.ExecuteCommand("vgremove", "-f", vg)
+	return lv2.lvm().ExecuteCommand("vgremove", "-f", vg)
 }
 
 // VGS 示例
@@ -134,15 +221,12 @@ func (lv2 *Lvm2Implement) VGRemove(vg string) error {
 // LVM2_VG_NAME='lvmvg',LVM2_PV_COUNT='1',LVM2_LV_COUNT='0',LVM2_SNAP_COUNT='0',LVM2_VG_ATTR='wz--n-',LVM2_VG_SIZE='16101933056',LVM2_VG_FREE='16101933056'
 // LVM2_VG_NAME='v1',LVM2_PV_COUNT='2',LVM2_LV_COUNT='0',LVM2_SNAP_COUNT='0',LVM2_VG_ATTR='wz--n-',LVM2_VG_SIZE='32203866112',LVM2_VG_FREE='32203866112'
 func (lv2 *Lvm2Implement) VGS() ([]api.VgGroup, error) {
-	flieds := []string{"-o", "VG_NAME,PV_NAME,PV_COUNT,LV_COUNT,SNAP_COUNT,VG_ATTR,VG_SIZE,VG_FREE"}
-	args := []string{"--noheadings", "--separator=,", "--units=b", "--nosuffix", "--unbuffered", "--nameprefixes"}
-
-	vgsInfo, err := lv2.Executor.ExecuteCommandWithOutput("vgs", append(flieds, args...)...)
+	rows, _, err := lv2.report("vgs", "vg", "vg_name,pv_name,pv_count,lv_count,snap_count,vg_attr,vg_size,vg_free")
 	if err != nil {
 		return nil, err
 	}
 
-	return parseVgs(vgsInfo), nil
+	return vgsFromRows(rows), nil
 }
 
 func (lv2 *Lvm2Implement) VGDisplay(vg string) (*api.VgGroup, error) {
@@ -167,12 +251,12 @@ func (lv2 *Lvm2Implement) VGScan(vg string) error {
 	if vg != "" {
 		args = append(args, vg)
 	}
-	return lv2.Executor.ExecuteCommand("vgscan", args...)
+	return lv2.lvm().ExecuteCommand("vgscan", args...)
 }
 
 func (lv2 *Lvm2Implement) VGExtend(vg, pv string) error {
 
-	err := lv2.Executor.ExecuteCommand("vgextend", vg, pv)
+	err := lv2.lvm().ExecuteCommand("vgextend", vg, pv)
 	if err != nil {
 		return err
 	}
@@ -203,7 +287,7 @@ func (lv2 *Lvm2Implement) VGExtend(vg, pv string) error {
 */
 func (lv2 *Lvm2Implement) VGReduce(vg, pv string) error {
 
-	output, err := lv2.Executor.ExecuteCommandWithOutput("pvmove", pv)
+	output, err := lv2.lvm().ExecuteCommandWithOutput("pvmove", pv)
 	if err != nil && !strings.Contains(output, "No data to move") {
 		log.Error(output)
 		return err
@@ -211,7 +295,7 @@ func (lv2 *Lvm2Implement) VGReduce(vg, pv string) error {
 
 	log.Info("wait 1s to exec vgreduce ")
 	time.Sleep(1 * time.Second)
-	if err := lv2.Executor.ExecuteCommand("vgreduce", vg, pv); err != nil {
+	if err := lv2.lvm().ExecuteCommand("vgreduce", vg, pv); err != nil {
 		return err
 	}
 
@@ -223,14 +307,54 @@ func (lv2 *Lvm2Implement) VGReduce(vg, pv string) error {
 	return nil
 }
 
-// CreateThinPool lvcreate -T v1/t5 --size 2g
-func (lv2 *Lvm2Implement) CreateThinPool(lv, vg string, size uint64) error {
-	return lv2.Executor.ExecuteCommand("lvcreate", "-T", fmt.Sprintf("%s/%s", vg, lv), "--size", fmt.Sprintf("%vg", size>>30))
+// PVSegments 示例输出
+// pvs --segments -o pv_name,vg_name,lv_name,seg_size --noheadings --separator=, --units=b --nosuffix --unbuffered --nameprefixes /dev/loop2
+// LVM2_PV_NAME='/dev/loop2',LVM2_VG_NAME='v1',LVM2_LV_NAME='[thin-pvc-1_tdata]',LVM2_SEG_SIZE='2147483648'
+// 空闲段的LVM2_LV_NAME为空
+func (lv2 *Lvm2Implement) PVSegments(pv string) ([]types.PVSegment, error) {
+	rows, segsInfo, err := lv2.report("pvs", "pvseg", "pv_name,vg_name,lv_name,seg_size", "--segments", pv)
+	if err != nil {
+		return nil, errors.New(segsInfo)
+	}
+	return pvSegmentsFromRows(rows), nil
+}
+
+// PVMove pvmove -n thin-pvc-1_tdata /dev/loop2 /dev/loop3 /dev/loop4
+// pvmove可以中断后继续，数据在迁移过程中始终可用
+func (lv2 *Lvm2Implement) PVMove(lv, source string, targets []string) error {
+	args := append([]string{"-n", lv, source}, targets...)
+	output, err := lv2.lvm().ExecuteCommandWithOutput("pvmove", args...)
+	if err != nil && !strings.Contains(output, "No data to move") {
+		return errors.New(output)
+	}
+	return nil
+}
+
+// LVRepair lvconvert --repair -y v1/raid-pvc-1 /dev/loop5
+func (lv2 *Lvm2Implement) LVRepair(lv, vg string, targets []string) error {
+	args := append([]string{"--repair", "-y", fmt.Sprintf("%s/%s", vg, lv)}, targets...)
+	output, err := lv2.lvm().ExecuteCommandWithOutput("lvconvert", args...)
+	if err != nil {
+		return errors.New(output)
+	}
+	return nil
+}
+
+// CreateThinPool lvcreate -T v1/t5 --size 2g [-i 2 -I 64k]
+func (lv2 *Lvm2Implement) CreateThinPool(lv, vg string, size uint64, stripes uint, stripeSize string) error {
+	args := []string{"-T", fmt.Sprintf("%s/%s", vg, lv), "--size", fmt.Sprintf("%vg", size>>30), "--addtag", MetadataTag(MetadataVersion)}
+	if stripes > 1 {
+		args = append(args, "-i", fmt.Sprintf("%d", stripes))
+		if stripeSize != "" {
+			args = append(args, "-I", stripeSize)
+		}
+	}
+	return lv2.lvm().ExecuteCommand("lvcreate", args...)
 }
 
 // ResizeThinPool lvresize -f -L 6g v1/t5
 func (lv2 *Lvm2Implement) ResizeThinPool(lv, vg string, size uint64) error {
-	return lv2.Executor.ExecuteCommand("lvresize", "-f", "-L", fmt.Sprintf("%vg", size>>30), fmt.Sprintf("%s/%s", vg, lv))
+	return lv2.lvm().ExecuteCommand("lvresize", "-f", "-L", fmt.Sprintf("%vg", size>>30), fmt.Sprintf("%s/%s", vg, lv))
 }
 
 // DeleteThinPool lvremove v1/t3
@@ -241,14 +365,14 @@ func (lv2 *Lvm2Implement) DeleteThinPool(lv, vg string) error {
 
 func (lv2 *Lvm2Implement) LVCreateFromPool(lv, thin, vg string, size uint64) error {
 
-	return lv2.Executor.ExecuteCommand("lvcreate", "-T", fmt.Sprintf("%s/%s", vg, thin), "-n", lv, "-V", fmt.Sprintf("%vg", size>>30))
+	return lv2.lvm().ExecuteCommand("lvcreate", "-T", fmt.Sprintf("%s/%s", vg, thin), "-n", lv, "-V", fmt.Sprintf("%vg", size>>30), "--addtag", MetadataTag(MetadataVersion))
 }
 
 // LVCreateFromVG LVCreate creates logical volume in this volume group.
 // name is a name of creating volume. size is volume size in bytes. volTags is a
 // list of tags to add to the volume.
 func (lv2 *Lvm2Implement) LVCreateFromVG(lv, vg string, size uint64, tags []string, stripe uint, stripeSize string) error {
-	args := []string{"-n", lv, "-L", fmt.Sprintf("%vg", size>>30), "-W", "y", "-y"}
+	args := []string{"-n", lv, "-L", fmt.Sprintf("%vg", size>>30), "-W", "y", "-y", "--add-tag=" + MetadataTag(MetadataVersion)}
 	for _, tag := range tags {
 		if tag != "" {
 			args = append(args, "--add-tag="+tag)
@@ -263,16 +387,66 @@ func (lv2 *Lvm2Implement) LVCreateFromVG(lv, vg string, size uint64, tags []stri
 	}
 	args = append(args, vg)
 
-	return lv2.Executor.ExecuteCommand("lvcreate", args...)
+	return lv2.lvm().ExecuteCommand("lvcreate", args...)
+}
+
+// LVChangeTags lvchange --addtag a --deltag d vg/lv
+func (lv2 *Lvm2Implement) LVChangeTags(lv, vg string, add, del []string) error {
+	args := []string{}
+	for _, tag := range add {
+		args = append(args, "--addtag", tag)
+	}
+	for _, tag := range del {
+		args = append(args, "--deltag", tag)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return lv2.lvm().ExecuteCommand("lvchange", append(args, fmt.Sprintf("%s/%s", vg, lv))...)
 }
 
 func (lv2 *Lvm2Implement) LVRemove(lv, vg string) error {
-	return lv2.Executor.ExecuteCommand("lvremove", "-f", fmt.Sprintf("%s/%s", vg, lv))
+	return lv2.lvm().ExecuteCommand("lvremove", "-f", fmt.Sprintf("%s/%s", vg, lv))
+}
+
+// LVRemoveBatch lvremove -f v1/m1 v1/m2
+func (lv2 *Lvm2Implement) LVRemoveBatch(lvs []string, vg string) error {
+	if len(lvs) == 0 {
+		return nil
+	}
+	args := []string{"-f"}
+	for _, lv := range lvs {
+		args = append(args, fmt.Sprintf("%s/%s", vg, lv))
+	}
+	return lv2.lvm().ExecuteCommand("lvremove", args...)
+}
+
+// LVWipe blkdiscard /dev/v1/m2
+func (lv2 *Lvm2Implement) LVWipe(lv, vg string, wipe device.Wipe) error {
+	return device.WipeDevice(lv2.Executor, fmt.Sprintf("/dev/%s/%s", vg, lv), wipe)
 }
 
 // LVResize lvresize -L 2g v1/m2
 func (lv2 *Lvm2Implement) LVResize(lv, vg string, size uint64) error {
-	return lv2.Executor.ExecuteCommand("lvresize", "-L", fmt.Sprintf("%vg", size>>30), fmt.Sprintf("%s/%s", vg, lv))
+	return lv2.lvm().ExecuteCommand("lvresize", "-L", fmt.Sprintf("%vg", size>>30), fmt.Sprintf("%s/%s", vg, lv))
+}
+
+// LVRename lvrename v1 m2 m3
+func (lv2 *Lvm2Implement) LVRename(lv, newName, vg string) error {
+	return lv2.lvm().ExecuteCommand("lvrename", vg, lv, newName)
+}
+
+// LVFormat mkfs.ext4 -F -m0 /dev/v1/m2, same options as kubelet uses when formatting a volume
+func (lv2 *Lvm2Implement) LVFormat(lv, vg, fsType string) error {
+	device := fmt.Sprintf("/dev/%s/%s", vg, lv)
+	args := []string{device}
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		args = []string{"-F", "-m0", device}
+	case "xfs":
+		args = []string{"-f", device}
+	}
+	return lv2.Executor.ExecuteCommand("mkfs."+fsType, args...)
 }
 
 // LVDisplay lvdisplay v1/m2
@@ -285,7 +459,7 @@ func (lv2 *Lvm2Implement) LVDisplay(lv, vg string) (*types.LvInfo, error) {
 		return nil, errors.New("not found")
 	}
 	return &lvInfo[0], nil
-	//return lv2.Executor.ExecuteCommandWithOutput("lvdisplay", fmt.Sprintf("%s/%s", vg, lv))
+	//return lv2.lvm().ExecuteCommandWithOutput("lvdisplay", fmt.Sprintf("%s/%s", vg, lv))
 }
 
 // LVS
@@ -297,28 +471,25 @@ func (lv2 *Lvm2Implement) LVDisplay(lv, vg string) (*types.LvInfo, error) {
 
 */
 func (lv2 *Lvm2Implement) LVS(lvName string) ([]types.LvInfo, error) {
-	fields := []string{"-o", "lv_name,vg_name,lv_path,lv_size,data_percent,lv_attr,lv_kernel_major,lv_kernel_minor,origin,origin_size,pool_lv,thin_count,lv_tags,lv_active"}
-	args := []string{"--noheadings", "--separator=,", "--units=b", "--nosuffix", "--unbuffered", "--nameprefixes"}
-
+	args := []string{}
 	if lvName != "" {
 		args = append(args, lvName)
 	}
 
-	lvsInfo, err := lv2.Executor.ExecuteCommandWithOutput("lvs", append(fields, args...)...)
+	rows, lvsInfo, err := lv2.report("lvs", "lv", "lv_name,vg_name,lv_path,lv_size,data_percent,lv_attr,lv_kernel_major,lv_kernel_minor,origin,origin_size,pool_lv,thin_count,lv_tags,lv_active", args...)
 	if err != nil && strings.Contains(lvsInfo, "Failed to find logical volume") {
 		return []types.LvInfo{}, nil
 	}
 	if err != nil {
 		return nil, errors.New(lvsInfo)
 	}
-	return parseLvs(lvsInfo), nil
+	return lvsFromRows(rows), nil
 }
 
 // CreateSnapshot lvcreate -s v1/m2 -n snaph-m1 -ay -Ky
 func (lv2 *Lvm2Implement) CreateSnapshot(snap, lv, vg string) error {
-	// Pool容量时lv卷的三倍，则能创建两个快照
-	// TODO: 需要检查pool>lvm卷,若是相等则不支持创建快照操作
-	return lv2.Executor.ExecuteCommand("lvcreate", "-s", fmt.Sprintf("%s/%s", vg, lv), "-n", snap, "-ay", "-Ky")
+	// Pool容量时lv卷的三倍，则能创建两个快照，pool容量由调用方保证
+	return lv2.lvm().ExecuteCommand("lvcreate", "-s", fmt.Sprintf("%s/%s", vg, lv), "-n", snap, "-ay", "-Ky", "--addtag", MetadataTag(MetadataVersion))
 }
 
 // DeleteSnapshot
@@ -337,7 +508,7 @@ func (lv2 *Lvm2Implement) DeleteSnapshot(snap, vg string) error {
 func (lv2 *Lvm2Implement) RestoreSnapshot(snap, vg string) error {
 	// 恢复快照后，此快照将消失
 	// TODO: 恢复快照前要umount
-	return lv2.Executor.ExecuteCommand("lvconvert", "--merge", fmt.Sprintf("%s/%s", vg, snap))
+	return lv2.lvm().ExecuteCommand("lvconvert", "--merge", fmt.Sprintf("%s/%s", vg, snap))
 }
 
 func (lv2 *Lvm2Implement) StartLvm2() error {
@@ -355,7 +526,7 @@ func (lv2 *Lvm2Implement) StartLvm2() error {
 }
 
 func (lv2 *Lvm2Implement) RemoveUnknownDevice(vg string) error {
-	return lv2.Executor.ExecuteCommand("vgreduce", "--removemissing", vg)
+	return lv2.lvm().ExecuteCommand("vgreduce", "--removemissing", vg)
 }
 
 func (lv2 *Lvm2Implement) PartProbe() error {
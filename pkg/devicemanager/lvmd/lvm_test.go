@@ -0,0 +1,66 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvmd
+
+import (
+	"testing"
+
+	"github.com/carina-io/carina/utils/exec"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordExecutor struct {
+	exec.Executor
+	commands [][]string
+}
+
+func (r *recordExecutor) ExecuteCommand(command string, arg ...string) error {
+	r.commands = append(r.commands, append([]string{command}, arg...))
+	return nil
+}
+
+func (r *recordExecutor) ExecuteCommandWithStderr(command string, arg ...string) (string, string, error) {
+	r.commands = append(r.commands, append([]string{command}, arg...))
+	return `{"report": [{"vg": [{"vg_name":"carina-vg-ssd", "vg_size":"32203866112", "vg_free":"21466447872"}]}]}`, "  WARNING: Not using lvmetad because config setting use_lvmetad=0.", nil
+}
+
+func TestStaticLvm(t *testing.T) {
+	a := assert.New(t)
+	r := &recordExecutor{}
+	lvm := &staticLvm{Executor: r, binary: "/usr/local/carina/sbin/lvm"}
+
+	a.NoError(lvm.ExecuteCommand("lvcreate", "-n", "volume-pvc-1", "-L", "1g", "carina-vg-ssd"))
+	_, _, err := lvm.ExecuteCommandWithStderr("vgs", "-o", "vg_name")
+	a.NoError(err)
+	a.Equal([][]string{
+		{"/usr/local/carina/sbin/lvm", "lvcreate", "-n", "volume-pvc-1", "-L", "1g", "carina-vg-ssd"},
+		{"/usr/local/carina/sbin/lvm", "vgs", "-o", "vg_name"},
+	}, r.commands)
+}
+
+func TestReport(t *testing.T) {
+	a := assert.New(t)
+	r := &recordExecutor{}
+	lv2 := &Lvm2Implement{Executor: r}
+
+	// 不存在静态lvm时使用PATH中的命令，stderr中的警告不影响解析
+	vgs, err := lv2.VGS()
+	a.NoError(err)
+	a.Len(vgs, 1)
+	a.Equal(uint64(21466447872), vgs[0].VGFree)
+	a.Equal("vgs", r.commands[0][0])
+}
@@ -0,0 +1,202 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvmd
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/carina-io/carina/utils"
+)
+
+const (
+	filterBegin = "# BEGIN carina managed global_filter"
+	filterEnd   = "# END carina managed global_filter"
+)
+
+// ErrFilterConflict lvm.conf中已有管理员配置的global_filter，carina不覆盖
+var ErrFilterConflict = errors.New("global_filter is already set outside of the carina managed block")
+
+var (
+	devicesSection = regexp.MustCompile(`^\s*devices\s*\{`)
+	globalFilter   = regexp.MustCompile(`^\s*global_filter\s*=`)
+	useDevicesFile = regexp.MustCompile(`^\s*use_devicesfile\s*=\s*1\b`)
+)
+
+// FilterStatus 最近一次同步lvm.conf过滤规则的结果
+type FilterStatus struct {
+	Path   string `json:"path"`
+	Filter string `json:"filter,omitempty"`
+	InSync bool   `json:"inSync"`
+	// Drifts counts how often the managed block was found modified and rewritten
+	Drifts    int       `json:"drifts"`
+	LastDrift time.Time `json:"lastDrift,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// DevicePattern 精确匹配设备路径的过滤正则
+// Special characters are put into brackets instead of being escaped with a backslash,
+// e.g. /dev/disk/by-id/wwn-0x5000c500a1b2c3d4 stays readable and needs no escaping in lvm.conf.
+func DevicePattern(path string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range path {
+		if strings.ContainsRune(`.+*?(){}[]$|`, c) {
+			b.WriteString("[" + string(c) + "]")
+			continue
+		}
+		b.WriteRune(c)
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// filterRule 选择正则中没有出现的分隔符，例如"a|^/dev/sdb$|"
+func filterRule(action, pattern string) string {
+	delimiter := "|"
+	for _, d := range []string{"|", "/", "#", "%", "!", "@"} {
+		if !strings.Contains(pattern, d) {
+			delimiter = d
+			break
+		}
+	}
+	return `"` + action + delimiter + pattern + delimiter + `"`
+}
+
+// AcceptOnlyFilter lvm只扫描匹配的设备，没有设备时返回空字符串
+func AcceptOnlyFilter(patterns []string) string {
+	if len(patterns) == 0 {
+		return ""
+	}
+	rules := []string{}
+	for _, p := range patterns {
+		rules = append(rules, filterRule("a", p))
+	}
+	rules = append(rules, filterRule("r", ".*"))
+	return "global_filter = [ " + strings.Join(rules, ", ") + " ]"
+}
+
+// RejectFilter lvm不扫描匹配的设备，其余设备不受影响，没有设备时返回空字符串
+func RejectFilter(patterns []string) string {
+	if len(patterns) == 0 {
+		return ""
+	}
+	rules := []string{}
+	for _, p := range patterns {
+		rules = append(rules, filterRule("r", p))
+	}
+	return "global_filter = [ " + strings.Join(rules, ", ") + " ]"
+}
+
+// ManagedFilter 返回carina管理的global_filter，没有管理块时返回false
+func ManagedFilter(conf string) (string, bool) {
+	lines := strings.Split(conf, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != filterBegin {
+			continue
+		}
+		filter := []string{}
+		for _, l := range lines[i+1:] {
+			if strings.TrimSpace(l) == filterEnd {
+				break
+			}
+			filter = append(filter, strings.TrimSpace(l))
+		}
+		return strings.Join(filter, "\n"), true
+	}
+	return "", false
+}
+
+// SetGlobalFilter 在devices段中写入carina管理的global_filter，filter为空时移除管理块
+// A global_filter set by the administrator outside of the managed block is never
+// overwritten, ErrFilterConflict is returned instead.
+func SetGlobalFilter(conf, filter string) (string, bool, error) {
+	lines := strings.Split(conf, "\n")
+	kept := []string{}
+	inBlock := false
+	for _, line := range lines {
+		switch {
+		case strings.TrimSpace(line) == filterBegin:
+			inBlock = true
+			continue
+		case strings.TrimSpace(line) == filterEnd:
+			inBlock = false
+			continue
+		case inBlock:
+			continue
+		case globalFilter.MatchString(line):
+			return conf, false, ErrFilterConflict
+		}
+		kept = append(kept, line)
+	}
+
+	if filter != "" {
+		block := []string{"\t" + filterBegin, "\t" + filter, "\t" + filterEnd}
+		inserted := false
+		for i, line := range kept {
+			if devicesSection.MatchString(line) {
+				kept = append(kept[:i+1], append(block, kept[i+1:]...)...)
+				inserted = true
+				break
+			}
+		}
+		if !inserted {
+			if len(kept) > 0 && kept[len(kept)-1] == "" {
+				kept = kept[:len(kept)-1]
+			}
+			kept = append(kept, "devices {")
+			kept = append(kept, block...)
+			kept = append(kept, "}", "")
+		}
+	}
+
+	result := strings.Join(kept, "\n")
+	return result, result != conf, nil
+}
+
+// UsesDevicesFile lvm 2.03.12以后可以用devices file代替过滤规则
+func UsesDevicesFile(conf string) bool {
+	for _, line := range strings.Split(conf, "\n") {
+		if useDevicesFile.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveDevicesFileEntries 从devices file中删除指定设备，返回新内容和删除的设备
+// Entries look like: IDTYPE=sys_wwid IDNAME=naa.5000c500a1b2c3d4 DEVNAME=/dev/sdb PVID=... PART=0
+func RemoveDevicesFileEntries(devices string, paths []string) (string, []string) {
+	kept := []string{}
+	removed := []string{}
+	for _, line := range strings.Split(devices, "\n") {
+		name := ""
+		for _, field := range strings.Fields(line) {
+			if strings.HasPrefix(field, "DEVNAME=") {
+				name = strings.TrimPrefix(field, "DEVNAME=")
+			}
+		}
+		if name != "" && !strings.HasPrefix(strings.TrimSpace(line), "#") && utils.ContainsString(paths, name) {
+			removed = append(removed, name)
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n"), removed
+}
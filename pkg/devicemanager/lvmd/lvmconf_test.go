@@ -0,0 +1,109 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvmd
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const lvmConf = `config {
+	checks = 1
+}
+devices {
+	dir = "/dev"
+	# global_filter = [ "a|.*|" ]
+	scan = [ "/dev" ]
+}
+`
+
+func TestFilters(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("", AcceptOnlyFilter(nil))
+	a.Equal(`global_filter = [ "a|loop+|", "a|^/dev/sd.b$|", "r|.*|" ]`, AcceptOnlyFilter([]string{"loop+", "^/dev/sd.b$"}))
+	a.Equal(`global_filter = [ "r/^vd[b-c]|xvd$/" ]`, RejectFilter([]string{"^vd[b-c]|xvd$"}))
+
+	pattern := DevicePattern("/dev/disk/by-id/wwn-0x5000c500a1b2c3d4.part1")
+	a.Equal("^/dev/disk/by-id/wwn-0x5000c500a1b2c3d4[.]part1$", pattern)
+	re := regexp.MustCompile(pattern)
+	a.True(re.MatchString("/dev/disk/by-id/wwn-0x5000c500a1b2c3d4.part1"))
+	a.False(re.MatchString("/dev/disk/by-id/wwn-0x5000c500a1b2c3d4xpart1"))
+	a.False(re.MatchString("/dev/disk/by-id/wwn-0x5000c500a1b2c3d4.part10"))
+}
+
+func TestSetGlobalFilter(t *testing.T) {
+	a := assert.New(t)
+	filter := RejectFilter([]string{DevicePattern("/dev/disk/by-id/wwn-0x5000c500a1b2c3d4")})
+
+	conf, changed, err := SetGlobalFilter(lvmConf, filter)
+	a.NoError(err)
+	a.True(changed)
+	a.Contains(conf, "devices {\n\t"+filterBegin+"\n\t"+filter+"\n\t"+filterEnd+"\n\tdir = \"/dev\"")
+	managed, ok := ManagedFilter(conf)
+	a.True(ok)
+	a.Equal(filter, managed)
+
+	_, changed, err = SetGlobalFilter(conf, filter)
+	a.NoError(err)
+	a.False(changed)
+
+	// 管理块被修改后恢复
+	drifted := regexp.MustCompile(`wwn-0x5000c500a1b2c3d4`).ReplaceAllString(conf, "wwn-0x1")
+	restored, changed, err := SetGlobalFilter(drifted, filter)
+	a.NoError(err)
+	a.True(changed)
+	a.Equal(conf, restored)
+
+	removed, changed, err := SetGlobalFilter(conf, "")
+	a.NoError(err)
+	a.True(changed)
+	a.Equal(lvmConf, removed)
+	_, ok = ManagedFilter(removed)
+	a.False(ok)
+
+	// 没有devices段时追加
+	conf, changed, err = SetGlobalFilter("config {\n}\n", filter)
+	a.NoError(err)
+	a.True(changed)
+	a.Equal("config {\n}\ndevices {\n\t"+filterBegin+"\n\t"+filter+"\n\t"+filterEnd+"\n}\n", conf)
+
+	// 管理员自己配置的global_filter不覆盖
+	_, _, err = SetGlobalFilter("devices {\n\tglobal_filter = [ \"r|/dev/sdb|\" ]\n}\n", filter)
+	a.Equal(ErrFilterConflict, err)
+}
+
+func TestDevicesFile(t *testing.T) {
+	a := assert.New(t)
+	a.False(UsesDevicesFile(lvmConf))
+	a.False(UsesDevicesFile("devices {\n\t# use_devicesfile = 1\n}\n"))
+	a.True(UsesDevicesFile("devices {\n\tuse_devicesfile = 1\n}\n"))
+
+	devices := `# LVM uses devices listed in this file.
+VERSION=1.1.2
+IDTYPE=sys_wwid IDNAME=naa.5000c500a1b2c3d4 DEVNAME=/dev/sda2 PVID=Lbx0MDn8JxzKjX6sU0ItOAzcRBBoQE8p PART=2
+IDTYPE=sys_wwid IDNAME=naa.5000c500a1b2c3d5 DEVNAME=/dev/sdb PVID=Mdq6XGc8mLx1JyJ0f0Wn2ffG3eY5u2bG
+`
+	kept, removed := RemoveDevicesFileEntries(devices, []string{"/dev/sdb", "/dev/sdc"})
+	a.Equal([]string{"/dev/sdb"}, removed)
+	a.NotContains(kept, "/dev/sdb")
+	a.Contains(kept, "DEVNAME=/dev/sda2")
+
+	_, removed = RemoveDevicesFileEntries(kept, []string{"/dev/sdb"})
+	a.Empty(removed)
+}
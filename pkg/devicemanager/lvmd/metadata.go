@@ -0,0 +1,55 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MetadataVersion carina写入lvm元数据的版本，记录在carina创建的卷、thin pool和快照的标签上
+// 版本0的卷没有版本标签，由记录版本之前的carina创建，版本1起带有版本标签。
+// Raise it together with a migration in devicemanager whenever carina changes what it keeps in
+// lvm in a way an older carina-node would misread.
+const MetadataVersion = 1
+
+// MetadataTagPrefix 版本标签的前缀，lvm标签只能包含[A-Za-z0-9_+.-/=!:&#]
+const MetadataTagPrefix = "carina.storage.io/metadata-version="
+
+// MetadataTag 元数据版本的标签
+func MetadataTag(version int) string {
+	return fmt.Sprintf("%s%d", MetadataTagPrefix, version)
+}
+
+// ParseMetadataVersion 从lv的标签中解析元数据版本，没有版本标签时为0，有多个时取最大值
+func ParseMetadataVersion(tags string) (int, error) {
+	version := 0
+	for _, tag := range strings.Split(tags, ",") {
+		if !strings.HasPrefix(tag, MetadataTagPrefix) {
+			continue
+		}
+		v, err := strconv.Atoi(strings.TrimPrefix(tag, MetadataTagPrefix))
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid metadata version tag %s", tag)
+		}
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
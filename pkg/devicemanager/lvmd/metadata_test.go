@@ -0,0 +1,42 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetadataVersion(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("carina.storage.io/metadata-version=1", MetadataTag(1))
+
+	for tags, version := range map[string]int{
+		"":       0,
+		"backup": 0,
+		"backup,carina.storage.io/metadata-version=1":                               1,
+		"carina.storage.io/metadata-version=1,carina.storage.io/metadata-version=3": 3,
+	} {
+		v, err := ParseMetadataVersion(tags)
+		a.NoError(err)
+		a.Equal(version, v, tags)
+	}
+
+	_, err := ParseMetadataVersion("carina.storage.io/metadata-version=x")
+	a.Error(err)
+}
@@ -17,56 +17,86 @@
 package lvmd
 
 import (
-	"github.com/carina-io/carina/api"
-	"github.com/carina-io/carina/pkg/devicemanager/types"
-	"github.com/carina-io/carina/utils/log"
+	"encoding/json"
+	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/carina-io/carina/api"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
 )
 
+// lvmRow 报告中的一行，字段名为不带LVM2_前缀的小写名称，e.g. vg_name
+type lvmRow map[string]string
+
+// lvmField 一个--nameprefixes输出的字段，值中可能含有逗号，例如多个pv_tags
+var lvmField = regexp.MustCompile(`LVM2_[A-Z_]+='[^']*'`)
+
+// parseNamePrefixes 解析--nameprefixes输出，字段按名称匹配，不按分隔符切分
+func parseNamePrefixes(out string) []lvmRow {
+	rows := []lvmRow{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := lvmField.FindAllString(line, -1)
+		if len(fields) == 0 {
+			continue
+		}
+		row := lvmRow{}
+		for _, f := range fields {
+			i := strings.Index(f, "=")
+			row[strings.ToLower(strings.TrimPrefix(f[:i], "LVM2_"))] = strings.Trim(f[i+1:], "'")
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// parseJSONReport 解析--reportformat json输出中section的各行
+// {"report": [{"vg": [{"vg_name":"v1", "pv_count":"1", ...}]}]}
+func parseJSONReport(out, section string) ([]lvmRow, error) {
+	// 部分版本在报告前输出提示信息
+	if i := strings.Index(out, "{"); i > 0 {
+		out = out[i:]
+	}
+	report := struct {
+		Report []map[string][]lvmRow `json:"report"`
+	}{}
+	// 只解析第一个json，忽略其后的输出
+	if err := json.NewDecoder(strings.NewReader(out)).Decode(&report); err != nil {
+		return nil, fmt.Errorf("invalid lvm json report: %v", err)
+	}
+	rows := []lvmRow{}
+	for _, r := range report.Report {
+		rows = append(rows, r[section]...)
+	}
+	return rows, nil
+}
+
+func (r lvmRow) uint(field string) uint64 {
+	v, _ := strconv.ParseUint(r[field], 10, 64)
+	return v
+}
+
 func parseVgs(vgsString string) []api.VgGroup {
 	// LVM2_VG_NAME='lvmvg',LVM2_PV_COUNT='1',LVM2_LV_COUNT='0',LVM2_SNAP_COUNT='0',LVM2_VG_ATTR='wz--n-',LVM2_VG_SIZE='16101933056',LVM2_VG_FREE='16101933056'
-	// LVM2_VG_NAME='v1',LVM2_PV_COUNT='2',LVM2_LV_COUNT='0',LVM2_SNAP_COUNT='0',LVM2_VG_ATTR='wz--n-',LVM2_VG_SIZE='32203866112',LVM2_VG_FREE='32203866112'
 	// LVM2_VG_NAME='v1',LVM2_PV_NAME='/dev/loop2',LVM2_PV_COUNT='1',LVM2_LV_COUNT='0',LVM2_SNAP_COUNT='0',LVM2_VG_ATTR='wz--n-',LVM2_VG_SIZE='16101933056',LVM2_VG_FREE='16101933056'
-	resp := []api.VgGroup{}
-
-	if vgsString == "" {
-		return resp
-	}
+	return vgsFromRows(parseNamePrefixes(vgsString))
+}
 
-	vgsString = strings.ReplaceAll(vgsString, "'", "")
-	vgsString = strings.ReplaceAll(vgsString, " ", "")
-
-	vgsList := strings.Split(vgsString, "\n")
-	for _, vgs := range vgsList {
-		tmp := api.VgGroup{}
-		vg := strings.Split(vgs, ",")
-		for _, v := range vg {
-			k := strings.Split(v, "=")
-
-			switch k[0] {
-			case "LVM2_VG_NAME":
-				tmp.VGName = k[1]
-			case "LVM2_PV_NAME":
-				tmp.PVName = k[1]
-			case "LVM2_PV_COUNT":
-				tmp.PVCount, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_LV_COUNT":
-				tmp.LVCount, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_SNAP_COUNT":
-				tmp.SnapCount, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_VG_ATTR":
-				tmp.VGAttr = k[1]
-			case "LVM2_VG_SIZE":
-				tmp.VGSize, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_VG_FREE":
-				tmp.VGFree, _ = strconv.ParseUint(k[1], 10, 64)
-			default:
-				log.Warnf("undefined filed %s-%s", k[0], k[1])
-			}
-		}
-		tmp.PVS = []*api.PVInfo{}
-		resp = append(resp, tmp)
+func vgsFromRows(rows []lvmRow) []api.VgGroup {
+	resp := []api.VgGroup{}
+	for _, r := range rows {
+		resp = append(resp, api.VgGroup{
+			VGName:    r["vg_name"],
+			PVName:    r["pv_name"],
+			PVCount:   r.uint("pv_count"),
+			LVCount:   r.uint("lv_count"),
+			SnapCount: r.uint("snap_count"),
+			VGAttr:    r["vg_attr"],
+			VGSize:    r.uint("vg_size"),
+			VGFree:    r.uint("vg_free"),
+			PVS:       []*api.PVInfo{},
+		})
 	}
 	return resp
 }
@@ -75,57 +105,30 @@ func parseLvs(lvsString string) []types.LvInfo {
 	// LVM2_LV_NAME='t1',LVM2_LV_PATH='/dev/v1/t1',LVM2_LV_SIZE='1073741824',LVM2_LV_KERNEL_MAJOR='252',LVM2_LV_KERNEL_MINOR='0',LVM2_ORIGIN='',LVM2_ORIGIN_SIZE='',LVM2_POOL_LV='',LVM2_THIN_COUNT='',LVM2_LV_TAGS='t1'
 	// LVM2_LV_NAME='t5',LVM2_LV_PATH='',LVM2_LV_SIZE='6979321856',LVM2_LV_KERNEL_MAJOR='252',LVM2_LV_KERNEL_MINOR='3',LVM2_ORIGIN='',LVM2_ORIGIN_SIZE='',LVM2_POOL_LV='',LVM2_THIN_COUNT='1',LVM2_LV_TAGS=''
 	// LVM2_LV_NAME='m2',LVM2_LV_PATH='/dev/v1/m2',LVM2_LV_SIZE='2147483648',LVM2_LV_KERNEL_MAJOR='252',LVM2_LV_KERNEL_MINOR='5',LVM2_ORIGIN='',LVM2_ORIGIN_SIZE='',LVM2_POOL_LV='t5',LVM2_THIN_COUNT='',LVM2_LV_TAGS=''
+	return lvsFromRows(parseNamePrefixes(lvsString))
+}
 
+// lvsFromRows 只返回carina创建的卷、thin pool和快照
+func lvsFromRows(rows []lvmRow) []types.LvInfo {
 	resp := []types.LvInfo{}
-	if lvsString == "" {
-		return resp
-	}
-
-	lvsString = strings.ReplaceAll(lvsString, "'", "")
-	lvsString = strings.ReplaceAll(lvsString, " ", "")
-
-	lvsList := strings.Split(lvsString, "\n")
-	for _, lvs := range lvsList {
-		tmp := types.LvInfo{}
-		lv := strings.Split(lvs, ",")
-		for _, v := range lv {
-			k := strings.Split(v, "=")
-
-			switch k[0] {
-			case "LVM2_LV_NAME":
-				tmp.LVName = k[1]
-			case "LVM2_VG_NAME":
-				tmp.VGName = k[1]
-			case "LVM2_LV_PATH":
-				tmp.LVPath = k[1]
-			case "LVM2_LV_SIZE":
-				tmp.LVSize, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_LV_KERNEL_MAJOR":
-				t, _ := strconv.ParseUint(k[1], 10, 32)
-				tmp.LVKernelMajor = uint32(t)
-			case "LVM2_LV_KERNEL_MINOR":
-				t, _ := strconv.ParseUint(k[1], 10, 32)
-				tmp.LVKernelMinor = uint32(t)
-			case "LVM2_ORIGIN":
-				tmp.Origin = k[1]
-			case "LVM2_ORIGIN_SIZE":
-				tmp.OriginSize, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_POOL_LV":
-				tmp.PoolLV = k[1]
-			case "LVM2_THIN_COUNT":
-				tmp.ThinCount, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_LV_TAGS":
-				tmp.LVTags = k[1]
-			case "LVM2_DATA_PERCENT":
-				tmp.DataPercent, _ = strconv.ParseFloat(k[1], 64)
-			case "LVM2_LV_ATTR":
-				tmp.LVAttr = k[1]
-			case "LVM2_LV_ACTIVE":
-				tmp.LVActive = k[1]
-			default:
-				log.Warnf("undefined field %s=%s", k[0], k[1])
-			}
+	for _, r := range rows {
+		tmp := types.LvInfo{
+			LVName:        r["lv_name"],
+			VGName:        r["vg_name"],
+			LVPath:        r["lv_path"],
+			LVSize:        r.uint("lv_size"),
+			LVKernelMajor: uint32(r.uint("lv_kernel_major")),
+			LVKernelMinor: uint32(r.uint("lv_kernel_minor")),
+			Origin:        r["origin"],
+			OriginSize:    r.uint("origin_size"),
+			PoolLV:        r["pool_lv"],
+			ThinCount:     r.uint("thin_count"),
+			LVTags:        r["lv_tags"],
+			LVAttr:        r["lv_attr"],
+			LVActive:      r["lv_active"],
 		}
+		// 部分语言环境下小数点为逗号
+		tmp.DataPercent, _ = strconv.ParseFloat(strings.Replace(r["data_percent"], ",", ".", 1), 64)
 		if strings.HasPrefix(tmp.LVName, "volume") || strings.HasPrefix(tmp.LVName, "thin") || strings.HasPrefix(tmp.LVName, "snap") {
 			resp = append(resp, tmp)
 		}
@@ -134,41 +137,41 @@ func parseLvs(lvsString string) []types.LvInfo {
 }
 
 func parsePvs(pvsString string) []api.PVInfo {
-	// LVM2_PV_NAME='/dev/loop2',LVM2_VG_NAME='lvmvg',LVM2_PV_FMT='lvm2',LVM2_PV_ATTR='a--',LVM2_PV_SIZE='16101933056',LVM2_PV_FREE='16101933056'
-	resp := []api.PVInfo{}
+	// LVM2_PV_NAME='/dev/loop2',LVM2_VG_NAME='lvmvg',LVM2_PV_FMT='lvm2',LVM2_PV_ATTR='a--',LVM2_PV_SIZE='16101933056',LVM2_PV_FREE='16101933056',LVM2_PV_TAGS='a,b'
+	return pvsFromRows(parseNamePrefixes(pvsString))
+}
 
-	if pvsString == "" {
-		return resp
+func pvsFromRows(rows []lvmRow) []api.PVInfo {
+	resp := []api.PVInfo{}
+	for _, r := range rows {
+		resp = append(resp, api.PVInfo{
+			PVName: r["pv_name"],
+			VGName: r["vg_name"],
+			PVFmt:  r["pv_fmt"],
+			PVAttr: r["pv_attr"],
+			PVSize: r.uint("pv_size"),
+			PVFree: r.uint("pv_free"),
+			PVTags: r["pv_tags"],
+		})
 	}
+	return resp
+}
 
-	pvsString = strings.ReplaceAll(pvsString, "'", "")
-	pvsString = strings.ReplaceAll(pvsString, " ", "")
-
-	pvsList := strings.Split(pvsString, "\n")
-	for _, pvs := range pvsList {
-		tmp := api.PVInfo{}
-		pv := strings.Split(pvs, ",")
-		for _, v := range pv {
-			k := strings.Split(v, "=")
-
-			switch k[0] {
-			case "LVM2_PV_NAME":
-				tmp.PVName = k[1]
-			case "LVM2_VG_NAME":
-				tmp.VGName = k[1]
-			case "LVM2_PV_FMT":
-				tmp.PVFmt = k[1]
-			case "LVM2_PV_ATTR":
-				tmp.PVAttr = k[1]
-			case "LVM2_PV_SIZE":
-				tmp.PVSize, _ = strconv.ParseUint(k[1], 10, 64)
-			case "LVM2_PV_FREE":
-				tmp.PVFree, _ = strconv.ParseUint(k[1], 10, 64)
-			default:
-				log.Warnf("undefined field %s-%s", k[0], k[1])
-			}
-		}
-		resp = append(resp, tmp)
+func parsePvSegments(segsString string) []types.PVSegment {
+	// LVM2_PV_NAME='/dev/loop2',LVM2_VG_NAME='v1',LVM2_LV_NAME='[thin-pvc-1_tdata]',LVM2_SEG_SIZE='2147483648'
+	return pvSegmentsFromRows(parseNamePrefixes(segsString))
+}
+
+func pvSegmentsFromRows(rows []lvmRow) []types.PVSegment {
+	resp := []types.PVSegment{}
+	for _, r := range rows {
+		resp = append(resp, types.PVSegment{
+			PVName: r["pv_name"],
+			VGName: r["vg_name"],
+			// 隐藏的lv带有中括号，如thin pool的数据卷[thin-pvc-1_tdata]
+			LVName:  strings.Trim(r["lv_name"], "[]"),
+			SegSize: r.uint("seg_size"),
+		})
 	}
 	return resp
 }
@@ -0,0 +1,95 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lvmd
+
+import (
+	"testing"
+
+	"github.com/carina-io/carina/api"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePvs(t *testing.T) {
+	a := assert.New(t)
+	out := `  LVM2_PV_NAME='/dev/loop2',LVM2_VG_NAME='carina-vg-ssd',LVM2_PV_FMT='lvm2',LVM2_PV_ATTR='a--',LVM2_PV_SIZE='16101933056',LVM2_PV_FREE='16101933056',LVM2_PV_TAGS='backup,carina.storage.io/profile=seqread-520:randread-85000:randwrite-60000:latency-180'
+  LVM2_PV_NAME='/dev/loop3',LVM2_VG_NAME='',LVM2_PV_FMT='lvm2',LVM2_PV_ATTR='---',LVM2_PV_SIZE='1073741824',LVM2_PV_FREE='1073741824',LVM2_PV_TAGS=''
+`
+	a.Equal([]api.PVInfo{
+		{PVName: "/dev/loop2", VGName: "carina-vg-ssd", PVFmt: "lvm2", PVAttr: "a--", PVSize: 16101933056, PVFree: 16101933056,
+			PVTags: "backup,carina.storage.io/profile=seqread-520:randread-85000:randwrite-60000:latency-180"},
+		{PVName: "/dev/loop3", PVFmt: "lvm2", PVAttr: "---", PVSize: 1073741824, PVFree: 1073741824},
+	}, parsePvs(out))
+	a.Empty(parsePvs(""))
+}
+
+func TestParseReport(t *testing.T) {
+	a := assert.New(t)
+	lvsJSON := `  {
+      "report": [
+          {
+              "lv": [
+                  {"lv_name":"thin-pvc-1", "vg_name":"carina-vg-ssd", "lv_path":"", "lv_size":"10737418240", "data_percent":"12.50", "lv_attr":"twi-aotz--", "lv_kernel_major":"253", "lv_kernel_minor":"2", "origin":"", "origin_size":"", "pool_lv":"", "thin_count":"1", "lv_tags":"", "lv_active":"active"},
+                  {"lv_name":"volume-pvc-1", "vg_name":"carina-vg-ssd", "lv_path":"/dev/carina-vg-ssd/volume-pvc-1", "lv_size":"10737418240", "data_percent":"0.00", "lv_attr":"Vwi-aotz--", "lv_kernel_major":"253", "lv_kernel_minor":"3", "origin":"", "origin_size":"", "pool_lv":"thin-pvc-1", "thin_count":"", "lv_tags":"carina.storage.io/owner=pvc-1,backup", "lv_active":"active"},
+                  {"lv_name":"root", "vg_name":"centos", "lv_path":"/dev/centos/root", "lv_size":"53687091200", "data_percent":"", "lv_attr":"-wi-ao----", "lv_kernel_major":"253", "lv_kernel_minor":"0", "origin":"", "origin_size":"", "pool_lv":"", "thin_count":"", "lv_tags":"", "lv_active":"active"}
+              ]
+          }
+      ]
+  }
+`
+	rows, err := parseJSONReport(lvsJSON, "lv")
+	a.NoError(err)
+	lvs := lvsFromRows(rows)
+	a.Len(lvs, 2)
+	a.Equal(12.5, lvs[0].DataPercent)
+	a.Equal(uint32(2), lvs[0].LVKernelMinor)
+	a.Equal("thin-pvc-1", lvs[1].PoolLV)
+	a.Equal("carina.storage.io/owner=pvc-1,backup", lvs[1].LVTags)
+
+	// --nameprefixes输出中带逗号的标签不再被切开
+	lvsText := `  LVM2_LV_NAME='volume-pvc-1',LVM2_VG_NAME='carina-vg-ssd',LVM2_LV_PATH='/dev/carina-vg-ssd/volume-pvc-1',LVM2_LV_SIZE='10737418240',LVM2_DATA_PERCENT='0,00',LVM2_LV_ATTR='Vwi-aotz--',LVM2_LV_KERNEL_MAJOR='253',LVM2_LV_KERNEL_MINOR='3',LVM2_ORIGIN='',LVM2_ORIGIN_SIZE='',LVM2_POOL_LV='thin-pvc-1',LVM2_THIN_COUNT='',LVM2_LV_TAGS='carina.storage.io/owner=pvc-1,backup',LVM2_LV_ACTIVE='active'
+`
+	a.Equal(lvs[1], parseLvs(lvsText)[0])
+
+	vgsJSON := `{"report": [{"vg": [{"vg_name":"carina-vg-ssd", "pv_name":"/dev/loop2", "pv_count":"2", "lv_count":"3", "snap_count":"0", "vg_attr":"wz--n-", "vg_size":"32203866112", "vg_free":"21466447872"},
+	{"vg_name":"carina-vg-ssd", "pv_name":"/dev/loop3", "pv_count":"2", "lv_count":"3", "snap_count":"0", "vg_attr":"wz--n-", "vg_size":"32203866112", "vg_free":"21466447872"}]}]}`
+	rows, err = parseJSONReport(vgsJSON, "vg")
+	a.NoError(err)
+	vgs := vgsFromRows(rows)
+	a.Equal(vgs, parseVgs(`  LVM2_VG_NAME='carina-vg-ssd',LVM2_PV_NAME='/dev/loop2',LVM2_PV_COUNT='2',LVM2_LV_COUNT='3',LVM2_SNAP_COUNT='0',LVM2_VG_ATTR='wz--n-',LVM2_VG_SIZE='32203866112',LVM2_VG_FREE='21466447872'
+  LVM2_VG_NAME='carina-vg-ssd',LVM2_PV_NAME='/dev/loop3',LVM2_PV_COUNT='2',LVM2_LV_COUNT='3',LVM2_SNAP_COUNT='0',LVM2_VG_ATTR='wz--n-',LVM2_VG_SIZE='32203866112',LVM2_VG_FREE='21466447872'
+`))
+	a.Equal(uint64(21466447872), vgs[1].VGFree)
+	a.Equal("/dev/loop3", vgs[1].PVName)
+
+	// 报告之后的提示信息不影响解析
+	rows, err = parseJSONReport(vgsJSON+"\n  WARNING: PV /dev/loop3 in VG carina-vg-ssd is using an old PV header, modify the VG to update.\n", "vg")
+	a.NoError(err)
+	a.Equal(vgs, vgsFromRows(rows))
+
+	segsJSON := `{"report": [{"pvseg": [{"pv_name":"/dev/loop2", "vg_name":"carina-vg-ssd", "lv_name":"[thin-pvc-1_tdata]", "seg_size":"10737418240"},
+	{"pv_name":"/dev/loop2", "vg_name":"carina-vg-ssd", "lv_name":"", "seg_size":"5364514816"}]}]}`
+	rows, err = parseJSONReport(segsJSON, "pvseg")
+	a.NoError(err)
+	a.Equal([]types.PVSegment{
+		{PVName: "/dev/loop2", VGName: "carina-vg-ssd", LVName: "thin-pvc-1_tdata", SegSize: 10737418240},
+		{PVName: "/dev/loop2", VGName: "carina-vg-ssd", SegSize: 5364514816},
+	}, pvSegmentsFromRows(rows))
+
+	_, err = parseJSONReport("lvs: unrecognized option '--reportformat'", "lv")
+	a.Error(err)
+}
@@ -0,0 +1,356 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/lvmd"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/carina-io/carina/utils/mutx"
+)
+
+const (
+	// containerLvmConf carina-node容器内lvm使用的配置
+	containerLvmConf = "/etc/lvm/lvm.conf"
+	// hostLvmConf 主机的/etc/lvm挂载到容器的/host/etc/lvm
+	hostLvmConf     = "/host/etc/lvm/lvm.conf"
+	hostDevicesFile = "/host/etc/lvm/devices/system.devices"
+	diskByID        = "/dev/disk/by-id"
+	// lvmFilterCheckInterval 检查过滤规则是否被修改的间隔
+	lvmFilterCheckInterval = 60 * time.Second
+)
+
+// LvmFilterTask 定时同步lvm.conf中carina管理的global_filter
+func (dm *DeviceManager) LvmFilterTask() {
+	ticker := time.NewTicker(lvmFilterCheckInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = dm.Pool.Run(context.Background(), mutx.PriorityBackground, "lvm filter", func() error {
+					dm.SyncLvmFilter()
+					return nil
+				})
+			case <-dm.stopChan:
+				log.Info("stop lvm filter task...")
+				return
+			}
+		}
+	}()
+}
+
+// SyncLvmFilter keeps lvm inside carina-node limited to the carina devices and keeps
+// the host lvm away from them. The host lvm.conf is only touched when /etc/lvm of the
+// host is mounted. With use_devicesfile the carina pvs are removed from the devices
+// file instead, raw disks are never written by lvm and are left alone.
+func (dm *DeviceManager) SyncLvmFilter() {
+	if !configuration.LvmFilter() {
+		// 关闭后移除之前写入的管理块
+		dm.syncFilterFile(containerLvmConf, "")
+		if _, err := os.Stat(hostLvmConf); err == nil {
+			dm.syncFilterFile(hostLvmConf, "")
+		}
+		return
+	}
+
+	diskClass := dm.GetNodeDiskSelectGroup()
+	if diskClass == nil {
+		return
+	}
+	vgs, err := dm.VolumeManager.GetCurrentVgStruct()
+	if err != nil {
+		log.Errorf("get current vg struct failed: %s", err.Error())
+		return
+	}
+
+	accept := []string{}
+	for _, item := range diskClass {
+		if strings.ToLower(item.Policy) == "raw" {
+			continue
+		}
+		accept = append(accept, item.Re...)
+	}
+	// 只按wwn、序列号或型号选择磁盘的磁盘组没有设备名正则，接受当前匹配的磁盘
+	for _, disk := range dm.attributeMatchedDisks(diskClass) {
+		accept = append(accept, lvmd.DevicePattern(disk))
+	}
+	for group, loops := range dm.LoopDevices() {
+		if _, ok := diskClass[group]; !ok {
+			continue
+		}
+		for _, loop := range loops {
+			accept = append(accept, lvmd.DevicePattern(loop))
+		}
+	}
+	pvs := []string{}
+	for _, vg := range vgs {
+		item, ok := diskClass[vg.VGName]
+		if !ok || strings.ToLower(item.Policy) == "raw" {
+			continue
+		}
+		for _, pv := range vg.PVS {
+			if pv.PVName == "" || pv.PVName == "unknown" {
+				continue
+			}
+			pvs = append(pvs, pv.PVName)
+			accept = append(accept, lvmd.DevicePattern(pv.PVName))
+		}
+	}
+	sort.Strings(pvs)
+	dm.syncFilterFile(containerLvmConf, lvmd.AcceptOnlyFilter(accept))
+
+	if _, err := os.Stat(hostLvmConf); err != nil {
+		return
+	}
+	conf, err := os.ReadFile(hostLvmConf)
+	if err != nil {
+		log.Warnf("read %s failed: %s", hostLvmConf, err.Error())
+		return
+	}
+	if lvmd.UsesDevicesFile(string(conf)) {
+		dm.syncDevicesFile(pvs)
+		return
+	}
+
+	// 内核设备名重启后可能变化，主机上按/dev/disk/by-id下的名称拒绝
+	reject := []string{}
+	unstable := []string{}
+	for _, pv := range pvs {
+		aliases := stableNames(pv)
+		if len(aliases) == 0 {
+			unstable = append(unstable, pv)
+			continue
+		}
+		for _, alias := range aliases {
+			reject = append(reject, lvmd.DevicePattern(alias))
+		}
+	}
+	dm.syncFilterFile(hostLvmConf, lvmd.RejectFilter(reject))
+	if len(unstable) > 0 {
+		dm.setFilterMessage(hostLvmConf, fmt.Sprintf("no stable name under %s for %s, not filtered", diskByID, strings.Join(unstable, ", ")))
+	}
+}
+
+// attributeMatchedDisks 返回没有设备名正则的磁盘组匹配的本地磁盘
+func (dm *DeviceManager) attributeMatchedDisks(diskClass map[string]configuration.DiskSelectorItem) []string {
+	matchers := []*diskMatcher{}
+	for _, item := range diskClass {
+		if strings.ToLower(item.Policy) == "raw" || len(item.Re) > 0 || !item.Selects() {
+			continue
+		}
+		m, err := newDiskMatcher(item)
+		if err != nil {
+			log.Warnf("disk group %s: %v", item.Name, err)
+			continue
+		}
+		matchers = append(matchers, m)
+	}
+	if len(matchers) == 0 {
+		return nil
+	}
+	localDisk, err := dm.DiskManager.ListDevicesDetail("")
+	if err != nil {
+		log.Warnf("get local disk failed: %s", err.Error())
+		return nil
+	}
+	disks := []string{}
+	for _, d := range localDisk {
+		for _, m := range matchers {
+			if m.Match(d) {
+				disks = append(disks, d.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(disks)
+	return disks
+}
+
+// syncFilterFile 写入global_filter，管理块与上次写入的不同时记为漂移
+func (dm *DeviceManager) syncFilterFile(path, filter string) {
+	dm.filterMutex.Lock()
+	defer dm.filterMutex.Unlock()
+
+	st, ok := dm.lvmFilter[path]
+	if !ok {
+		st = &lvmd.FilterStatus{Path: path}
+		dm.lvmFilter[path] = st
+	}
+	st.Filter = filter
+	st.Message = ""
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		st.InSync = false
+		st.Message = err.Error()
+		if filter != "" {
+			log.Warnf("read %s failed: %s", path, err.Error())
+		}
+		return
+	}
+	conf := string(content)
+	current, managed := lvmd.ManagedFilter(conf)
+	if written, ok := dm.filterWritten[path]; ok && (!managed || current != written) {
+		st.Drifts++
+		st.LastDrift = time.Now()
+		log.Warnf("carina managed global_filter of %s was modified, restore it", path)
+	}
+
+	updated, changed, err := lvmd.SetGlobalFilter(conf, filter)
+	if errors.Is(err, lvmd.ErrFilterConflict) {
+		st.InSync = false
+		if filter != "" {
+			st.Message = err.Error()
+			log.Warnf("skip %s: %s", path, err.Error())
+		}
+		return
+	}
+	if changed {
+		if err := writeLvmConf(path, updated); err != nil {
+			st.InSync = false
+			st.Message = err.Error()
+			log.Errorf("write %s failed: %s", path, err.Error())
+			return
+		}
+		log.Infof("update carina managed global_filter of %s: %s", path, filter)
+	}
+	if filter == "" {
+		delete(dm.filterWritten, path)
+	} else {
+		dm.filterWritten[path] = filter
+	}
+	st.InSync = true
+}
+
+// syncDevicesFile 从主机的devices file中删除carina的pv
+func (dm *DeviceManager) syncDevicesFile(pvs []string) {
+	dm.filterMutex.Lock()
+	defer dm.filterMutex.Unlock()
+
+	st, ok := dm.lvmFilter[hostDevicesFile]
+	if !ok {
+		st = &lvmd.FilterStatus{Path: hostDevicesFile}
+		dm.lvmFilter[hostDevicesFile] = st
+	}
+	st.Filter = ""
+	st.Message = ""
+
+	content, err := os.ReadFile(hostDevicesFile)
+	if os.IsNotExist(err) {
+		// 没有devices file时主机lvm不扫描任何设备
+		st.InSync = true
+		return
+	}
+	if err != nil {
+		st.InSync = false
+		st.Message = err.Error()
+		log.Warnf("read %s failed: %s", hostDevicesFile, err.Error())
+		return
+	}
+	kept, removed := lvmd.RemoveDevicesFileEntries(string(content), pvs)
+	if len(removed) > 0 {
+		if err := writeLvmConf(hostDevicesFile, kept); err != nil {
+			st.InSync = false
+			st.Message = err.Error()
+			log.Errorf("write %s failed: %s", hostDevicesFile, err.Error())
+			return
+		}
+		if _, ok := dm.filterWritten[hostDevicesFile]; ok {
+			st.Drifts++
+			st.LastDrift = time.Now()
+		}
+		log.Warnf("remove carina pvs %v from %s", removed, hostDevicesFile)
+	}
+	dm.filterWritten[hostDevicesFile] = strings.Join(pvs, ",")
+	st.InSync = true
+}
+
+func (dm *DeviceManager) setFilterMessage(path, message string) {
+	dm.filterMutex.Lock()
+	defer dm.filterMutex.Unlock()
+	if st, ok := dm.lvmFilter[path]; ok {
+		st.Message = message
+	}
+}
+
+// LvmFilterStatus 返回各lvm配置文件的同步状态
+func (dm *DeviceManager) LvmFilterStatus() []lvmd.FilterStatus {
+	dm.filterMutex.Lock()
+	defer dm.filterMutex.Unlock()
+	result := []lvmd.FilterStatus{}
+	for _, st := range dm.lvmFilter {
+		result = append(result, *st)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// writeLvmConf 第一次修改前保留原文件，写临时文件后rename，lvm不会读到写了一半的配置
+func writeLvmConf(path, content string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	backup := path + ".carina.bak"
+	if _, err := os.Stat(backup); os.IsNotExist(err) {
+		original, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(backup, original, info.Mode()); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".carina.tmp"
+	if err := os.WriteFile(tmp, []byte(content), info.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// stableNames 返回/dev/disk/by-id下指向该设备的名称
+func stableNames(device string) []string {
+	target, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return nil
+	}
+	entries, err := os.ReadDir(diskByID)
+	if err != nil {
+		return nil
+	}
+	names := []string{}
+	for _, e := range entries {
+		link := filepath.Join(diskByID, e.Name())
+		resolved, err := filepath.EvalSymlinks(link)
+		if err != nil || resolved != target {
+			continue
+		}
+		names = append(names, link)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -18,17 +18,21 @@ package deviceManager
 
 import (
 	"context"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/carina-io/carina/api"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
 
 	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/datamover"
 	"github.com/carina-io/carina/pkg/devicemanager/bcache"
 	"github.com/carina-io/carina/pkg/devicemanager/device"
+	"github.com/carina-io/carina/pkg/devicemanager/drbd"
 	"github.com/carina-io/carina/pkg/devicemanager/lvmd"
 	"github.com/carina-io/carina/pkg/devicemanager/partition"
+	"github.com/carina-io/carina/pkg/devicemanager/tools"
 	"github.com/carina-io/carina/pkg/devicemanager/troubleshoot"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 	"github.com/carina-io/carina/pkg/devicemanager/volume"
@@ -41,6 +45,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// benchmarkDuration 磁盘测试每一项的时长
+const benchmarkDuration = 5 * time.Second
+
 type DeviceManager struct {
 	Cache cache.Cache
 	// The implementation of executing a console command
@@ -55,15 +62,38 @@ type DeviceManager struct {
 	VolumeManager volume.LocalVolume
 	// bcache
 	Bcache bcache.Bcache
+	// 多副本卷的drbd资源
+	Drbd drbd.Drbd
 	// stop
 	stopChan <-chan struct{}
 	nodeName string
 	// 本地设备一致性检查
-	trouble *troubleshoot.Trouble
+	Trouble *troubleshoot.Trouble
 	// 配置变更即触发搜索本地磁盘逻辑
 	configModifyChan chan struct{}
 	//磁盘分区
 	Partition partition.LocalPartition
+	// 按优先级调度节点上的存储操作
+	Pool *mutx.PriorityPool
+	// 数据搬迁任务的维护窗口及带宽限制，由NodeStorageResource配置
+	Throttle *datamover.Throttle
+	// lvm.conf过滤规则的同步状态，filterWritten记录上次写入的管理块
+	filterMutex   sync.Mutex
+	lvmFilter     map[string]*lvmd.FilterStatus
+	filterWritten map[string]string
+	// lastFstrim 各卷上次执行fstrim的时间，只由fstrim任务访问
+	lastFstrim map[string]time.Time
+	// loopDevices 各磁盘组中由稀疏文件挂载的loop设备
+	loopMutex   sync.Mutex
+	loopDevices map[string][]string
+	// 热备盘及替换记录，spareHandled记录各磁盘组已替换的丢失pv数量
+	spareMutex        sync.Mutex
+	hotSpares         []carinav1beta1.HotSpare
+	spareReplacements []carinav1beta1.SpareReplacement
+	spareHandled      map[string]int
+	// 开启diskApproval时等待批准加入vg的磁盘
+	pendingMutex sync.Mutex
+	pendingDisks []carinav1beta1.PendingDisk
 }
 
 func NewDeviceManager(nodeName string, cache cache.Cache, stopChan <-chan struct{}) *DeviceManager {
@@ -76,15 +106,23 @@ func NewDeviceManager(nodeName string, cache cache.Cache, stopChan <-chan struct
 		Mutex:            mutex,
 		DiskManager:      &device.LocalDeviceImplement{Executor: executor},
 		LvmManager:       &lvmd.Lvm2Implement{Executor: executor},
-		VolumeManager:    &volume.LocalVolumeImplement{Mutex: mutex, Lv: &lvmd.Lvm2Implement{Executor: executor}, Bcache: &bcache.BcacheImplement{Executor: executor}, NoticeServerMap: make(map[string]chan struct{})},
+		VolumeManager:    &volume.LocalVolumeImplement{Mutex: mutex, Lv: &lvmd.Lvm2Implement{Executor: executor}, Bcache: &bcache.BcacheImplement{Executor: executor}, Executor: executor, NoticeServerMap: make(map[string]chan struct{})},
 		Bcache:           &bcache.BcacheImplement{Executor: executor},
+		Drbd:             &drbd.DrbdImplement{Executor: executor},
 		stopChan:         stopChan,
 		nodeName:         nodeName,
-		trouble:          &troubleshoot.Trouble{},
+		Trouble:          &troubleshoot.Trouble{},
 		configModifyChan: make(chan struct{}),
 		Partition:        &partition.LocalPartitionImplement{Mutex: mutex, CacheParttionNum: make(map[string]uint), Executor: executor},
+		Pool:             mutx.NewPriorityPool(configuration.OperationWorkers()),
+		Throttle:         datamover.NewThrottle(),
+		lvmFilter:        map[string]*lvmd.FilterStatus{},
+		filterWritten:    map[string]string{},
+		lastFstrim:       map[string]time.Time{},
+		loopDevices:      map[string][]string{},
+		spareHandled:     map[string]int{},
 	}
-	dm.trouble = troubleshoot.NewTroubleObject(dm.VolumeManager, dm.Partition, cache, nodeName)
+	dm.Trouble = troubleshoot.NewTroubleObject(dm.VolumeManager, dm.Partition, cache, nodeName)
 	// 注册监听配置变更
 	dm.configModifyChan = make(chan struct{}, 1)
 	configuration.RegisterListenerChan(dm.configModifyChan)
@@ -114,7 +152,26 @@ func (dm *DeviceManager) GetNodeDiskSelectGroup() map[string]configuration.DiskS
 
 // AddAndRemoveDevice 定时巡检磁盘，是否有新磁盘加入
 func (dm *DeviceManager) AddAndRemoveDevice() {
+	if err := dm.Pool.Acquire(context.Background(), mutx.PriorityBackground, "device scan"); err != nil {
+		log.Warnf("skip device scan: %s", err.Error())
+		return
+	}
+	defer dm.Pool.Release(mutx.PriorityBackground)
+
+	// 缺少lvm2或磁盘工具时不扫描，避免每次扫描都报命令执行失败，节点状态见NodeStorageResource的ToolsMissing
+	for _, feature := range []string{tools.FeatureLvm, tools.FeatureDisk} {
+		if err := tools.Check(feature); err != nil {
+			log.Errorf("skip device scan: %s", err.Error())
+			return
+		}
+	}
+
 	diskClass := dm.GetNodeDiskSelectGroup()
+	loops, attached := dm.AttachLoopDevices(diskClass)
+	if attached || attributeOnly(diskClass) {
+		// lvm过滤规则接受新挂载的loop设备和按wwn等条件匹配的磁盘后才能创建pv
+		dm.SyncLvmFilter()
+	}
 	ActuallyVg, err := dm.VolumeManager.GetCurrentVgStruct()
 	if err != nil {
 		log.Error("get current vg struct failed: " + err.Error())
@@ -134,6 +191,41 @@ func (dm *DeviceManager) AddAndRemoveDevice() {
 		return
 	}
 	log.Debug("newPv: ", newPv)
+	spares, err := dm.DiscoverSpares(diskClass)
+	if err != nil {
+		log.Error("find hot spare failed: " + err.Error())
+		return
+	}
+
+	// 只有空磁盘可以测试性能，已有的pv可能存有数据
+	blankDisks := map[string]bool{}
+	for _, disks := range newDisk {
+		for _, d := range disks {
+			blankDisks[d] = true
+		}
+	}
+
+	// loop设备只加入配置的磁盘组，不论是否匹配其他磁盘组的正则
+	loopGroup := map[string]string{}
+	for group, devs := range loops {
+		for _, d := range devs {
+			loopGroup[d] = group
+		}
+	}
+	for _, found := range []map[string][]string{newDisk, newPv} {
+		for group, devs := range found {
+			kept := []string{}
+			for _, d := range devs {
+				if g, ok := loopGroup[d]; !ok || g == group {
+					kept = append(kept, d)
+				}
+			}
+			found[group] = kept
+		}
+	}
+	for group, devs := range loops {
+		newDisk[group] = utils.SliceMergeSlice(newDisk[group], devs)
+	}
 
 	// 合并新增设备
 	for key, value := range newDisk {
@@ -162,6 +254,9 @@ func (dm *DeviceManager) AddAndRemoveDevice() {
 		}
 	}
 
+	// 开启diskApproval时未批准的磁盘只报告，不加入vg
+	needAddPv = dm.holdUnapproved(needAddPv, blankDisks, loops)
+
 	// 执行新增磁盘
 	log.Debug("needAddPv ", needAddPv)
 	for vg, pvs := range needAddPv {
@@ -171,8 +266,16 @@ func (dm *DeviceManager) AddAndRemoveDevice() {
 			if v, ok := ActuallyVgMap[vg]; ok && utils.ContainsString(v, pv) {
 				continue
 			}
+			var profile *api.DiskProfile
+			if blankDisks[pv] && configuration.DiskBenchmark() {
+				profile = dm.benchmarkDisk(pv)
+			}
 			if err := dm.VolumeManager.AddNewDiskToVg(pv, vg); err != nil {
 				log.Errorf("add new disk failed vg: %s, disk: %s, error: %v", vg, pv, err)
+			} else if profile != nil {
+				if err := dm.LvmManager.PVAddTag(pv, device.ProfileTag(*profile)); err != nil {
+					log.Errorf("tag pv %s with its profile failed: %v", pv, err)
+				}
 			}
 			//同步磁盘分区表
 			if err := dm.LvmManager.PartProbe(); err != nil {
@@ -191,24 +294,50 @@ func (dm *DeviceManager) AddAndRemoveDevice() {
 		return
 	}
 
+	// 按wwn、序列号等条件匹配pv时需要磁盘详情
+	pvDisks := map[string]*types.LocalDisk{}
+	if localDisk, err := dm.DiskManager.ListDevicesDetail(""); err == nil {
+		for _, d := range localDisk {
+			pvDisks[d.Name] = d
+		}
+	} else {
+		log.Warnf("get local disk failed: %s", err.Error())
+	}
+
 	for _, v := range ActuallyVg {
 		if _, ok := diskClass[v.VGName]; !ok {
 			continue
 		}
 
-		diskSelector, err := regexp.Compile(strings.Join(diskClass[v.VGName].Re, "|"))
+		diskSelector, err := newDiskMatcher(diskClass[v.VGName])
 		if err != nil {
-			log.Warnf("disk regex %s error %v ", strings.Join(diskClass[v.VGName].Re, "|"), err)
+			log.Warnf("disk group %s: %v", v.VGName, err)
 			return
 		}
 		log.Debug("diskSelector  ", diskSelector)
+		// 丢失的pv先由热备盘替换，raid卷重建到热备盘之后才能从vg中移除
+		spares[v.VGName] = dm.replaceMissingPvs(&v, diskClass[v.VGName], spares[v.VGName])
+		spare := spareSelector(diskClass[v.VGName])
 		for _, pv := range v.PVS {
 			if strings.Contains(pv.PVName, "unknown") {
 				_ = dm.LvmManager.RemoveUnknownDevice(pv.VGName)
 				continue
 			}
-			//同一个vg里，如果正则不匹配就将磁盘移出vg
-			if !diskSelector.MatchString(pv.PVName) {
+			// 只有loop设备的磁盘组不移出磁盘
+			if !diskClass[v.VGName].Selects() || utils.ContainsString(loops[v.VGName], pv.PVName) || (spare != nil && spare.MatchString(pv.PVName)) {
+				continue
+			}
+			disk, ok := pvDisks[pv.PVName]
+			if !ok {
+				// 查不到磁盘详情时无法判断wwn等条件，不移出
+				if diskSelector.attributes {
+					log.Warnf("cannot get detail of pv %s in vg %s, keep it", pv.PVName, v.VGName)
+					continue
+				}
+				disk = &types.LocalDisk{Name: pv.PVName}
+			}
+			//同一个vg里，如果不再匹配磁盘组的条件就将磁盘移出vg，配置的loop设备及替换了丢失pv的热备盘除外
+			if !diskSelector.Match(disk) {
 				log.Infof("remove pv %s in vg %s", pv.PVName, v.VGName)
 				if err := dm.VolumeManager.RemoveDiskInVg(pv.PVName, v.VGName); err != nil {
 					log.Errorf("remove pv %s error %v", pv.PVName, err)
@@ -221,6 +350,8 @@ func (dm *DeviceManager) AddAndRemoveDevice() {
 		}
 	}
 
+	dm.setHotSpares(spares)
+
 	changeAfter, err := dm.VolumeManager.GetCurrentVgStruct()
 	if err != nil {
 		log.Error("get current vg struct failed: " + err.Error())
@@ -232,6 +363,19 @@ func (dm *DeviceManager) AddAndRemoveDevice() {
 	}
 }
 
+// benchmarkDisk 测试将加入vg的空磁盘，失败时不影响磁盘加入vg
+func (dm *DeviceManager) benchmarkDisk(disk string) *api.DiskProfile {
+	log.Infof("benchmark disk %s before adding it to a volume group", disk)
+	profile, err := device.Benchmark(disk, benchmarkDuration)
+	if err != nil {
+		log.Warnf("benchmark disk %s failed: %v", disk, err)
+		return nil
+	}
+	log.Infof("disk %s: sequential read %d MiB/s, random read %d iops, random write %d iops, latency %dus",
+		disk, profile.SeqReadMBps, profile.RandReadIOPS, profile.RandWriteIOPS, profile.LatencyMicros)
+	return &profile
+}
+
 // DiscoverDisk 查找是否有符合条件的块设备加入
 func (dm *DeviceManager) DiscoverDisk(diskClass map[string]configuration.DiskSelectorItem) (map[string][]string, error) {
 	blockClass := map[string][]string{}
@@ -259,45 +403,27 @@ func (dm *DeviceManager) DiscoverDisk(diskClass map[string]configuration.DiskSel
 			// 目前不支持raw磁盘模式
 			continue
 		}
-		diskSelector, err := regexp.Compile(strings.Join(ds.Re, "|"))
+		// 没有匹配条件的磁盘组只加入loop设备，空正则会匹配所有磁盘
+		if !ds.Selects() {
+			continue
+		}
+		diskSelector, err := newDiskMatcher(ds)
 		if err != nil {
-			log.Warnf("disk regex %s error %v ", strings.Join(ds.Re, "|"), err)
+			log.Warnf("disk group %s: %v", ds.Name, err)
 			continue
 		}
 		// 过滤出空块设备
 		for _, d := range localDisk {
-			if strings.Contains(d.Name, types.KEYWORD) {
-				continue
-			}
-			// 如果是其他磁盘Parent直接跳过
-			if _, ok := parentDisk[d.Name]; ok {
-				continue
-			}
-
-			if d.Readonly || d.Size < 10<<30 || d.Filesystem != "" || d.MountPoint != "" {
-				//		log.Infof("mismatched disk: %s filesystem:%s mountpoint:%s readonly:%t, size:%d", d.Name, d.Filesystem, d.MountPoint, d.Readonly, d.Size)
-				continue
-			}
-
-			if strings.Contains(d.Name, "cache") {
+			if !candidateDisk(d, parentDisk) {
 				continue
 			}
-
-			// 过滤不支持的磁盘类型
-			diskTypeCheck := true
-			for _, t := range []string{types.LVMType, types.CryptType, types.MultiPath, "rom"} {
-				if strings.Contains(d.Type, t) {
-					diskTypeCheck = false
-					break
-				}
-			}
-			if !diskTypeCheck {
-				log.Infof("mismatched disk:%s, disktype:%s", d.Name, d.Type)
+			// 热备盘不加入磁盘组
+			if spareDisk(diskClass, d.Name) {
 				continue
 			}
 
-			if !diskSelector.MatchString(d.Name) {
-				log.Infof("mismatched disk:%s, regex:%s", d.Name, diskSelector.String())
+			if !diskSelector.Match(d) {
+				log.Infof("mismatched disk:%s, wwn:%s, serial:%s, selector:%s", d.Name, d.Wwn, d.Serial, diskSelector.String())
 				continue
 			}
 
@@ -325,6 +451,35 @@ func (dm *DeviceManager) DiscoverDisk(diskClass map[string]configuration.DiskSel
 	return blockClass, nil
 }
 
+// candidateDisk 过滤出可以加入磁盘组的空块设备
+func candidateDisk(d *types.LocalDisk, parentDisk map[string]int8) bool {
+	if strings.Contains(d.Name, types.KEYWORD) {
+		return false
+	}
+	// 如果是其他磁盘Parent直接跳过
+	if _, ok := parentDisk[d.Name]; ok {
+		return false
+	}
+
+	if d.Readonly || d.Size < 10<<30 || d.Filesystem != "" || d.MountPoint != "" {
+		//		log.Infof("mismatched disk: %s filesystem:%s mountpoint:%s readonly:%t, size:%d", d.Name, d.Filesystem, d.MountPoint, d.Readonly, d.Size)
+		return false
+	}
+
+	if strings.Contains(d.Name, "cache") {
+		return false
+	}
+
+	// 过滤不支持的磁盘类型
+	for _, t := range []string{types.LVMType, types.CryptType, types.MultiPath, "rom"} {
+		if strings.Contains(d.Type, t) {
+			log.Infof("mismatched disk:%s, disktype:%s", d.Name, d.Type)
+			return false
+		}
+	}
+	return true
+}
+
 // DiscoverPv 支持发现Pv，由于某些异常情况，只创建成功了PV,并未创建成功VG
 func (dm *DeviceManager) DiscoverPv(diskClass map[string]configuration.DiskSelectorItem) (map[string][]string, error) {
 	resp := map[string][]string{}
@@ -338,9 +493,9 @@ func (dm *DeviceManager) DiscoverPv(diskClass map[string]configuration.DiskSelec
 		if strings.ToLower(ds.Policy) == "raw" {
 			continue
 		}
-		diskSelector, err := regexp.Compile(strings.Join(ds.Re, "|"))
+		diskSelector, err := newDiskMatcher(ds)
 		if err != nil {
-			log.Warnf("disk regex %s error %v ", strings.Join(ds.Re, "|"), err)
+			log.Warnf("disk group %s: %v", ds.Name, err)
 			return resp, err
 		}
 
@@ -352,11 +507,7 @@ func (dm *DeviceManager) DiscoverPv(diskClass map[string]configuration.DiskSelec
 					log.Errorf("resize %s error", pv.PVName)
 				}
 			}
-			if pv.VGName != "" {
-				continue
-			}
-			if !diskSelector.MatchString(pv.PVName) {
-				log.Infof("mismatched pv:%s, regex:%s", pv.PVName, diskSelector.String())
+			if pv.VGName != "" || !ds.Selects() {
 				continue
 			}
 			disk, err := dm.DiskManager.ListDevicesDetail(pv.PVName)
@@ -368,6 +519,10 @@ func (dm *DeviceManager) DiscoverPv(diskClass map[string]configuration.DiskSelec
 				log.Error("get disk count not equal 1")
 				continue
 			}
+			if !diskSelector.Match(disk[0]) {
+				log.Infof("mismatched pv:%s, selector:%s", pv.PVName, diskSelector.String())
+				continue
+			}
 			name = ds.Name
 			log.Infof("eligible %s pv %s", ds.Name, disk[0].Name)
 			if !utils.ContainsString(resp[name], disk[0].Name) {
@@ -378,25 +533,6 @@ func (dm *DeviceManager) DiscoverPv(diskClass map[string]configuration.DiskSelec
 	return resp, nil
 }
 
-func (dm *DeviceManager) VolumeConsistencyCheck() {
-
-	ticker1 := time.NewTicker(600 * time.Second)
-	go func(t *time.Ticker) {
-		defer ticker1.Stop()
-		for {
-			select {
-			case <-t.C:
-				log.Info("volume consistency check...")
-				dm.trouble.CleanupOrphanVolume()
-				dm.trouble.CleanupOrphanPartition()
-			case <-dm.stopChan:
-				log.Info("stop volume consistency check...")
-				return
-			}
-		}
-	}(ticker1)
-}
-
 func (dm *DeviceManager) DeviceCheckTask() {
 	dm.Cache.WaitForCacheSync(context.Background())
 	log.Info("start device scan...")
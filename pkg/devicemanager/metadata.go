@@ -0,0 +1,120 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carina-io/carina/pkg/devicemanager/lvmd"
+	"github.com/carina-io/carina/pkg/devicemanager/tools"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/pkg/devicemanager/volume"
+	"github.com/carina-io/carina/utils/log"
+)
+
+// metadataMigration 将卷的元数据从From升级到From+1
+type metadataMigration struct {
+	From        int
+	Description string
+	Apply       func(lvm lvmd.Lvm2, lv types.LvInfo) error
+}
+
+// metadataMigrations 每个版本一项，按From排序，最后一项升级到lvmd.MetadataVersion
+// 版本标签由MigrateMetadata在全部步骤完成后更新，Apply只需处理版本之间的差异
+var metadataMigrations = []metadataMigration{
+	{
+		From:        0,
+		Description: "record the metadata version",
+		Apply:       func(lvmd.Lvm2, types.LvInfo) error { return nil },
+	},
+}
+
+// carinaVolume carina创建的卷、thin pool和快照
+func carinaVolume(lv types.LvInfo) bool {
+	for _, prefix := range []string{volume.LVVolume, volume.THIN, volume.SNAP} {
+		if strings.HasPrefix(lv.LVName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// planMetadataMigration 返回元数据版本低于当前版本的卷及其版本
+// It fails if a volume was written by a newer carina, this release must not touch it.
+func planMetadataMigration(lvs []types.LvInfo) ([]types.LvInfo, []int, error) {
+	pending := []types.LvInfo{}
+	versions := []int{}
+	newer := []string{}
+	for _, lv := range lvs {
+		if !carinaVolume(lv) {
+			continue
+		}
+		v, err := lvmd.ParseMetadataVersion(lv.LVTags)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s/%s: %s", lv.VGName, lv.LVName, err.Error())
+		}
+		switch {
+		case v > lvmd.MetadataVersion:
+			newer = append(newer, fmt.Sprintf("%s/%s (version %d)", lv.VGName, lv.LVName, v))
+		case v < lvmd.MetadataVersion:
+			pending = append(pending, lv)
+			versions = append(versions, v)
+		}
+	}
+	if len(newer) > 0 {
+		return nil, nil, fmt.Errorf("lvm metadata of %s is newer than version %d supported by this carina-node, upgrade carina-node again", strings.Join(newer, ", "), lvmd.MetadataVersion)
+	}
+	return pending, versions, nil
+}
+
+// MigrateMetadata 启动时将卷的lvm元数据升级到当前版本
+// carina-node calls it before it starts any controller. If any volume carries metadata of a
+// newer carina, i.e. after a rollback, it returns an error and carina-node refuses to start,
+// nothing on the node is changed.
+func (dm *DeviceManager) MigrateMetadata() error {
+	// 没有lvm工具的节点上不会有carina创建的lvm卷
+	if err := tools.Check(tools.FeatureLvm); err != nil {
+		log.Warnf("skip lvm metadata migration: %s", err.Error())
+		return nil
+	}
+	dm.VolumeManager.RefreshLvmCache()
+	lvs, err := dm.LvmManager.LVS("")
+	if err != nil {
+		return fmt.Errorf("list logical volumes failed: %s", err.Error())
+	}
+	pending, versions, err := planMetadataMigration(lvs)
+	if err != nil {
+		return err
+	}
+	for i, lv := range pending {
+		for _, m := range metadataMigrations[versions[i]:] {
+			if err := m.Apply(dm.LvmManager, lv); err != nil {
+				return fmt.Errorf("migrate metadata of %s/%s from version %d (%s) failed: %s", lv.VGName, lv.LVName, m.From, m.Description, err.Error())
+			}
+		}
+		del := []string{}
+		if versions[i] > 0 {
+			del = append(del, lvmd.MetadataTag(versions[i]))
+		}
+		if err := dm.LvmManager.LVChangeTags(lv.LVName, lv.VGName, []string{lvmd.MetadataTag(lvmd.MetadataVersion)}, del); err != nil {
+			return fmt.Errorf("tag %s/%s with metadata version %d failed: %s", lv.VGName, lv.LVName, lvmd.MetadataVersion, err.Error())
+		}
+		log.Infof("migrated lvm metadata of %s/%s from version %d to %d", lv.VGName, lv.LVName, versions[i], lvmd.MetadataVersion)
+	}
+	return nil
+}
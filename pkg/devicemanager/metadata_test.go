@@ -0,0 +1,53 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"testing"
+
+	"github.com/carina-io/carina/pkg/devicemanager/lvmd"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataMigrations(t *testing.T) {
+	// 每个版本都有升级步骤
+	assert.Len(t, metadataMigrations, lvmd.MetadataVersion)
+	for i, m := range metadataMigrations {
+		assert.Equal(t, i, m.From)
+	}
+}
+
+func TestPlanMetadataMigration(t *testing.T) {
+	a := assert.New(t)
+	current := lvmd.MetadataTag(lvmd.MetadataVersion)
+	lvs := []types.LvInfo{
+		{LVName: "root", VGName: "centos"},
+		{LVName: "volume-pvc-1", VGName: "carina-vg-ssd"},
+		{LVName: "thin-pvc-1", VGName: "carina-vg-ssd", LVTags: "backup"},
+		{LVName: "snap-pvc-1-0", VGName: "carina-vg-ssd", LVTags: current},
+	}
+	pending, versions, err := planMetadataMigration(lvs)
+	a.NoError(err)
+	a.Equal([]string{"volume-pvc-1", "thin-pvc-1"}, []string{pending[0].LVName, pending[1].LVName})
+	a.Equal([]int{0, 0}, versions)
+
+	lvs = append(lvs, types.LvInfo{LVName: "volume-pvc-2", VGName: "carina-vg-hdd", LVTags: lvmd.MetadataTag(lvmd.MetadataVersion + 1)})
+	_, _, err = planMetadataMigration(lvs)
+	a.Error(err)
+	a.Contains(err.Error(), "carina-vg-hdd/volume-pvc-2")
+}
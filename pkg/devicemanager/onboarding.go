@@ -0,0 +1,160 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// OnboardFormat 空磁盘，批准后pvcreate写入磁盘
+	OnboardFormat = "Format"
+	// OnboardAdopt 没有vg的pv，批准后直接加入vg
+	OnboardAdopt = "Adopt"
+)
+
+// diskID 批准注解中磁盘的标识，wwn和序列号不随设备名变化
+func diskID(d *types.LocalDisk) string {
+	switch {
+	case d.Wwn != "":
+		return "wwn-" + d.Wwn
+	case d.Serial != "":
+		return "serial-" + d.Serial
+	}
+	return d.Name
+}
+
+// parseApprovedDisks 解析以逗号分隔的批准注解
+func parseApprovedDisks(value string) map[string]bool {
+	approved := map[string]bool{}
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			approved[id] = true
+		}
+	}
+	return approved
+}
+
+// approvedDisks 读取NodeStorageResource上批准加入vg的磁盘
+func (dm *DeviceManager) approvedDisks() map[string]bool {
+	nsr := &carinav1beta1.NodeStorageResource{}
+	if err := dm.Cache.Get(context.Background(), client.ObjectKey{Name: dm.nodeName}, nsr); err != nil {
+		log.Warnf("get nodestorageresource %s failed, no disk is approved: %s", dm.nodeName, err.Error())
+		return map[string]bool{}
+	}
+	return parseApprovedDisks(nsr.Annotations[utils.ApprovedDisks])
+}
+
+// holdDisks 拆分出已批准及免批准的磁盘，其余的作为待批准磁盘返回
+func holdDisks(needAdd map[string][]string, details map[string]*types.LocalDisk, blank, approved, exempt map[string]bool) (map[string][]string, []carinav1beta1.PendingDisk) {
+	kept := map[string][]string{}
+	pending := []carinav1beta1.PendingDisk{}
+	for group, disks := range needAdd {
+		for _, name := range disks {
+			d, ok := details[name]
+			if !ok {
+				d = &types.LocalDisk{Name: name}
+			}
+			if exempt[name] || approved[diskID(d)] {
+				kept[group] = append(kept[group], name)
+				continue
+			}
+			action := OnboardAdopt
+			if blank[name] {
+				action = OnboardFormat
+			}
+			pending = append(pending, carinav1beta1.PendingDisk{
+				DeviceGroup: group,
+				Disk:        name,
+				ID:          diskID(d),
+				Size:        d.Size,
+				Wwn:         d.Wwn,
+				Serial:      d.Serial,
+				Model:       d.Model,
+				Action:      action,
+			})
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].DeviceGroup != pending[j].DeviceGroup {
+			return pending[i].DeviceGroup < pending[j].DeviceGroup
+		}
+		return pending[i].Disk < pending[j].Disk
+	})
+	return kept, pending
+}
+
+// holdUnapproved 开启diskApproval时未批准的磁盘只报告，不加入vg，配置的loop设备不需要批准
+func (dm *DeviceManager) holdUnapproved(needAdd map[string][]string, blank map[string]bool, loops map[string][]string) map[string][]string {
+	if !configuration.DiskApproval() {
+		dm.setPendingDisks(nil)
+		return needAdd
+	}
+	exempt := map[string]bool{}
+	for _, devs := range loops {
+		for _, d := range devs {
+			exempt[d] = true
+		}
+	}
+	details := map[string]*types.LocalDisk{}
+	if localDisk, err := dm.DiskManager.ListDevicesDetail(""); err == nil {
+		for _, d := range localDisk {
+			details[d.Name] = d
+		}
+	} else {
+		log.Warnf("get local disk failed: %s", err.Error())
+	}
+	kept, pending := holdDisks(needAdd, details, blank, dm.approvedDisks(), exempt)
+	for _, p := range pending {
+		log.Infof("disk %s (%s) of %s waits for approval, action %s", p.Disk, p.ID, p.DeviceGroup, p.Action)
+	}
+	dm.setPendingDisks(pending)
+	return kept
+}
+
+func (dm *DeviceManager) setPendingDisks(pending []carinav1beta1.PendingDisk) {
+	dm.pendingMutex.Lock()
+	defer dm.pendingMutex.Unlock()
+	dm.pendingDisks = pending
+}
+
+// PendingDisks 返回等待批准加入vg的磁盘
+func (dm *DeviceManager) PendingDisks() []carinav1beta1.PendingDisk {
+	dm.pendingMutex.Lock()
+	defer dm.pendingMutex.Unlock()
+	// 为空时返回nil，与api server返回的status一致
+	var pending []carinav1beta1.PendingDisk
+	pending = append(pending, dm.pendingDisks...)
+	return pending
+}
+
+// RequestDeviceScan 触发一次磁盘扫描，已有扫描排队时忽略
+func (dm *DeviceManager) RequestDeviceScan() {
+	select {
+	case dm.configModifyChan <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,62 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"testing"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskID(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("wwn-0x5000c500a1b2c3d4", diskID(&types.LocalDisk{Name: "/dev/sdb", Wwn: "0x5000c500a1b2c3d4", Serial: "ZC1234AB"}))
+	a.Equal("serial-ZC1234AB", diskID(&types.LocalDisk{Name: "/dev/sdb", Serial: "ZC1234AB"}))
+	a.Equal("/dev/vdb", diskID(&types.LocalDisk{Name: "/dev/vdb"}))
+}
+
+func TestParseApprovedDisks(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(map[string]bool{"wwn-0x5000c500a1b2c3d4": true, "serial-ZC1234AB": true}, parseApprovedDisks(" wwn-0x5000c500a1b2c3d4, serial-ZC1234AB,,"))
+	a.Empty(parseApprovedDisks(""))
+}
+
+func TestHoldDisks(t *testing.T) {
+	a := assert.New(t)
+	needAdd := map[string][]string{
+		"carina-vg-hdd":  {"/dev/sdb", "/dev/sdc"},
+		"carina-vg-ssd":  {"/dev/vdb"},
+		"carina-vg-loop": {"/dev/loop0"},
+	}
+	details := map[string]*types.LocalDisk{
+		"/dev/sdb": {Name: "/dev/sdb", Size: 4 << 40, Wwn: "0x5000c500a1b2c3d4", Serial: "ZC1234AB", Model: "ST4000NM0035"},
+		"/dev/sdc": {Name: "/dev/sdc", Size: 4 << 40, Wwn: "0x5000c500a1b2c3d5"},
+	}
+	blank := map[string]bool{"/dev/sdb": true, "/dev/sdc": true}
+	approved := parseApprovedDisks("wwn-0x5000c500a1b2c3d5,/dev/sdb")
+	exempt := map[string]bool{"/dev/loop0": true}
+
+	kept, pending := holdDisks(needAdd, details, blank, approved, exempt)
+	// 批准注解只认wwn等标识，设备名不能批准有wwn的磁盘
+	a.Equal(map[string][]string{"carina-vg-hdd": {"/dev/sdc"}, "carina-vg-loop": {"/dev/loop0"}}, kept)
+	a.Equal([]carinav1beta1.PendingDisk{
+		{DeviceGroup: "carina-vg-hdd", Disk: "/dev/sdb", ID: "wwn-0x5000c500a1b2c3d4", Size: 4 << 40, Wwn: "0x5000c500a1b2c3d4", Serial: "ZC1234AB", Model: "ST4000NM0035", Action: OnboardFormat},
+		{DeviceGroup: "carina-vg-ssd", Disk: "/dev/vdb", ID: "/dev/vdb", Action: OnboardAdopt},
+	}, pending)
+}
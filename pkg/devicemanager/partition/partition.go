@@ -25,6 +25,8 @@ import (
 	"github.com/anuvu/disko"
 	"github.com/anuvu/disko/linux"
 	"github.com/anuvu/disko/partid"
+	"github.com/carina-io/carina/pkg/devicemanager/device"
+	"github.com/carina-io/carina/pkg/devicemanager/tools"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 	"github.com/carina-io/carina/utils/exec"
 	"github.com/carina-io/carina/utils/log"
@@ -46,6 +48,7 @@ type LocalPartition interface {
 	GetPartition(name, groups string) (disko.Partition, error)
 	UpdatePartition(name, groups string, size uint64) error
 	DeletePartition(name, groups string) error
+	WipePartition(name, groups string, wipe device.Wipe) error
 	DeletePartitionByPartNumber(disk disko.Disk, number uint) error
 	UpdatePartitionCache(name string, number uint) error
 	Wipe(name, groups string) error
@@ -71,10 +74,10 @@ func NewLocalPartitionImplement() *LocalPartitionImplement {
 		CacheParttionNum: make(map[string]uint),
 		Executor:         executor}
 }
-func (ld *LocalPartitionImplement) ListDevicesDetail(device string) ([]*types.LocalDisk, error) {
+func (ld *LocalPartitionImplement) ListDevicesDetail(dev string) ([]*types.LocalDisk, error) {
 	args := []string{"--pairs", "--paths", "--bytes", "--output", "NAME,FSTYPE,MOUNTPOINT,SIZE,STATE,TYPE,ROTA,RO,PKNAME"}
-	if device != "" {
-		args = append(args, device)
+	if dev != "" {
+		args = append(args, dev)
 	}
 	devices, err := ld.Executor.ExecuteCommandWithOutput("lsblk", args...)
 	if err != nil {
@@ -82,7 +85,7 @@ func (ld *LocalPartitionImplement) ListDevicesDetail(device string) ([]*types.Lo
 		return nil, err
 	}
 
-	return filter(parseDiskString(devices)), nil
+	return filter(device.ApplyQuirks(parseDiskString(devices))), nil
 }
 
 func parseDiskString(diskString string) []*types.LocalDisk {
@@ -163,6 +166,13 @@ func (ld *LocalPartitionImplement) ScanAllDisk(paths []string) (disko.DiskSet, e
 		log.Errorf("scan  node disk resource error %s", err.Error())
 		return disko.DiskSet{}, err
 	}
+	// eMMC、usb桥接的固态盘被识别为HDD
+	for name, disk := range diskSet {
+		if disk.Type == disko.HDD && device.NonRotational(disk.Path) {
+			disk.Type = disko.SSD
+			diskSet[name] = disk
+		}
+	}
 	return diskSet, nil
 }
 
@@ -202,6 +212,23 @@ func (ld *LocalPartitionImplement) GetPartition(name, groups string) (disko.Part
 
 }
 
+// WipePartition 删除分区前擦除分区上的数据
+func (ld *LocalPartitionImplement) WipePartition(name, groups string, wipe device.Wipe) error {
+	part, err := ld.GetPartition(name, groups)
+	if err != nil {
+		return err
+	}
+	if part.Number == 0 {
+		log.Warnf("partition %s not found in %s, skip wipe", name, groups)
+		return nil
+	}
+	disk, err := ld.ScanDisk(groups)
+	if err != nil {
+		return err
+	}
+	return device.WipeDevice(ld.Executor, device.PartitionPath(disk.Path, part.Number), wipe)
+}
+
 func parseUdevInfo(output string) map[string]string {
 	lines := strings.Split(output, "\n")
 	result := make(map[string]string, len(lines))
@@ -318,7 +345,7 @@ func (ld *LocalPartitionImplement) UpdatePartition(name, groups string, size uin
 		last := p.Start + uint64(size) - 1
 		partitionNum = p.Number
 		log.Info("Update parttion on disk dst: ", fmt.Sprintf("/dev/%s", diskPath), " number:", p.Number, " name:", p.Name, " start:", p.Start, " size: ", p.Last, " last:", p.Last, disk.Table)
-		targetPathOut, err := ld.Executor.ExecuteCommandWithOutput("/usr/bin/findmnt", "-S", fmt.Sprintf("/dev/%sp%d", diskPath, p.Number), "--noheadings", "--output=target")
+		targetPathOut, err := ld.Executor.ExecuteCommandWithOutput(tools.Path("findmnt", "/usr/bin/findmnt"), "-S", fmt.Sprintf("/dev/%sp%d", diskPath, p.Number), "--noheadings", "--output=target")
 		if err != nil {
 			log.Error("/usr/bin/findmnt", "-S", fmt.Sprintf("/dev/%sp%d", diskPath, p.Number), "--noheadings", "--output=target", "failed"+err.Error())
 			return err
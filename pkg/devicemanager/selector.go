@@ -0,0 +1,171 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// diskMatcher 磁盘组的匹配条件，配置的条件全部满足才匹配，拒绝列表优先
+type diskMatcher struct {
+	name       *regexp.Regexp
+	model      *regexp.Regexp
+	wwn        []string
+	serial     []string
+	minSize    uint64
+	maxSize    uint64
+	rotational *bool
+	deny       []string
+	attributes bool
+}
+
+func newDiskMatcher(ds configuration.DiskSelectorItem) (*diskMatcher, error) {
+	m := &diskMatcher{rotational: ds.Rotational, attributes: ds.Attributes()}
+	var err error
+	if len(ds.Re) > 0 {
+		if m.name, err = regexp.Compile(strings.Join(ds.Re, "|")); err != nil {
+			return nil, fmt.Errorf("disk regex %s error %v", strings.Join(ds.Re, "|"), err)
+		}
+	}
+	if len(ds.Model) > 0 {
+		if m.model, err = regexp.Compile(strings.Join(ds.Model, "|")); err != nil {
+			return nil, fmt.Errorf("model regex %s error %v", strings.Join(ds.Model, "|"), err)
+		}
+	}
+	for _, w := range ds.Wwn {
+		m.wwn = append(m.wwn, normalizeWwn(w))
+	}
+	for _, s := range ds.Serial {
+		m.serial = append(m.serial, strings.TrimSpace(s))
+	}
+	for _, d := range ds.Deny {
+		m.deny = append(m.deny, strings.TrimSpace(d))
+	}
+	for _, s := range []struct {
+		value string
+		size  *uint64
+	}{{ds.MinSize, &m.minSize}, {ds.MaxSize, &m.maxSize}} {
+		if s.value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(s.value)
+		if err != nil {
+			return nil, fmt.Errorf("disk size %s error %v", s.value, err)
+		}
+		*s.size = uint64(q.Value())
+	}
+	return m, nil
+}
+
+// attributeOnly reports whether a lvm disk group selects disks without a device name regexp
+func attributeOnly(diskClass map[string]configuration.DiskSelectorItem) bool {
+	for _, item := range diskClass {
+		if strings.ToLower(item.Policy) != "raw" && len(item.Re) == 0 && item.Selects() {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeWwn lsblk输出0x开头的wwn，/dev/disk/by-id下为wwn-0x开头
+func normalizeWwn(wwn string) string {
+	wwn = strings.ToLower(strings.TrimSpace(wwn))
+	wwn = strings.TrimPrefix(wwn, "wwn-")
+	return strings.TrimPrefix(wwn, "0x")
+}
+
+// Match reports whether the disk joins the disk group
+func (m *diskMatcher) Match(d *types.LocalDisk) bool {
+	if m.name == nil && m.model == nil && len(m.wwn) == 0 && len(m.serial) == 0 {
+		return false
+	}
+	if m.Denied(d) {
+		return false
+	}
+	if m.name != nil && !m.name.MatchString(d.Name) {
+		return false
+	}
+	if m.model != nil && !m.model.MatchString(d.Model) {
+		return false
+	}
+	if len(m.wwn) > 0 && (d.Wwn == "" || !utils.ContainsString(m.wwn, normalizeWwn(d.Wwn))) {
+		return false
+	}
+	if len(m.serial) > 0 && (d.Serial == "" || !utils.ContainsString(m.serial, d.Serial)) {
+		return false
+	}
+	if m.minSize > 0 && d.Size < m.minSize {
+		return false
+	}
+	if m.maxSize > 0 && d.Size > m.maxSize {
+		return false
+	}
+	if m.rotational != nil && (d.Rotational == "1") != *m.rotational {
+		return false
+	}
+	return true
+}
+
+// Denied reports whether the device name, wwn or serial of the disk is in the deny list
+func (m *diskMatcher) Denied(d *types.LocalDisk) bool {
+	for _, v := range m.deny {
+		switch {
+		case v == d.Name || filepath.Join("/dev", v) == d.Name:
+			return true
+		case d.Wwn != "" && normalizeWwn(v) == normalizeWwn(d.Wwn):
+			return true
+		case d.Serial != "" && v == d.Serial:
+			return true
+		}
+	}
+	return false
+}
+
+// String 日志中输出的匹配条件
+func (m *diskMatcher) String() string {
+	conditions := []string{}
+	if m.name != nil {
+		conditions = append(conditions, "re="+m.name.String())
+	}
+	if m.model != nil {
+		conditions = append(conditions, "model="+m.model.String())
+	}
+	if len(m.wwn) > 0 {
+		conditions = append(conditions, "wwn="+strings.Join(m.wwn, ","))
+	}
+	if len(m.serial) > 0 {
+		conditions = append(conditions, "serial="+strings.Join(m.serial, ","))
+	}
+	if m.minSize > 0 || m.maxSize > 0 {
+		conditions = append(conditions, fmt.Sprintf("size=%d-%d", m.minSize, m.maxSize))
+	}
+	if m.rotational != nil {
+		conditions = append(conditions, fmt.Sprintf("rotational=%t", *m.rotational))
+	}
+	if len(m.deny) > 0 {
+		conditions = append(conditions, "deny="+strings.Join(m.deny, ","))
+	}
+	return strings.Join(conditions, " ")
+}
@@ -0,0 +1,82 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"testing"
+
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskMatcher(t *testing.T) {
+	hdd, ssd := true, false
+	sdb := &types.LocalDisk{Name: "/dev/sdb", Size: 4 << 40, Rotational: "1", Wwn: "0x5000c500a1b2c3d4", Serial: "ZC1234AB", Model: "ST4000NM0035"}
+	sdc := &types.LocalDisk{Name: "/dev/sdc", Size: 960 << 30, Rotational: "0", Wwn: "0x55cd2e404c0a1b2c", Serial: "PHYF1234", Model: "INTEL SSDSC2KG960G8"}
+	nvme := &types.LocalDisk{Name: "/dev/nvme0n1", Size: 2 << 40, Rotational: "0", Wwn: "eui.0025388b91b0a2f1", Serial: "S4EWNX0N", Model: "Samsung SSD 970 EVO Plus 2TB"}
+
+	table := []struct {
+		item    configuration.DiskSelectorItem
+		matched []*types.LocalDisk
+	}{
+		{item: configuration.DiskSelectorItem{Re: []string{"sd[b-z]"}}, matched: []*types.LocalDisk{sdb, sdc}},
+		// wwn不区分大小写，可以带wwn-前缀
+		{item: configuration.DiskSelectorItem{Wwn: []string{"wwn-0x5000C500A1B2C3D4", "eui.0025388b91b0a2f1"}}, matched: []*types.LocalDisk{sdb, nvme}},
+		{item: configuration.DiskSelectorItem{Serial: []string{"PHYF1234"}}, matched: []*types.LocalDisk{sdc}},
+		{item: configuration.DiskSelectorItem{Model: []string{"(?i)ssd"}}, matched: []*types.LocalDisk{sdc, nvme}},
+		{item: configuration.DiskSelectorItem{Model: []string{"(?i)ssd"}, MinSize: "1Ti"}, matched: []*types.LocalDisk{nvme}},
+		{item: configuration.DiskSelectorItem{Re: []string{"sd", "nvme"}, MaxSize: "3Ti"}, matched: []*types.LocalDisk{sdc, nvme}},
+		{item: configuration.DiskSelectorItem{Re: []string{"sd", "nvme"}, Rotational: &hdd}, matched: []*types.LocalDisk{sdb}},
+		{item: configuration.DiskSelectorItem{Re: []string{"sd", "nvme"}, Rotational: &ssd}, matched: []*types.LocalDisk{sdc, nvme}},
+		// 拒绝列表优先于其他条件
+		{item: configuration.DiskSelectorItem{Re: []string{"sd", "nvme"}, Deny: []string{"sdb", "PHYF1234"}}, matched: []*types.LocalDisk{nvme}},
+		{item: configuration.DiskSelectorItem{Wwn: []string{"0x5000c500a1b2c3d4"}, Deny: []string{"wwn-0x5000c500a1b2c3d4"}}},
+		// 只有容量条件时不匹配任何磁盘
+		{item: configuration.DiskSelectorItem{MinSize: "1Gi"}},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		m, err := newDiskMatcher(e.item)
+		a.NoError(err)
+		matched := []*types.LocalDisk{}
+		for _, d := range []*types.LocalDisk{sdb, sdc, nvme} {
+			if m.Match(d) {
+				matched = append(matched, d)
+			}
+		}
+		if e.matched == nil {
+			e.matched = []*types.LocalDisk{}
+		}
+		a.Equal(e.matched, matched, m.String())
+	}
+
+	_, err := newDiskMatcher(configuration.DiskSelectorItem{Re: []string{"sd[b"}})
+	a.Error(err)
+}
+
+func TestAttributeOnly(t *testing.T) {
+	a := assert.New(t)
+	a.False(attributeOnly(map[string]configuration.DiskSelectorItem{
+		"carina-vg-hdd": {Name: "carina-vg-hdd", Re: []string{"sd"}, Wwn: []string{"0x5000c500a1b2c3d4"}},
+		"carina-vg-ssd": {Name: "carina-vg-ssd"},
+	}))
+	a.True(attributeOnly(map[string]configuration.DiskSelectorItem{
+		"carina-vg-hdd": {Name: "carina-vg-hdd", Serial: []string{"ZC1234AB"}},
+	}))
+}
@@ -0,0 +1,145 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package deviceManager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/carina-io/carina/pkg/configuration"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/pkg/devicemanager/volume"
+	"github.com/carina-io/carina/pkg/version"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/carina-io/carina/utils/mutx"
+)
+
+// spareCheckInterval 补充备用卷的间隔，领用之后最迟在这个时间内补齐
+const spareCheckInterval = 60 * time.Second
+
+// SpareVolumeTask 定时按配置补充或回收各磁盘组的备用卷
+func (dm *DeviceManager) SpareVolumeTask() {
+	ticker := time.NewTicker(spareCheckInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = dm.Pool.Run(context.Background(), mutx.PriorityBackground, "spare volumes", func() error {
+					dm.ReplenishSpareVolumes()
+					return nil
+				})
+			case <-dm.stopChan:
+				log.Info("stop spare volume task...")
+				return
+			}
+		}
+	}()
+}
+
+// ReplenishSpareVolumes creates the missing spare volumes of every volume group and
+// removes those no longer configured. Spares are created one at a time, a failure
+// stops the volume group until the next round.
+func (dm *DeviceManager) ReplenishSpareVolumes() {
+	spares := configuration.SpareVolumes()
+	for i := range spares {
+		spares[i].DeviceGroup = version.GetDeviceGroup(spares[i].DeviceGroup)
+	}
+
+	vgs, err := dm.VolumeManager.GetCurrentVgStruct()
+	if err != nil {
+		log.Errorf("get current vg struct failed: %s", err.Error())
+		return
+	}
+	for _, vg := range vgs {
+		existing, err := dm.VolumeManager.SpareList(vg.VGName)
+		if err != nil {
+			log.Errorf("list spare volumes of %s failed: %s", vg.VGName, err.Error())
+			continue
+		}
+		create, remove := planSpares(spares, vg.VGName, existing)
+		if len(create) == 0 && len(remove) == 0 {
+			continue
+		}
+
+		for _, name := range remove {
+			log.Infof("remove spare volume %s/%s", vg.VGName, name)
+			if err := dm.VolumeManager.DeleteSpare(name, vg.VGName); err != nil {
+				log.Errorf("remove spare volume %s/%s failed: %s", vg.VGName, name, err.Error())
+			}
+		}
+		for _, item := range create {
+			size, _ := item.SizeBytes()
+			if err := dm.VolumeManager.CreateSpare(vg.VGName, uint64(size), item.FsType); err != nil {
+				log.Warnf("create %s spare volume of %s in %s failed: %s", item.FsType, item.Size, vg.VGName, err.Error())
+				break
+			}
+		}
+		dm.VolumeManager.NoticeUpdateCapacity([]string{vg.VGName})
+	}
+}
+
+// planSpares 对比配置与已有的备用卷，按文件系统和大小分组计数
+func planSpares(spares []configuration.SpareVolumeItem, vgName string, existing []types.LvInfo) ([]configuration.SpareVolumeItem, []string) {
+	key := func(fsType string, size int64) string {
+		return fmt.Sprintf("%s/%d", fsType, size)
+	}
+
+	wanted := map[string]int{}
+	items := map[string]configuration.SpareVolumeItem{}
+	keys := []string{}
+	for _, item := range spares {
+		if item.DeviceGroup != vgName {
+			continue
+		}
+		size, err := item.SizeBytes()
+		if err != nil {
+			continue
+		}
+		k := key(item.FsType, size)
+		if _, ok := items[k]; !ok {
+			keys = append(keys, k)
+		}
+		wanted[k] += item.Count
+		items[k] = item
+	}
+
+	have := map[string][]string{}
+	for _, lv := range existing {
+		k := key(volume.SpareFsType(lv.LVName), int64(lv.LVSize))
+		have[k] = append(have[k], lv.LVName)
+	}
+
+	remove := []string{}
+	for k, names := range have {
+		if extra := len(names) - wanted[k]; extra > 0 {
+			sort.Strings(names)
+			remove = append(remove, names[:extra]...)
+		}
+	}
+	sort.Strings(remove)
+
+	create := []configuration.SpareVolumeItem{}
+	for _, k := range keys {
+		for i := len(have[k]); i < wanted[k]; i++ {
+			create = append(create, items[k])
+		}
+	}
+	return create, remove
+}
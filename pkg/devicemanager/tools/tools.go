@@ -0,0 +1,218 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tools
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/carina-io/carina/utils/log"
+)
+
+const (
+	// FeatureLvm lvm磁盘组，缺失时节点无法提供lvm卷
+	FeatureLvm = "lvm"
+	// FeatureDisk 磁盘发现、分区和挂载检查
+	FeatureDisk = "disk"
+	// FeatureFilesystem 格式化及扩容文件系统
+	FeatureFilesystem = "filesystem"
+	// FeatureBcache bcache卷，还需要加载bcache内核模块
+	FeatureBcache = "bcache"
+	// FeatureEncryption luks加密卷
+	FeatureEncryption = "encryption"
+	// FeatureLoop 由稀疏文件挂载的loop设备
+	FeatureLoop = "loop"
+
+	// bcacheSysfs bcache内核模块加载后出现
+	bcacheSysfs = "/sys/fs/bcache"
+
+	// StaticLvm carina镜像自带的静态链接lvm，存在时lvm2的命令都通过它执行，不需要宿主机或基础镜像的lvm2
+	StaticLvm = "/usr/local/carina/sbin/lvm"
+)
+
+// features 各功能依赖的命令
+var features = map[string][]string{
+	FeatureLvm:        {"lvm", "pvcreate", "pvs", "vgcreate", "vgs", "lvcreate", "lvs"},
+	FeatureDisk:       {"lsblk", "parted", "wipefs", "blkid", "findmnt", "udevadm"},
+	FeatureFilesystem: {"mkfs.ext4", "mkfs.xfs", "resize2fs", "xfs_growfs"},
+	FeatureBcache:     {"make-bcache", "bcache-super-show"},
+	FeatureEncryption: {"cryptsetup"},
+	FeatureLoop:       {"losetup"},
+}
+
+// searchDirs 不同发行版及架构的镜像中lvm2等工具的位置，不在PATH中的目录也会查找
+var searchDirs = []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"}
+
+// Report 节点上命令的检查结果
+type Report struct {
+	// Platform of carina-node, e.g. linux/arm64
+	Platform string
+	// Paths 找到的命令及其路径
+	Paths map[string]string
+	// Missing 各功能缺失的命令
+	Missing map[string][]string
+}
+
+// MissingError 功能依赖的命令在节点上缺失
+type MissingError struct {
+	Feature  string
+	Tools    []string
+	Platform string
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("%s is not available on this %s node, missing %s", e.Feature, e.Platform, strings.Join(e.Tools, ", "))
+}
+
+var (
+	mu      sync.RWMutex
+	current *Report
+)
+
+// Setup looks for the tools of every feature in PATH and in the usual directories of the
+// distributions, adds the directories found outside of PATH to it and keeps the result.
+func Setup() Report {
+	r := detect(exec.LookPath, exists, searchDirs)
+	path := filepath.SplitList(os.Getenv("PATH"))
+	for _, p := range r.Paths {
+		dir := filepath.Dir(p)
+		if !containsString(path, dir) {
+			path = append(path, dir)
+		}
+	}
+	_ = os.Setenv("PATH", strings.Join(path, string(os.PathListSeparator)))
+
+	for _, feature := range sortedKeys(r.Missing) {
+		log.Warnf("%s", (&MissingError{Feature: feature, Tools: r.Missing[feature], Platform: r.Platform}).Error())
+	}
+	mu.Lock()
+	current = &r
+	mu.Unlock()
+	return r
+}
+
+// Summary 按功能列出缺失的命令，e.g. bcache: make-bcache; encryption: cryptsetup
+func (r *Report) Summary() string {
+	msgs := []string{}
+	for _, feature := range sortedKeys(r.Missing) {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", feature, strings.Join(r.Missing[feature], ", ")))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Current returns the result of the last Setup, nil before it
+func Current() *Report {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Path 返回检测到的命令路径，未检测或未找到时返回fallback
+func Path(name, fallback string) string {
+	if r := Current(); r != nil {
+		if p, ok := r.Paths[name]; ok {
+			return p
+		}
+	}
+	return fallback
+}
+
+// Lvm 返回执行lvm2子命令的静态lvm，未检测到时返回空，使用PATH中的lvm2命令
+func Lvm() string {
+	if Path("lvm", "") == StaticLvm {
+		return StaticLvm
+	}
+	return ""
+}
+
+// Check returns a *MissingError if a tool of the feature was not found by Setup. Before
+// Setup every feature is taken as available.
+func Check(feature string) error {
+	r := Current()
+	if r == nil || len(r.Missing[feature]) == 0 {
+		return nil
+	}
+	return &MissingError{Feature: feature, Tools: r.Missing[feature], Platform: r.Platform}
+}
+
+func detect(lookPath func(string) (string, error), exists func(string) bool, dirs []string) Report {
+	r := Report{
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		Paths:    map[string]string{},
+		Missing:  map[string][]string{},
+	}
+	staticLvm := exists(StaticLvm)
+	for _, feature := range sortedKeys(features) {
+		if feature == FeatureLvm && staticLvm {
+			r.Paths["lvm"] = StaticLvm
+			continue
+		}
+		for _, name := range features[feature] {
+			if p, ok := r.Paths[name]; ok && p != "" {
+				continue
+			}
+			p, err := lookPath(name)
+			if err != nil {
+				p = ""
+				for _, dir := range dirs {
+					if exists(filepath.Join(dir, name)) {
+						p = filepath.Join(dir, name)
+						break
+					}
+				}
+			}
+			if p == "" {
+				r.Missing[feature] = append(r.Missing[feature], name)
+				continue
+			}
+			r.Paths[name] = p
+		}
+	}
+	if !exists(bcacheSysfs) {
+		r.Missing[FeatureBcache] = append(r.Missing[FeatureBcache], "bcache kernel module")
+	}
+	return r
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
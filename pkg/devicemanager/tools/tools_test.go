@@ -0,0 +1,88 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tools
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	a := assert.New(t)
+	// PATH中只有/usr/bin，lvm2装在/usr/sbin，没有bcache-tools和cryptsetup
+	inPath := map[string]bool{"lsblk": true, "findmnt": true, "losetup": true}
+	files := map[string]bool{"/usr/bin/lsblk": true, "/usr/bin/findmnt": true, "/usr/bin/losetup": true, "/sbin/udevadm": true}
+	for _, name := range append(features[FeatureLvm], "parted", "wipefs", "blkid", "mkfs.ext4", "mkfs.xfs", "resize2fs", "xfs_growfs") {
+		files["/usr/sbin/"+name] = true
+	}
+	lookPath := func(name string) (string, error) {
+		if inPath[name] {
+			return "/usr/bin/" + name, nil
+		}
+		return "", errors.New("executable file not found in $PATH")
+	}
+	exists := func(path string) bool { return files[path] }
+
+	r := detect(lookPath, exists, searchDirs)
+	a.Equal(map[string][]string{
+		FeatureBcache:     {"make-bcache", "bcache-super-show", "bcache kernel module"},
+		FeatureEncryption: {"cryptsetup"},
+	}, r.Missing)
+	a.Equal("/usr/sbin/lvcreate", r.Paths["lvcreate"])
+	a.Equal("/usr/bin/lsblk", r.Paths["lsblk"])
+	a.Equal("/sbin/udevadm", r.Paths["udevadm"])
+
+	a.Equal("bcache: make-bcache, bcache-super-show, bcache kernel module; encryption: cryptsetup", r.Summary())
+
+	mu.Lock()
+	current = &Report{Platform: "linux/arm64", Paths: r.Paths, Missing: r.Missing}
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		current = nil
+		mu.Unlock()
+	}()
+	a.NoError(Check(FeatureLvm))
+	err := Check(FeatureBcache)
+	a.Equal("bcache is not available on this linux/arm64 node, missing make-bcache, bcache-super-show, bcache kernel module", err.Error())
+	a.Equal("/usr/sbin/blkid", Path("blkid", "/sbin/blkid"))
+	a.Equal("/sbin/cryptsetup", Path("cryptsetup", "/sbin/cryptsetup"))
+}
+
+func TestDetectStaticLvm(t *testing.T) {
+	a := assert.New(t)
+	// 镜像自带静态lvm，没有安装lvm2
+	lookPath := func(name string) (string, error) { return "/usr/bin/" + name, nil }
+	exists := func(path string) bool { return path == StaticLvm || path == bcacheSysfs }
+
+	r := detect(lookPath, exists, searchDirs)
+	a.Empty(r.Missing)
+	a.Equal(StaticLvm, r.Paths["lvm"])
+	a.NotContains(r.Paths, "lvcreate")
+
+	mu.Lock()
+	current = &r
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		current = nil
+		mu.Unlock()
+	}()
+	a.Equal(StaticLvm, Lvm())
+}
@@ -19,18 +19,35 @@ package troubleshoot
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/anuvu/disko/linux"
 	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
 	"github.com/carina-io/carina/pkg/devicemanager/partition"
 	"github.com/carina-io/carina/pkg/devicemanager/volume"
 	"github.com/carina-io/carina/utils"
 	"github.com/carina-io/carina/utils/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	// OrphanVolume 节点上的逻辑卷或分区没有LogicVolume
+	OrphanVolume = "Volume"
+	// OrphanLogicVolume LogicVolume没有PV，或者节点上没有它的卷
+	OrphanLogicVolume = "LogicVolume"
+	// OrphanPersistentVolume PV没有LogicVolume
+	OrphanPersistentVolume = "PersistentVolume"
+
+	// provisionGrace CreateVolume创建LogicVolume之后才创建PV
+	provisionGrace = 10 * time.Minute
+)
+
 type Trouble struct {
 	volumeManager volume.LocalVolume
 	partition     partition.LocalPartition
@@ -38,7 +55,20 @@ type Trouble struct {
 	nodeName      string
 }
 
-const logPrefix = "clean orphan volume:"
+// LocalVolume 节点上carina创建的逻辑卷或裸盘分区
+type LocalVolume struct {
+	// Name of the logical volume, e.g. volume-pvc-xxx, or of the partition, e.g. carina.io/xxx
+	Name string
+	// DeviceGroup is the volume group of a logical volume or the disk of a partition
+	DeviceGroup string
+	// Number of a partition on its disk
+	Number uint
+}
+
+// Partition reports whether the volume is a partition of a raw disk
+func (v LocalVolume) Partition() bool {
+	return strings.HasPrefix(v.Name, "carina.io/")
+}
 
 func NewTroubleObject(volumeManager volume.LocalVolume, partition partition.LocalPartition, cache cache.Cache, nodeName string) *Trouble {
 
@@ -62,123 +92,147 @@ func NewTroubleObject(volumeManager volume.LocalVolume, partition partition.Loca
 	}
 }
 
-func (t *Trouble) CleanupOrphanVolume() {
-	//t.volumeManager.HealthCheck()
-
-	// step.1 获取所有本地volume
-	log.Infof("%s get all local logic volume", logPrefix)
+// LocalVolumes 列出节点上carina创建的卷和分区，备用卷由节点自行补充和回收，不包括在内
+func (t *Trouble) LocalVolumes() ([]LocalVolume, error) {
+	resp := []LocalVolume{}
 	volumeList, err := t.volumeManager.VolumeList("", "")
 	if err != nil {
-		log.Errorf("% get all local volume failed %s", logPrefix, err.Error())
+		return nil, fmt.Errorf("list local volume failed %s", err.Error())
 	}
-
-	// step.2 检查卷状态是否正常
-	log.Infof("%s check volume status", logPrefix)
 	for _, lv := range volumeList {
+		if !strings.Contains(lv.VGName, "carina") || !strings.HasPrefix(lv.LVName, volume.LVVolume) || volume.IsSpare(lv.LVName) {
+			continue
+		}
 		if lv.LVActive != "active" {
-			log.Warnf("%s logic volume %s current status %s", logPrefix, lv.LVName, lv.LVActive)
+			log.Warnf("logic volume %s current status %s", lv.LVName, lv.LVActive)
 		}
+		resp = append(resp, LocalVolume{Name: lv.LVName, DeviceGroup: lv.VGName})
 	}
 
-	// step.3 获取集群中logicVolume对象
-	log.Infof("%s get all logicVolume in cluster", logPrefix)
-	lvList := &carinav1.LogicVolumeList{}
-	err = t.cache.List(context.Background(), lvList, client.MatchingFields{"nodeName": t.nodeName})
+	disklist, err := t.partition.ListDevicesDetail("")
 	if err != nil {
-		log.Errorf("%s list logic volume error %s", logPrefix, err.Error())
-		return
-	}
-
-	// step.4 对比本地volume与logicVolume是否一致， 集群中没有的便删除本地的
-	log.Infof("%s cleanup orphan volume", logPrefix)
-	mapLvList := map[string]bool{}
-	for _, v := range lvList.Items {
-		//skip raw logicVolume
-		if v.Annotations[utils.VolumeManagerType] == utils.RawVolumeType {
-			continue
-		}
-		mapLvList[v.Name] = true
-		mapLvList[fmt.Sprintf("thin-%s", v.Name)] = true
-		mapLvList[fmt.Sprintf("volume-%s", v.Name)] = true
+		return nil, fmt.Errorf("list local disk failed %s", err.Error())
 	}
-
-	for _, v := range volumeList {
-		if !strings.Contains(v.VGName, "carina") {
-			log.Infof("%s skip volume %s", logPrefix, v.LVName)
-			continue
+	for _, d := range disklist {
+		disk, err := linux.System().ScanDisk(d.Name)
+		if err != nil {
+			return nil, fmt.Errorf("get disk %s info error %s", d.Name, err.Error())
 		}
-		if _, ok := mapLvList[v.LVName]; !ok {
-			log.Warnf("%s remove volume %s %s", logPrefix, v.VGName, v.LVName)
-			if strings.HasPrefix(v.LVName, "volume-") {
-				err := t.volumeManager.DeleteVolume(v.LVName, v.VGName)
-				if err != nil {
-					log.Errorf("%s delete volume vg %s lv %s error %s", logPrefix, v.VGName, v.LVName, err.Error())
-				}
+		for _, p := range disk.Partitions {
+			if !strings.Contains(p.Name, "carina.io") {
+				continue
 			}
+			resp = append(resp, LocalVolume{Name: p.Name, DeviceGroup: disk.Path, Number: p.Number})
 		}
 	}
-
-	log.Infof("%s volume check finished.", logPrefix)
+	return resp, nil
 }
 
-//清理裸盘分区和logicVolume的对应关系
-func (t *Trouble) CleanupOrphanPartition() {
-	// step.1 获取所有本地 磁盘分区，一个lv其实就是对应一个分区
-	log.Infof("%s get all local partition", "CleanupOrphanPartition")
-
-	disklist, err := t.partition.ListDevicesDetail("")
+// DeleteLocalVolume 删除孤儿卷或分区
+func (t *Trouble) DeleteLocalVolume(v LocalVolume) error {
+	if !v.Partition() {
+		return t.volumeManager.DeleteVolume(v.Name, v.DeviceGroup)
+	}
+	disk, err := linux.System().ScanDisk(v.DeviceGroup)
 	if err != nil {
-		log.Errorf("fail get all local parttions failed %s", err.Error())
+		return err
 	}
+	return t.partition.DeletePartitionByPartNumber(disk, v.Number)
+}
 
-	//TODU step.2 检查磁盘逻辑坏道，物理坏道隔离
+// FindOrphans 对比节点上的卷、LogicVolume和PV，找出缺少对应对象的一方
+// A LogicVolume being deleted still owns its volume. A LogicVolume without PersistentVolume is
+// reported before its missing volume, since collecting it removes the volume anyway.
+func FindOrphans(nodeName string, local []LocalVolume, lvs []carinav1.LogicVolume, pvs []corev1.PersistentVolume) []carinav1beta1.OrphanVolume {
+	orphans := []carinav1beta1.OrphanVolume{}
 
-	// step.3 获取集群中logicVolume对象
-	log.Infof("%s get all logicVolume in cluster", logPrefix)
-	lvList := &carinav1.LogicVolumeList{}
-	err = t.cache.List(context.Background(), lvList, client.MatchingFields{"nodeName": t.nodeName})
-	if err != nil {
-		log.Errorf("%s list logic volume error %s", logPrefix, err.Error())
-		return
+	owned := map[string]bool{}
+	lvNames := map[string]bool{}
+	for _, lv := range lvs {
+		if lv.Spec.NodeName != nodeName {
+			continue
+		}
+		lvNames[lv.Name] = true
+		owned[volume.LVVolume+lv.Name] = true
+		owned[utils.PartitionName(lv.Name)] = true
+	}
+	present := map[string]bool{}
+	for _, v := range local {
+		present[v.Name] = true
+		if !owned[v.Name] {
+			orphans = append(orphans, carinav1beta1.OrphanVolume{Kind: OrphanVolume, Name: v.Name, DeviceGroup: v.DeviceGroup, Reason: "no LogicVolume"})
+		}
 	}
 
-	// step.4 对比本地分区与logicVolume是否一致， 集群中没有的便删除本地磁盘分区
-	log.Infof("%s cleanup orphan parttions", logPrefix)
-	mapLvList := map[string]bool{}
-	for _, v := range lvList.Items {
-		//skip lvm logicVolume
-		if v.Annotations[utils.VolumeManagerType] == utils.LvmVolumeType {
+	pvNames := map[string]bool{}
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != utils.CSIPluginName {
 			continue
 		}
-
-		mapLvList[utils.PartitionName(v.Name)] = true
-	}
-	log.Infof("mapLvList:%v", mapLvList)
-	for _, d := range disklist {
-		disk, err := linux.System().ScanDisk(d.Name)
-		if err != nil {
-			log.Errorf("%s get disk info error %s", logPrefix, err.Error())
-			return
+		pvNames[pv.Name] = true
+		if pv.DeletionTimestamp != nil || pv.Spec.CSI.VolumeAttributes[utils.VolumeDeviceNode] != nodeName {
+			continue
+		}
+		if !lvNames[pv.Name] {
+			orphans = append(orphans, carinav1beta1.OrphanVolume{Kind: OrphanPersistentVolume, Name: pv.Name, Reason: "no LogicVolume"})
 		}
-		if len(disk.Partitions) < 1 {
+	}
+
+	for _, lv := range lvs {
+		if lv.Spec.NodeName != nodeName || lv.DeletionTimestamp != nil {
 			continue
 		}
-		for _, p := range disk.Partitions {
-			if !strings.Contains(p.Name, "carina.io") {
-				log.Infof("skip parttions %s", p.Name)
+		if !pvNames[lv.Name] {
+			if time.Since(lv.CreationTimestamp.Time) < provisionGrace {
 				continue
 			}
-			log.Infof("check parttions %s %d %d", p.Name, p.Start, p.Last)
-			if _, ok := mapLvList[p.Name]; !ok {
-				log.Warnf("remove parttions %s %d %d", p.Name, p.Start, p.Last)
-				if err := t.partition.DeletePartitionByPartNumber(disk, p.Number); err != nil {
-					log.Errorf("delete parttions in disk name: %s  number: %d error: %s", disk.Name, p.Number, err.Error())
-				}
+			orphans = append(orphans, carinav1beta1.OrphanVolume{Kind: OrphanLogicVolume, Name: lv.Name, DeviceGroup: lv.Spec.DeviceGroup, Reason: "no PersistentVolume"})
+			continue
+		}
+		if lv.Status.Status != "Success" {
+			continue
+		}
+		name := volume.LVVolume + lv.Name
+		if lv.Annotations[utils.VolumeManagerType] == utils.RawVolumeType {
+			name = utils.PartitionName(lv.Name)
+		}
+		if !present[name] {
+			orphans = append(orphans, carinav1beta1.OrphanVolume{Kind: OrphanLogicVolume, Name: lv.Name, DeviceGroup: lv.Spec.DeviceGroup, Reason: fmt.Sprintf("volume %s is missing on the node", name)})
+		}
+	}
 
-			}
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].Kind != orphans[j].Kind {
+			return orphans[i].Kind < orphans[j].Kind
+		}
+		return orphans[i].Name < orphans[j].Name
+	})
+	return orphans
+}
+
+// Collectable reports whether the orphan is garbage collected after the grace period
+func Collectable(o carinav1beta1.OrphanVolume) bool {
+	return o.Kind == OrphanVolume || (o.Kind == OrphanLogicVolume && o.Reason == "no PersistentVolume")
+}
 
+// TrackOrphans keeps the time the orphans of the last check were found and sets when the
+// collectable ones are deleted
+func TrackOrphans(previous, found []carinav1beta1.OrphanVolume, now time.Time, grace time.Duration) []carinav1beta1.OrphanVolume {
+	since := map[string]metav1.Time{}
+	for _, o := range previous {
+		since[o.Kind+"/"+o.Name] = o.Since
+	}
+	var orphans []carinav1beta1.OrphanVolume
+	for _, o := range found {
+		o.Since = metav1.NewTime(now)
+		if s, ok := since[o.Kind+"/"+o.Name]; ok {
+			o.Since = s
+		}
+		if Collectable(o) {
+			after := metav1.NewTime(o.Since.Add(grace))
+			o.CollectAfter = &after
 		}
+		orphans = append(orphans, o)
 	}
-
-	log.Infof("%s volume check finished.", logPrefix)
+	return orphans
 }
@@ -0,0 +1,104 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package troubleshoot
+
+import (
+	"testing"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFindOrphans(t *testing.T) {
+	a := assert.New(t)
+	now := metav1.Now()
+	lv := func(name, node, status string, annotations map[string]string) carinav1.LogicVolume {
+		return carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+			Spec:       carinav1.LogicVolumeSpec{NodeName: node, DeviceGroup: "carina-vg-ssd"},
+			Status:     carinav1.LogicVolumeStatus{Status: status},
+		}
+	}
+	pv := func(name, node string) corev1.PersistentVolume {
+		return corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+				Driver:           utils.CSIPluginName,
+				VolumeAttributes: map[string]string{utils.VolumeDeviceNode: node},
+			}}},
+		}
+	}
+
+	deleting := lv("pvc-deleting", "node1", "Success", nil)
+	deleting.DeletionTimestamp = &now
+	provisioning := lv("pvc-provisioning", "node1", "", nil)
+	provisioning.CreationTimestamp = now
+	lvs := []carinav1.LogicVolume{
+		lv("pvc-ok", "node1", "Success", nil),
+		lv("pvc-raw", "node1", "Success", map[string]string{utils.VolumeManagerType: utils.RawVolumeType}),
+		lv("pvc-nopv", "node1", "Success", nil),
+		lv("pvc-lost", "node1", "Success", nil),
+		lv("pvc-other", "node2", "Success", nil),
+		deleting,
+		provisioning,
+	}
+	pvs := []corev1.PersistentVolume{
+		pv("pvc-ok", "node1"), pv("pvc-raw", "node1"), pv("pvc-lost", "node1"), pv("pvc-other", "node2"),
+		pv("pvc-deleting", "node1"), pv("pvc-stale", "node1"), pv("pvc-elsewhere", "node2"),
+	}
+	local := []LocalVolume{
+		{Name: "volume-pvc-ok", DeviceGroup: "carina-vg-ssd"},
+		{Name: "carina.io/raw", DeviceGroup: "/dev/sdb", Number: 1},
+		{Name: "volume-pvc-nopv", DeviceGroup: "carina-vg-ssd"},
+		{Name: "volume-pvc-deleting", DeviceGroup: "carina-vg-ssd"},
+		{Name: "volume-pvc-gone", DeviceGroup: "carina-vg-hdd"},
+		{Name: "carina.io/gone", DeviceGroup: "/dev/sdc", Number: 2},
+	}
+
+	orphans := FindOrphans("node1", local, lvs, pvs)
+	a.Equal([]carinav1beta1.OrphanVolume{
+		{Kind: OrphanLogicVolume, Name: "pvc-lost", DeviceGroup: "carina-vg-ssd", Reason: "volume volume-pvc-lost is missing on the node"},
+		{Kind: OrphanLogicVolume, Name: "pvc-nopv", DeviceGroup: "carina-vg-ssd", Reason: "no PersistentVolume"},
+		{Kind: OrphanPersistentVolume, Name: "pvc-stale", Reason: "no LogicVolume"},
+		{Kind: OrphanVolume, Name: "carina.io/gone", DeviceGroup: "/dev/sdc", Reason: "no LogicVolume"},
+		{Kind: OrphanVolume, Name: "volume-pvc-gone", DeviceGroup: "carina-vg-hdd", Reason: "no LogicVolume"},
+	}, orphans)
+
+	// 宽限期从第一次发现开始计算，只报告的孤儿没有回收时间
+	first := time.Date(2022, 3, 1, 8, 0, 0, 0, time.UTC)
+	tracked := TrackOrphans(nil, orphans, first, time.Hour)
+	a.Len(tracked, 5)
+	for _, o := range tracked {
+		a.Equal(first, o.Since.Time)
+		a.Equal(Collectable(o), o.CollectAfter != nil, o.Name)
+	}
+	a.Nil(tracked[0].CollectAfter)
+	a.Equal(first.Add(time.Hour), tracked[1].CollectAfter.Time)
+
+	later := first.Add(20 * time.Minute)
+	tracked = TrackOrphans(tracked[1:3], orphans[1:4], later, time.Hour)
+	a.Equal(first, tracked[0].Since.Time)
+	a.Equal(first.Add(time.Hour), tracked[0].CollectAfter.Time)
+	a.Equal(first, tracked[1].Since.Time)
+	a.Equal(later, tracked[2].Since.Time)
+	a.Equal(later.Add(time.Hour), tracked[2].CollectAfter.Time)
+}
@@ -37,3 +37,17 @@ type BcacheDeviceInfo struct {
 	KernelMajor uint32 `json:"lvKernelMajor"`
 	KernelMinor uint32 `json:"lvKernelMinor"`
 }
+
+// BcacheStats bcache设备的运行状态，读取自/sys/block/bcacheN/bcache
+type BcacheStats struct {
+	Name          string `json:"name"`
+	BackingDevice string `json:"backing_device"`
+	CacheMode     string `json:"cache_mode"`
+	State         string `json:"state"`
+	DirtyData     string `json:"dirty_data"`
+	CacheHits     int64  `json:"cache_hits"`
+	CacheMisses   int64  `json:"cache_misses"`
+	CacheHitRatio int64  `json:"cache_hit_ratio"`
+	BypassHits    int64  `json:"cache_bypass_hits"`
+	BypassMisses  int64  `json:"cache_bypass_misses"`
+}
@@ -0,0 +1,55 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package types
+
+import (
+	"time"
+
+	"github.com/carina-io/carina/api"
+)
+
+// NodeDebugState carina-node调试接口返回的节点实时状态
+// Every part is collected on its own, a part that failed is left empty and its error is kept in Errors.
+type NodeDebugState struct {
+	Node     string            `json:"node"`
+	Time     time.Time         `json:"time"`
+	VgGroups []api.VgGroup     `json:"vgs"`
+	PVs      []api.PVInfo      `json:"pvs"`
+	LVs      []LvInfo          `json:"lvs"`
+	Bcache   []BcacheStats     `json:"bcache"`
+	Mounts   []MountEntry      `json:"mounts"`
+	Throttle ThrottleState     `json:"throttle"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+// MountEntry /proc/mounts中的一项
+type MountEntry struct {
+	Device  string `json:"device"`
+	Path    string `json:"path"`
+	FsType  string `json:"fsType"`
+	Options string `json:"options"`
+}
+
+// ThrottleState 节点上的限速配置
+type ThrottleState struct {
+	// Windows and Bandwidth limit the data movement jobs of the node, see NodeStorageResource
+	Windows   []string `json:"windows"`
+	Bandwidth int64    `json:"bandwidth"`
+	Open      bool     `json:"open"`
+	// Blkio is the content of the blkio cgroup throttle files, by file name and major:minor
+	Blkio map[string]map[string]string `json:"blkio"`
+}
@@ -54,4 +54,10 @@ type LocalDisk struct {
 	Used uint64 `json:"used"`
 	// parent Name
 	ParentName string `json:"parentName"`
+	// Wwn 磁盘的全球唯一名称，设备名重启后可能变化，wwn不变
+	Wwn string `json:"wwn"`
+	// Serial 磁盘序列号
+	Serial string `json:"serial"`
+	// Model 磁盘型号
+	Model string `json:"model"`
 }
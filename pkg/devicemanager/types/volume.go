@@ -33,3 +33,11 @@ type LvInfo struct {
 	LVAttr        string  `json:"lvAttr"`
 	LVActive      string  `json:"lvActive"`
 }
+
+// PVSegment 一个pv上属于某个lv的一段空间，空闲段的LVName为空
+type PVSegment struct {
+	PVName  string `json:"pvName"`
+	VGName  string `json:"vgName"`
+	LVName  string `json:"lvName"`
+	SegSize uint64 `json:"segSize"`
+}
@@ -17,7 +17,10 @@
 package volume
 
 import (
+	"time"
+
 	"github.com/carina-io/carina/api"
+	"github.com/carina-io/carina/pkg/devicemanager/device"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 )
 
@@ -25,14 +28,19 @@ const (
 	THIN     = "thin-"
 	SNAP     = "snap-"
 	LVVolume = "volume-"
+	// SPARE 预先创建并格式化的备用卷，名称为spare-<fstype>-<id>
+	SPARE = "spare-"
 )
 
 // LocalVolume 本接口负责对外提供方法
 // 处理业务逻辑并调用lvm接口
 type LocalVolume interface {
-	CreateVolume(lvName, vgName string, size, ratio uint64) error
+	CreateVolume(lvName, vgName string, size, ratio uint64, stripes uint, stripeSize string) error
 	DeleteVolume(lvName, vgName string) error
-	ResizeVolume(lvName, vgName string, size, ratio uint64) error
+	// DeleteVolumes 批量删除同一vg中的多个卷，卷和池子各只调用一次lvremove
+	DeleteVolumes(lvNames []string, vgName string) error
+	WipeVolume(lvName, vgName string, wipe device.Wipe) error
+	ResizeVolume(lvName, vgName string, size, ratio uint64, stripes uint) error
 	VolumeList(lvName, vgName string) ([]types.LvInfo, error)
 	VolumeInfo(lvName, vgName string) (*types.LvInfo, error)
 
@@ -43,9 +51,21 @@ type LocalVolume interface {
 
 	CloneVolume(lvName, vgName, newLvName string) error
 
+	// CreateSpare 创建并格式化一个备用卷
+	CreateSpare(vgName string, size uint64, fsType string) error
+	// AdoptSpare 将大小和文件系统相同的备用卷改名为lvName，没有可用的备用卷时返回false
+	AdoptSpare(lvName, vgName string, size uint64, fsType string) (bool, error)
+	DeleteSpare(lvName, vgName string) error
+	// SpareList 列出vg中的备用卷
+	SpareList(vgName string) ([]types.LvInfo, error)
+
 	// GetCurrentVgStruct 额外的方法
 	GetCurrentVgStruct() ([]api.VgGroup, error)
 	GetCurrentPvStruct() ([]api.PVInfo, error)
+	// VolumeFailureDomains 返回卷数据所在的故障域
+	VolumeFailureDomains(lvName, vgName string) ([]string, error)
+	// VolumeDevices 返回卷数据所在的磁盘
+	VolumeDevices(lvName, vgName string) ([]string, error)
 	AddNewDiskToVg(disk, vgName string) error
 	RemoveDiskInVg(disk, vgName string) error
 
@@ -58,6 +78,13 @@ type LocalVolume interface {
 
 	// CreateBcache bcache
 	CreateBcache(dev, cacheDev string, block, bucket string, cacheMode string) (*types.BcacheDeviceInfo, error)
+	// DeleteBcache 删除卷时停止bcache设备，不等待脏数据写回
 	DeleteBcache(dev, cacheDev string) error
+	// DetachBcache 卸载卷时把脏数据写回后端盘再停止bcache设备，超时返回*bcache.DirtyError，force时丢弃脏数据
+	DetachBcache(dev string, timeout time.Duration, force bool) error
 	BcacheDeviceInfo(dev string) (*types.BcacheDeviceInfo, error)
+	// DeviceStack 叠加在卷上的loop、bcache、crypt和dm设备，最外层在前
+	DeviceStack(dev string) ([]device.StackLayer, error)
+	// TeardownStack 自上而下删除叠加在卷上的设备
+	TeardownStack(dev string) error
 }
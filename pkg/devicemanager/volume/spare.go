@@ -0,0 +1,134 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils/log"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+// SPAREMUTEX 格式化备用卷期间不能领用，避免卷在mkfs时被挂载
+const SPAREMUTEX = "SpareMutex"
+
+// IsSpare reports whether the lv or thin pool belongs to a spare volume
+func IsSpare(lvName string) bool {
+	name := strings.TrimPrefix(strings.TrimPrefix(lvName, LVVolume), THIN)
+	return strings.HasPrefix(name, SPARE)
+}
+
+// SpareFsType returns the filesystem a spare volume was formatted with
+func SpareFsType(lvName string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(lvName, LVVolume), THIN)
+	if !strings.HasPrefix(name, SPARE) {
+		return ""
+	}
+	parts := strings.Split(strings.TrimPrefix(name, SPARE), "-")
+	if len(parts) != 2 {
+		return ""
+	}
+	return parts[0]
+}
+
+func (v *LocalVolumeImplement) CreateSpare(vgName string, size uint64, fsType string) error {
+	if !v.Mutex.TryAcquire(SPAREMUTEX) {
+		return errors.New("get spare mutex failed")
+	}
+	defer v.Mutex.Release(SPAREMUTEX)
+
+	name := fmt.Sprintf("%s%s-%s", SPARE, fsType, rand.String(8))
+	if err := v.CreateVolume(name, vgName, size, 1, 0, ""); err != nil {
+		return err
+	}
+	if err := v.Lv.LVFormat(LVVolume+name, vgName, fsType); err != nil {
+		log.Errorf("format spare volume %s/%s failed %s", vgName, name, err.Error())
+		if err2 := v.DeleteVolume(name, vgName); err2 != nil {
+			log.Errorf("delete spare volume %s/%s failed %s", vgName, name, err2.Error())
+		}
+		return err
+	}
+	log.Infof("created spare volume %s/%s size %d fstype %s", vgName, name, size, fsType)
+	return nil
+}
+
+// AdoptSpare 先改名thin pool再改名卷，中途失败时重试会找到已改名的pool并完成卷的改名
+func (v *LocalVolumeImplement) AdoptSpare(lvName, vgName string, size uint64, fsType string) (bool, error) {
+	if !v.Mutex.TryAcquire(SPAREMUTEX) {
+		log.Infof("spare volumes of %s are being replenished, create %s instead", vgName, lvName)
+		return false, nil
+	}
+	defer v.Mutex.Release(SPAREMUTEX)
+
+	lvs, err := v.Lv.LVS(vgName)
+	if err != nil {
+		return false, err
+	}
+	thinName := THIN + lvName
+	for _, lv := range lvs {
+		if lv.PoolLV == thinName && IsSpare(lv.LVName) {
+			log.Infof("resume adopting spare volume %s/%s as %s", vgName, lv.LVName, lvName)
+			return true, v.Lv.LVRename(lv.LVName, LVVolume+lvName, vgName)
+		}
+	}
+
+	for _, lv := range lvs {
+		if !strings.HasPrefix(lv.LVName, LVVolume+SPARE) || lv.LVSize != size || SpareFsType(lv.LVName) != fsType {
+			continue
+		}
+		if lv.PoolLV != THIN+strings.TrimPrefix(lv.LVName, LVVolume) {
+			continue
+		}
+		if err := v.Lv.LVRename(lv.PoolLV, thinName, vgName); err != nil {
+			return false, err
+		}
+		if err := v.Lv.LVRename(lv.LVName, LVVolume+lvName, vgName); err != nil {
+			return true, err
+		}
+		log.Infof("adopted spare volume %s/%s as %s", vgName, lv.LVName, lvName)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (v *LocalVolumeImplement) DeleteSpare(lvName, vgName string) error {
+	if !v.Mutex.TryAcquire(SPAREMUTEX) {
+		return errors.New("get spare mutex failed")
+	}
+	defer v.Mutex.Release(SPAREMUTEX)
+	if !IsSpare(lvName) {
+		return fmt.Errorf("%s is not a spare volume", lvName)
+	}
+	return v.DeleteVolume(lvName, vgName)
+}
+
+func (v *LocalVolumeImplement) SpareList(vgName string) ([]types.LvInfo, error) {
+	lvs, err := v.Lv.LVS(vgName)
+	if err != nil {
+		return nil, err
+	}
+	spares := []types.LvInfo{}
+	for _, lv := range lvs {
+		if strings.HasPrefix(lv.LVName, LVVolume+SPARE) && lv.PoolLV == THIN+strings.TrimPrefix(lv.LVName, LVVolume) {
+			spares = append(spares, lv)
+		}
+	}
+	return spares, nil
+}
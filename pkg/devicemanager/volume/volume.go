@@ -20,15 +20,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/carina-io/carina/api"
 
+	"github.com/carina-io/carina/pkg/configuration"
 	"github.com/carina-io/carina/pkg/devicemanager/bcache"
+	"github.com/carina-io/carina/pkg/devicemanager/device"
 	"github.com/carina-io/carina/pkg/devicemanager/lvmd"
 	"github.com/carina-io/carina/pkg/devicemanager/types"
 	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/exec"
 	"github.com/carina-io/carina/utils/log"
 	"github.com/carina-io/carina/utils/mutx"
 	"google.golang.org/grpc/codes"
@@ -37,14 +41,18 @@ import (
 
 const VOLUMEMUTEX = "VolumeMutex"
 
+// teardownStack 删除叠加在卷上的设备，测试中替换
+var teardownStack = device.TeardownStack
+
 type LocalVolumeImplement struct {
 	Lv              lvmd.Lvm2
 	Bcache          bcache.Bcache
+	Executor        exec.Executor
 	Mutex           *mutx.GlobalLocks
 	NoticeServerMap map[string]chan struct{}
 }
 
-func (v *LocalVolumeImplement) CreateVolume(lvName, vgName string, size, ratio uint64) error {
+func (v *LocalVolumeImplement) CreateVolume(lvName, vgName string, size, ratio uint64, stripes uint, stripeSize string) error {
 	if !v.Mutex.TryAcquire(VOLUMEMUTEX) {
 		log.Info("wait other task release mutex, please retry...")
 		return errors.New("get global mutex failed")
@@ -79,8 +87,11 @@ func (v *LocalVolumeImplement) CreateVolume(lvName, vgName string, size, ratio u
 
 	thinInfo, _ := v.Lv.LVDisplay(thinName, vgName)
 	if thinInfo == nil {
+		if err := v.checkStripedSpace(vgName, sizePool, stripes); err != nil {
+			return err
+		}
 		// 首先创建thin pool
-		if err := v.Lv.CreateThinPool(thinName, vgName, sizePool); err != nil {
+		if err := v.Lv.CreateThinPool(thinName, vgName, sizePool, stripes, stripeSize); err != nil {
 			log.Errorf("create thin pool failed %s", err.Error())
 			return err
 		}
@@ -94,6 +105,31 @@ func (v *LocalVolumeImplement) CreateVolume(lvName, vgName string, size, ratio u
 	return nil
 }
 
+// checkStripedSpace 条带卷的每个条带需要在不同的pv上分配同样大小的空间
+// vg剩余容量充足并不代表条带卷可以创建，提前给出明确的错误
+func (v *LocalVolumeImplement) checkStripedSpace(vgName string, size uint64, stripes uint) error {
+	if stripes < 2 {
+		return nil
+	}
+	pvs, err := v.Lv.PVS()
+	if err != nil {
+		return err
+	}
+	free := []uint64{}
+	for _, pv := range pvs {
+		if pv.VGName == vgName {
+			free = append(free, pv.PVFree)
+		}
+	}
+	if len(free) < int(stripes) {
+		return fmt.Errorf("%s has %d physical volumes, can not stripe across %d", vgName, len(free), stripes)
+	}
+	if capacity := utils.StripedCapacity(free, int(stripes)); capacity < size {
+		return fmt.Errorf("%s can allocate %d bytes striped across %d physical volumes, %d bytes requested", vgName, capacity, stripes, size)
+	}
+	return nil
+}
+
 func (v *LocalVolumeImplement) DeleteVolume(lvName, vgName string) error {
 	if !v.Mutex.TryAcquire(VOLUMEMUTEX) {
 		log.Info("wait other task release mutex, please retry...")
@@ -110,6 +146,13 @@ func (v *LocalVolumeImplement) DeleteVolume(lvName, vgName string) error {
 	lvInfo, err := v.Lv.LVDisplay(name, vgName)
 	if err != nil && strings.Contains(err.Error(), "not found") {
 		log.Warnf("volume %s/%s not exist", vgName, lvName)
+		// 创建中断在thin pool和volume之间时只留下了空的thin pool
+		thinName := THIN + strings.TrimPrefix(name, LVVolume)
+		thinInfo, _ := v.Lv.LVDisplay(thinName, vgName)
+		if thinInfo != nil && thinInfo.ThinCount == 0 {
+			log.Infof("remove empty thin pool %s/%s", vgName, thinName)
+			return v.Lv.DeleteThinPool(thinName, vgName)
+		}
 		return nil
 	}
 	if err != nil {
@@ -118,6 +161,11 @@ func (v *LocalVolumeImplement) DeleteVolume(lvName, vgName string) error {
 	}
 	// delete bcache device if exists
 	_ = v.DeleteBcache(fmt.Sprintf("/dev/%s/%s", vgName, name), "")
+	// 删除失败时残留的loop、bcache、dm设备会导致lvremove失败
+	if err := v.TeardownStack(fmt.Sprintf("/dev/%s/%s", vgName, name)); err != nil {
+		log.Errorf("teardown devices stacked on %s/%s failed %s", vgName, name, err.Error())
+		return err
+	}
 	thinName := lvInfo.PoolLV
 	if err := v.Lv.LVRemove(name, vgName); err != nil {
 		return err
@@ -130,7 +178,56 @@ func (v *LocalVolumeImplement) DeleteVolume(lvName, vgName string) error {
 	return nil
 }
 
-func (v *LocalVolumeImplement) ResizeVolume(lvName, vgName string, size, ratio uint64) error {
+// DeleteVolumes 删除命名空间等批量回收的卷，不存在的卷直接跳过
+func (v *LocalVolumeImplement) DeleteVolumes(lvNames []string, vgName string) error {
+	if !v.Mutex.TryAcquire(VOLUMEMUTEX) {
+		log.Info("wait other task release mutex, please retry...")
+		return errors.New("get global mutex failed")
+	}
+	defer v.Mutex.Release(VOLUMEMUTEX)
+
+	lvs, err := v.Lv.LVS(vgName)
+	if err != nil {
+		log.Errorf("list volumes of %s failed %s", vgName, err.Error())
+		return err
+	}
+	existing := map[string]types.LvInfo{}
+	for _, lv := range lvs {
+		existing[lv.LVName] = lv
+	}
+
+	names := []string{}
+	pools := []string{}
+	for _, lvName := range lvNames {
+		name := lvName
+		if !strings.HasPrefix(lvName, LVVolume) {
+			name = LVVolume + lvName
+		}
+		lvInfo, ok := existing[name]
+		if !ok {
+			log.Warnf("volume %s/%s not exist", vgName, lvName)
+			continue
+		}
+		dev := fmt.Sprintf("/dev/%s/%s", vgName, name)
+		_ = v.DeleteBcache(dev, "")
+		if err := v.TeardownStack(dev); err != nil {
+			log.Errorf("teardown devices stacked on %s/%s failed %s", vgName, name, err.Error())
+			return err
+		}
+		names = append(names, name)
+		if lvInfo.PoolLV != "" {
+			pools = append(pools, lvInfo.PoolLV)
+		}
+	}
+
+	// 先删卷，再删各卷独占的池子
+	if err := v.Lv.LVRemoveBatch(names, vgName); err != nil {
+		return err
+	}
+	return v.Lv.LVRemoveBatch(pools, vgName)
+}
+
+func (v *LocalVolumeImplement) ResizeVolume(lvName, vgName string, size, ratio uint64, stripes uint) error {
 	if !v.Mutex.TryAcquire(VOLUMEMUTEX) {
 		log.Info("wait other task release mutex, please retry...")
 		return errors.New("get global mutex failed")
@@ -180,6 +277,9 @@ func (v *LocalVolumeImplement) ResizeVolume(lvName, vgName string, size, ratio u
 	}
 
 	if thinInfo.LVSize < size {
+		if err := v.checkStripedSpace(vgName, sizePool-thinInfo.LVSize, stripes); err != nil {
+			return err
+		}
 		if err := v.Lv.ResizeThinPool(thinName, vgName, sizePool); err != nil {
 			return err
 		}
@@ -200,6 +300,21 @@ func (v *LocalVolumeImplement) VolumeList(lvName, vgName string) ([]types.LvInfo
 	return v.Lv.LVS(name)
 }
 
+// WipeVolume 删除卷前擦除数据，耗时较长因此不持有全局锁
+func (v *LocalVolumeImplement) WipeVolume(lvName, vgName string, wipe device.Wipe) error {
+	name := lvName
+	if !strings.HasPrefix(lvName, LVVolume) {
+		name = LVVolume + lvName
+	}
+	if _, err := v.Lv.LVDisplay(name, vgName); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil
+		}
+		return err
+	}
+	return v.Lv.LVWipe(name, vgName, wipe)
+}
+
 func (v *LocalVolumeImplement) VolumeInfo(lvName, vgName string) (*types.LvInfo, error) {
 	lvs, err := v.VolumeList(lvName, vgName)
 	if err != nil {
@@ -215,6 +330,8 @@ func (v *LocalVolumeImplement) VolumeInfo(lvName, vgName string) (*types.LvInfo,
 	return nil, errors.New("not found")
 }
 
+// CreateSnapshot 在卷的thin pool中创建快照snap-<snapName>
+// 快照与卷共享thin pool，卷改写的数据块由pool另行分配，pool要为每个快照预留一个卷的容量，不足时先扩容pool
 func (v *LocalVolumeImplement) CreateSnapshot(snapName, lvName, vgName string) error {
 
 	if !v.Mutex.TryAcquire(VOLUMEMUTEX) {
@@ -223,10 +340,57 @@ func (v *LocalVolumeImplement) CreateSnapshot(snapName, lvName, vgName string) e
 	}
 	defer v.Mutex.Release(VOLUMEMUTEX)
 
-	// TODO: 检查pool容量是否剩余lv一倍，若是pool容量不足需要先扩容
+	name := SNAP + strings.TrimPrefix(snapName, SNAP)
+	volumeName := lvName
+	if !strings.HasPrefix(lvName, LVVolume) {
+		volumeName = LVVolume + lvName
+	}
+
+	snapInfo, _ := v.Lv.LVDisplay(name, vgName)
+	if snapInfo != nil && snapInfo.VGName == vgName {
+		log.Infof("%s/%s snapshot exists", vgName, name)
+		return nil
+	}
+
+	lvInfo, err := v.Lv.LVDisplay(volumeName, vgName)
+	if err != nil {
+		log.Errorf("get volume info failed %s/%s %s", vgName, volumeName, err.Error())
+		return err
+	}
+	thinInfo, err := v.Lv.LVDisplay(lvInfo.PoolLV, vgName)
+	if err != nil {
+		log.Errorf("get thin pool failed %s/%s %s", vgName, lvInfo.PoolLV, err.Error())
+		return err
+	}
+
+	lvs, err := v.Lv.LVS("")
+	if err != nil {
+		return err
+	}
+	snapshots := uint64(0)
+	for _, lv := range lvs {
+		if strings.HasPrefix(lv.LVName, SNAP) && lv.VGName == vgName && lv.PoolLV == lvInfo.PoolLV {
+			snapshots++
+		}
+	}
+
+	sizePool := lvInfo.LVSize * (snapshots + 2)
+	if thinInfo.LVSize < sizePool {
+		vgInfo, err := v.Lv.VGDisplay(vgName)
+		if err != nil {
+			log.Errorf("get device group info failed %s %s", vgName, err.Error())
+			return err
+		}
+		if vgInfo.VGFree < sizePool-thinInfo.LVSize+utils.DefaultReservedSpace/2 {
+			log.Warnf("%s don't have enough space for snapshot of %s, reserved 10 g", vgName, volumeName)
+			return errors.New("don't have enough space")
+		}
+		if err := v.Lv.ResizeThinPool(lvInfo.PoolLV, vgName, sizePool); err != nil {
+			return err
+		}
+	}
 
-	name := SNAP + snapName
-	if err := v.Lv.CreateSnapshot(name, lvName, vgName); err != nil {
+	if err := v.Lv.CreateSnapshot(name, volumeName, vgName); err != nil {
 		return err
 	}
 
@@ -240,10 +404,16 @@ func (v *LocalVolumeImplement) DeleteSnapshot(snapName, vgName string) error {
 		return errors.New("get global mutex failed")
 	}
 	defer v.Mutex.Release(VOLUMEMUTEX)
-	if err := v.Lv.DeleteSnapshot(snapName, vgName); err != nil {
-		return nil
+
+	name := SNAP + strings.TrimPrefix(snapName, SNAP)
+	if _, err := v.Lv.LVDisplay(name, vgName); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			log.Warnf("snapshot %s/%s not exist", vgName, name)
+			return nil
+		}
+		return err
 	}
-	return nil
+	return v.Lv.DeleteSnapshot(name, vgName)
 }
 
 func (v *LocalVolumeImplement) RestoreSnapshot(snapName, vgName string) error {
@@ -290,7 +460,7 @@ func (v *LocalVolumeImplement) CloneVolume(lvName, vgName, newLvName string) err
 	size := uint64(100)
 	// 创建thin pool
 	thinName := THIN + lvName
-	if err := v.Lv.CreateThinPool(thinName, vgName, size); err != nil {
+	if err := v.Lv.CreateThinPool(thinName, vgName, size, 0, ""); err != nil {
 		return err
 	}
 	name := LVVolume + lvName
@@ -346,6 +516,64 @@ func (v *LocalVolumeImplement) GetCurrentPvStruct() ([]api.PVInfo, error) {
 	return v.Lv.PVS()
 }
 
+// VolumeFailureDomains 返回存放卷数据的pv所在的故障域
+func (v *LocalVolumeImplement) VolumeFailureDomains(lvName, vgName string) ([]string, error) {
+	pvs, err := v.volumePVs(lvName, vgName)
+	if err != nil {
+		return nil, err
+	}
+	domains := []string{}
+	for _, pv := range pvs {
+		if pv.FailureDomain != "" && !utils.ContainsString(domains, pv.FailureDomain) {
+			domains = append(domains, pv.FailureDomain)
+		}
+	}
+	sort.Strings(domains)
+	return domains, nil
+}
+
+// VolumeDevices 返回存放卷数据的pv，例如/dev/sdb
+func (v *LocalVolumeImplement) VolumeDevices(lvName, vgName string) ([]string, error) {
+	pvs, err := v.volumePVs(lvName, vgName)
+	if err != nil {
+		return nil, err
+	}
+	devices := []string{}
+	for _, pv := range pvs {
+		devices = append(devices, pv.PVName)
+	}
+	sort.Strings(devices)
+	return devices, nil
+}
+
+// volumePVs 返回存放卷数据的pv
+// The data of a volume lives in its thin pool, so every pv holding a segment of
+// the pool or the volume counts, e.g. [thin-pvc-1_tdata] for pvc-1.
+func (v *LocalVolumeImplement) volumePVs(lvName, vgName string) ([]api.PVInfo, error) {
+	lvName = strings.TrimPrefix(lvName, LVVolume)
+	pvs, err := v.Lv.PVS()
+	if err != nil {
+		return nil, err
+	}
+	result := []api.PVInfo{}
+	for _, pv := range pvs {
+		if pv.VGName != vgName {
+			continue
+		}
+		segments, err := v.Lv.PVSegments(pv.PVName)
+		if err != nil {
+			return nil, err
+		}
+		for _, seg := range segments {
+			if seg.LVName != "" && strings.Contains(seg.LVName, lvName) {
+				result = append(result, pv)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
 func (v *LocalVolumeImplement) AddNewDiskToVg(disk, vgName string) error {
 	vgName = strings.ToLower(vgName)
 	if !v.Mutex.TryAcquire(VOLUMEMUTEX) {
@@ -481,8 +709,13 @@ func (v *LocalVolumeImplement) HealthCheck() {
 		case <-ctx.Done():
 			log.Info("volume health check timeout.")
 		default:
-			_ = v.Lv.RemoveUnknownDevice(utils.DeviceVGHDD)
-			_ = v.Lv.RemoveUnknownDevice(utils.DeviceVGSSD)
+			// 配置中的磁盘组不限于ssd/hdd，逐个检查lvm磁盘组
+			for _, ds := range configuration.DiskSelector() {
+				if strings.ToLower(ds.Policy) == "raw" {
+					continue
+				}
+				_ = v.Lv.RemoveUnknownDevice(ds.Name)
+			}
 			return
 		}
 	}
@@ -565,13 +798,17 @@ func (v *LocalVolumeImplement) CreateBcache(dev, cacheDev string, block, bucket
 }
 
 func (v *LocalVolumeImplement) DeleteBcache(dev, cacheDev string) error {
+	return v.DetachBcache(dev, 0, true)
+}
+
+func (v *LocalVolumeImplement) DetachBcache(dev string, timeout time.Duration, force bool) error {
 
 	deviceInfo, err := v.BcacheDeviceInfo(dev)
 	if err != nil {
 		log.Errorf("get device info error %s %s", dev, err.Error())
 		return err
 	}
-	err = v.Bcache.RemoveBcache(deviceInfo)
+	err = v.Bcache.RemoveBcache(deviceInfo, timeout, force)
 
 	if err != nil {
 		log.Errorf("delete cache device failed %s", err.Error())
@@ -580,6 +817,15 @@ func (v *LocalVolumeImplement) DeleteBcache(dev, cacheDev string) error {
 	return nil
 }
 
+func (v *LocalVolumeImplement) DeviceStack(dev string) ([]device.StackLayer, error) {
+	return device.DeviceStack(dev)
+}
+
+// TeardownStack 自上而下删除叠加在卷上的设备
+func (v *LocalVolumeImplement) TeardownStack(dev string) error {
+	return teardownStack(v.Executor, dev)
+}
+
 func (v *LocalVolumeImplement) BcacheDeviceInfo(dev string) (*types.BcacheDeviceInfo, error) {
 	bcacheInfo, err := v.Bcache.ShowDevice(dev)
 	if err != nil {
@@ -0,0 +1,131 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/carina-io/carina/pkg/devicemanager/bcache"
+	"github.com/carina-io/carina/pkg/devicemanager/lvmd"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils/exec"
+	"github.com/carina-io/carina/utils/mutx"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLvm 记录批量删除的卷
+type fakeLvm struct {
+	lvmd.Lvm2
+	lvs     []types.LvInfo
+	removed [][]string
+}
+
+func (l *fakeLvm) LVS(lvName string) ([]types.LvInfo, error) {
+	return l.lvs, nil
+}
+
+func (l *fakeLvm) LVRemoveBatch(lvs []string, vg string) error {
+	l.removed = append(l.removed, lvs)
+	return nil
+}
+
+// fakeBcache 卷上没有bcache设备
+type fakeBcache struct {
+	bcache.Bcache
+}
+
+func (b *fakeBcache) ShowDevice(dev string) (*types.BcacheDeviceInfo, error) {
+	return nil, errors.New("not a bcache device")
+}
+
+func TestDeleteVolumes(t *testing.T) {
+	lvs := []types.LvInfo{
+		{LVName: "volume-pvc-1", VGName: "carina-vg-ssd", PoolLV: "thin-pvc-1"},
+		{LVName: "thin-pvc-1", VGName: "carina-vg-ssd"},
+		{LVName: "volume-pvc-2", VGName: "carina-vg-ssd", PoolLV: "thin-pvc-2"},
+		{LVName: "thin-pvc-2", VGName: "carina-vg-ssd"},
+		// 线性卷没有池子
+		{LVName: "volume-pvc-3", VGName: "carina-vg-ssd"},
+	}
+	origin := teardownStack
+	defer func() { teardownStack = origin }()
+
+	table := []struct {
+		name        string
+		lvNames     []string
+		teardownErr error
+		locked      bool
+		torndown    []string
+		removed     [][]string
+		expectErr   bool
+	}{
+		{
+			name:     "volumes before pools",
+			lvNames:  []string{"pvc-1", "volume-pvc-2", "pvc-3"},
+			torndown: []string{"/dev/carina-vg-ssd/volume-pvc-1", "/dev/carina-vg-ssd/volume-pvc-2", "/dev/carina-vg-ssd/volume-pvc-3"},
+			removed:  [][]string{{"volume-pvc-1", "volume-pvc-2", "volume-pvc-3"}, {"thin-pvc-1", "thin-pvc-2"}},
+		},
+		{
+			name:     "missing volumes skipped",
+			lvNames:  []string{"pvc-9", "pvc-2"},
+			torndown: []string{"/dev/carina-vg-ssd/volume-pvc-2"},
+			removed:  [][]string{{"volume-pvc-2"}, {"thin-pvc-2"}},
+		},
+		{
+			name:     "nothing to remove",
+			lvNames:  []string{"pvc-9"},
+			torndown: []string{},
+			removed:  [][]string{{}, {}},
+		},
+		{
+			// 叠加设备删不掉时不执行lvremove
+			name:        "teardown failed",
+			lvNames:     []string{"pvc-1", "pvc-2"},
+			teardownErr: errors.New("device busy"),
+			torndown:    []string{"/dev/carina-vg-ssd/volume-pvc-1"},
+			expectErr:   true,
+		},
+		{
+			name:      "mutex held",
+			lvNames:   []string{"pvc-1"},
+			locked:    true,
+			torndown:  []string{},
+			expectErr: true,
+		},
+	}
+
+	for _, c := range table {
+		t.Run(c.name, func(t *testing.T) {
+			torndown := []string{}
+			teardownStack = func(executor exec.Executor, dev string) error {
+				torndown = append(torndown, dev)
+				return c.teardownErr
+			}
+			lvm := &fakeLvm{lvs: lvs}
+			v := &LocalVolumeImplement{Lv: lvm, Bcache: &fakeBcache{}, Mutex: mutx.NewGlobalLocks()}
+			if c.locked {
+				assert.True(t, v.Mutex.TryAcquire(VOLUMEMUTEX))
+			}
+
+			err := v.DeleteVolumes(c.lvNames, "carina-vg-ssd")
+			assert.Equal(t, c.expectErr, err != nil, err)
+			assert.Equal(t, c.torndown, torndown)
+			assert.Equal(t, c.removed, lvm.removed)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package diskimpact 根据节点和磁盘找出数据落在该盘上的LogicVolume、PVC和Pod
+package diskimpact
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/utils"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// RoleData 卷的数据在盘上，包括跨盘的条带和raid卷的一条腿
+	RoleData = "data"
+	// RoleCache bcache卷的缓存卷在盘上
+	RoleCache = "cache"
+	// RoleBacking bcache卷的后端卷在盘上
+	RoleBacking = "backing"
+	// RoleSnapshot 快照与源卷共用thin pool，数据在盘上
+	RoleSnapshot = "snapshot"
+)
+
+// Volume is a LogicVolume with data on the disk and the PVC and pods using it
+type Volume struct {
+	LogicVolume      string   `json:"logicVolume"`
+	DeviceGroup      string   `json:"deviceGroup"`
+	Role             string   `json:"role"`
+	Devices          []string `json:"devices"`
+	PersistentVolume string   `json:"persistentVolume,omitempty"`
+	Namespace        string   `json:"namespace,omitempty"`
+	PVC              string   `json:"pvc,omitempty"`
+	Pods             []string `json:"pods,omitempty"`
+}
+
+// ResolveDisk 将磁盘标识转换为设备路径
+// The disk is a kernel name like sdb, a path like /dev/sdb, or a /dev/disk/by-id link, serial
+// or wwn of a raw disk listed in the NodeStorageResource. Disks of volume groups are only known by path.
+func ResolveDisk(disk string, nsr *carinav1beta1.NodeStorageResource) string {
+	if nsr != nil {
+		for _, d := range nsr.Status.Disks {
+			props := d.UdevInfo.Properties
+			if disk == props["ID_SERIAL"] || disk == props["ID_SERIAL_SHORT"] || disk == props["ID_WWN"] || utils.ContainsString(d.UdevInfo.Symlinks, disk) {
+				return d.Path
+			}
+		}
+	}
+	if !strings.HasPrefix(disk, "/") {
+		return filepath.Join("/dev", disk)
+	}
+	return disk
+}
+
+// OnDisk reports whether device is the disk or one of its partitions, e.g. /dev/sdb1 or /dev/nvme0n1p1
+func OnDisk(device, disk string) bool {
+	if device == disk {
+		return true
+	}
+	rest := strings.TrimPrefix(device, disk)
+	if rest == device || rest == "" {
+		return false
+	}
+	// 名字以数字结尾的盘，分区前有p，例如nvme0n1p1
+	if last := disk[len(disk)-1]; last >= '0' && last <= '9' {
+		if !strings.HasPrefix(rest, "p") {
+			return false
+		}
+		rest = rest[1:]
+	}
+	if rest == "" {
+		return false
+	}
+	for _, c := range rest {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Find 列出节点上数据落在disk上的LogicVolume，以及使用它们的PV、PVC和Pod
+// The disks of a LogicVolume are recorded in its status by the node when the volume is created.
+// A bcache volume consists of two LogicVolumes, the one on the disk is reported as cache or backing.
+func Find(node, disk string, lvs []carinav1.LogicVolume, pvs []corev1.PersistentVolume, pods []corev1.Pod) []Volume {
+	byHandle := map[string]*corev1.PersistentVolume{}
+	byCache := map[string]*corev1.PersistentVolume{}
+	for i := range pvs {
+		pv := &pvs[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != utils.CSIPluginName {
+			continue
+		}
+		byHandle[pv.Spec.CSI.VolumeHandle] = pv
+		if id := pv.Spec.CSI.VolumeAttributes[utils.VolumeCacheId]; id != "" {
+			byCache[id] = pv
+		}
+	}
+	claimPods := map[string][]string{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName != node || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, v := range pod.Spec.Volumes {
+			if v.PersistentVolumeClaim != nil {
+				key := pod.Namespace + "/" + v.PersistentVolumeClaim.ClaimName
+				claimPods[key] = append(claimPods[key], pod.Name)
+			}
+		}
+	}
+
+	volumes := []Volume{}
+	for _, lv := range lvs {
+		if lv.Spec.NodeName != node {
+			continue
+		}
+		onDisk := false
+		for _, d := range lv.Status.Devices {
+			if OnDisk(d, disk) {
+				onDisk = true
+				break
+			}
+		}
+		if !onDisk {
+			continue
+		}
+		v := Volume{LogicVolume: lv.Name, DeviceGroup: lv.Spec.DeviceGroup, Role: RoleData, Devices: lv.Status.Devices}
+		pv := byHandle[lv.Status.VolumeID]
+		switch {
+		case lv.Annotations[utils.SnapshotSource] != "":
+			v.Role = RoleSnapshot
+		case pv != nil && pv.Spec.CSI.VolumeAttributes[utils.VolumeCacheDiskType] != "":
+			v.Role = RoleBacking
+		case byCache[lv.Status.VolumeID] != nil:
+			pv = byCache[lv.Status.VolumeID]
+			v.Role = RoleCache
+		}
+		if pv != nil && v.Role != RoleSnapshot {
+			v.PersistentVolume = pv.Name
+			if pv.Spec.ClaimRef != nil {
+				v.Namespace = pv.Spec.ClaimRef.Namespace
+				v.PVC = pv.Spec.ClaimRef.Name
+				v.Pods = claimPods[v.Namespace+"/"+v.PVC]
+				sort.Strings(v.Pods)
+			}
+		}
+		volumes = append(volumes, v)
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].LogicVolume < volumes[j].LogicVolume
+	})
+	return volumes
+}
@@ -0,0 +1,123 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package diskimpact
+
+import (
+	"testing"
+
+	"github.com/carina-io/carina/api"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOnDisk(t *testing.T) {
+	a := assert.New(t)
+	a.True(OnDisk("/dev/sdb", "/dev/sdb"))
+	a.True(OnDisk("/dev/sdb1", "/dev/sdb"))
+	a.False(OnDisk("/dev/sdbb", "/dev/sdb"))
+	a.False(OnDisk("/dev/sdc", "/dev/sdb"))
+	a.True(OnDisk("/dev/nvme0n1p2", "/dev/nvme0n1"))
+	a.False(OnDisk("/dev/nvme0n12", "/dev/nvme0n1"))
+	a.False(OnDisk("/dev/sdb", ""))
+}
+
+func TestResolveDisk(t *testing.T) {
+	a := assert.New(t)
+	nsr := &carinav1beta1.NodeStorageResource{Status: carinav1beta1.NodeStorageResourceStatus{Disks: []api.Disk{{
+		Path: "/dev/sdd",
+		UdevInfo: api.UdevInfo{
+			Symlinks:   []string{"/dev/disk/by-id/wwn-0x5000c500a1b2c3d4"},
+			Properties: map[string]string{"ID_SERIAL": "ST4000NM0035_ZC1ABCDE", "ID_WWN": "0x5000c500a1b2c3d4"},
+		},
+	}}}}
+	a.Equal("/dev/sdb", ResolveDisk("sdb", nil))
+	a.Equal("/dev/sdb", ResolveDisk("/dev/sdb", nsr))
+	a.Equal("/dev/sdd", ResolveDisk("ST4000NM0035_ZC1ABCDE", nsr))
+	a.Equal("/dev/sdd", ResolveDisk("0x5000c500a1b2c3d4", nsr))
+	a.Equal("/dev/sdd", ResolveDisk("/dev/disk/by-id/wwn-0x5000c500a1b2c3d4", nsr))
+}
+
+func TestFind(t *testing.T) {
+	a := assert.New(t)
+	lv := func(name, node, volumeID string, devices ...string) carinav1.LogicVolume {
+		return carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       carinav1.LogicVolumeSpec{NodeName: node, DeviceGroup: "carina-vg-hdd"},
+			Status:     carinav1.LogicVolumeStatus{VolumeID: volumeID, Devices: devices},
+		}
+	}
+	pv := func(name, handle, pvc string, attributes map[string]string) corev1.PersistentVolume {
+		return corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+					Driver: utils.CSIPluginName, VolumeHandle: handle, VolumeAttributes: attributes,
+				}},
+				ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: pvc},
+			},
+		}
+	}
+	pod := func(name, node, pvc string, phase corev1.PodPhase) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: corev1.PodSpec{NodeName: node, Volumes: []corev1.Volume{{
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc}},
+			}}},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+	}
+
+	snapshot := lv("snap-1", "node1", "volume-snap-1", "/dev/sdb")
+	snapshot.Annotations = map[string]string{utils.SnapshotSource: "pvc-striped"}
+	lvs := []carinav1.LogicVolume{
+		lv("pvc-striped", "node1", "volume-pvc-striped", "/dev/sdb", "/dev/sdc"),
+		lv("pvc-other", "node1", "volume-pvc-other", "/dev/sdc"),
+		lv("pvc-remote", "node2", "volume-pvc-remote", "/dev/sdb"),
+		lv("pvc-cached", "node1", "volume-pvc-cached", "/dev/sdd"),
+		lv("cache-pvc-cached", "node1", "volume-cache-pvc-cached", "/dev/sdb1"),
+		snapshot,
+	}
+	pvs := []corev1.PersistentVolume{
+		pv("pvc-striped", "volume-pvc-striped", "data-0", nil),
+		pv("pvc-other", "volume-pvc-other", "data-1", nil),
+		pv("pvc-cached", "volume-pvc-cached", "cached", map[string]string{
+			utils.VolumeCacheDiskType: "carina-vg-ssd", utils.VolumeCacheId: "volume-cache-pvc-cached",
+		}),
+	}
+	pods := []corev1.Pod{
+		pod("web-0", "node1", "data-0", corev1.PodRunning),
+		pod("job-0", "node1", "data-0", corev1.PodSucceeded),
+		pod("db-0", "node1", "cached", corev1.PodRunning),
+	}
+
+	a.Equal([]Volume{
+		{LogicVolume: "cache-pvc-cached", DeviceGroup: "carina-vg-hdd", Role: RoleCache, Devices: []string{"/dev/sdb1"},
+			PersistentVolume: "pvc-cached", Namespace: "default", PVC: "cached", Pods: []string{"db-0"}},
+		{LogicVolume: "pvc-striped", DeviceGroup: "carina-vg-hdd", Role: RoleData, Devices: []string{"/dev/sdb", "/dev/sdc"},
+			PersistentVolume: "pvc-striped", Namespace: "default", PVC: "data-0", Pods: []string{"web-0"}},
+		{LogicVolume: "snap-1", DeviceGroup: "carina-vg-hdd", Role: RoleSnapshot, Devices: []string{"/dev/sdb"}},
+	}, Find("node1", "/dev/sdb", lvs, pvs, pods))
+
+	backing := Find("node1", "/dev/sdd", lvs, pvs, pods)
+	a.Len(backing, 1)
+	a.Equal(RoleBacking, backing[0].Role)
+	a.Empty(Find("node1", "/dev/sde", lvs, pvs, pods))
+}
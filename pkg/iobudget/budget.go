@@ -0,0 +1,110 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package iobudget
+
+import (
+	"math/bits"
+	"sort"
+)
+
+const (
+	// DistributionEven 每个设备分得相同的额度
+	DistributionEven = "even"
+	// DistributionCapacity 按卷的容量比例分配额度
+	DistributionCapacity = "capacity"
+)
+
+// Device is the block device of one carina volume mounted by a pod
+type Device struct {
+	// Key is the major:minor of the device, as written to the blkio cgroup files
+	Key string
+	// Capacity of the volume in bytes
+	Capacity int64
+}
+
+// Split 将Pod的总额度分配到它挂载的各个设备上，各设备的额度之和等于总额度
+// Every device gets at least 1, a limit of 0 removes the throttle from the cgroup, so a total
+// smaller than the number of devices is exceeded. Remainders go to the devices in key order.
+func Split(total uint64, devices []Device, distribution string) map[string]uint64 {
+	result := map[string]uint64{}
+	if len(devices) == 0 {
+		return result
+	}
+	sorted := append([]Device{}, devices...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+
+	var sum uint64
+	for _, d := range sorted {
+		if d.Capacity > 0 {
+			sum += uint64(d.Capacity)
+		}
+	}
+	if distribution != DistributionCapacity || sum == 0 {
+		for i := range sorted {
+			sorted[i].Capacity = 1
+		}
+		sum = uint64(len(sorted))
+	}
+
+	var assigned uint64
+	for _, d := range sorted {
+		var share uint64
+		if d.Capacity > 0 {
+			// total*capacity可能溢出uint64，商不会超过total
+			hi, lo := bits.Mul64(total, uint64(d.Capacity))
+			share, _ = bits.Div64(hi, lo, sum)
+		}
+		result[d.Key] = share
+		assigned += share
+	}
+	for i := 0; assigned < total; i = (i + 1) % len(sorted) {
+		result[sorted[i].Key]++
+		assigned++
+	}
+	for k, v := range result {
+		if v == 0 {
+			result[k] = 1
+		}
+	}
+	return result
+}
+
+// Limits 计算一个blkio限速文件中各设备的限速值
+// perDevice is the limit of every device and total the budget of the pod split across the
+// devices, either may be nil. With both set a device gets the smaller one. Devices missing from
+// the result are not throttled.
+func Limits(perDevice, total *uint64, devices []Device, distribution string) map[string]uint64 {
+	result := map[string]uint64{}
+	var shares map[string]uint64
+	if total != nil {
+		shares = Split(*total, devices, distribution)
+	}
+	for _, d := range devices {
+		switch {
+		case perDevice != nil && total != nil:
+			result[d.Key] = shares[d.Key]
+			if *perDevice < shares[d.Key] {
+				result[d.Key] = *perDevice
+			}
+		case perDevice != nil:
+			result[d.Key] = *perDevice
+		case total != nil:
+			result[d.Key] = shares[d.Key]
+		}
+	}
+	return result
+}
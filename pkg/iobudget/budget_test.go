@@ -0,0 +1,54 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package iobudget
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	a := assert.New(t)
+	devices := []Device{
+		{Key: "253:2", Capacity: 30 << 30},
+		{Key: "253:1", Capacity: 10 << 30},
+	}
+
+	a.Equal(map[string]uint64{}, Split(100, nil, DistributionEven))
+	a.Equal(map[string]uint64{"253:1": 51, "253:2": 50}, Split(101, devices, DistributionEven))
+	a.Equal(map[string]uint64{"253:1": 25, "253:2": 75}, Split(100, devices, DistributionCapacity))
+	a.Equal(map[string]uint64{"253:1": 26, "253:2": 75}, Split(101, devices, DistributionCapacity))
+	// 总额度小于设备数量时每个设备至少为1
+	a.Equal(map[string]uint64{"253:1": 1, "253:2": 1}, Split(1, devices, DistributionCapacity))
+	// 没有容量时平均分配
+	a.Equal(map[string]uint64{"253:1": 5, "253:2": 5}, Split(10, []Device{{Key: "253:1"}, {Key: "253:2"}}, DistributionCapacity))
+	// total*capacity溢出uint64
+	big := Split(10<<30, []Device{{Key: "253:1", Capacity: 10 << 40}, {Key: "253:2", Capacity: 30 << 40}}, DistributionCapacity)
+	a.Equal(map[string]uint64{"253:1": 10 << 28, "253:2": 30 << 28}, big)
+}
+
+func TestLimits(t *testing.T) {
+	a := assert.New(t)
+	devices := []Device{{Key: "253:1"}, {Key: "253:2"}}
+	u := func(v uint64) *uint64 { return &v }
+
+	a.Equal(map[string]uint64{}, Limits(nil, nil, devices, DistributionEven))
+	a.Equal(map[string]uint64{"253:1": 70, "253:2": 70}, Limits(u(70), nil, devices, DistributionEven))
+	a.Equal(map[string]uint64{"253:1": 50, "253:2": 50}, Limits(nil, u(100), devices, DistributionEven))
+	a.Equal(map[string]uint64{"253:1": 40, "253:2": 40}, Limits(u(40), u(100), devices, DistributionEven))
+}
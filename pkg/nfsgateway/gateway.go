@@ -0,0 +1,151 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package nfsgateway 通过集群内的nfs-ganesha导出共享卷，为carina卷提供ReadWriteMany
+// Every LogicVolume of a storage class with carina.storage.io/shared: "true" gets a gateway
+// Deployment on the node of the volume and a Service in front of it. The gateway formats the
+// volume on first use, mounts it and exports it over NFSv4, pods on any node mount the export
+// through the Service.
+package nfsgateway
+
+import (
+	"fmt"
+	"strings"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// ExportPath 网关导出的nfs路径
+	ExportPath = "/export"
+	// Port nfs服务端口
+	Port = 2049
+	// AppLabel 网关Deployment和Pod的app标签
+	AppLabel = "carina-nfs-gateway"
+	// VolumeLabel 网关所导出的LogicVolume
+	VolumeLabel = "carina.storage.io/logic-volume"
+	// lvPrefix lvm中卷名的前缀，与devicemanager/volume一致
+	lvPrefix = "volume-"
+)
+
+// gatewayScript 格式化（仅首次）并挂载卷，再以前台方式运行ganesha
+// The volume is unmounted when the pod stops so that the next gateway, or carina-node deleting
+// the volume, finds it unused.
+const gatewayScript = `set -e
+mkdir -p ` + ExportPath + ` /var/run/ganesha /etc/ganesha
+blkid "$DEVICE" >/dev/null 2>&1 || mkfs."$FSTYPE" "$DEVICE"
+mountpoint -q ` + ExportPath + ` || mount "$DEVICE" ` + ExportPath + `
+cat > /etc/ganesha/ganesha.conf <<CONF
+NFS_Core_Param { NFS_Protocols = 4; }
+NFSv4 { Grace_Period = 10; }
+EXPORT {
+  Export_Id = 1; Path = ` + ExportPath + `; Pseudo = ` + ExportPath + `;
+  Access_Type = RW; Squash = No_Root_Squash; SecType = sys; Protocols = 4; Transports = TCP;
+  FSAL { Name = VFS; }
+}
+CONF
+ganesha.nfsd -F -L /dev/stdout -f /etc/ganesha/ganesha.conf &
+pid=$!
+trap 'kill $pid; wait $pid; umount ` + ExportPath + `' TERM INT
+wait $pid
+`
+
+// Name 网关Deployment和Service的名称
+func Name(lv *carinav1.LogicVolume) string {
+	return "carina-nfs-" + strings.TrimPrefix(lv.Name, lvPrefix)
+}
+
+// Shared 卷是否通过nfs网关共享
+func Shared(lv *carinav1.LogicVolume) bool {
+	return lv.Annotations[utils.VolumeShared] == "true"
+}
+
+// devicePath lvm卷在节点上的设备路径
+func devicePath(lv *carinav1.LogicVolume) string {
+	name := lv.Name
+	if !strings.HasPrefix(name, lvPrefix) {
+		name = lvPrefix + name
+	}
+	return fmt.Sprintf("/dev/%s/%s", lv.Spec.DeviceGroup, name)
+}
+
+func labels(lv *carinav1.LogicVolume) map[string]string {
+	return map[string]string{"app": AppLabel, VolumeLabel: lv.Name}
+}
+
+// MutateDeployment 设置网关Deployment的期望状态，用于controllerutil.CreateOrUpdate
+// The pod is pinned to the node of the volume and replaced with the Recreate strategy, so that
+// two gateways never mount the volume at the same time.
+func MutateDeployment(d *appsv1.Deployment, lv *carinav1.LogicVolume, image string) {
+	replicas := int32(1)
+	privileged := true
+	fsType := lv.Annotations[utils.VolumeFsType]
+	if fsType == "" {
+		fsType = "ext4"
+	}
+	d.Labels = labels(lv)
+	d.Spec.Replicas = &replicas
+	d.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	d.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels(lv)}
+	d.Spec.Template.Labels = labels(lv)
+	d.Spec.Template.Spec.NodeName = lv.Spec.NodeName
+	d.Spec.Template.Spec.PriorityClassName = "system-node-critical"
+	d.Spec.Template.Spec.Tolerations = []corev1.Toleration{{Operator: corev1.TolerationOpExists}}
+	d.Spec.Template.Spec.Containers = []corev1.Container{{
+		Name:            "ganesha",
+		Image:           image,
+		Command:         []string{"/bin/sh", "-c", gatewayScript},
+		Env:             []corev1.EnvVar{{Name: "DEVICE", Value: devicePath(lv)}, {Name: "FSTYPE", Value: fsType}},
+		Ports:           []corev1.ContainerPort{{Name: "nfs", ContainerPort: Port, Protocol: corev1.ProtocolTCP}},
+		SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+		VolumeMounts:    []corev1.VolumeMount{{Name: "dev", MountPath: "/dev"}},
+		ReadinessProbe: &corev1.Probe{
+			ProbeHandler:  corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(Port)}},
+			PeriodSeconds: 5,
+		},
+	}}
+	d.Spec.Template.Spec.Volumes = []corev1.Volume{{
+		Name:         "dev",
+		VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/dev"}},
+	}}
+}
+
+// MutateService 设置网关Service的期望状态，ClusterIP由kubernetes分配后不变
+func MutateService(s *corev1.Service, lv *carinav1.LogicVolume) {
+	s.Labels = labels(lv)
+	s.Spec.Selector = labels(lv)
+	s.Spec.Ports = []corev1.ServicePort{{Name: "nfs", Port: Port, TargetPort: intstr.FromInt(Port), Protocol: corev1.ProtocolTCP}}
+}
+
+// Source 客户端挂载的nfs源，server为Service的ClusterIP
+func Source(server string) string {
+	return server + ":" + ExportPath
+}
+
+// MountOptions 客户端挂载参数，未指定版本时使用NFSv4.1
+func MountOptions(options []string) []string {
+	for _, o := range options {
+		if strings.HasPrefix(o, "vers=") || strings.HasPrefix(o, "nfsvers=") {
+			return options
+		}
+	}
+	return append(options, "nfsvers=4.1")
+}
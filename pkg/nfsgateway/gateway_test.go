@@ -0,0 +1,82 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nfsgateway
+
+import (
+	"testing"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func sharedVolume() *carinav1.LogicVolume {
+	lv := &carinav1.LogicVolume{}
+	lv.Name = "pvc-4c1f2b"
+	lv.Annotations = map[string]string{utils.VolumeShared: "true"}
+	lv.Spec.NodeName = "node-1"
+	lv.Spec.DeviceGroup = "carina-vg-hdd"
+	return lv
+}
+
+func TestName(t *testing.T) {
+	lv := sharedVolume()
+	assert.Equal(t, "carina-nfs-pvc-4c1f2b", Name(lv))
+	assert.Equal(t, "/dev/carina-vg-hdd/volume-pvc-4c1f2b", devicePath(lv))
+	assert.True(t, Shared(lv))
+
+	lv.Name = "volume-pvc-4c1f2b"
+	assert.Equal(t, "carina-nfs-pvc-4c1f2b", Name(lv))
+	assert.Equal(t, "/dev/carina-vg-hdd/volume-pvc-4c1f2b", devicePath(lv))
+
+	lv.Annotations = nil
+	assert.False(t, Shared(lv))
+}
+
+func TestMutateDeployment(t *testing.T) {
+	lv := sharedVolume()
+	d := &appsv1.Deployment{}
+	MutateDeployment(d, lv, "ganesha:v1")
+	assert.Equal(t, int32(1), *d.Spec.Replicas)
+	assert.Equal(t, appsv1.RecreateDeploymentStrategyType, d.Spec.Strategy.Type)
+	assert.Equal(t, "node-1", d.Spec.Template.Spec.NodeName)
+	assert.Equal(t, d.Spec.Selector.MatchLabels, d.Spec.Template.Labels)
+	c := d.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "ganesha:v1", c.Image)
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "DEVICE", Value: "/dev/carina-vg-hdd/volume-pvc-4c1f2b"},
+		{Name: "FSTYPE", Value: "ext4"},
+	}, c.Env)
+
+	lv.Annotations[utils.VolumeFsType] = "xfs"
+	MutateDeployment(d, lv, "ganesha:v1")
+	assert.Equal(t, "xfs", d.Spec.Template.Spec.Containers[0].Env[1].Value)
+
+	s := &corev1.Service{}
+	MutateService(s, lv)
+	assert.Equal(t, d.Spec.Template.Labels, s.Spec.Selector)
+	assert.Equal(t, int32(Port), s.Spec.Ports[0].Port)
+}
+
+func TestMountOptions(t *testing.T) {
+	assert.Equal(t, "10.96.0.12:/export", Source("10.96.0.12"))
+	assert.Equal(t, []string{"nfsvers=4.1"}, MountOptions(nil))
+	assert.Equal(t, []string{"noatime", "nfsvers=4.1"}, MountOptions([]string{"noatime"}))
+	assert.Equal(t, []string{"vers=3", "nolock"}, MountOptions([]string{"vers=3", "nolock"}))
+}
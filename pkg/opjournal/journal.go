@@ -0,0 +1,212 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package opjournal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"google.golang.org/grpc/codes"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Journal 节点本地的卷创建日志，节点在创建卷前记录，LogicVolume状态更新后删除
+// An entry left over after a crash of carina-node tells which volume may have been created
+// partially, so that the restarted carina-node either resumes the creation or removes what
+// was created, instead of leaking the volume or failing every retry of the LogicVolume.
+type Journal struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+// Step of a volume creation reached before the last journal write
+type Step string
+
+const (
+	// StepStarted the volume, its thin pool or partition may exist partially
+	StepStarted Step = "Started"
+	// StepCreated the volume exists, its data source is not restored yet
+	StepCreated Step = "Created"
+)
+
+// Entry creation of the volume of a LogicVolume
+type Entry struct {
+	// Name of the LogicVolume
+	Name string `json:"name"`
+	// UID of the LogicVolume, a LogicVolume recreated with the same name does not own the volume
+	UID         types.UID `json:"uid"`
+	VolumeType  string    `json:"volumeType"`
+	DeviceGroup string    `json:"deviceGroup"`
+	// SnapshotSource the volume is a snapshot of this volume, it is removed without its thin pool
+	SnapshotSource string `json:"snapshotSource,omitempty"`
+	// Restore the data of the volume is copied from a snapshot after creation
+	Restore bool      `json:"restore,omitempty"`
+	Step    Step      `json:"step"`
+	Started time.Time `json:"started"`
+}
+
+// Action decided for a journal entry
+type Action string
+
+const (
+	// ActionResume the LogicVolume still waits for the volume, creation continues with what exists
+	ActionResume Action = "Resume"
+	// ActionRollback the volume is removed, it is not wanted or its content is incomplete
+	ActionRollback Action = "Rollback"
+	// ActionComplete nothing to do, the entry is dropped
+	ActionComplete Action = "Complete"
+)
+
+// Open loads the journal file from dir, a missing file is an empty journal
+func Open(dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	j := &Journal{path: filepath.Join(dir, "operations.json"), entries: map[string]Entry{}}
+	content, err := os.ReadFile(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		j.entries[e.Name] = e
+	}
+	return j, nil
+}
+
+// Record adds or replaces the entry of a LogicVolume and writes the journal
+func (j *Journal) Record(e Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	old, ok := j.entries[e.Name]
+	j.entries[e.Name] = e
+	if err := j.save(); err != nil {
+		if ok {
+			j.entries[e.Name] = old
+		} else {
+			delete(j.entries, e.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Complete drops the entry of a LogicVolume, a missing entry is no error
+func (j *Journal) Complete(name string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	old, ok := j.entries[name]
+	if !ok {
+		return nil
+	}
+	delete(j.entries, name)
+	if err := j.save(); err != nil {
+		j.entries[name] = old
+		return err
+	}
+	return nil
+}
+
+// Get returns the entry of a LogicVolume
+func (j *Journal) Get(name string) (Entry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.entries[name]
+	return e, ok
+}
+
+// Entries returns all entries sorted by name
+func (j *Journal) Entries() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := make([]Entry, 0, len(j.entries))
+	for _, e := range j.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Name < entries[b].Name })
+	return entries
+}
+
+// save writes all entries, a crash leaves either the old or the new file
+func (j *Journal) save() error {
+	entries := make([]Entry, 0, len(j.entries))
+	for _, e := range j.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Name < entries[b].Name })
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
+
+// Decide 根据LogicVolume的当前状态决定未完成的创建如何收尾，lv为nil表示LogicVolume已不存在
+func Decide(e Entry, lv *carinav1.LogicVolume, nodeName string) Action {
+	if lv == nil || lv.UID != e.UID {
+		// 同名的新LogicVolume已建好卷时卷属于它
+		if lv != nil && lv.Status.VolumeID != "" {
+			return ActionComplete
+		}
+		return ActionRollback
+	}
+	if lv.Spec.NodeName != nodeName {
+		return ActionRollback
+	}
+	if lv.Status.VolumeID != "" {
+		return ActionComplete
+	}
+	// 删除中或创建失败的LogicVolume由finalizer删除卷
+	if lv.DeletionTimestamp != nil || lv.Status.Code != codes.OK {
+		return ActionComplete
+	}
+	// 恢复数据中断的卷内容不完整，删除后重新创建
+	if e.Restore && e.Step == StepCreated {
+		return ActionRollback
+	}
+	return ActionResume
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package opjournal
+
+import (
+	"testing"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestJournal(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+	j, err := Open(dir)
+	a.NoError(err)
+	a.Empty(j.Entries())
+
+	started := time.Now().UTC().Truncate(time.Second)
+	a.NoError(j.Record(Entry{Name: "pvc-b", UID: "2", VolumeType: "lvm", DeviceGroup: "carina-vg-ssd", Step: StepStarted, Started: started}))
+	a.NoError(j.Record(Entry{Name: "pvc-a", UID: "1", VolumeType: "raw", DeviceGroup: "carina-raw-ssd/sdb", Step: StepStarted, Started: started}))
+	a.NoError(j.Record(Entry{Name: "pvc-b", UID: "2", VolumeType: "lvm", DeviceGroup: "carina-vg-ssd", Restore: true, Step: StepCreated, Started: started}))
+	a.NoError(j.Complete("pvc-a"))
+	a.NoError(j.Complete("pvc-c"))
+
+	// carina-node重启后读回未完成的条目
+	j, err = Open(dir)
+	a.NoError(err)
+	entries := j.Entries()
+	if a.Len(entries, 1) {
+		a.Equal(Entry{Name: "pvc-b", UID: "2", VolumeType: "lvm", DeviceGroup: "carina-vg-ssd", Restore: true, Step: StepCreated, Started: started}, entries[0])
+	}
+	_, ok := j.Get("pvc-a")
+	a.False(ok)
+}
+
+func TestDecide(t *testing.T) {
+	now := metav1.Now()
+	lv := func(uid, node, volumeID string, code codes.Code, deleting bool) *carinav1.LogicVolume {
+		l := &carinav1.LogicVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-a", UID: "1"}}
+		if uid != "" {
+			l.UID = types.UID(uid)
+		}
+		l.Spec.NodeName = node
+		l.Status.VolumeID = volumeID
+		l.Status.Code = code
+		if deleting {
+			l.DeletionTimestamp = &now
+		}
+		return l
+	}
+	started := Entry{Name: "pvc-a", UID: "1", VolumeType: "lvm", Step: StepStarted}
+	restoring := Entry{Name: "pvc-a", UID: "1", VolumeType: "lvm", Restore: true, Step: StepCreated}
+
+	table := []struct {
+		name   string
+		entry  Entry
+		lv     *carinav1.LogicVolume
+		action Action
+	}{
+		{name: "logicvolume deleted", entry: started, lv: nil, action: ActionRollback},
+		{name: "recreated and waiting", entry: started, lv: lv("other", "node1", "", codes.OK, false), action: ActionRollback},
+		{name: "recreated and done", entry: started, lv: lv("other", "node1", "volume-pvc-a", codes.OK, false), action: ActionComplete},
+		{name: "moved to another node", entry: started, lv: lv("", "node2", "", codes.OK, false), action: ActionRollback},
+		{name: "status updated", entry: started, lv: lv("", "node1", "volume-pvc-a", codes.OK, false), action: ActionComplete},
+		{name: "failed", entry: started, lv: lv("", "node1", "", codes.Internal, false), action: ActionComplete},
+		{name: "deleting", entry: started, lv: lv("", "node1", "", codes.OK, true), action: ActionComplete},
+		{name: "interrupted", entry: started, lv: lv("", "node1", "", codes.OK, false), action: ActionResume},
+		{name: "restore interrupted", entry: restoring, lv: lv("", "node1", "", codes.OK, false), action: ActionRollback},
+	}
+	for _, c := range table {
+		assert.Equal(t, c.action, Decide(c.entry, c.lv, "node1"), c.name)
+	}
+}
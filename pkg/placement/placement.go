@@ -0,0 +1,171 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package placement plans the nodes of the volumes of replicated databases.
+// Operators of databases that replicate on their own, e.g. MySQL or
+// PostgreSQL, need every replica on a different node with enough local
+// storage. They either call Compute directly, or annotate their StatefulSet
+// with a Request and read the plan carina-controller writes back, then pin
+// replica i to the node of Replica i.
+package placement
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Request N个副本分别放在N个不同节点上，每个节点在磁盘组上至少有Free字节可分配
+// It is written as replicas=3,disk-group=carina-vg-ssd,free=100Gi, the disk
+// group is optional.
+type Request struct {
+	Replicas    int
+	DeviceGroup string
+	Free        int64
+}
+
+// Replica is the placement of one replica, the index in the plan is the ordinal of the replica
+type Replica struct {
+	Node        string `json:"node"`
+	DeviceGroup string `json:"diskGroup"`
+}
+
+// NodeCapacity 节点上各lvm磁盘组可分配的容量，字节
+type NodeCapacity struct {
+	Node string
+	Free map[string]int64
+}
+
+// ParseRequest parses a request written as replicas=3,disk-group=carina-vg-ssd,free=100Gi
+func ParseRequest(s string) (Request, error) {
+	req := Request{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return req, fmt.Errorf("invalid field %q, expect key=value", field)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "replicas":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return req, fmt.Errorf("invalid replicas %q", value)
+			}
+			req.Replicas = n
+		case "disk-group":
+			req.DeviceGroup = value
+		case "free":
+			q, err := resource.ParseQuantity(value)
+			if err != nil || q.Sign() < 0 {
+				return req, fmt.Errorf("invalid free %q", value)
+			}
+			req.Free = q.Value()
+		default:
+			return req, fmt.Errorf("unknown field %q", key)
+		}
+	}
+	if req.Replicas == 0 {
+		return req, errors.New("replicas is required")
+	}
+	return req, nil
+}
+
+func (r Request) String() string {
+	s := fmt.Sprintf("replicas=%d", r.Replicas)
+	if r.DeviceGroup != "" {
+		s += ",disk-group=" + r.DeviceGroup
+	}
+	return s + ",free=" + resource.NewQuantity(r.Free, resource.BinarySI).String()
+}
+
+// Compute 为每个副本选择一个不同的节点，优先选择可分配容量最大的节点
+// Replicas of previous stay where they are as long as their node is in nodes,
+// their volumes already use the capacity that would be checked. Nodes that
+// are not ready must be left out of nodes by the caller.
+func Compute(req Request, nodes []NodeCapacity, previous []Replica) ([]Replica, error) {
+	plan := make([]Replica, req.Replicas)
+	used := map[string]bool{}
+	known := map[string]bool{}
+	for _, n := range nodes {
+		known[n.Node] = true
+	}
+	for i, r := range previous {
+		if i < req.Replicas && known[r.Node] && !used[r.Node] {
+			plan[i] = r
+			used[r.Node] = true
+		}
+	}
+
+	candidates := []Replica{}
+	free := map[string]int64{}
+	for _, n := range nodes {
+		if used[n.Node] {
+			continue
+		}
+		group, capacity := largestGroup(n, req.DeviceGroup)
+		if group == "" || capacity < req.Free {
+			continue
+		}
+		candidates = append(candidates, Replica{Node: n.Node, DeviceGroup: group})
+		free[n.Node] = capacity
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if free[candidates[i].Node] != free[candidates[j].Node] {
+			return free[candidates[i].Node] > free[candidates[j].Node]
+		}
+		return candidates[i].Node < candidates[j].Node
+	})
+
+	placed := len(used)
+	for i := range plan {
+		if plan[i].Node != "" {
+			continue
+		}
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("only %d of %d replicas can be placed on distinct nodes with %s", placed, req.Replicas, req)
+		}
+		plan[i] = candidates[0]
+		candidates = candidates[1:]
+		placed++
+	}
+	return plan, nil
+}
+
+// largestGroup 返回节点上满足要求的可分配容量最大的磁盘组
+func largestGroup(n NodeCapacity, deviceGroup string) (string, int64) {
+	if deviceGroup != "" {
+		capacity, ok := n.Free[deviceGroup]
+		if !ok {
+			return "", 0
+		}
+		return deviceGroup, capacity
+	}
+	group, capacity := "", int64(-1)
+	for g, c := range n.Free {
+		if c > capacity || c == capacity && g < group {
+			group, capacity = g, c
+		}
+	}
+	return group, capacity
+}
@@ -0,0 +1,112 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package placement
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRequest(t *testing.T) {
+	table := []struct {
+		raw    string
+		result Request
+		err    bool
+	}{
+		{raw: "replicas=3,disk-group=carina-vg-ssd,free=100Gi", result: Request{Replicas: 3, DeviceGroup: "carina-vg-ssd", Free: 100 << 30}},
+		{raw: " replicas = 2 ", result: Request{Replicas: 2}},
+		{raw: "free=10Gi", err: true},
+		{raw: "replicas=0", err: true},
+		{raw: "replicas=3,zone=a", err: true},
+		{raw: "replicas=3,free", err: true},
+	}
+
+	a := assert.New(t)
+	for _, test := range table {
+		result, err := ParseRequest(test.raw)
+		if test.err {
+			a.Error(err, test.raw)
+			continue
+		}
+		a.NoError(err, test.raw)
+		a.Equal(test.result, result, test.raw)
+	}
+}
+
+func TestCompute(t *testing.T) {
+	nodes := []NodeCapacity{
+		{Node: "node1", Free: map[string]int64{"carina-vg-ssd": 50 << 30, "carina-vg-hdd": 500 << 30}},
+		{Node: "node2", Free: map[string]int64{"carina-vg-ssd": 200 << 30}},
+		{Node: "node3", Free: map[string]int64{"carina-vg-ssd": 120 << 30}},
+		{Node: "node4", Free: map[string]int64{"carina-vg-ssd": 120 << 30}},
+	}
+
+	table := []struct {
+		name     string
+		req      Request
+		previous []Replica
+		result   []Replica
+		err      bool
+	}{
+		{
+			name: "largest free first",
+			req:  Request{Replicas: 3, DeviceGroup: "carina-vg-ssd", Free: 100 << 30},
+			result: []Replica{
+				{Node: "node2", DeviceGroup: "carina-vg-ssd"},
+				{Node: "node3", DeviceGroup: "carina-vg-ssd"},
+				{Node: "node4", DeviceGroup: "carina-vg-ssd"},
+			},
+		},
+		{
+			name: "any disk group",
+			req:  Request{Replicas: 1, Free: 300 << 30},
+			result: []Replica{
+				{Node: "node1", DeviceGroup: "carina-vg-hdd"},
+			},
+		},
+		{
+			name: "not enough nodes",
+			req:  Request{Replicas: 4, DeviceGroup: "carina-vg-ssd", Free: 100 << 30},
+			err:  true,
+		},
+		{
+			name: "previous placement is kept",
+			req:  Request{Replicas: 3, DeviceGroup: "carina-vg-ssd", Free: 100 << 30},
+			previous: []Replica{
+				{Node: "node1", DeviceGroup: "carina-vg-ssd"},
+				{Node: "gone", DeviceGroup: "carina-vg-ssd"},
+			},
+			result: []Replica{
+				{Node: "node1", DeviceGroup: "carina-vg-ssd"},
+				{Node: "node2", DeviceGroup: "carina-vg-ssd"},
+				{Node: "node3", DeviceGroup: "carina-vg-ssd"},
+			},
+		},
+	}
+
+	a := assert.New(t)
+	for _, test := range table {
+		result, err := Compute(test.req, nodes, test.previous)
+		if test.err {
+			a.Error(err, test.name)
+			continue
+		}
+		a.NoError(err, test.name)
+		a.Equal(test.result, result, test.name)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package populator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// copyHostPath copies the tree below the HostPath of the request into root, keeping
+// mode, owner and modification time. Sockets, fifos and devices are skipped.
+func (j *job) copyHostPath(ctx context.Context, root string) (int, int64, error) {
+	src := filepath.Clean(j.req.HostPath)
+	info, err := os.Stat(src)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !info.IsDir() {
+		return 0, 0, fmt.Errorf("import source %s is not a directory", src)
+	}
+
+	var count int
+	var total int64
+	err = filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			count++
+			total += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	j.update(func(p *Progress) {
+		p.TotalObjects = count
+		p.TotalBytes = total
+	})
+	j.report()
+
+	stopReport := make(chan struct{})
+	defer close(stopReport)
+	go func() {
+		ticker := time.NewTicker(reportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.report()
+			case <-stopReport:
+				return
+			}
+		}
+	}()
+
+	// 目录的时间戳在子项写完之后才能设置
+	type dirTime struct {
+		path  string
+		mtime time.Time
+	}
+	dirs := []dirTime{}
+	err = filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(root, rel)
+		if rel == "." {
+			dst = root
+		}
+
+		switch {
+		case fi.IsDir():
+			if rel != "." {
+				if err := os.Mkdir(dst, fi.Mode().Perm()); err != nil && !os.IsExist(err) {
+					return err
+				}
+			}
+			if err := os.Chmod(dst, fi.Mode().Perm()); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirTime{path: dst, mtime: fi.ModTime()})
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_ = os.Remove(dst)
+			if err := os.Symlink(target, dst); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			if err := j.copyFile(ctx, path, dst, fi); err != nil {
+				return fmt.Errorf("copy %s failed: %v", path, err)
+			}
+			j.update(func(p *Progress) { p.DoneObjects++ })
+		default:
+			return nil
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			if err := os.Lchown(dst, int(st.Uid), int(st.Gid)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime)
+	}
+	return count, total, nil
+}
+
+func (j *job) copyFile(ctx context.Context, src, dst string, fi os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = in
+	if j.throttle != nil {
+		r = j.throttle.Reader(ctx, in)
+	}
+	buf := make([]byte, 1<<20)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if _, werr := out.Write(buf[:n]); werr != nil {
+				out.Close()
+				return werr
+			}
+			j.update(func(p *Progress) { p.DoneBytes += int64(n) })
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			out.Close()
+			return rerr
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// chmod again, the umask applies to OpenFile
+	if err := os.Chmod(dst, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
@@ -0,0 +1,70 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package populator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyHostPath(t *testing.T) {
+	a := assert.New(t)
+	src, err := ioutil.TempDir("", "import-src")
+	a.NoError(err)
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "import-dst")
+	a.NoError(err)
+	defer os.RemoveAll(dst)
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	a.NoError(os.MkdirAll(filepath.Join(src, "data", "nested"), 0750))
+	a.NoError(ioutil.WriteFile(filepath.Join(src, "data", "nested", "a.txt"), []byte("hello"), 0600))
+	a.NoError(ioutil.WriteFile(filepath.Join(src, "b.txt"), []byte("carina"), 0644))
+	a.NoError(os.Chtimes(filepath.Join(src, "b.txt"), mtime, mtime))
+	a.NoError(os.Symlink("b.txt", filepath.Join(src, "link")))
+
+	j := &job{req: Request{VolumeID: "volume-test", HostPath: src}}
+	count, total, err := j.copyHostPath(context.Background(), dst)
+	a.NoError(err)
+	a.Equal(2, count)
+	a.Equal(int64(11), total)
+	a.Equal(2, j.snapshot().DoneObjects)
+	a.Equal(int64(11), j.snapshot().DoneBytes)
+
+	content, err := ioutil.ReadFile(filepath.Join(dst, "data", "nested", "a.txt"))
+	a.NoError(err)
+	a.Equal("hello", string(content))
+	fi, err := os.Stat(filepath.Join(dst, "data", "nested", "a.txt"))
+	a.NoError(err)
+	a.Equal(os.FileMode(0600), fi.Mode().Perm())
+	fi, err = os.Stat(filepath.Join(dst, "b.txt"))
+	a.NoError(err)
+	a.True(fi.ModTime().Equal(mtime))
+	target, err := os.Readlink(filepath.Join(dst, "link"))
+	a.NoError(err)
+	a.Equal("b.txt", target)
+
+	j = &job{req: Request{VolumeID: "volume-test", HostPath: filepath.Join(src, "b.txt")}}
+	_, _, err = j.copyHostPath(context.Background(), dst)
+	a.Error(err)
+}
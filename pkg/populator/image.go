@@ -0,0 +1,187 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package populator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/carina-io/carina/pkg/datamover"
+)
+
+const (
+	// ImageManifest is written under the image prefix once all chunks are uploaded
+	ImageManifest = "manifest.json"
+
+	imageChunk = 4 << 20
+)
+
+// Image 快照上传到s3后的清单，只记录非零的数据块
+// A chunk i holds the bytes [i*ChunkSize, (i+1)*ChunkSize) of the device and is stored
+// as <prefix>/chunks/<i>, chunks holding only zeros are not uploaded.
+type Image struct {
+	Size        int64        `json:"size"`
+	ChunkSize   int64        `json:"chunkSize"`
+	Chunks      []ImageChunk `json:"chunks"`
+	CompletedAt time.Time    `json:"completedAt"`
+}
+
+// ImageChunk is one uploaded chunk of an Image
+type ImageChunk struct {
+	Index  int64  `json:"index"`
+	SHA256 string `json:"sha256"`
+}
+
+// ExportURL returns where a snapshot is exported under the export target s3://bucket/prefix
+func ExportURL(target, snapshotID string) string {
+	return strings.TrimSuffix(target, "/") + "/" + snapshotID
+}
+
+func chunkKey(prefix string, index int64) string {
+	return path.Join(prefix, "chunks", fmt.Sprintf("%08d", index))
+}
+
+func deviceSize(f *os.File) (int64, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	return size, err
+}
+
+// ExportImage uploads the block device src to dst.Prefix within the bandwidth ceiling of t.
+// The manifest is uploaded last, an image without manifest is incomplete.
+func ExportImage(ctx context.Context, t *datamover.Throttle, src string, dst *S3Source) (*Image, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	size, err := deviceSize(in)
+	if err != nil {
+		return nil, err
+	}
+
+	image := &Image{Size: size, ChunkSize: imageChunk, Chunks: []ImageChunk{}}
+	r := t.Reader(ctx, in)
+	buf := make([]byte, imageChunk)
+	for index := int64(0); ; index++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 && !isZero(buf[:n]) {
+			sum := sha256.Sum256(buf[:n])
+			if perr := dst.PutObject(ctx, chunkKey(dst.Prefix, index), buf[:n]); perr != nil {
+				return nil, fmt.Errorf("upload chunk %d failed: %v", index, perr)
+			}
+			image.Chunks = append(image.Chunks, ImageChunk{Index: index, SHA256: hex.EncodeToString(sum[:])})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	image.CompletedAt = time.Now()
+	manifest, _ := json.Marshal(image)
+	if err := dst.PutObject(ctx, path.Join(dst.Prefix, ImageManifest), manifest); err != nil {
+		return nil, fmt.Errorf("upload manifest failed: %v", err)
+	}
+	return image, nil
+}
+
+// RestoreImage writes the image under src.Prefix to the start of the block device dst within
+// the bandwidth ceiling of t. Chunks not in the image are left alone, dst must read as zeros
+// there, as a new thin volume does.
+func RestoreImage(ctx context.Context, t *datamover.Throttle, dst string, src *S3Source) (*Image, error) {
+	body, err := src.GetObject(ctx, path.Join(src.Prefix, ImageManifest))
+	if err != nil {
+		return nil, fmt.Errorf("get manifest failed, the image may be incomplete: %v", err)
+	}
+	manifest, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+	image := &Image{}
+	if err := json.Unmarshal(manifest, image); err != nil {
+		return nil, fmt.Errorf("decode manifest failed: %v", err)
+	}
+	if image.ChunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d in manifest", image.ChunkSize)
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	size, err := deviceSize(out)
+	if err != nil {
+		return nil, err
+	}
+	if size < image.Size {
+		return nil, fmt.Errorf("image of %d bytes does not fit into %s of %d bytes", image.Size, dst, size)
+	}
+
+	for _, c := range image.Chunks {
+		data, err := fetchChunk(ctx, t, src, c)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := out.WriteAt(data, c.Index*image.ChunkSize); err != nil {
+			return nil, err
+		}
+	}
+	return image, out.Sync()
+}
+
+func fetchChunk(ctx context.Context, t *datamover.Throttle, src *S3Source, c ImageChunk) ([]byte, error) {
+	body, err := src.GetObject(ctx, chunkKey(src.Prefix, c.Index))
+	if err != nil {
+		return nil, fmt.Errorf("download chunk %d failed: %v", c.Index, err)
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(t.Reader(ctx, body))
+	if err != nil {
+		return nil, fmt.Errorf("download chunk %d failed: %v", c.Index, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != c.SHA256 {
+		return nil, fmt.Errorf("chunk %d is corrupted, sha256 mismatch", c.Index)
+	}
+	return data, nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
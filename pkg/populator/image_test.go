@@ -0,0 +1,175 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package populator
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/carina-io/carina/pkg/datamover"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 按路径保存对象的s3服务
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=carina/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch req.Method {
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(req.Body)
+		f.objects[req.URL.Path] = body
+	case http.MethodGet:
+		body, ok := f.objects[req.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	}
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server, string) {
+	f := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	// carina-node中挂载的s3配置
+	config := t.TempDir()
+	for file, value := range map[string]string{
+		"endpoint":                   server.URL,
+		"region":                     "minio",
+		utils.PrefillAccessKeySecret: "carina",
+		utils.PrefillSecretKeySecret: "secret\n",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(config, file), []byte(value), 0600))
+	}
+	return f, server, config
+}
+
+func device(t *testing.T, size int64, chunks map[int64]byte) string {
+	f, err := ioutil.TempFile(t.TempDir(), "device")
+	assert.NoError(t, err)
+	defer f.Close()
+	assert.NoError(t, f.Truncate(size))
+	for index, b := range chunks {
+		_, err := f.WriteAt(bytes.Repeat([]byte{b}, 1000), index*imageChunk+10)
+		assert.NoError(t, err)
+	}
+	return f.Name()
+}
+
+func TestLoadConfig(t *testing.T) {
+	_, server, config := newFakeS3(t)
+	src, err := ParseS3URL("s3://backup/cluster-a")
+	assert.NoError(t, err)
+	assert.NoError(t, src.LoadConfig(config))
+	assert.Equal(t, server.URL, src.Endpoint)
+	assert.Equal(t, "minio", src.Region)
+	assert.Equal(t, "carina", src.AccessKey)
+	assert.Equal(t, "secret", src.SecretKey)
+
+	// 没有挂载s3配置时保持默认值
+	src, _ = ParseS3URL("s3://backup/cluster-a")
+	assert.NoError(t, src.LoadConfig(filepath.Join(config, "missing")))
+	assert.Equal(t, defaultEndpoint, src.Endpoint)
+	assert.Equal(t, defaultRegion, src.Region)
+}
+
+func TestExportRestoreImage(t *testing.T) {
+	f, _, config := newFakeS3(t)
+	ctx := context.Background()
+	throttle := datamover.NewThrottle()
+
+	// 最后一个数据块不满4M
+	size := int64(5*imageChunk + 4096)
+	src := device(t, size, map[int64]byte{0: 1, 2: 2, 5: 3})
+	target, err := ParseS3URL(ExportURL("s3://backup/cluster-a/", "snap-pvc-1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "cluster-a/snap-pvc-1", target.Prefix)
+	assert.NoError(t, target.LoadConfig(config))
+
+	image, err := ExportImage(ctx, throttle, src, target)
+	assert.NoError(t, err)
+	assert.Equal(t, size, image.Size)
+	indexes := []int64{}
+	for _, c := range image.Chunks {
+		indexes = append(indexes, c.Index)
+	}
+	assert.Equal(t, []int64{0, 2, 5}, indexes)
+	// 全零的数据块不上传
+	keys := []string{}
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	assert.ElementsMatch(t, []string{
+		"/backup/cluster-a/snap-pvc-1/chunks/00000000",
+		"/backup/cluster-a/snap-pvc-1/chunks/00000002",
+		"/backup/cluster-a/snap-pvc-1/chunks/00000005",
+		"/backup/cluster-a/snap-pvc-1/manifest.json",
+	}, keys)
+
+	// 恢复到更大的新卷
+	dst := device(t, 8*imageChunk, nil)
+	restored, err := RestoreImage(ctx, throttle, dst, target)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Chunks, restored.Chunks)
+	want, _ := ioutil.ReadFile(src)
+	got, _ := ioutil.ReadFile(dst)
+	assert.Equal(t, want, got[:size])
+	assert.Equal(t, make([]byte, len(got)-int(size)), got[size:])
+
+	// 新卷小于快照
+	_, err = RestoreImage(ctx, throttle, device(t, 4*imageChunk, nil), target)
+	assert.Error(t, err)
+
+	// 数据块损坏
+	f.objects["/backup/cluster-a/snap-pvc-1/chunks/00000002"][0] ^= 0xff
+	_, err = RestoreImage(ctx, throttle, device(t, 8*imageChunk, nil), target)
+	assert.EqualError(t, err, "chunk 2 is corrupted, sha256 mismatch")
+
+	// 没有清单的镜像未上传完成
+	delete(f.objects, "/backup/cluster-a/snap-pvc-1/manifest.json")
+	_, err = RestoreImage(ctx, throttle, device(t, 8*imageChunk, nil), target)
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "get manifest failed"), err.Error())
+}
+
+func TestExportImageMissingDevice(t *testing.T) {
+	_, _, config := newFakeS3(t)
+	target, _ := ParseS3URL("s3://backup/snap-pvc-1")
+	assert.NoError(t, target.LoadConfig(config))
+	_, err := ExportImage(context.Background(), datamover.NewThrottle(), filepath.Join(t.TempDir(), "missing"), target)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,522 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package populator
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carina-io/carina/pkg/datamover"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	mountutil "k8s.io/mount-utils"
+)
+
+const (
+	// StagingDirectory 数据预填充时设备的临时挂载目录
+	StagingDirectory = "/var/lib/carina/populate"
+	// MarkerFile is written to the volume root once the dataset has been fully loaded
+	MarkerFile = ".carina-prefilled"
+
+	defaultParallelism = 8
+	partSize           = 16 << 20
+	reportInterval     = 15 * time.Second
+)
+
+// job states
+const (
+	StateRunning   = "Running"
+	StateSucceeded = "Succeeded"
+	StateFailed    = "Failed"
+)
+
+// Progress is a point-in-time view of a prefill job
+type Progress struct {
+	State        string
+	TotalObjects int
+	DoneObjects  int
+	TotalBytes   int64
+	DoneBytes    int64
+	Message      string
+}
+
+func (p Progress) String() string {
+	return fmt.Sprintf("%s: %d/%d objects, %d/%d bytes %s", p.State, p.DoneObjects, p.TotalObjects, p.DoneBytes, p.TotalBytes, p.Message)
+}
+
+// Reporter is called whenever a job makes notable progress
+type Reporter func(volumeID string, progress Progress)
+
+// Request describes one volume to fill
+type Request struct {
+	VolumeID    string
+	Device      string
+	FsType      string
+	Source      *S3Source
+	Parallelism int
+	// HostPath is a directory of the node copied instead of the s3 source
+	HostPath string
+}
+
+func (r Request) source() string {
+	if r.HostPath != "" {
+		return "file://" + r.HostPath
+	}
+	return fmt.Sprintf("s3://%s/%s", r.Source.Bucket, r.Source.Prefix)
+}
+
+type marker struct {
+	Source      string    `json:"source"`
+	Objects     int       `json:"objects"`
+	Bytes       int64     `json:"bytes"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// Populator runs prefill jobs in the background, one per volume
+type Populator struct {
+	mu       sync.Mutex
+	jobs     map[string]*job
+	mounter  *mountutil.SafeFormatAndMount
+	throttle *datamover.Throttle
+	reporter Reporter
+}
+
+// NewPopulator returns a new Populator, downloads are limited by the throttle of the node
+func NewPopulator(mounter *mountutil.SafeFormatAndMount, throttle *datamover.Throttle, reporter Reporter) *Populator {
+	return &Populator{
+		jobs:     map[string]*job{},
+		mounter:  mounter,
+		throttle: throttle,
+		reporter: reporter,
+	}
+}
+
+// Populate starts filling the volume if no job is known for it and returns the
+// current progress. Failed jobs are forgotten after being reported once so that
+// the next call retries from scratch.
+func (p *Populator) Populate(req Request) Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if j, ok := p.jobs[req.VolumeID]; ok {
+		progress := j.snapshot()
+		if progress.State == StateFailed {
+			delete(p.jobs, req.VolumeID)
+		}
+		return progress
+	}
+
+	if req.Parallelism <= 0 {
+		req.Parallelism = defaultParallelism
+	}
+	j := &job{req: req, mounter: p.mounter, throttle: p.throttle, reporter: p.reporter}
+	j.progress.State = StateRunning
+	p.jobs[req.VolumeID] = j
+	go j.run()
+	return j.snapshot()
+}
+
+// Forget drops the state kept for the volume once its job has finished. A running job is
+// kept, otherwise the next Populate would start a second job writing to the same device.
+func (p *Populator) Forget(volumeID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if j, ok := p.jobs[volumeID]; ok && j.snapshot().State != StateRunning {
+		delete(p.jobs, volumeID)
+	}
+}
+
+type job struct {
+	mu       sync.Mutex
+	req      Request
+	progress Progress
+	mounter  *mountutil.SafeFormatAndMount
+	throttle *datamover.Throttle
+	reporter Reporter
+}
+
+func (j *job) snapshot() Progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.progress
+}
+
+func (j *job) update(f func(p *Progress)) {
+	j.mu.Lock()
+	f(&j.progress)
+	j.mu.Unlock()
+}
+
+func (j *job) report() {
+	if j.reporter != nil {
+		j.reporter(j.req.VolumeID, j.snapshot())
+	}
+}
+
+func (j *job) run() {
+	err := j.populate()
+	j.update(func(p *Progress) {
+		if err != nil {
+			p.State = StateFailed
+			p.Message = err.Error()
+			return
+		}
+		p.State = StateSucceeded
+		p.Message = ""
+	})
+	if err != nil {
+		log.Errorf("prefill volume %s from %s failed: %s", j.req.VolumeID, j.req.source(), err.Error())
+	} else {
+		log.Infof("prefill volume %s finished", j.req.VolumeID)
+	}
+	j.report()
+}
+
+func (j *job) populate() error {
+	staging := filepath.Join(StagingDirectory, j.req.VolumeID)
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return err
+	}
+	defer os.Remove(staging)
+
+	if err := j.mounter.FormatAndMount(j.req.Device, staging, j.req.FsType, nil); err != nil {
+		return fmt.Errorf("mount %s on %s failed: %v", j.req.Device, staging, err)
+	}
+	defer func() {
+		if err := j.mounter.Unmount(staging); err != nil {
+			log.Warnf("unmount prefill staging %s failed: %s", staging, err.Error())
+		}
+	}()
+
+	markerPath := filepath.Join(staging, MarkerFile)
+	if utils.FileExists(markerPath) {
+		log.Infof("volume %s has already been prefilled", j.req.VolumeID)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var count int
+	var total int64
+	if j.req.HostPath != "" {
+		var err error
+		if count, total, err = j.copyHostPath(ctx, staging); err != nil {
+			return err
+		}
+	} else {
+		objects, err := j.req.Source.ListObjects(ctx)
+		if err != nil {
+			return err
+		}
+		for _, o := range objects {
+			total += o.Size
+		}
+		count = len(objects)
+		j.update(func(p *Progress) {
+			p.TotalObjects = count
+			p.TotalBytes = total
+		})
+		j.report()
+
+		if err := j.download(ctx, staging, objects); err != nil {
+			return err
+		}
+	}
+
+	m, _ := json.Marshal(marker{
+		Source:      j.req.source(),
+		Objects:     count,
+		Bytes:       total,
+		CompletedAt: time.Now(),
+	})
+	if err := ioutil.WriteFile(markerPath, m, 0644); err != nil {
+		return err
+	}
+	if err := os.Chmod(staging, 0777|os.ModeSetgid); err != nil {
+		return err
+	}
+	return nil
+}
+
+type part struct {
+	object *S3Object
+	file   *os.File
+	offset int64
+	length int64
+	// remaining 该对象尚未完成的分片数，归零后进行校验
+	remaining *int32
+}
+
+// download fetches every object with ranged GETs spread over a worker pool
+// and writes each range straight to its offset in the destination file
+func (j *job) download(ctx context.Context, root string, objects []S3Object) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make(chan part, j.req.Parallelism*2)
+	errCh := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+		cancel()
+	}
+
+	stopReport := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(reportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.report()
+			case <-stopReport:
+				return
+			}
+		}
+	}()
+	defer close(stopReport)
+
+	var counterMu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < j.req.Parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pt := range parts {
+				if ctx.Err() != nil {
+					continue
+				}
+				err := utils.UntilMaxRetry(func() error {
+					return j.fetchPart(ctx, pt)
+				}, 3, 2*time.Second)
+				if err != nil {
+					fail(fmt.Errorf("download %s failed: %v", pt.object.Key, err))
+					continue
+				}
+				j.update(func(p *Progress) { p.DoneBytes += pt.length })
+
+				counterMu.Lock()
+				*pt.remaining--
+				finished := *pt.remaining == 0
+				counterMu.Unlock()
+				if !finished {
+					continue
+				}
+				if err := verifyObject(pt.file, pt.object); err != nil {
+					fail(err)
+					continue
+				}
+				j.update(func(p *Progress) { p.DoneObjects++ })
+			}
+		}()
+	}
+
+	files := []*os.File{}
+	err := func() error {
+		defer close(parts)
+		for i := range objects {
+			o := &objects[i]
+			dst, err := destination(root, j.req.Source.Prefix, o.Key)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(dst, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			files = append(files, f)
+			if err := f.Truncate(o.Size); err != nil {
+				return err
+			}
+			if o.Size == 0 {
+				j.update(func(p *Progress) { p.DoneObjects++ })
+				continue
+			}
+
+			count := int32((o.Size + partSize - 1) / partSize)
+			remaining := &count
+			for off := int64(0); off < o.Size; off += partSize {
+				length := int64(partSize)
+				if off+length > o.Size {
+					length = o.Size - off
+				}
+				select {
+				case parts <- part{object: o, file: f, offset: off, length: length, remaining: remaining}:
+				case err := <-errCh:
+					return err
+				}
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		fail(err)
+	}
+	wg.Wait()
+
+	for _, f := range files {
+		if serr := f.Sync(); serr != nil && err == nil {
+			err = serr
+		}
+		f.Close()
+	}
+
+	select {
+	case e := <-errCh:
+		return e
+	default:
+	}
+	return err
+}
+
+func (j *job) fetchPart(ctx context.Context, pt part) error {
+	if j.throttle != nil {
+		if err := j.throttle.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	body, err := j.req.Source.GetRange(ctx, pt.object.Key, pt.offset, pt.length)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	var r io.Reader = body
+	if j.throttle != nil {
+		r = j.throttle.Reader(ctx, body)
+	}
+
+	buf := make([]byte, 1<<20)
+	offset := pt.offset
+	end := pt.offset + pt.length
+	for offset < end {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if offset+int64(n) > end {
+				return fmt.Errorf("range response for %s is longer than requested", pt.object.Key)
+			}
+			if _, werr := pt.file.WriteAt(buf[:n], offset); werr != nil {
+				return werr
+			}
+			offset += int64(n)
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	if offset != end {
+		return fmt.Errorf("short read for %s at offset %d", pt.object.Key, offset)
+	}
+	return nil
+}
+
+// destination maps an object key below the prefix to a path inside root and
+// refuses keys that would escape the volume
+func destination(root, prefix, key string) (string, error) {
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+	if rel == "" {
+		rel = filepath.Base(key)
+	}
+	dst := filepath.Join(root, filepath.FromSlash(rel))
+	if dst != root && !strings.HasPrefix(dst, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("object key %s escapes the volume root", key)
+	}
+	return dst, nil
+}
+
+// verifyObject checks the size and, when the etag carries it, the md5 of a
+// downloaded object. Multipart etags are the md5 of the part md5s, the part
+// size is not recorded by s3 so it is guessed the way common clients pick it;
+// if the guess does not match the object is accepted with a warning.
+func verifyObject(f *os.File, o *S3Object) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != o.Size {
+		return fmt.Errorf("object %s size mismatch, expect %d got %d", o.Key, o.Size, info.Size())
+	}
+	if o.ETag == "" {
+		return nil
+	}
+
+	etag := strings.ToLower(o.ETag)
+	if !strings.Contains(etag, "-") {
+		if len(etag) != md5.Size*2 {
+			return nil
+		}
+		sum, err := md5Range(f, 0, o.Size)
+		if err != nil {
+			return err
+		}
+		if hex.EncodeToString(sum) != etag {
+			return fmt.Errorf("object %s checksum mismatch", o.Key)
+		}
+		return nil
+	}
+
+	fields := strings.SplitN(etag, "-", 2)
+	parts, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || parts <= 0 {
+		return nil
+	}
+	chunk := (o.Size + parts - 1) / parts
+	chunk = (chunk + (1<<20 - 1)) &^ (1<<20 - 1)
+	h := md5.New()
+	for off := int64(0); off < o.Size; off += chunk {
+		length := chunk
+		if off+length > o.Size {
+			length = o.Size - off
+		}
+		sum, err := md5Range(f, off, length)
+		if err != nil {
+			return err
+		}
+		h.Write(sum)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != fields[0] {
+		log.Warnf("object %s multipart etag could not be verified, part size unknown", o.Key)
+	}
+	return nil
+}
+
+func md5Range(f *os.File, offset, length int64) ([]byte, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, offset, length)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
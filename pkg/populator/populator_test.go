@@ -0,0 +1,38 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package populator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForget(t *testing.T) {
+	a := assert.New(t)
+	p := NewPopulator(nil, nil, nil)
+	for id, state := range map[string]string{"pvc-running": StateRunning, "pvc-succeeded": StateSucceeded, "pvc-failed": StateFailed} {
+		p.jobs[id] = &job{progress: Progress{State: state}}
+	}
+
+	for _, id := range []string{"pvc-running", "pvc-succeeded", "pvc-failed", "pvc-unknown"} {
+		p.Forget(id)
+	}
+	// 运行中的任务保留，避免同一设备上启动第二个任务
+	a.Len(p.jobs, 1)
+	a.Contains(p.jobs, "pvc-running")
+}
@@ -0,0 +1,305 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package populator
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/carina-io/carina/utils"
+)
+
+const (
+	defaultRegion   = "us-east-1"
+	defaultEndpoint = "https://s3.amazonaws.com"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	amzDateFormat   = "20060102T150405Z"
+)
+
+// S3Object is an object listed under the dataset prefix
+type S3Object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+	ETag string `xml:"ETag"`
+}
+
+type listBucketResult struct {
+	Contents              []S3Object `xml:"Contents"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+// S3Source describes where a dataset lives, requests are path-style so that
+// minio/ceph rgw endpoints work as well as aws
+type S3Source struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+
+	client *http.Client
+}
+
+// ParseS3URL 解析 s3://bucket/prefix 形式的数据源地址
+func ParseS3URL(raw string) (*S3Source, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" {
+		return nil, fmt.Errorf("unsupported prefill source scheme %q, only s3:// is supported", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("prefill source has no bucket")
+	}
+	return &S3Source{
+		Endpoint: defaultEndpoint,
+		Region:   defaultRegion,
+		Bucket:   u.Host,
+		Prefix:   strings.TrimPrefix(u.Path, "/"),
+	}, nil
+}
+
+func (s *S3Source) httpClient() *http.Client {
+	if s.client == nil {
+		s.client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	return s.client
+}
+
+// ListObjects returns all objects under the prefix, directory placeholders are skipped
+func (s *S3Source) ListObjects(ctx context.Context) ([]S3Object, error) {
+	objects := []S3Object{}
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if s.Prefix != "" {
+			query.Set("prefix", s.Prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		result := listBucketResult{}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("decode list objects response failed: %v", err)
+		}
+		for _, o := range result.Contents {
+			if strings.HasSuffix(o.Key, "/") {
+				continue
+			}
+			o.ETag = strings.Trim(o.ETag, "\"")
+			objects = append(objects, o)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	return objects, nil
+}
+
+// GetRange opens a reader over [offset, offset+length) of the object
+func (s *S3Source) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := s.do(ctx, http.MethodGet, key, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetObject opens a reader over the whole object
+func (s *S3Source) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// PutObject uploads body as the object key
+func (s *S3Source) PutObject(ctx context.Context, key string, body []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, nil, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// LoadConfig reads the endpoint, region and credentials from the files of a mounted
+// secret in dir, keys that are missing keep their value
+func (s *S3Source) LoadConfig(dir string) error {
+	for file, field := range map[string]*string{
+		"endpoint":                   &s.Endpoint,
+		"region":                     &s.Region,
+		utils.PrefillAccessKeySecret: &s.AccessKey,
+		utils.PrefillSecretKeySecret: &s.SecretKey,
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if v := strings.TrimSpace(string(b)); v != "" {
+			*field = v
+		}
+	}
+	return nil
+}
+
+func (s *S3Source) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	canonicalURI := "/" + uriEncode(s.Bucket, false)
+	if key != "" {
+		canonicalURI += "/" + uriEncode(key, true)
+	}
+	endpoint.Opaque = "//" + endpoint.Host + canonicalURI
+	endpoint.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.AccessKey != "" {
+		s.sign(req, canonicalURI, endpoint.RawQuery, time.Now().UTC())
+	}
+
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 request %s failed: %s %s", canonicalURI, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// sign adds an AWS signature version 4 authorization header, the payload is
+// not hashed and sent as UNSIGNED-PAYLOAD
+func (s *S3Source) sign(req *http.Request, canonicalURI, rawQuery string, now time.Time) {
+	amzDate := now.Format(amzDateFormat)
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", unsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, unsignedPayload, amzDate)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		rawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.Region)
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(hashed[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode 按照 sigv4 的规则编码，仅保留 RFC 3986 非保留字符
+func uriEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package populator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseS3URL(t *testing.T) {
+	table := []struct {
+		raw    string
+		bucket string
+		prefix string
+		err    bool
+	}{
+		{raw: "s3://datasets/imagenet/train", bucket: "datasets", prefix: "imagenet/train"},
+		{raw: "s3://datasets", bucket: "datasets", prefix: ""},
+		{raw: "http://datasets/imagenet", err: true},
+		{raw: "s3:///imagenet", err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		src, err := ParseS3URL(e.raw)
+		if e.err {
+			a.Error(err, e.raw)
+			continue
+		}
+		a.NoError(err, e.raw)
+		a.Equal(e.bucket, src.Bucket)
+		a.Equal(e.prefix, src.Prefix)
+	}
+}
+
+func TestDestination(t *testing.T) {
+	table := []struct {
+		prefix string
+		key    string
+		result string
+		err    bool
+	}{
+		{prefix: "train", key: "train/a/b.jpg", result: "/mnt/a/b.jpg"},
+		{prefix: "train/", key: "train/c.jpg", result: "/mnt/c.jpg"},
+		{prefix: "train/c.jpg", key: "train/c.jpg", result: "/mnt/c.jpg"},
+		{prefix: "train", key: "train/../../etc/passwd", err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		dst, err := destination("/mnt", e.prefix, e.key)
+		if e.err {
+			a.Error(err, e.key)
+			continue
+		}
+		a.NoError(err, e.key)
+		a.Equal(e.result, dst)
+	}
+}
+
+func TestUriEncode(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("a/b%20c/d~e", uriEncode("a/b c/d~e", true))
+	a.Equal("a%2Fb", uriEncode("a/b", false))
+	a.Equal("list-type=2&prefix=a%2Fb", canonicalQuery(map[string][]string{"prefix": {"a/b"}, "list-type": {"2"}}))
+}
@@ -0,0 +1,394 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type Status string
+
+const (
+	StatusPass Status = "PASS"
+	StatusWarn Status = "WARN"
+	StatusFail Status = "FAIL"
+)
+
+// stuckDeletionTime LogicVolume删除超过该时间仍未完成视为卡住
+const stuckDeletionTime = 10 * time.Minute
+
+// crdVersions 当前版本使用的crd及其存储版本
+var crdVersions = map[string]string{
+	"logicvolumes.carina.storage.io":         "v1",
+	"nodestorageresources.carina.storage.io": "v1beta1",
+	"carinadefaultclasses.carina.storage.io": "v1",
+	"carinaquotas.carina.storage.io":         "v1",
+	"rebalances.carina.storage.io":           "v1",
+	"snapshotpolicies.carina.storage.io":     "v1",
+	"storagepolicies.carina.storage.io":      "v1",
+	"volumefreezes.carina.storage.io":        "v1",
+	"volumeoperations.carina.storage.io":     "v1",
+	"volumereplications.carina.storage.io":   "v1",
+}
+
+// knownConfigKeys config.json中当前版本识别的配置项，viper不区分大小写
+var knownConfigKeys = []string{
+	"diskSelector", "diskScanInterval", "schedulerStrategy", "operationWorkers", "reclaimReleasedVolume",
+	"wipePolicy", "encryptedDeviceGroups", "importHostPaths", "spareVolumes", "rebalanceHighWatermark",
+	"rebalanceLowWatermark", "lvmFilter", "fstrimInterval", "usageThreshold", "usagePodCondition", "autoresize", "diskBenchmark",
+	"fragmentationThreshold", "defragment", "capacityForecastDays", "capacityHistorySamples", "orphanGracePeriod", "orphanDryRun",
+	"reservedCapacity", "loopDevices", "loopDeviceDir", "diskApproval", "nfsGatewayImage", "logLevel",
+}
+
+// deprecatedConfigKeys 已废弃的配置项及替代方式
+var deprecatedConfigKeys = map[string]string{
+	"diskGroupPolicy": "disk groups are configured by diskSelector[].name and diskSelector[].policy",
+}
+
+// Result 一项检查的结果
+type Result struct {
+	Check   string `json:"check"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+}
+
+// Report 升级前检查的报告，有FAIL时不应升级
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+func (r *Report) add(check string, status Status, format string, a ...interface{}) {
+	r.Results = append(r.Results, Result{Check: check, Status: status, Message: fmt.Sprintf(format, a...)})
+}
+
+// Failed reports whether any check failed
+func (r *Report) Failed() bool {
+	for _, res := range r.Results {
+		if res.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes one line per result and a summary line
+func (r *Report) Print(w io.Writer) {
+	counts := map[Status]int{}
+	for _, res := range r.Results {
+		counts[res.Status]++
+		fmt.Fprintf(w, "[%s] %-10s %s\n", res.Status, res.Check, res.Message)
+	}
+	verdict := "upgrade can proceed"
+	if r.Failed() {
+		verdict = "fix the failed checks before upgrading"
+	}
+	fmt.Fprintf(w, "%d passed, %d warning(s), %d failed: %s\n", counts[StatusPass], counts[StatusWarn], counts[StatusFail], verdict)
+}
+
+// Options 被检查的carina安装
+type Options struct {
+	// Namespace carina所在的命名空间
+	Namespace string
+	// ControllerName carina-controller的deployment
+	ControllerName string
+	// NodeName carina-node的daemonset
+	NodeName string
+	// ConfigMap 保存config.json的configmap
+	ConfigMap string
+}
+
+// Run checks the installed crds, orphaned objects, the carina configuration and the versions of the
+// running components. The client needs the carina, apiextensions and client-go types in its scheme.
+func Run(ctx context.Context, c client.Client, opt Options) *Report {
+	r := &Report{}
+	checkCRDs(ctx, c, r)
+	checkOrphans(ctx, c, r)
+	checkConfig(ctx, c, opt, r)
+	checkComponents(ctx, c, opt, r)
+	return r
+}
+
+func checkCRDs(ctx context.Context, c client.Client, r *Report) {
+	names := make([]string, 0, len(crdVersions))
+	for name := range crdVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	healthy := true
+	for _, name := range names {
+		crd := new(apiextv1.CustomResourceDefinition)
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, crd); err != nil {
+			healthy = false
+			if apierrors.IsNotFound(err) {
+				r.add("crd", StatusFail, "%s is not installed, apply the crds of the new release first", name)
+			} else {
+				r.add("crd", StatusFail, "get %s: %v", name, err)
+			}
+			continue
+		}
+		if msg := crdProblem(crd, crdVersions[name]); msg != "" {
+			healthy = false
+			r.add("crd", StatusFail, "%s %s", name, msg)
+		}
+	}
+	if healthy {
+		r.add("crd", StatusPass, "%d crds serve and store the expected versions", len(names))
+	}
+}
+
+// crdProblem 检查crd是否提供并存储version，且没有以其他版本存储的对象
+func crdProblem(crd *apiextv1.CustomResourceDefinition, version string) string {
+	served, storage := false, ""
+	for _, v := range crd.Spec.Versions {
+		if v.Name == version && v.Served {
+			served = true
+		}
+		if v.Storage {
+			storage = v.Name
+		}
+	}
+	if !served {
+		return fmt.Sprintf("does not serve %s, apply the crds of the new release first", version)
+	}
+	if storage != version {
+		return fmt.Sprintf("stores %s instead of %s", storage, version)
+	}
+	for _, v := range crd.Status.StoredVersions {
+		if v != version {
+			return fmt.Sprintf("has objects stored as %s, migrate them to %s and drop it from status.storedVersions", v, version)
+		}
+	}
+	return ""
+}
+
+func checkOrphans(ctx context.Context, c client.Client, r *Report) {
+	lvs := new(carinav1.LogicVolumeList)
+	if err := c.List(ctx, lvs); err != nil {
+		r.add("orphans", StatusFail, "list LogicVolumes: %v", err)
+		return
+	}
+	nodes := new(corev1.NodeList)
+	if err := c.List(ctx, nodes); err != nil {
+		r.add("orphans", StatusFail, "list nodes: %v", err)
+		return
+	}
+	pvs := new(corev1.PersistentVolumeList)
+	if err := c.List(ctx, pvs); err != nil {
+		r.add("orphans", StatusFail, "list persistent volumes: %v", err)
+		return
+	}
+
+	nodeMap := map[string]bool{}
+	for _, n := range nodes.Items {
+		nodeMap[n.Name] = true
+	}
+	pvMap := map[string]bool{}
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == utils.CSIPluginName {
+			pvMap[pv.Name] = true
+		}
+	}
+
+	healthy := true
+	lvMap := map[string]bool{}
+	for _, lv := range lvs.Items {
+		lvMap[lv.Name] = true
+		if lv.DeletionTimestamp != nil && time.Since(lv.DeletionTimestamp.Time) > stuckDeletionTime {
+			healthy = false
+			r.add("orphans", StatusFail, "LogicVolume %s is being deleted since %s, finish its deletion before upgrading", lv.Name, lv.DeletionTimestamp.Format(time.RFC3339))
+			continue
+		}
+		if !nodeMap[lv.Spec.NodeName] {
+			healthy = false
+			r.add("orphans", StatusWarn, "LogicVolume %s is on node %s which does not exist", lv.Name, lv.Spec.NodeName)
+		}
+		// bcache的缓存卷没有对应的pv
+		if len(lv.OwnerReferences) == 0 && lv.Status.Status != "" && !pvMap[lv.Name] {
+			healthy = false
+			r.add("orphans", StatusWarn, "LogicVolume %s has no PersistentVolume", lv.Name)
+		}
+	}
+	for name := range pvMap {
+		if !lvMap[name] {
+			healthy = false
+			r.add("orphans", StatusWarn, "PersistentVolume %s has no LogicVolume", name)
+		}
+	}
+	if healthy {
+		r.add("orphans", StatusPass, "%d LogicVolume(s) without orphans", len(lvs.Items))
+	}
+}
+
+func checkConfig(ctx context.Context, c client.Client, opt Options, r *Report) {
+	cm := new(corev1.ConfigMap)
+	if err := c.Get(ctx, client.ObjectKey{Namespace: opt.Namespace, Name: opt.ConfigMap}, cm); err != nil {
+		r.add("config", StatusFail, "get configmap %s/%s: %v", opt.Namespace, opt.ConfigMap, err)
+		return
+	}
+	cfg := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(cm.Data["config.json"]), &cfg); err != nil {
+		r.add("config", StatusFail, "config.json of configmap %s/%s is not valid json: %v", opt.Namespace, opt.ConfigMap, err)
+		return
+	}
+	results := configResults(cfg)
+	if len(results) == 0 {
+		r.add("config", StatusPass, "config.json uses no deprecated or unknown key")
+	}
+	r.Results = append(r.Results, results...)
+}
+
+// configResults 检查config.json中废弃和无法识别的配置项
+func configResults(cfg map[string]interface{}) []Result {
+	known := map[string]bool{}
+	for _, k := range knownConfigKeys {
+		known[strings.ToLower(k)] = true
+	}
+	deprecated := map[string]string{}
+	for k, v := range deprecatedConfigKeys {
+		deprecated[strings.ToLower(k)] = v
+	}
+
+	keys := make([]string, 0, len(cfg))
+	for k := range cfg {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	results := []Result{}
+	for _, k := range keys {
+		if hint, ok := deprecated[strings.ToLower(k)]; ok {
+			results = append(results, Result{Check: "config", Status: StatusFail, Message: fmt.Sprintf("%s is deprecated, %s", k, hint)})
+			continue
+		}
+		if !known[strings.ToLower(k)] {
+			results = append(results, Result{Check: "config", Status: StatusWarn, Message: fmt.Sprintf("%s is not a carina config key and is ignored", k)})
+		}
+	}
+	// 早期版本的diskSelector是正则表达式列表
+	if selectors, ok := cfg["diskSelector"].([]interface{}); ok {
+		for _, s := range selectors {
+			if _, ok := s.(map[string]interface{}); !ok {
+				results = append(results, Result{Check: "config", Status: StatusFail, Message: fmt.Sprintf("diskSelector entry %v is deprecated, entries are objects with name, re, policy and nodeLabel", s)})
+			}
+		}
+	}
+	return results
+}
+
+func checkComponents(ctx context.Context, c client.Client, opt Options, r *Report) {
+	deploy := new(appsv1.Deployment)
+	if err := c.Get(ctx, client.ObjectKey{Namespace: opt.Namespace, Name: opt.ControllerName}, deploy); err != nil {
+		r.add("version", StatusFail, "get controller deployment %s/%s: %v", opt.Namespace, opt.ControllerName, err)
+		return
+	}
+	ds := new(appsv1.DaemonSet)
+	if err := c.Get(ctx, client.ObjectKey{Namespace: opt.Namespace, Name: opt.NodeName}, ds); err != nil {
+		r.add("version", StatusFail, "get node daemonset %s/%s: %v", opt.Namespace, opt.NodeName, err)
+		return
+	}
+
+	healthy := true
+	if deploy.Spec.Replicas != nil && deploy.Status.UpdatedReplicas < *deploy.Spec.Replicas {
+		healthy = false
+		r.add("version", StatusFail, "controller deployment rollout in progress, %d/%d replicas updated", deploy.Status.UpdatedReplicas, *deploy.Spec.Replicas)
+	}
+	if ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled {
+		healthy = false
+		r.add("version", StatusFail, "node daemonset rollout in progress, %d/%d pods updated", ds.Status.UpdatedNumberScheduled, ds.Status.DesiredNumberScheduled)
+	}
+
+	controllerImages, err := podImages(ctx, c, opt.Namespace, deploy.Spec.Template.Labels, "carina-controller")
+	if err != nil {
+		r.add("version", StatusFail, "list controller pods: %v", err)
+		return
+	}
+	nodeImages, err := podImages(ctx, c, opt.Namespace, ds.Spec.Template.Labels, "carina-node")
+	if err != nil {
+		r.add("version", StatusFail, "list node pods: %v", err)
+		return
+	}
+	if len(controllerImages) > 1 {
+		healthy = false
+		r.add("version", StatusFail, "controller pods run mixed images %s", formatImages(controllerImages))
+	}
+	if len(nodeImages) > 1 {
+		healthy = false
+		r.add("version", StatusFail, "node daemons run mixed images %s", formatImages(nodeImages))
+	}
+	if len(controllerImages) == 1 && len(nodeImages) == 1 {
+		ci, ni := onlyImage(controllerImages), onlyImage(nodeImages)
+		if ci != ni {
+			healthy = false
+			r.add("version", StatusFail, "controller runs %s but node daemons run %s", ci, ni)
+		}
+	}
+	if healthy {
+		r.add("version", StatusPass, "controller and node daemons run %s", formatImages(nodeImages))
+	}
+}
+
+// podImages 统计运行command的carina容器所用的镜像
+func podImages(ctx context.Context, c client.Client, namespace string, labels map[string]string, command string) (map[string]int, error) {
+	pods := new(corev1.PodList)
+	if err := c.List(ctx, pods, client.InNamespace(namespace), client.MatchingLabels(labels)); err != nil {
+		return nil, err
+	}
+	return imageCounts(pods.Items, command), nil
+}
+
+func imageCounts(pods []corev1.Pod, command string) map[string]int {
+	images := map[string]int{}
+	for _, p := range pods {
+		for _, ct := range p.Spec.Containers {
+			if len(ct.Command) > 0 && ct.Command[0] == command {
+				images[ct.Image]++
+			}
+		}
+	}
+	return images
+}
+
+func onlyImage(images map[string]int) string {
+	for image := range images {
+		return image
+	}
+	return ""
+}
+
+func formatImages(images map[string]int) string {
+	list := []string{}
+	for image, n := range images {
+		list = append(list, fmt.Sprintf("%s (%d pod(s))", image, n))
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,111 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package preflight
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+func newCRD(stored []string, versions ...apiextv1.CustomResourceDefinitionVersion) *apiextv1.CustomResourceDefinition {
+	crd := &apiextv1.CustomResourceDefinition{}
+	crd.Spec.Versions = versions
+	crd.Status.StoredVersions = stored
+	return crd
+}
+
+func TestCrdProblem(t *testing.T) {
+	v1 := apiextv1.CustomResourceDefinitionVersion{Name: "v1", Served: true, Storage: true}
+	v1beta1 := apiextv1.CustomResourceDefinitionVersion{Name: "v1beta1", Served: true}
+	v1beta1Storage := apiextv1.CustomResourceDefinitionVersion{Name: "v1beta1", Served: true, Storage: true}
+	v1Served := apiextv1.CustomResourceDefinitionVersion{Name: "v1", Served: true}
+
+	table := []struct {
+		name string
+		crd  *apiextv1.CustomResourceDefinition
+		ok   bool
+	}{
+		{name: "current", crd: newCRD([]string{"v1"}, v1), ok: true},
+		{name: "not served", crd: newCRD([]string{"v1beta1"}, v1beta1Storage), ok: false},
+		{name: "other storage version", crd: newCRD([]string{"v1beta1"}, v1beta1Storage, v1Served), ok: false},
+		{name: "objects of old version", crd: newCRD([]string{"v1beta1", "v1"}, v1beta1, v1), ok: false},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		a.Equal(e.ok, crdProblem(e.crd, "v1") == "", e.name)
+	}
+}
+
+func TestConfigResults(t *testing.T) {
+	table := []struct {
+		name  string
+		cfg   map[string]interface{}
+		fails int
+		warns int
+	}{
+		{
+			name: "current",
+			cfg: map[string]interface{}{
+				"diskSelector":      []interface{}{map[string]interface{}{"name": "carina-vg-ssd", "re": []interface{}{"loop2+"}}},
+				"schedulerStrategy": "spreadout",
+				"fstrimInterval":    604800,
+				"logLevel":          "debug",
+			},
+		},
+		{name: "keys are case insensitive", cfg: map[string]interface{}{"diskscaninterval": "300"}},
+		{
+			name:  "old disk selector",
+			cfg:   map[string]interface{}{"diskSelector": []interface{}{"loop+", "vd+"}, "diskGroupPolicy": "type"},
+			fails: 3,
+		},
+		{name: "unknown key", cfg: map[string]interface{}{"wipePolicie": "zero"}, warns: 1},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		fails, warns := 0, 0
+		for _, r := range configResults(e.cfg) {
+			switch r.Status {
+			case StatusFail:
+				fails++
+			case StatusWarn:
+				warns++
+			}
+		}
+		a.Equal(e.fails, fails, e.name)
+		a.Equal(e.warns, warns, e.name)
+	}
+}
+
+func TestImageCounts(t *testing.T) {
+	pod := func(image string) corev1.Pod {
+		return corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "node-driver-registrar", Image: "csi-node-driver-registrar:v2.1.0"},
+			{Name: "csi-carina-node", Image: image, Command: []string{"carina-node"}},
+		}}}
+	}
+	pods := []corev1.Pod{pod("carina:v0.10.0"), pod("carina:v0.10.0"), pod("carina:v0.9.1")}
+
+	a := assert.New(t)
+	a.Equal(map[string]int{"carina:v0.10.0": 2, "carina:v0.9.1": 1}, imageCounts(pods, "carina-node"))
+	a.Empty(imageCounts(pods, "carina-controller"))
+	a.Equal("carina:v0.10.0 (2 pod(s)), carina:v0.9.1 (1 pod(s))", formatImages(imageCounts(pods, "carina-node")))
+}
@@ -0,0 +1,158 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package quota
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// DeviceGroup 裸盘卷的磁盘组形如carina-raw-ssd/sdb，配额按磁盘组统计
+func DeviceGroup(group string) string {
+	return strings.ToLower(strings.Split(group, "/")[0])
+}
+
+// Usage sums up the carina volumes of the namespace, in total and per disk group.
+// The cache volume of a bcache volume adds to the capacity of its disk group but
+// is not counted as a volume.
+func Usage(lvs []carinav1.LogicVolume, namespace string) (carinav1.QuotaUsage, map[string]carinav1.QuotaUsage) {
+	total := carinav1.QuotaUsage{Capacity: *resource.NewQuantity(0, resource.BinarySI)}
+	groups := map[string]carinav1.QuotaUsage{}
+	for _, lv := range lvs {
+		if lv.Spec.NameSpace != namespace {
+			continue
+		}
+		group := DeviceGroup(lv.Spec.DeviceGroup)
+		used, ok := groups[group]
+		if !ok {
+			used.Capacity = *resource.NewQuantity(0, resource.BinarySI)
+		}
+		used.Capacity.Add(lv.Spec.Size)
+		total.Capacity.Add(lv.Spec.Size)
+		if !isCacheVolume(lv) {
+			used.Volumes++
+			total.Volumes++
+		}
+		groups[group] = used
+	}
+	return total, groups
+}
+
+func isCacheVolume(lv carinav1.LogicVolume) bool {
+	for _, owner := range lv.OwnerReferences {
+		if owner.Kind == "LogicVolume" {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns the usage and remaining quota reported in the CarinaQuota status
+func Status(q *carinav1.CarinaQuota, total carinav1.QuotaUsage, groups map[string]carinav1.QuotaUsage) carinav1.CarinaQuotaStatus {
+	status := carinav1.CarinaQuotaStatus{
+		QuotaStatus: carinav1.QuotaStatus{Used: total, Remaining: remaining(q.Spec.Hard, total)},
+	}
+	if len(q.Spec.DeviceGroups) > 0 {
+		status.DeviceGroups = map[string]carinav1.QuotaStatus{}
+	}
+	for group, limit := range q.Spec.DeviceGroups {
+		used, ok := groups[DeviceGroup(group)]
+		if !ok {
+			used.Capacity = *resource.NewQuantity(0, resource.BinarySI)
+		}
+		status.DeviceGroups[group] = carinav1.QuotaStatus{Used: used, Remaining: remaining(limit, used)}
+	}
+	return status
+}
+
+func remaining(limit carinav1.QuotaLimit, used carinav1.QuotaUsage) carinav1.QuotaLimit {
+	result := carinav1.QuotaLimit{}
+	if limit.Capacity != nil {
+		c := limit.Capacity.DeepCopy()
+		c.Sub(used.Capacity)
+		if c.Sign() < 0 {
+			c = *resource.NewQuantity(0, resource.BinarySI)
+		}
+		result.Capacity = &c
+	}
+	if limit.Volumes != nil {
+		v := *limit.Volumes - used.Volumes
+		if v < 0 {
+			v = 0
+		}
+		result.Volumes = &v
+	}
+	return result
+}
+
+// Check returns an error if creating a volume in the disk group volumeGroup exceeds the quota.
+// requests holds the bytes requested per disk group, a bcache volume requests its backend and
+// its cache disk group. An empty volumeGroup checks capacity only, e.g. for volume expansion.
+func Check(q *carinav1.CarinaQuota, total carinav1.QuotaUsage, groups map[string]carinav1.QuotaUsage, volumeGroup string, requests map[string]int64) error {
+	var requestBytes int64
+	for _, r := range requests {
+		requestBytes += r
+	}
+	var volumes int64
+	if volumeGroup != "" {
+		volumes = 1
+	}
+	if err := exceeded(q.Spec.Hard, total, requestBytes, volumes); err != nil {
+		return fmt.Errorf("carina quota %s exceeded: %s", q.Name, err.Error())
+	}
+
+	names := []string{}
+	for group := range q.Spec.DeviceGroups {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+	for _, group := range names {
+		g := DeviceGroup(group)
+		var volumes int64
+		if volumeGroup != "" && g == DeviceGroup(volumeGroup) {
+			volumes = 1
+		}
+		var request int64
+		for rg, r := range requests {
+			if DeviceGroup(rg) == g {
+				request += r
+			}
+		}
+		if request == 0 && volumes == 0 {
+			continue
+		}
+		if err := exceeded(q.Spec.DeviceGroups[group], groups[g], request, volumes); err != nil {
+			return fmt.Errorf("carina quota %s exceeded for disk group %s: %s", q.Name, group, err.Error())
+		}
+	}
+	return nil
+}
+
+func exceeded(limit carinav1.QuotaLimit, used carinav1.QuotaUsage, requestBytes, volumes int64) error {
+	if limit.Volumes != nil && volumes > 0 && used.Volumes+volumes > *limit.Volumes {
+		return fmt.Errorf("requested 1 volume, used %d, limited %d", used.Volumes, *limit.Volumes)
+	}
+	if limit.Capacity != nil && requestBytes > 0 && used.Capacity.Value()+requestBytes > limit.Capacity.Value() {
+		return fmt.Errorf("requested %s, used %s, limited %s",
+			resource.NewQuantity(requestBytes, resource.BinarySI).String(), used.Capacity.String(), limit.Capacity.String())
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package quota
+
+import (
+	"testing"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newLV(namespace, group, size string, cache bool) carinav1.LogicVolume {
+	lv := carinav1.LogicVolume{Spec: carinav1.LogicVolumeSpec{
+		NameSpace:   namespace,
+		DeviceGroup: group,
+		Size:        resource.MustParse(size),
+	}}
+	if cache {
+		lv.OwnerReferences = []metav1.OwnerReference{{Kind: "LogicVolume", Name: "volume-backend"}}
+	}
+	return lv
+}
+
+func TestUsage(t *testing.T) {
+	lvs := []carinav1.LogicVolume{
+		newLV("default", "carina-vg-hdd", "10Gi", false),
+		newLV("default", "carina-vg-ssd", "2Gi", true),
+		newLV("default", "carina-raw-ssd/sdb", "20Gi", false),
+		newLV("other", "carina-vg-hdd", "100Gi", false),
+	}
+
+	a := assert.New(t)
+	total, groups := Usage(lvs, "default")
+	a.Equal(int64(2), total.Volumes)
+	a.Equal(int64(32<<30), total.Capacity.Value())
+	a.Equal(int64(1), groups["carina-vg-hdd"].Volumes)
+	a.Equal(int64(0), groups["carina-vg-ssd"].Volumes)
+	ssd, raw := groups["carina-vg-ssd"], groups["carina-raw-ssd"]
+	a.Equal(int64(2<<30), ssd.Capacity.Value())
+	a.Equal(int64(20<<30), raw.Capacity.Value())
+}
+
+func TestCheck(t *testing.T) {
+	capacity := resource.MustParse("50Gi")
+	ssdCapacity := resource.MustParse("10Gi")
+	volumes := int64(3)
+	q := &carinav1.CarinaQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a"},
+		Spec: carinav1.CarinaQuotaSpec{
+			Hard:         carinav1.QuotaLimit{Capacity: &capacity, Volumes: &volumes},
+			DeviceGroups: map[string]carinav1.QuotaLimit{"carina-vg-ssd": {Capacity: &ssdCapacity}},
+		},
+	}
+	lvs := []carinav1.LogicVolume{
+		newLV("default", "carina-vg-hdd", "30Gi", false),
+		newLV("default", "carina-vg-ssd", "8Gi", false),
+	}
+	total, groups := Usage(lvs, "default")
+
+	table := []struct {
+		group    string
+		requests map[string]int64
+		err      bool
+	}{
+		{group: "carina-vg-hdd", requests: map[string]int64{"carina-vg-hdd": 10 << 30}, err: false},
+		{group: "carina-vg-hdd", requests: map[string]int64{"carina-vg-hdd": 20 << 30}, err: true},
+		{group: "carina-vg-ssd", requests: map[string]int64{"carina-vg-ssd": 2 << 30}, err: false},
+		{group: "carina-vg-ssd", requests: map[string]int64{"carina-vg-ssd": 3 << 30}, err: true},
+		// bcache, the cache volume is limited by the ssd group
+		{group: "carina-vg-hdd", requests: map[string]int64{"carina-vg-hdd": 5 << 30, "carina-vg-ssd": 4 << 30}, err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		err := Check(q, total, groups, e.group, e.requests)
+		if e.err {
+			a.Error(err, e.requests)
+		} else {
+			a.NoError(err, e.requests)
+		}
+	}
+
+	lvs = append(lvs, newLV("default", "carina-vg-hdd", "1Gi", false))
+	total, groups = Usage(lvs, "default")
+	a.Error(Check(q, total, groups, "carina-vg-hdd", map[string]int64{"carina-vg-hdd": 1 << 30}))
+}
+
+func TestStatus(t *testing.T) {
+	capacity := resource.MustParse("50Gi")
+	ssdVolumes := int64(1)
+	q := &carinav1.CarinaQuota{Spec: carinav1.CarinaQuotaSpec{
+		Hard:         carinav1.QuotaLimit{Capacity: &capacity},
+		DeviceGroups: map[string]carinav1.QuotaLimit{"carina-vg-ssd": {Volumes: &ssdVolumes}},
+	}}
+	lvs := []carinav1.LogicVolume{
+		newLV("default", "carina-vg-ssd", "8Gi", false),
+		newLV("default", "carina-vg-ssd", "8Gi", false),
+	}
+	total, groups := Usage(lvs, "default")
+	status := Status(q, total, groups)
+
+	a := assert.New(t)
+	a.Equal(int64(34<<30), status.Remaining.Capacity.Value())
+	a.Nil(status.Remaining.Volumes)
+	a.Equal(int64(2), status.DeviceGroups["carina-vg-ssd"].Used.Volumes)
+	a.Equal(int64(0), *status.DeviceGroups["carina-vg-ssd"].Remaining.Volumes)
+}
@@ -0,0 +1,141 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package rbac 各组件ServiceAccount需要的最小权限，部署清单由hack/rbacgen从这里生成
+package rbac
+
+import (
+	"bytes"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const header = "# Code generated by hack/rbacgen from pkg/rbac. DO NOT EDIT.\n"
+
+var (
+	core   = []string{""}
+	carina = []string{"carina.storage.io"}
+
+	read        = []string{"get", "list", "watch"}
+	writeStatus = []string{"get", "update", "patch"}
+)
+
+// Component 一个组件的ClusterRole
+type Component struct {
+	// Name ServiceAccount和ClusterRoleBinding的名称
+	Name string
+	// ClusterRole ClusterRole的名称
+	ClusterRole string
+	Rules       []rbacv1.PolicyRule
+}
+
+// Node carina-node的权限
+// carina-node runs on every node, so its token is the one most likely to leak. It reads
+// PersistentVolumes but can not change them and can not read PersistentVolumeClaims at all. It
+// writes the status of its own objects; whatever affects other objects goes through
+// carina-controller: orphan LogicVolumes are annotated with carina.storage.io/collect-orphan and
+// deleted by carina-controller, pod conditions are set by carina-controller from the taints in
+// the NodeStorageResource.
+var Node = Component{
+	Name:        "carina-csi-node",
+	ClusterRole: "carina-csi-node-rbac",
+	Rules: []rbacv1.PolicyRule{
+		{APIGroups: core, Resources: []string{"nodes", "namespaces", "pods", "persistentvolumes"}, Verbs: read},
+		{APIGroups: core, Resources: []string{"events"}, Verbs: []string{"create", "update", "patch"}},
+		{APIGroups: carina, Resources: []string{"logicvolumes"}, Verbs: []string{"get", "list", "watch", "patch"}},
+		{APIGroups: carina, Resources: []string{"nodestorageresources"}, Verbs: []string{"get", "list", "watch", "create", "delete"}},
+		{APIGroups: carina, Resources: []string{"rebalances", "volumefreezes", "volumereplications"}, Verbs: read},
+		{APIGroups: carina, Resources: []string{"logicvolumes/status", "nodestorageresources/status", "rebalances/status", "volumefreezes/status", "volumereplications/status"}, Verbs: writeStatus},
+		{APIGroups: []string{"storage.k8s.io"}, Resources: []string{"csidrivers"}, Verbs: read},
+	},
+}
+
+// Standalone 单机模式下carina-node额外的权限，没有carina-controller时由节点设置pod的condition
+// The CSI controller service of a standalone carina-node is covered by the roles of the sidecars.
+var Standalone = Component{
+	Name:        "carina-csi-node",
+	ClusterRole: "carina-csi-node-standalone",
+	Rules: []rbacv1.PolicyRule{
+		{APIGroups: core, Resources: []string{"pods/status"}, Verbs: []string{"patch"}},
+	},
+}
+
+type metadata struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type object struct {
+	APIVersion string              `json:"apiVersion"`
+	Kind       string              `json:"kind"`
+	Metadata   metadata            `json:"metadata"`
+	Rules      []rbacv1.PolicyRule `json:"rules,omitempty"`
+	Subjects   []rbacv1.Subject    `json:"subjects,omitempty"`
+	RoleRef    *rbacv1.RoleRef     `json:"roleRef,omitempty"`
+}
+
+// RulesYaml 权限列表，helm chart中ClusterRole的rules
+func RulesYaml(c Component) ([]byte, error) {
+	b, err := yaml.Marshal(c.Rules)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(header), b...), nil
+}
+
+// Manifest 组件的ServiceAccount、ClusterRole和ClusterRoleBinding
+func Manifest(c Component, namespace string) ([]byte, error) {
+	labels := map[string]string{"class": "carina"}
+	objects := []object{
+		{APIVersion: "v1", Kind: "ServiceAccount", Metadata: metadata{Name: c.Name, Namespace: namespace, Labels: labels}},
+		{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole", Metadata: metadata{Name: c.ClusterRole, Labels: labels}, Rules: c.Rules},
+		{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "ClusterRoleBinding",
+			Metadata:   metadata{Name: c.Name, Labels: labels},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: c.Name, Namespace: namespace}},
+			RoleRef:    &rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: c.ClusterRole},
+		},
+	}
+	buf := bytes.NewBufferString(header)
+	for _, o := range objects {
+		b, err := yaml.Marshal(o)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("---\n")
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+// Files 生成的文件，路径相对于仓库根目录
+func Files() (map[string][]byte, error) {
+	files := map[string][]byte{}
+	var err error
+	if files["deploy/kubernetes/csi-node-rbac.yaml"], err = Manifest(Node, "kube-system"); err != nil {
+		return nil, err
+	}
+	if files["charts/rbac/node-rules.yaml"], err = RulesYaml(Node); err != nil {
+		return nil, err
+	}
+	if files["charts/rbac/node-standalone-rules.yaml"], err = RulesYaml(Standalone); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
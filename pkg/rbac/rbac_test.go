@@ -0,0 +1,58 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rbac
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeLeastPrivilege(t *testing.T) {
+	for _, c := range []Component{Node, Standalone} {
+		for _, r := range c.Rules {
+			for _, v := range append(append(r.Verbs, r.Resources...), r.APIGroups...) {
+				assert.NotEqual(t, "*", v, "%s: wildcard in %v", c.ClusterRole, r)
+			}
+			for _, res := range r.Resources {
+				switch res {
+				case "persistentvolumes":
+					assert.ElementsMatch(t, read, r.Verbs, "%s may only read persistentvolumes", c.ClusterRole)
+				case "persistentvolumeclaims", "secrets":
+					t.Errorf("%s may not access %s", c.ClusterRole, res)
+				case "logicvolumes":
+					assert.NotContains(t, r.Verbs, "create")
+					assert.NotContains(t, r.Verbs, "delete")
+				}
+			}
+		}
+	}
+}
+
+// TestGenerated 提交的清单与pkg/rbac一致，不一致时执行make rbac
+func TestGenerated(t *testing.T) {
+	files, err := Files()
+	assert.NoError(t, err)
+	for path, content := range files {
+		b, err := os.ReadFile(filepath.Join("..", "..", path))
+		if assert.NoError(t, err) {
+			assert.Equal(t, string(content), string(b), "%s is out of date, run make rbac", path)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rebalance
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/carina-io/carina/api"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils"
+)
+
+// minFragmentedFree vg空闲空间不足1GiB时不计算碎片
+const minFragmentedFree = 1 << 30
+
+// Fragmentation 根据pv的段计算vg空闲空间的碎片程度，并给出整理建议
+// The suggestion drains the physical volume that needs the least data moved
+// into the free space of the others, leaving it as one contiguous free segment.
+// A drain is only suggested when it yields a larger free segment than exists.
+// segments are the segments of the physical volumes keyed by pv name.
+func Fragmentation(vg api.VgGroup, segments map[string][]types.PVSegment) (carinav1beta1.VGFragmentation, bool) {
+	frag := carinav1beta1.VGFragmentation{DeviceGroup: vg.VGName}
+	lvs := map[string][]string{}
+	for _, pv := range vg.PVS {
+		if pv == nil {
+			continue
+		}
+		for _, seg := range segments[pv.PVName] {
+			if seg.LVName == "" {
+				frag.Free += seg.SegSize
+				if seg.SegSize > frag.LargestFree {
+					frag.LargestFree = seg.SegSize
+				}
+				continue
+			}
+			if !strings.HasPrefix(seg.LVName, "pvmove") && !utils.ContainsString(lvs[pv.PVName], seg.LVName) {
+				lvs[pv.PVName] = append(lvs[pv.PVName], seg.LVName)
+			}
+		}
+	}
+	if frag.Free < minFragmentedFree {
+		return frag, false
+	}
+	frag.Percent = 100 - int(frag.LargestFree*100/frag.Free)
+
+	var drain *api.PVInfo
+	for _, pv := range vg.PVS {
+		if pv == nil || pv.PVSize <= frag.LargestFree || len(lvs[pv.PVName]) == 0 {
+			continue
+		}
+		used := pv.PVSize - pv.PVFree
+		if used > frag.Free-pv.PVFree {
+			continue
+		}
+		if drain == nil || used < drain.PVSize-drain.PVFree || (used == drain.PVSize-drain.PVFree && pv.PVName < drain.PVName) {
+			drain = pv
+		}
+	}
+	if drain == nil {
+		return frag, true
+	}
+
+	for _, pv := range vg.PVS {
+		if pv != nil && pv != drain && pv.PVFree > 0 {
+			frag.TargetPVs = append(frag.TargetPVs, pv.PVName)
+		}
+	}
+	sort.Strings(frag.TargetPVs)
+	frag.DrainPV = drain.PVName
+	names := lvs[drain.PVName]
+	sort.Strings(names)
+	for _, lv := range names {
+		frag.Plan = append(frag.Plan, fmt.Sprintf("pvmove -n %s %s %s", lv, drain.PVName, strings.Join(frag.TargetPVs, " ")))
+	}
+	return frag, true
+}
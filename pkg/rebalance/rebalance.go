@@ -0,0 +1,148 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rebalance
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/carina-io/carina/api"
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+)
+
+// Move is a logical volume to move off the source physical volume
+type Move struct {
+	LVName string
+	Size   uint64
+}
+
+func usage(pv *api.PVInfo) int {
+	if pv.PVSize == 0 {
+		return 0
+	}
+	return int((pv.PVSize - pv.PVFree) * 100 / pv.PVSize)
+}
+
+// Plan 找出vg中使用率超过高水位的pv，以及使用率不超过低水位可以接收数据的pv
+// The source is relieved down to the average usage of the volume group, but
+// never below the low watermark. No plan is made when the volume group as a
+// whole is above the high watermark, there is nowhere to move the data to.
+func Plan(vg api.VgGroup, high, low int) (carinav1.RebalanceSpec, bool) {
+	spec := carinav1.RebalanceSpec{VGName: vg.VGName}
+	if high <= 0 || len(vg.PVS) < 2 {
+		return spec, false
+	}
+
+	var source *api.PVInfo
+	var size, used uint64
+	for _, pv := range vg.PVS {
+		if pv == nil || pv.PVSize == 0 {
+			continue
+		}
+		size += pv.PVSize
+		used += pv.PVSize - pv.PVFree
+		if u := usage(pv); u >= high && (source == nil || u > usage(source)) {
+			source = pv
+		}
+	}
+	if source == nil {
+		return spec, false
+	}
+
+	for _, pv := range vg.PVS {
+		if pv == nil || pv == source || pv.PVSize == 0 {
+			continue
+		}
+		if usage(pv) <= low {
+			spec.TargetPVs = append(spec.TargetPVs, pv.PVName)
+		}
+	}
+	if len(spec.TargetPVs) == 0 {
+		return spec, false
+	}
+	sort.Strings(spec.TargetPVs)
+
+	average := int((used*100 + size - 1) / size)
+	if average >= high {
+		return spec, false
+	}
+	if average < low {
+		average = low
+	}
+	spec.SourcePV = source.PVName
+	spec.TargetUsage = int32(average)
+	return spec, true
+}
+
+// SelectLVs 选择需要从源pv迁出的lv，使源pv的使用率降到targetUsage
+// Each round takes the smallest logical volume that alone covers what is left
+// to move, or the largest one when none does, so that a small imbalance does
+// not move a huge volume. Volumes that do not fit into targetFree are skipped.
+func SelectLVs(segments []types.PVSegment, size, used uint64, targetUsage int32, targetFree uint64) []Move {
+	goal := size * uint64(targetUsage) / 100
+	if used <= goal {
+		return nil
+	}
+	need := used - goal
+
+	sizes := map[string]uint64{}
+	for _, seg := range segments {
+		// 空闲段及pvmove自身的临时卷不参与迁移
+		if seg.LVName == "" || strings.HasPrefix(seg.LVName, "pvmove") {
+			continue
+		}
+		sizes[seg.LVName] += seg.SegSize
+	}
+	candidates := []Move{}
+	for name, s := range sizes {
+		candidates = append(candidates, Move{LVName: name, Size: s})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Size == candidates[j].Size {
+			return candidates[i].LVName < candidates[j].LVName
+		}
+		return candidates[i].Size < candidates[j].Size
+	})
+
+	moves := []Move{}
+	for need > 0 {
+		pick := -1
+		for i, c := range candidates {
+			if c.Size > targetFree {
+				continue
+			}
+			pick = i
+			if c.Size >= need {
+				break
+			}
+		}
+		if pick < 0 {
+			break
+		}
+		m := candidates[pick]
+		candidates = append(candidates[:pick], candidates[pick+1:]...)
+		moves = append(moves, m)
+		targetFree -= m.Size
+		if m.Size >= need {
+			need = 0
+		} else {
+			need -= m.Size
+		}
+	}
+	return moves
+}
@@ -0,0 +1,125 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rebalance
+
+import (
+	"testing"
+
+	"github.com/carina-io/carina/api"
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan(t *testing.T) {
+	pv := func(name string, size, free uint64) *api.PVInfo {
+		return &api.PVInfo{PVName: name, VGName: "carina-vg-ssd", PVSize: size << 30, PVFree: free << 30}
+	}
+	table := []struct {
+		pvs     []*api.PVInfo
+		ok      bool
+		source  string
+		targets []string
+		usage   int32
+	}{
+		{pvs: []*api.PVInfo{pv("/dev/sdb", 100, 5), pv("/dev/sdc", 100, 95), pv("/dev/sdd", 100, 100)}, ok: true, source: "/dev/sdb", targets: []string{"/dev/sdc", "/dev/sdd"}, usage: 34},
+		{pvs: []*api.PVInfo{pv("/dev/sdb", 100, 5), pv("/dev/sdc", 100, 100), pv("/dev/sdd", 100, 100), pv("/dev/sde", 100, 100)}, ok: true, source: "/dev/sdb", targets: []string{"/dev/sdc", "/dev/sdd", "/dev/sde"}, usage: 30},
+		{pvs: []*api.PVInfo{pv("/dev/sdb", 100, 50), pv("/dev/sdc", 100, 95)}},
+		{pvs: []*api.PVInfo{pv("/dev/sdb", 100, 5), pv("/dev/sdc", 100, 50)}},
+		{pvs: []*api.PVInfo{pv("/dev/sdb", 100, 5)}},
+	}
+
+	a := assert.New(t)
+	for i, e := range table {
+		spec, ok := Plan(api.VgGroup{VGName: "carina-vg-ssd", PVS: e.pvs}, 90, 30)
+		a.Equal(e.ok, ok, i)
+		if !e.ok {
+			continue
+		}
+		a.Equal(e.source, spec.SourcePV, i)
+		a.Equal(e.targets, spec.TargetPVs, i)
+		a.Equal(e.usage, spec.TargetUsage, i)
+	}
+}
+
+func TestSelectLVs(t *testing.T) {
+	seg := func(lv string, size uint64) types.PVSegment {
+		return types.PVSegment{PVName: "/dev/sdb", VGName: "carina-vg-ssd", LVName: lv, SegSize: size << 30}
+	}
+	segments := []types.PVSegment{
+		seg("thin-a_tdata", 40),
+		seg("thin-b_tdata", 10),
+		seg("thin-c_tdata", 30),
+		seg("thin-c_tdata", 5),
+		seg("thin-d_tmeta", 1),
+		seg("", 14),
+	}
+
+	a := assert.New(t)
+	// 86G used, relieve to 50G: thin-a is the smallest volume covering 36G
+	moves := SelectLVs(segments, 100<<30, 86<<30, 50, 200<<30)
+	a.Equal([]Move{{LVName: "thin-a_tdata", Size: 40 << 30}}, moves)
+
+	// relieve to 60G, the smallest volume covering 26G is thin-c
+	moves = SelectLVs(segments, 100<<30, 86<<30, 60, 200<<30)
+	a.Equal([]Move{{LVName: "thin-c_tdata", Size: 35 << 30}}, moves)
+
+	// targets only have room for small volumes
+	moves = SelectLVs(segments, 100<<30, 86<<30, 60, 20<<30)
+	a.Equal([]Move{{LVName: "thin-b_tdata", Size: 10 << 30}, {LVName: "thin-d_tmeta", Size: 1 << 30}}, moves)
+
+	a.Len(SelectLVs(segments, 100<<30, 86<<30, 90, 200<<30), 0)
+}
+
+func TestFragmentation(t *testing.T) {
+	seg := func(pv, lv string, size uint64) types.PVSegment {
+		return types.PVSegment{PVName: pv, VGName: "carina-vg-ssd", LVName: lv, SegSize: size << 30}
+	}
+	pv := func(name string, size, free uint64) *api.PVInfo {
+		return &api.PVInfo{PVName: name, VGName: "carina-vg-ssd", PVSize: size << 30, PVFree: free << 30}
+	}
+	vg := api.VgGroup{VGName: "carina-vg-ssd", PVS: []*api.PVInfo{pv("/dev/sdb", 100, 30), pv("/dev/sdc", 100, 40), pv("/dev/sdd", 100, 30)}}
+	segments := map[string][]types.PVSegment{
+		"/dev/sdb": {seg("/dev/sdb", "thin-a_tdata", 40), seg("/dev/sdb", "", 10), seg("/dev/sdb", "thin-b_tdata", 30), seg("/dev/sdb", "", 20)},
+		"/dev/sdc": {seg("/dev/sdc", "thin-c_tdata", 30), seg("/dev/sdc", "", 20), seg("/dev/sdc", "thin-d_tdata", 30), seg("/dev/sdc", "", 20)},
+		"/dev/sdd": {seg("/dev/sdd", "", 15), seg("/dev/sdd", "thin-e_tdata", 40), seg("/dev/sdd", "pvmove0", 30), seg("/dev/sdd", "", 15)},
+	}
+
+	a := assert.New(t)
+	frag, ok := Fragmentation(vg, segments)
+	a.True(ok)
+	a.Equal(uint64(100<<30), frag.Free)
+	a.Equal(uint64(20<<30), frag.LargestFree)
+	a.Equal(80, frag.Percent)
+	// sdc holds the least data, 60G fit into the 60G free on sdb and sdd
+	a.Equal("/dev/sdc", frag.DrainPV)
+	a.Equal([]string{"/dev/sdb", "/dev/sdd"}, frag.TargetPVs)
+	a.Equal([]string{"pvmove -n thin-c_tdata /dev/sdc /dev/sdb /dev/sdd", "pvmove -n thin-d_tdata /dev/sdc /dev/sdb /dev/sdd"}, frag.Plan)
+
+	// the data of any pv does not fit into the free space of the others
+	vg.PVS[0].PVFree, vg.PVS[1].PVFree = 10<<30, 10<<30
+	segments["/dev/sdb"] = []types.PVSegment{seg("/dev/sdb", "thin-a_tdata", 90), seg("/dev/sdb", "", 10)}
+	segments["/dev/sdc"] = []types.PVSegment{seg("/dev/sdc", "thin-c_tdata", 90), seg("/dev/sdc", "", 10)}
+	frag, ok = Fragmentation(vg, segments)
+	a.True(ok)
+	a.Equal(70, frag.Percent)
+	a.Empty(frag.DrainPV)
+	a.Empty(frag.Plan)
+
+	// too little free space to matter
+	_, ok = Fragmentation(api.VgGroup{VGName: "carina-vg-ssd", PVS: []*api.PVInfo{pv("/dev/sdb", 100, 0)}}, map[string][]types.PVSegment{"/dev/sdb": {seg("/dev/sdb", "thin-a_tdata", 100)}})
+	a.False(ok)
+}
@@ -0,0 +1,137 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package replication
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+)
+
+const (
+	// DefaultInterval 未设置spec.interval时两次同步的间隔
+	DefaultInterval = 5 * time.Minute
+	// MinInterval 同步间隔下限
+	MinInterval = time.Minute
+
+	// snapshotPrefix 复制快照名repl-<pv>-<generation>，lvm中再加上snap-前缀
+	snapshotPrefix = "repl-"
+
+	// Path 接收端的api路径，后接pv名
+	Path = "/replication/v1/volumes/"
+)
+
+// Interval returns the time between two syncs of the replication
+func Interval(vr *carinav1.VolumeReplication) time.Duration {
+	if vr.Spec.Interval == nil || vr.Spec.Interval.Duration <= 0 {
+		return DefaultInterval
+	}
+	if vr.Spec.Interval.Duration < MinInterval {
+		return MinInterval
+	}
+	return vr.Spec.Interval.Duration
+}
+
+// SyncDue reports whether the primary ships the next snapshot at now
+func SyncDue(vr *carinav1.VolumeReplication, now time.Time) bool {
+	if vr.Status.LastSyncAttempt == nil {
+		return true
+	}
+	return !now.Before(vr.Status.LastSyncAttempt.Add(Interval(vr)))
+}
+
+// SnapshotName returns the name of the replication snapshot of a volume at a generation
+func SnapshotName(volume string, generation int64) string {
+	return fmt.Sprintf("%s%s-%d", snapshotPrefix, volume, generation)
+}
+
+// SnapshotGeneration returns the generation of a replication snapshot of the volume, false if
+// the snapshot, with or without the snap- prefix of lvm, is not one
+func SnapshotGeneration(name, volume string) (int64, bool) {
+	name = strings.TrimPrefix(name, "snap-")
+	prefix := snapshotPrefix + volume + "-"
+	if !strings.HasPrefix(name, prefix) {
+		return 0, false
+	}
+	generation, err := strconv.ParseInt(strings.TrimPrefix(name, prefix), 10, 64)
+	if err != nil || generation <= 0 {
+		return 0, false
+	}
+	return generation, true
+}
+
+// TLSConfig returns the mutual TLS configuration of the replication endpoint or of its client
+// from tls.crt, tls.key and ca.crt in dir. Both sides present a certificate signed by the CA.
+func TLSConfig(dir string, server bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate in %s", filepath.Join(dir, "ca.crt"))
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if server {
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// Validate checks the spec of a VolumeReplication
+func Validate(vr *carinav1.VolumeReplication) error {
+	if vr.Spec.PVC == "" {
+		return fmt.Errorf("spec.pvc is required")
+	}
+	switch vr.Spec.Role {
+	case carinav1.ReplicationPrimary:
+		if vr.Spec.Peer == nil || vr.Spec.Peer.Address == "" || vr.Spec.Peer.Volume == "" {
+			return fmt.Errorf("a primary requires spec.peer.address and spec.peer.volume")
+		}
+	case carinav1.ReplicationSecondary:
+	default:
+		return fmt.Errorf("spec.role must be Primary or Secondary, got %q", vr.Spec.Role)
+	}
+	return nil
+}
+
+// Phase returns the phase of a VolumeReplication from its desired and its current role
+func Phase(vr *carinav1.VolumeReplication) string {
+	switch {
+	case vr.Spec.Role == carinav1.ReplicationPrimary && vr.Status.Role == carinav1.ReplicationSecondary:
+		return carinav1.VolumeReplicationPromoting
+	case vr.Spec.Role == carinav1.ReplicationSecondary && vr.Status.Role != carinav1.ReplicationSecondary:
+		return carinav1.VolumeReplicationDemoting
+	case vr.Status.Role == carinav1.ReplicationSecondary:
+		return carinav1.VolumeReplicationStandby
+	}
+	return carinav1.VolumeReplicationReplicating
+}
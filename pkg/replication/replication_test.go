@@ -0,0 +1,78 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package replication
+
+import (
+	"testing"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSnapshotGeneration(t *testing.T) {
+	a := assert.New(t)
+	name := SnapshotName("pvc-1", 42)
+	generation, ok := SnapshotGeneration(name, "pvc-1")
+	a.True(ok)
+	a.Equal(int64(42), generation)
+	generation, ok = SnapshotGeneration("snap-"+name, "pvc-1")
+	a.True(ok)
+	a.Equal(int64(42), generation)
+
+	// pvc-1 的快照不是 pvc-12 的
+	_, ok = SnapshotGeneration(SnapshotName("pvc-12", 42), "pvc-1")
+	a.False(ok)
+	_, ok = SnapshotGeneration("snap-user-snapshot", "pvc-1")
+	a.False(ok)
+}
+
+func TestSyncDue(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now()
+	vr := &carinav1.VolumeReplication{}
+	a.True(SyncDue(vr, now))
+	a.Equal(DefaultInterval, Interval(vr))
+
+	vr.Status.LastSyncAttempt = &metav1.Time{Time: now.Add(-4 * time.Minute)}
+	a.False(SyncDue(vr, now))
+	vr.Spec.Interval = &metav1.Duration{Duration: 10 * time.Second}
+	a.Equal(MinInterval, Interval(vr))
+	a.True(SyncDue(vr, now))
+}
+
+func TestValidateAndPhase(t *testing.T) {
+	a := assert.New(t)
+	vr := &carinav1.VolumeReplication{Spec: carinav1.VolumeReplicationSpec{PVC: "data", Role: carinav1.ReplicationPrimary}}
+	a.Error(Validate(vr))
+	vr.Spec.Peer = &carinav1.ReplicationPeer{Address: "10.0.0.1:28443", Volume: "pvc-2"}
+	a.NoError(Validate(vr))
+	vr.Spec.Role = "Mirror"
+	a.Error(Validate(vr))
+
+	vr.Spec.Role = carinav1.ReplicationSecondary
+	a.NoError(Validate(vr))
+	vr.Status.Role = carinav1.ReplicationPrimary
+	a.Equal(carinav1.VolumeReplicationDemoting, Phase(vr))
+	vr.Status.Role = carinav1.ReplicationSecondary
+	a.Equal(carinav1.VolumeReplicationStandby, Phase(vr))
+	vr.Spec.Role = carinav1.ReplicationPrimary
+	a.Equal(carinav1.VolumeReplicationPromoting, Phase(vr))
+	vr.Status.Role = carinav1.ReplicationPrimary
+	a.Equal(carinav1.VolumeReplicationReplicating, Phase(vr))
+}
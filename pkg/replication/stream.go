@@ -0,0 +1,201 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ChunkSize is the unit Delta compares and ships
+const ChunkSize = 1 << 20
+
+const (
+	frameData byte = iota
+	frameZero
+	frameEnd
+)
+
+// frameHeader type(1) offset(8) length(4)
+const frameHeader = 13
+
+// ErrIncomplete is returned by Apply when the stream ends before its end frame
+var ErrIncomplete = errors.New("replication stream ended before it was complete")
+
+// Writer encodes the changed chunks of a volume into a replication stream
+type Writer struct {
+	w      io.Writer
+	frames uint64
+	bytes  int64
+	header [frameHeader]byte
+}
+
+// NewWriter returns a Writer encoding to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) frame(kind byte, offset uint64, length uint32, data []byte) error {
+	w.header[0] = kind
+	binary.BigEndian.PutUint64(w.header[1:9], offset)
+	binary.BigEndian.PutUint32(w.header[9:13], length)
+	if _, err := w.w.Write(w.header[:]); err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if _, err := w.w.Write(data); err != nil {
+			return err
+		}
+	}
+	w.frames++
+	return nil
+}
+
+// Data ships a chunk at offset
+func (w *Writer) Data(offset int64, data []byte) error {
+	w.bytes += int64(len(data))
+	return w.frame(frameData, uint64(offset), uint32(len(data)), data)
+}
+
+// Zero ships a chunk of length zero bytes at offset without its content
+func (w *Writer) Zero(offset int64, length int) error {
+	return w.frame(frameZero, uint64(offset), uint32(length), nil)
+}
+
+// Close ends the stream, the receiver only accepts a stream with its end frame
+func (w *Writer) Close() error {
+	return w.frame(frameEnd, w.frames, 0, nil)
+}
+
+// Bytes returns the number of data bytes shipped, zero chunks are not counted
+func (w *Writer) Bytes() int64 {
+	return w.bytes
+}
+
+// Apply writes the chunks of a replication stream to dst, which must be at least size bytes,
+// and returns the number of data bytes written. Chunks beyond size fail the stream.
+func Apply(r io.Reader, dst io.WriterAt, size int64) (int64, error) {
+	var header [frameHeader]byte
+	buf := make([]byte, ChunkSize)
+	zero := make([]byte, ChunkSize)
+	var frames uint64
+	var written int64
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return written, ErrIncomplete
+			}
+			return written, err
+		}
+		kind := header[0]
+		offset := binary.BigEndian.Uint64(header[1:9])
+		length := binary.BigEndian.Uint32(header[9:13])
+		if kind == frameEnd {
+			if offset != frames {
+				return written, fmt.Errorf("replication stream has %d chunks, its end frame announces %d", frames, offset)
+			}
+			return written, nil
+		}
+		if length > ChunkSize || offset > uint64(size) || int64(offset)+int64(length) > size {
+			return written, fmt.Errorf("chunk of %d bytes at %d is out of the volume of %d bytes", length, offset, size)
+		}
+		switch kind {
+		case frameData:
+			if _, err := io.ReadFull(r, buf[:length]); err != nil {
+				return written, ErrIncomplete
+			}
+			if _, err := dst.WriteAt(buf[:length], int64(offset)); err != nil {
+				return written, err
+			}
+			written += int64(length)
+		case frameZero:
+			if _, err := dst.WriteAt(zero[:length], int64(offset)); err != nil {
+				return written, err
+			}
+		default:
+			return written, fmt.Errorf("unknown replication frame %d", kind)
+		}
+		frames++
+	}
+}
+
+// Delta ships the first size bytes of the block device cur to w. With base it only ships the
+// chunks that differ from the block device base, the snapshot the receiver already holds.
+// Without base every chunk is shipped, chunks holding only zeros as zero frames, so a receiver
+// with stale data ends up with an exact copy.
+func Delta(ctx context.Context, w *Writer, base, cur string, size int64) error {
+	in, err := os.Open(cur)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var old *os.File
+	if base != "" {
+		if old, err = os.Open(base); err != nil {
+			return err
+		}
+		defer old.Close()
+	}
+
+	buf := make([]byte, ChunkSize)
+	oldBuf := make([]byte, ChunkSize)
+	for offset := int64(0); offset < size; offset += ChunkSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		length := int64(ChunkSize)
+		if size-offset < length {
+			length = size - offset
+		}
+		chunk := buf[:length]
+		if _, err := in.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return err
+		}
+		if old != nil {
+			oldChunk := oldBuf[:length]
+			if _, err := old.ReadAt(oldChunk, offset); err != nil && err != io.EOF {
+				return err
+			}
+			if bytes.Equal(chunk, oldChunk) {
+				continue
+			}
+		}
+		if isZero(chunk) {
+			err = w.Zero(offset, len(chunk))
+		} else {
+			err = w.Data(offset, chunk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
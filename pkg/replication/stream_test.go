@@ -0,0 +1,110 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package replication
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buffer implements io.WriterAt over a byte slice
+type buffer []byte
+
+func (b buffer) WriteAt(p []byte, off int64) (int, error) {
+	return copy(b[off:], p), nil
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStream(t *testing.T) {
+	a := assert.New(t)
+	var stream bytes.Buffer
+	w := NewWriter(&stream)
+	a.NoError(w.Data(0, []byte("hello")))
+	a.NoError(w.Zero(8, 4))
+	a.NoError(w.Data(12, []byte("world")))
+	a.NoError(w.Close())
+	a.Equal(int64(10), w.Bytes())
+
+	dst := buffer(bytes.Repeat([]byte{'x'}, 20))
+	written, err := Apply(bytes.NewReader(stream.Bytes()), dst, int64(len(dst)))
+	a.NoError(err)
+	a.Equal(int64(10), written)
+	a.Equal("helloxxx\x00\x00\x00\x00worldxxx", string(dst))
+
+	// a stream cut before its end frame is not complete
+	_, err = Apply(bytes.NewReader(stream.Bytes()[:stream.Len()-1]), buffer(make([]byte, 20)), 20)
+	a.Equal(ErrIncomplete, err)
+	_, err = Apply(bytes.NewReader(stream.Bytes()[:20]), buffer(make([]byte, 20)), 20)
+	a.Equal(ErrIncomplete, err)
+
+	// chunks beyond the volume
+	_, err = Apply(bytes.NewReader(stream.Bytes()), buffer(make([]byte, 16)), 16)
+	a.Error(err)
+}
+
+func TestDelta(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "replication")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	size := int64(3*ChunkSize + 100)
+	base := make([]byte, size)
+	for i := range base[:ChunkSize] {
+		base[i] = byte(i)
+	}
+	cur := append([]byte{}, base...)
+	// 第二块改变，第一块清零
+	cur[ChunkSize+7] = 1
+	copy(cur[:ChunkSize], make([]byte, ChunkSize))
+	basePath := writeFile(t, dir, "base", base)
+	curPath := writeFile(t, dir, "cur", cur)
+
+	var stream bytes.Buffer
+	w := NewWriter(&stream)
+	a.NoError(Delta(context.Background(), w, basePath, curPath, size))
+	a.NoError(w.Close())
+	a.Equal(int64(ChunkSize), w.Bytes())
+	standby := buffer(append([]byte{}, base...))
+	_, err = Apply(&stream, standby, size)
+	a.NoError(err)
+	a.True(bytes.Equal(cur, standby))
+
+	// 全量同步覆盖备用卷上的旧数据
+	stream.Reset()
+	w = NewWriter(&stream)
+	a.NoError(Delta(context.Background(), w, "", curPath, size))
+	a.NoError(w.Close())
+	a.Equal(int64(ChunkSize), w.Bytes())
+	standby = buffer(bytes.Repeat([]byte{0xff}, int(size)))
+	_, err = Apply(&stream, standby, size)
+	a.NoError(err)
+	a.True(bytes.Equal(cur, standby))
+}
@@ -0,0 +1,183 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package simulate 模拟PVC在各节点磁盘组上的落点，不创建任何对象，用于容量规划
+package simulate
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// StrategyBinpack 优先放到剩余容量最小且满足的磁盘组，与调度器的binpack一致
+	StrategyBinpack = "binpack"
+	// StrategySpreadout 优先放到剩余容量最大的磁盘组，与调度器的spreadout一致
+	StrategySpreadout = "spreadout"
+)
+
+// Request 要模拟的一批同样大小的PVC，例如StatefulSet扩容新增的副本
+type Request struct {
+	// DeviceGroup 磁盘组，为空时可以落在任意lvm磁盘组
+	DeviceGroup string `json:"deviceGroup,omitempty"`
+	// Size 每个PVC请求的字节数
+	Size int64 `json:"size"`
+	// Count PVC的个数，默认1
+	Count int `json:"count"`
+	// Strategy binpack or spreadout
+	Strategy string `json:"strategy"`
+	// OnePerNode 每个节点最多一个，对应带反亲和的StatefulSet
+	OnePerNode bool `json:"onePerNode,omitempty"`
+}
+
+// Placement 一个PVC的落点
+type Placement struct {
+	Index       int    `json:"index"`
+	Node        string `json:"node"`
+	DeviceGroup string `json:"deviceGroup"`
+	Disk        string `json:"disk,omitempty"`
+}
+
+// Capacity 一个磁盘组（裸盘组为一块盘）模拟前后的可分配容量，单位Gi
+type Capacity struct {
+	Node          string `json:"node"`
+	DeviceGroup   string `json:"deviceGroup"`
+	Disk          string `json:"disk,omitempty"`
+	AllocatableGb int64  `json:"allocatableGb"`
+	RemainingGb   int64  `json:"remainingGb"`
+	Volumes       int    `json:"volumes"`
+}
+
+// Result 模拟的结果，Unplaced为放不下的PVC个数
+type Result struct {
+	Request    Request     `json:"request"`
+	Placements []Placement `json:"placements"`
+	Unplaced   int         `json:"unplaced"`
+	Capacity   []Capacity  `json:"capacity"`
+}
+
+// ParseRequest 解析size、group、count、strategy和onePerNode参数，strategy为空时使用defaultStrategy
+func ParseRequest(size, group, count, strategy string, onePerNode bool, defaultStrategy string) (Request, error) {
+	req := Request{DeviceGroup: group, Count: 1, Strategy: strings.ToLower(strategy), OnePerNode: onePerNode}
+	q, err := resource.ParseQuantity(size)
+	if err != nil || q.Value() <= 0 {
+		return req, fmt.Errorf("size must be a positive quantity such as 100Gi, got %q", size)
+	}
+	req.Size = q.Value()
+	if count != "" {
+		if req.Count, err = strconv.Atoi(count); err != nil || req.Count < 1 {
+			return req, fmt.Errorf("count must be a positive integer, got %q", count)
+		}
+	}
+	if req.Strategy == "" {
+		req.Strategy = strings.ToLower(defaultStrategy)
+	}
+	if req.Strategy != StrategyBinpack && req.Strategy != StrategySpreadout {
+		return req, fmt.Errorf("strategy must be %s or %s, got %q", StrategyBinpack, StrategySpreadout, strategy)
+	}
+	return req, nil
+}
+
+// Simulate 按调度器的容量规则逐个放置PVC
+// Each PVC is placed like the scheduler filters and scores a node: the request rounded up to Gi has to fit the
+// allocatable capacity of a volume group, or of a single disk of a raw disk group. Without a device group only
+// volume groups are considered, the same as a storage class without one. Nodes in skip are left out, e.g. cordoned nodes.
+func Simulate(req Request, nsrs []carinav1beta1.NodeStorageResource, skip []string) Result {
+	result := Result{Request: req, Placements: []Placement{}, Capacity: []Capacity{}}
+	for _, nsr := range nsrs {
+		if utils.ContainsString(skip, nsr.Spec.NodeName) {
+			continue
+		}
+		for key, v := range nsr.Status.Allocatable {
+			if !strings.HasPrefix(key, utils.DeviceCapacityKeyPrefix) {
+				continue
+			}
+			parts := strings.SplitN(strings.TrimPrefix(key, utils.DeviceCapacityKeyPrefix), "/", 2)
+			c := Capacity{Node: nsr.Spec.NodeName, DeviceGroup: parts[0], AllocatableGb: v.Value(), RemainingGb: v.Value()}
+			if len(parts) == 2 {
+				c.Disk = parts[1]
+			}
+			if req.DeviceGroup == "" && c.Disk != "" || req.DeviceGroup != "" && req.DeviceGroup != c.DeviceGroup {
+				continue
+			}
+			result.Capacity = append(result.Capacity, c)
+		}
+	}
+	sort.Slice(result.Capacity, func(i, j int) bool {
+		a, b := result.Capacity[i], result.Capacity[j]
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		if a.DeviceGroup != b.DeviceGroup {
+			return a.DeviceGroup < b.DeviceGroup
+		}
+		return a.Disk < b.Disk
+	})
+
+	count := req.Count
+	if count < 1 {
+		count = 1
+	}
+	requestGb := (req.Size-1)>>30 + 1
+	used := map[string]bool{}
+	for i := 0; i < count; i++ {
+		best := -1
+		for j, c := range result.Capacity {
+			if c.RemainingGb < requestGb || req.OnePerNode && used[c.Node] {
+				continue
+			}
+			if best < 0 || better(req.Strategy, c.RemainingGb, result.Capacity[best].RemainingGb) {
+				best = j
+			}
+		}
+		if best < 0 {
+			result.Unplaced = count - i
+			break
+		}
+		c := &result.Capacity[best]
+		c.RemainingGb -= requestGb
+		c.Volumes++
+		used[c.Node] = true
+		result.Placements = append(result.Placements, Placement{Index: i, Node: c.Node, DeviceGroup: c.DeviceGroup, Disk: c.Disk})
+	}
+	return result
+}
+
+// UnschedulableNodes 返回被cordon的节点，新的PVC不会落在这些节点上
+func UnschedulableNodes(nodes []corev1.Node) []string {
+	skip := []string{}
+	for _, n := range nodes {
+		if n.Spec.Unschedulable {
+			skip = append(skip, n.Name)
+		}
+	}
+	return skip
+}
+
+// better 比较两个都能放下的磁盘组，相等时保留先出现的，保证结果稳定
+func better(strategy string, remaining, best int64) bool {
+	if strategy == StrategyBinpack {
+		return remaining < best
+	}
+	return remaining > best
+}
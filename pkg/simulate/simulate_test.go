@@ -0,0 +1,90 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package simulate
+
+import (
+	"testing"
+
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func nsr(node string, allocatable map[string]int64) carinav1beta1.NodeStorageResource {
+	n := carinav1beta1.NodeStorageResource{}
+	n.Spec.NodeName = node
+	n.Status.Allocatable = map[string]resource.Quantity{}
+	for k, v := range allocatable {
+		n.Status.Allocatable[utils.DeviceCapacityKeyPrefix+k] = *resource.NewQuantity(v, resource.BinarySI)
+	}
+	return n
+}
+
+func TestParseRequest(t *testing.T) {
+	a := assert.New(t)
+	req, err := ParseRequest("100Gi", "carina-vg-ssd", "3", "", true, StrategySpreadout)
+	a.NoError(err)
+	a.Equal(Request{DeviceGroup: "carina-vg-ssd", Size: 100 << 30, Count: 3, Strategy: StrategySpreadout, OnePerNode: true}, req)
+	req, err = ParseRequest("1Gi", "", "", "BinPack", false, StrategySpreadout)
+	a.NoError(err)
+	a.Equal(1, req.Count)
+	a.Equal(StrategyBinpack, req.Strategy)
+
+	for _, e := range [][3]string{{"", "1", ""}, {"-1Gi", "1", ""}, {"1Gi", "0", ""}, {"1Gi", "2x", ""}, {"1Gi", "1", "random"}} {
+		_, err := ParseRequest(e[0], "", e[1], e[2], false, StrategySpreadout)
+		a.Error(err, e)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	a := assert.New(t)
+	nsrs := []carinav1beta1.NodeStorageResource{
+		nsr("node1", map[string]int64{"carina-vg-ssd": 100, "carina-vg-hdd": 500, "carina-raw-ssd/sdd": 200}),
+		nsr("node2", map[string]int64{"carina-vg-ssd": 300}),
+		nsr("node3", map[string]int64{"carina-vg-ssd": 50}),
+	}
+
+	r := Simulate(Request{DeviceGroup: "carina-vg-ssd", Size: 90 << 30, Count: 4, Strategy: StrategySpreadout}, nsrs, nil)
+	a.Equal(0, r.Unplaced)
+	nodes := []string{}
+	for _, p := range r.Placements {
+		nodes = append(nodes, p.Node)
+	}
+	a.Equal([]string{"node2", "node2", "node2", "node1"}, nodes)
+	a.Len(r.Capacity, 3)
+	a.Equal(Capacity{Node: "node2", DeviceGroup: "carina-vg-ssd", AllocatableGb: 300, RemainingGb: 30, Volumes: 3}, r.Capacity[1])
+
+	r = Simulate(Request{DeviceGroup: "carina-vg-ssd", Size: 40 << 30, Count: 1, Strategy: StrategyBinpack}, nsrs, nil)
+	a.Equal("node3", r.Placements[0].Node)
+
+	r = Simulate(Request{DeviceGroup: "carina-vg-ssd", Size: 60 << 30, Count: 3, Strategy: StrategySpreadout, OnePerNode: true}, nsrs, nil)
+	a.Equal(1, r.Unplaced)
+	a.Len(r.Placements, 2)
+
+	r = Simulate(Request{DeviceGroup: "carina-vg-ssd", Size: 60 << 30, Count: 1, Strategy: StrategySpreadout}, nsrs, []string{"node2"})
+	a.Equal("node1", r.Placements[0].Node)
+
+	// 不指定磁盘组时不会落到裸盘上
+	r = Simulate(Request{Size: 1 << 30, Count: 1, Strategy: StrategySpreadout}, nsrs, nil)
+	a.Equal(Placement{Node: "node1", DeviceGroup: "carina-vg-hdd"}, r.Placements[0])
+	a.Len(r.Capacity, 4)
+
+	r = Simulate(Request{DeviceGroup: "carina-raw-ssd", Size: 150 << 30, Count: 2, Strategy: StrategyBinpack}, nsrs, nil)
+	a.Equal(Placement{Node: "node1", DeviceGroup: "carina-raw-ssd", Disk: "sdd"}, r.Placements[0])
+	a.Equal(1, r.Unplaced)
+}
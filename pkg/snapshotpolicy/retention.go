@@ -0,0 +1,61 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package snapshotpolicy
+
+import (
+	"sort"
+	"time"
+)
+
+// Snapshot is a VolumeSnapshot taken by a policy
+type Snapshot struct {
+	Name    string
+	PVC     string
+	Created time.Time
+}
+
+// Expired returns the snapshots beyond the newest retention ones of each pvc, oldest first
+func Expired(snapshots []Snapshot, retention int) []Snapshot {
+	if retention < 1 {
+		retention = 1
+	}
+	byPVC := map[string][]Snapshot{}
+	for _, s := range snapshots {
+		byPVC[s.PVC] = append(byPVC[s.PVC], s)
+	}
+
+	expired := []Snapshot{}
+	for _, list := range byPVC {
+		if len(list) <= retention {
+			continue
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Created.Equal(list[j].Created) {
+				return list[i].Name > list[j].Name
+			}
+			return list[i].Created.After(list[j].Created)
+		})
+		expired = append(expired, list[retention:]...)
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if expired[i].Created.Equal(expired[j].Created) {
+			return expired[i].Name < expired[j].Name
+		}
+		return expired[i].Created.Before(expired[j].Created)
+	})
+	return expired
+}
@@ -0,0 +1,48 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package snapshotpolicy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpired(t *testing.T) {
+	base := time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC)
+	snapshots := []Snapshot{
+		{Name: "a-1", PVC: "a", Created: base},
+		{Name: "a-3", PVC: "a", Created: base.Add(2 * time.Hour)},
+		{Name: "a-2", PVC: "a", Created: base.Add(time.Hour)},
+		{Name: "b-1", PVC: "b", Created: base},
+		{Name: "c-1", PVC: "c", Created: base},
+		{Name: "c-2", PVC: "c", Created: base.Add(time.Hour)},
+	}
+
+	a := assert.New(t)
+	names := func(list []Snapshot) []string {
+		result := []string{}
+		for _, s := range list {
+			result = append(result, s.Name)
+		}
+		return result
+	}
+	a.Equal([]string{"a-1", "c-1", "a-2"}, names(Expired(snapshots, 1)))
+	a.Equal([]string{"a-1"}, names(Expired(snapshots, 2)))
+	a.Empty(Expired(snapshots, 3))
+}
@@ -0,0 +1,186 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package snapshotpolicy holds the schedule and retention logic of SnapshotPolicy.
+package snapshotpolicy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearchYears 找不到匹配时间时的搜索上限，例如2月30日
+const maxSearchYears = 5
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Schedule is a standard five field cron schedule: minute hour day-of-month month day-of-week
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar dowStar 日期和星期都被限制时，满足其一即可，与cron一致
+	domStar, dowStar bool
+}
+
+type bounds struct {
+	min, max int
+}
+
+var (
+	minutes = bounds{0, 59}
+	hours   = bounds{0, 23}
+	doms    = bounds{1, 31}
+	months  = bounds{1, 12}
+	dows    = bounds{0, 7}
+)
+
+// ParseSchedule parses a cron expression such as "30 2 * * 1-5", "*/15 * * * *" or "@daily"
+func ParseSchedule(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := descriptors[spec]; ok {
+		spec = d
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, expect 5 fields", spec)
+	}
+
+	s := &Schedule{}
+	var err error
+	if s.minute, err = parseField(fields[0], minutes); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseField(fields[1], hours); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseField(fields[2], doms); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseField(fields[3], months); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseField(fields[4], dows); err != nil {
+		return nil, err
+	}
+	// 星期日可以写作0或7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseField parses a comma separated list of *, n, a-b, each optionally followed by /step
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		start, end := b.min, b.max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			r := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(r[0]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+			if end, err = strconv.Atoi(r[1]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			start = n
+			// a/n从a开始到最大值，单独的a只有一个值
+			if step == 1 {
+				end = n
+			}
+		}
+		if start < b.min || end > b.max || start > end {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, b.min, b.max)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first scheduled time after t, the zero time if there is none within five years
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.Year() + maxSearchYears
+	for t.Year() <= limit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Last returns the latest scheduled time after since and not after now, the
+// zero time if there is none. Missed rounds are not caught up one by one.
+func (s *Schedule) Last(since, now time.Time) time.Time {
+	last := time.Time{}
+	for t := s.Next(since); !t.IsZero() && !t.After(now); t = s.Next(t) {
+		last = t
+	}
+	return last
+}
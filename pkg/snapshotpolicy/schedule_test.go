@@ -0,0 +1,88 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package snapshotpolicy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	table := []struct {
+		spec string
+		err  bool
+	}{
+		{spec: "*/15 * * * *"},
+		{spec: "30 2 * * 1-5"},
+		{spec: "0 0,12 1 */2 7"},
+		{spec: "@daily"},
+		{spec: "* * * *", err: true},
+		{spec: "60 * * * *", err: true},
+		{spec: "5-1 * * * *", err: true},
+		{spec: "*/0 * * * *", err: true},
+		{spec: "@every 1h", err: true},
+	}
+
+	a := assert.New(t)
+	for _, test := range table {
+		_, err := ParseSchedule(test.spec)
+		if test.err {
+			a.Error(err, test.spec)
+		} else {
+			a.NoError(err, test.spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// 2022-03-04 是星期五
+	from := time.Date(2022, 3, 4, 10, 7, 30, 0, time.UTC)
+	table := []struct {
+		spec string
+		next time.Time
+	}{
+		{spec: "*/15 * * * *", next: time.Date(2022, 3, 4, 10, 15, 0, 0, time.UTC)},
+		{spec: "@hourly", next: time.Date(2022, 3, 4, 11, 0, 0, 0, time.UTC)},
+		{spec: "30 2 * * *", next: time.Date(2022, 3, 5, 2, 30, 0, 0, time.UTC)},
+		{spec: "0 3 * * 1-5", next: time.Date(2022, 3, 7, 3, 0, 0, 0, time.UTC)},
+		{spec: "0 0 * * 7", next: time.Date(2022, 3, 6, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 1 * *", next: time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)},
+		// 日期和星期都限制时满足其一即可
+		{spec: "0 0 15 * 6", next: time.Date(2022, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 29 2 *", next: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 30 2 *", next: time.Time{}},
+	}
+
+	a := assert.New(t)
+	for _, test := range table {
+		s, err := ParseSchedule(test.spec)
+		a.NoError(err, test.spec)
+		a.Equal(test.next, s.Next(from), test.spec)
+	}
+}
+
+func TestLast(t *testing.T) {
+	a := assert.New(t)
+	s, err := ParseSchedule("0 * * * *")
+	a.NoError(err)
+
+	since := time.Date(2022, 3, 4, 10, 0, 0, 0, time.UTC)
+	a.True(s.Last(since, since.Add(59*time.Minute)).IsZero())
+	a.Equal(time.Date(2022, 3, 4, 13, 0, 0, 0, time.UTC), s.Last(since, since.Add(3*time.Hour+30*time.Minute)))
+}
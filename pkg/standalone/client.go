@@ -0,0 +1,200 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package standalone
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+)
+
+// NewCache returns a cache.NewCacheFunc for the manager, the carina objects are read from
+// and watched in the store, all other objects in the api server as usual.
+func NewCache(store *Store) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		c, err := cache.New(config, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &storeCache{Cache: c, store: store}, nil
+	}
+}
+
+// NewClient returns a cluster.NewClientFunc for the manager, the client writes the carina
+// objects to the store and all other objects to the api server.
+func NewClient(store *Store) cluster.NewClientFunc {
+	return func(c cache.Cache, config *rest.Config, options client.Options, uncachedObjects ...client.Object) (client.Client, error) {
+		delegate, err := cluster.DefaultNewClient(c, config, options, uncachedObjects...)
+		if err != nil {
+			return nil, err
+		}
+		return &storeClient{Client: delegate, store: store}, nil
+	}
+}
+
+type storeCache struct {
+	cache.Cache
+	store *Store
+}
+
+func (c *storeCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if c.store.Handles(obj) {
+		return c.store.Get(key, obj)
+	}
+	return c.Cache.Get(ctx, key, obj)
+}
+
+func (c *storeCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if c.store.Handles(list) {
+		return c.store.List(list, opts...)
+	}
+	return c.Cache.List(ctx, list, opts...)
+}
+
+func (c *storeCache) GetInformer(ctx context.Context, obj client.Object) (cache.Informer, error) {
+	if c.store.Handles(obj) {
+		gvk, err := c.store.objectKind(obj)
+		if err != nil {
+			return nil, err
+		}
+		return &storeInformer{store: c.store, gvk: gvk}, nil
+	}
+	return c.Cache.GetInformer(ctx, obj)
+}
+
+func (c *storeCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.Informer, error) {
+	obj, err := c.store.newObject(gvk)
+	if err == nil && c.store.Handles(obj) {
+		return &storeInformer{store: c.store, gvk: gvk}, nil
+	}
+	return c.Cache.GetInformerForKind(ctx, gvk)
+}
+
+func (c *storeCache) IndexField(ctx context.Context, obj client.Object, field string, extractValue client.IndexerFunc) error {
+	if c.store.Handles(obj) {
+		return c.store.IndexField(obj, field, extractValue)
+	}
+	return c.Cache.IndexField(ctx, obj, field, extractValue)
+}
+
+// storeInformer delivers the changes of a kind in the store, the store is always synced
+type storeInformer struct {
+	store *Store
+	gvk   schema.GroupVersionKind
+}
+
+func (i *storeInformer) AddEventHandler(handler toolscache.ResourceEventHandler) {
+	if err := i.store.AddEventHandler(i.gvk, handler); err != nil {
+		panic(fmt.Sprintf("watch %s in the standalone store: %v", i.gvk.Kind, err))
+	}
+}
+
+func (i *storeInformer) AddEventHandlerWithResyncPeriod(handler toolscache.ResourceEventHandler, _ time.Duration) {
+	i.AddEventHandler(handler)
+}
+
+func (i *storeInformer) AddIndexers(toolscache.Indexers) error {
+	return fmt.Errorf("indexers of %s are not supported in standalone mode, use IndexField", i.gvk.Kind)
+}
+
+func (i *storeInformer) HasSynced() bool {
+	return true
+}
+
+type storeClient struct {
+	client.Client
+	store *Store
+}
+
+func (c *storeClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if c.store.Handles(obj) {
+		return c.store.Get(key, obj)
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *storeClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if c.store.Handles(list) {
+		return c.store.List(list, opts...)
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *storeClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if c.store.Handles(obj) {
+		return c.store.Create(obj)
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *storeClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if c.store.Handles(obj) {
+		return c.store.Update(obj, "")
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *storeClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if c.store.Handles(obj) {
+		return c.store.Patch(obj, patch, "")
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *storeClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if c.store.Handles(obj) {
+		return c.store.Delete(obj)
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *storeClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	if c.store.Handles(obj) {
+		return fmt.Errorf("deleting all %T is not supported in standalone mode", obj)
+	}
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *storeClient) Status() client.StatusWriter {
+	return &storeStatusWriter{StatusWriter: c.Client.Status(), store: c.store}
+}
+
+type storeStatusWriter struct {
+	client.StatusWriter
+	store *Store
+}
+
+func (w *storeStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if w.store.Handles(obj) {
+		return w.store.Update(obj, "status")
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w *storeStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if w.store.Handles(obj) {
+		return w.store.Patch(obj, patch, "status")
+	}
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
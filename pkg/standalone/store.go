@@ -0,0 +1,608 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package standalone
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Store 单机模式下保存carina的LogicVolume、NodeStorageResource等对象，代替api server和crd
+// The objects of every kind are kept in memory and written to one json file per kind in the
+// directory of the store after every change. The store mimics the api server where the
+// controllers rely on it: resource versions with conflicts on stale updates, a status
+// subresource, finalizers delaying deletion and events for the controllers watching a kind.
+type Store struct {
+	dir    string
+	scheme *runtime.Scheme
+
+	mu      sync.Mutex
+	kinds   map[schema.GroupVersionKind]*kindStore
+	version uint64
+}
+
+type kindStore struct {
+	gvk      schema.GroupVersionKind
+	objects  map[types.NamespacedName]client.Object
+	indexes  map[string]client.IndexerFunc
+	handlers []toolscache.ResourceEventHandler
+}
+
+// notification event delivered to the handlers of a kind once the store is unlocked
+type notification struct {
+	handlers []toolscache.ResourceEventHandler
+	old, new client.Object
+}
+
+// NewStore opens the store in dir, objects saved by a previous run are loaded when their kind is first used
+func NewStore(dir string, scheme *runtime.Scheme) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, scheme: scheme, kinds: map[schema.GroupVersionKind]*kindStore{}}, nil
+}
+
+// Handles reports whether the object is kept in the store, these are the objects of the carina api group
+func (s *Store) Handles(obj runtime.Object) bool {
+	gvk, err := apiutil.GVKForObject(obj, s.scheme)
+	return err == nil && gvk.Group == carinav1.GroupVersion.Group
+}
+
+// objectKind returns the kind of an object or a list of objects
+func (s *Store) objectKind(obj runtime.Object) (schema.GroupVersionKind, error) {
+	gvk, err := apiutil.GVKForObject(obj, s.scheme)
+	if err != nil {
+		return gvk, err
+	}
+	if meta.IsListType(obj) {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+	return gvk, nil
+}
+
+// kind returns the objects of gvk, loading them from disk on first use. s.mu must be held.
+func (s *Store) kind(gvk schema.GroupVersionKind) (*kindStore, error) {
+	if ks, ok := s.kinds[gvk]; ok {
+		return ks, nil
+	}
+	ks := &kindStore{
+		gvk:     gvk,
+		objects: map[types.NamespacedName]client.Object{},
+		indexes: map[string]client.IndexerFunc{},
+	}
+	content, err := os.ReadFile(s.file(gvk))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(content) > 0 {
+		items := []json.RawMessage{}
+		if err := json.Unmarshal(content, &items); err != nil {
+			return nil, fmt.Errorf("corrupted %s: %v", s.file(gvk), err)
+		}
+		for _, item := range items {
+			obj, err := s.newObject(gvk)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(item, obj); err != nil {
+				return nil, fmt.Errorf("corrupted %s: %v", s.file(gvk), err)
+			}
+			ks.objects[client.ObjectKeyFromObject(obj)] = obj
+			if v, err := strconv.ParseUint(obj.GetResourceVersion(), 10, 64); err == nil && v > s.version {
+				s.version = v
+			}
+		}
+	}
+	s.kinds[gvk] = ks
+	return ks, nil
+}
+
+func (s *Store) file(gvk schema.GroupVersionKind) string {
+	return filepath.Join(s.dir, strings.ToLower(fmt.Sprintf("%s.%s.%s.json", gvk.Kind, gvk.Version, gvk.Group)))
+}
+
+func (s *Store) newObject(gvk schema.GroupVersionKind) (client.Object, error) {
+	o, err := s.scheme.New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := o.(client.Object)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an object", gvk)
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	return obj, nil
+}
+
+// save writes all objects of the kind, a crash leaves either the old or the new file
+func (s *Store) save(ks *kindStore) error {
+	keys := sortedKeys(ks.objects)
+	items := make([]client.Object, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, ks.objects[key])
+	}
+	content, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	path := s.file(ks.gvk)
+	tmp, err := os.CreateTemp(s.dir, filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *Store) nextVersion() string {
+	s.version++
+	return strconv.FormatUint(s.version, 10)
+}
+
+// notify delivers the events of a change, it must be called without holding s.mu
+func notify(n *notification) {
+	if n == nil {
+		return
+	}
+	for _, h := range n.handlers {
+		switch {
+		case n.old == nil:
+			h.OnAdd(n.new)
+		case n.new == nil:
+			h.OnDelete(n.old)
+		default:
+			h.OnUpdate(n.old, n.new)
+		}
+	}
+}
+
+func groupResource(gvk schema.GroupVersionKind) schema.GroupResource {
+	return schema.GroupResource{Group: gvk.Group, Resource: strings.ToLower(gvk.Kind) + "s"}
+}
+
+// Get copies the stored object into obj
+func (s *Store) Get(key client.ObjectKey, obj client.Object) error {
+	gvk, err := s.objectKind(obj)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ks, err := s.kind(gvk)
+	if err != nil {
+		return err
+	}
+	stored, ok := ks.objects[key]
+	if !ok {
+		return apierrors.NewNotFound(groupResource(gvk), key.Name)
+	}
+	return copyInto(stored, obj)
+}
+
+// List copies the stored objects matching the namespace, label and field selectors into list
+func (s *Store) List(list client.ObjectList, opts ...client.ListOption) error {
+	gvk, err := s.objectKind(list)
+	if err != nil {
+		return err
+	}
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ks, err := s.kind(gvk)
+	if err != nil {
+		return err
+	}
+	items := []runtime.Object{}
+	for _, key := range sortedKeys(ks.objects) {
+		obj := ks.objects[key]
+		if listOpts.Namespace != "" && obj.GetNamespace() != listOpts.Namespace {
+			continue
+		}
+		if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		if listOpts.FieldSelector != nil {
+			matched, err := ks.matchFields(obj, listOpts.FieldSelector)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				continue
+			}
+		}
+		items = append(items, obj.DeepCopyObject())
+	}
+	if err := meta.SetList(list, items); err != nil {
+		return err
+	}
+	list.SetResourceVersion(strconv.FormatUint(s.version, 10))
+	return nil
+}
+
+// matchFields supports the exact match field selectors of the fields indexed with IndexField
+func (ks *kindStore) matchFields(obj client.Object, selector fields.Selector) (bool, error) {
+	for _, r := range selector.Requirements() {
+		index, ok := ks.indexes[r.Field]
+		if !ok {
+			return false, fmt.Errorf("field %s of %s is not indexed", r.Field, ks.gvk.Kind)
+		}
+		found := false
+		for _, v := range index(obj) {
+			if v == r.Value {
+				found = true
+				break
+			}
+		}
+		if found == (r.Operator == "!=") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// IndexField registers a field the objects of the kind can be listed by
+func (s *Store) IndexField(obj client.Object, field string, extractValue client.IndexerFunc) error {
+	gvk, err := s.objectKind(obj)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ks, err := s.kind(gvk)
+	if err != nil {
+		return err
+	}
+	ks.indexes[field] = extractValue
+	return nil
+}
+
+// AddEventHandler registers a handler for the changes of the kind, the stored objects are added first like an informer does
+func (s *Store) AddEventHandler(gvk schema.GroupVersionKind, handler toolscache.ResourceEventHandler) error {
+	s.mu.Lock()
+	ks, err := s.kind(gvk)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	ks.handlers = append(ks.handlers, handler)
+	existing := []client.Object{}
+	for _, key := range sortedKeys(ks.objects) {
+		existing = append(existing, ks.objects[key].DeepCopyObject().(client.Object))
+	}
+	s.mu.Unlock()
+
+	for _, obj := range existing {
+		handler.OnAdd(obj)
+	}
+	return nil
+}
+
+// Create stores a new object
+func (s *Store) Create(obj client.Object) error {
+	gvk, err := s.objectKind(obj)
+	if err != nil {
+		return err
+	}
+	n, err := s.create(gvk, obj)
+	if err != nil {
+		return err
+	}
+	notify(n)
+	return nil
+}
+
+func (s *Store) create(gvk schema.GroupVersionKind, obj client.Object) (*notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ks, err := s.kind(gvk)
+	if err != nil {
+		return nil, err
+	}
+	if obj.GetName() == "" && obj.GetGenerateName() != "" {
+		obj.SetName(obj.GetGenerateName() + rand.String(5))
+	}
+	if obj.GetName() == "" {
+		return nil, apierrors.NewBadRequest("name is required")
+	}
+	key := client.ObjectKeyFromObject(obj)
+	if _, ok := ks.objects[key]; ok {
+		return nil, apierrors.NewAlreadyExists(groupResource(gvk), obj.GetName())
+	}
+	stored := obj.DeepCopyObject().(client.Object)
+	stored.GetObjectKind().SetGroupVersionKind(gvk)
+	stored.SetUID(uuid.NewUUID())
+	stored.SetCreationTimestamp(metav1.NewTime(time.Now()))
+	stored.SetDeletionTimestamp(nil)
+	stored.SetGeneration(1)
+	stored.SetResourceVersion(s.nextVersion())
+	ks.objects[key] = stored
+	if err := s.save(ks); err != nil {
+		delete(ks.objects, key)
+		return nil, err
+	}
+	if err := copyInto(stored, obj); err != nil {
+		return nil, err
+	}
+	return &notification{handlers: ks.handlers, new: stored.DeepCopyObject().(client.Object)}, nil
+}
+
+// Update replaces the object, subresource "status" replaces only its status and "" everything but its status
+func (s *Store) Update(obj client.Object, subresource string) error {
+	gvk, err := s.objectKind(obj)
+	if err != nil {
+		return err
+	}
+	n, err := s.update(gvk, obj, nil, subresource)
+	if err != nil {
+		return err
+	}
+	notify(n)
+	return nil
+}
+
+// Patch applies a json merge patch to the object
+func (s *Store) Patch(obj client.Object, patch client.Patch, subresource string) error {
+	gvk, err := s.objectKind(obj)
+	if err != nil {
+		return err
+	}
+	if patch.Type() != types.MergePatchType {
+		return apierrors.NewBadRequest(fmt.Sprintf("patch type %s is not supported in standalone mode", patch.Type()))
+	}
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	n, err := s.update(gvk, obj, data, subresource)
+	if err != nil {
+		return err
+	}
+	notify(n)
+	return nil
+}
+
+func (s *Store) update(gvk schema.GroupVersionKind, obj client.Object, mergePatch []byte, subresource string) (*notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ks, err := s.kind(gvk)
+	if err != nil {
+		return nil, err
+	}
+	key := client.ObjectKeyFromObject(obj)
+	old, ok := ks.objects[key]
+	if !ok {
+		return nil, apierrors.NewNotFound(groupResource(gvk), key.Name)
+	}
+	oldContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(old)
+	if err != nil {
+		return nil, err
+	}
+
+	var content map[string]interface{}
+	if mergePatch != nil {
+		patch := map[string]interface{}{}
+		if err := json.Unmarshal(mergePatch, &patch); err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+		content = mergeJSON(runtime.DeepCopyJSON(oldContent), patch).(map[string]interface{})
+	} else {
+		content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, err
+		}
+	}
+	updated, err := s.newObject(gvk)
+	if err != nil {
+		return nil, err
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, updated); err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	if rv := updated.GetResourceVersion(); rv != "" && rv != old.GetResourceVersion() {
+		return nil, apierrors.NewConflict(groupResource(gvk), key.Name,
+			fmt.Errorf("the object has been modified; please apply your changes to the latest version and try again"))
+	}
+
+	// 与status子资源一致：更新对象时保留原status，更新status时只修改status
+	updatedContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(updated)
+	if err != nil {
+		return nil, err
+	}
+	if subresource == "status" {
+		status := updatedContent["status"]
+		updatedContent = runtime.DeepCopyJSON(oldContent)
+		if status == nil {
+			delete(updatedContent, "status")
+		} else {
+			updatedContent["status"] = status
+		}
+	} else if status, ok := oldContent["status"]; ok {
+		updatedContent["status"] = status
+	} else {
+		delete(updatedContent, "status")
+	}
+	stored, err := s.newObject(gvk)
+	if err != nil {
+		return nil, err
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(updatedContent, stored); err != nil {
+		return nil, err
+	}
+	stored.GetObjectKind().SetGroupVersionKind(gvk)
+	stored.SetUID(old.GetUID())
+	stored.SetCreationTimestamp(old.GetCreationTimestamp())
+	stored.SetDeletionTimestamp(old.GetDeletionTimestamp())
+	stored.SetGeneration(old.GetGeneration())
+	if !reflect.DeepEqual(oldContent["spec"], updatedContent["spec"]) {
+		stored.SetGeneration(old.GetGeneration() + 1)
+	}
+	stored.SetResourceVersion(old.GetResourceVersion())
+	// 比较json内容，resource.Quantity等类型的缓存字段不影响判断
+	storedContent, err := runtime.DefaultUnstructuredConverter.ToUnstructured(stored)
+	if err != nil {
+		return nil, err
+	}
+	if reflect.DeepEqual(oldContent, storedContent) {
+		return nil, copyInto(old, obj)
+	}
+	stored.SetResourceVersion(s.nextVersion())
+
+	// 删除中的对象去掉最后一个finalizer后删除
+	if stored.GetDeletionTimestamp() != nil && len(stored.GetFinalizers()) == 0 {
+		delete(ks.objects, key)
+		if err := s.save(ks); err != nil {
+			ks.objects[key] = old
+			return nil, err
+		}
+		if err := copyInto(stored, obj); err != nil {
+			return nil, err
+		}
+		return &notification{handlers: ks.handlers, old: old}, nil
+	}
+	ks.objects[key] = stored
+	if err := s.save(ks); err != nil {
+		ks.objects[key] = old
+		return nil, err
+	}
+	if err := copyInto(stored, obj); err != nil {
+		return nil, err
+	}
+	return &notification{handlers: ks.handlers, old: old, new: stored.DeepCopyObject().(client.Object)}, nil
+}
+
+// Delete removes the object, an object with finalizers is only marked deleted until they are removed
+func (s *Store) Delete(obj client.Object) error {
+	gvk, err := s.objectKind(obj)
+	if err != nil {
+		return err
+	}
+	n, err := s.delete(gvk, client.ObjectKeyFromObject(obj))
+	if err != nil {
+		return err
+	}
+	notify(n)
+	return nil
+}
+
+func (s *Store) delete(gvk schema.GroupVersionKind, key client.ObjectKey) (*notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ks, err := s.kind(gvk)
+	if err != nil {
+		return nil, err
+	}
+	old, ok := ks.objects[key]
+	if !ok {
+		return nil, apierrors.NewNotFound(groupResource(gvk), key.Name)
+	}
+	if len(old.GetFinalizers()) > 0 {
+		if old.GetDeletionTimestamp() != nil {
+			return nil, nil
+		}
+		stored := old.DeepCopyObject().(client.Object)
+		now := metav1.NewTime(time.Now())
+		stored.SetDeletionTimestamp(&now)
+		stored.SetResourceVersion(s.nextVersion())
+		ks.objects[key] = stored
+		if err := s.save(ks); err != nil {
+			ks.objects[key] = old
+			return nil, err
+		}
+		return &notification{handlers: ks.handlers, old: old, new: stored.DeepCopyObject().(client.Object)}, nil
+	}
+	delete(ks.objects, key)
+	if err := s.save(ks); err != nil {
+		ks.objects[key] = old
+		return nil, err
+	}
+	return &notification{handlers: ks.handlers, old: old}, nil
+}
+
+// mergeJSON applies a json merge patch (RFC 7386) to doc
+func mergeJSON(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]interface{})
+	if !ok {
+		d = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+			continue
+		}
+		d[k] = mergeJSON(d[k], v)
+	}
+	return d
+}
+
+// copyInto copies src into dst, both pointers to the same struct type
+func copyInto(src, dst client.Object) error {
+	sv, dv := reflect.ValueOf(src.DeepCopyObject()), reflect.ValueOf(dst)
+	if sv.Type() != dv.Type() {
+		return fmt.Errorf("can not copy %T into %T", src, dst)
+	}
+	dv.Elem().Set(sv.Elem())
+	return nil
+}
+
+func sortedKeys(objects map[types.NamespacedName]client.Object) []types.NamespacedName {
+	keys := make([]types.NamespacedName, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
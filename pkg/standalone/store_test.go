@@ -0,0 +1,114 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package standalone
+
+import (
+	"testing"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestStore(t *testing.T, dir string) *Store {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, carinav1.AddToScheme(scheme))
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	s, err := NewStore(dir, scheme)
+	assert.NoError(t, err)
+	return s
+}
+
+func TestStore(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+	s := newTestStore(t, dir)
+	a.True(s.Handles(&carinav1.LogicVolume{}))
+	a.True(s.Handles(&carinav1.LogicVolumeList{}))
+	a.False(s.Handles(&corev1.Pod{}))
+
+	events := []string{}
+	a.NoError(s.AddEventHandler(carinav1.GroupVersion.WithKind("LogicVolume"), toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { events = append(events, "add "+obj.(client.Object).GetName()) },
+		UpdateFunc: func(_, obj interface{}) { events = append(events, "update "+obj.(client.Object).GetName()) },
+		DeleteFunc: func(obj interface{}) { events = append(events, "delete "+obj.(client.Object).GetName()) },
+	}))
+	a.NoError(s.IndexField(&carinav1.LogicVolume{}, "status.volumeID", func(o client.Object) []string {
+		return []string{o.(*carinav1.LogicVolume).Status.VolumeID}
+	}))
+
+	lv := &carinav1.LogicVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: "default", Finalizers: []string{"carina.storage.io/logicvolume"}},
+		Spec:       carinav1.LogicVolumeSpec{NodeName: "node1", DeviceGroup: "carina-vg-ssd", Size: resource.MustParse("1Gi")},
+	}
+	a.NoError(s.Create(lv))
+	a.NotEmpty(lv.UID)
+	a.True(apierrors.IsAlreadyExists(s.Create(lv.DeepCopy())))
+
+	// 更新对象不修改status，更新status不修改spec
+	stale := lv.DeepCopy()
+	lv.Status.VolumeID = "volume-pvc-1"
+	a.NoError(s.Update(lv, ""))
+	a.Empty(lv.Status.VolumeID)
+	lv.Status.VolumeID = "volume-pvc-1"
+	lv.Spec.NodeName = "node2"
+	a.NoError(s.Update(lv, "status"))
+	a.Equal("node1", lv.Spec.NodeName)
+	a.Equal("volume-pvc-1", lv.Status.VolumeID)
+	stale.Spec.Size = resource.MustParse("2Gi")
+	a.True(apierrors.IsConflict(s.Update(stale, "")))
+
+	// 合并补丁
+	base := lv.DeepCopy()
+	lv.Annotations = map[string]string{"carina.storage.io/resize": "true"}
+	a.NoError(s.Patch(lv, client.MergeFrom(base), ""))
+	a.Equal(int64(1), lv.Generation)
+	base = lv.DeepCopy()
+	lv.Spec.Size = resource.MustParse("2Gi")
+	a.NoError(s.Patch(lv, client.MergeFromWithOptions(base, client.MergeFromWithOptimisticLock{}), ""))
+	a.Equal(int64(2), lv.Generation)
+	a.True(apierrors.IsConflict(s.Patch(lv, client.MergeFromWithOptions(base, client.MergeFromWithOptimisticLock{}), "")))
+
+	list := &carinav1.LogicVolumeList{}
+	a.NoError(s.List(list, client.MatchingFields{"status.volumeID": "volume-pvc-1"}))
+	a.Len(list.Items, 1)
+	a.NoError(s.List(list, client.MatchingFields{"status.volumeID": "volume-pvc-2"}))
+	a.Len(list.Items, 0)
+
+	// 重新打开后对象仍在
+	reopened := newTestStore(t, dir)
+	got := &carinav1.LogicVolume{}
+	a.NoError(reopened.Get(client.ObjectKeyFromObject(lv), got))
+	a.Equal(lv.ResourceVersion, got.ResourceVersion)
+	a.Equal("2Gi", got.Spec.Size.String())
+
+	// finalizer移除后才删除
+	a.NoError(s.Delete(lv))
+	a.NoError(s.Get(client.ObjectKeyFromObject(lv), lv))
+	a.NotNil(lv.DeletionTimestamp)
+	lv.Finalizers = nil
+	a.NoError(s.Update(lv, ""))
+	a.True(apierrors.IsNotFound(s.Get(client.ObjectKeyFromObject(lv), lv)))
+
+	a.Equal([]string{"add pvc-1", "update pvc-1", "update pvc-1", "update pvc-1", "update pvc-1", "delete pvc-1"}, events)
+}
@@ -0,0 +1,129 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/carina-io/carina/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	instrumentationName = "github.com/carina-io/carina"
+	traceParentHeader   = "traceparent"
+	// ProvisioningWindow 卷创建后这段时间内的节点操作归入创建卷的trace，之后只做关联
+	ProvisioningWindow = time.Hour
+)
+
+var propagator = propagation.TraceContext{}
+
+// Setup exports the spans of the component to the OTLP gRPC endpoint, host:port of e.g. an
+// opentelemetry collector. Tracing stays disabled and every span is a no-op without an endpoint.
+// The returned function flushes the pending spans.
+func Setup(ctx context.Context, component, nodeName, endpoint string, insecure bool, sampleRatio float64) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []attribute.KeyValue{
+		semconv.ServiceNameKey.String(component),
+		semconv.ServiceVersionKey.String(utils.Version),
+	}
+	if nodeName != "" {
+		attrs = append(attrs, semconv.HostNameKey.String(nodeName))
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, attrs...)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span of carina as child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject 将ctx中的span写入注解，节点上对该对象的操作可以接续同一个trace
+func Inject(ctx context.Context, annotations map[string]string) {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	if parent := carrier.Get(traceParentHeader); parent != "" {
+		annotations[utils.AnnTraceParent] = parent
+	}
+}
+
+// Extract returns ctx with the span context recorded by Inject in the annotations as remote parent
+func Extract(ctx context.Context, annotations map[string]string) context.Context {
+	parent := annotations[utils.AnnTraceParent]
+	if parent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{traceParentHeader: parent})
+}
+
+// StartFromObject starts a span for an operation on the object. Within ProvisioningWindow after the object
+// was created the span continues the trace injected in its annotations and links the span in ctx, later
+// operations are children of the span in ctx and only link the trace of the object.
+func StartFromObject(ctx context.Context, name string, obj metav1.Object, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("object", obj.GetName()))
+	remote := trace.SpanContextFromContext(Extract(context.Background(), obj.GetAnnotations()))
+	if !remote.IsValid() {
+		return Start(ctx, name, attrs...)
+	}
+	local := trace.SpanContextFromContext(ctx)
+	tracer := otel.Tracer(instrumentationName)
+	if time.Since(obj.GetCreationTimestamp().Time) < ProvisioningWindow {
+		opts := []trace.SpanStartOption{trace.WithAttributes(attrs...)}
+		if local.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: local}))
+		}
+		// 保留ctx的取消和日志字段，只替换父span
+		return tracer.Start(trace.ContextWithRemoteSpanContext(ctx, remote), name, opts...)
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...), trace.WithLinks(trace.Link{SpanContext: remote}))
+}
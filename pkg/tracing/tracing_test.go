@@ -0,0 +1,71 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStartFromObject(t *testing.T) {
+	a := assert.New(t)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	// 未启用tracing时不写注解
+	annotations := map[string]string{}
+	Inject(context.Background(), annotations)
+	a.Empty(annotations)
+
+	ctx, create := Start(context.Background(), "CreateVolume")
+	Inject(ctx, annotations)
+	a.Contains(annotations[utils.AnnTraceParent], create.SpanContext().TraceID().String())
+	End(create, nil)
+
+	publishCtx, publish := Start(context.Background(), "NodePublishVolume")
+
+	lv := &metav1.ObjectMeta{Name: "pvc-1", Annotations: annotations, CreationTimestamp: metav1.Now()}
+	_, span := StartFromObject(publishCtx, "publish", lv)
+	End(span, errors.New("mount failed"))
+	a.Equal(create.SpanContext().TraceID(), span.SpanContext().TraceID())
+
+	lv.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * ProvisioningWindow))
+	_, span = StartFromObject(publishCtx, "publish", lv)
+	span.End()
+	a.NotEqual(create.SpanContext().TraceID(), span.SpanContext().TraceID())
+	publish.End()
+
+	spans := recorder.Ended()
+	a.Len(spans, 4)
+	a.Equal(codes.Error, spans[1].Status().Code)
+	a.Len(spans[1].Links(), 1)
+	a.Equal(create.SpanContext().TraceID(), spans[2].Links()[0].SpanContext.TraceID())
+
+	// 没有注解时是ctx中span的子span
+	_, span = StartFromObject(publishCtx, "publish", &metav1.ObjectMeta{Name: "pvc-2"})
+	a.Equal(publish.SpanContext().TraceID(), span.SpanContext().TraceID())
+}
@@ -0,0 +1,157 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package volumeactivity
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+)
+
+const (
+	// SampleInterval 读取/proc/diskstats的间隔
+	SampleInterval = 5 * time.Minute
+	// RecordGranularity LogicVolume的lastActivity最多每小时更新一次，避免频繁写api server
+	RecordGranularity = time.Hour
+)
+
+// ParseDiskStats returns the number of completed reads and writes of every device in
+// /proc/diskstats keyed by "major:minor"
+func ParseDiskStats(content string) map[string]uint64 {
+	result := map[string]uint64{}
+	for _, line := range strings.Split(content, "\n") {
+		// major minor name reads merged sectors ms writes ...
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			continue
+		}
+		reads, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		writes, err := strconv.ParseUint(fields[7], 10, 64)
+		if err != nil {
+			continue
+		}
+		result[fields[0]+":"+fields[1]] = reads + writes
+	}
+	return result
+}
+
+// DeviceKey returns the "major:minor" key of the device of the LogicVolume in ParseDiskStats
+func DeviceKey(lv *carinav1.LogicVolume) string {
+	if lv.Status.DeviceMajor == 0 && lv.Status.DeviceMinor == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", lv.Status.DeviceMajor, lv.Status.DeviceMinor)
+}
+
+// Tracker remembers the I/O counters of the volumes between two samples
+type Tracker struct {
+	counters map[string]uint64
+}
+
+// NewTracker returns a Tracker without samples
+func NewTracker() *Tracker {
+	return &Tracker{counters: map[string]uint64{}}
+}
+
+// Observe records the counters of a sample keyed by volume and returns the volumes with I/O
+// since the previous sample. The first sample of a volume is only the baseline, counters that
+// went backwards, e.g. because the device was recreated, count as activity.
+func (t *Tracker) Observe(counters map[string]uint64) []string {
+	active := []string{}
+	for name, c := range counters {
+		if last, ok := t.counters[name]; ok && c != last {
+			active = append(active, name)
+		}
+		t.counters[name] = c
+	}
+	for name := range t.counters {
+		if _, ok := counters[name]; !ok {
+			delete(t.counters, name)
+		}
+	}
+	sort.Strings(active)
+	return active
+}
+
+// NeedsRecord returns whether the activity seen at now has to be written to the LogicVolume
+func NeedsRecord(lv *carinav1.LogicVolume, now time.Time) bool {
+	return lv.Status.LastActivity == nil || now.Sub(lv.Status.LastActivity.Time) >= RecordGranularity
+}
+
+// LastActivity returns the last activity of the LogicVolume, its creation if no I/O was recorded yet
+func LastActivity(lv *carinav1.LogicVolume) time.Time {
+	if lv.Status.LastActivity != nil {
+		return lv.Status.LastActivity.Time
+	}
+	return lv.CreationTimestamp.Time
+}
+
+// IdleVolume a pvc without I/O for a while
+type IdleVolume struct {
+	Namespace    string
+	Pvc          string
+	Node         string
+	DeviceGroup  string
+	SizeBytes    int64
+	LastActivity time.Time
+	Idle         time.Duration
+}
+
+// IdleVolumes returns the pvcs whose LogicVolumes saw no I/O for at least minIdle, the longest idle
+// first. The LogicVolumes of a bcache volume belong to the same pvc, the most recent activity counts.
+func IdleVolumes(lvs []carinav1.LogicVolume, minIdle time.Duration, now time.Time) []IdleVolume {
+	byPvc := map[string]*IdleVolume{}
+	for i := range lvs {
+		lv := &lvs[i]
+		if lv.Spec.Pvc == "" || lv.DeletionTimestamp != nil {
+			continue
+		}
+		key := lv.Spec.NameSpace + "/" + lv.Spec.Pvc
+		last := LastActivity(lv)
+		v, ok := byPvc[key]
+		if !ok {
+			v = &IdleVolume{Namespace: lv.Spec.NameSpace, Pvc: lv.Spec.Pvc, Node: lv.Spec.NodeName, DeviceGroup: lv.Spec.DeviceGroup, LastActivity: last}
+			byPvc[key] = v
+		}
+		v.SizeBytes += lv.Spec.Size.Value()
+		if last.After(v.LastActivity) {
+			v.LastActivity = last
+		}
+	}
+
+	result := []IdleVolume{}
+	for _, v := range byPvc {
+		v.Idle = now.Sub(v.LastActivity)
+		if v.Idle >= minIdle {
+			result = append(result, *v)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Idle != result[j].Idle {
+			return result[i].Idle > result[j].Idle
+		}
+		return result[i].Namespace+"/"+result[i].Pvc < result[j].Namespace+"/"+result[j].Pvc
+	})
+	return result
+}
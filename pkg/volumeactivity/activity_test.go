@@ -0,0 +1,94 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package volumeactivity
+
+import (
+	"testing"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseDiskStats(t *testing.T) {
+	stats := `   8       0 sda 1043 12 83214 420 2210 381 61880 2912 0 2740 3333 0 0 0 0
+ 253       3 dm-3 120 0 4096 16 7 0 56 4 0 20 20 0 0 0 0
+ 253       4 dm-4 0 0 0 0 0 0 0 0 0 0 0
+   7       0 loop0
+`
+	assert.Equal(t, map[string]uint64{"8:0": 3253, "253:3": 127, "253:4": 0}, ParseDiskStats(stats))
+}
+
+func TestTrackerObserve(t *testing.T) {
+	a := assert.New(t)
+	tr := NewTracker()
+	a.Empty(tr.Observe(map[string]uint64{"a": 10, "b": 5}))
+	a.Equal([]string{"a"}, tr.Observe(map[string]uint64{"a": 12, "b": 5}))
+	// c是新卷，b被删除后重建计数归零
+	a.Equal([]string{"b"}, tr.Observe(map[string]uint64{"a": 12, "b": 0, "c": 1}))
+	a.Empty(tr.Observe(map[string]uint64{"a": 12, "c": 1}))
+	a.Empty(tr.Observe(map[string]uint64{"a": 12, "b": 3, "c": 1}))
+}
+
+func TestIdleVolumes(t *testing.T) {
+	now := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	lv := func(name, pvc string, created time.Time, last *time.Time) carinav1.LogicVolume {
+		v := carinav1.LogicVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)},
+			Spec:       carinav1.LogicVolumeSpec{NameSpace: "ns", Pvc: pvc, NodeName: "n1", Size: resource.MustParse("1Gi")},
+		}
+		if last != nil {
+			t := metav1.NewTime(*last)
+			v.Status.LastActivity = &t
+		}
+		return v
+	}
+	tenDays, twoDays := now.Add(-10*day), now.Add(-2*day)
+	lvs := []carinav1.LogicVolume{
+		lv("pvc-1", "a", now.Add(-40*day), &tenDays),
+		lv("pvc-2", "b", now.Add(-40*day), nil),
+		lv("pvc-3", "c", now.Add(-40*day), &twoDays),
+		// bcache的缓存卷和后端卷属于同一个pvc
+		lv("pvc-4", "d", now.Add(-40*day), &tenDays),
+		lv("pvc-4-cache", "d", now.Add(-40*day), &twoDays),
+		lv("spare", "", now.Add(-40*day), nil),
+	}
+
+	idle := IdleVolumes(lvs, 7*day, now)
+	a := assert.New(t)
+	a.Len(idle, 2)
+	a.Equal("b", idle[0].Pvc)
+	a.Equal(40*day, idle[0].Idle)
+	a.Equal("a", idle[1].Pvc)
+	a.Equal(10*day, idle[1].Idle)
+	a.Equal(int64(1<<30), idle[1].SizeBytes)
+
+	a.Len(IdleVolumes(lvs, 0, now), 4)
+}
+
+func TestNeedsRecord(t *testing.T) {
+	now := time.Now()
+	lv := &carinav1.LogicVolume{}
+	assert.True(t, NeedsRecord(lv, now))
+	recent := metav1.NewTime(now.Add(-10 * time.Minute))
+	lv.Status.LastActivity = &recent
+	assert.False(t, NeedsRecord(lv, now))
+	assert.True(t, NeedsRecord(lv, now.Add(RecordGranularity)))
+}
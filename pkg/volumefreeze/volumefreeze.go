@@ -0,0 +1,120 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package volumefreeze
+
+import (
+	"fmt"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+)
+
+const (
+	// DefaultTimeout 未指定timeout时冻结的最长时间
+	DefaultTimeout = 5 * time.Minute
+	// MaxTimeout 冻结时间上限，写入被阻塞的时间过长会导致业务超时
+	MaxTimeout = time.Hour
+	// ThawGracePeriod 截止时间之后等待节点上报解冻的时间，之后不再等待离线的节点
+	ThawGracePeriod = 5 * time.Minute
+)
+
+// Timeout returns the timeout of the freeze, DefaultTimeout if unset and at most MaxTimeout
+func Timeout(vf *carinav1.VolumeFreeze) time.Duration {
+	if vf.Spec.Timeout == nil || vf.Spec.Timeout.Duration <= 0 {
+		return DefaultTimeout
+	}
+	if vf.Spec.Timeout.Duration > MaxTimeout {
+		return MaxTimeout
+	}
+	return vf.Spec.Timeout.Duration
+}
+
+// Expired returns whether the thaw deadline of the freeze has passed
+func Expired(vf *carinav1.VolumeFreeze, now time.Time) bool {
+	return vf.Status.ThawDeadline != nil && !now.Before(vf.Status.ThawDeadline.Time)
+}
+
+// ShouldThaw returns whether the nodes have to thaw the volumes of the freeze
+func ShouldThaw(vf *carinav1.VolumeFreeze, now time.Time) bool {
+	switch vf.Status.Phase {
+	case carinav1.VolumeFreezeThawing, carinav1.VolumeFreezeThawed, carinav1.VolumeFreezeFailed:
+		return true
+	}
+	return vf.DeletionTimestamp != nil || vf.Spec.Thaw || Expired(vf, now)
+}
+
+// Next returns the phase and message following the reported state of the volumes.
+// A freeze that failed on one volume thaws the others before it ends as Failed, a volume
+// still frozen after ThawGracePeriod, e.g. on a node that is down, fails the freeze too.
+func Next(vf *carinav1.VolumeFreeze, now time.Time) (string, string) {
+	frozen, failed := []carinav1.FrozenVolume{}, ""
+	for _, v := range vf.Status.Volumes {
+		if v.Frozen {
+			frozen = append(frozen, v)
+		} else if v.Message != "" && failed == "" {
+			failed = fmt.Sprintf("pvc %s: %s", v.PVC, v.Message)
+		}
+	}
+
+	switch vf.Status.Phase {
+	case carinav1.VolumeFreezeFreezing, carinav1.VolumeFreezeFrozen:
+		switch {
+		case failed != "":
+			return carinav1.VolumeFreezeThawing, failed
+		case vf.DeletionTimestamp != nil:
+			return carinav1.VolumeFreezeThawing, "volume freeze deleted"
+		case vf.Spec.Thaw:
+			return carinav1.VolumeFreezeThawing, "thaw requested"
+		case Expired(vf, now):
+			return carinav1.VolumeFreezeThawing, "thaw deadline reached"
+		case vf.Status.Phase == carinav1.VolumeFreezeFreezing && len(frozen) == len(vf.Status.Volumes):
+			return carinav1.VolumeFreezeFrozen, ""
+		}
+	case carinav1.VolumeFreezeThawing:
+		if len(frozen) > 0 {
+			if vf.Status.ThawDeadline == nil || now.Before(vf.Status.ThawDeadline.Add(ThawGracePeriod)) {
+				break
+			}
+			return carinav1.VolumeFreezeFailed, fmt.Sprintf("pvc %s was not reported thawed by node %s", frozen[0].PVC, frozen[0].Node)
+		}
+		if failed != "" {
+			return carinav1.VolumeFreezeFailed, failed
+		}
+		return carinav1.VolumeFreezeThawed, vf.Status.Message
+	}
+	return vf.Status.Phase, vf.Status.Message
+}
+
+// RequeueAfter returns the time until the freeze has to be looked at again without any update of it
+func RequeueAfter(vf *carinav1.VolumeFreeze, now time.Time) time.Duration {
+	if vf.Status.ThawDeadline == nil {
+		return 0
+	}
+	var deadline time.Time
+	switch vf.Status.Phase {
+	case carinav1.VolumeFreezeFreezing, carinav1.VolumeFreezeFrozen:
+		deadline = vf.Status.ThawDeadline.Time
+	case carinav1.VolumeFreezeThawing:
+		deadline = vf.Status.ThawDeadline.Add(ThawGracePeriod)
+	default:
+		return 0
+	}
+	if !now.Before(deadline) {
+		return time.Second
+	}
+	return deadline.Sub(now)
+}
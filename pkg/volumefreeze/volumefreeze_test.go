@@ -0,0 +1,98 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package volumefreeze
+
+import (
+	"testing"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTimeout(t *testing.T) {
+	a := assert.New(t)
+	for _, c := range []struct {
+		timeout *metav1.Duration
+		expect  time.Duration
+	}{
+		{nil, DefaultTimeout},
+		{&metav1.Duration{Duration: -time.Minute}, DefaultTimeout},
+		{&metav1.Duration{Duration: 30 * time.Second}, 30 * time.Second},
+		{&metav1.Duration{Duration: 3 * time.Hour}, MaxTimeout},
+	} {
+		vf := &carinav1.VolumeFreeze{Spec: carinav1.VolumeFreezeSpec{Timeout: c.timeout}}
+		a.Equal(c.expect, Timeout(vf))
+	}
+}
+
+func TestNext(t *testing.T) {
+	now := time.Date(2022, 3, 4, 12, 0, 0, 0, time.UTC)
+	deadline := metav1.NewTime(now.Add(time.Minute))
+	passed := metav1.NewTime(now.Add(-time.Minute))
+	longPassed := metav1.NewTime(now.Add(-ThawGracePeriod - time.Minute))
+	frozen := carinav1.FrozenVolume{PVC: "a", PV: "pv-a", Node: "n1", Frozen: true}
+	pending := carinav1.FrozenVolume{PVC: "b", PV: "pv-b", Node: "n2"}
+	failed := carinav1.FrozenVolume{PVC: "b", PV: "pv-b", Node: "n2", Message: "fsfreeze failed"}
+	thawed := carinav1.FrozenVolume{PVC: "a", PV: "pv-a", Node: "n1"}
+
+	for _, c := range []struct {
+		name     string
+		phase    string
+		thaw     bool
+		deadline *metav1.Time
+		volumes  []carinav1.FrozenVolume
+		phase2   string
+		message  string
+		thawNode bool
+	}{
+		{"freezing", carinav1.VolumeFreezeFreezing, false, &deadline, []carinav1.FrozenVolume{frozen, pending}, carinav1.VolumeFreezeFreezing, "", false},
+		{"all frozen", carinav1.VolumeFreezeFreezing, false, &deadline, []carinav1.FrozenVolume{frozen}, carinav1.VolumeFreezeFrozen, "", false},
+		{"freeze failed", carinav1.VolumeFreezeFreezing, false, &deadline, []carinav1.FrozenVolume{frozen, failed}, carinav1.VolumeFreezeThawing, "pvc b: fsfreeze failed", false},
+		{"thaw requested", carinav1.VolumeFreezeFrozen, true, &deadline, []carinav1.FrozenVolume{frozen}, carinav1.VolumeFreezeThawing, "thaw requested", true},
+		{"deadline", carinav1.VolumeFreezeFrozen, false, &passed, []carinav1.FrozenVolume{frozen}, carinav1.VolumeFreezeThawing, "thaw deadline reached", true},
+		{"thawing", carinav1.VolumeFreezeThawing, false, &passed, []carinav1.FrozenVolume{frozen}, carinav1.VolumeFreezeThawing, "", true},
+		{"thawed", carinav1.VolumeFreezeThawing, false, &passed, []carinav1.FrozenVolume{thawed}, carinav1.VolumeFreezeThawed, "", true},
+		{"thawed after failure", carinav1.VolumeFreezeThawing, false, &deadline, []carinav1.FrozenVolume{thawed, failed}, carinav1.VolumeFreezeFailed, "pvc b: fsfreeze failed", true},
+		{"node gone", carinav1.VolumeFreezeThawing, false, &longPassed, []carinav1.FrozenVolume{frozen}, carinav1.VolumeFreezeFailed, "pvc a was not reported thawed by node n1", true},
+	} {
+		vf := &carinav1.VolumeFreeze{
+			Spec:   carinav1.VolumeFreezeSpec{Thaw: c.thaw},
+			Status: carinav1.VolumeFreezeStatus{Phase: c.phase, ThawDeadline: c.deadline, Volumes: c.volumes},
+		}
+		phase, message := Next(vf, now)
+		a := assert.New(t)
+		a.Equal(c.phase2, phase, c.name)
+		a.Equal(c.message, message, c.name)
+		a.Equal(c.thawNode, ShouldThaw(vf, now), c.name)
+	}
+}
+
+func TestRequeueAfter(t *testing.T) {
+	now := time.Date(2022, 3, 4, 12, 0, 0, 0, time.UTC)
+	deadline := metav1.NewTime(now.Add(time.Minute))
+
+	a := assert.New(t)
+	vf := &carinav1.VolumeFreeze{Status: carinav1.VolumeFreezeStatus{Phase: carinav1.VolumeFreezeFrozen, ThawDeadline: &deadline}}
+	a.Equal(time.Minute, RequeueAfter(vf, now))
+	a.Equal(time.Second, RequeueAfter(vf, now.Add(2*time.Minute)))
+	vf.Status.Phase = carinav1.VolumeFreezeThawing
+	a.Equal(time.Minute+ThawGracePeriod, RequeueAfter(vf, now))
+	vf.Status.Phase = carinav1.VolumeFreezeThawed
+	a.Equal(time.Duration(0), RequeueAfter(vf, now))
+}
@@ -0,0 +1,187 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package csiproxy
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// CSI Proxy v1的命名管道
+const (
+	diskPipe       = `\\.\pipe\csi-proxy-disk-v1`
+	volumePipe     = `\\.\pipe\csi-proxy-volume-v1`
+	filesystemPipe = `\\.\pipe\csi-proxy-filesystem-v1`
+)
+
+// Proxy 通过CSI Proxy在Windows主机上分区、格式化和挂载磁盘
+type Proxy interface {
+	// Rescan makes the host detect new disks and changed disk sizes
+	Rescan(ctx context.Context) error
+	// PartitionDisk initializes the disk with GPT and creates one partition of its full size
+	PartitionDisk(ctx context.Context, diskNumber uint32) error
+	// ListVolumesOnDisk returns the ids of the volumes on the partitions of the disk
+	ListVolumesOnDisk(ctx context.Context, diskNumber uint32) ([]string, error)
+	IsVolumeFormatted(ctx context.Context, volumeID string) (bool, error)
+	// FormatVolume formats the volume with NTFS
+	FormatVolume(ctx context.Context, volumeID string) error
+	// MountVolume links targetPath, which must not exist, to the volume
+	MountVolume(ctx context.Context, volumeID, targetPath string) error
+	UnmountVolume(ctx context.Context, volumeID, targetPath string) error
+	// ResizeVolume grows the partition and the filesystem of the volume to fill the disk
+	ResizeVolume(ctx context.Context, volumeID string) error
+	// GetVolumeStats returns the total and used bytes of the volume
+	GetVolumeStats(ctx context.Context, volumeID string) (int64, int64, error)
+	// GetVolumeIDFromTargetPath returns the volume mounted at targetPath
+	GetVolumeIDFromTargetPath(ctx context.Context, targetPath string) (string, error)
+	PathExists(ctx context.Context, path string) (bool, error)
+	// Rmdir removes the directory or the link of a mounted volume
+	Rmdir(ctx context.Context, path string) error
+}
+
+// Client is the Proxy of the csi-proxy service of the host
+type Client struct {
+	disk       *grpc.ClientConn
+	volume     *grpc.ClientConn
+	filesystem *grpc.ClientConn
+}
+
+var _ Proxy = &Client{}
+
+// NewClient connects to the csi-proxy service of the host
+func NewClient() (*Client, error) {
+	c := &Client{}
+	var err error
+	if c.disk, err = dial(diskPipe); err != nil {
+		return nil, err
+	}
+	if c.volume, err = dial(volumePipe); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if c.filesystem, err = dial(filesystemPipe); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func dial(pipe string) (*grpc.ClientConn, error) {
+	return grpc.Dial("passthrough:///"+pipe,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return dialPipe(ctx, pipe)
+		}),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})),
+	)
+}
+
+// Close closes the connections to csi-proxy
+func (c *Client) Close() {
+	for _, conn := range []*grpc.ClientConn{c.disk, c.volume, c.filesystem} {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}
+}
+
+func invoke(ctx context.Context, conn *grpc.ClientConn, method string, req *message) (*message, error) {
+	resp := newMessage()
+	if err := conn.Invoke(ctx, method, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) Rescan(ctx context.Context) error {
+	_, err := invoke(ctx, c.disk, "/v1.Disk/Rescan", newMessage())
+	return err
+}
+
+func (c *Client) PartitionDisk(ctx context.Context, diskNumber uint32) error {
+	_, err := invoke(ctx, c.disk, "/v1.Disk/PartitionDisk", newMessage().Uint(1, uint64(diskNumber)))
+	return err
+}
+
+func (c *Client) ListVolumesOnDisk(ctx context.Context, diskNumber uint32) ([]string, error) {
+	resp, err := invoke(ctx, c.volume, "/v1.Volume/ListVolumesOnDisk", newMessage().Uint(1, uint64(diskNumber)))
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetStrings(1), nil
+}
+
+func (c *Client) IsVolumeFormatted(ctx context.Context, volumeID string) (bool, error) {
+	resp, err := invoke(ctx, c.volume, "/v1.Volume/IsVolumeFormatted", newMessage().String(1, volumeID))
+	if err != nil {
+		return false, err
+	}
+	return resp.GetBool(1), nil
+}
+
+func (c *Client) FormatVolume(ctx context.Context, volumeID string) error {
+	_, err := invoke(ctx, c.volume, "/v1.Volume/FormatVolume", newMessage().String(1, volumeID))
+	return err
+}
+
+func (c *Client) MountVolume(ctx context.Context, volumeID, targetPath string) error {
+	_, err := invoke(ctx, c.volume, "/v1.Volume/MountVolume", newMessage().String(1, volumeID).String(2, targetPath))
+	return err
+}
+
+func (c *Client) UnmountVolume(ctx context.Context, volumeID, targetPath string) error {
+	_, err := invoke(ctx, c.volume, "/v1.Volume/UnmountVolume", newMessage().String(1, volumeID).String(2, targetPath))
+	return err
+}
+
+func (c *Client) ResizeVolume(ctx context.Context, volumeID string) error {
+	// size_bytes为0时扩展到磁盘的最大可用空间
+	_, err := invoke(ctx, c.volume, "/v1.Volume/ResizeVolume", newMessage().String(1, volumeID))
+	return err
+}
+
+func (c *Client) GetVolumeStats(ctx context.Context, volumeID string) (int64, int64, error) {
+	resp, err := invoke(ctx, c.volume, "/v1.Volume/GetVolumeStats", newMessage().String(1, volumeID))
+	if err != nil {
+		return 0, 0, err
+	}
+	return int64(resp.GetUint(1)), int64(resp.GetUint(2)), nil
+}
+
+func (c *Client) GetVolumeIDFromTargetPath(ctx context.Context, targetPath string) (string, error) {
+	resp, err := invoke(ctx, c.volume, "/v1.Volume/GetVolumeIDFromTargetPath", newMessage().String(1, targetPath))
+	if err != nil {
+		return "", err
+	}
+	return resp.GetString(1), nil
+}
+
+func (c *Client) PathExists(ctx context.Context, path string) (bool, error) {
+	resp, err := invoke(ctx, c.filesystem, "/v1.Filesystem/PathExists", newMessage().String(1, path))
+	if err != nil {
+		return false, err
+	}
+	return resp.GetBool(1), nil
+}
+
+func (c *Client) Rmdir(ctx context.Context, path string) error {
+	_, err := invoke(ctx, c.filesystem, "/v1.Filesystem/Rmdir", newMessage().String(1, path))
+	return err
+}
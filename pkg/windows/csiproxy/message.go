@@ -0,0 +1,172 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package csiproxy
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// message 是CSI Proxy接口的protobuf消息
+// The requests and responses of the api used by carina only have scalar and repeated
+// string fields, they are encoded with protowire instead of generated code so that
+// carina does not depend on the csi-proxy client module.
+type message struct {
+	fields []field
+}
+
+type field struct {
+	num    protowire.Number
+	typ    protowire.Type
+	varint uint64
+	bytes  []byte
+}
+
+func newMessage() *message {
+	return &message{}
+}
+
+// String sets a string field, empty strings are omitted like proto3 does
+func (m *message) String(num protowire.Number, s string) *message {
+	if s != "" {
+		m.fields = append(m.fields, field{num: num, typ: protowire.BytesType, bytes: []byte(s)})
+	}
+	return m
+}
+
+// Uint sets a uint32, uint64 or int64 field
+func (m *message) Uint(num protowire.Number, v uint64) *message {
+	if v != 0 {
+		m.fields = append(m.fields, field{num: num, typ: protowire.VarintType, varint: v})
+	}
+	return m
+}
+
+// Bool sets a bool field
+func (m *message) Bool(num protowire.Number, b bool) *message {
+	if b {
+		m.fields = append(m.fields, field{num: num, typ: protowire.VarintType, varint: 1})
+	}
+	return m
+}
+
+// Marshal encodes the fields in the order they were set
+func (m *message) Marshal() []byte {
+	var b []byte
+	for _, f := range m.fields {
+		b = protowire.AppendTag(b, f.num, f.typ)
+		switch f.typ {
+		case protowire.VarintType:
+			b = protowire.AppendVarint(b, f.varint)
+		case protowire.BytesType:
+			b = protowire.AppendBytes(b, f.bytes)
+		}
+	}
+	return b
+}
+
+// Unmarshal decodes varint and length delimited fields, others are skipped
+func (m *message) Unmarshal(b []byte) error {
+	m.fields = nil
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		f := field{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			m.fields = append(m.fields, f)
+		}
+	}
+	return nil
+}
+
+// GetString returns the last value of a string field
+func (m *message) GetString(num protowire.Number) string {
+	s := ""
+	for _, f := range m.fields {
+		if f.num == num && f.typ == protowire.BytesType {
+			s = string(f.bytes)
+		}
+	}
+	return s
+}
+
+// GetStrings returns the values of a repeated string field
+func (m *message) GetStrings(num protowire.Number) []string {
+	var s []string
+	for _, f := range m.fields {
+		if f.num == num && f.typ == protowire.BytesType {
+			s = append(s, string(f.bytes))
+		}
+	}
+	return s
+}
+
+// GetUint returns the last value of a varint field
+func (m *message) GetUint(num protowire.Number) uint64 {
+	var v uint64
+	for _, f := range m.fields {
+		if f.num == num && f.typ == protowire.VarintType {
+			v = f.varint
+		}
+	}
+	return v
+}
+
+// GetBool returns the last value of a bool field
+func (m *message) GetBool(num protowire.Number) bool {
+	return m.GetUint(num) != 0
+}
+
+// codec 让grpc用message收发CSI Proxy的protobuf消息
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(*message)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return m.Marshal(), nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(*message)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	return m.Unmarshal(data)
+}
+
+// Name is the content subtype the csi proxy server expects
+func (codec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,63 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package csiproxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestMessage(t *testing.T) {
+	a := assert.New(t)
+
+	// MountVolumeRequest {string volume_id = 1; string target_path = 2;}
+	req := newMessage().String(1, `\\?\Volume{4c1b02c1-d990-11dc-99ae-806e6f6e6963}\`).String(2, `c:\var\lib\kubelet\pods\x\mount`)
+	decoded := newMessage()
+	a.NoError(decoded.Unmarshal(req.Marshal()))
+	a.Equal(`\\?\Volume{4c1b02c1-d990-11dc-99ae-806e6f6e6963}\`, decoded.GetString(1))
+	a.Equal(`c:\var\lib\kubelet\pods\x\mount`, decoded.GetString(2))
+
+	// proto3不编码零值
+	a.Empty(newMessage().Uint(1, 0).Bool(2, false).String(3, "").Marshal())
+
+	// ListVolumesOnDiskResponse {repeated string volume_ids = 1;}，未知的fixed32字段被跳过
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, "vol1")
+	b = protowire.AppendTag(b, 7, protowire.Fixed32Type)
+	b = protowire.AppendFixed32(b, 42)
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, "vol2")
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, 1<<40)
+	resp := newMessage()
+	a.NoError(resp.Unmarshal(b))
+	a.Equal([]string{"vol1", "vol2"}, resp.GetStrings(1))
+	a.Equal(uint64(1<<40), resp.GetUint(2))
+	a.True(newMessage().Bool(1, true).GetBool(1))
+
+	a.Error(newMessage().Unmarshal([]byte{0x0a, 0x05, 'a'}))
+
+	c := codec{}
+	data, err := c.Marshal(newMessage().Uint(1, 3))
+	a.NoError(err)
+	a.Equal([]byte{0x08, 0x03}, data)
+	_, err = c.Marshal("not a message")
+	a.Error(err)
+}
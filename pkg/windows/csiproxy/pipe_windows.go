@@ -0,0 +1,121 @@
+//go:build windows
+// +build windows
+
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package csiproxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// dialPipe 打开命名管道，读写使用重叠I/O，grpc的读写可以同时进行
+func dialPipe(ctx context.Context, path string) (net.Conn, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		h, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil,
+			windows.OPEN_EXISTING, windows.FILE_FLAG_OVERLAPPED, 0)
+		if err == nil {
+			return &pipeConn{h: h, path: path}, nil
+		}
+		if err != windows.ERROR_PIPE_BUSY {
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(path), Err: err}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+type pipeConn struct {
+	h         windows.Handle
+	path      string
+	closeOnce sync.Once
+}
+
+func (c *pipeConn) do(b []byte, write bool) (int, error) {
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(event)
+	o := windows.Overlapped{HEvent: event}
+	var n uint32
+	if write {
+		err = windows.WriteFile(c.h, b, &n, &o)
+	} else {
+		err = windows.ReadFile(c.h, b, &n, &o)
+	}
+	if err == windows.ERROR_IO_PENDING {
+		err = windows.GetOverlappedResult(c.h, &o, &n, true)
+	}
+	switch err {
+	case nil:
+		return int(n), nil
+	case windows.ERROR_BROKEN_PIPE, windows.ERROR_PIPE_NOT_CONNECTED, windows.ERROR_OPERATION_ABORTED:
+		return int(n), io.EOF
+	}
+	return int(n), err
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return c.do(b, false)
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n, err := c.do(b[written:], true)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close cancels pending reads and writes and closes the handle
+func (c *pipeConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		_ = windows.CancelIoEx(c.h, nil)
+		err = windows.CloseHandle(c.h)
+	})
+	return err
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return pipeAddr(c.path) }
+func (c *pipeConn) RemoteAddr() net.Addr { return pipeAddr(c.path) }
+
+// 管道不支持超时，grpc的调用由context控制
+func (c *pipeConn) SetDeadline(time.Time) error      { return nil }
+func (c *pipeConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *pipeConn) SetWriteDeadline(time.Time) error { return nil }
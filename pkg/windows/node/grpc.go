@@ -0,0 +1,58 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"net"
+	"os"
+
+	"google.golang.org/grpc"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+type gRPCServerRunner struct {
+	srv      *grpc.Server
+	sockFile string
+}
+
+var _ manager.LeaderElectionRunnable = gRPCServerRunner{}
+
+// NewGRPCRunner creates controller-runtime's manager.Runnable for the gRPC server of a windows node.
+// The server listens on the UNIX domain socket at sockFile, supported since Windows Server 2019.
+func NewGRPCRunner(srv *grpc.Server, sockFile string) manager.Runnable {
+	return gRPCServerRunner{srv, sockFile}
+}
+
+// Start implements controller-runtime's manager.Runnable.
+func (r gRPCServerRunner) Start(ctx context.Context) error {
+	_ = os.Remove(r.sockFile)
+	lis, err := net.Listen("unix", r.sockFile)
+	if err != nil {
+		return err
+	}
+
+	go r.srv.Serve(lis)
+	<-ctx.Done()
+	r.srv.GracefulStop()
+	return nil
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (r gRPCServerRunner) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,253 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/windows/storagespaces"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"google.golang.org/grpc/codes"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// LogicVolumeReconciler creates, expands and removes the virtual disks of the LogicVolumes on a windows node
+type LogicVolumeReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	nodeName string
+	spaces   storagespaces.StorageSpaces
+}
+
+func NewLogicVolumeReconciler(client client.Client, recorder record.EventRecorder, nodeName string, spaces storagespaces.StorageSpaces) *LogicVolumeReconciler {
+	return &LogicVolumeReconciler{
+		Client:   client,
+		Recorder: recorder,
+		nodeName: nodeName,
+		spaces:   spaces,
+	}
+}
+
+func (r *LogicVolumeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	lv := new(carinav1.LogicVolume)
+	if err := r.Client.Get(ctx, req.NamespacedName, lv); err != nil {
+		if !apierrs.IsNotFound(err) {
+			log.Error(err, "unable to fetch LogicVolume")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if lv.Spec.NodeName != r.nodeName {
+		return ctrl.Result{}, nil
+	}
+
+	if lv.ObjectMeta.DeletionTimestamp == nil {
+		if !utils.ContainsString(lv.Finalizers, utils.LogicVolumeFinalizer) {
+			lv2 := lv.DeepCopy()
+			lv2.Finalizers = append(lv2.Finalizers, utils.LogicVolumeFinalizer)
+			if err := r.Patch(ctx, lv2, client.MergeFrom(lv)); err != nil {
+				log.Error(err, " failed to add finalizer name ", lv.Name)
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{Requeue: true}, nil
+		}
+
+		if lv.Status.VolumeID == "" {
+			err := r.createLV(ctx, lv)
+			if err != nil {
+				log.Error(err, " failed to create LV name ", lv.Name)
+			}
+			return ctrl.Result{}, err
+		}
+		err := r.expandLV(ctx, lv)
+		if err != nil {
+			log.Error(err, " failed to expand LV name ", lv.Name)
+		}
+		return ctrl.Result{}, err
+	}
+
+	if !utils.ContainsString(lv.Finalizers, utils.LogicVolumeFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	log.Info("start finalizing LogicVolume name ", lv.Name)
+	// 删除是幂等的，虚拟磁盘不存在时直接返回
+	err := utils.UntilMaxRetry(func() error {
+		return r.spaces.RemoveVirtualDisk("volume-" + lv.Name)
+	}, 10, 12*time.Second)
+	if err != nil {
+		log.Error(err, " failed to remove virtual disk name ", lv.Name, " pool ", lv.Spec.DeviceGroup)
+		return ctrl.Result{}, err
+	}
+
+	lv2 := lv.DeepCopy()
+	lv2.Finalizers = utils.SliceRemoveString(lv2.Finalizers, utils.LogicVolumeFinalizer)
+	if err := r.Patch(ctx, lv2, client.MergeFrom(lv)); err != nil {
+		log.Error(err, " failed to remove finalizer name ", lv.Name)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *LogicVolumeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&carinav1.LogicVolume{}).
+		WithEventFilter(&logicVolumeFilter{r.nodeName}).
+		Complete(r)
+}
+
+// unsupported 返回Windows节点不支持的卷特性，这些卷直接标记为失败
+func unsupported(lv *carinav1.LogicVolume) string {
+	a := lv.Annotations
+	if t := a[utils.VolumeManagerType]; t != "" && t != utils.LvmVolumeType {
+		return fmt.Sprintf("volume type %s", t)
+	}
+	if a[utils.SnapshotSource] != "" {
+		return "snapshots"
+	}
+	if a[utils.VolumeDataSource] != "" || a[utils.VolumeImportSource] != "" || a[utils.VolumePrefillSource] != "" {
+		return "volume data sources"
+	}
+	if a[utils.VolumeEncrypted] == "true" {
+		return "encryption"
+	}
+	if stripes, _, err := utils.StripeParameters(a); err != nil || stripes > 1 {
+		return "striping"
+	}
+	if a[utils.VolumeDrbdMinor] != "" || a[utils.VolumeReplicaOf] != "" {
+		return "replicas"
+	}
+	if a[utils.VolumeCacheDiskType] != "" {
+		return "bcache"
+	}
+	if p := a[utils.VolumeWipePolicy]; p != "" && p != utils.WipePolicyNone {
+		return "wiping volumes on delete"
+	}
+	return ""
+}
+
+func (r *LogicVolumeReconciler) createLV(ctx context.Context, lv *carinav1.LogicVolume) error {
+	if lv.Status.Code != codes.OK {
+		return nil
+	}
+	reqBytes := lv.Spec.Size.Value()
+
+	if feature := unsupported(lv); feature != "" {
+		return r.failLV(ctx, lv, codes.Unimplemented, fmt.Errorf("%s is not supported on windows node %s", feature, r.nodeName))
+	}
+
+	err := utils.UntilMaxRetry(func() error {
+		_, err := r.spaces.CreateVirtualDisk(lv.Spec.DeviceGroup, "volume-"+lv.Name, uint64(reqBytes))
+		return err
+	}, 5, 12*time.Second)
+	if err != nil {
+		return r.failLV(ctx, lv, codes.Internal, err)
+	}
+
+	lv.Status.VolumeID = "volume-" + lv.Name
+	lv.Status.CurrentSize = resource.NewQuantity(reqBytes, resource.BinarySI)
+	lv.Status.Code = codes.OK
+	lv.Status.Message = ""
+	lv.Status.Status = "Success"
+	r.Recorder.Event(lv, corev1.EventTypeNormal, "CreateVolumeSuccess", fmt.Sprintf("create volume success node: %s, time: %s", r.nodeName, time.Now().Format("2006-01-02T15:04:05.000Z")))
+	if err := r.Status().Update(ctx, lv); err != nil {
+		log.Error(err, " failed to update status name ", lv.Name, " uid ", lv.UID)
+		return err
+	}
+	log.Info("created virtual disk name ", lv.Name, " pool ", lv.Spec.DeviceGroup)
+	return nil
+}
+
+// failLV 记录创建失败，LogicVolume随后由控制器删除
+func (r *LogicVolumeReconciler) failLV(ctx context.Context, lv *carinav1.LogicVolume, code codes.Code, err error) error {
+	lv.Status.Code = code
+	lv.Status.Message = err.Error()
+	lv.Status.Status = "Failed"
+	r.Recorder.Event(lv, corev1.EventTypeWarning, "CreateVolumeFailed", fmt.Sprintf("create volume failed node: %s, time: %s, error: %s", r.nodeName, time.Now().Format("2006-01-02T15:04:05.000Z"), err.Error()))
+	if err2 := r.Status().Update(ctx, lv); err2 != nil {
+		// err2 is logged but not returned because err is more important
+		log.Error(err2, " failed to update status name ", lv.Name, " uid ", lv.UID)
+	}
+	return err
+}
+
+func (r *LogicVolumeReconciler) expandLV(ctx context.Context, lv *carinav1.LogicVolume) error {
+	if lv.Status.CurrentSize == nil || lv.Spec.Size.Cmp(*lv.Status.CurrentSize) <= 0 {
+		return nil
+	}
+	reqBytes := lv.Spec.Size.Value()
+
+	err := utils.UntilMaxRetry(func() error {
+		return r.spaces.ResizeVirtualDisk("volume-"+lv.Name, uint64(reqBytes))
+	}, 10, 12*time.Second)
+	if err != nil {
+		lv.Status.Code = codes.Internal
+		lv.Status.Message = err.Error()
+		lv.Status.Status = "Failed"
+		r.Recorder.Event(lv, corev1.EventTypeWarning, "ExpandVolumeFailed", fmt.Sprintf("expand volume failed node: %s, time: %s, error: %s", r.nodeName, time.Now().Format("2006-01-02T15:04:05.000Z"), err.Error()))
+		if err2 := r.Status().Update(ctx, lv); err2 != nil {
+			log.Error(err2, " failed to update status name ", lv.Name, " uid ", lv.UID)
+		}
+		return err
+	}
+
+	lv.Status.CurrentSize = resource.NewQuantity(reqBytes, resource.BinarySI)
+	lv.Status.Code = codes.OK
+	lv.Status.Message = ""
+	lv.Status.Status = "Success"
+	r.Recorder.Event(lv, corev1.EventTypeNormal, "ExpandVolumeSuccess", fmt.Sprintf("expand volume success node: %s, time: %s", r.nodeName, time.Now().Format("2006-01-02T15:04:05.000Z")))
+	if err := r.Status().Update(ctx, lv); err != nil {
+		log.Error(err, " failed to update status name ", lv.Name, " uid ", lv.UID)
+		return err
+	}
+	return nil
+}
+
+type logicVolumeFilter struct {
+	nodeName string
+}
+
+func (f logicVolumeFilter) filter(lv *carinav1.LogicVolume) bool {
+	return lv != nil && lv.Spec.NodeName == f.nodeName
+}
+
+func (f logicVolumeFilter) Create(e event.CreateEvent) bool {
+	return f.filter(e.Object.(*carinav1.LogicVolume))
+}
+
+func (f logicVolumeFilter) Delete(e event.DeleteEvent) bool {
+	return f.filter(e.Object.(*carinav1.LogicVolume))
+}
+
+func (f logicVolumeFilter) Update(e event.UpdateEvent) bool {
+	return f.filter(e.ObjectNew.(*carinav1.LogicVolume))
+}
+
+func (f logicVolumeFilter) Generic(e event.GenericEvent) bool {
+	return f.filter(e.Object.(*carinav1.LogicVolume))
+}
@@ -0,0 +1,296 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	carinav1 "github.com/carina-io/carina/api/v1"
+	"github.com/carina-io/carina/pkg/windows/csiproxy"
+	"github.com/carina-io/carina/pkg/windows/storagespaces"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fsType Windows节点的卷只能格式化为NTFS
+const fsType = "ntfs"
+
+// NewNodeService returns the CSI node service of a windows node, volumes are virtual disks of
+// storage spaces mounted by csi-proxy.
+func NewNodeService(nodeName string, c client.Client, spaces storagespaces.StorageSpaces, proxy csiproxy.Proxy) csi.NodeServer {
+	return &nodeService{nodeName: nodeName, client: c, spaces: spaces, proxy: proxy}
+}
+
+type nodeService struct {
+	csi.UnimplementedNodeServer
+
+	nodeName string
+	client   client.Client
+	spaces   storagespaces.StorageSpaces
+	proxy    csiproxy.Proxy
+	mu       sync.Mutex
+}
+
+func (s *nodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
+	volumeID := req.GetVolumeId()
+	target := req.GetTargetPath()
+	logger.Info("NodePublishVolume called volume_id ", volumeID, " target_path ", target, " volume_capability ", req.GetVolumeCapability(), " read_only ", req.GetReadonly())
+
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "no volume_id is provided")
+	}
+	if target == "" {
+		return nil, status.Error(codes.InvalidArgument, "no target_path is provided")
+	}
+	capability := req.GetVolumeCapability()
+	if capability == nil {
+		return nil, status.Error(codes.InvalidArgument, "no volume_capability is provided")
+	}
+	if capability.GetBlock() != nil {
+		return nil, status.Error(codes.InvalidArgument, "block volumes are not supported on windows nodes")
+	}
+	if f := capability.GetMount().GetFsType(); f != "" && !strings.EqualFold(f, fsType) {
+		return nil, status.Errorf(codes.InvalidArgument, "filesystem %s is not supported on windows nodes, use ntfs", f)
+	}
+	// csi-proxy只能以读写方式挂载
+	if req.GetReadonly() {
+		return nil, status.Error(codes.InvalidArgument, "read only volumes are not supported on windows nodes")
+	}
+
+	if _, err := s.logicVolume(ctx, volumeID); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	volume, err := s.diskVolume(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := s.proxy.PathExists(ctx, target)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "check target path %s: %v", target, err)
+	}
+	if exists {
+		if mounted, err := s.proxy.GetVolumeIDFromTargetPath(ctx, target); err == nil && mounted == volume {
+			return &csi.NodePublishVolumeResponse{}, nil
+		}
+		// kubelet创建的空目录，csi-proxy挂载时要求目标路径不存在
+		if err := s.proxy.Rmdir(ctx, target); err != nil {
+			return nil, status.Errorf(codes.Internal, "remove target path %s: %v", target, err)
+		}
+	}
+
+	formatted, err := s.proxy.IsVolumeFormatted(ctx, volume)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "check format of volume %s: %v", volumeID, err)
+	}
+	if !formatted {
+		logger.Infof("format volume %s of %s with ntfs", volume, volumeID)
+		if err := s.proxy.FormatVolume(ctx, volume); err != nil {
+			return nil, status.Errorf(codes.Internal, "format volume %s: %v", volumeID, err)
+		}
+	}
+	if err := s.proxy.MountVolume(ctx, volume, target); err != nil {
+		return nil, status.Errorf(codes.Internal, "mount volume %s at %s: %v", volumeID, target, err)
+	}
+	logger.Infof("NodePublishVolume(windows) succeeded volume_id %s target_path %s", volumeID, target)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (s *nodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	logger := log.FromContext(ctx)
+	volumeID := req.GetVolumeId()
+	target := req.GetTargetPath()
+	logger.Info("NodeUnpublishVolume called volume_id ", volumeID, " target_path ", target)
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "no volume_id is provided")
+	}
+	if target == "" {
+		return nil, status.Error(codes.InvalidArgument, "no target_path is provided")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exists, err := s.proxy.PathExists(ctx, target)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "check target path %s: %v", target, err)
+	}
+	if !exists {
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+	if volume, err := s.proxy.GetVolumeIDFromTargetPath(ctx, target); err == nil && volume != "" {
+		if err := s.proxy.UnmountVolume(ctx, volume, target); err != nil {
+			return nil, status.Errorf(codes.Internal, "unmount volume %s from %s: %v", volumeID, target, err)
+		}
+	}
+	if exists, err := s.proxy.PathExists(ctx, target); err == nil && exists {
+		if err := s.proxy.Rmdir(ctx, target); err != nil {
+			return nil, status.Errorf(codes.Internal, "remove target path %s: %v", target, err)
+		}
+	}
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+func (s *nodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	volumeID := req.GetVolumeId()
+	volumePath := req.GetVolumePath()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "no volume_id is provided")
+	}
+	if volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "no volume_path is provided")
+	}
+	exists, err := s.proxy.PathExists(ctx, volumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "check volume path %s: %v", volumePath, err)
+	}
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "volume path %s does not exist", volumePath)
+	}
+	volume, err := s.proxy.GetVolumeIDFromTargetPath(ctx, volumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "no volume is mounted at %s: %v", volumePath, err)
+	}
+	total, used, err := s.proxy.GetVolumeStats(ctx, volume)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "get stats of volume %s: %v", volumeID, err)
+	}
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{{
+			Unit:      csi.VolumeUsage_BYTES,
+			Total:     total,
+			Used:      used,
+			Available: total - used,
+		}},
+	}, nil
+}
+
+func (s *nodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	logger := log.FromContext(ctx)
+	volumeID := req.GetVolumeId()
+	logger.Info("NodeExpandVolume called volume_id ", volumeID, " volume_path ", req.GetVolumePath(), " required ", req.GetCapacityRange().GetRequiredBytes())
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "no volume_id is provided")
+	}
+	lv, err := s.logicVolume(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 虚拟磁盘已由LogicVolume控制器扩容，重新扫描后扩展分区和文件系统
+	if err := s.proxy.Rescan(ctx); err != nil {
+		return nil, status.Errorf(codes.Internal, "rescan disks: %v", err)
+	}
+	volume, err := s.diskVolume(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.proxy.ResizeVolume(ctx, volume); err != nil {
+		return nil, status.Errorf(codes.Internal, "resize volume %s: %v", volumeID, err)
+	}
+	capacity := req.GetCapacityRange().GetRequiredBytes()
+	if capacity == 0 {
+		capacity = lv.Spec.Size.Value()
+	}
+	logger.Infof("NodeExpandVolume(windows) succeeded volume_id %s", volumeID)
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: capacity}, nil
+}
+
+func (s *nodeService) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	capabilities := []*csi.NodeServiceCapability{}
+	for _, c := range []csi.NodeServiceCapability_RPC_Type{
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+	} {
+		capabilities = append(capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{Rpc: &csi.NodeServiceCapability_RPC{Type: c}},
+		})
+	}
+	return &csi.NodeGetCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+func (s *nodeService) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{
+		NodeId:            s.nodeName,
+		MaxVolumesPerNode: 1000,
+		AccessibleTopology: &csi.Topology{
+			Segments: map[string]string{
+				utils.TopologyNodeKey: s.nodeName,
+			},
+		},
+	}, nil
+}
+
+// logicVolume 返回本节点上的卷对应的LogicVolume，卷id为volume-加LogicVolume名
+func (s *nodeService) logicVolume(ctx context.Context, volumeID string) (*carinav1.LogicVolume, error) {
+	name := strings.TrimPrefix(volumeID, "volume-")
+	if name == volumeID {
+		return nil, status.Errorf(codes.NotFound, "volume %s is not a carina volume of a windows node", volumeID)
+	}
+	lv := &carinav1.LogicVolume{}
+	if err := s.client.Get(ctx, client.ObjectKey{Name: name, Namespace: utils.LogicVolumeNamespace}, lv); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "LogicVolume for volume id %s is not found", volumeID)
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if lv.Status.VolumeID != volumeID {
+		return nil, status.Errorf(codes.NotFound, "LogicVolume for volume id %s is not found", volumeID)
+	}
+	if lv.Spec.NodeName != s.nodeName {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is on node %s, not on %s", volumeID, lv.Spec.NodeName, s.nodeName)
+	}
+	return lv, nil
+}
+
+// diskVolume 返回卷所在虚拟磁盘上的Windows卷，新的虚拟磁盘先分区
+func (s *nodeService) diskVolume(ctx context.Context, volumeID string) (string, error) {
+	disk, err := s.spaces.GetVirtualDisk(volumeID)
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	if disk == nil {
+		return "", status.Errorf(codes.NotFound, "virtual disk %s is not found", volumeID)
+	}
+	volumes, err := s.proxy.ListVolumesOnDisk(ctx, disk.DiskNumber)
+	if err != nil || len(volumes) == 0 {
+		if err := s.proxy.PartitionDisk(ctx, disk.DiskNumber); err != nil {
+			return "", status.Errorf(codes.Internal, "partition disk %d of %s: %v", disk.DiskNumber, volumeID, err)
+		}
+		if volumes, err = s.proxy.ListVolumesOnDisk(ctx, disk.DiskNumber); err != nil {
+			return "", status.Errorf(codes.Internal, "list volumes on disk %d of %s: %v", disk.DiskNumber, volumeID, err)
+		}
+	}
+	if len(volumes) == 0 {
+		return "", status.Errorf(codes.Internal, "no volume on disk %d of %s", disk.DiskNumber, volumeID)
+	}
+	return volumes[0], nil
+}
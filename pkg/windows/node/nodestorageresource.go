@@ -0,0 +1,127 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/carina-io/carina/api"
+	carinav1beta1 "github.com/carina-io/carina/api/v1beta1"
+	"github.com/carina-io/carina/pkg/windows/storagespaces"
+	"github.com/carina-io/carina/utils"
+	"github.com/carina-io/carina/utils/log"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// resourceSyncInterval 存储池容量的上报周期
+const resourceSyncInterval = 60 * time.Second
+
+type resourceReporter struct {
+	client   client.Client
+	nodeName string
+	spaces   storagespaces.StorageSpaces
+}
+
+var _ manager.LeaderElectionRunnable = &resourceReporter{}
+
+// NewResourceReporter creates controller-runtime's manager.Runnable reporting the storage pools of a windows
+// node in its NodeStorageResource, each pool is reported like a volume group of a linux node.
+func NewResourceReporter(c client.Client, nodeName string, spaces storagespaces.StorageSpaces) manager.Runnable {
+	return &resourceReporter{client: c, nodeName: nodeName, spaces: spaces}
+}
+
+// Start implements controller-runtime's manager.Runnable.
+func (r *resourceReporter) Start(ctx context.Context) error {
+	ticker := time.NewTicker(resourceSyncInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.sync(ctx); err != nil {
+			log.Warnf("sync NodeStorageResource %s failed %s", r.nodeName, err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection implements controller-runtime's manager.LeaderElectionRunnable.
+func (r *resourceReporter) NeedLeaderElection() bool {
+	return false
+}
+
+func (r *resourceReporter) sync(ctx context.Context) error {
+	pools, err := r.spaces.ListPools()
+	if err != nil {
+		return err
+	}
+
+	nsr := &carinav1beta1.NodeStorageResource{}
+	if err := r.client.Get(ctx, client.ObjectKey{Name: r.nodeName}, nsr); err != nil {
+		if !apierrs.IsNotFound(err) {
+			return err
+		}
+		nsr = &carinav1beta1.NodeStorageResource{
+			ObjectMeta: metav1.ObjectMeta{Name: r.nodeName},
+			Spec:       carinav1beta1.NodeStorageResourceSpec{NodeName: r.nodeName},
+		}
+		if err := r.client.Create(ctx, nsr); err != nil {
+			return err
+		}
+	}
+
+	status := poolStatus(pools)
+	if equality.Semantic.DeepEqual(status.VgGroups, nsr.Status.VgGroups) &&
+		equality.Semantic.DeepEqual(status.Capacity, nsr.Status.Capacity) &&
+		equality.Semantic.DeepEqual(status.Allocatable, nsr.Status.Allocatable) {
+		return nil
+	}
+	nsr2 := nsr.DeepCopy()
+	nsr2.Status.VgGroups = status.VgGroups
+	nsr2.Status.Capacity = status.Capacity
+	nsr2.Status.Allocatable = status.Allocatable
+	nsr2.Status.SyncTime = metav1.Now()
+	return r.client.Status().Update(ctx, nsr2)
+}
+
+// poolStatus 存储池按卷组上报，容量单位为GiB
+func poolStatus(pools []storagespaces.Pool) carinav1beta1.NodeStorageResourceStatus {
+	status := carinav1beta1.NodeStorageResourceStatus{
+		Capacity:    map[string]resource.Quantity{},
+		Allocatable: map[string]resource.Quantity{},
+	}
+	for _, p := range pools {
+		key := fmt.Sprintf("%s%s", utils.DeviceCapacityKeyPrefix, p.Name)
+		status.Capacity[key] = *resource.NewQuantity(int64((p.Used+p.Free)>>30), resource.BinarySI)
+		status.Allocatable[key] = *resource.NewQuantity(int64(p.Free>>30), resource.BinarySI)
+		status.VgGroups = append(status.VgGroups, api.VgGroup{
+			VGName:  p.Name,
+			VGSize:  p.Used + p.Free,
+			VGFree:  p.Free,
+			LVCount: uint64(p.VirtualDisks),
+		})
+	}
+	return status
+}
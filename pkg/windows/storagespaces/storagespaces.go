@@ -0,0 +1,195 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package storagespaces
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// PoolPrefix 只管理名称以此开头的存储池，存储池名即磁盘组名
+const PoolPrefix = "carina-"
+
+// Pool is a storage pool used as device group
+type Pool struct {
+	Name string `json:"Name"`
+	// Used is the size of the virtual disks in the pool
+	Used uint64 `json:"Used"`
+	// Free is the size of the largest virtual disk that can be created with the default resiliency of the pool
+	Free         uint64 `json:"Free"`
+	VirtualDisks int    `json:"VirtualDisks"`
+}
+
+// VirtualDisk is the backing disk of a volume
+type VirtualDisk struct {
+	Name       string `json:"Name"`
+	Pool       string `json:"Pool"`
+	Size       uint64 `json:"Size"`
+	DiskNumber uint32 `json:"DiskNumber"`
+}
+
+// StorageSpaces 管理Windows节点存储池中的虚拟磁盘
+type StorageSpaces interface {
+	// ListPools returns the storage pools whose name starts with PoolPrefix
+	ListPools() ([]Pool, error)
+	// GetVirtualDisk returns nil if the virtual disk does not exist
+	GetVirtualDisk(name string) (*VirtualDisk, error)
+	// CreateVirtualDisk creates a fixed provisioned virtual disk with the default resiliency of the pool and brings it online
+	CreateVirtualDisk(pool, name string, size uint64) (*VirtualDisk, error)
+	ResizeVirtualDisk(name string, size uint64) error
+	// RemoveVirtualDisk removes the virtual disk, a missing disk is ignored
+	RemoveVirtualDisk(name string) error
+}
+
+// Shell runs a PowerShell script and returns its standard output
+type Shell interface {
+	Run(script string) (string, error)
+}
+
+// PowerShell runs scripts with powershell.exe of the host
+type PowerShell struct{}
+
+func (PowerShell) Run(script string) (string, error) {
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", "$ErrorActionPreference = 'Stop'; "+script)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// validName 存储池和虚拟磁盘名拼接到脚本中，只允许kubernetes对象名中的字符
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+func checkName(s string) error {
+	if !validName.MatchString(s) {
+		return fmt.Errorf("invalid storage spaces name %q", s)
+	}
+	return nil
+}
+
+// StorageSpacesImplement manages virtual disks with the Storage cmdlets of PowerShell
+type StorageSpacesImplement struct {
+	Shell Shell
+}
+
+var _ StorageSpaces = &StorageSpacesImplement{}
+
+const listPoolsScript = `$pools = @(Get-StoragePool -IsPrimordial $false -ErrorAction SilentlyContinue | Where-Object { $_.FriendlyName -like '%s*' } | ForEach-Object {
+  $disks = @($_ | Get-VirtualDisk)
+  $max = (Get-VirtualDiskSupportedSize -StoragePoolFriendlyName $_.FriendlyName -ResiliencySettingName $_.ResiliencySettingNameDefault).VirtualDiskSizeMax
+  [pscustomobject]@{ Name = $_.FriendlyName; Used = [uint64]($disks | Measure-Object -Property Size -Sum).Sum; Free = [uint64]$max; VirtualDisks = $disks.Count }
+})
+ConvertTo-Json -Compress -InputObject $pools`
+
+func (s *StorageSpacesImplement) ListPools() ([]Pool, error) {
+	out, err := s.Shell.Run(fmt.Sprintf(listPoolsScript, PoolPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("list storage pools: %v", err)
+	}
+	return ParsePools(out)
+}
+
+const getVirtualDiskScript = `$d = Get-VirtualDisk -FriendlyName '%s' -ErrorAction SilentlyContinue
+if ($d) { [pscustomobject]@{ Name = $d.FriendlyName; Pool = ($d | Get-StoragePool).FriendlyName; Size = [uint64]$d.Size; DiskNumber = [uint32]($d | Get-Disk).Number } | ConvertTo-Json -Compress }`
+
+func (s *StorageSpacesImplement) GetVirtualDisk(name string) (*VirtualDisk, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	out, err := s.Shell.Run(fmt.Sprintf(getVirtualDiskScript, name))
+	if err != nil {
+		return nil, fmt.Errorf("get virtual disk %s: %v", name, err)
+	}
+	return ParseVirtualDisk(out)
+}
+
+const createVirtualDiskScript = `New-VirtualDisk -StoragePoolFriendlyName '%s' -FriendlyName '%s' -Size %d -ProvisioningType Fixed | Out-Null
+$disk = Get-VirtualDisk -FriendlyName '%s' | Get-Disk
+if ($disk.IsOffline) { $disk | Set-Disk -IsOffline $false }
+if ($disk.IsReadOnly) { $disk | Set-Disk -IsReadOnly $false }`
+
+func (s *StorageSpacesImplement) CreateVirtualDisk(pool, name string, size uint64) (*VirtualDisk, error) {
+	if err := checkName(pool); err != nil {
+		return nil, err
+	}
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	if _, err := s.Shell.Run(fmt.Sprintf(createVirtualDiskScript, pool, name, size, name)); err != nil {
+		return nil, fmt.Errorf("create virtual disk %s in pool %s: %v", name, pool, err)
+	}
+	disk, err := s.GetVirtualDisk(name)
+	if err == nil && disk == nil {
+		err = fmt.Errorf("virtual disk %s not found after creation", name)
+	}
+	return disk, err
+}
+
+func (s *StorageSpacesImplement) ResizeVirtualDisk(name string, size uint64) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if _, err := s.Shell.Run(fmt.Sprintf("Resize-VirtualDisk -FriendlyName '%s' -Size %d", name, size)); err != nil {
+		return fmt.Errorf("resize virtual disk %s: %v", name, err)
+	}
+	return nil
+}
+
+const removeVirtualDiskScript = `$d = Get-VirtualDisk -FriendlyName '%s' -ErrorAction SilentlyContinue
+if ($d) { $d | Get-Disk | Set-Disk -IsOffline $true -ErrorAction SilentlyContinue; $d | Remove-VirtualDisk -Confirm:$false }`
+
+func (s *StorageSpacesImplement) RemoveVirtualDisk(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if _, err := s.Shell.Run(fmt.Sprintf(removeVirtualDiskScript, name)); err != nil {
+		return fmt.Errorf("remove virtual disk %s: %v", name, err)
+	}
+	return nil
+}
+
+// ParsePools parses the json array written by the script of ListPools
+func ParsePools(out string) ([]Pool, error) {
+	out = strings.TrimSpace(out)
+	pools := []Pool{}
+	if out == "" || out == "null" {
+		return pools, nil
+	}
+	if err := json.Unmarshal([]byte(out), &pools); err != nil {
+		return nil, fmt.Errorf("parse storage pools %q: %v", out, err)
+	}
+	return pools, nil
+}
+
+// ParseVirtualDisk parses the json object written by the script of GetVirtualDisk, nil if it wrote nothing
+func ParseVirtualDisk(out string) (*VirtualDisk, error) {
+	out = strings.TrimSpace(out)
+	if out == "" {
+		return nil, nil
+	}
+	disk := &VirtualDisk{}
+	if err := json.Unmarshal([]byte(out), disk); err != nil {
+		return nil, fmt.Errorf("parse virtual disk %q: %v", out, err)
+	}
+	return disk, nil
+}
@@ -0,0 +1,87 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package storagespaces
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeShell 记录执行的脚本，按顺序返回预设的输出
+type fakeShell struct {
+	scripts []string
+	outputs []string
+	err     error
+}
+
+func (f *fakeShell) Run(script string) (string, error) {
+	f.scripts = append(f.scripts, script)
+	if f.err != nil {
+		return "", f.err
+	}
+	out := ""
+	if len(f.outputs) > 0 {
+		out, f.outputs = f.outputs[0], f.outputs[1:]
+	}
+	return out, nil
+}
+
+func TestListPools(t *testing.T) {
+	a := assert.New(t)
+	shell := &fakeShell{outputs: []string{`[{"Name":"carina-vg-ssd","Used":10737418240,"Free":53687091200,"VirtualDisks":1}]` + "\r\n"}}
+	s := &StorageSpacesImplement{Shell: shell}
+	pools, err := s.ListPools()
+	a.NoError(err)
+	a.Equal([]Pool{{Name: "carina-vg-ssd", Used: 10 << 30, Free: 50 << 30, VirtualDisks: 1}}, pools)
+	a.Contains(shell.scripts[0], "-like 'carina-*'")
+
+	pools, err = ParsePools("[]")
+	a.NoError(err)
+	a.Empty(pools)
+	pools, err = ParsePools("")
+	a.NoError(err)
+	a.Empty(pools)
+	_, err = ParsePools("Get-StoragePool : Access denied")
+	a.Error(err)
+}
+
+func TestVirtualDisk(t *testing.T) {
+	a := assert.New(t)
+	shell := &fakeShell{outputs: []string{"", `{"Name":"volume-pvc-1","Pool":"carina-vg-ssd","Size":5368709120,"DiskNumber":3}`}}
+	s := &StorageSpacesImplement{Shell: shell}
+	disk, err := s.CreateVirtualDisk("carina-vg-ssd", "volume-pvc-1", 5<<30)
+	a.NoError(err)
+	a.Equal(&VirtualDisk{Name: "volume-pvc-1", Pool: "carina-vg-ssd", Size: 5 << 30, DiskNumber: 3}, disk)
+	a.True(strings.HasPrefix(shell.scripts[0], "New-VirtualDisk -StoragePoolFriendlyName 'carina-vg-ssd' -FriendlyName 'volume-pvc-1' -Size 5368709120 -ProvisioningType Fixed"))
+
+	// 不存在的虚拟磁盘没有输出
+	disk, err = s.GetVirtualDisk("volume-pvc-2")
+	a.NoError(err)
+	a.Nil(disk)
+
+	// 名称拼接进脚本，不能包含引号等字符
+	_, err = s.GetVirtualDisk("x'; Remove-Item C:\\ -Recurse; '")
+	a.Error(err)
+	a.Error(s.RemoveVirtualDisk(""))
+
+	s.Shell = &fakeShell{err: errors.New("exit status 1: Not enough available capacity")}
+	err = s.ResizeVirtualDisk("volume-pvc-1", 10<<30)
+	a.EqualError(err, "resize virtual disk volume-pvc-1: exit status 1: Not enough available capacity")
+}
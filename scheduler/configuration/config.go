@@ -17,15 +17,21 @@
 package configuration
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/carina-io/carina/scheduler/utils"
 	"github.com/fsnotify/fsnotify"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
+	"k8s.io/klog/v2"
 )
 
 // 配置文件路径
@@ -36,23 +42,28 @@ const (
 )
 
 var TestAssistDiskSelector []string
-var configModifyNotice []chan<- struct{}
-var err error
 var GlobalConfig *viper.Viper
 var DiskConfig Disk
-var opt = viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
-	mapstructure.StringToTimeDurationHookFunc(),
-	mapstructure.StringToSliceHookFunc(","),
-	// Custom Decode Hook Function
-	func(rf reflect.Kind, rt reflect.Kind, data interface{}) (interface{}, error) {
-		if rf != reflect.Map || rt != reflect.Struct {
+
+// revision 当前生效的配置文件摘要，与carina-node报告的版本相同即配置相同
+var revision string
+
+// decodeOption 解析diskSelector到disk
+func decodeOption(disk *Disk) viper.DecoderConfigOption {
+	return viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		// Custom Decode Hook Function
+		func(rf reflect.Kind, rt reflect.Kind, data interface{}) (interface{}, error) {
+			if rf != reflect.Map || rt != reflect.Struct {
+				return data, nil
+			}
+			mapstructure.Decode(data.(map[string]interface{}), disk)
+			mapstructure.Decode(data.(map[string]interface{})["diskselector"], &disk.DiskSelectors)
 			return data, nil
-		}
-		mapstructure.Decode(data.(map[string]interface{}), &DiskConfig)
-		mapstructure.Decode(data.(map[string]interface{})["diskselector"], &DiskConfig.DiskSelectors)
-		return data, err
-	},
-))
+		},
+	))
+}
 
 type DiskSelectorItem struct {
 	Name      string   `json:"name"`
@@ -67,6 +78,27 @@ type Disk struct {
 	SchedulerStrategy string             `json:"schedulerStrategy"`
 }
 
+// PolicyWebhook an external placement policy the scheduler consults for pods with carina pvcs
+type PolicyWebhook struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// CAFile pem bundle to verify a https webhook, the system roots if empty
+	CAFile  string        `json:"caFile"`
+	Timeout time.Duration `json:"timeout"`
+	// FailurePolicy Ignore or Fail, how an unreachable webhook or an invalid answer is treated
+	FailurePolicy string `json:"failurePolicy"`
+	// Filter and Score whether the webhook is consulted when filtering or scoring nodes
+	Filter bool `json:"filter"`
+	Score  bool `json:"score"`
+}
+
+const (
+	PolicyFailurePolicyIgnore = "Ignore"
+	PolicyFailurePolicyFail   = "Fail"
+	defaultPolicyTimeout      = time.Second
+	maxPolicyTimeout          = 10 * time.Second
+)
+
 type DiskClass struct {
 	DiskClassByName map[string]DiskSelectorItem `json:"diskClassByName"`
 }
@@ -77,27 +109,96 @@ func init() {
 
 }
 
+func configFile() string {
+	return filepath.Join(configPath, "config.json")
+}
+
 func initConfig() *viper.Viper {
-	GlobalConfig := viper.New()
-	GlobalConfig.AddConfigPath(configPath)
-	GlobalConfig.SetConfigName("config")
-	GlobalConfig.SetConfigType("json")
-	err := GlobalConfig.ReadInConfig()
+	data, err := os.ReadFile(configFile())
 	if err != nil {
 		os.Exit(-1)
 	}
-	err = GlobalConfig.Unmarshal(&DiskConfig, opt)
+	v, disk, err := decodeConfig(data)
 	if err != nil {
 		os.Exit(-1)
 	}
-	return GlobalConfig
+	DiskConfig = disk
+	revision = configRevision(data)
+	return v
+}
+
+func configRevision(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// decodeConfig 从配置文件内容解析配置，不修改当前生效的配置
+func decodeConfig(data []byte) (*viper.Viper, Disk, error) {
+	v := viper.New()
+	v.SetConfigType("json")
+	disk := Disk{}
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, disk, err
+	}
+	if err := v.Unmarshal(&disk, decodeOption(&disk)); err != nil {
+		return nil, disk, err
+	}
+	return v, disk, nil
+}
+
+// validateConfig 调度器只校验它使用的配置
+func validateConfig(disk Disk) error {
+	if s := strings.ToLower(disk.SchedulerStrategy); s != "" && s != SchedulerBinpack && s != Schedulerspreadout {
+		return fmt.Errorf("schedulerStrategy should be binpack or spreadout: %s", disk.SchedulerStrategy)
+	}
+	for _, d := range disk.DiskSelectors {
+		if d.Name == "" {
+			return fmt.Errorf("disk name should not be empty")
+		}
+		if !utils.ContainsString([]string{"", "lvm", "raw"}, strings.ToLower(d.Policy)) {
+			return fmt.Errorf("disk group %s policy should be LVM or RAW: %s", d.Name, d.Policy)
+		}
+	}
+	return nil
 }
 
 func dynamicConfig() {
-	GlobalConfig.WatchConfig()
-	GlobalConfig.OnConfigChange(func(event fsnotify.Event) {
-		_ = GlobalConfig.Unmarshal(&DiskConfig, opt)
+	// 单独的viper监听文件，校验失败的配置不会替换当前生效的配置
+	watcher := viper.New()
+	watcher.SetConfigFile(configFile())
+	watcher.SetConfigType("json")
+	if err := watcher.ReadInConfig(); err != nil {
+		klog.Errorf("Failed to watch the configuration: %s", err)
+		return
+	}
+	watcher.OnConfigChange(func(event fsnotify.Event) {
+		data, err := os.ReadFile(configFile())
+		if err != nil {
+			klog.Errorf("Failed to read the configuration: %s", err)
+			return
+		}
+		reload(data)
 	})
+	watcher.WatchConfig()
+}
+
+func reload(data []byte) {
+	rev := configRevision(data)
+	if rev == revision {
+		return
+	}
+	v, disk, err := decodeConfig(data)
+	if err == nil {
+		err = validateConfig(disk)
+	}
+	if err != nil {
+		klog.Errorf("Reject the configuration revision %s, keep revision %s: %s", rev, revision, err)
+		return
+	}
+	GlobalConfig = v
+	DiskConfig = disk
+	revision = rev
+	klog.Infof("Applied the configuration revision %s", rev)
 }
 
 // SchedulerStrategy pv调度策略binpac/spreadout，默认为binpac
@@ -148,3 +249,35 @@ func CheckRawDeviceGroup(diskType string) bool {
 	}
 	return false
 }
+
+// PolicyWebhooks 读取配置的调度策略webhook，跳过没有url的项
+// The timeout defaults to 1s and is capped at 10s as the webhooks are called for every node,
+// an unknown failurePolicy is treated as Ignore.
+func PolicyWebhooks() []PolicyWebhook {
+	hooks := []PolicyWebhook{}
+	if err := GlobalConfig.UnmarshalKey("policyWebhooks", &hooks, viper.DecodeHook(mapstructure.StringToTimeDurationHookFunc())); err != nil {
+		return nil
+	}
+	result := []PolicyWebhook{}
+	for _, h := range hooks {
+		if h.URL == "" {
+			continue
+		}
+		if h.Name == "" {
+			h.Name = h.URL
+		}
+		if h.Timeout <= 0 {
+			h.Timeout = defaultPolicyTimeout
+		}
+		if h.Timeout > maxPolicyTimeout {
+			h.Timeout = maxPolicyTimeout
+		}
+		if !strings.EqualFold(h.FailurePolicy, PolicyFailurePolicyFail) {
+			h.FailurePolicy = PolicyFailurePolicyIgnore
+		} else {
+			h.FailurePolicy = PolicyFailurePolicyFail
+		}
+		result = append(result, h)
+	}
+	return result
+}
@@ -0,0 +1,141 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package localstorage
+
+import (
+	"strconv"
+
+	carinav1 "github.com/carina-io/carina-api/api/v1"
+	"github.com/carina-io/carina/scheduler/configuration"
+	"github.com/carina-io/carina/scheduler/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// pendingCacheRequest 统计节点上已调度但缓存卷尚未创建的容量，单位Gi
+// NodeStorageResource only reflects volumes that exist on the node, during a burst of
+// StatefulSet creation the cache volumes of pvcs already assigned to the node would
+// otherwise not be counted and the ssd group is overcommitted.
+// Three cases are counted:
+//   - cache LogicVolumes which are not yet created on the node
+//   - backend LogicVolumes whose cache LogicVolume is not yet requested
+//   - pvcs selected to the node by the scheduler and not yet provisioned
+func (ls *LocalStorage) pendingCacheRequest(pod *v1.Pod, node string, lvs []carinav1.LogicVolume) map[string]int64 {
+	pending := map[string]int64{}
+
+	provisioned := map[string]bool{}
+	hasCache := map[string]bool{}
+	for _, lv := range lvs {
+		if lv.Annotations[utils.VolumeCacheDiskRatio] == "" {
+			continue
+		}
+		provisioned[lv.Spec.NameSpace+"/"+lv.Spec.Pvc] = true
+		for _, owner := range lv.OwnerReferences {
+			if owner.Kind == "LogicVolume" {
+				hasCache[owner.Name] = true
+			}
+		}
+	}
+
+	for _, lv := range lvs {
+		if lv.Annotations[utils.VolumeCacheDiskRatio] == "" || lv.DeletionTimestamp != nil {
+			continue
+		}
+		isCache := false
+		for _, owner := range lv.OwnerReferences {
+			if owner.Kind == "LogicVolume" {
+				isCache = true
+			}
+		}
+		if isCache {
+			if lv.Status.Status == "" {
+				pending[cacheCapacityKey(lv.Spec.DeviceGroup)] += lv.Spec.Size.Value() >> 30
+			}
+			continue
+		}
+		cacheGroup := lv.Annotations[utils.VolumeCacheDiskType]
+		if hasCache[lv.Name] || cacheGroup == "" {
+			continue
+		}
+		if gb, ok := cacheRequestGb(lv.Spec.Size.Value(), lv.Annotations[utils.VolumeCacheDiskRatio]); ok {
+			pending[cacheCapacityKey(cacheGroup)] += gb
+		}
+	}
+
+	claims := map[string]bool{}
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil {
+			claims[pod.Namespace+"/"+vol.PersistentVolumeClaim.ClaimName] = true
+		}
+	}
+	pvcs, err := ls.pvcLister.List(labels.Everything())
+	if err != nil {
+		klog.V(3).Infof("list pvc failed: %v", err)
+		return pending
+	}
+	for _, pvc := range pvcs {
+		key := pvc.Namespace + "/" + pvc.Name
+		if pvc.Status.Phase != v1.ClaimPending || pvc.Annotations[utils.AnnSelectedNode] != node {
+			continue
+		}
+		if claims[key] || provisioned[key] || pvc.Spec.StorageClassName == nil {
+			continue
+		}
+		sc, err := ls.scLister.Get(*pvc.Spec.StorageClassName)
+		if err != nil || sc.Provisioner != utils.CSIPluginName {
+			continue
+		}
+		params := utils.BcacheParameters(sc.Parameters, pvc.Annotations)
+		if params[utils.VolumeCacheDiskType] == "" {
+			continue
+		}
+		if gb, ok := cacheRequestGb(pvc.Spec.Resources.Requests.Storage().Value(), params[utils.VolumeCacheDiskRatio]); ok {
+			pending[cacheCapacityKey(params[utils.VolumeCacheDiskType])] += gb
+		}
+	}
+	klog.V(3).Infof("pending cache request node: %v, %v", node, pending)
+	return pending
+}
+
+// cacheRequestGb 与csi控制器创建bcache卷时的计算方式保持一致
+func cacheRequestGb(requestBytes int64, cacheDiskRatio string) (int64, bool) {
+	ratio, err := strconv.ParseInt(cacheDiskRatio, 10, 64)
+	if err != nil || ratio < 1 || ratio >= 100 || requestBytes <= 0 {
+		return 0, false
+	}
+	requestGb := (requestBytes-1)>>30 + 1
+	return requestGb * ratio / 100, true
+}
+
+func cacheCapacityKey(group string) string {
+	return utils.DeviceCapacityKeyPrefix + configuration.GetDeviceGroup(group)
+}
+
+// reservePendingCache 从可用容量中扣除待创建的缓存卷容量
+func reservePendingCache(capacityMap, pending map[string]int64) {
+	for key, gb := range pending {
+		c, ok := capacityMap[key]
+		if !ok {
+			continue
+		}
+		if c -= gb; c < 0 {
+			c = 0
+		}
+		capacityMap[key] = c
+	}
+}
@@ -0,0 +1,62 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package localstorage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheRequestGb(t *testing.T) {
+	table := []struct {
+		bytes  int64
+		ratio  string
+		result int64
+		ok     bool
+	}{
+		{bytes: 10 << 30, ratio: "50", result: 5, ok: true},
+		{bytes: 10<<30 + 1, ratio: "10", result: 1, ok: true},
+		{bytes: 10 << 30, ratio: "100", ok: false},
+		{bytes: 10 << 30, ratio: "abc", ok: false},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		gb, ok := cacheRequestGb(e.bytes, e.ratio)
+		a.Equal(e.ok, ok)
+		a.Equal(e.result, gb)
+	}
+}
+
+func TestReservePendingCache(t *testing.T) {
+	a := assert.New(t)
+	capacityMap := map[string]int64{
+		"carina.storage.io/carina-vg-ssd": 100,
+		"carina.storage.io/carina-vg-hdd": 200,
+	}
+	reservePendingCache(capacityMap, map[string]int64{
+		"carina.storage.io/carina-vg-ssd":  30,
+		"carina.storage.io/carina-vg-nvme": 10,
+	})
+	a.Equal(int64(70), capacityMap["carina.storage.io/carina-vg-ssd"])
+	a.Equal(int64(200), capacityMap["carina.storage.io/carina-vg-hdd"])
+	a.Len(capacityMap, 2)
+
+	reservePendingCache(capacityMap, map[string]int64{"carina.storage.io/carina-vg-ssd": 80})
+	a.Equal(int64(0), capacityMap["carina.storage.io/carina-vg-ssd"])
+}
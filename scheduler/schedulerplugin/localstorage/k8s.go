@@ -2,12 +2,14 @@ package localstorage
 
 import (
 	"context"
+	"fmt"
 	"path/filepath"
 
 	v1 "github.com/carina-io/carina-api/api/v1"
 	"github.com/carina-io/carina-api/api/v1beta1"
 	"github.com/carina-io/carina/scheduler/utils"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
@@ -23,6 +25,24 @@ var gvr = schema.GroupVersionResource{
 	Resource: "nodestorageresources",
 }
 
+var lvGVR = schema.GroupVersionResource{
+	Group:    v1.GroupVersion.Group,
+	Version:  v1.GroupVersion.Version,
+	Resource: "logicvolumes",
+}
+
+var volumeSnapshotGVR = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshots",
+}
+
+var volumeSnapshotContentGVR = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshotcontents",
+}
+
 func newDynamicClientFromConfig() dynamic.Interface {
 
 	var kubeconfig string
@@ -50,16 +70,44 @@ func newDynamicClientFromConfig() dynamic.Interface {
 }
 
 func getNodeStorageResource(client dynamic.Interface, node string) (*v1beta1.NodeStorageResource, error) {
+	nsr, _, err := getNodeStorageResourceWithProfiles(client, node)
+	return nsr, err
+}
+
+// getNodeStorageResourceWithProfiles also returns the random read iops of the slowest disk of each
+// device group, the diskProfiles of the status are read from the object as carina-api lacks them
+func getNodeStorageResourceWithProfiles(client dynamic.Interface, node string) (*v1beta1.NodeStorageResource, map[string]int64, error) {
 	unstructObj, err := client.Resource(gvr).Namespace("").Get(context.TODO(), node, metav1.GetOptions{})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	nsr := &v1beta1.NodeStorageResource{}
 	err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructObj.UnstructuredContent(), nsr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return nsr, nil
+	return nsr, deviceGroupIOPS(unstructObj.Object), nil
+}
+
+// deviceGroupIOPS 各磁盘组中最慢磁盘的随机读iops，没有测试结果的磁盘组不在其中
+func deviceGroupIOPS(obj map[string]interface{}) map[string]int64 {
+	result := map[string]int64{}
+	profiles, _, _ := unstructured.NestedSlice(obj, "status", "diskProfiles")
+	for _, p := range profiles {
+		profile, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(profile, "deviceGroup")
+		iops, found, _ := unstructured.NestedInt64(profile, "randReadIOPS")
+		if group == "" || !found {
+			continue
+		}
+		if v, ok := result[group]; !ok || iops < v {
+			result[group] = iops
+		}
+	}
+	return result
 }
 
 func listNodeStorageResources(client dynamic.Interface) (*v1beta1.NodeStorageResourceList, error) {
@@ -75,13 +123,19 @@ func listNodeStorageResources(client dynamic.Interface) (*v1beta1.NodeStorageRes
 	return nsr, nil
 }
 
-func listLogicVolumes(client dynamic.Interface, node string) (lvs []string, err error) {
-	var gvr = schema.GroupVersionResource{
-		Group:    v1.GroupVersion.Group,
-		Version:  v1.GroupVersion.Version,
-		Resource: "logicvolumes",
+// exclusiveDeviceGroups returns the raw disks already taken by exclusive volumes
+func exclusiveDeviceGroups(lvs []v1.LogicVolume) (groups []string) {
+	for _, lv := range lvs {
+		klog.V(3).Infof("Get lv:%v, exclusivity: %s", lv.Spec.NodeName, lv.Annotations[utils.ExclusivityDisk])
+		if lv.Annotations[utils.ExclusivityDisk] == "true" {
+			groups = append(groups, lv.Spec.DeviceGroup)
+		}
 	}
-	unstrructObj, err := client.Resource(gvr).Namespace("").List(context.TODO(), metav1.ListOptions{})
+	return groups
+}
+
+func listLogicVolumes(client dynamic.Interface) (*v1.LogicVolumeList, error) {
+	unstrructObj, err := client.Resource(lvGVR).Namespace("").List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -90,15 +144,73 @@ func listLogicVolumes(client dynamic.Interface, node string) (lvs []string, err
 	if err != nil {
 		return nil, err
 	}
-	klog.V(3).Infof("Get lvlist:%v", lvlist)
-	if len(lvlist.Items) == 0 {
-		return lvs, nil
+	return lvlist, nil
+}
+
+func listNodeLogicVolumes(client dynamic.Interface, node string) (lvs []v1.LogicVolume, err error) {
+	lvlist, err := listLogicVolumes(client)
+	if err != nil {
+		return nil, err
 	}
+	klog.V(3).Infof("Get lvlist:%v", lvlist)
 	for _, lv := range lvlist.Items {
-		klog.V(3).Infof("Get lv:%v,node:%s, exclusivity: %s", lv.Spec.NodeName, node, lv.Annotations[utils.ExclusivityDisk])
-		if lv.Spec.NodeName == node && lv.Annotations[utils.ExclusivityDisk] == "true" {
-			lvs = append(lvs, lv.Spec.DeviceGroup)
+		if lv.Spec.NodeName == node {
+			lvs = append(lvs, lv)
 		}
 	}
 	return lvs, nil
 }
+
+// snapshotNode returns the node holding the snapshot a pvc is restored from,
+// a snapshot exported to s3 can be restored on any node and "" is returned
+func snapshotNode(client dynamic.Interface, namespace, name string) (string, error) {
+	snapshot, err := client.Resource(volumeSnapshotGVR).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	contentName, _, _ := unstructured.NestedString(snapshot.Object, "status", "boundVolumeSnapshotContentName")
+	if contentName == "" {
+		return "", fmt.Errorf("volumesnapshot %s/%s is not bound", namespace, name)
+	}
+	content, err := client.Resource(volumeSnapshotContentGVR).Get(context.TODO(), contentName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	handle, _, _ := unstructured.NestedString(content.Object, "status", "snapshotHandle")
+	if handle == "" {
+		return "", fmt.Errorf("volumesnapshotcontent %s is not ready", contentName)
+	}
+
+	// carina-api的LogicVolume类型没有conditions字段，直接读取unstructured对象
+	lvlist, err := client.Resource(lvGVR).Namespace("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, lv := range lvlist.Items {
+		volumeID, _, _ := unstructured.NestedString(lv.Object, "status", "volumeID")
+		if volumeID != handle {
+			continue
+		}
+		if snapshotExported(lv.Object) {
+			return "", nil
+		}
+		nodeName, _, _ := unstructured.NestedString(lv.Object, "spec", "nodeName")
+		return nodeName, nil
+	}
+	return "", fmt.Errorf("snapshot %s of volumesnapshot %s/%s is not found", handle, namespace, name)
+}
+
+// snapshotExported reports whether the Exported condition of a snapshot LogicVolume is true
+func snapshotExported(lv map[string]interface{}) bool {
+	conditions, _, _ := unstructured.NestedSlice(lv, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if condition["type"] == utils.ConditionExported {
+			return condition["status"] == string(metav1.ConditionTrue)
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package localstorage
+
+import (
+	"testing"
+
+	"github.com/carina-io/carina/scheduler/utils"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestSnapshotNode(t *testing.T) {
+	snapshot := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "snapshot.storage.k8s.io/v1",
+		"kind":       "VolumeSnapshot",
+		"metadata":   map[string]interface{}{"name": "snap-1", "namespace": "default"},
+		"status":     map[string]interface{}{"boundVolumeSnapshotContentName": "snapcontent-1"},
+	}}
+	content := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "snapshot.storage.k8s.io/v1",
+		"kind":       "VolumeSnapshotContent",
+		"metadata":   map[string]interface{}{"name": "snapcontent-1"},
+		"status":     map[string]interface{}{"snapshotHandle": "snapshot-1"},
+	}}
+	newLV := func(conditions ...interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "carina.storage.io/v1",
+			"kind":       "LogicVolume",
+			"metadata":   map[string]interface{}{"name": "snapshot-1", "namespace": "default"},
+			"spec":       map[string]interface{}{"nodeName": "node1"},
+			"status":     map[string]interface{}{"volumeID": "snapshot-1", "conditions": conditions},
+		}}
+	}
+
+	table := []struct {
+		name   string
+		lv     *unstructured.Unstructured
+		expect string
+	}{
+		{name: "local", lv: newLV(), expect: "node1"},
+		{name: "uploading", lv: newLV(map[string]interface{}{"type": utils.ConditionExported, "status": "False"}), expect: "node1"},
+		// 已导出到s3的快照可以在任意节点恢复
+		{name: "exported", lv: newLV(map[string]interface{}{"type": utils.ConditionExported, "status": "True"}), expect: ""},
+	}
+
+	for _, c := range table {
+		client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			lvGVR: "LogicVolumeList",
+		}, snapshot, content, c.lv)
+		node, err := snapshotNode(client, "default", "snap-1")
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.expect, node, c.name)
+	}
+}
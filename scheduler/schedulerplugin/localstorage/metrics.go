@@ -0,0 +1,185 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package localstorage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carina-io/carina/scheduler/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// 过滤拒绝的原因，作为指标的reason标签，取值有限
+const (
+	reasonNodeMismatch         = "node_mismatch"
+	reasonStorageUnavailable   = "storage_unavailable"
+	reasonNoDeviceGroup        = "no_device_group"
+	reasonInsufficientCapacity = "insufficient_capacity"
+	reasonInsufficientCache    = "insufficient_cache"
+	reasonInsufficientStripes  = "insufficient_stripes"
+	reasonInsufficientReplica  = "insufficient_replica"
+	reasonPolicy               = "policy"
+	// anyDeviceGroup storageclass未设置磁盘组时的device_group标签
+	anyDeviceGroup = "any"
+)
+
+var (
+	filterRejections = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      "carina",
+		Subsystem:      "scheduler",
+		Name:           "filter_rejections_total",
+		Help:           "Number of nodes the local-storage plugin filtered out, by reason and device group.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"reason", "device_group"})
+
+	filterDuration = metrics.NewHistogram(&metrics.HistogramOpts{
+		Namespace:      "carina",
+		Subsystem:      "scheduler",
+		Name:           "filter_duration_seconds",
+		Help:           "Latency of the local-storage plugin filtering a node.",
+		Buckets:        metrics.ExponentialBuckets(0.001, 2, 12),
+		StabilityLevel: metrics.ALPHA,
+	})
+
+	scoreDuration = metrics.NewHistogram(&metrics.HistogramOpts{
+		Namespace:      "carina",
+		Subsystem:      "scheduler",
+		Name:           "score_duration_seconds",
+		Help:           "Latency of the local-storage plugin scoring a node.",
+		Buckets:        metrics.ExponentialBuckets(0.001, 2, 12),
+		StabilityLevel: metrics.ALPHA,
+	})
+
+	placementFailures = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      "carina",
+		Subsystem:      "scheduler",
+		Name:           "placement_failures_total",
+		Help:           "Number of scheduling attempts of pods with carina volumes that found no node, by device group and the most frequent reason.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"reason", "device_group"})
+
+	registerOnce sync.Once
+)
+
+var _ framework.PostFilterPlugin = &LocalStorage{}
+
+// registerMetrics 注册到kube-scheduler的legacyregistry，随调度器的/metrics一起暴露
+func registerMetrics() {
+	registerOnce.Do(func() {
+		legacyregistry.MustRegister(filterRejections, filterDuration, scoreDuration, placementFailures)
+	})
+}
+
+// rejectionReason 将Filter返回的消息归类为指标的reason，不是本插件的拒绝时返回空
+func rejectionReason(message string) string {
+	switch {
+	case message == "pv node mismatch":
+		return reasonNodeMismatch
+	case strings.HasPrefix(message, "Failed to obtain"):
+		return reasonStorageUnavailable
+	case message == "does not have a disk group that satisfies":
+		return reasonNoDeviceGroup
+	case message == "node storage resource insufficient":
+		return reasonInsufficientCapacity
+	case message == "node cache storage resource insufficient":
+		return reasonInsufficientCache
+	case message == "node striped storage resource insufficient", strings.HasPrefix(message, "disk group ") && strings.Contains(message, "physical volumes"):
+		return reasonInsufficientStripes
+	case message == "no other node has capacity for the volume replicas":
+		return reasonInsufficientReplica
+	case strings.HasPrefix(message, "policy "):
+		return reasonPolicy
+	}
+	return ""
+}
+
+// podDeviceGroups pod待创建的卷所在的磁盘组，用作device_group标签
+func podDeviceGroups(pvcMap map[string][]*v1.PersistentVolumeClaim) []string {
+	groups := []string{}
+	for key := range pvcMap {
+		if key == undefined {
+			groups = append(groups, anyDeviceGroup)
+			continue
+		}
+		groups = append(groups, strings.TrimPrefix(key, utils.DeviceCapacityKeyPrefix))
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// observeFilter 记录一次过滤的耗时，节点被拒绝时按原因和磁盘组计数
+func (ls *LocalStorage) observeFilter(pod *v1.Pod, status *framework.Status, start time.Time) {
+	filterDuration.Observe(time.Since(start).Seconds())
+	if status.IsSuccess() || status.Code() == framework.Error {
+		return
+	}
+	reason := rejectionReason(status.Message())
+	if reason == "" {
+		return
+	}
+	pvcMap, _, _, err := ls.getLocalStoragePvc(pod)
+	if err != nil {
+		return
+	}
+	for _, group := range podDeviceGroups(pvcMap) {
+		filterRejections.WithLabelValues(reason, group).Inc()
+	}
+}
+
+// PostFilter 没有节点通过过滤时调用，只记录本插件导致的失败，不做抢占
+// The status is Unschedulable so that the scheduler goes on with the next post filter plugins, e.g. preemption.
+func (ls *LocalStorage) PostFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod, filteredNodeStatusMap framework.NodeToStatusMap) (*framework.PostFilterResult, *framework.Status) {
+	reason := mostFrequentReason(filteredNodeStatusMap)
+	if reason == "" {
+		return nil, framework.NewStatus(framework.Unschedulable)
+	}
+	pvcMap, _, _, err := ls.getLocalStoragePvc(pod)
+	if err != nil {
+		return nil, framework.NewStatus(framework.Unschedulable)
+	}
+	groups := podDeviceGroups(pvcMap)
+	klog.V(3).Infof("no node for pod %s/%s, device groups %v: %s", pod.Namespace, pod.Name, groups, reason)
+	for _, group := range groups {
+		placementFailures.WithLabelValues(reason, group).Inc()
+	}
+	return nil, framework.NewStatus(framework.Unschedulable)
+}
+
+// mostFrequentReason 返回本插件拒绝节点最多的原因，相同时取字典序最小的，保证标签稳定
+func mostFrequentReason(statuses framework.NodeToStatusMap) string {
+	count := map[string]int{}
+	for _, status := range statuses {
+		if reason := rejectionReason(status.Message()); reason != "" {
+			count[reason]++
+		}
+	}
+	reason := ""
+	for r, n := range count {
+		if reason == "" || n > count[reason] || n == count[reason] && r < reason {
+			reason = r
+		}
+	}
+	return reason
+}
@@ -0,0 +1,54 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package localstorage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+func TestRejectionReason(t *testing.T) {
+	assert.Equal(t, reasonInsufficientCapacity, rejectionReason("node storage resource insufficient"))
+	assert.Equal(t, reasonInsufficientCache, rejectionReason("node cache storage resource insufficient"))
+	assert.Equal(t, reasonInsufficientStripes, rejectionReason("disk group carina-vg-ssd has fewer than 3 physical volumes"))
+	assert.Equal(t, reasonStorageUnavailable, rejectionReason("Failed to obtain node storage information"))
+	assert.Equal(t, reasonPolicy, rejectionReason("policy tier-guard: tenant quota exceeded"))
+	// 其他插件的拒绝不计入
+	assert.Equal(t, "", rejectionReason("node(s) didn't match Pod's node affinity"))
+}
+
+func TestMostFrequentReason(t *testing.T) {
+	statuses := framework.NodeToStatusMap{
+		"node1": framework.NewStatus(framework.UnschedulableAndUnresolvable, "node storage resource insufficient"),
+		"node2": framework.NewStatus(framework.UnschedulableAndUnresolvable, "node storage resource insufficient"),
+		"node3": framework.NewStatus(framework.UnschedulableAndUnresolvable, "pv node mismatch"),
+		"node4": framework.NewStatus(framework.Unschedulable, "node(s) had taint"),
+	}
+	assert.Equal(t, reasonInsufficientCapacity, mostFrequentReason(statuses))
+	assert.Equal(t, "", mostFrequentReason(framework.NodeToStatusMap{"node4": statuses["node4"]}))
+}
+
+func TestPodDeviceGroups(t *testing.T) {
+	pvcMap := map[string][]*v1.PersistentVolumeClaim{
+		"carina.storage.io/carina-vg-ssd": nil,
+		undefined:                         nil,
+	}
+	assert.Equal(t, []string{anyDeviceGroup, "carina-vg-ssd"}, podDeviceGroups(pvcMap))
+}
@@ -0,0 +1,232 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package localstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/carina-io/carina/scheduler/configuration"
+	"github.com/carina-io/carina/scheduler/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+const (
+	policyPhaseFilter = "filter"
+	policyPhaseScore  = "score"
+	// maxPolicyScore webhook打分范围0-10，与容量打分同一量级
+	maxPolicyScore = 10
+)
+
+// PolicyVolume a carina pvc of the pod that is not bound yet
+type PolicyVolume struct {
+	Namespace    string `json:"namespace"`
+	Name         string `json:"name"`
+	StorageClass string `json:"storageClass"`
+	// DeviceGroup empty if the storageclass does not set one
+	DeviceGroup  string `json:"deviceGroup"`
+	RequestBytes int64  `json:"requestBytes"`
+}
+
+// PolicyRequest body posted to a policy webhook
+type PolicyRequest struct {
+	Phase        string            `json:"phase"`
+	Pod          PolicyObject      `json:"pod"`
+	Node         PolicyObject      `json:"node"`
+	Volumes      []PolicyVolume    `json:"volumes"`
+	NodeCapacity map[string]int64  `json:"nodeCapacity,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// PolicyObject name and labels of the pod or node
+type PolicyObject struct {
+	Namespace string            `json:"namespace,omitempty"`
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// PolicyResponse answer of a policy webhook. Allowed is only read in the filter phase, Score only in the score phase.
+type PolicyResponse struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+	Score   int64  `json:"score"`
+}
+
+// policyClients http clients by ca file, the clients keep their connections between scheduling cycles
+var policyClients = struct {
+	sync.Mutex
+	byCA map[string]*http.Client
+}{byCA: map[string]*http.Client{}}
+
+func policyClient(caFile string) (*http.Client, error) {
+	policyClients.Lock()
+	defer policyClients.Unlock()
+	if c, ok := policyClients.byCA[caFile]; ok {
+		return c, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	c := &http.Client{Transport: transport}
+	policyClients.byCA[caFile] = c
+	return c, nil
+}
+
+// callPolicyWebhook posts the request and decodes the answer, non 200 answers are errors
+func callPolicyWebhook(ctx context.Context, hook configuration.PolicyWebhook, req *PolicyRequest) (*PolicyResponse, error) {
+	c, err := policyClient(hook.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := c.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	answer := &PolicyResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(answer); err != nil {
+		return nil, fmt.Errorf("invalid answer: %v", err)
+	}
+	return answer, nil
+}
+
+// newPolicyRequest describes the pending carina pvcs of the pod on the node
+func newPolicyRequest(phase string, pod *v1.Pod, node *v1.Node, pvcMap map[string][]*v1.PersistentVolumeClaim, capacityMap map[string]int64) *PolicyRequest {
+	req := &PolicyRequest{
+		Phase:        phase,
+		Pod:          PolicyObject{Namespace: pod.Namespace, Name: pod.Name, Labels: pod.Labels},
+		Node:         PolicyObject{Name: node.Name, Labels: node.Labels},
+		Volumes:      []PolicyVolume{},
+		NodeCapacity: map[string]int64{},
+		Annotations:  pod.Annotations,
+	}
+	for key, pvcs := range pvcMap {
+		group := ""
+		if key != undefined {
+			group = strings.TrimPrefix(key, utils.DeviceCapacityKeyPrefix)
+		}
+		for _, pvc := range pvcs {
+			sc := ""
+			if pvc.Spec.StorageClassName != nil {
+				sc = *pvc.Spec.StorageClassName
+			}
+			req.Volumes = append(req.Volumes, PolicyVolume{
+				Namespace:    pvc.Namespace,
+				Name:         pvc.Name,
+				StorageClass: sc,
+				DeviceGroup:  group,
+				RequestBytes: pvc.Spec.Resources.Requests.Storage().Value(),
+			})
+		}
+	}
+	sort.Slice(req.Volumes, func(i, j int) bool { return req.Volumes[i].Name < req.Volumes[j].Name })
+	// 容量以Gi为单位，与NodeStorageResource的allocatable一致
+	for key, c := range capacityMap {
+		req.NodeCapacity[strings.TrimPrefix(key, utils.DeviceCapacityKeyPrefix)] = c
+	}
+	return req
+}
+
+// policyFilter asks the filter webhooks whether the pod may run on the node, the first denial wins.
+// A failing webhook rejects the node with a resolvable status when its failurePolicy is Fail so the pod is retried.
+func policyFilter(ctx context.Context, hooks []configuration.PolicyWebhook, req *PolicyRequest) *framework.Status {
+	for _, hook := range hooks {
+		if !hook.Filter {
+			continue
+		}
+		answer, err := callPolicyWebhook(ctx, hook, req)
+		if err != nil {
+			klog.Warningf("policy webhook %s failed pod: %s/%s, node: %s, err: %v", hook.Name, req.Pod.Namespace, req.Pod.Name, req.Node.Name, err)
+			if hook.FailurePolicy == configuration.PolicyFailurePolicyFail {
+				return framework.NewStatus(framework.Unschedulable, fmt.Sprintf("policy webhook %s unavailable", hook.Name))
+			}
+			continue
+		}
+		if !answer.Allowed {
+			reason := answer.Reason
+			if reason == "" {
+				reason = "denied"
+			}
+			klog.V(3).Infof("policy webhook %s denied pod: %s/%s, node: %s, reason: %s", hook.Name, req.Pod.Namespace, req.Pod.Name, req.Node.Name, reason)
+			return framework.NewStatus(framework.UnschedulableAndUnresolvable, fmt.Sprintf("policy %s: %s", hook.Name, reason))
+		}
+	}
+	return nil
+}
+
+// policyScore sums the scores of the score webhooks, each clamped to 0-10. A failing webhook adds
+// nothing when its failurePolicy is Fail and the neutral half of the range when it is Ignore.
+func policyScore(ctx context.Context, hooks []configuration.PolicyWebhook, req *PolicyRequest) int64 {
+	var score int64
+	for _, hook := range hooks {
+		if !hook.Score {
+			continue
+		}
+		answer, err := callPolicyWebhook(ctx, hook, req)
+		if err != nil {
+			klog.Warningf("policy webhook %s failed pod: %s/%s, node: %s, err: %v", hook.Name, req.Pod.Namespace, req.Pod.Name, req.Node.Name, err)
+			if hook.FailurePolicy == configuration.PolicyFailurePolicyIgnore {
+				score += maxPolicyScore / 2
+			}
+			continue
+		}
+		switch {
+		case answer.Score < 0:
+		case answer.Score > maxPolicyScore:
+			score += maxPolicyScore
+		default:
+			score += answer.Score
+		}
+	}
+	return score
+}
@@ -0,0 +1,88 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package localstorage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/carina-io/carina/scheduler/configuration"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+func TestPolicyWebhooks(t *testing.T) {
+	a := assert.New(t)
+	// raid-only: 没有raid卡的节点拒绝数据库pod，有raid卡的节点打8分
+	raid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := PolicyRequest{}
+		a.NoError(json.NewDecoder(r.Body).Decode(&req))
+		if req.Node.Labels["raid"] != "true" && req.Pod.Labels["app"] == "mysql" {
+			_ = json.NewEncoder(w).Encode(PolicyResponse{Allowed: false, Reason: "databases need a raid controller"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(PolicyResponse{Allowed: true, Score: 80})
+	}))
+	defer raid.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_ = json.NewEncoder(w).Encode(PolicyResponse{Allowed: false})
+	}))
+	defer slow.Close()
+
+	sc := "csi-carina-sc"
+	pvcMap := map[string][]*v1.PersistentVolumeClaim{
+		"carina.storage.io/carina-vg-ssd": {{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "data"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				StorageClassName: &sc,
+				Resources:        v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")}},
+			},
+		}},
+	}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "mysql-0", Labels: map[string]string{"app": "mysql"}}}
+	plain := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n1"}}
+	withRaid := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n2", Labels: map[string]string{"raid": "true"}}}
+
+	req := newPolicyRequest(policyPhaseFilter, pod, plain, pvcMap, map[string]int64{"carina.storage.io/carina-vg-ssd": 100})
+	a.Equal([]PolicyVolume{{Namespace: "db", Name: "data", StorageClass: sc, DeviceGroup: "carina-vg-ssd", RequestBytes: 10 << 30}}, req.Volumes)
+	a.Equal(map[string]int64{"carina-vg-ssd": 100}, req.NodeCapacity)
+
+	hooks := []configuration.PolicyWebhook{{Name: "raid-only", URL: raid.URL, Timeout: time.Second, FailurePolicy: configuration.PolicyFailurePolicyFail, Filter: true, Score: true}}
+	status := policyFilter(context.TODO(), hooks, req)
+	a.Equal(framework.UnschedulableAndUnresolvable, status.Code())
+	a.Contains(status.Message(), "databases need a raid controller")
+	req.Node = PolicyObject{Name: withRaid.Name, Labels: withRaid.Labels}
+	a.Nil(policyFilter(context.TODO(), hooks, req))
+	a.Equal(int64(maxPolicyScore), policyScore(context.TODO(), hooks, req))
+
+	// 超时的webhook按failurePolicy处理
+	timeout := configuration.PolicyWebhook{Name: "slow", URL: slow.URL, Timeout: 50 * time.Millisecond, Filter: true, Score: true}
+	timeout.FailurePolicy = configuration.PolicyFailurePolicyIgnore
+	a.Nil(policyFilter(context.TODO(), []configuration.PolicyWebhook{timeout}, req))
+	a.Equal(int64(maxPolicyScore/2), policyScore(context.TODO(), []configuration.PolicyWebhook{timeout}, req))
+	timeout.FailurePolicy = configuration.PolicyFailurePolicyFail
+	a.Equal(framework.Unschedulable, policyFilter(context.TODO(), []configuration.PolicyWebhook{timeout}, req).Code())
+	a.Equal(int64(0), policyScore(context.TODO(), []configuration.PolicyWebhook{timeout}, req))
+}
@@ -0,0 +1,102 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package localstorage
+
+import (
+	"strings"
+
+	"github.com/carina-io/carina/scheduler/configuration"
+	"github.com/carina-io/carina/scheduler/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// replicaStatus 检查副本卷的第二个副本能否放到其它节点
+// The second copy is placed by the controller after the pod is bound, a node is
+// only rejected when no other node could take the copy at all.
+func (ls *LocalStorage) replicaStatus(pvcMap map[string][]*v1.PersistentVolumeClaim, nodeName string) *framework.Status {
+	requests := map[string]int64{}
+	for key, pvcs := range pvcMap {
+		for _, pvc := range pvcs {
+			if pvc.Spec.StorageClassName == nil {
+				continue
+			}
+			sc, err := ls.scLister.Get(*pvc.Spec.StorageClassName)
+			if err != nil {
+				return framework.NewStatus(framework.Error, "get sc resource error")
+			}
+			if sc.Parameters[utils.VolumeReplicas] != "2" {
+				continue
+			}
+			requests[key] += (pvc.Spec.Resources.Requests.Storage().Value()-1)>>30 + 1
+		}
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+
+	nsrList, err := listNodeStorageResources(ls.dynamicClient)
+	if err != nil {
+		return framework.NewStatus(framework.Error, "list node storage resources error")
+	}
+	allocatable := map[string]map[string]int64{}
+	for _, nsr := range nsrList.Items {
+		groups := map[string]int64{}
+		for key, v := range nsr.Status.Allocatable {
+			if strings.HasPrefix(key, utils.DeviceCapacityKeyPrefix) && !configuration.CheckRawDeviceGroup(strings.Split(key, "/")[1]) {
+				groups[key] = v.Value()
+			}
+		}
+		allocatable[nsr.Name] = groups
+	}
+	if !replicaFits(requests, allocatable, nodeName) {
+		klog.V(3).Infof("no node other than %s can hold the replicas %v", nodeName, requests)
+		return framework.NewStatus(framework.Unschedulable, "no other node has capacity for the volume replicas")
+	}
+	return nil
+}
+
+// replicaFits 判断除exclude之外是否有节点能放下全部第二副本，容量单位GiB
+func replicaFits(requests map[string]int64, allocatable map[string]map[string]int64, exclude string) bool {
+	for node, groups := range allocatable {
+		if node == exclude {
+			continue
+		}
+		fits := true
+		for key, request := range requests {
+			if key != undefined {
+				fits = groups[key] >= request
+			} else {
+				fits = false
+				for _, free := range groups {
+					if free >= request {
+						fits = true
+						break
+					}
+				}
+			}
+			if !fits {
+				break
+			}
+		}
+		if fits {
+			return true
+		}
+	}
+	return false
+}
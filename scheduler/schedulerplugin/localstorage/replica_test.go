@@ -0,0 +1,40 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package localstorage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicaFits(t *testing.T) {
+	allocatable := map[string]map[string]int64{
+		"node1": {"carina.storage.io/carina-vg-ssd": 100},
+		"node2": {"carina.storage.io/carina-vg-ssd": 20, "carina.storage.io/carina-vg-hdd": 200},
+	}
+
+	// 只有当前节点放得下时，第二副本无处可放
+	assert.False(t, replicaFits(map[string]int64{"carina.storage.io/carina-vg-ssd": 50}, allocatable, "node1"))
+	assert.True(t, replicaFits(map[string]int64{"carina.storage.io/carina-vg-ssd": 50}, allocatable, "node2"))
+	assert.True(t, replicaFits(map[string]int64{"carina.storage.io/carina-vg-ssd": 20}, allocatable, "node1"))
+	// 未指定磁盘组时任一lvm磁盘组放得下即可
+	assert.True(t, replicaFits(map[string]int64{undefined: 150}, allocatable, "node1"))
+	assert.False(t, replicaFits(map[string]int64{undefined: 250}, allocatable, "node1"))
+	// 所有请求必须放在同一个节点
+	assert.False(t, replicaFits(map[string]int64{"carina.storage.io/carina-vg-ssd": 50, "carina.storage.io/carina-vg-hdd": 50}, allocatable, ""))
+}
@@ -22,6 +22,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"k8s.io/client-go/dynamic"
 
@@ -57,6 +58,7 @@ func New(_ runtime.Object, handle framework.Handle) (framework.Plugin, error) {
 	pvcLister := handle.SharedInformerFactory().Core().V1().PersistentVolumeClaims().Lister()
 	pvLister := handle.SharedInformerFactory().Core().V1().PersistentVolumes().Lister()
 	dynamicClient := newDynamicClientFromConfig()
+	registerMetrics()
 	return &LocalStorage{
 		handle:        handle,
 		pvcLister:     pvcLister,
@@ -72,7 +74,13 @@ func (ls *LocalStorage) Name() string {
 
 // Filter 过滤掉不符合当前 Pod 运行条件的Node（相当于旧版本的 predicate）
 func (ls *LocalStorage) Filter(ctx context.Context, cycleState *framework.CycleState, pod *v1.Pod, node *framework.NodeInfo) *framework.Status {
+	start := time.Now()
+	status := ls.filter(ctx, pod, node)
+	ls.observeFilter(pod, status, start)
+	return status
+}
 
+func (ls *LocalStorage) filter(ctx context.Context, pod *v1.Pod, node *framework.NodeInfo) *framework.Status {
 	klog.V(3).Infof("filter pod: %v, node: %v", pod.Name, node.Node().Name)
 
 	pvcMap, nodeName, cacheDeviceRequest, err := ls.getLocalStoragePvc(pod)
@@ -95,11 +103,12 @@ func (ls *LocalStorage) Filter(ctx context.Context, cycleState *framework.CycleS
 		return framework.NewStatus(framework.UnschedulableAndUnresolvable, "Failed to obtain node storage information")
 	}
 
-	lvs, err := listLogicVolumes(ls.dynamicClient, node.Node().Name)
+	nodeLvs, err := listNodeLogicVolumes(ls.dynamicClient, node.Node().Name)
 	if err != nil {
 		klog.V(3).Infof("Failed to obtain logicVolumes  information pod: %v, node: %v, err: %v", pod.Name, node.Node().Name, err.Error())
 		return framework.NewStatus(framework.UnschedulableAndUnresolvable, "Failed to obtain logicVolumes  information")
 	}
+	lvs := exclusiveDeviceGroups(nodeLvs)
 	volumeType := utils.LvmVolumeType
 	for key, _ := range pvcMap {
 		strArr := strings.Split(key, "/")
@@ -161,6 +170,9 @@ func (ls *LocalStorage) Filter(ctx context.Context, cycleState *framework.CycleS
 			}
 		}
 	}
+	if volumeType == utils.LvmVolumeType {
+		reservePendingCache(capacityMap, ls.pendingCacheRequest(pod, node.Node().Name, nodeLvs))
+	}
 	klog.V(3).Infof("capacityMap: %v", capacityMap)
 	klog.V(3).Infof("type:%s,total: %v", volumeType, total)
 
@@ -213,6 +225,15 @@ func (ls *LocalStorage) Filter(ctx context.Context, cycleState *framework.CycleS
 		}
 	}
 
+	if volumeType == utils.LvmVolumeType {
+		if status := ls.stripeStatus(pvcMap, nsr); status != nil {
+			return status
+		}
+		if status := ls.replicaStatus(pvcMap, node.Node().Name); status != nil {
+			return status
+		}
+	}
+
 	// check cache device request
 	for key, value := range cacheDeviceRequest {
 		requestTotalGb := (value-1)>>30 + 1
@@ -222,12 +243,22 @@ func (ls *LocalStorage) Filter(ctx context.Context, cycleState *framework.CycleS
 		}
 	}
 
+	// 容量满足后再询问外部策略，减少webhook调用
+	if hooks := configuration.PolicyWebhooks(); len(hooks) > 0 {
+		if status := policyFilter(ctx, hooks, newPolicyRequest(policyPhaseFilter, pod, node.Node(), pvcMap, capacityMap)); status != nil {
+			return status
+		}
+	}
+
 	klog.V(3).Infof("filter success pod: %v, node: %v", pod.Name, node.Node().Name)
 	return framework.NewStatus(framework.Success, "")
 }
 
 // Score 对节点进行打分（相当于旧版本的 priorities）
 func (ls *LocalStorage) Score(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) (int64, *framework.Status) {
+	defer func(start time.Time) {
+		scoreDuration.Observe(time.Since(start).Seconds())
+	}(time.Now())
 	klog.V(3).Infof("score pod: %v, node: %v", pod.Name, nodeName)
 	pvcMap, node, _, _ := ls.getLocalStoragePvc(pod)
 	if node == nodeName {
@@ -245,7 +276,7 @@ func (ls *LocalStorage) Score(ctx context.Context, state *framework.CycleState,
 		}
 	}
 
-	nsr, err := getNodeStorageResource(ls.dynamicClient, nodeName)
+	nsr, groupIOPS, err := getNodeStorageResourceWithProfiles(ls.dynamicClient, nodeName)
 	if err != nil {
 		klog.V(3).Infof("Failed to obtain node storage information pod: %v, node: %v, err: %v", pod.Name, nodeName, err.Error())
 		return 0, framework.NewStatus(framework.UnschedulableAndUnresolvable, "Failed to obtain node storage information")
@@ -280,6 +311,14 @@ func (ls *LocalStorage) Score(ctx context.Context, state *framework.CycleState,
 			}
 		}
 	}
+	if volumeType == utils.LvmVolumeType {
+		nodeLvs, err := listNodeLogicVolumes(ls.dynamicClient, nodeName)
+		if err != nil {
+			klog.V(3).Infof("Failed to obtain logicVolumes information pod: %v, node: %v, err: %v", pod.Name, nodeName, err.Error())
+		} else {
+			reservePendingCache(capacityMap, ls.pendingCacheRequest(pod, nodeName, nodeLvs))
+		}
+	}
 	var score int64
 	// 计算节点分数
 	// 影响磁盘分数的有磁盘容量,磁盘上现有pv数量,磁盘IO
@@ -315,6 +354,13 @@ func (ls *LocalStorage) Score(ctx context.Context, state *framework.CycleState,
 			if configuration.SchedulerStrategy() == configuration.SchedulerBinpack {
 				score = 6 - reasonableScore(ratio)
 			}
+			// 同名磁盘组在各节点上的硬件可能差别很大，按磁盘测试结果加分
+			score += profileScore(groupIOPS, strings.TrimPrefix(key, utils.DeviceCapacityKeyPrefix))
+		}
+	}
+	if hooks := configuration.PolicyWebhooks(); len(hooks) > 0 {
+		if nodeInfo, err := ls.handle.SnapshotSharedLister().NodeInfos().Get(nodeName); err == nil {
+			score += policyScore(ctx, hooks, newPolicyRequest(policyPhaseScore, pod, nodeInfo.Node(), pvcMap, capacityMap))
 		}
 	}
 	klog.V(3).Infof("score pod: %v, node: %v score %v", pod.Name, nodeName, score)
@@ -359,6 +405,14 @@ func (ls *LocalStorage) getLocalStoragePvc(pod *v1.Pod) (map[string][]*v1.Persis
 			if err != nil {
 				return localPvc, nodeName, cacheDeviceRequest, err
 			}
+			// 副本卷可以在两个副本所在的任一节点使用，由pv的nodeAffinity约束
+			if pv.Spec.CSI != nil && pv.Spec.CSI.VolumeAttributes[utils.VolumeReplicaNode] != "" {
+				continue
+			}
+			// 共享卷经nfs网关挂载，pod可以调度到任意节点
+			if pv.Spec.CSI != nil && pv.Spec.CSI.VolumeAttributes[utils.VolumeShared] == "true" {
+				continue
+			}
 			if nodeName == "" {
 				nodeName = pv.Spec.CSI.VolumeAttributes[utils.VolumeDeviceNode]
 			} else if nodeName != pv.Spec.CSI.VolumeAttributes[utils.VolumeDeviceNode] {
@@ -367,16 +421,45 @@ func (ls *LocalStorage) getLocalStoragePvc(pod *v1.Pod) (map[string][]*v1.Persis
 			continue
 		}
 
-		deviceGroup := sc.Parameters[utils.DeviceDiskKey]
+		// 从快照恢复的pvc只能在快照所在节点创建，已导出到s3的快照可以在任意节点恢复
+		if ds := pvc.Spec.DataSource; ds != nil && ds.Kind == "VolumeSnapshot" {
+			snapNode, err := snapshotNode(ls.dynamicClient, pvc.Namespace, ds.Name)
+			if err != nil {
+				return localPvc, nodeName, cacheDeviceRequest, err
+			}
+			if snapNode != "" && nodeName == "" {
+				nodeName = snapNode
+			} else if snapNode != "" && nodeName != snapNode {
+				return localPvc, nodeName, cacheDeviceRequest, errors.New("pvc node clash")
+			}
+		}
+
+		// 导入主机目录的pvc只能在数据所在节点创建
+		if source := pvc.Annotations[utils.VolumeImportSource]; source != "" {
+			importNode := strings.SplitN(source, ":", 2)[0]
+			if nodeName == "" {
+				nodeName = importNode
+			} else if nodeName != importNode {
+				return localPvc, nodeName, cacheDeviceRequest, errors.New("pvc node clash")
+			}
+		}
+
+		// pvc注解可以覆盖bcache的后端盘组、缓存盘组和缓存比例
+		params := utils.BcacheParameters(sc.Parameters, pvc.Annotations)
+		deviceGroup := utils.DeviceGroupParameter(params)
+		// StoragePolicy注入的磁盘组优先于storageclass参数
+		if group := pvc.Annotations[utils.DeviceDiskKey]; group != "" && params[utils.VolumeBackendDiskType] == "" {
+			deviceGroup = group
+		}
 		// bcache device
-		if deviceGroup == "" {
-			deviceGroup = sc.Parameters[utils.VolumeBackendDiskType]
+		if group := params[utils.VolumeBackendDiskType]; group != "" {
+			deviceGroup = group
 		}
 
-		cacheGroup := sc.Parameters[utils.VolumeCacheDiskType]
+		cacheGroup := params[utils.VolumeCacheDiskType]
 		if cacheGroup != "" {
-			cacheGroup = utils.DeviceCapacityKeyPrefix + configuration.GetDeviceGroup(deviceGroup)
-			cacheDiskRatio := sc.Parameters[utils.VolumeCacheDiskRatio]
+			cacheGroup = cacheCapacityKey(cacheGroup)
+			cacheDiskRatio := params[utils.VolumeCacheDiskRatio]
 			ratio, err := strconv.ParseInt(cacheDiskRatio, 10, 64)
 			if err != nil {
 				return localPvc, nodeName, cacheDeviceRequest, errors.New("carina.storage.io/cache-disk-ratio, Should be in 1-100")
@@ -423,6 +506,26 @@ func minimumValueMinus(array []int64, value int64) []int64 {
 	return array
 }
 
+// profileScore 按磁盘组中最慢磁盘的随机读iops加0-4分，没有测试结果的磁盘组加2分
+// The volume may be allocated on any disk of the group, so the slowest one counts.
+func profileScore(groupIOPS map[string]int64, group string) int64 {
+	iops, ok := groupIOPS[group]
+	if !ok {
+		return 2
+	}
+	switch {
+	case iops < 1000:
+		return 0
+	case iops < 10000:
+		return 1
+	case iops < 50000:
+		return 2
+	case iops < 200000:
+		return 3
+	}
+	return 4
+}
+
 // 分值范围为0-10，在此降低pv分值比例限制为1-5分
 // 考虑到扩容以及提高资源利用率方面，进行中性的评分
 // 对于申请用量与现存容量差距巨大，则配置文件中选节点策略可以忽略
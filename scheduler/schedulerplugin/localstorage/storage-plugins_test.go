@@ -47,4 +47,33 @@ func TestReasonableScore(t *testing.T) {
 	for _, e := range table {
 		a.Equal(reasonableScore(e.ration), e.result)
 	}
-}
\ No newline at end of file
+}
+
+func TestProfileScore(t *testing.T) {
+	groupIOPS := deviceGroupIOPS(map[string]interface{}{
+		"status": map[string]interface{}{
+			"diskProfiles": []interface{}{
+				map[string]interface{}{"disk": "/dev/sdb", "deviceGroup": "carina-vg-ssd", "randReadIOPS": int64(90000)},
+				map[string]interface{}{"disk": "/dev/sdc", "deviceGroup": "carina-vg-ssd", "randReadIOPS": int64(40000)},
+				map[string]interface{}{"disk": "/dev/nvme0n1", "deviceGroup": "carina-vg-nvme", "randReadIOPS": int64(450000)},
+				map[string]interface{}{"disk": "/dev/sdd", "deviceGroup": "carina-vg-hdd", "randReadIOPS": int64(300)},
+			},
+		},
+	})
+	a := assert.New(t)
+	a.Equal(map[string]int64{"carina-vg-ssd": 40000, "carina-vg-nvme": 450000, "carina-vg-hdd": 300}, groupIOPS)
+
+	table := []struct {
+		group  string
+		result int64
+	}{
+		{group: "carina-vg-ssd", result: 2},
+		{group: "carina-vg-nvme", result: 4},
+		{group: "carina-vg-hdd", result: 0},
+		{group: "carina-vg-unknown", result: 2},
+	}
+	for _, e := range table {
+		a.Equal(e.result, profileScore(groupIOPS, e.group), e.group)
+	}
+	a.Empty(deviceGroupIOPS(map[string]interface{}{}))
+}
@@ -0,0 +1,73 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package localstorage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carina-io/carina-api/api/v1beta1"
+	"github.com/carina-io/carina/scheduler/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// stripeStatus 检查条带卷所在vg是否有足够的pv及每个pv上的剩余空间
+// A striped volume takes an equal share from as many physical volumes as it
+// has stripes, so the total free space of the group is not enough.
+func (ls *LocalStorage) stripeStatus(pvcMap map[string][]*v1.PersistentVolumeClaim, nsr *v1beta1.NodeStorageResource) *framework.Status {
+	for key, pvcs := range pvcMap {
+		if key == undefined {
+			continue
+		}
+		group := strings.TrimPrefix(key, utils.DeviceCapacityKeyPrefix)
+		pvFree := []uint64{}
+		for _, vg := range nsr.Status.VgGroups {
+			if vg.VGName != group {
+				continue
+			}
+			for _, pv := range vg.PVS {
+				pvFree = append(pvFree, pv.PVFree)
+			}
+		}
+
+		for _, pvc := range pvcs {
+			if pvc.Spec.StorageClassName == nil {
+				continue
+			}
+			sc, err := ls.scLister.Get(*pvc.Spec.StorageClassName)
+			if err != nil {
+				return framework.NewStatus(framework.Error, "get sc resource error")
+			}
+			stripes := utils.StripeCount(sc.Parameters)
+			if stripes < 2 {
+				continue
+			}
+			if len(pvFree) < stripes {
+				klog.V(3).Infof("pvc %s/%s needs %d stripes, disk group %s of node %s has %d pvs", pvc.Namespace, pvc.Name, stripes, group, nsr.Name, len(pvFree))
+				return framework.NewStatus(framework.UnschedulableAndUnresolvable, fmt.Sprintf("disk group %s has fewer than %d physical volumes", group, stripes))
+			}
+			request := uint64(pvc.Spec.Resources.Requests.Storage().Value())
+			if capacity := utils.StripedCapacity(pvFree, stripes); request > capacity {
+				klog.V(3).Infof("pvc %s/%s request %d, striped capacity of disk group %s on node %s is %d", pvc.Namespace, pvc.Name, request, group, nsr.Name, capacity)
+				return framework.NewStatus(framework.UnschedulableAndUnresolvable, "node striped storage resource insufficient")
+			}
+		}
+	}
+	return nil
+}
@@ -21,6 +21,8 @@ const (
 	CSIPluginName = "carina.storage.io"
 	// DeviceDiskKey storage class disk group
 	DeviceDiskKey = "carina.storage.io/disk-group-name"
+	// DeviceGroupKey short form of DeviceDiskKey
+	DeviceGroupKey = "carina.storage.io/disk-group"
 	// VolumeDeviceNode pv csi VolumeAttributes
 	VolumeDeviceNode = "carina.storage.io/node"
 	// DeviceCapacityKeyPrefix device plugin
@@ -31,9 +33,25 @@ const (
 	VolumeCacheDiskType   = "carina.storage.io/cache-disk-group-name"
 	// VolumeCacheDiskRatio value: 1-100 Cache Capacity Ratio
 	VolumeCacheDiskRatio = "carina.storage.io/cache-disk-ratio"
+	// VolumeCachePolicy value: writethrough|writeback|writearound
+	VolumeCachePolicy = "carina.storage.io/cache-policy"
 	// DeviceVolumeType type
 	LvmVolumeType = "lvm"
 	RawVolumeType = "raw"
 	//ExclusivityDisk  true or false  is the key indicates that only the disk is used by one pod
 	ExclusivityDisk = "carina.storage.io/exclusively-raw-disk"
+	// VolumeStripes number of physical volumes an lvm volume is striped across
+	VolumeStripes = "carina.storage.io/stripes"
+	// VolumeImportSource pvc annotation, <node>:<host path> the volume is imported from
+	VolumeImportSource = "carina.storage.io/import-source"
+	// VolumeReplicas storageclass parameter, 2 keeps a copy of the volume on a second node
+	VolumeReplicas = "carina.storage.io/replicas"
+	// VolumeReplicaNode pv csi VolumeAttributes of a replicated volume, the node holding the second copy
+	VolumeReplicaNode = "carina.storage.io/replica-node"
+	// VolumeShared pv csi VolumeAttributes of a volume exported over nfs, usable from any node
+	VolumeShared = "carina.storage.io/shared"
+	// AnnSelectedNode is added to a PVC by the scheduler when the volume binding is delayed
+	AnnSelectedNode = "volume.kubernetes.io/selected-node"
+	// ConditionExported snapshot LogicVolume condition type, true once the snapshot is uploaded to its export target
+	ConditionExported = "Exported"
 )
@@ -16,7 +16,11 @@
 
 package utils
 
-import "os"
+import (
+	"os"
+	"sort"
+	"strconv"
+)
 
 func ContainsString(slice []string, s string) bool {
 	for _, item := range slice {
@@ -37,3 +41,55 @@ func Exists(path string) bool {
 	}
 	return true
 }
+
+// DeviceGroupParameter returns the disk group requested by storageclass parameters,
+// carina.storage.io/disk-group-name takes precedence over carina.storage.io/disk-group
+func DeviceGroupParameter(params map[string]string) string {
+	if group := params[DeviceDiskKey]; group != "" {
+		return group
+	}
+	return params[DeviceGroupKey]
+}
+
+// BcacheParameters 合并pvc注解中的bcache参数，pvc注解优先于storageclass参数
+// A pvc of a storageclass without bcache becomes a bcache volume with the cache-disk-group-name and
+// cache-disk-ratio annotations, its backing disk group is the disk group of the pvc or the storageclass.
+func BcacheParameters(params, annotations map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range params {
+		merged[k] = v
+	}
+	for _, key := range []string{VolumeBackendDiskType, VolumeCacheDiskType, VolumeCacheDiskRatio, VolumeCachePolicy} {
+		if v := annotations[key]; v != "" {
+			merged[key] = v
+		}
+	}
+	if merged[VolumeCacheDiskType] == "" || merged[VolumeBackendDiskType] != "" {
+		return merged
+	}
+	if group := annotations[DeviceDiskKey]; group != "" {
+		merged[VolumeBackendDiskType] = group
+	} else if group := DeviceGroupParameter(params); group != "" {
+		merged[VolumeBackendDiskType] = group
+	}
+	return merged
+}
+
+// StripeCount returns the stripes requested by storageclass parameters, 1 when not striped
+func StripeCount(params map[string]string) int {
+	n, err := strconv.Atoi(params[VolumeStripes])
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// StripedCapacity 条带卷可用的最大容量，受第stripes大的pv剩余空间限制
+func StripedCapacity(pvFree []uint64, stripes int) uint64 {
+	if stripes < 1 || len(pvFree) < stripes {
+		return 0
+	}
+	free := append([]uint64{}, pvFree...)
+	sort.Slice(free, func(i, j int) bool { return free[i] > free[j] })
+	return free[stripes-1] * uint64(stripes)
+}
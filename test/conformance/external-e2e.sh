@@ -0,0 +1,21 @@
+#!/bin/bash
+# 运行kubernetes external storage e2e测试，驱动描述由make testdriver生成
+set -e
+
+NC='\e[0m'
+BGREEN='\e[32m'
+
+E2E_FOCUS=${E2E_FOCUS:-External.Storage}
+E2E_SKIP=${E2E_SKIP:-\[Disruptive\]|\[Serial\]}
+E2E_NODES=${E2E_NODES:-4}
+KUBECONFIG=${KUBECONFIG:-${HOME}/.kube/config}
+OUTPUT=$(cd "$(dirname "$0")" && pwd)/_output
+
+echo -e "${BGREEN}Running external storage e2e (FOCUS=${E2E_FOCUS})...${NC}"
+"${OUTPUT}/ginkgo" -p -nodes="${E2E_NODES}" \
+  -focus="${E2E_FOCUS}" \
+  -skip="${E2E_SKIP}" \
+  "${OUTPUT}/e2e.test" -- \
+  -storage.testdriver="${OUTPUT}/testdriver.yaml" \
+  -kubeconfig="${KUBECONFIG}" \
+  -report-dir="${OUTPUT}/report"
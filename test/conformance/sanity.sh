@@ -0,0 +1,31 @@
+#!/bin/bash
+# 在kind节点上对carina-node的csi socket运行csi-sanity
+# carina-node has to run with --standalone so that its socket serves the controller service as well.
+set -e
+
+NC='\e[0m'
+BGREEN='\e[32m'
+
+SANITY_NODE=${SANITY_NODE:-e2e-worker}
+SANITY_SKIP=${SANITY_SKIP:-}
+KUBELET_DIR=${KUBELET_DIR:-/var/lib/kubelet}
+DRIVER_DIR=${DRIVER_DIR:-csi.carina.com}
+OUTPUT=$(dirname "$0")/_output
+
+# staging and target paths live below the plugins directory, carina-node mounts it with Bidirectional propagation
+SANITY_DIR=${KUBELET_DIR}/plugins/csi-sanity
+
+docker cp "${OUTPUT}/csi-sanity" "${SANITY_NODE}:/usr/local/bin/csi-sanity"
+docker cp "$(dirname "$0")/sanity-parameters.yaml" "${SANITY_NODE}:/tmp/sanity-parameters.yaml"
+docker exec "${SANITY_NODE}" mkdir -p "${SANITY_DIR}"
+
+echo -e "${BGREEN}Running csi-sanity on ${SANITY_NODE}...${NC}"
+docker exec "${SANITY_NODE}" csi-sanity \
+  --csi.endpoint="${KUBELET_DIR}/plugins/${DRIVER_DIR}/csi.sock" \
+  --csi.stagingdir="${SANITY_DIR}/staging" \
+  --csi.mountdir="${SANITY_DIR}/target" \
+  --csi.testvolumeparameters=/tmp/sanity-parameters.yaml \
+  --csi.testvolumesize=1073741824 \
+  --csi.testvolumeexpandsize=2147483648 \
+  --ginkgo.skip="${SANITY_SKIP:-^$}" \
+  --ginkgo.v
@@ -0,0 +1,39 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// testdriver 输出kubernetes external storage e2e测试使用的驱动描述，能力取自驱动代码
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/carina-io/carina/pkg/csidriver/driver"
+)
+
+func main() {
+	storageClass := flag.String("storageclass", "storageclass.yaml", "StorageClass manifest the suite provisions with")
+	snapshotClass := flag.String("snapshotclass", "", "Existing VolumeSnapshotClass of carina, snapshot tests are skipped when empty")
+	flag.Parse()
+
+	manifest, err := driver.NewTestDriver(*storageClass, *snapshotClass).Manifest()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	_, _ = os.Stdout.Write(manifest)
+}
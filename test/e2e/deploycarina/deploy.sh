@@ -6,6 +6,14 @@ function install() {
 
   kubectl apply -f crd-logicvolume.yaml
   kubectl apply -f crd-nodestoreresource.yaml
+  kubectl apply -f crd-storagepolicy.yaml
+  kubectl apply -f crd-carinadefaultclass.yaml
+  kubectl apply -f crd-carinaquota.yaml
+  kubectl apply -f crd-rebalance.yaml
+  kubectl apply -f crd-snapshotpolicy.yaml
+  kubectl apply -f crd-volumeoperation.yaml
+  kubectl apply -f crd-volumefreeze.yaml
+  kubectl apply -f crd-volumereplication.yaml
   kubectl apply -f csi-config-map.yaml
   kubectl apply -f csi-controller-psp.yaml
   kubectl apply -f csi-controller-rbac.yaml
@@ -45,6 +53,14 @@ function uninstall() {
     kubectl delete -f crd-logicvolume.yaml
   fi
   kubectl delete -f crd-nodestoreresource.yaml
+  kubectl delete -f crd-storagepolicy.yaml
+  kubectl delete -f crd-carinadefaultclass.yaml
+  kubectl delete -f crd-carinaquota.yaml
+  kubectl delete -f crd-rebalance.yaml
+  kubectl delete -f crd-snapshotpolicy.yaml
+  kubectl delete -f crd-volumeoperation.yaml
+  kubectl delete -f crd-volumefreeze.yaml
+  kubectl delete -f crd-volumereplication.yaml
 
 }
 
@@ -7,10 +7,13 @@ import (
 	"github.com/carina-io/carina/test/e2e/framework"
 	"github.com/onsi/ginkgo"
 	"github.com/onsi/ginkgo/config"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/component-base/logs"
 
 	// tests to run
 	_ "github.com/carina-io/carina/test/e2e/lvm"
+	_ "github.com/carina-io/carina/test/e2e/matrix"
+	_ "github.com/carina-io/carina/test/e2e/perf"
 )
 
 // RunE2ETests checks configuration parameters (specified through flags) and then runs
@@ -23,7 +26,23 @@ func RunE2ETests(t *testing.T) {
 		framework.Logf("Using kubectl path '%s'", framework.KubectlPath)
 	}
 
-	framework.Logf("Starting e2e run %q on Ginkgo node %d", framework.RunID, config.GinkgoConfig.ParallelNode)
-
 	ginkgo.RunSpecs(t, "carina e2e suite")
 }
+
+// 第一个ginkgo节点清理遗留对象，并把运行标识分享给所有并行节点
+var _ = ginkgo.SynchronizedBeforeSuite(func() []byte {
+	if err := framework.SweepOrphans(framework.TestContext.SweepOlderThan); err != nil {
+		framework.Logf("sweeping objects of previous runs: %v", err)
+	}
+	return []byte(framework.RunID)
+}, func(runID []byte) {
+	framework.RunID = types.UID(runID)
+	framework.Logf("Starting e2e run %q on Ginkgo node %d", framework.RunID, config.GinkgoConfig.ParallelNode)
+})
+
+// 每个ginkgo节点写出自己的性能报告
+var _ = ginkgo.AfterSuite(func() {
+	if err := framework.WritePerfReport(framework.TestContext.PerfReport); err != nil {
+		framework.Logf("writing perf report: %v", err)
+	}
+})
@@ -7,6 +7,9 @@ BGREEN='\e[32m'
 SLOW_E2E_THRESHOLD=${SLOW_E2E_THRESHOLD:-5}
 FOCUS=${FOCUS:-.*}
 E2E_NODES=${E2E_NODES:-5}
+E2E_TIMEOUT=${E2E_TIMEOUT:-75m}
+# flags of the test binary, e.g. "-perf -perf-volumes=5"
+E2E_ARGS=${E2E_ARGS:-}
 
 export ACK_GINKGO_RC=true
 ginkgo_args=(
@@ -16,12 +19,13 @@ ginkgo_args=(
   "-progress"
   "-slowSpecThreshold=${SLOW_E2E_THRESHOLD}"
   "-succinct"
-  "-timeout=75m"
+  "-timeout=${E2E_TIMEOUT}"
   "-v"
 )
 
 echo -e "${BGREEN}Running e2e test suite (FOCUS=${FOCUS})...${NC}"
 ginkgo "${ginkgo_args[@]}"               \
   -focus="${FOCUS}"                  \
-  -nodes="${E2E_NODES}" 
+  -nodes="${E2E_NODES}"              \
+  -- ${E2E_ARGS} 
 
@@ -0,0 +1,162 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// NodeRestartTimeout how long a node may take to come back after a reboot or kubelet restart
+	NodeRestartTimeout = 10 * time.Minute
+)
+
+// RestartKubelet restarts kubelet on the node and waits until the node and its carina-node pod are ready again
+func (f *Framework) RestartKubelet(node string) error {
+	if _, err := f.ExecOnNode(node, "systemctl restart kubelet"); err != nil {
+		return err
+	}
+	if err := f.WaitForNodeReady(node, NodeRestartTimeout); err != nil {
+		return err
+	}
+	return f.WaitForCarinaNodePodReady(node, NodeRestartTimeout)
+}
+
+// RebootNode reboots the node and waits until it is ready again with its carina-node pod.
+// The command of TestContext.NodeRebootCommand runs on the test host when set, e.g. "docker restart {node}"
+// for kind, {node} is replaced by the node name; otherwise the node runs "systemctl reboot" itself.
+func (f *Framework) RebootNode(node string) error {
+	before, err := f.KubeClientSet.CoreV1().Nodes().Get(context.TODO(), node, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+
+	if TestContext.NodeRebootCommand != "" {
+		command := strings.ReplaceAll(TestContext.NodeRebootCommand, "{node}", node)
+		out, err := exec.Command("sh", "-c", command).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%q failed: %v, output: %s", command, err, out)
+		}
+	} else {
+		// 重启会断开exec连接，延迟执行后立即返回
+		if _, err := f.ExecOnNode(node, "nohup sh -c 'sleep 2; systemctl reboot' >/dev/null 2>&1 &"); err != nil {
+			return err
+		}
+	}
+	// 调试pod随节点重启退出，下次使用时重新创建
+	delete(f.nodeDebugPods, node)
+
+	err = wait.PollImmediate(Poll, NodeRestartTimeout, func() (bool, error) {
+		n, err := f.KubeClientSet.CoreV1().Nodes().Get(context.TODO(), node, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		return nodeRestarted(before, n, start), nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for node %s to restart: %v", node, err)
+	}
+	Logf("node %s restarted after %s", node, time.Since(start).Round(time.Second))
+
+	if err := f.WaitForNodeReady(node, NodeRestartTimeout); err != nil {
+		return err
+	}
+	return f.WaitForCarinaNodePodReady(node, NodeRestartTimeout)
+}
+
+// nodeRestarted 节点boot id变化，或kubelet重新上报了Ready状态
+func nodeRestarted(before, after *corev1.Node, start time.Time) bool {
+	if after.Status.NodeInfo.BootID != before.Status.NodeInfo.BootID {
+		return true
+	}
+	for _, c := range after.Status.Conditions {
+		if c.Type == corev1.NodeReady && c.LastTransitionTime.Time.After(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitForNodeReady waits until the Ready condition of the node is true
+func (f *Framework) WaitForNodeReady(node string, timeout time.Duration) error {
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		n, err := f.KubeClientSet.CoreV1().Nodes().Get(context.TODO(), node, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		for _, c := range n.Status.Conditions {
+			if c.Type == corev1.NodeReady {
+				return c.Status == corev1.ConditionTrue, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for node %s to be ready: %v", node, err)
+	}
+	return nil
+}
+
+// WaitForCarinaNodePodReady waits until the carina-node pod on the node is running and ready
+func (f *Framework) WaitForCarinaNodePodReady(node string, timeout time.Duration) error {
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pods, err := f.KubeClientSet.CoreV1().Pods(carinaNamespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: carinaNodeLabel,
+			FieldSelector: "spec.nodeName=" + node,
+		})
+		if err != nil {
+			return false, nil
+		}
+		for _, p := range pods.Items {
+			if p.DeletionTimestamp == nil && p.Status.Phase == corev1.PodRunning && podReady(&p) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for carina-node on node %s to be ready: %v", node, err)
+	}
+	return nil
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// DetachLoopDevice detaches a loop device, e.g. /dev/loop2, on the node to simulate the removal of a disk and
+// returns its backing file for ReattachLoopDevice. A loop device that is still open, e.g. by an active
+// logical volume, is only detached by the kernel once it is closed.
+func (f *Framework) DetachLoopDevice(node, device string) (string, error) {
+	out, err := f.ExecOnNode(node, fmt.Sprintf("losetup -n -O BACK-FILE %s", device))
+	if err != nil {
+		return "", err
+	}
+	backingFile := strings.TrimSpace(out)
+	if backingFile == "" {
+		return "", fmt.Errorf("%s on node %s is not a loop device with a backing file", device, node)
+	}
+	if _, err := f.ExecOnNode(node, fmt.Sprintf("losetup -d %s", device)); err != nil {
+		return "", err
+	}
+	Logf("detached %s (%s) on node %s", device, backingFile, node)
+	return backingFile, nil
+}
+
+// ReattachLoopDevice attaches the backing file to the loop device again, as if the disk was plugged back
+func (f *Framework) ReattachLoopDevice(node, device, backingFile string) error {
+	_, err := f.ExecOnNode(node, fmt.Sprintf("losetup %s %s", device, backingFile))
+	return err
+}
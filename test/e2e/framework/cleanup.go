@@ -0,0 +1,52 @@
+package framework
+
+import (
+	"fmt"
+	"sync"
+)
+
+// cleanupAction 一项清理动作
+type cleanupAction struct {
+	description string
+	fn          func() error
+}
+
+// cleanupRegistry 记录spec中创建的资源，AfterEach中按创建的逆序删除
+type cleanupRegistry struct {
+	mu      sync.Mutex
+	actions []cleanupAction
+}
+
+// AddCleanup registers fn to run after the current spec, also when an assertion failed halfway.
+// Cleanups run in reverse order of registration, so a snapshot is removed before the pvc it was taken
+// from and a pvc before its storageclass. A failing cleanup is logged and does not stop the others.
+func (f *Framework) AddCleanup(description string, fn func() error) {
+	f.cleanups.mu.Lock()
+	defer f.cleanups.mu.Unlock()
+	f.cleanups.actions = append(f.cleanups.actions, cleanupAction{description: description, fn: fn})
+}
+
+// RunCleanups runs and forgets the registered cleanups, DestroyEnvironment calls it before the namespace is deleted
+func (f *Framework) RunCleanups() {
+	f.cleanups.mu.Lock()
+	actions := f.cleanups.actions
+	f.cleanups.actions = nil
+	f.cleanups.mu.Unlock()
+
+	for i := len(actions) - 1; i >= 0; i-- {
+		Logf("cleanup: %s", actions[i].description)
+		if err := runCleanup(actions[i].fn); err != nil {
+			Logf("cleanup %s failed: %v", actions[i].description, err)
+		}
+	}
+}
+
+// runCleanup 清理动作中的断言失败或panic不能中断其余清理
+func runCleanup(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
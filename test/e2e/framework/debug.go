@@ -0,0 +1,79 @@
+package framework
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/carina-io/carina/pkg/devicemanager/types"
+	"github.com/carina-io/carina/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	carinaNamespace = "kube-system"
+	carinaNodeLabel = "app=csi-carina-node"
+)
+
+// EnsureDebugToken creates the token secret of the carina-node debug api if it does not exist and returns the token.
+// kubelet syncs the secret into running carina-node pods within a minute.
+func (f *Framework) EnsureDebugToken() string {
+	secrets := f.KubeClientSet.CoreV1().Secrets(carinaNamespace)
+	secret, err := secrets.Get(context.TODO(), utils.DebugTokenSecret, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		secret, err = secrets.Create(context.TODO(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: utils.DebugTokenSecret, Namespace: carinaNamespace},
+			StringData: map[string]string{"token": rand.String(32)},
+		}, metav1.CreateOptions{})
+		assert.Nil(ginkgo.GinkgoT(), err, "creating debug token secret")
+		return secret.StringData["token"]
+	}
+	assert.Nil(ginkgo.GinkgoT(), err, "getting debug token secret")
+	return string(secret.Data["token"])
+}
+
+// GetNodeDebugState reads the live lvm, bcache, mount and throttle state of a node through the apiserver pod proxy
+func (f *Framework) GetNodeDebugState(node string) *types.NodeDebugState {
+	token := f.EnsureDebugToken()
+	pods, err := f.KubeClientSet.CoreV1().Pods(carinaNamespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: carinaNodeLabel,
+		FieldSelector: "spec.nodeName=" + node,
+	})
+	assert.Nil(ginkgo.GinkgoT(), err, "listing carina-node pods")
+	assert.NotEmpty(ginkgo.GinkgoT(), pods.Items, "expected a carina-node pod on node %s", node)
+
+	pod := pods.Items[0]
+	port := int32(0)
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == "http" {
+				port = p.ContainerPort
+			}
+		}
+	}
+	var body []byte
+	// 新建的secret同步到pod之前接口返回404
+	err = wait.PollImmediate(5*time.Second, 2*time.Minute, func() (bool, error) {
+		body, err = f.KubeClientSet.CoreV1().RESTClient().Get().
+			Namespace(carinaNamespace).
+			Resource("pods").
+			Name(fmt.Sprintf("%s:%d", pod.Name, port)).
+			SubResource("proxy").
+			Suffix("debug/state").
+			SetHeader(utils.DebugTokenHeader, token).
+			DoRaw(context.TODO())
+		return err == nil, nil
+	})
+	assert.Nil(ginkgo.GinkgoT(), err, "getting debug state of node %s", node)
+
+	state := &types.NodeDebugState{}
+	assert.Nil(ginkgo.GinkgoT(), json.Unmarshal(body, state), "decoding debug state")
+	return state
+}
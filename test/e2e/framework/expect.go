@@ -19,6 +19,11 @@ func ExpectError(err error, explain ...interface{}) {
 	gomega.ExpectWithOffset(1, err).To(gomega.HaveOccurred(), explain...)
 }
 
+// ExpectNoError expects no error happens, otherwise an exception raises
+func ExpectNoError(err error, explain ...interface{}) {
+	gomega.ExpectWithOffset(1, err).NotTo(gomega.HaveOccurred(), explain...)
+}
+
 // ExpectConsistOf expects actual contains precisely the extra elements.  The ordering of the elements does not matter.
 func ExpectConsistOf(actual interface{}, extra interface{}, explain ...interface{}) {
 	gomega.ExpectWithOffset(1, actual).To(gomega.ConsistOf(extra), explain...)
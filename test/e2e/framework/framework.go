@@ -10,6 +10,7 @@ import (
 	apiextcs "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
 )
@@ -25,7 +26,12 @@ type Framework struct {
 	KubeClientSet          kubernetes.Interface
 	KubeConfig             *restclient.Config
 	APIExtensionsClientSet apiextcs.Interface
+	DynamicClient          dynamic.Interface
 	Namespace              string
+
+	cleanups cleanupRegistry
+	// nodeDebugPods 节点名到其调试pod
+	nodeDebugPods map[string]string
 }
 
 // NewDefaultFramework makes a new framework and sets up a BeforeEach/AfterEach for
@@ -71,14 +77,23 @@ func (f *Framework) CreateEnvironment() {
 		f.KubeClientSet, err = kubernetes.NewForConfig(f.KubeConfig)
 		assert.Nil(ginkgo.GinkgoT(), err, "creating a kubernetes client")
 
-		// TODO 检查Carina相关CRD是否安装
+		f.DynamicClient, err = dynamic.NewForConfig(f.KubeConfig)
+		assert.Nil(ginkgo.GinkgoT(), err, "creating a dynamic client")
+
+		f.APIExtensionsClientSet, err = apiextcs.NewForConfig(f.KubeConfig)
+		assert.Nil(ginkgo.GinkgoT(), err, "creating an apiextensions client")
 	}
+	f.preflight()
 
 	f.Namespace, err = CreateKubeNamespace(f.BaseName, f.KubeClientSet)
 	assert.Nil(ginkgo.GinkgoT(), err, "creating namespace")
 }
 
 func (f *Framework) DestroyEnvironment() {
+	if ginkgo.CurrentGinkgoTestDescription().Failed {
+		f.LogNamespaceDiagnostics(f.Namespace)
+	}
+	f.RunCleanups()
 	go func() {
 		defer ginkgo.GinkgoRecover()
 		err := DeleteKubeNamespace(f.KubeClientSet, f.Namespace)
@@ -103,9 +118,12 @@ func CrainaDescribe(text string, body func()) bool {
 
 // EnsurePvc creates an pvc object and returns it, throws error if it already exists.
 func (f *Framework) EnsurePvc(pvc *corev1.PersistentVolumeClaim) *corev1.PersistentVolumeClaim {
-
+	labelRun(pvc)
 	err := createPvcWithRetries(f.KubeClientSet, pvc.Namespace, pvc)
 	assert.Nil(ginkgo.GinkgoT(), err, "creating pvc")
+	f.AddCleanup(fmt.Sprintf("delete pvc %s/%s", pvc.Namespace, pvc.Name), func() error {
+		return f.deletePvcAndWait(pvc.Namespace, pvc.Name)
+	})
 
 	pvcResult := f.GetPvc(pvc.Namespace, pvc.Name)
 	return pvcResult
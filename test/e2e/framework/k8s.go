@@ -61,6 +61,28 @@ func (f *Framework) GetPvc(namespace string, name string) *corev1.PersistentVolu
 	return pvc
 }
 
+func (f *Framework) GetPv(name string) *corev1.PersistentVolume {
+	pv, err := f.KubeClientSet.CoreV1().PersistentVolumes().Get(context.TODO(), name, metav1.GetOptions{})
+	assert.Nil(ginkgo.GinkgoT(), err, "getting pv")
+	assert.NotNil(ginkgo.GinkgoT(), pv, "expected a pv but none returned")
+	return pv
+}
+
+// deletePvcAndWait deletes a pvc and waits until it is gone, so that its volume is deleted before the storageclass
+func (f *Framework) deletePvcAndWait(namespace, name string) error {
+	err := f.KubeClientSet.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return wait.PollImmediate(Poll, DefaultTimeout, func() (bool, error) {
+		_, err := f.KubeClientSet.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		return k8sErrors.IsNotFound(err), nil
+	})
+}
+
 // GetConfig creates a *rest.Config for talking to a Kubernetes API server.
 // If --kubeconfig is set, will use the kubeconfig file at that location.  Otherwise will assume running
 // in cluster and use the cluster provided kubeconfig.
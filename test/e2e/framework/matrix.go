@@ -0,0 +1,134 @@
+package framework
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/onsi/ginkgo/extensions/table"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+// MatrixValue one value of a matrix dimension. Its storageclass parameters are merged in dimension order,
+// an empty parameter value removes a parameter set by an earlier dimension.
+type MatrixValue struct {
+	Name       string
+	Params     map[string]string
+	VolumeMode corev1.PersistentVolumeMode
+}
+
+// MatrixDimension a storageclass parameter or volume property the spec runs with each value of
+type MatrixDimension struct {
+	Name   string
+	Values []MatrixValue
+}
+
+// Matrix the cross product of its dimensions, Exclude drops combinations carina does not support
+type Matrix struct {
+	Dimensions []MatrixDimension
+	Exclude    func(c MatrixCase) bool
+}
+
+// MatrixCase one combination of the matrix
+type MatrixCase struct {
+	// Name e.g. "fstype=xfs,cache=none,provisioning=thin,mode=fs"
+	Name string
+	// Values dimension name to the name of its value
+	Values     map[string]string
+	Params     map[string]string
+	VolumeMode corev1.PersistentVolumeMode
+}
+
+// Block whether the case uses block volumes
+func (c MatrixCase) Block() bool {
+	return c.VolumeMode == corev1.PersistentVolumeBlock
+}
+
+// StorageClass returns a storageclass with a random name and the parameters of the case
+func (c MatrixCase) StorageClass() *storagev1.StorageClass {
+	del := corev1.PersistentVolumeReclaimDelete
+	waitForFirstConsumer := storagev1.VolumeBindingWaitForFirstConsumer
+	t := true
+	params := map[string]string{}
+	for k, v := range c.Params {
+		params[k] = v
+	}
+	return &storagev1.StorageClass{
+		ObjectMeta:           metav1.ObjectMeta{Name: "carina-matrix-" + rand.String(5)},
+		Provisioner:          utils.CSIPluginName,
+		Parameters:           params,
+		ReclaimPolicy:        &del,
+		AllowVolumeExpansion: &t,
+		VolumeBindingMode:    &waitForFirstConsumer,
+	}
+}
+
+// Cases expands the matrix, the first dimension varies slowest
+func (m Matrix) Cases() []MatrixCase {
+	cases := []MatrixCase{{Values: map[string]string{}, Params: map[string]string{}, VolumeMode: corev1.PersistentVolumeFilesystem}}
+	for _, d := range m.Dimensions {
+		next := []MatrixCase{}
+		for _, c := range cases {
+			for _, v := range d.Values {
+				next = append(next, c.with(d.Name, v))
+			}
+		}
+		cases = next
+	}
+
+	result := []MatrixCase{}
+	for _, c := range cases {
+		for k, v := range c.Params {
+			if v == "" {
+				delete(c.Params, k)
+			}
+		}
+		names := []string{}
+		for _, d := range m.Dimensions {
+			names = append(names, fmt.Sprintf("%s=%s", d.Name, c.Values[d.Name]))
+		}
+		c.Name = strings.Join(names, ",")
+		if m.Exclude != nil && m.Exclude(c) {
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+func (c MatrixCase) with(dimension string, v MatrixValue) MatrixCase {
+	n := MatrixCase{Values: map[string]string{}, Params: map[string]string{}, VolumeMode: c.VolumeMode}
+	for k, val := range c.Values {
+		n.Values[k] = val
+	}
+	n.Values[dimension] = v.Name
+	for k, val := range c.Params {
+		n.Params[k] = val
+	}
+	for k, val := range v.Params {
+		n.Params[k] = val
+	}
+	if v.VolumeMode != "" {
+		n.VolumeMode = v.VolumeMode
+	}
+	return n
+}
+
+// DescribeMatrix generates one ginkgo entry per case of the matrix, each running body in its own namespace.
+// A new parameter gets coverage by adding a value or a dimension to the matrix.
+func DescribeMatrix(text string, m Matrix, body func(f *Framework, c MatrixCase)) bool {
+	return CrainaDescribe(text, func() {
+		f := NewDefaultFramework("matrix")
+		entries := []table.TableEntry{}
+		for _, c := range m.Cases() {
+			entries = append(entries, table.Entry(c.Name, c))
+		}
+		table.DescribeTable("storageclass", func(c MatrixCase) {
+			Logf("matrix case %s, parameters %v, volume mode %s", c.Name, c.Params, c.VolumeMode)
+			body(f, c)
+		}, entries...)
+	})
+}
@@ -0,0 +1,140 @@
+package framework
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+var (
+	// NodeDebugImage image of the node debug pods, it needs sh and nsenter
+	NodeDebugImage = "busybox:1.35"
+)
+
+// blkioThrottleFiles carina-node写入的节点级blkio限速文件
+var blkioThrottleFiles = []string{
+	"blkio.throttle.read_bps_device",
+	"blkio.throttle.write_bps_device",
+	"blkio.throttle.read_iops_device",
+	"blkio.throttle.write_iops_device",
+}
+
+// newNodeDebugPod 特权pod，通过nsenter进入节点的命名空间执行命令
+func newNodeDebugPod(namespace, node string) *corev1.Pod {
+	privileged := true
+	grace := int64(0)
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "node-debug-" + rand.String(5),
+			Namespace: namespace,
+			Labels:    map[string]string{"app": "carina-e2e-node-debug"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:                      node,
+			HostPID:                       true,
+			HostNetwork:                   true,
+			RestartPolicy:                 corev1.RestartPolicyNever,
+			TerminationGracePeriodSeconds: &grace,
+			Tolerations:                   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Containers: []corev1.Container{{
+				Name:            "debug",
+				Image:           NodeDebugImage,
+				Command:         []string{"sh", "-c", "trap exit TERM; while true; do sleep 1; done"},
+				SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+			}},
+		},
+	}
+}
+
+// nodeDebugPod returns the debug pod of the node, it is created on first use and deleted after the spec
+func (f *Framework) nodeDebugPod(node string) string {
+	if name, ok := f.nodeDebugPods[node]; ok {
+		return name
+	}
+	pod := f.EnsurePod(newNodeDebugPod(f.Namespace, node))
+	err := f.WaitForPodRunning(pod.Namespace, pod.Name, DefaultTimeout)
+	assert.Nil(ginkgo.GinkgoT(), err, "starting debug pod on node %s", node)
+
+	if f.nodeDebugPods == nil {
+		f.nodeDebugPods = map[string]string{}
+	}
+	f.nodeDebugPods[node] = pod.Name
+	f.AddCleanup(fmt.Sprintf("forget debug pod of node %s", node), func() error {
+		delete(f.nodeDebugPods, node)
+		return nil
+	})
+	return pod.Name
+}
+
+// ExecOnNode runs a shell command in the mount, uts, ipc, network and pid namespaces of the node and returns its stdout
+func (f *Framework) ExecOnNode(node, command string) (string, error) {
+	stdout, stderr, err := f.ExecInPod(f.Namespace, f.nodeDebugPod(node), "", "nsenter", "-t", "1", "-m", "-u", "-i", "-n", "-p", "--", "sh", "-c", command)
+	if err != nil {
+		return stdout, fmt.Errorf("%q on node %s failed: %v, stderr: %s", command, node, err, stderr)
+	}
+	return stdout, nil
+}
+
+// NodeLVs returns the names of the logical volumes of the volume group on the node
+func (f *Framework) NodeLVs(node, vg string) ([]string, error) {
+	out, err := f.ExecOnNode(node, fmt.Sprintf("lvs --noheadings -o lv_name %s", vg))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+// ExpectLVExists expects the logical volume lv in the volume group vg on the node
+func (f *Framework) ExpectLVExists(node, vg, lv string) {
+	lvs, err := f.NodeLVs(node, vg)
+	gomega.ExpectWithOffset(1, err).NotTo(gomega.HaveOccurred())
+	gomega.ExpectWithOffset(1, lvs).To(gomega.ContainElement(lv), "lv %s/%s on node %s", vg, lv, node)
+}
+
+// ExpectLVNotExists expects no logical volume lv in the volume group vg on the node
+func (f *Framework) ExpectLVNotExists(node, vg, lv string) {
+	lvs, err := f.NodeLVs(node, vg)
+	gomega.ExpectWithOffset(1, err).NotTo(gomega.HaveOccurred())
+	gomega.ExpectWithOffset(1, lvs).NotTo(gomega.ContainElement(lv), "lv %s/%s on node %s", vg, lv, node)
+}
+
+// NodeThrottles returns the blkio throttle limits of the device (major:minor) on the node, keyed by throttle file
+func (f *Framework) NodeThrottles(node, device string) (map[string]int64, error) {
+	limits := map[string]int64{}
+	for _, file := range blkioThrottleFiles {
+		out, err := f.ExecOnNode(node, fmt.Sprintf("cat /sys/fs/cgroup/blkio/%s", file))
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 || fields[0] != device {
+				continue
+			}
+			limit, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parse %s of %s: %v", file, device, err)
+			}
+			limits[file] = limit
+		}
+	}
+	return limits, nil
+}
+
+// ExpectThrottleApplied expects limit in at least one blkio throttle file of the device (major:minor) on the node
+func (f *Framework) ExpectThrottleApplied(node, device string, limit int64) {
+	limits, err := f.NodeThrottles(node, device)
+	gomega.ExpectWithOffset(1, err).NotTo(gomega.HaveOccurred())
+	values := []int64{}
+	for _, v := range limits {
+		values = append(values, v)
+	}
+	gomega.ExpectWithOffset(1, values).To(gomega.ContainElement(limit), "blkio throttle of %s on node %s: %v", device, node, limits)
+}
@@ -0,0 +1,276 @@
+package framework
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/onsi/ginkgo/config"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	// FioImage image of the pods the perf specs run fio in, it needs sh and fio
+	FioImage = "nixery.dev/shell/fio"
+)
+
+// FioProfile a fio job the perf specs run on every volume
+type FioProfile struct {
+	Name      string
+	RW        string
+	BlockSize string
+	IODepth   int
+	// RWMixRead percentage of reads of a randrw job
+	RWMixRead int
+}
+
+// DefaultFioProfiles 覆盖小块随机读写的iops、延迟和大块顺序读写的带宽
+var DefaultFioProfiles = []FioProfile{
+	{Name: "randread-4k", RW: "randread", BlockSize: "4k", IODepth: 32},
+	{Name: "randwrite-4k", RW: "randwrite", BlockSize: "4k", IODepth: 32},
+	{Name: "randrw-4k-70", RW: "randrw", BlockSize: "4k", IODepth: 32, RWMixRead: 70},
+	{Name: "seqread-1m", RW: "read", BlockSize: "1m", IODepth: 8},
+	{Name: "seqwrite-1m", RW: "write", BlockSize: "1m", IODepth: 8},
+}
+
+// Command returns the fio command line of the profile on path, the output is fio json
+func (p FioProfile) Command(path, size string, runtime time.Duration) string {
+	cmd := fmt.Sprintf("fio --name=%s --filename=%s --rw=%s --bs=%s --size=%s --direct=1 --ioengine=libaio --iodepth=%d --time_based --runtime=%d --group_reporting --output-format=json",
+		p.Name, path, p.RW, p.BlockSize, size, p.IODepth, int(runtime.Seconds()))
+	if p.RWMixRead > 0 {
+		cmd += fmt.Sprintf(" --rwmixread=%d", p.RWMixRead)
+	}
+	return cmd
+}
+
+// FioStats the result of one direction of a fio job, latencies are in microseconds
+type FioStats struct {
+	IOPS    float64 `json:"iops"`
+	BWKiB   int64   `json:"bwKiB"`
+	LatMean float64 `json:"latMeanUs"`
+	LatP99  float64 `json:"latP99Us"`
+}
+
+// FioResult the result of a profile on one volume
+type FioResult struct {
+	DiskGroup string    `json:"diskGroup"`
+	Profile   string    `json:"profile"`
+	Volume    string    `json:"volume"`
+	Node      string    `json:"node"`
+	Round     int       `json:"round"`
+	Read      FioStats  `json:"read"`
+	Write     FioStats  `json:"write"`
+	Time      time.Time `json:"time"`
+}
+
+type fioOutput struct {
+	Jobs []struct {
+		Error int          `json:"error"`
+		Read  fioDirection `json:"read"`
+		Write fioDirection `json:"write"`
+	} `json:"jobs"`
+}
+
+type fioDirection struct {
+	IOPS  float64 `json:"iops"`
+	BW    int64   `json:"bw"`
+	LatNs struct {
+		Mean float64 `json:"mean"`
+	} `json:"lat_ns"`
+	ClatNs struct {
+		Percentile map[string]float64 `json:"percentile"`
+	} `json:"clat_ns"`
+}
+
+func (d fioDirection) stats() FioStats {
+	return FioStats{
+		IOPS:    d.IOPS,
+		BWKiB:   d.BW,
+		LatMean: d.LatNs.Mean / 1000,
+		LatP99:  d.ClatNs.Percentile["99.000000"] / 1000,
+	}
+}
+
+// ParseFioOutput parses the json output of a fio job run with --group_reporting
+func ParseFioOutput(out []byte) (FioStats, FioStats, error) {
+	o := fioOutput{}
+	if err := json.Unmarshal(out, &o); err != nil {
+		return FioStats{}, FioStats{}, fmt.Errorf("parse fio output: %v", err)
+	}
+	if len(o.Jobs) == 0 {
+		return FioStats{}, FioStats{}, fmt.Errorf("fio output has no jobs")
+	}
+	if o.Jobs[0].Error != 0 {
+		return FioStats{}, FioStats{}, fmt.Errorf("fio job failed with error %d", o.Jobs[0].Error)
+	}
+	return o.Jobs[0].Read.stats(), o.Jobs[0].Write.stats(), nil
+}
+
+// RunFio runs the profile in the pod on path and returns its result
+func (f *Framework) RunFio(namespace, name, path string, p FioProfile) (FioResult, error) {
+	size, err := resource.ParseQuantity(TestContext.PerfFileSize)
+	if err != nil {
+		return FioResult{}, err
+	}
+	out, err := f.ExecShellInPod(namespace, name, p.Command(path, strconv.FormatInt(size.Value(), 10), TestContext.PerfRuntime))
+	if err != nil {
+		return FioResult{}, err
+	}
+	read, write, err := ParseFioOutput([]byte(out))
+	if err != nil {
+		return FioResult{}, err
+	}
+	return FioResult{Profile: p.Name, Volume: name, Read: read, Write: write, Time: time.Now()}, nil
+}
+
+// PerfSummary the aggregate of a profile on a disk group. IOPS and bandwidth are the sum over the volumes
+// that ran concurrently, averaged over the rounds, MinIOPS is the total of the worst round, latencies are averaged and
+// the p99 latency is the worst of all volumes.
+type PerfSummary struct {
+	DiskGroup string   `json:"diskGroup"`
+	Profile   string   `json:"profile"`
+	Volumes   int      `json:"volumes"`
+	Rounds    int      `json:"rounds"`
+	Read      FioStats `json:"read"`
+	Write     FioStats `json:"write"`
+	MinIOPS   float64  `json:"minIOPS"`
+}
+
+// PerfReport collects the fio results of the perf specs of a ginkgo node
+type PerfReport struct {
+	mu      sync.Mutex
+	Results []FioResult `json:"results"`
+}
+
+// Perf the report of this ginkgo node, written by WritePerfReport after the suite
+var Perf = &PerfReport{}
+
+// Add records a result
+func (r *PerfReport) Add(result FioResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Results = append(r.Results, result)
+}
+
+// Summaries aggregates the results per disk group and profile
+func (r *PerfReport) Summaries() []PerfSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type key struct {
+		group, profile string
+	}
+	type round struct {
+		volumes     int
+		read, write FioStats
+	}
+	rounds := map[key]map[int]*round{}
+	for _, res := range r.Results {
+		k := key{res.DiskGroup, res.Profile}
+		if rounds[k] == nil {
+			rounds[k] = map[int]*round{}
+		}
+		rd := rounds[k][res.Round]
+		if rd == nil {
+			rd = &round{}
+			rounds[k][res.Round] = rd
+		}
+		rd.volumes++
+		addStats(&rd.read, res.Read)
+		addStats(&rd.write, res.Write)
+	}
+
+	summaries := []PerfSummary{}
+	for k, rds := range rounds {
+		s := PerfSummary{DiskGroup: k.group, Profile: k.profile, Rounds: len(rds), MinIOPS: -1}
+		samples := 0
+		for _, rd := range rds {
+			if rd.volumes > s.Volumes {
+				s.Volumes = rd.volumes
+			}
+			samples += rd.volumes
+			addStats(&s.Read, rd.read)
+			addStats(&s.Write, rd.write)
+			if total := rd.read.IOPS + rd.write.IOPS; s.MinIOPS < 0 || total < s.MinIOPS {
+				s.MinIOPS = total
+			}
+		}
+		n := float64(len(rds))
+		s.Read.IOPS, s.Write.IOPS = s.Read.IOPS/n, s.Write.IOPS/n
+		s.Read.BWKiB, s.Write.BWKiB = s.Read.BWKiB/int64(len(rds)), s.Write.BWKiB/int64(len(rds))
+		s.Read.LatMean, s.Write.LatMean = s.Read.LatMean/float64(samples), s.Write.LatMean/float64(samples)
+		summaries = append(summaries, s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].DiskGroup != summaries[j].DiskGroup {
+			return summaries[i].DiskGroup < summaries[j].DiskGroup
+		}
+		return summaries[i].Profile < summaries[j].Profile
+	})
+	return summaries
+}
+
+// addStats 累加iops、带宽和平均延迟，p99取最大值
+func addStats(sum *FioStats, s FioStats) {
+	sum.IOPS += s.IOPS
+	sum.BWKiB += s.BWKiB
+	sum.LatMean += s.LatMean
+	if s.LatP99 > sum.LatP99 {
+		sum.LatP99 = s.LatP99
+	}
+}
+
+// WritePerfReport writes the summaries and results to <prefix>.json and the summaries to <prefix>.csv,
+// the files of parallel ginkgo nodes are suffixed with the node number. Nothing is written without results.
+func WritePerfReport(prefix string) error {
+	if prefix == "" || len(Perf.Results) == 0 {
+		return nil
+	}
+	if config.GinkgoConfig.ParallelTotal > 1 {
+		prefix = fmt.Sprintf("%s-%d", prefix, config.GinkgoConfig.ParallelNode)
+	}
+	summaries := Perf.Summaries()
+
+	body, err := json.MarshalIndent(struct {
+		RunID     string        `json:"runID"`
+		Summaries []PerfSummary `json:"summaries"`
+		Results   []FioResult   `json:"results"`
+	}{string(RunID), summaries, Perf.Results}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(prefix+".json", body, 0644); err != nil {
+		return err
+	}
+
+	file, err := os.Create(prefix + ".csv")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	_ = w.Write([]string{"disk_group", "profile", "volumes", "rounds",
+		"read_iops", "read_bw_kib", "read_lat_mean_us", "read_lat_p99_us",
+		"write_iops", "write_bw_kib", "write_lat_mean_us", "write_lat_p99_us", "min_iops"})
+	for _, s := range summaries {
+		_ = w.Write([]string{s.DiskGroup, s.Profile, strconv.Itoa(s.Volumes), strconv.Itoa(s.Rounds),
+			formatFloat(s.Read.IOPS), strconv.FormatInt(s.Read.BWKiB, 10), formatFloat(s.Read.LatMean), formatFloat(s.Read.LatP99),
+			formatFloat(s.Write.IOPS), strconv.FormatInt(s.Write.BWKiB, 10), formatFloat(s.Write.LatMean), formatFloat(s.Write.LatP99),
+			formatFloat(s.MinIOPS)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	Logf("perf report written to %s.json and %s.csv", prefix, prefix)
+	return nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
@@ -0,0 +1,273 @@
+package framework
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+var (
+	// PodImage image of the pods created by NewPodWithPvc, it needs sh and dd
+	PodImage = "busybox:1.35"
+)
+
+const (
+	// PodMountPath where NewPodWithPvc mounts a filesystem pvc
+	PodMountPath = "/data"
+	// PodDevicePath where NewPodWithPvc maps a block pvc
+	PodDevicePath = "/dev/xvda"
+)
+
+// NewPodWithPvc returns a pod that sleeps with the pvc mounted at PodMountPath, or mapped at PodDevicePath if block is true
+func NewPodWithPvc(namespace, name, pvcName string, block bool) *corev1.Pod {
+	grace := int64(0)
+	container := corev1.Container{
+		Name:    "main",
+		Image:   PodImage,
+		Command: []string{"sh", "-c", "trap exit TERM; while true; do sleep 1; done"},
+	}
+	if block {
+		container.VolumeDevices = []corev1.VolumeDevice{{Name: "data", DevicePath: PodDevicePath}}
+	} else {
+		container.VolumeMounts = []corev1.VolumeMount{{Name: "data", MountPath: PodMountPath}}
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: corev1.PodSpec{
+			Containers:                    []corev1.Container{container},
+			RestartPolicy:                 corev1.RestartPolicyNever,
+			TerminationGracePeriodSeconds: &grace,
+			Volumes: []corev1.Volume{{
+				Name: "data",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName},
+				},
+			}},
+		},
+	}
+}
+
+// EnsurePod creates a pod object and returns it, throws error if it already exists.
+func (f *Framework) EnsurePod(pod *corev1.Pod) *corev1.Pod {
+	labelRun(pod)
+	err := createPodWithRetries(f.KubeClientSet, pod.Namespace, pod)
+	assert.Nil(ginkgo.GinkgoT(), err, "creating pod")
+	f.AddCleanup(fmt.Sprintf("delete pod %s/%s", pod.Namespace, pod.Name), func() error {
+		return f.deletePodAndWait(pod.Namespace, pod.Name)
+	})
+
+	return f.GetPod(pod.Namespace, pod.Name)
+}
+
+func createPodWithRetries(c kubernetes.Interface, namespace string, obj *corev1.Pod) error {
+	if obj == nil {
+		return fmt.Errorf("object provided to create is empty")
+	}
+	createFunc := func() (bool, error) {
+		_, err := c.CoreV1().Pods(namespace).Create(context.TODO(), obj, metav1.CreateOptions{})
+		if err == nil {
+			return true, nil
+		}
+		if k8sErrors.IsAlreadyExists(err) {
+			return false, err
+		}
+		if isRetryableAPIError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to create object with non-retriable error: %v", err)
+	}
+
+	return retryWithExponentialBackOff(createFunc)
+}
+
+func (f *Framework) GetPod(namespace string, name string) *corev1.Pod {
+	pod, err := f.KubeClientSet.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	assert.Nil(ginkgo.GinkgoT(), err, "getting pod")
+	assert.NotNil(ginkgo.GinkgoT(), pod, "expected a pod but none returned")
+	return pod
+}
+
+// WaitForPodRunning waits until the pod is Running, a pod that ends up Failed or Succeeded fails at once.
+// The logs and events of the pod are written to the ginkgo output when it does not get to Running.
+func (f *Framework) WaitForPodRunning(namespace, name string, timeout time.Duration) error {
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		pod, err := f.KubeClientSet.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			if isRetryableAPIError(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		switch pod.Status.Phase {
+		case corev1.PodRunning:
+			return true, nil
+		case corev1.PodFailed, corev1.PodSucceeded:
+			return false, fmt.Errorf("pod %s/%s is %s: %s", namespace, name, pod.Status.Phase, pod.Status.Message)
+		}
+		return false, nil
+	})
+	if err != nil {
+		f.LogPodDiagnostics(namespace, name)
+		return fmt.Errorf("waiting for pod %s/%s to be running: %v", namespace, name, err)
+	}
+	return nil
+}
+
+// DeletePod deletes a pod and waits until it is gone, so its volumes are unpublished
+func (f *Framework) DeletePod(namespace, name string) {
+	err := f.deletePodAndWait(namespace, name)
+	assert.Nil(ginkgo.GinkgoT(), err, "deleting pod %s/%s", namespace, name)
+}
+
+func (f *Framework) deletePodAndWait(namespace, name string) error {
+	grace := int64(0)
+	err := f.KubeClientSet.CoreV1().Pods(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{GracePeriodSeconds: &grace})
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return wait.PollImmediate(Poll, DefaultTimeout, func() (bool, error) {
+		_, err := f.KubeClientSet.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		return k8sErrors.IsNotFound(err), nil
+	})
+}
+
+// ExecInPod runs a command in a container of the pod and returns its stdout and stderr.
+// An empty container name means the first container.
+func (f *Framework) ExecInPod(namespace, name, container string, command ...string) (string, string, error) {
+	if container == "" {
+		container = f.GetPod(namespace, name).Spec.Containers[0].Name
+	}
+	req := f.KubeClientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(f.KubeConfig, "POST", req.URL())
+	if err != nil {
+		return "", "", err
+	}
+	var stdout, stderr bytes.Buffer
+	err = exec.Stream(remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr})
+	Logf("exec %v in %s/%s: stdout %q, stderr %q", command, namespace, name, stdout.String(), stderr.String())
+	return stdout.String(), stderr.String(), err
+}
+
+// ExecShellInPod runs a shell command in the first container of the pod and returns its stdout
+func (f *Framework) ExecShellInPod(namespace, name, command string) (string, error) {
+	stdout, stderr, err := f.ExecInPod(namespace, name, "", "sh", "-c", command)
+	if err != nil {
+		return stdout, fmt.Errorf("%q failed: %v, stderr: %s", command, err, stderr)
+	}
+	return stdout, nil
+}
+
+// DdWrite writes mib MiB of random data to path in the pod with direct io and returns its md5sum,
+// path is a file under PodMountPath or PodDevicePath.
+func (f *Framework) DdWrite(namespace, name, path string, mib int) (string, error) {
+	_, err := f.ExecShellInPod(namespace, name, fmt.Sprintf("dd if=/dev/urandom of=%s bs=1M count=%d oflag=direct conv=fsync", path, mib))
+	if err != nil {
+		return "", err
+	}
+	return f.Md5sum(namespace, name, path, mib)
+}
+
+// Md5sum returns the md5sum of the first mib MiB of path in the pod
+func (f *Framework) Md5sum(namespace, name, path string, mib int) (string, error) {
+	out, err := f.ExecShellInPod(namespace, name, fmt.Sprintf("dd if=%s bs=1M count=%d iflag=direct 2>/dev/null | md5sum", path, mib))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty md5sum output")
+	}
+	return fields[0], nil
+}
+
+// FioCommand returns a fio command line for a short random read/write job on path,
+// the image of the pod has to provide fio.
+func FioCommand(path, rw string, size string, runtime time.Duration) string {
+	return fmt.Sprintf("fio --name=e2e --filename=%s --rw=%s --bs=4k --size=%s --direct=1 --ioengine=libaio --iodepth=16 --time_based --runtime=%d --output-format=json",
+		path, rw, size, int(runtime.Seconds()))
+}
+
+// PodLogs returns the logs of a container of the pod, of the previous instance too if it restarted
+func (f *Framework) PodLogs(namespace, name, container string) string {
+	logs := ""
+	for _, previous := range []bool{true, false} {
+		body, err := f.KubeClientSet.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{Container: container, Previous: previous}).DoRaw(context.TODO())
+		if err != nil {
+			continue
+		}
+		logs += string(body)
+	}
+	return logs
+}
+
+// LogPodDiagnostics writes the status, the container logs and the events of a pod to the ginkgo output
+func (f *Framework) LogPodDiagnostics(namespace, name string) {
+	pod, err := f.KubeClientSet.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		Logf("get pod %s/%s: %v", namespace, name, err)
+		return
+	}
+	Logf("pod %s/%s on node %q is %s, conditions %+v", namespace, name, pod.Spec.NodeName, pod.Status.Phase, pod.Status.Conditions)
+	for _, c := range pod.Spec.Containers {
+		Logf("logs of %s/%s container %s:\n%s", namespace, name, c.Name, f.PodLogs(namespace, name, c.Name))
+	}
+	events, err := f.KubeClientSet.CoreV1().Events(namespace).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=Pod,involvedObject.name=" + name,
+	})
+	if err != nil {
+		return
+	}
+	for _, e := range events.Items {
+		Logf("event %s/%s %s %s: %s", namespace, name, e.Type, e.Reason, e.Message)
+	}
+}
+
+// LogNamespaceDiagnostics writes the diagnostics of every pod of the namespace and the events of its pvcs
+func (f *Framework) LogNamespaceDiagnostics(namespace string) {
+	pods, err := f.KubeClientSet.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		Logf("list pods of %s: %v", namespace, err)
+		return
+	}
+	for _, p := range pods.Items {
+		f.LogPodDiagnostics(namespace, p.Name)
+	}
+	events, err := f.KubeClientSet.CoreV1().Events(namespace).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=PersistentVolumeClaim",
+	})
+	if err != nil {
+		return
+	}
+	for _, e := range events.Items {
+		Logf("event pvc %s/%s %s %s: %s", namespace, e.InvolvedObject.Name, e.Type, e.Reason, e.Message)
+	}
+}
@@ -0,0 +1,132 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	carinaControllerLabel = "app=csi-carina-provisioner"
+	// carinaReadyTimeout how long the preflight waits for the carina pods to become ready
+	carinaReadyTimeout = 3 * time.Minute
+)
+
+// carinaCRDs 用例依赖的carina crd
+var carinaCRDs = []string{
+	"logicvolumes.carina.storage.io",
+	"nodestorageresources.carina.storage.io",
+}
+
+var (
+	preflightOnce sync.Once
+	preflightErr  error
+)
+
+// preflight 每次运行只检查一次carina是否安装就绪，未就绪时跳过或失败所有用例
+func (f *Framework) preflight() {
+	preflightOnce.Do(func() {
+		preflightErr = f.WaitForCarinaReady(carinaReadyTimeout)
+	})
+	if preflightErr == nil {
+		return
+	}
+	msg := fmt.Sprintf("carina is not ready, check the installation in namespace %s: %v", carinaNamespace, preflightErr)
+	if TestContext.SkipIfCarinaNotReady {
+		ginkgo.Skip(msg)
+	}
+	ginkgo.Fail(msg)
+}
+
+// WaitForCarinaReady checks that the carina crds are established, then waits until the carina-controller
+// deployment and every pod of the carina-node daemonset are ready. Missing crds or workloads fail at once.
+func (f *Framework) WaitForCarinaReady(timeout time.Duration) error {
+	if err := f.checkCarinaCRDs(); err != nil {
+		return err
+	}
+
+	var notReady error
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		notReady = f.carinaWorkloadsReady()
+		if notReady == nil {
+			return true, nil
+		}
+		if k8sErrors.IsNotFound(notReady) || k8sErrors.IsForbidden(notReady) {
+			return false, notReady
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("timed out after %s: %v", timeout, notReady)
+	}
+	return err
+}
+
+func (f *Framework) checkCarinaCRDs() error {
+	missing := []string{}
+	for _, name := range carinaCRDs {
+		crd, err := f.APIExtensionsClientSet.ApiextensionsV1().CustomResourceDefinitions().Get(context.TODO(), name, metav1.GetOptions{})
+		if k8sErrors.IsNotFound(err) {
+			missing = append(missing, name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("get crd %s: %v", name, err)
+		}
+		if !crdEstablished(crd) {
+			return fmt.Errorf("crd %s is not established", name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("crds %s are not installed", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func crdEstablished(crd *apiextv1.CustomResourceDefinition) bool {
+	for _, c := range crd.Status.Conditions {
+		if c.Type == apiextv1.Established {
+			return c.Status == apiextv1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// carinaWorkloadsReady 返回第一个未就绪的组件
+func (f *Framework) carinaWorkloadsReady() error {
+	deployments, err := f.KubeClientSet.AppsV1().Deployments(carinaNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: carinaControllerLabel})
+	if err != nil {
+		return err
+	}
+	if len(deployments.Items) == 0 {
+		return k8sErrors.NewNotFound(schema.GroupResource{Group: "apps", Resource: "deployments"}, carinaControllerLabel)
+	}
+	for _, d := range deployments.Items {
+		if d.Status.ReadyReplicas < 1 || d.Status.UpdatedReplicas < d.Status.Replicas {
+			return fmt.Errorf("carina-controller deployment %s has %d/%d ready replicas", d.Name, d.Status.ReadyReplicas, d.Status.Replicas)
+		}
+	}
+
+	daemonSets, err := f.KubeClientSet.AppsV1().DaemonSets(carinaNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: carinaNodeLabel})
+	if err != nil {
+		return err
+	}
+	if len(daemonSets.Items) == 0 {
+		return k8sErrors.NewNotFound(schema.GroupResource{Group: "apps", Resource: "daemonsets"}, carinaNodeLabel)
+	}
+	for _, ds := range daemonSets.Items {
+		if ds.Status.DesiredNumberScheduled == 0 || ds.Status.NumberReady < ds.Status.DesiredNumberScheduled {
+			return fmt.Errorf("carina-node daemonset %s has %d/%d ready pods", ds.Name, ds.Status.NumberReady, ds.Status.DesiredNumberScheduled)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/carina-io/carina/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/stretchr/testify/assert"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+// 快照使用external-snapshotter的crd，通过dynamic client访问，e2e不依赖其client库
+var (
+	volumeSnapshotClassGVR = schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshotclasses"}
+	volumeSnapshotGVR      = schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshots"}
+)
+
+// EnsureVolumeSnapshotClass creates a carina volumesnapshotclass unless it already exists and returns it,
+// a class created here is deleted after the spec.
+func (f *Framework) EnsureVolumeSnapshotClass(name, deletionPolicy string) *unstructured.Unstructured {
+	vsc := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion":     volumeSnapshotClassGVR.GroupVersion().String(),
+		"kind":           "VolumeSnapshotClass",
+		"metadata":       map[string]interface{}{"name": name},
+		"driver":         utils.CSIPluginName,
+		"deletionPolicy": deletionPolicy,
+	}}
+	labelRun(vsc)
+	client := f.DynamicClient.Resource(volumeSnapshotClassGVR)
+	_, err := client.Create(context.TODO(), vsc, metav1.CreateOptions{})
+	if err == nil {
+		f.AddCleanup(fmt.Sprintf("delete volumesnapshotclass %s", name), func() error {
+			return deleteAndWait(client, name)
+		})
+	} else if !k8sErrors.IsAlreadyExists(err) {
+		assert.Nil(ginkgo.GinkgoT(), err, "creating volumesnapshotclass")
+	}
+
+	vsc, err = client.Get(context.TODO(), name, metav1.GetOptions{})
+	assert.Nil(ginkgo.GinkgoT(), err, "getting volumesnapshotclass")
+	return vsc
+}
+
+// EnsureVolumeSnapshot creates a snapshot of the pvc and returns it, throws error if it already exists.
+// The snapshot is deleted after the spec, before the pvc it was taken from.
+func (f *Framework) EnsureVolumeSnapshot(namespace, name, pvcName, className string) *unstructured.Unstructured {
+	vs := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": volumeSnapshotGVR.GroupVersion().String(),
+		"kind":       "VolumeSnapshot",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"spec": map[string]interface{}{
+			"volumeSnapshotClassName": className,
+			"source":                  map[string]interface{}{"persistentVolumeClaimName": pvcName},
+		},
+	}}
+	labelRun(vs)
+	client := f.DynamicClient.Resource(volumeSnapshotGVR).Namespace(namespace)
+	_, err := client.Create(context.TODO(), vs, metav1.CreateOptions{})
+	assert.Nil(ginkgo.GinkgoT(), err, "creating volumesnapshot")
+	f.AddCleanup(fmt.Sprintf("delete volumesnapshot %s/%s", namespace, name), func() error {
+		return deleteAndWait(client, name)
+	})
+
+	vs, err = client.Get(context.TODO(), name, metav1.GetOptions{})
+	assert.Nil(ginkgo.GinkgoT(), err, "getting volumesnapshot")
+	return vs
+}
+
+// WaitForVolumeSnapshotReady waits until status.readyToUse of the snapshot is true
+func (f *Framework) WaitForVolumeSnapshotReady(namespace, name string, timeout time.Duration) error {
+	client := f.DynamicClient.Resource(volumeSnapshotGVR).Namespace(namespace)
+	err := wait.PollImmediate(Poll, timeout, func() (bool, error) {
+		vs, err := client.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			if isRetryableAPIError(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		ready, _, _ := unstructured.NestedBool(vs.Object, "status", "readyToUse")
+		return ready, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for volumesnapshot %s/%s to be ready: %v", namespace, name, err)
+	}
+	return nil
+}
+
+// deleteAndWait 删除对象并等待finalizer处理完成
+func deleteAndWait(client dynamic.ResourceInterface, name string) error {
+	err := client.Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return wait.PollImmediate(Poll, DefaultTimeout, func() (bool, error) {
+		_, err := client.Get(context.TODO(), name, metav1.GetOptions{})
+		return k8sErrors.IsNotFound(err), nil
+	})
+}
@@ -13,7 +13,7 @@ import (
 )
 
 func (f *Framework) NewStorageClass(s *storagev1.StorageClass) {
-
+	labelRun(s)
 	err := createStorageClassWithRetries(f.KubeClientSet, s)
 	assert.Nil(ginkgo.GinkgoT(), err, "creating storageClass")
 
@@ -23,6 +23,29 @@ func (f *Framework) NewStorageClass(s *storagev1.StorageClass) {
 
 }
 
+// EnsureStorageClass creates the storageclass unless it already exists and returns it,
+// a storageclass created here is deleted after the spec.
+func (f *Framework) EnsureStorageClass(s *storagev1.StorageClass) *storagev1.StorageClass {
+	labelRun(s)
+	err := createStorageClassWithRetries(f.KubeClientSet, s)
+	if err == nil {
+		f.AddCleanup(fmt.Sprintf("delete storageclass %s", s.Name), func() error {
+			err := deleteStorageClassWithRetries(f.KubeClientSet, s.Name)
+			if k8sErrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		})
+	} else if !k8sErrors.IsAlreadyExists(err) {
+		assert.Nil(ginkgo.GinkgoT(), err, "creating storageClass")
+	}
+
+	sc, err := f.KubeClientSet.StorageV1().StorageClasses().Get(context.TODO(), s.Name, metav1.GetOptions{})
+	assert.Nil(ginkgo.GinkgoT(), err, "getting storageClass")
+	assert.NotNil(ginkgo.GinkgoT(), sc, "expected a storageClass but none returned")
+	return sc
+}
+
 func createStorageClassWithRetries(c kubernetes.Interface, obj *storagev1.StorageClass) error {
 	if obj == nil {
 		return fmt.Errorf("object provided to create is empty")
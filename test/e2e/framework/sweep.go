@@ -0,0 +1,162 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+var logicVolumeGVR = schema.GroupVersionResource{Group: "carina.storage.io", Version: "v1", Resource: "logicvolumes"}
+
+// sweeper 清理崩溃的运行遗留的对象
+type sweeper struct {
+	client  kubernetes.Interface
+	dynamic dynamic.Interface
+	cutoff  time.Time
+	errs    []error
+}
+
+// SweepOrphans deletes what crashed previous runs left behind, it runs once per suite before the specs.
+// Namespaces, storageclasses and volumesnapshotclasses labeled with another RunID and older than olderThan
+// are deleted. pvs with Retain policy and LogicVolumes of e2e namespaces that no longer exist follow, the
+// LogicVolumes only when no pv uses them, so what a namespace deleted now leaves is swept by the next run.
+// A run never sweeps objects younger than olderThan, so concurrent runs on the cluster are left alone; 0 disables.
+func SweepOrphans(olderThan time.Duration) error {
+	if olderThan <= 0 {
+		return nil
+	}
+	cfg, err := GetConfig()
+	if err != nil {
+		return err
+	}
+	s := &sweeper{cutoff: time.Now().Add(-olderThan)}
+	if s.client, err = kubernetes.NewForConfig(cfg); err != nil {
+		return err
+	}
+	if s.dynamic, err = dynamic.NewForConfig(cfg); err != nil {
+		return err
+	}
+
+	s.sweepNamespaces()
+	s.sweepStorageClasses()
+	s.sweepDynamic(volumeSnapshotClassGVR)
+	s.sweepVolumes()
+	return utilerrors.NewAggregate(s.errs)
+}
+
+// stale 其他运行创建，且早于cutoff
+func (s *sweeper) stale(obj metav1.Object) bool {
+	return obj.GetLabels()[RunIDLabel] != string(RunID) && obj.GetCreationTimestamp().Time.Before(s.cutoff)
+}
+
+func (s *sweeper) deleted(kind, name string, err error) {
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		s.errs = append(s.errs, fmt.Errorf("delete %s %s: %v", kind, name, err))
+		return
+	}
+	Logf("swept %s %s", kind, name)
+}
+
+func (s *sweeper) sweepNamespaces() {
+	namespaces, err := s.client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{LabelSelector: RunIDLabel})
+	if err != nil {
+		s.errs = append(s.errs, err)
+		return
+	}
+	for _, ns := range namespaces.Items {
+		if s.stale(&ns) && ns.DeletionTimestamp == nil {
+			s.deleted("namespace", ns.Name, DeleteKubeNamespace(s.client, ns.Name))
+		}
+	}
+}
+
+func (s *sweeper) sweepStorageClasses() {
+	classes, err := s.client.StorageV1().StorageClasses().List(context.TODO(), metav1.ListOptions{LabelSelector: RunIDLabel})
+	if err != nil {
+		s.errs = append(s.errs, err)
+		return
+	}
+	for _, sc := range classes.Items {
+		if s.stale(&sc) {
+			s.deleted("storageclass", sc.Name, s.client.StorageV1().StorageClasses().Delete(context.TODO(), sc.Name, metav1.DeleteOptions{}))
+		}
+	}
+}
+
+// sweepDynamic 清理集群级的crd对象，crd未安装时跳过
+func (s *sweeper) sweepDynamic(gvr schema.GroupVersionResource) {
+	client := s.dynamic.Resource(gvr)
+	list, err := client.List(context.TODO(), metav1.ListOptions{LabelSelector: RunIDLabel})
+	if k8sErrors.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		s.errs = append(s.errs, err)
+		return
+	}
+	for _, obj := range list.Items {
+		if s.stale(&obj) {
+			s.deleted(gvr.Resource, obj.GetName(), client.Delete(context.TODO(), obj.GetName(), metav1.DeleteOptions{}))
+		}
+	}
+}
+
+// gone e2e命名空间已不存在
+func (s *sweeper) gone(namespace string) bool {
+	if !strings.HasPrefix(namespace, namespacePrefix) {
+		return false
+	}
+	_, err := s.client.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+	return k8sErrors.IsNotFound(err)
+}
+
+// sweepVolumes pv与LogicVolume由carina创建，没有运行标签，按所属的e2e命名空间判断
+// pvs with Delete policy are removed by the provisioner together with their pvc, deleting them
+// here would leak the lvm volume, so only pvs with Retain policy are swept.
+func (s *sweeper) sweepVolumes() {
+	pvs, err := s.client.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		s.errs = append(s.errs, err)
+		return
+	}
+	used := map[string]bool{}
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI != nil {
+			used[pv.Spec.CSI.VolumeHandle] = true
+		}
+		if pv.Spec.CSI == nil || pv.Spec.ClaimRef == nil || pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain ||
+			!pv.CreationTimestamp.Time.Before(s.cutoff) || !s.gone(pv.Spec.ClaimRef.Namespace) {
+			continue
+		}
+		s.deleted("pv", pv.Name, s.client.CoreV1().PersistentVolumes().Delete(context.TODO(), pv.Name, metav1.DeleteOptions{}))
+		delete(used, pv.Spec.CSI.VolumeHandle)
+	}
+
+	client := s.dynamic.Resource(logicVolumeGVR)
+	lvs, err := client.List(context.TODO(), metav1.ListOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		s.errs = append(s.errs, err)
+		return
+	}
+	for _, lv := range lvs.Items {
+		namespace, _, _ := unstructured.NestedString(lv.Object, "spec", "nameSpace")
+		volumeID, _, _ := unstructured.NestedString(lv.Object, "status", "volumeID")
+		if used[volumeID] || lv.GetDeletionTimestamp() != nil || !lv.GetCreationTimestamp().Time.Before(s.cutoff) || !s.gone(namespace) {
+			continue
+		}
+		s.deleted("logicvolume", lv.GetName(), client.Delete(context.TODO(), lv.GetName(), metav1.DeleteOptions{}))
+	}
+}
@@ -2,6 +2,7 @@ package framework
 
 import (
 	"flag"
+	"time"
 
 	"github.com/onsi/ginkgo/config"
 )
@@ -11,6 +12,26 @@ type TestContextType struct {
 	KubeHost string
 	//KubeConfig  string
 	KubeContext string
+	// SkipIfCarinaNotReady skips the specs instead of failing them when carina is not installed or not ready
+	SkipIfCarinaNotReady bool
+	// NodeRebootCommand command run on the test host to reboot a node, {node} is replaced by the node name
+	NodeRebootCommand string
+	// SweepOlderThan objects of other runs older than this are swept before the suite, 0 disables
+	SweepOlderThan time.Duration
+	// Perf runs the [Perf] specs, they are skipped otherwise
+	Perf bool
+	// PerfVolumes number of volumes per disk group that run fio concurrently
+	PerfVolumes int
+	// PerfDiskGroups disk groups the perf specs create volumes in
+	PerfDiskGroups string
+	// PerfRuntime runtime of each fio profile
+	PerfRuntime time.Duration
+	// PerfFileSize quantity of the fio test file, the volumes are 1Gi larger
+	PerfFileSize string
+	// PerfSoak repeats the fio profiles until this much time passed, 0 runs them once
+	PerfSoak time.Duration
+	// PerfReport prefix of the json and csv reports of the perf specs
+	PerfReport string
 }
 
 // TestContext is the global client context for tests.
@@ -23,6 +44,17 @@ func registerCommonFlags() {
 	flag.StringVar(&TestContext.KubeHost, "kubernetes-host", "http://127.0.0.1:8080", "The kubernetes host, or apiserver, to connect to")
 	//flag.StringVar(&TestContext.KubeConfig, "kubernetes-config", os.Getenv(clientcmd.RecommendedConfigPathEnvVar), "Path to config containing embedded authinfo for kubernetes. Default value is from environment variable "+clientcmd.RecommendedConfigPathEnvVar)
 	flag.StringVar(&TestContext.KubeContext, "kubernetes-context", "", "config context to use for kubernetes. If unset, will use value from 'current-context'")
+	flag.BoolVar(&TestContext.SkipIfCarinaNotReady, "skip-if-carina-not-ready", false, "Skip the specs instead of failing them when the carina crds or pods are not ready")
+	flag.DurationVar(&TestContext.SweepOlderThan, "sweep-older-than", time.Hour, "Delete namespaces, storageclasses, pvs and LogicVolumes that other e2e runs created longer ago than this before running the specs, 0 disables")
+	flag.BoolVar(&TestContext.Perf, "perf", false, "Run the [Perf] specs that benchmark the volumes of every disk group with fio")
+	flag.IntVar(&TestContext.PerfVolumes, "perf-volumes", 3, "Number of volumes per disk group the perf specs run fio on concurrently")
+	flag.StringVar(&TestContext.PerfDiskGroups, "perf-disk-groups", "carina-vg-ssd,carina-vg-hdd", "Comma separated disk groups the perf specs create volumes in")
+	flag.DurationVar(&TestContext.PerfRuntime, "perf-runtime", time.Minute, "Runtime of each fio profile of the perf specs")
+	flag.StringVar(&TestContext.PerfFileSize, "perf-file-size", "4Gi", "Size of the fio test file of the perf specs, the volumes are 1Gi larger")
+	flag.DurationVar(&TestContext.PerfSoak, "perf-soak", 0, "Repeat the fio profiles of the perf specs until this much time passed, 0 runs them once")
+	flag.StringVar(&TestContext.PerfReport, "perf-report", "perf-report", "Prefix of the json and csv reports the perf specs write, empty disables them")
+	flag.StringVar(&FioImage, "fio-image", FioImage, "Image of the pods the perf specs run fio in, it needs sh and fio")
+	flag.StringVar(&TestContext.NodeRebootCommand, "node-reboot-command", "", "Command run on the test host to reboot a node, {node} is replaced by the node name, e.g. \"docker restart {node}\" for kind. Empty runs systemctl reboot on the node")
 }
 
 // RegisterParseFlags registers and parses flags for the test binary.
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/config"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/uuid"
@@ -33,9 +34,41 @@ const (
 // CurrentSuite represents current test suite.
 var CurrentSuite Suite
 
-// RunID unique identifier of the e2e run
+// RunID unique identifier of the e2e run, shared by the parallel ginkgo nodes of the run
 var RunID = uuid.NewUUID()
 
+const (
+	// RunIDLabel label of every object the framework creates, its value is the RunID
+	RunIDLabel = "e2e.carina.storage.io/run-id"
+	// namespacePrefix prefix of the namespaces of the specs
+	namespacePrefix = "e2e-tests-"
+)
+
+// runSuffix 区分本次运行及并行的ginkgo节点，例如3f2a9c1d-2
+func runSuffix() string {
+	id := string(RunID)
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return fmt.Sprintf("%s-%d", id, config.GinkgoConfig.ParallelNode)
+}
+
+// UniqueName returns base suffixed with the run and the ginkgo node, cluster scoped objects
+// such as storageclasses need it to not collide with the specs of other nodes under ginkgo -p.
+func UniqueName(base string) string {
+	return fmt.Sprintf("%s-%s", base, runSuffix())
+}
+
+// labelRun 给对象打上本次运行的标签，清理程序据此找到崩溃的运行遗留的对象
+func labelRun(obj metav1.Object) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[RunIDLabel] = string(RunID)
+	obj.SetLabels(labels)
+}
+
 func nowStamp() string {
 	return time.Now().Format(time.StampMilli)
 }
@@ -63,13 +96,13 @@ func CreateKubeNamespace(baseName string, c kubernetes.Interface) (string, error
 }
 
 func createNamespace(baseName string, labels map[string]string, c kubernetes.Interface) (string, error) {
-	ts := time.Now().UnixNano()
 	ns := &corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: fmt.Sprintf("e2e-tests-%v-%v-", baseName, ts),
+			GenerateName: fmt.Sprintf("%s%v-%s-", namespacePrefix, baseName, runSuffix()),
 			Labels:       labels,
 		},
 	}
+	labelRun(ns)
 
 	// Be robust about making the namespace creation call.
 	var got *corev1.Namespace
@@ -1,7 +1,10 @@
 package lvm
 
 import (
+	"strings"
+
 	"github.com/carina-io/carina/test/e2e/framework"
+	"github.com/carina-io/carina/utils"
 	"github.com/onsi/ginkgo"
 	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
@@ -11,8 +14,9 @@ import (
 
 var _ = framework.CrainaDescribe("create LVM pvc", func() {
 	f := framework.NewDefaultFramework("lvm-pvc")
-	storageClassName := "csi-carina-lvm"
+	var storageClassName string
 	ginkgo.BeforeEach(func() {
+		storageClassName = framework.UniqueName("csi-carina-lvm")
 		del := corev1.PersistentVolumeReclaimDelete
 		waitForFirstConsumer := storagev1.VolumeBindingWaitForFirstConsumer
 		t := true
@@ -28,7 +32,7 @@ var _ = framework.CrainaDescribe("create LVM pvc", func() {
 			VolumeBindingMode:    &waitForFirstConsumer,
 			AllowedTopologies:    []corev1.TopologySelectorTerm{},
 		}
-		f.NewStorageClass(s)
+		f.EnsureStorageClass(s)
 	})
 
 	// 1. create LVM pvc
@@ -54,10 +58,45 @@ var _ = framework.CrainaDescribe("create LVM pvc", func() {
 		framework.ExpectEqual(pvcResult.Status.Phase, corev1.ClaimPending)
 	})
 
-	// TODO 2.LVM pvc resizing
+	// 2. consume LVM pvc
+	ginkgo.It("should write and read back data through a pod", func() {
+		persistentVolumeBlock := corev1.PersistentVolumeBlock
+		lvmPvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "lvm-block-pvc",
+				Namespace: f.Namespace,
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				VolumeMode:       &persistentVolumeBlock,
+				StorageClassName: &storageClassName,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceName(corev1.ResourceStorage): resource.MustParse("3Gi"),
+					}},
+			},
+		}
+		f.EnsurePvc(lvmPvc)
+		f.EnsurePod(framework.NewPodWithPvc(f.Namespace, "lvm-block-pod", lvmPvc.Name, true))
+		framework.ExpectNoError(f.WaitForPodRunning(f.Namespace, "lvm-block-pod", framework.DefaultTimeout))
+
+		written, err := f.DdWrite(f.Namespace, "lvm-block-pod", framework.PodDevicePath, 64)
+		framework.ExpectNoError(err)
+		read, err := f.Md5sum(f.Namespace, "lvm-block-pod", framework.PodDevicePath, 64)
+		framework.ExpectNoError(err)
+		framework.ExpectEqual(read, written)
+
+		// 卷对应的lv确实建在pod所在的节点上
+		pod := f.GetPod(f.Namespace, "lvm-block-pod")
+		pv := f.GetPv(f.GetPvc(f.Namespace, lvmPvc.Name).Spec.VolumeName)
+		path := strings.Split(strings.TrimPrefix(pv.Spec.CSI.VolumeAttributes[utils.VolumeDevicePath], "/dev/"), "/")
+		framework.ExpectEqual(len(path), 2, "device path of pv %s", pv.Name)
+		f.ExpectLVExists(pod.Spec.NodeName, path[0], path[1])
 
-	// TODO 3.delete LVM pvc
-	ginkgo.AfterEach(func() {
-		f.DeleteStorageClass(storageClassName)
+		f.DeletePod(f.Namespace, "lvm-block-pod")
 	})
+
+	// TODO 3.LVM pvc resizing
+
+	// TODO 4.delete LVM pvc
 })
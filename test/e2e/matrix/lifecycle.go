@@ -0,0 +1,80 @@
+package matrix
+
+import (
+	"github.com/carina-io/carina/test/e2e/framework"
+	"github.com/carina-io/carina/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// lifecycleMatrix carina的lvm卷都建在各自的thin pool上，thick对应按需分区的裸盘卷
+var lifecycleMatrix = framework.Matrix{
+	Dimensions: []framework.MatrixDimension{
+		{Name: "fstype", Values: []framework.MatrixValue{
+			{Name: "xfs", Params: map[string]string{"csi.storage.k8s.io/fstype": "xfs"}},
+			{Name: "ext4", Params: map[string]string{"csi.storage.k8s.io/fstype": "ext4"}},
+		}},
+		{Name: "provisioning", Values: []framework.MatrixValue{
+			{Name: "thin", Params: map[string]string{utils.DeviceDiskKey: "carina-vg-ssd"}},
+			{Name: "thick", Params: map[string]string{utils.DeviceDiskKey: "carina-raw-ssd"}},
+		}},
+		{Name: "cache", Values: []framework.MatrixValue{
+			{Name: "none"},
+			{Name: "writeback", Params: map[string]string{
+				utils.DeviceDiskKey:         "",
+				utils.VolumeBackendDiskType: "carina-vg-ssd",
+				utils.VolumeCacheDiskType:   "carina-vg-ssd",
+				utils.VolumeCacheDiskRatio:  "50",
+				utils.VolumeCachePolicy:     "writeback",
+			}},
+		}},
+		{Name: "mode", Values: []framework.MatrixValue{
+			{Name: "fs", VolumeMode: corev1.PersistentVolumeFilesystem},
+			{Name: "block", VolumeMode: corev1.PersistentVolumeBlock},
+		}},
+	},
+	Exclude: func(c framework.MatrixCase) bool {
+		// 块设备不格式化，只保留一种fstype；bcache只支持lvm卷
+		return (c.Block() && c.Values["fstype"] != "xfs") ||
+			(c.Values["cache"] != "none" && c.Values["provisioning"] != "thin")
+	},
+}
+
+var _ = framework.DescribeMatrix("volume lifecycle", lifecycleMatrix, func(f *framework.Framework, c framework.MatrixCase) {
+	sc := f.EnsureStorageClass(c.StorageClass())
+	volumeMode := c.VolumeMode
+	pvc := f.EnsurePvc(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "matrix-pvc",
+			Namespace: f.Namespace,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			VolumeMode:       &volumeMode,
+			StorageClassName: &sc.Name,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: resource.MustParse("2Gi"),
+				}},
+		},
+	})
+
+	path := framework.PodMountPath + "/matrix"
+	if c.Block() {
+		path = framework.PodDevicePath
+	}
+
+	// 写入数据，删除pod后在新pod中读回，覆盖挂载、卸载和再次挂载
+	f.EnsurePod(framework.NewPodWithPvc(f.Namespace, "matrix-writer", pvc.Name, c.Block()))
+	framework.ExpectNoError(f.WaitForPodRunning(f.Namespace, "matrix-writer", framework.DefaultTimeout))
+	written, err := f.DdWrite(f.Namespace, "matrix-writer", path, 32)
+	framework.ExpectNoError(err)
+	f.DeletePod(f.Namespace, "matrix-writer")
+
+	f.EnsurePod(framework.NewPodWithPvc(f.Namespace, "matrix-reader", pvc.Name, c.Block()))
+	framework.ExpectNoError(f.WaitForPodRunning(f.Namespace, "matrix-reader", framework.DefaultTimeout))
+	read, err := f.Md5sum(f.Namespace, "matrix-reader", path, 32)
+	framework.ExpectNoError(err)
+	framework.ExpectEqual(read, written)
+})
@@ -0,0 +1,111 @@
+package perf
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carina-io/carina/test/e2e/framework"
+	"github.com/carina-io/carina/utils"
+	"github.com/onsi/ginkgo"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// 每个磁盘组创建PerfVolumes个卷，组内所有卷并发运行同一个fio profile，结果写入性能报告
+var _ = framework.CrainaDescribe("[Perf] fio benchmark", func() {
+	f := framework.NewDefaultFramework("perf")
+
+	ginkgo.BeforeEach(func() {
+		if !framework.TestContext.Perf {
+			ginkgo.Skip("perf specs run with -perf only")
+		}
+	})
+
+	// 标志在构建spec树之后才解析，磁盘组只能在spec中展开
+	ginkgo.It("of every disk group", func() {
+		groups := map[string][]*corev1.Pod{}
+		for _, group := range strings.Split(framework.TestContext.PerfDiskGroups, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				groups[group] = preparePods(f, group)
+			}
+		}
+
+		deadline := time.Now().Add(framework.TestContext.PerfSoak)
+		for round := 0; round == 0 || time.Now().Before(deadline); round++ {
+			for group, pods := range groups {
+				for _, p := range framework.DefaultFioProfiles {
+					runProfile(f, group, round, p, pods)
+				}
+			}
+		}
+	})
+})
+
+// preparePods 创建存储类、卷和运行fio的pod
+func preparePods(f *framework.Framework, group string) []*corev1.Pod {
+	del := corev1.PersistentVolumeReclaimDelete
+	waitForFirstConsumer := storagev1.VolumeBindingWaitForFirstConsumer
+	sc := f.EnsureStorageClass(&storagev1.StorageClass{
+		ObjectMeta:        metav1.ObjectMeta{Name: framework.UniqueName("carina-perf-" + group)},
+		Provisioner:       utils.CSIPluginName,
+		Parameters:        map[string]string{"csi.storage.k8s.io/fstype": "xfs", utils.DeviceDiskKey: group},
+		ReclaimPolicy:     &del,
+		VolumeBindingMode: &waitForFirstConsumer,
+	})
+
+	size := resource.MustParse(framework.TestContext.PerfFileSize)
+	size.Add(resource.MustParse("1Gi"))
+	pods := []*corev1.Pod{}
+	for i := 0; i < framework.TestContext.PerfVolumes; i++ {
+		name := fmt.Sprintf("perf-%s-%d", group, i)
+		pvc := f.EnsurePvc(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: f.Namespace},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				StorageClassName: &sc.Name,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+				},
+			},
+		})
+		pod := framework.NewPodWithPvc(f.Namespace, name, pvc.Name, false)
+		pod.Spec.Containers[0].Image = framework.FioImage
+		f.EnsurePod(pod)
+	}
+	for i := 0; i < framework.TestContext.PerfVolumes; i++ {
+		name := fmt.Sprintf("perf-%s-%d", group, i)
+		framework.ExpectNoError(f.WaitForPodRunning(f.Namespace, name, 5*time.Minute))
+		pods = append(pods, f.GetPod(f.Namespace, name))
+	}
+	return pods
+}
+
+// runProfile 在所有pod中同时运行profile
+func runProfile(f *framework.Framework, group string, round int, p framework.FioProfile, pods []*corev1.Pod) {
+	var wg sync.WaitGroup
+	errs := make([]error, len(pods))
+	for i, pod := range pods {
+		wg.Add(1)
+		go func(i int, pod *corev1.Pod) {
+			defer wg.Done()
+			result, err := f.RunFio(pod.Namespace, pod.Name, framework.PodMountPath+"/fio.data", p)
+			if err != nil {
+				errs[i] = fmt.Errorf("fio %s on %s: %v", p.Name, pod.Name, err)
+				return
+			}
+			result.DiskGroup, result.Node, result.Round = group, pod.Spec.NodeName, round
+			framework.Perf.Add(result)
+			framework.Logf("round %d %s %s on %s: read %.0f iops %d KiB/s p99 %.0fus, write %.0f iops %d KiB/s p99 %.0fus",
+				round, group, p.Name, pod.Name, result.Read.IOPS, result.Read.BWKiB, result.Read.LatP99,
+				result.Write.IOPS, result.Write.BWKiB, result.Write.LatP99)
+		}(i, pod)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		framework.ExpectNoError(err)
+	}
+}
@@ -30,8 +30,14 @@ const (
 	LogicVolumeNamespace = "default"
 	// LogicVolumeFinalizer LogicalVolumeFinalizer is the name of LogicalVolume finalizer
 	LogicVolumeFinalizer = "carina.storage.io/logicvolume"
+	// VolumeFreezeFinalizer VolumeFreeze finalizer, removed once all volumes of the freeze are thawed
+	VolumeFreezeFinalizer = "carina.storage.io/volume-freeze"
+	// VolumeReplicationFinalizer VolumeReplication finalizer, removed once its standby volume can be used again
+	VolumeReplicationFinalizer = "carina.storage.io/volume-replication"
 	// ResizeRequestedAtKey is the key of LogicalVolume that represents the timestamp of the resize request.
 	ResizeRequestedAtKey = "carina.storage.io/resize-requested-at"
+	// AnnTraceParent LogicVolume annotation, w3c traceparent of the CreateVolume that created it
+	AnnTraceParent = "carina.storage.io/traceparent"
 
 	//ExclusivityDisk  true or false  is the key indicates that only the disk is used by one pod
 	ExclusivityDisk = "carina.storage.io/exclusively-raw-disk"
@@ -41,6 +47,11 @@ const (
 	// DeviceDiskKey storage class
 	// DeviceDiskKey is the key used in CSI volume create requests to specify a DeviceDiskKey support carina-vg-ssd carina-vg-hdd
 	DeviceDiskKey = "carina.storage.io/disk-group-name"
+	// DeviceGroupKey short form of DeviceDiskKey, e.g. carina.storage.io/disk-group: nvme
+	DeviceGroupKey = "carina.storage.io/disk-group"
+	// VolumeFsType pvc annotation and volume context, filesystem used instead of the storage class csi.storage.k8s.io/fstype,
+	// also set on the LogicVolume of filesystem volumes so that the node can adopt a preformatted spare volume
+	VolumeFsType = "carina.storage.io/fstype"
 
 	VolumeBackendDiskType = "carina.storage.io/backend-disk-group-name"
 	VolumeCacheDiskType   = "carina.storage.io/cache-disk-group-name"
@@ -87,4 +98,205 @@ const (
 	RawVolumeType = "raw"
 
 	AllowPodMigrationIfNodeNotready = "carina.stroage.io/allow-pod-migration-if-node-notready"
+	// ApprovedDisks NodeStorageResource annotation, comma separated ids of the pending disks carina-node may add to a volume group while diskApproval is enabled
+	ApprovedDisks = "carina.storage.io/approved-disks"
+	// AllowDrain pod or node annotation, "true" lets the node be drained although pods use carina volumes on it
+	AllowDrain = "carina.storage.io/allow-drain"
+
+	// VolumePrefillSource storage class parameter, s3://bucket/prefix the new volume is filled from before first use
+	VolumePrefillSource = "carina.storage.io/prefill-source"
+	// VolumePrefillEndpoint s3 compatible endpoint, defaults to aws
+	VolumePrefillEndpoint = "carina.storage.io/prefill-endpoint"
+	VolumePrefillRegion   = "carina.storage.io/prefill-region"
+	// VolumePrefillParallelism number of concurrent ranged downloads
+	VolumePrefillParallelism = "carina.storage.io/prefill-parallelism"
+	// PrefillAccessKeySecret node publish secret keys holding the s3 credentials
+	PrefillAccessKeySecret = "accessKeyID"
+	PrefillSecretKeySecret = "secretAccessKey"
+
+	// VolumeImportSource pvc annotation and volume context, <node>:<host path> copied into the new volume before first use
+	VolumeImportSource = "carina.storage.io/import-source"
+
+	// VolumeForceDetach LogicVolume annotation, "true" drops the bcache dirty data not written back within the flush timeout on unpublish
+	VolumeForceDetach = "carina.storage.io/force-detach"
+
+	// VolumeEncrypted storage class parameter and LogicVolume annotation, "true" if the volume is luks encrypted
+	VolumeEncrypted = "carina.storage.io/encrypted"
+	// EncryptionPassphraseSecret node publish secret key holding the luks passphrase
+	EncryptionPassphraseSecret = "passphrase"
+	// NodePublishSecretName storage class parameter naming the node publish secret
+	NodePublishSecretName = "csi.storage.k8s.io/node-publish-secret-name"
+
+	// VolumeStripes storage class parameter and LogicVolume annotation, number of physical volumes an lvm volume is striped across
+	VolumeStripes = "carina.storage.io/stripes"
+	// VolumeStripeSize size of a stripe, e.g. 64k, lvm default if unset
+	VolumeStripeSize = "carina.storage.io/stripe-size"
+	// VolumeDeviceOwner storage class parameter, owner of the device node of block volumes, "<uid>:<gid>" or "pod" for the pod securityContext
+	VolumeDeviceOwner = "carina.storage.io/device-owner"
+	// VolumeDeviceMode storage class parameter, octal permissions of the device node of block volumes, 0660 if only the owner is set
+	VolumeDeviceMode = "carina.storage.io/device-mode"
+	// DeviceOwnerPod VolumeDeviceOwner value, take the owner from runAsUser and fsGroup of the pod
+	DeviceOwnerPod = "pod"
+	// PodNameKey PodNamespaceKey volume context of NodePublishVolume, set because the CSIDriver has podInfoOnMount
+	PodNameKey      = "csi.storage.k8s.io/pod.name"
+	PodNamespaceKey = "csi.storage.k8s.io/pod.namespace"
+	// VolumeFstrim storage class parameter and LogicVolume annotation, "true" lets carina-node run fstrim on the mounted volume periodically
+	VolumeFstrim = "carina.storage.io/fstrim"
+	// VolumeMkfsOptions storage class parameter, extra mkfs flags used when a filesystem volume is formatted, e.g. "-O ^has_journal"
+	VolumeMkfsOptions = "carina.storage.io/mkfs-options"
+	// VolumeFsckPolicy storage class parameter and pvc annotation, when carina-node repairs the filesystem of a volume
+	// before mounting it: never, auto after a failed mount, force before every mount
+	VolumeFsckPolicy = "carina.storage.io/fsck-policy"
+	FsckPolicyNever  = "never"
+	FsckPolicyAuto   = "auto"
+	FsckPolicyForce  = "force"
+
+	// VolumeShared storage class parameter, LogicVolume annotation and volume context, "true" exports the volume
+	// through an nfs gateway so that pods on every node can use it, which allows ReadWriteMany
+	VolumeShared = "carina.storage.io/shared"
+	// VolumeNfsServer LogicVolume annotation maintained by carina-controller, cluster ip of the nfs gateway of a shared volume
+	VolumeNfsServer = "carina.storage.io/nfs-server"
+
+	// SnapshotSource LogicVolume annotation, the LogicVolume is a csi snapshot of the named LogicVolume
+	SnapshotSource = "carina.storage.io/snapshot-source"
+	// VolumeDataSource LogicVolume annotation, snapshot id the new volume is restored from
+	VolumeDataSource = "carina.storage.io/data-source"
+	// SnapshotExportTarget volume snapshot class parameter and snapshot LogicVolume annotation, s3://bucket/prefix
+	// carina-node uploads the snapshot to, the snapshot is stored under <prefix>/<snapshot id>
+	SnapshotExportTarget = "carina.storage.io/export-target"
+	// VolumeRestoreSource pvc and LogicVolume annotation, s3://bucket/prefix/<snapshot id> of an exported snapshot
+	// the new volume is restored from
+	VolumeRestoreSource = "carina.storage.io/restore-source"
+	// S3ConfigDir directory of carina-node where the secret carina-s3 is mounted, keys endpoint, region,
+	// accessKeyID and secretAccessKey, used to export snapshots and restore them
+	S3ConfigDir = "/var/run/carina/s3"
+
+	// ReplicaPlacement StatefulSet annotation set by database operators, e.g. replicas=3,disk-group=carina-vg-ssd,free=100Gi
+	ReplicaPlacement = "carina.storage.io/replica-placement"
+	// ReplicaPlacementPlan StatefulSet annotation written by carina-controller, json list of node and disk group per ordinal
+	ReplicaPlacementPlan = "carina.storage.io/replica-placement-plan"
+
+	// SnapshotPolicyLabel VolumeSnapshot label, name of the SnapshotPolicy that took the snapshot
+	SnapshotPolicyLabel = "carina.storage.io/snapshot-policy"
+	// SnapshotPVCLabel VolumeSnapshot label, name of the pvc the snapshot was taken from
+	SnapshotPVCLabel = "carina.storage.io/snapshot-pvc"
+
+	// FailureDomainLabelPrefix pv label prefix, one label per enclosure or HBA holding the data of the volume,
+	// e.g. failure-domain.carina.storage.io/enclosure-500304801f0d2a3f: "true"
+	FailureDomainLabelPrefix = "failure-domain.carina.storage.io/"
+	// CacheDevices pv annotation maintained by carina-controller for bcache volumes, comma separated disks holding the cache, e.g. /dev/nvme0n1
+	CacheDevices = "carina.storage.io/cache-devices"
+	// BackingDevices pv annotation maintained by carina-controller for bcache volumes, comma separated disks holding the backing volume
+	BackingDevices = "carina.storage.io/backing-devices"
+
+	// VolumeOperationRequester VolumeOperation annotation set by the admission webhook, user the operation was authorized for
+	VolumeOperationRequester = "carina.storage.io/requested-by"
+
+	// ConditionPrefilled LogicVolume condition type tracking dataset prefill
+	ConditionPrefilled = "Prefilled"
+	// ConditionFragmentation NodeStorageResource condition type, true while a volume group is at or above fragmentationThreshold
+	ConditionFragmentation = "Fragmentation"
+	// ConditionCapacityExhaustion NodeStorageResource condition type, true while a volume group is forecast to be full within capacityForecastDays
+	ConditionCapacityExhaustion = "CapacityExhaustion"
+	// ConditionToolsMissing NodeStorageResource condition type, true while tools of a feature, e.g. bcache-tools, are missing on the node
+	ConditionToolsMissing = "ToolsMissing"
+	// ConditionHealthy NodeStorageResource condition type, false while another condition reports a problem, a device group is tainted or a disk is unhealthy
+	ConditionHealthy = "Healthy"
+	// ConditionLiveMigratable LogicVolume condition type, false once a KubeVirt live migration of the vm using it was rejected
+	ConditionLiveMigratable = "LiveMigratable"
+	// ConditionLiveMigration LogicVolume condition type, the last KubeVirt live migration of the vm using it,
+	// true with reason Migrating until carina cancelled it, then false with reason Cancelled
+	ConditionLiveMigration = "LiveMigration"
+	// ConditionExported snapshot LogicVolume condition type, true once the snapshot is uploaded to its export target
+	ConditionExported = "Exported"
+	// ConditionStorageNearlyFull pod condition type, true while the thin pool of a volume the pod uses is above usageThreshold
+	ConditionStorageNearlyFull = "carina.storage.io/StorageNearlyFull"
+	// CollectOrphan LogicVolume annotation carina-node sets to its node name to have an orphan LogicVolume deleted,
+	// carina-controller deletes it after confirming there is no PersistentVolume
+	CollectOrphan = "carina.storage.io/collect-orphan"
+	// LiveMigration VirtualMachineInstanceMigration annotation recording what carina did with the migration
+	LiveMigration         = "carina.storage.io/live-migration"
+	LiveMigrationRejected = "rejected"
+
+	// ReclaimReleasedVolume pv annotation applied by an admin, a Released pv with Retain policy
+	// is wiped and deleted and its capacity returned to the node
+	ReclaimReleasedVolume = "carina.storage.io/reclaim-released"
+	// VolumeWipePolicy storage class parameter, pv and LogicVolume annotation, how the data is erased before the volume is removed
+	VolumeWipePolicy  = "carina.storage.io/wipe-policy"
+	WipePolicyNone    = "none"
+	WipePolicyDiscard = "discard"
+	WipePolicyZero    = "zero"
+	// VolumeWipePasses storage class parameter and LogicVolume annotation, how often the volume is overwritten with zeros
+	VolumeWipePasses = "carina.storage.io/wipe-passes"
+	// MaxWipePasses upper bound of VolumeWipePasses
+	MaxWipePasses = 7
+
+	// VolumeTTL pvc annotation, the pvc is deleted once it has not been used by any pod for this long, e.g. 12h
+	VolumeTTL = "carina.storage.io/ttl"
+	// VolumeUnusedSince pvc annotation maintained by carina-controller for pvcs with a ttl, when the last pod using it went away
+	VolumeUnusedSince = "carina.storage.io/unused-since"
+	// VolumeAutoresizeThreshold pvc annotation, carina-controller expands the pvc once the filesystem usage reaches this percent
+	VolumeAutoresizeThreshold = "carina.storage.io/autoresize-threshold"
+	// VolumeAutoresizeStep pvc annotation, how much an automatic expansion adds, a size like 10Gi or a percent of the request like 20%
+	VolumeAutoresizeStep = "carina.storage.io/autoresize-step"
+	// VolumeAutoresizeMaxSize pvc annotation, automatic expansions never grow the request beyond this size
+	VolumeAutoresizeMaxSize = "carina.storage.io/autoresize-max-size"
+	// VolumeReplicationRole LogicVolume annotation set by carina-controller on the standby volume of a VolumeReplication, it can not be published
+	VolumeReplicationRole = "carina.storage.io/replication-role"
+	// VolumeReplicas storageclass parameter, 2 keeps a synchronous copy of the volume on a second node with drbd
+	VolumeReplicas = "carina.storage.io/replicas"
+	// VolumeReplicaNode LogicVolume annotation and volume context of a replicated volume, the node holding the second copy
+	VolumeReplicaNode = "carina.storage.io/replica-node"
+	// VolumeReplicaOf LogicVolume annotation of the second copy of a replicated volume, the LogicVolume it replicates
+	VolumeReplicaOf = "carina.storage.io/replica-of"
+	// VolumeDrbdMinor LogicVolume annotation and volume context of a replicated volume, the minor of its drbd device on both nodes
+	VolumeDrbdMinor = "carina.storage.io/drbd-minor"
+	// DrbdFinalizer LogicVolume finalizer of a replicated volume, removed once the node took its drbd resource down
+	DrbdFinalizer = "carina.storage.io/drbd"
+	// VolumeDrbdTiebreaker LogicVolume annotation of a replicated volume, the third node joining its drbd resource without disk for quorum
+	VolumeDrbdTiebreaker = "carina.storage.io/drbd-tiebreaker"
+	// DrbdTiebreakerFinalizer LogicVolume finalizer of a replicated volume, removed once the tiebreaker node took its drbd resource down
+	DrbdTiebreakerFinalizer = "carina.storage.io/drbd-tiebreaker"
+	// OutOfServiceTaint node taint an administrator sets after shutting down a failed node, its pods can be removed right away
+	OutOfServiceTaint = "node.kubernetes.io/out-of-service"
+
+	// DebugTokenHeader http header carrying the token of the carina-node debug api
+	DebugTokenHeader = "X-Carina-Debug-Token"
+	// DebugTokenSecret secret in the carina namespace holding the token of the debug api under key token
+	DebugTokenSecret = "carina-debug-token"
 )
+
+// StorageClassParameters are the carina parameters a StorageClass may set, the admission
+// webhook rejects other carina.storage.io parameters. A new parameter is added here.
+var StorageClassParameters = []string{
+	DeviceDiskKey,
+	DeviceGroupKey,
+	VolumeBackendDiskType,
+	VolumeCacheDiskType,
+	VolumeCacheDiskRatio,
+	VolumeCachePolicy,
+	VolumeCacheBlock,
+	VolumeCacheBucket,
+	ExclusivityDisk,
+	VolumePrefillSource,
+	VolumePrefillEndpoint,
+	VolumePrefillRegion,
+	VolumePrefillParallelism,
+	VolumeEncrypted,
+	VolumeStripes,
+	VolumeStripeSize,
+	VolumeMkfsOptions,
+	VolumeDeviceOwner,
+	VolumeDeviceMode,
+	VolumeFstrim,
+	VolumeFsckPolicy,
+	VolumeReplicas,
+	VolumeWipePolicy,
+	VolumeWipePasses,
+	VolumeShared,
+}
+
+// SnapshotClassParameters are the carina parameters a VolumeSnapshotClass may set.
+var SnapshotClassParameters = []string{
+	SnapshotExportTarget,
+}
@@ -37,6 +37,7 @@ type Executor interface {
 	ExecuteCommandWithEnv(env []string, command string, arg ...string) error
 	ExecuteCommandWithOutput(command string, arg ...string) (string, error)
 	ExecuteCommandWithCombinedOutput(command string, arg ...string) (string, error)
+	ExecuteCommandWithStderr(command string, arg ...string) (string, string, error)
 	ExecuteCommandWithOutputFile(command, outfileArg string, arg ...string) (string, error)
 	ExecuteCommandWithOutputFileTimeout(timeout time.Duration, command, outfileArg string, arg ...string) (string, error)
 	ExecuteCommandWithTimeout(timeout time.Duration, command string, arg ...string) (string, error)
@@ -137,6 +138,18 @@ func (*CommandExecutor) ExecuteCommandWithCombinedOutput(command string, arg ...
 	return runCommandWithOutput(cmd, true)
 }
 
+// ExecuteCommandWithStderr executes a command and returns its stdout and stderr separately
+func (*CommandExecutor) ExecuteCommandWithStderr(command string, arg ...string) (string, string, error) {
+	logCommand(command, arg...)
+	// #nosec G204 Rook controls the input to the exec arguments
+	cmd := exec.Command(command, arg...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String()), err
+}
+
 // ExecuteCommandWithOutputFileTimeout Same as ExecuteCommandWithOutputFile but with a timeout limit.
 // #nosec G307 Calling defer to close the file without checking the error return is not a risk for a simple file open and close
 func (*CommandExecutor) ExecuteCommandWithOutputFileTimeout(timeout time.Duration,
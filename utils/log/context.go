@@ -0,0 +1,69 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package log
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// RequestIDKey field name of the request id in the logs
+const RequestIDKey = "request_id"
+
+type contextKey struct{}
+
+type contextFields struct {
+	requestID string
+	fields    []interface{}
+}
+
+// NewContext returns a context whose logger from FromContext carries the request id and the key value pairs,
+// pairs of an outer context are kept.
+func NewContext(ctx context.Context, requestID string, keysAndValues ...interface{}) context.Context {
+	parent, _ := ctx.Value(contextKey{}).(*contextFields)
+	f := &contextFields{requestID: requestID}
+	if parent != nil {
+		if requestID == "" {
+			f.requestID = parent.requestID
+		}
+		f.fields = append(f.fields, parent.fields...)
+	}
+	f.fields = append(f.fields, keysAndValues...)
+	return context.WithValue(ctx, contextKey{}, f)
+}
+
+// RequestID returns the request id of the context, empty if it has none
+func RequestID(ctx context.Context) string {
+	if f, ok := ctx.Value(contextKey{}).(*contextFields); ok {
+		return f.requestID
+	}
+	return ""
+}
+
+// FromContext returns the logger with the request id and fields of the context
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	f, ok := ctx.Value(contextKey{}).(*contextFields)
+	if !ok {
+		return base.Sugar()
+	}
+	fields := f.fields
+	if f.requestID != "" {
+		fields = append([]interface{}{RequestIDKey, f.requestID}, fields...)
+	}
+	return base.Sugar().With(fields...)
+}
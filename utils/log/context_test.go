@@ -0,0 +1,61 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package log
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewContext(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	a.Equal("", RequestID(ctx))
+	a.NotNil(FromContext(ctx))
+
+	ctx = NewContext(ctx, "3f2a", "method", "CreateVolume")
+	a.Equal("3f2a", RequestID(ctx))
+	inner := NewContext(ctx, "", "volume_id", "pvc-1")
+	a.Equal("3f2a", RequestID(inner))
+	a.Equal([]interface{}{"method", "CreateVolume", "volume_id", "pvc-1"}, inner.Value(contextKey{}).(*contextFields).fields)
+	a.Equal([]interface{}{"method", "CreateVolume"}, ctx.Value(contextKey{}).(*contextFields).fields)
+}
+
+func TestSetup(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(Setup("warn", FormatJSON))
+	a.False(base.Core().Enabled(-1))
+	a.NoError(Setup("DEBUG", FormatConsole))
+	a.True(base.Core().Enabled(-1))
+	a.Error(Setup("verbose", FormatConsole))
+	a.Error(Setup("info", "xml"))
+	a.NoError(Setup("info", FormatConsole))
+}
+
+func TestSetLevel(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(Setup("info", FormatConsole))
+	a.False(base.Core().Enabled(-1))
+	a.NoError(SetLevel("debug"))
+	a.True(base.Core().Enabled(-1))
+	a.Error(SetLevel("verbose"))
+	a.True(base.Core().Enabled(-1))
+	a.NoError(SetLevel("INFO"))
+	a.False(base.Core().Enabled(-1))
+}
@@ -17,10 +17,15 @@
 package log
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
 	"github.com/natefinch/lumberjack"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"os"
 )
 
 const logPath = "/var/log/carina/carina.log"
@@ -37,23 +42,45 @@ var sugareLogger *zap.SugaredLogger
 // AddCaller 显示调用者
 // logInConsole 是否同时输出到控制台
 
-func init() {
-	hook := lumberjack.Logger{
+// Formats of Setup
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+var (
+	hook = &lumberjack.Logger{
 		Filename:   logPath, // 日志文件路径
 		MaxSize:    30,      // megabytes
 		MaxBackups: 3,       // 最多保留300个备份
-		Compress:   false,   // 是否压缩 disabled by default
+		MaxAge:     1,
+		Compress:   false, // 是否压缩 disabled by default
 	}
+	// base 不跳过调用栈的logger，FromContext和Logr基于它
+	base  *zap.Logger
+	level = zap.NewAtomicLevel()
+)
 
-	hook.MaxAge = 1
+func init() {
+	lvl := "info"
+	if os.Getenv("DEBUG") != "" {
+		lvl = "debug"
+	}
+	_ = Setup(lvl, FormatConsole)
+}
 
-	var syncer zapcore.WriteSyncer
-	syncer = zapcore.NewMultiWriteSyncer(zapcore.AddSync(os.Stdout), zapcore.AddSync(&hook))
-	//if logInConsole {
-	// syncer = zapcore.NewMultiWriteSyncer(zapcore.AddSync(os.Stdout), zapcore.AddSync(&hook))
-	//} else {
-	// syncer = zapcore.AddSync(&hook)
-	//}
+// Setup replaces the logger by one with the level debug/info/warn/error and the format console or json.
+// The DEBUG environment variable keeps forcing the debug level for compatibility.
+func Setup(logLevel, format string) error {
+	if os.Getenv("DEBUG") != "" {
+		logLevel = "debug"
+	}
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(strings.ToLower(logLevel))); err != nil {
+		return fmt.Errorf("invalid log level %q: %v", logLevel, err)
+	}
+
+	syncer := zapcore.NewMultiWriteSyncer(zapcore.AddSync(os.Stdout), zapcore.AddSync(hook))
 
 	encoderConfig := zapcore.EncoderConfig{
 		TimeKey:        "time",
@@ -70,26 +97,38 @@ func init() {
 		EncodeName:     zapcore.FullNameEncoder,
 	}
 
-	//var encoder zapcore.Encoder
-	//if jsonFormat {
-	// encoder = zapcore.NewJSONEncoder(encoderConfig)
-	//} else {
-	// encoder = zapcore.NewConsoleEncoder(encoderConfig)
-	//}
-	encoder := zapcore.NewConsoleEncoder(encoderConfig)
+	var encoder zapcore.Encoder
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case FormatConsole, "":
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		return fmt.Errorf("invalid log format %q, supports %s and %s", format, FormatConsole, FormatJSON)
+	}
+
+	level.SetLevel(l)
+	base = zap.New(zapcore.NewCore(encoder, syncer, level), zap.AddCaller())
+	sugareLogger = base.WithOptions(zap.AddCallerSkip(1)).Sugar()
+	return nil
+}
 
-	level := zap.InfoLevel
+// SetLevel changes the level of the logger at runtime, the DEBUG environment variable keeps forcing the debug level
+func SetLevel(logLevel string) error {
 	if os.Getenv("DEBUG") != "" {
-		level = zap.DebugLevel
+		return nil
+	}
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(strings.ToLower(logLevel))); err != nil {
+		return fmt.Errorf("invalid log level %q: %v", logLevel, err)
 	}
-	core := zapcore.NewCore(
-		encoder,
-		syncer,
-		level,
-	)
-
-	log := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
-	sugareLogger = log.Sugar()
+	level.SetLevel(l)
+	return nil
+}
+
+// Logr returns the logger as logr.Logger for controller-runtime and klog, they log with the same level and format
+func Logr() logr.Logger {
+	return zapr.NewLogger(base)
 }
 
 func Debug(args ...interface{}) {
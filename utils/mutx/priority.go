@@ -0,0 +1,193 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mutx
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Priority of a storage operation, lower value runs first
+type Priority int
+
+const (
+	// PriorityPublish mount/unmount for pods, a crashed pod waits on these
+	PriorityPublish Priority = iota
+	// PriorityProvision create/expand/delete of volumes
+	PriorityProvision
+	// PriorityBackground disk scan, orphan cleanup and similar housekeeping
+	PriorityBackground
+	priorityCount
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityPublish:
+		return "publish"
+	case PriorityProvision:
+		return "provision"
+	case PriorityBackground:
+		return "background"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+type waiter struct {
+	priority Priority
+	name     string
+	ready    chan struct{}
+}
+
+// PriorityPool limits how many storage operations run on the node at once and
+// admits waiting operations strictly by priority, FIFO within a priority.
+// Lower priorities are additionally capped so that a slot is always left for
+// publish operations: provision may use all but one worker, background at
+// most a quarter of them.
+type PriorityPool struct {
+	mux     sync.Mutex
+	workers int
+	running [priorityCount]int
+	waiters []*waiter
+}
+
+// NewPriorityPool returns a new PriorityPool with the given number of workers.
+func NewPriorityPool(workers int) *PriorityPool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &PriorityPool{workers: workers}
+}
+
+func (pp *PriorityPool) limit(p Priority) int {
+	switch p {
+	case PriorityPublish:
+		return pp.workers
+	case PriorityProvision:
+		if pp.workers > 1 {
+			return pp.workers - 1
+		}
+		return 1
+	default:
+		if pp.workers/4 > 1 {
+			return pp.workers / 4
+		}
+		return 1
+	}
+}
+
+func (pp *PriorityPool) total() int {
+	t := 0
+	for _, r := range pp.running {
+		t += r
+	}
+	return t
+}
+
+// dispatch admits waiters in order until one cannot run, must hold mux
+func (pp *PriorityPool) dispatch() {
+	for len(pp.waiters) > 0 {
+		w := pp.waiters[0]
+		if pp.total() >= pp.workers || pp.running[w.priority] >= pp.limit(w.priority) {
+			return
+		}
+		pp.running[w.priority]++
+		pp.waiters = pp.waiters[1:]
+		close(w.ready)
+	}
+}
+
+// Acquire blocks until an operation of priority p may run or ctx is done.
+// Every successful Acquire must be paired with a Release of the same priority.
+func (pp *PriorityPool) Acquire(ctx context.Context, p Priority, name string) error {
+	if p < 0 || p >= priorityCount {
+		p = PriorityBackground
+	}
+	w := &waiter{priority: p, name: name, ready: make(chan struct{})}
+
+	pp.mux.Lock()
+	// 按优先级插入，同优先级保持先来先服务
+	i := len(pp.waiters)
+	for i > 0 && pp.waiters[i-1].priority > p {
+		i--
+	}
+	pp.waiters = append(pp.waiters, nil)
+	copy(pp.waiters[i+1:], pp.waiters[i:])
+	pp.waiters[i] = w
+	pp.dispatch()
+	pp.mux.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	pp.mux.Lock()
+	defer pp.mux.Unlock()
+	select {
+	case <-w.ready:
+		// admitted concurrently with cancellation, give the slot back
+		pp.running[p]--
+		pp.dispatch()
+	default:
+		for i, v := range pp.waiters {
+			if v == w {
+				pp.waiters = append(pp.waiters[:i], pp.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	return fmt.Errorf("%s operation %s not started: %v", p, name, ctx.Err())
+}
+
+// Release frees the slot taken by Acquire.
+func (pp *PriorityPool) Release(p Priority) {
+	if p < 0 || p >= priorityCount {
+		p = PriorityBackground
+	}
+	pp.mux.Lock()
+	defer pp.mux.Unlock()
+	if pp.running[p] > 0 {
+		pp.running[p]--
+	}
+	pp.dispatch()
+}
+
+// Run executes f once an operation of priority p may run.
+func (pp *PriorityPool) Run(ctx context.Context, p Priority, name string, f func() error) error {
+	if err := pp.Acquire(ctx, p, name); err != nil {
+		return err
+	}
+	defer pp.Release(p)
+	return f()
+}
+
+// Stats returns the number of running and waiting operations per priority.
+func (pp *PriorityPool) Stats() (running, waiting map[string]int) {
+	pp.mux.Lock()
+	defer pp.mux.Unlock()
+	running = map[string]int{}
+	waiting = map[string]int{}
+	for p := Priority(0); p < priorityCount; p++ {
+		running[p.String()] = pp.running[p]
+	}
+	for _, w := range pp.waiters {
+		waiting[w.priority.String()]++
+	}
+	return running, waiting
+}
@@ -0,0 +1,75 @@
+/*
+   Copyright @ 2021 bocloud <fushaosong@beyondcent.com>.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mutx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriorityPoolReservesPublishSlot(t *testing.T) {
+	a := assert.New(t)
+	pp := NewPriorityPool(2)
+	ctx := context.Background()
+
+	a.NoError(pp.Acquire(ctx, PriorityProvision, "create-1"))
+
+	// provision may only use workers-1 slots
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	a.Error(pp.Acquire(tctx, PriorityProvision, "create-2"))
+
+	a.NoError(pp.Acquire(ctx, PriorityPublish, "mount-1"))
+	pp.Release(PriorityPublish)
+	pp.Release(PriorityProvision)
+
+	running, waiting := pp.Stats()
+	a.Equal(0, running["provision"])
+	a.Equal(0, waiting["provision"])
+}
+
+func TestPriorityPoolOrder(t *testing.T) {
+	a := assert.New(t)
+	pp := NewPriorityPool(1)
+	ctx := context.Background()
+
+	a.NoError(pp.Acquire(ctx, PriorityPublish, "mount-0"))
+
+	order := make(chan string, 3)
+	start := func(p Priority, name string) {
+		go func() {
+			_ = pp.Run(ctx, p, name, func() error {
+				order <- name
+				return nil
+			})
+		}()
+	}
+	start(PriorityBackground, "scan")
+	time.Sleep(10 * time.Millisecond)
+	start(PriorityProvision, "create")
+	time.Sleep(10 * time.Millisecond)
+	start(PriorityPublish, "mount-1")
+	time.Sleep(10 * time.Millisecond)
+
+	pp.Release(PriorityPublish)
+	a.Equal("mount-1", <-order)
+	a.Equal("create", <-order)
+	a.Equal("scan", <-order)
+}
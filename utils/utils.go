@@ -19,8 +19,13 @@ package utils
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -144,3 +149,248 @@ func PartitionName(lv string) string {
 	strtemp := strings.Split(lv, "-")
 	return "carina.io/" + strtemp[len(strtemp)-1]
 }
+
+// DeviceGroupParameter returns the disk group requested by storageclass parameters,
+// carina.storage.io/disk-group-name takes precedence over carina.storage.io/disk-group
+func DeviceGroupParameter(params map[string]string) string {
+	if group := params[DeviceDiskKey]; group != "" {
+		return group
+	}
+	return params[DeviceGroupKey]
+}
+
+// BcacheParameters 合并pvc注解中的bcache参数，pvc注解优先于storageclass参数
+// A pvc of a storageclass without bcache becomes a bcache volume with the cache-disk-group-name and
+// cache-disk-ratio annotations, its backing disk group is the disk group of the pvc or the storageclass.
+func BcacheParameters(params, annotations map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range params {
+		merged[k] = v
+	}
+	for _, key := range []string{VolumeBackendDiskType, VolumeCacheDiskType, VolumeCacheDiskRatio, VolumeCachePolicy} {
+		if v := annotations[key]; v != "" {
+			merged[key] = v
+		}
+	}
+	if merged[VolumeCacheDiskType] == "" || merged[VolumeBackendDiskType] != "" {
+		return merged
+	}
+	if group := annotations[DeviceDiskKey]; group != "" {
+		merged[VolumeBackendDiskType] = group
+	} else if group := DeviceGroupParameter(params); group != "" {
+		merged[VolumeBackendDiskType] = group
+	}
+	return merged
+}
+
+// StripeParameters returns the striping requested by storageclass parameters, 0 stripes means not striped.
+// lvm allows at most 128 stripes and a stripe size that is a power of 2 of at least 4k.
+func StripeParameters(params map[string]string) (uint, string, error) {
+	var stripes uint
+	if v := params[VolumeStripes]; v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || n < 1 || n > 128 {
+			return 0, "", fmt.Errorf("%s must be an integer between 1 and 128, got %q", VolumeStripes, v)
+		}
+		stripes = uint(n)
+	}
+
+	stripeSize := strings.ToLower(params[VolumeStripeSize])
+	if stripeSize == "" {
+		return stripes, "", nil
+	}
+	if stripes < 2 {
+		return 0, "", fmt.Errorf("%s needs %s of at least 2", VolumeStripeSize, VolumeStripes)
+	}
+	kb := int64(0)
+	if strings.HasSuffix(stripeSize, "k") {
+		kb, _ = strconv.ParseInt(strings.TrimSuffix(stripeSize, "k"), 10, 64)
+	} else if strings.HasSuffix(stripeSize, "m") {
+		kb, _ = strconv.ParseInt(strings.TrimSuffix(stripeSize, "m"), 10, 64)
+		kb <<= 10
+	}
+	if kb < 4 || kb&(kb-1) != 0 {
+		return 0, "", fmt.Errorf("%s must be a power of 2 of at least 4k, e.g. 64k, got %q", VolumeStripeSize, params[VolumeStripeSize])
+	}
+	return stripes, stripeSize, nil
+}
+
+// WipeParameters returns the wipe policy and passes requested by storageclass parameters, passes are only
+// allowed with a policy that erases the data and default to 1
+func WipeParameters(params map[string]string) (string, int, error) {
+	policy := params[VolumeWipePolicy]
+	if !ContainsString([]string{"", WipePolicyNone, WipePolicyDiscard, WipePolicyZero}, policy) {
+		return "", 0, fmt.Errorf("%s must be none, discard or zero, got %q", VolumeWipePolicy, policy)
+	}
+	v := params[VolumeWipePasses]
+	if v == "" {
+		return policy, 1, nil
+	}
+	if policy != WipePolicyDiscard && policy != WipePolicyZero {
+		return "", 0, fmt.Errorf("%s needs %s discard or zero", VolumeWipePasses, VolumeWipePolicy)
+	}
+	passes, err := strconv.Atoi(v)
+	if err != nil || passes < 1 || passes > MaxWipePasses {
+		return "", 0, fmt.Errorf("%s must be an integer between 1 and %d, got %q", VolumeWipePasses, MaxWipePasses, v)
+	}
+	return policy, passes, nil
+}
+
+// StripedCapacity 条带卷可用的最大容量
+// Every stripe takes the same amount of space from a different physical
+// volume, so the capacity is limited by the stripes-th largest free space.
+func StripedCapacity(pvFree []uint64, stripes int) uint64 {
+	if stripes < 1 || len(pvFree) < stripes {
+		return 0
+	}
+	free := append([]uint64{}, pvFree...)
+	sort.Slice(free, func(i, j int) bool { return free[i] > free[j] })
+	return free[stripes-1] * uint64(stripes)
+}
+
+// mkfsOption mkfs参数不经过shell，只允许常见字符
+var mkfsOption = regexp.MustCompile(`^[A-Za-z0-9_.,:=^+/-]+$`)
+
+// MkfsOptions parses the mkfs options of a storage class for fsType. Options that
+// only print what would be done (-n of mke2fs, -N of mkfs.xfs) leave the volume
+// unformatted and are rejected.
+func MkfsOptions(fsType, options string) ([]string, error) {
+	args := strings.Fields(options)
+	if len(args) == 0 {
+		return nil, nil
+	}
+	var dryRun string
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		dryRun = "-n"
+	case "xfs":
+		dryRun = "-N"
+	default:
+		return nil, fmt.Errorf("%s is not supported for filesystem %q", VolumeMkfsOptions, fsType)
+	}
+	if !strings.HasPrefix(args[0], "-") {
+		return nil, fmt.Errorf("%s must start with an option, got %q", VolumeMkfsOptions, args[0])
+	}
+	for _, arg := range args {
+		if !mkfsOption.MatchString(arg) {
+			return nil, fmt.Errorf("invalid %s %q", VolumeMkfsOptions, arg)
+		}
+		if arg == dryRun {
+			return nil, fmt.Errorf("%s %s would leave the volume unformatted", VolumeMkfsOptions, arg)
+		}
+	}
+	return args, nil
+}
+
+// CheckMountFlags mountOptions of a storage class are passed to mount -o as they are
+func CheckMountFlags(flags []string) error {
+	for _, flag := range flags {
+		if flag == "" || strings.HasPrefix(flag, "-") || strings.ContainsAny(flag, " \t\n") {
+			return fmt.Errorf("invalid mount option %q, use the option name only, e.g. noatime", flag)
+		}
+	}
+	return nil
+}
+
+// ParseDeviceOwner parses "<uid>:<gid>", -1 means unset. The value "pod" is returned
+// as fromPod, the ids are then taken from the pod when the volume is published.
+func ParseDeviceOwner(owner string) (int, int, bool, error) {
+	if owner == "" {
+		return -1, -1, false, nil
+	}
+	if owner == DeviceOwnerPod {
+		return -1, -1, true, nil
+	}
+	parts := strings.Split(owner, ":")
+	if len(parts) == 2 {
+		uid, err1 := strconv.ParseUint(parts[0], 10, 31)
+		gid, err2 := strconv.ParseUint(parts[1], 10, 31)
+		if err1 == nil && err2 == nil {
+			return int(uid), int(gid), false, nil
+		}
+	}
+	return -1, -1, false, fmt.Errorf("%s must be <uid>:<gid> or %s, got %q", VolumeDeviceOwner, DeviceOwnerPod, owner)
+}
+
+// ParseDeviceMode parses octal permissions such as 0660, 0 means unset.
+// carina-node opens the device as root, so the owner must keep read and write.
+func ParseDeviceMode(mode string) (uint32, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 || m&0600 != 0600 {
+		return 0, fmt.Errorf("%s must be octal permissions between 0600 and 0777 with read and write for the owner, got %q", VolumeDeviceMode, mode)
+	}
+	return uint32(m), nil
+}
+
+// ParseImportSource splits the value of the import source annotation, <node>:<absolute host path>
+func ParseImportSource(source string) (string, string, error) {
+	i := strings.Index(source, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("%s must be <node>:<path>, got %q", VolumeImportSource, source)
+	}
+	node, path := source[:i], source[i+1:]
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path || path == "/" {
+		return "", "", fmt.Errorf("%s needs a clean absolute path other than /, got %q", VolumeImportSource, path)
+	}
+	return node, path, nil
+}
+
+// PathWithin 路径是否位于某个根目录之下，路径需已经过Clean
+func PathWithin(path string, roots []string) bool {
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if root == "/" || root == "." {
+			continue
+		}
+		if path == root || strings.HasPrefix(path, root+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// FailureDomainLabels 按故障域重新生成pv的故障域标签，返回新标签以及是否有变化
+func FailureDomainLabels(labels map[string]string, domains []string) (map[string]string, bool) {
+	result := map[string]string{}
+	for k, v := range labels {
+		if !strings.HasPrefix(k, FailureDomainLabelPrefix) {
+			result[k] = v
+		}
+	}
+	for _, domain := range domains {
+		result[FailureDomainLabelPrefix+domain] = "true"
+	}
+	return result, !MapEqualMap(labels, result)
+}
+
+// CacheDeviceAnnotations 按缓存盘与后端盘重新生成pv注解，返回新注解以及是否有变化，没有磁盘时去掉对应注解
+func CacheDeviceAnnotations(annotations map[string]string, cache, backing []string) (map[string]string, bool) {
+	result := map[string]string{}
+	for k, v := range annotations {
+		if k != CacheDevices && k != BackingDevices {
+			result[k] = v
+		}
+	}
+	if len(cache) > 0 {
+		result[CacheDevices] = strings.Join(cache, ",")
+	}
+	if len(backing) > 0 {
+		result[BackingDevices] = strings.Join(backing, ",")
+	}
+	return result, !MapEqualMap(annotations, result)
+}
+
+// ParseVolumeTTL parses the value of the ttl annotation of a pvc, a Go duration of at least a minute, e.g. 12h or 90m
+func ParseVolumeTTL(s string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", VolumeTTL, s, err)
+	}
+	if ttl < time.Minute {
+		return 0, fmt.Errorf("%s must be at least 1m, got %q", VolumeTTL, s)
+	}
+	return ttl, nil
+}
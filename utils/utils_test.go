@@ -3,6 +3,7 @@ package utils
 import (
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestContainsString(t *testing.T) {
@@ -98,3 +99,290 @@ func TestMapEqualMap(t *testing.T) {
 		a.Equal(MapEqualMap(e.src, e.dst), e.result)
 	}
 }
+
+func TestMkfsOptions(t *testing.T) {
+	table := []struct {
+		fsType  string
+		options string
+		args    []string
+		err     bool
+	}{
+		{fsType: "ext4", options: "", args: nil},
+		{fsType: "ext4", options: "-O ^has_journal", args: []string{"-O", "^has_journal"}},
+		{fsType: "ext4", options: " -E  lazy_itable_init=0,lazy_journal_init=0 ", args: []string{"-E", "lazy_itable_init=0,lazy_journal_init=0"}},
+		{fsType: "xfs", options: "-m crc=1 -i size=512", args: []string{"-m", "crc=1", "-i", "size=512"}},
+		{fsType: "btrfs", options: "-O ^has_journal", err: true},
+		{fsType: "ext4", options: "^has_journal", err: true},
+		{fsType: "ext4", options: "-O ^has_journal;reboot", err: true},
+		{fsType: "ext4", options: "-n", err: true},
+		{fsType: "xfs", options: "-N", err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		args, err := MkfsOptions(e.fsType, e.options)
+		if e.err {
+			a.Error(err, e.options)
+			continue
+		}
+		a.NoError(err, e.options)
+		a.Equal(e.args, args)
+	}
+}
+
+func TestCheckMountFlags(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(CheckMountFlags(nil))
+	a.NoError(CheckMountFlags([]string{"noatime", "discard", "data=writeback"}))
+	a.Error(CheckMountFlags([]string{"-o noatime"}))
+	a.Error(CheckMountFlags([]string{"noatime nodiratime"}))
+	a.Error(CheckMountFlags([]string{""}))
+}
+
+func TestParseDeviceOwner(t *testing.T) {
+	table := []struct {
+		owner   string
+		uid     int
+		gid     int
+		fromPod bool
+		err     bool
+	}{
+		{owner: "", uid: -1, gid: -1},
+		{owner: "pod", uid: -1, gid: -1, fromPod: true},
+		{owner: "1000:2000", uid: 1000, gid: 2000},
+		{owner: "0:6", uid: 0, gid: 6},
+		{owner: "1000", err: true},
+		{owner: "-1:1000", err: true},
+		{owner: "root:disk", err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		uid, gid, fromPod, err := ParseDeviceOwner(e.owner)
+		if e.err {
+			a.Error(err, e.owner)
+			continue
+		}
+		a.NoError(err, e.owner)
+		a.Equal(e.uid, uid, e.owner)
+		a.Equal(e.gid, gid, e.owner)
+		a.Equal(e.fromPod, fromPod, e.owner)
+	}
+}
+
+func TestParseDeviceMode(t *testing.T) {
+	table := []struct {
+		mode     string
+		expected uint32
+		err      bool
+	}{
+		{mode: "", expected: 0},
+		{mode: "0660", expected: 0660},
+		{mode: "666", expected: 0666},
+		{mode: "0440", err: true},
+		{mode: "01777", err: true},
+		{mode: "rw", err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		mode, err := ParseDeviceMode(e.mode)
+		if e.err {
+			a.Error(err, e.mode)
+			continue
+		}
+		a.NoError(err, e.mode)
+		a.Equal(e.expected, mode, e.mode)
+	}
+}
+
+func TestWipeParameters(t *testing.T) {
+	table := []struct {
+		params map[string]string
+		policy string
+		passes int
+		err    bool
+	}{
+		{params: map[string]string{}, policy: "", passes: 1},
+		{params: map[string]string{VolumeWipePolicy: "discard"}, policy: "discard", passes: 1},
+		{params: map[string]string{VolumeWipePolicy: "zero", VolumeWipePasses: "3"}, policy: "zero", passes: 3},
+		{params: map[string]string{VolumeWipePolicy: "shred"}, err: true},
+		{params: map[string]string{VolumeWipePasses: "3"}, err: true},
+		{params: map[string]string{VolumeWipePolicy: "none", VolumeWipePasses: "3"}, err: true},
+		{params: map[string]string{VolumeWipePolicy: "zero", VolumeWipePasses: "0"}, err: true},
+		{params: map[string]string{VolumeWipePolicy: "zero", VolumeWipePasses: "8"}, err: true},
+		{params: map[string]string{VolumeWipePolicy: "zero", VolumeWipePasses: "three"}, err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		policy, passes, err := WipeParameters(e.params)
+		if e.err {
+			a.Error(err, e.params)
+			continue
+		}
+		a.NoError(err, e.params)
+		a.Equal(e.policy, policy)
+		a.Equal(e.passes, passes)
+	}
+}
+
+func TestStripeParameters(t *testing.T) {
+	table := []struct {
+		params     map[string]string
+		stripes    uint
+		stripeSize string
+		err        bool
+	}{
+		{params: map[string]string{}, stripes: 0},
+		{params: map[string]string{VolumeStripes: "4"}, stripes: 4},
+		{params: map[string]string{VolumeStripes: "2", VolumeStripeSize: "64K"}, stripes: 2, stripeSize: "64k"},
+		{params: map[string]string{VolumeStripes: "2", VolumeStripeSize: "1m"}, stripes: 2, stripeSize: "1m"},
+		{params: map[string]string{VolumeStripes: "0"}, err: true},
+		{params: map[string]string{VolumeStripes: "two"}, err: true},
+		{params: map[string]string{VolumeStripes: "2", VolumeStripeSize: "48k"}, err: true},
+		{params: map[string]string{VolumeStripes: "2", VolumeStripeSize: "2k"}, err: true},
+		{params: map[string]string{VolumeStripeSize: "64k"}, err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		stripes, stripeSize, err := StripeParameters(e.params)
+		if e.err {
+			a.Error(err, e.params)
+			continue
+		}
+		a.NoError(err, e.params)
+		a.Equal(e.stripes, stripes)
+		a.Equal(e.stripeSize, stripeSize)
+	}
+}
+
+func TestStripedCapacity(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(uint64(60), StripedCapacity([]uint64{50, 30, 10}, 2))
+	a.Equal(uint64(30), StripedCapacity([]uint64{50, 30, 10}, 3))
+	a.Equal(uint64(50), StripedCapacity([]uint64{50, 30, 10}, 1))
+	a.Equal(uint64(0), StripedCapacity([]uint64{50}, 2))
+}
+
+func TestParseImportSource(t *testing.T) {
+	table := []struct {
+		source string
+		node   string
+		path   string
+		err    bool
+	}{
+		{source: "node1:/mnt/disks/mysql-0", node: "node1", path: "/mnt/disks/mysql-0"},
+		{source: "10.20.9.153:/data", node: "10.20.9.153", path: "/data"},
+		{source: "/mnt/disks/mysql-0", err: true},
+		{source: "node1:mnt/disks", err: true},
+		{source: "node1:/mnt/../etc", err: true},
+		{source: "node1:/", err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		node, path, err := ParseImportSource(e.source)
+		if e.err {
+			a.Error(err, e.source)
+			continue
+		}
+		a.NoError(err, e.source)
+		a.Equal(e.node, node)
+		a.Equal(e.path, path)
+	}
+}
+
+func TestPathWithin(t *testing.T) {
+	roots := []string{"/mnt/disks/", "/var/local-path-provisioner", "/"}
+	a := assert.New(t)
+	a.True(PathWithin("/mnt/disks/mysql-0", roots))
+	a.True(PathWithin("/var/local-path-provisioner", roots))
+	a.False(PathWithin("/mnt/disks2", roots))
+	a.False(PathWithin("/etc", roots))
+	a.False(PathWithin("/mnt/disks/a", nil))
+}
+
+func TestFailureDomainLabels(t *testing.T) {
+	a := assert.New(t)
+	labels, changed := FailureDomainLabels(nil, []string{"enclosure-500304801f0d2a3f", "hba-0000-3b-00.0"})
+	a.True(changed)
+	a.Equal(map[string]string{
+		FailureDomainLabelPrefix + "enclosure-500304801f0d2a3f": "true",
+		FailureDomainLabelPrefix + "hba-0000-3b-00.0":           "true",
+	}, labels)
+
+	labels, changed = FailureDomainLabels(map[string]string{"app": "mysql", FailureDomainLabelPrefix + "hba-0000-3b-00.0": "true"}, []string{"hba-0000-d8-00.0"})
+	a.True(changed)
+	a.Equal(map[string]string{"app": "mysql", FailureDomainLabelPrefix + "hba-0000-d8-00.0": "true"}, labels)
+
+	_, changed = FailureDomainLabels(labels, []string{"hba-0000-d8-00.0"})
+	a.False(changed)
+}
+
+func TestCacheDeviceAnnotations(t *testing.T) {
+	a := assert.New(t)
+	annotations, changed := CacheDeviceAnnotations(map[string]string{"pv.kubernetes.io/provisioned-by": CSIPluginName}, []string{"/dev/nvme0n1"}, []string{"/dev/sdb", "/dev/sdc"})
+	a.True(changed)
+	a.Equal(map[string]string{
+		"pv.kubernetes.io/provisioned-by": CSIPluginName,
+		CacheDevices:                      "/dev/nvme0n1",
+		BackingDevices:                    "/dev/sdb,/dev/sdc",
+	}, annotations)
+
+	_, changed = CacheDeviceAnnotations(annotations, []string{"/dev/nvme0n1"}, []string{"/dev/sdb", "/dev/sdc"})
+	a.False(changed)
+
+	annotations, changed = CacheDeviceAnnotations(annotations, nil, []string{"/dev/sdb"})
+	a.True(changed)
+	a.Equal(map[string]string{"pv.kubernetes.io/provisioned-by": CSIPluginName, BackingDevices: "/dev/sdb"}, annotations)
+}
+
+func TestParseVolumeTTL(t *testing.T) {
+	table := []struct {
+		raw string
+		ttl time.Duration
+		err bool
+	}{
+		{raw: "12h", ttl: 12 * time.Hour},
+		{raw: " 90m ", ttl: 90 * time.Minute},
+		{raw: "1m", ttl: time.Minute},
+		{raw: "30s", err: true},
+		{raw: "-1h", err: true},
+		{raw: "1d", err: true},
+		{raw: "", err: true},
+	}
+
+	a := assert.New(t)
+	for _, e := range table {
+		ttl, err := ParseVolumeTTL(e.raw)
+		if e.err {
+			a.Error(err, e.raw)
+			continue
+		}
+		a.NoError(err, e.raw)
+		a.Equal(e.ttl, ttl)
+	}
+}
+
+func TestBcacheParameters(t *testing.T) {
+	a := assert.New(t)
+	params := map[string]string{DeviceDiskKey: "carina-vg-hdd", VolumeFsType: "xfs"}
+	merged := BcacheParameters(params, map[string]string{VolumeCacheDiskType: "carina-vg-ssd", VolumeCacheDiskRatio: "25"})
+	a.Equal("carina-vg-hdd", merged[VolumeBackendDiskType])
+	a.Equal("carina-vg-ssd", merged[VolumeCacheDiskType])
+	a.Equal("25", merged[VolumeCacheDiskRatio])
+	a.Equal("xfs", merged[VolumeFsType])
+	a.Empty(params[VolumeCacheDiskType])
+
+	// pvc注解选择的磁盘组作为后端盘组
+	merged = BcacheParameters(params, map[string]string{DeviceDiskKey: "carina-vg-sata", VolumeCacheDiskType: "carina-vg-ssd"})
+	a.Equal("carina-vg-sata", merged[VolumeBackendDiskType])
+
+	bcache := map[string]string{VolumeBackendDiskType: "carina-vg-hdd", VolumeCacheDiskType: "carina-vg-ssd", VolumeCacheDiskRatio: "50"}
+	merged = BcacheParameters(bcache, map[string]string{VolumeCacheDiskType: "carina-vg-nvme", VolumeCachePolicy: "writeback"})
+	a.Equal(map[string]string{VolumeBackendDiskType: "carina-vg-hdd", VolumeCacheDiskType: "carina-vg-nvme", VolumeCacheDiskRatio: "50", VolumeCachePolicy: "writeback"}, merged)
+
+	a.Equal(params, BcacheParameters(params, nil))
+}